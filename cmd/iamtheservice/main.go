@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	"github.com/danthegoodman1/IAMTheService/observability"
+
+	"github.com/danthegoodman1/IAMTheService/config"
+	"github.com/danthegoodman1/IAMTheService/gologger"
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/tracing"
+	"github.com/danthegoodman1/IAMTheService/utils"
+)
+
+var logger = gologger.NewLogger()
+
+func main() {
+	if _, err := os.Stat(".env"); err == nil {
+		err = godotenv.Load()
+		if err != nil {
+			logger.Fatal().Err(err).Msg("error loading .env file, exiting")
+			return
+		}
+	}
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path of the JSON or YAML config file")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, *configPath); err != nil {
+		logger.Fatal().Err(err).Msg("error running iamtheservice, exiting")
+	}
+}
+
+// run serves the proxy configured by the config file until ctx is done (e.g. on SIGTERM), then drains it
+func run(ctx context.Context, configPath string) error {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+	if cfg.TLSCert != "" {
+		utils.TLSCert = cfg.TLSCert
+	}
+	if cfg.TLSKey != "" {
+		utils.TLSKey = cfg.TLSKey
+	}
+	logger.Debug().Msg("starting iamtheservice")
+
+	tp, err := tracing.InitTracer(context.Background())
+	if err != nil {
+		return fmt.Errorf("error initializing tracing: %w", err)
+	}
+	if tp != nil {
+		defer func() {
+			if err := tp.Shutdown(context.Background()); err != nil {
+				logger.Error().Err(err).Msg("error shutting down tracer provider")
+			}
+		}()
+	}
+
+	prometheusReporter := observability.NewPrometheusReporter()
+	go func() {
+		err := observability.StartInternalHTTPServer(":8042", prometheusReporter)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatal().Err(err).Msg("internal server couldn't start")
+			return
+		}
+	}()
+	proxy := buildProxy()
+	loader := &config.Loader{Path: configPath, Proxy: proxy}
+	if err = loader.Reload(context.Background()); err != nil {
+		return fmt.Errorf("error loading proxy config: %w", err)
+	}
+	defer loader.Close()
+	serverConfig := http_server.ServerConfig{
+		Port:   cfg.Port,
+		Proxy:  proxy,
+		Reload: loader.Reload,
+	}
+	if utils.CORSAllowOrigins != "" {
+		serverConfig.CORS.AllowOrigins = strings.Split(utils.CORSAllowOrigins, ",")
+	}
+	httpServer := http_server.StartHTTPServerWithConfig(serverConfig)
+
+	// SIGHUP reloads the config, swapping the lookups and providers without dropping in-flight requests
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for range hup {
+			if err := loader.Reload(context.Background()); err != nil {
+				logger.Error().Err(err).Msg("error reloading config, keeping the current one")
+			}
+		}
+	}()
+
+	<-ctx.Done()
+	logger.Warn().Msg("received shutdown signal!")
+
+	// For AWS ALB needing some time to de-register pod
+	// Convert the time to seconds
+	sleepTime := utils.GetEnvOrDefaultInt("SHUTDOWN_SLEEP_SEC", 0)
+	logger.Info().Msg(fmt.Sprintf("sleeping for %ds before exiting", sleepTime))
+
+	time.Sleep(time.Second * time.Duration(sleepTime))
+	logger.Info().Msg(fmt.Sprintf("slept for %ds, exiting", sleepTime))
+
+	// Give in-flight requests (e.g. large object streams) time to finish
+	drainTime := utils.GetEnvOrDefaultInt("SHUTDOWN_DRAIN_SEC", 10)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(drainTime))
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("error shutting down HTTP server: %w", err)
+	}
+	logger.Info().Msg("successfully shutdown HTTP server")
+	return nil
+}
+
+// buildProxy assembles the AWSProxy from the environment, its lookups and providers are loaded by config.Loader
+func buildProxy() *http_server.AWSProxy {
+	proxy := &http_server.AWSProxy{
+		Timeout: time.Second * time.Duration(utils.GetEnvOrDefaultInt("ORIGIN_TIMEOUT_SEC", 0)),
+	}
+	if utils.OutboundAccessKeyID != "" {
+		proxy.OutboundCredentials = http_server.StaticOutboundCredentials{
+			AccessKeyID:     utils.OutboundAccessKeyID,
+			SecretAccessKey: utils.OutboundSecretAccessKey,
+			SessionToken:    utils.OutboundSessionToken,
+		}
+	}
+	if utils.VerifyPayloadHash {
+		proxy.PayloadVerification = &http_server.PayloadVerification{}
+	}
+	return proxy
+}
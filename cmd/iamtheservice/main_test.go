@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+	"github.com/danthegoodman1/IAMTheService/utils"
+)
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// TestRun boots the service from a config file, proxies a signed request to the configured origin, and shuts
+// down once the context is done
+func TestRun(t *testing.T) {
+	origin := iamtest.NewFakeOrigin()
+	defer origin.Close()
+	origin.RespondWith(http.StatusOK, http.Header{"Content-Type": {"text/plain"}}, []byte("from origin"))
+
+	// The HTTP/3 server writes its self-signed certificate in the background, possibly after the test
+	utils.TLSCert = filepath.Join(os.TempDir(), "iamtheservice-test-cert.pem")
+	utils.TLSKey = filepath.Join(os.TempDir(), "iamtheservice-test-key.pem")
+	port := freePort(t)
+	raw, _ := json.Marshal(map[string]any{
+		"port":        port,
+		"providers":   []string{"s3"},
+		"originHosts": map[string]string{"s3": origin.URL},
+		"keys":        map[string]string{iamtest.KeyID: iamtest.KeySecret},
+	})
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(configPath, raw, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- run(ctx, configPath) }()
+
+	url := "http://127.0.0.1:" + strconv.Itoa(port)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		res, err := http.Get(url + "/.internal/hc")
+		if err == nil {
+			res.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("service didn't start: %v", err)
+		}
+	}
+
+	res, err := http.DefaultClient.Do(iamtest.NewSignedRequest(http.MethodGet, url+"/bucket/key", nil, "s3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || string(body) != "from origin" {
+		t.Fatalf("got %d %s, want the origin's response", res.StatusCode, body)
+	}
+	if requests := origin.Requests(); len(requests) != 1 || requests[0].Path != "/bucket/key" {
+		t.Fatalf("origin received %+v", requests)
+	}
+
+	// A request with a bad signature never reaches the origin
+	r := iamtest.NewSignedRequest(http.MethodGet, url+"/bucket/key", nil, "s3")
+	r.Header.Set("Authorization", r.Header.Get("Authorization")+"0")
+	if res, err = http.DefaultClient.Do(r); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("got status %d for a bad signature", res.StatusCode)
+	}
+	if n := len(origin.Requests()); n != 1 {
+		t.Errorf("origin received %d requests", n)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(15 * time.Second):
+		t.Fatal("service didn't shut down")
+	}
+}
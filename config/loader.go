@@ -0,0 +1,186 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danthegoodman1/IAMTheService/gologger"
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/utils"
+)
+
+var logger = gologger.NewLogger()
+
+// Loader loads the config file at Path and builds the lookups and providers of an AWSProxy from it.
+// Reload it (e.g. on SIGHUP or POST /.internal/reload) to swap them without dropping in-flight requests.
+type Loader struct {
+	Path  string
+	Proxy *http_server.AWSProxy
+
+	mu sync.Mutex
+	// stopWatch stops watching the LookupFile of the current config
+	stopWatch context.CancelFunc
+	// keys is the store of the current config's Keys, whose issued keys are kept by the next one
+	keys *http_server.MemoryCredentialStore
+}
+
+// Reload loads the config and swaps the lookups of Proxy. A config that fails to load leaves the current
+// lookups in place.
+func (l *Loader) Reload(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cfg, err := Load(l.Path)
+	if err != nil {
+		return fmt.Errorf("error in Load: %w", err)
+	}
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	lookups, err := cfg.ProxyLookups(watchCtx)
+	if err != nil {
+		stopWatch()
+		return fmt.Errorf("error in ProxyLookups: %w", err)
+	}
+
+	keys, _ := lookups.CredentialStore.(*http_server.MemoryCredentialStore)
+	if keys != nil && l.keys != nil {
+		// Keys minted at /.internal/keys aren't in the config, and disabling a key isn't either
+		keys.ImportIssuedKeys(l.keys)
+	}
+	if err = l.Proxy.Reload(lookups); err != nil {
+		stopWatch()
+		return fmt.Errorf("error in Proxy.Reload: %w", err)
+	}
+	if keys != nil {
+		l.keys = keys
+	}
+	if l.stopWatch != nil {
+		l.stopWatch()
+	}
+	l.stopWatch = stopWatch
+	logger.Info().Str("path", l.Path).Strs("providers", cfg.Providers).Msg("loaded config")
+	return nil
+}
+
+// Close stops watching the LookupFile
+func (l *Loader) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopWatch != nil {
+		l.stopWatch()
+	}
+}
+
+// ProxyLookups builds the lookups, providers, and limits of the config. A LookupFile is watched until ctx is done.
+func (cfg Config) ProxyLookups(ctx context.Context) (http_server.ProxyLookups, error) {
+	var lookups http_server.ProxyLookups
+	var providers []http_server.AWSServiceProvider
+	for _, service := range cfg.Providers {
+		service = strings.TrimSpace(service)
+		provider, base, err := newProvider(service)
+		if err != nil {
+			return lookups, fmt.Errorf("error in newProvider: %w", err)
+		}
+		base.OriginHost = cfg.OriginHosts[service]
+		providers = append(providers, provider)
+	}
+	lookups.Providers = http_server.NewProviderRegistry(providers...)
+
+	switch {
+	case cfg.LookupFile != "":
+		lookupFile, err := http_server.NewFileLookupProvider(cfg.LookupFile)
+		if err != nil {
+			return lookups, fmt.Errorf("error in NewFileLookupProvider: %w", err)
+		}
+		go lookupFile.Watch(ctx, 0)
+		lookups.KeyLookupFunc = lookupFile.LookupKey
+		lookups.HostLookupFunc = lookupFile.LookupHost
+	case cfg.Vault.Addr != "":
+		lookups.CredentialStore = &http_server.VaultSecretProvider{
+			Address:             cfg.Vault.Addr,
+			Token:               cfg.Vault.Token,
+			Mount:               cfg.Vault.Mount,
+			Path:                cfg.Vault.Path,
+			RotationGracePeriod: time.Duration(cfg.Vault.RotationGraceSeconds) * time.Second,
+			CacheTTL:            time.Duration(cfg.Vault.CacheSeconds) * time.Second,
+			NegativeTTL:         time.Duration(cfg.Vault.CacheSeconds) * time.Second,
+		}
+	case len(cfg.Keys) > 0:
+		lookups.CredentialStore = http_server.NewMemoryCredentialStore(cfg.Keys)
+	default:
+		lookups.SecretProvider = http_server.EnvSecretProvider{Prefix: utils.KeySecretPrefix}
+	}
+	if len(cfg.Hosts) > 0 && lookups.HostLookupFunc == nil {
+		hosts := cfg.Hosts
+		lookups.HostLookupFunc = func(_ context.Context, host string) (string, error) {
+			outbound, ok := hosts[host]
+			if !ok {
+				return "", http_server.ErrKeyNotFound
+			}
+			return outbound, nil
+		}
+	}
+
+	if cfg.Policies != nil {
+		for keyID, policies := range cfg.Policies {
+			for _, policy := range policies {
+				if err := policy.Validate(); err != nil {
+					return lookups, fmt.Errorf("error in policy of key %s: %w", keyID, err)
+				}
+			}
+		}
+		lookups.PolicyLookupFunc = http_server.StaticPolicies(cfg.Policies)
+	}
+
+	if cfg.RateLimits != nil {
+		lookups.RateLimitLookupFunc = http_server.StaticRateLimits(cfg.RateLimits)
+	}
+	if cfg.OPAURL != "" {
+		lookups.Authorizer = &http_server.OPAAuthorizer{URL: cfg.OPAURL}
+	}
+
+	if cfg.Limits != (Limits{}) {
+		lookups.Limits = &http_server.RequestLimits{
+			MaxSignedHeaders: cfg.Limits.MaxSignedHeaders,
+			MaxHeaderBytes:   cfg.Limits.MaxHeaderBytes,
+			MaxQueryParams:   cfg.Limits.MaxQueryParams,
+		}
+	}
+	return lookups, nil
+}
+
+// newProvider creates the provider of an enabled service, along with its base to configure the origin
+func newProvider(service string) (http_server.AWSServiceProvider, *http_server.BaseAWSProvider, error) {
+	switch service {
+	case "s3":
+		p := http_server.NewS3Provider()
+		return p, p.BaseAWSProvider, nil
+	case "dynamodb":
+		p := http_server.NewDynamoDBProvider()
+		return p, p.BaseAWSProvider, nil
+	case "sts":
+		p := http_server.NewSTSProvider()
+		return p, p.BaseAWSProvider, nil
+	case "lambda":
+		p := http_server.NewLambdaProvider()
+		return p, p.BaseAWSProvider, nil
+	case "sns":
+		p := http_server.NewSNSProvider()
+		return p, p.BaseAWSProvider, nil
+	case "sqs":
+		p := http_server.NewSQSProvider()
+		return p, p.BaseAWSProvider, nil
+	case "kinesis":
+		p := http_server.NewKinesisProvider()
+		return p, p.BaseAWSProvider, nil
+	case "kms":
+		p := http_server.NewKMSProvider()
+		return p, p.BaseAWSProvider, nil
+	case "iam":
+		p := http_server.NewIAMProvider()
+		return p, p.BaseAWSProvider, nil
+	}
+	return nil, nil, fmt.Errorf("unknown provider %q", service)
+}
@@ -81,6 +81,21 @@ func NewLogger() zerolog.Logger {
 	return logger
 }
 
+// SetLevel changes the global log level at runtime, e.g. to turn on debug logs without a redeploy
+func SetLevel(level string) error {
+	lvl, err := zerolog.ParseLevel(strings.ToLower(level))
+	if err != nil {
+		return err
+	}
+	zerolog.SetGlobalLevel(lvl)
+	return nil
+}
+
+// GetLevel returns the current global log level
+func GetLevel() string {
+	return zerolog.GlobalLevel().String()
+}
+
 type CallerHook struct{}
 
 func (h CallerHook) Run(e *zerolog.Event, _ zerolog.Level, _ string) {
@@ -0,0 +1,43 @@
+package gologger
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestSetLevel(t *testing.T) {
+	defer zerolog.SetGlobalLevel(zerolog.GlobalLevel())
+
+	var buf bytes.Buffer
+	logger := zerolog.New(&buf)
+
+	if err := SetLevel("WARN"); err != nil {
+		t.Fatal(err)
+	}
+	if got := GetLevel(); got != "warn" {
+		t.Errorf("got level %q", got)
+	}
+	logger.Debug().Msg("hidden")
+	logger.Warn().Msg("shown")
+	if bytes.Contains(buf.Bytes(), []byte("hidden")) || !bytes.Contains(buf.Bytes(), []byte("shown")) {
+		t.Errorf("got logs %s at warn", buf.String())
+	}
+
+	buf.Reset()
+	if err := SetLevel("debug"); err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug().Msg("debugging")
+	if !bytes.Contains(buf.Bytes(), []byte("debugging")) {
+		t.Errorf("got logs %s at debug", buf.String())
+	}
+
+	if err := SetLevel("verbose"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+	if got := GetLevel(); got != "debug" {
+		t.Errorf("got level %q after an unknown level", got)
+	}
+}
@@ -0,0 +1,48 @@
+package http_server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/danthegoodman1/IAMTheService/gologger"
+	"github.com/danthegoodman1/IAMTheService/utils"
+)
+
+var ErrAdminUnauthorized = echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+
+// adminAuthMiddleware guards admin endpoints with the ADMIN_TOKEN bearer token.
+// If no token is configured, admin endpoints are disabled.
+func adminAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token, found := strings.CutPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+		if utils.AdminToken == "" || !found || subtle.ConstantTimeCompare([]byte(token), []byte(utils.AdminToken)) != 1 {
+			return ErrAdminUnauthorized
+		}
+		return next(c)
+	}
+}
+
+type LogLevelBody struct {
+	Level string `json:"level" validate:"required"`
+}
+
+func (*HTTPServer) GetLogLevel(c echo.Context) error {
+	return c.JSON(http.StatusOK, LogLevelBody{Level: gologger.GetLevel()})
+}
+
+func (*HTTPServer) SetLogLevel(c echo.Context) error {
+	var body LogLevelBody
+	if err := ValidateRequest(c, &body); err != nil {
+		return err
+	}
+
+	if err := gologger.SetLevel(body.Level); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	logger.Warn().Str("level", body.Level).Msg("log level changed")
+
+	return c.JSON(http.StatusOK, LogLevelBody{Level: gologger.GetLevel()})
+}
@@ -0,0 +1,173 @@
+package http_server_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+	"github.com/danthegoodman1/IAMTheService/utils"
+)
+
+// withAdminToken sets the ADMIN_TOKEN of the admin endpoints for the test
+func withAdminToken(t *testing.T, token string) {
+	t.Helper()
+	previous := utils.AdminToken
+	utils.AdminToken = token
+	t.Cleanup(func() { utils.AdminToken = previous })
+}
+
+func TestLogLevelEndpoint(t *testing.T) {
+	withAdminToken(t, "admin_token")
+	level := zerolog.GlobalLevel()
+	t.Cleanup(func() { zerolog.SetGlobalLevel(level) })
+	url := startServer(t, http_server.ServerConfig{})
+
+	setLevel := func(token, body string) (int, string) {
+		r, _ := http.NewRequest(http.MethodPut, url+"/.internal/log-level", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		resBody, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(resBody)
+	}
+
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	for _, token := range []string{"", "wrong_token"} {
+		if status, _ := setLevel(token, `{"level":"trace"}`); status != http.StatusUnauthorized {
+			t.Errorf("token %q got status %d, want 401", token, status)
+		}
+	}
+	if zerolog.GlobalLevel() != zerolog.InfoLevel {
+		t.Fatalf("an unauthorized request changed the level to %s", zerolog.GlobalLevel())
+	}
+
+	if status, body := setLevel("admin_token", `{"level":"nonsense"}`); status != http.StatusBadRequest {
+		t.Errorf("got %d %s for an unknown level", status, body)
+	}
+
+	status, body := setLevel("admin_token", `{"level":"trace"}`)
+	if status != http.StatusOK {
+		t.Fatalf("got %d %s", status, body)
+	}
+	if zerolog.GlobalLevel() != zerolog.TraceLevel {
+		t.Errorf("got level %s after setting trace", zerolog.GlobalLevel())
+	}
+
+	r, _ := http.NewRequest(http.MethodGet, url+"/.internal/log-level", nil)
+	r.Header.Set("Authorization", "Bearer admin_token")
+	res, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var got http_server.LogLevelBody
+	if err = json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Level != "trace" {
+		t.Errorf("got level %q", got.Level)
+	}
+}
+
+// Admin endpoints are disabled without an ADMIN_TOKEN
+func TestAdminEndpointsDisabledWithoutToken(t *testing.T) {
+	withAdminToken(t, "")
+	url := startServer(t, http_server.ServerConfig{})
+
+	r, _ := http.NewRequest(http.MethodGet, url+"/.internal/log-level", nil)
+	r.Header.Set("Authorization", "Bearer ")
+	res, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("got status %d, want 401", res.StatusCode)
+	}
+}
+
+// The routing endpoint must report the provider and origin a request like the one described is proxied to
+func TestRoutingEndpointMatchesProxy(t *testing.T) {
+	withAdminToken(t, "admin_token")
+	s3Origin, dynamoDBOrigin := iamtest.NewFakeOrigin(), iamtest.NewFakeOrigin()
+	t.Cleanup(s3Origin.Close)
+	t.Cleanup(dynamoDBOrigin.Close)
+	s3 := http_server.NewS3Provider()
+	s3.OriginHost = s3Origin.URL
+	dynamodb := http_server.NewDynamoDBProvider()
+	dynamodb.OriginHost = dynamoDBOrigin.URL
+	providers := http_server.NewProviderRegistry(s3, dynamodb)
+	url := startServer(t, http_server.ServerConfig{
+		Proxy: &http_server.AWSProxy{
+			KeyLookupFunc: iamtest.MapLookupFunc(map[string]string{iamtest.KeyID: iamtest.KeySecret}),
+			Providers:     providers,
+		},
+		Providers: providers,
+	})
+	origins := map[string]*iamtest.FakeOrigin{s3Origin.URL: s3Origin, dynamoDBOrigin.URL: dynamoDBOrigin}
+
+	tests := []struct {
+		name        string
+		host        string
+		service     string
+		wantService string
+	}{
+		{name: "by scope service", host: "dynamodb.us-east-1.amazonaws.com", service: "s3", wantService: "s3"},
+		{name: "by host", host: "dynamodb.us-east-1.amazonaws.com", service: "execute-api", wantService: "dynamodb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodGet, url+"/.internal/routing?host="+tt.host+"&service="+tt.service, nil)
+			r.Header.Set("Authorization", "Bearer admin_token")
+			res, err := http.DefaultClient.Do(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var table http_server.RoutingTable
+			err = json.NewDecoder(res.Body).Decode(&table)
+			res.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if table.Decision == nil || table.Decision.ServiceName != tt.wantService {
+				t.Fatalf("got decision %+v, want %s", table.Decision, tt.wantService)
+			}
+			var origin *iamtest.FakeOrigin
+			for _, provider := range table.Providers {
+				if provider.ServiceName == table.Decision.ServiceName {
+					origin = origins[provider.Origin]
+				}
+			}
+			if origin == nil {
+				t.Fatalf("the routing table has no known origin for %s: %+v", table.Decision.ServiceName, table.Providers)
+			}
+
+			before := len(origin.Requests())
+			r, _ = http.NewRequest(http.MethodGet, url+"/", nil)
+			r.Host = tt.host
+			http_server.SignRequest(r, iamtest.KeyID, iamtest.KeySecret, iamtest.Region, tt.service, time.Now())
+			res, err = http.DefaultClient.Do(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK || len(origin.Requests()) != before+1 {
+				t.Errorf("got status %d, and the reported origin received %d requests", res.StatusCode, len(origin.Requests())-before)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package http_server
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// AuditRecord is emitted once for every request that made it past signature verification
+type AuditRecord struct {
+	Time       time.Time
+	Principal  Principal
+	ClientIP   string
+	Service    string
+	Operation  string
+	Method     string
+	Host       string
+	Path       string
+	StatusCode int
+	Duration   time.Duration
+	// Error is the error that failed the request, if any
+	Error string
+	// RejectionReason is set if the proxy rejected the request
+	RejectionReason RejectionReason
+}
+
+type AuditSink interface {
+	WriteAuditRecord(ctx context.Context, record AuditRecord)
+}
+
+// LogAuditSink writes audit records to the request logger
+type LogAuditSink struct{}
+
+func (LogAuditSink) WriteAuditRecord(ctx context.Context, record AuditRecord) {
+	zerolog.Ctx(ctx).Info().
+		Str("principal", record.Principal.String()).
+		Str("keyID", record.Principal.KeyID).
+		Str("account", record.Principal.Account).
+		Str("tenant", record.Principal.Tenant).
+		Str("clientIP", record.ClientIP).
+		Str("service", record.Service).
+		Str("operation", record.Operation).
+		Str("method", record.Method).
+		Str("host", record.Host).
+		Str("path", record.Path).
+		Int("status", record.StatusCode).
+		Int64("duration_ns", int64(record.Duration)).
+		Str("error", record.Error).
+		Str("rejectionReason", string(record.RejectionReason)).
+		Msg("audit")
+}
@@ -0,0 +1,125 @@
+package http_server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Authorizer decides whether verified requests are allowed, e.g. by policies managed outside the proxy
+// (see OPAAuthorizer). It is asked after the key's policies (AWSProxy.PolicyLookupFunc) allowed the request.
+type Authorizer interface {
+	Authorize(ctx context.Context, request AuthorizationRequest) (AuthorizationDecision, error)
+}
+
+// AuthorizerFunc is a func Authorizer
+type AuthorizerFunc func(ctx context.Context, request AuthorizationRequest) (AuthorizationDecision, error)
+
+func (f AuthorizerFunc) Authorize(ctx context.Context, request AuthorizationRequest) (AuthorizationDecision, error) {
+	return f(ctx, request)
+}
+
+// AuthorizationRequest is the credential, service, operation, resources, and metadata of a verified request
+type AuthorizationRequest struct {
+	KeyID     string    `json:"keyID"`
+	Principal Principal `json:"principal"`
+	Service   string    `json:"service"`
+	// Operation is as classified by the provider, e.g. GetObject, or OperationUnknown
+	Operation string `json:"operation"`
+	// Resources are ARNs, see ResourceExtractor
+	Resources []string `json:"resources"`
+	// ResourceOperations are resources also authorized as a different operation, see ResourceOperationExtractor
+	ResourceOperations []ResourceOperation `json:"resourceOperations,omitempty"`
+	Method             string              `json:"method"`
+	Host               string              `json:"host"`
+	Path               string              `json:"path"`
+	// Context are the policy condition keys, e.g. aws:SourceIp and aws:SecureTransport
+	Context map[string]string `json:"context"`
+}
+
+type AuthorizationDecision struct {
+	Allowed bool
+	// Reason is optionally why the request was denied, logged but not returned to the client
+	Reason string
+}
+
+// authorize evaluates the policies of the key, then asks the Authorizer, rejecting denied requests with AccessDenied
+func (p *AWSProxy) authorize(ctx context.Context, provider AWSServiceProvider, request *ProxiedRequest, now time.Time) error {
+	lookups := p.Lookups()
+	lookup, authorizer := lookups.PolicyLookupFunc, lookups.Authorizer
+	if lookup == nil && authorizer == nil {
+		return nil
+	}
+
+	authzRequest := AuthorizationRequest{
+		KeyID:     request.KeyID,
+		Principal: request.Principal,
+		Service:   provider.ServiceName(),
+		Operation: extractOperationName(provider, request),
+		Resources: []string{},
+		Method:    request.Request.Method,
+		Host:      request.OriginalHost,
+		Path:      request.Request.URL.Path,
+		Context:   policyContext(request, now),
+	}
+	if extractor, ok := provider.(ResourceExtractor); ok {
+		resources, err := extractor.ExtractResources(request)
+		if err != nil {
+			// What can't be inspected can't be allowed
+			return reject(RejectionPolicyDenied, fmt.Errorf("error in ExtractResources: %w: %w", ErrAWSAccessDenied, err))
+		}
+		if resources != nil {
+			authzRequest.Resources = resources
+		}
+	}
+	if extractor, ok := provider.(ResourceOperationExtractor); ok {
+		resourceOperations, err := extractor.ExtractResourceOperations(request)
+		if err != nil {
+			return reject(RejectionPolicyDenied, fmt.Errorf("error in ExtractResourceOperations: %w: %w", ErrAWSAccessDenied, err))
+		}
+		authzRequest.ResourceOperations = resourceOperations
+	}
+	action := authzRequest.Service + ":" + authzRequest.Operation
+	resourceActions := make([]ResourceAction, 0, len(authzRequest.ResourceOperations))
+	for _, resourceOperation := range authzRequest.ResourceOperations {
+		resourceActions = append(resourceActions, ResourceAction{
+			Resource: resourceOperation.Resource,
+			Action:   authzRequest.Service + ":" + resourceOperation.Operation,
+		})
+	}
+
+	if lookup != nil {
+		policies, err := lookup(ctx, request.KeyID)
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			return fmt.Errorf("error in PolicyLookupFunc: %w", err)
+		}
+		decision := EvaluatePolicies(policies, PolicyRequest{
+			Action:          action,
+			Resources:       authzRequest.Resources,
+			ResourceActions: resourceActions,
+			Context:         authzRequest.Context,
+		})
+		if !decision.Allowed {
+			how := "no statement allows it"
+			if decision.ExplicitDeny {
+				how = fmt.Sprintf("denied by statement %q", decision.Sid)
+			}
+			return reject(RejectionPolicyDenied, fmt.Errorf("key %s is not allowed %s on %v, %s: %w",
+				request.KeyID, action, authzRequest.Resources, how, ErrAWSAccessDenied))
+		}
+	}
+
+	if authorizer != nil {
+		decision, err := authorizer.Authorize(ctx, authzRequest)
+		if err != nil {
+			// Fail closed, AccessDenied would hide an unavailable authorizer
+			return fmt.Errorf("error in Authorize: %w", err)
+		}
+		if !decision.Allowed {
+			return reject(RejectionPolicyDenied, fmt.Errorf("authorizer denied key %s %s on %v (%s): %w",
+				request.KeyID, action, authzRequest.Resources, decision.Reason, ErrAWSAccessDenied))
+		}
+	}
+	return nil
+}
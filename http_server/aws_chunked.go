@@ -0,0 +1,136 @@
+package http_server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var ErrMalformedChunk = errors.New("malformed aws-chunked body")
+
+// maxChunkHeaderBytes bounds the length of a chunk header line (size + chunk signature)
+const maxChunkHeaderBytes = 4096
+
+// isAWSChunked returns whether the request body uses aws-chunked (streaming payload) framing,
+// see https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-streaming.html
+//
+// The aws-chunked Content-Encoding (possibly combined with the object's own, e.g. "aws-chunked,gzip") is
+// forwarded verbatim with the framed body, S3 strips it when storing the object. Nothing may normalize it.
+func isAWSChunked(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("x-amz-content-sha256"), "STREAMING-") ||
+		hasContentEncoding(r.Header, "aws-chunked")
+}
+
+// hasContentEncoding returns whether any Content-Encoding line of the header lists the encoding
+func hasContentEncoding(header http.Header, encoding string) bool {
+	for _, value := range header.Values("Content-Encoding") {
+		for _, coding := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(coding), encoding) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// awsChunkedReader de-frames an aws-chunked body, yielding only the payload bytes.
+// Each chunk looks like `<hex size>;chunk-signature=<sig>\r\n<data>\r\n`, ending with a 0 sized chunk.
+type awsChunkedReader struct {
+	r         *bufio.Reader
+	remaining int64
+	done      bool
+	// signature of the chunk currently being read, empty for unsigned chunks
+	chunkSignature string
+}
+
+func newAWSChunkedReader(r io.Reader) *awsChunkedReader {
+	return &awsChunkedReader{r: bufio.NewReader(r)}
+}
+
+func (c *awsChunkedReader) Read(p []byte) (int, error) {
+	if c.done {
+		return 0, io.EOF
+	}
+
+	if c.remaining == 0 {
+		if err := c.readChunkHeader(); err != nil {
+			return 0, err
+		}
+		if c.remaining == 0 {
+			// The final chunk, anything after it is trailers
+			c.done = true
+			return 0, io.EOF
+		}
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if errors.Is(err, io.EOF) {
+		return n, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return n, err
+	}
+
+	if c.remaining == 0 {
+		if err = c.readCRLF(); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+func (c *awsChunkedReader) readChunkHeader() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+
+	sizeHex, extension, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(sizeHex, 16, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("bad chunk size %q: %w", sizeHex, ErrMalformedChunk)
+	}
+	c.remaining = size
+	c.chunkSignature = strings.TrimPrefix(extension, "chunk-signature=")
+	return nil
+}
+
+func (c *awsChunkedReader) readLine() (string, error) {
+	var line []byte
+	for {
+		part, isPrefix, err := c.r.ReadLine()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return "", io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		line = append(line, part...)
+		if len(line) > maxChunkHeaderBytes {
+			return "", fmt.Errorf("chunk header too long: %w", ErrMalformedChunk)
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+func (c *awsChunkedReader) readCRLF() error {
+	crlf := make([]byte, 2)
+	if _, err := io.ReadFull(c.r, crlf); err != nil {
+		return fmt.Errorf("error reading chunk terminator: %w", err)
+	}
+	if string(crlf) != "\r\n" {
+		return fmt.Errorf("missing chunk terminator: %w", ErrMalformedChunk)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package http_server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	streamingSignedPayload        = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingSignedPayloadTrailer = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	// streamingSigV4APayload is an aws-chunked body whose chunks (and trailer) are signed with SigV4A
+	streamingSigV4APayload        = "STREAMING-AWS4-ECDSA-P256-SHA256-PAYLOAD"
+	streamingSigV4APayloadTrailer = "STREAMING-AWS4-ECDSA-P256-SHA256-PAYLOAD-TRAILER"
+	// trailerSignatureHeader is the trailer with the signature of the other trailers
+	trailerSignatureHeader = "x-amz-trailer-signature"
+	// maxSignedChunkBytes bounds how much of a chunk is buffered to sign it, SDKs send 64KiB chunks
+	maxSignedChunkBytes = 16 * 1024 * 1024
+)
+
+var ErrChunkSignatureMismatch = errors.New("chunk signature does not match")
+
+// ErrAWSSigV4AStreamingUnsupported rejects aws-chunked uploads signed with SigV4A. Their ECDSA chunk signatures
+// chain from the seed signature, which changes when the proxy re-signs the request, and aren't re-signed.
+var ErrAWSSigV4AStreamingUnsupported = NewAWSError(http.StatusBadRequest, "InvalidRequest",
+	"aws-chunked uploads signed with SigV4A are not supported, sign them with SigV4 or send an unsigned payload.")
+
+// isSigV4ASignedStreaming returns whether the chunks or trailer of a SigV4A request's aws-chunked body are
+// signed. Unsigned trailers (STREAMING-UNSIGNED-PAYLOAD-TRAILER) are forwarded untouched, like with SigV4.
+func isSigV4ASignedStreaming(r *http.Request, parsedHeader AWSAuthHeader) bool {
+	if parsedHeader.Algorithm != AlgorithmSigV4A {
+		return false
+	}
+	sha := r.Header.Get("x-amz-content-sha256")
+	return isSignedStreaming(r) || sha == streamingSigV4APayload || sha == streamingSigV4APayloadTrailer
+}
+
+// isSignedStreaming returns whether every chunk (and the trailer, if declared with X-Amz-Trailer) of an
+// aws-chunked body is signed, chained from the seed signature of the request. Unsigned streaming
+// (STREAMING-UNSIGNED-PAYLOAD-TRAILER) bodies are forwarded untouched.
+func isSignedStreaming(r *http.Request) bool {
+	sha := r.Header.Get("x-amz-content-sha256")
+	return sha == streamingSignedPayload || sha == streamingSignedPayloadTrailer
+}
+
+// chunkSigner computes the signature chain of a streaming payload
+type chunkSigner struct {
+	signingKey []byte
+	date       string
+	scope      string
+	previous   string
+}
+
+// newChunkSigner starts the chain at the seed signature of the request. The chain can't be verified without
+// the X-Amz-Date it was signed at, so a missing or malformed one fails with ErrChunkSignatureMismatch.
+func newChunkSigner(r *http.Request, authHeader AWSAuthHeader, keySecret string) (*chunkSigner, error) {
+	signedAt, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return nil, fmt.Errorf("malformed X-Amz-Date of streaming payload: %w", ErrChunkSignatureMismatch)
+	}
+	day := signedAt.Format("20060102")
+	return &chunkSigner{
+		signingKey: getSigningKeyForDate(day, keySecret, authHeader.Credential.Region, authHeader.Credential.Service),
+		date:       signedAt.Format("20060102T150405Z"),
+		scope:      day + "/" + authHeader.Credential.Region + "/" + authHeader.Credential.Service + "/aws4_request",
+		previous:   authHeader.Signature,
+	}, nil
+}
+
+func (s *chunkSigner) signChunk(data []byte) string {
+	stringToSign := "AWS4-HMAC-SHA256-PAYLOAD\n" + s.date + "\n" + s.scope + "\n" + s.previous + "\n" +
+		fmt.Sprintf("%x", getSHA256(nil)) + "\n" + fmt.Sprintf("%x", getSHA256(data))
+	s.previous = fmt.Sprintf("%x", getHMAC(s.signingKey, []byte(stringToSign)))
+	return s.previous
+}
+
+// signTrailer signs the canonical trailers, each "name:value\n"
+func (s *chunkSigner) signTrailer(canonicalTrailers string) string {
+	stringToSign := "AWS4-HMAC-SHA256-TRAILER\n" + s.date + "\n" + s.scope + "\n" + s.previous + "\n" +
+		fmt.Sprintf("%x", getSHA256([]byte(canonicalTrailers)))
+	s.previous = fmt.Sprintf("%x", getHMAC(s.signingKey, []byte(stringToSign)))
+	return s.previous
+}
+
+// chunkResigner re-frames a signed aws-chunked body for the re-signed outbound request. The client's chunk
+// and trailer signatures are verified against its chain, and replaced with the chain of the outbound seed
+// signature. Signatures are the same length, so the Content-Length is unchanged.
+// With a nil outbound signer the client's signatures are kept, which only verifies the body.
+type chunkResigner struct {
+	in       *awsChunkedReader
+	inbound  *chunkSigner
+	outbound *chunkSigner
+	trailer  bool
+
+	out  bytes.Buffer
+	done bool
+}
+
+func newChunkResigner(body io.Reader, inbound, outbound *chunkSigner, trailer bool) *chunkResigner {
+	return &chunkResigner{
+		in:       newAWSChunkedReader(body),
+		inbound:  inbound,
+		outbound: outbound,
+		trailer:  trailer,
+	}
+}
+
+func (c *chunkResigner) Read(p []byte) (int, error) {
+	for c.out.Len() == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		if err := c.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+	return c.out.Read(p)
+}
+
+func (c *chunkResigner) nextChunk() error {
+	if err := c.in.readChunkHeader(); err != nil {
+		return err
+	}
+	if c.in.remaining > maxSignedChunkBytes {
+		return fmt.Errorf("chunk of %d bytes too large to sign: %w", c.in.remaining, ErrMalformedChunk)
+	}
+
+	data := make([]byte, c.in.remaining)
+	if _, err := io.ReadFull(c.in.r, data); err != nil {
+		return fmt.Errorf("error reading chunk: %w", err)
+	}
+	if !hmac.Equal([]byte(c.inbound.signChunk(data)), []byte(c.in.chunkSignature)) {
+		return ErrChunkSignatureMismatch
+	}
+	signature := c.in.chunkSignature
+	if c.outbound != nil {
+		signature = c.outbound.signChunk(data)
+	}
+	c.out.WriteString(strconv.FormatInt(int64(len(data)), 16) + ";chunk-signature=" + signature + "\r\n")
+	c.out.Write(data)
+
+	if len(data) > 0 {
+		c.in.remaining = 0
+		if err := c.in.readCRLF(); err != nil {
+			return err
+		}
+		c.out.WriteString("\r\n")
+		return nil
+	}
+
+	// The final chunk, followed by the trailers (if any) and an empty line
+	c.done = true
+	if !c.trailer {
+		if err := c.in.readCRLF(); err != nil {
+			return err
+		}
+		c.out.WriteString("\r\n")
+		return nil
+	}
+	return c.resignTrailers()
+}
+
+func (c *chunkResigner) resignTrailers() error {
+	var (
+		lines             []string
+		canonicalTrailers string
+		signature         string
+	)
+	for {
+		line, err := c.in.readLine()
+		if err != nil {
+			return fmt.Errorf("error reading trailer: %w", err)
+		}
+		if line == "" {
+			break
+		}
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			return fmt.Errorf("bad trailer %q: %w", line, ErrMalformedChunk)
+		}
+		if strings.EqualFold(name, trailerSignatureHeader) {
+			signature = strings.TrimSpace(value)
+			continue
+		}
+		lines = append(lines, line)
+		canonicalTrailers += strings.ToLower(name) + ":" + strings.TrimSpace(value) + "\n"
+	}
+
+	if !hmac.Equal([]byte(c.inbound.signTrailer(canonicalTrailers)), []byte(signature)) {
+		return ErrChunkSignatureMismatch
+	}
+	for _, line := range lines {
+		c.out.WriteString(line + "\r\n")
+	}
+	if c.outbound != nil {
+		signature = c.outbound.signTrailer(canonicalTrailers)
+	}
+	c.out.WriteString(trailerSignatureHeader + ":" + signature + "\r\n\r\n")
+	return nil
+}
+
+// newVerifiedChunkedReader de-frames a signed aws-chunked body like awsChunkedReader, but fails with
+// ErrChunkSignatureMismatch on a chunk (or trailer) that isn't signed by the chain of the seed signature
+func newVerifiedChunkedReader(body io.Reader, r *http.Request, authHeader AWSAuthHeader, keySecret string) io.Reader {
+	signer, err := newChunkSigner(r, authHeader, keySecret)
+	if err != nil {
+		return errReader{err: err}
+	}
+	trailer := r.Header.Get("x-amz-content-sha256") == streamingSignedPayloadTrailer
+	return newAWSChunkedReader(newChunkResigner(body, signer, nil, trailer))
+}
+
+// errReader fails every Read with err
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
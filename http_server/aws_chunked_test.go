@@ -0,0 +1,228 @@
+package http_server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newStreamingUpload is a PutObject of the payload in aws-chunked chunks of chunkSize, each signed in the chain
+// of the request's seed signature. tamper changes a byte of the second chunk after it was signed.
+func newStreamingUpload(t *testing.T, url string, payload []byte, chunkSize int, tamper bool) *http.Request {
+	t.Helper()
+	r, _ := http.NewRequest(http.MethodPut, url+"/bucket/key", nil)
+	r.Header.Set("x-amz-content-sha256", streamingSignedPayload)
+	r.Header.Set("Content-Encoding", "aws-chunked")
+	r.Header.Set("x-amz-decoded-content-length", strconv.Itoa(len(payload)))
+	signWithHeaders(r, "AKIACLIENT", "client_secret", "s3", "content-encoding", "x-amz-decoded-content-length")
+
+	signer, err := newChunkSigner(r, parseAuthHeader(r.Header.Get("Authorization")), "client_secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var framed bytes.Buffer
+	for i := 0; ; i++ {
+		chunk := payload[:min(chunkSize, len(payload))]
+		payload = payload[len(chunk):]
+		signature := signer.signChunk(chunk)
+		if tamper && i == 1 {
+			chunk = bytes.ToUpper(chunk)
+		}
+		fmt.Fprintf(&framed, "%x;chunk-signature=%s\r\n%s\r\n", len(chunk), signature, chunk)
+		if len(chunk) == 0 {
+			break
+		}
+	}
+	r.Body = io.NopCloser(&framed)
+	r.ContentLength = int64(framed.Len())
+	return r
+}
+
+// streamingOrigin verifies the chunk signatures of the re-signed uploads it receives, and records the decoded payload
+type streamingOrigin struct {
+	header  http.Header
+	payload []byte
+	err     error
+	// response is an XML body to respond with
+	response []byte
+}
+
+func (o *streamingOrigin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.header = r.Header.Clone()
+	o.payload, o.err = io.ReadAll(newVerifiedChunkedReader(r.Body, r, parseAuthHeader(r.Header.Get("Authorization")), "client_secret"))
+	if o.response != nil {
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write(o.response)
+	}
+}
+
+func newStreamingProxy(t *testing.T, handler OperationHandler) (*httptest.Server, *streamingOrigin) {
+	t.Helper()
+	recorder := &streamingOrigin{}
+	origin := httptest.NewServer(recorder)
+	t.Cleanup(origin.Close)
+
+	provider := NewS3Provider()
+	provider.OriginHost = origin.URL
+	if handler != nil {
+		provider.RegisterOperationHandler("PutObject", func(ctx context.Context, request *ProxiedRequest) (*http.Response, error) {
+			if res, err := handler(ctx, request); res != nil || err != nil {
+				return res, err
+			}
+			return request.DoProxiedRequest(ctx, origin.URL)
+		})
+	}
+	server := httptest.NewServer(&AWSProxy{
+		KeyLookupFunc: func(context.Context, string) (string, error) {
+			return "client_secret", nil
+		},
+		ServiceLookupFunc: func(context.Context, string) (AWSServiceProvider, error) {
+			return provider, nil
+		},
+	})
+	t.Cleanup(server.Close)
+	return server, recorder
+}
+
+func TestAWSChunkedProxy(t *testing.T) {
+	payload := bytes.Repeat([]byte("streamed payload "), 1000)
+
+	tests := []struct {
+		name    string
+		inspect bool
+	}{
+		{name: "proxied through"},
+		{name: "inspected then forwarded", inspect: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var decoded []byte
+			var handler OperationHandler
+			if tt.inspect {
+				handler = func(_ context.Context, request *ProxiedRequest) (*http.Response, error) {
+					var err error
+					if decoded, err = io.ReadAll(request.DecodedBody()); err != nil {
+						return nil, err
+					}
+					return nil, nil
+				}
+			}
+			server, origin := newStreamingProxy(t, handler)
+
+			res, err := server.Client().Do(newStreamingUpload(t, server.URL, payload, 4096, false))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("got status %d", res.StatusCode)
+			}
+
+			if tt.inspect && !bytes.Equal(decoded, payload) {
+				t.Errorf("handler decoded %d bytes, want the %d byte payload", len(decoded), len(payload))
+			}
+			// The origin gets the framing, with chunk signatures it can verify against the re-signed request
+			if origin.err != nil {
+				t.Fatalf("origin failed to verify the upload: %v", origin.err)
+			}
+			if !bytes.Equal(origin.payload, payload) {
+				t.Errorf("origin decoded %d bytes, want the %d byte payload", len(origin.payload), len(payload))
+			}
+			if got := origin.header.Get("Content-Encoding"); got != "aws-chunked" {
+				t.Errorf("origin got Content-Encoding %q", got)
+			}
+			if got := origin.header.Get("x-amz-decoded-content-length"); got != strconv.Itoa(len(payload)) {
+				t.Errorf("origin got x-amz-decoded-content-length %q", got)
+			}
+			if got := origin.header.Get("x-amz-content-sha256"); got != streamingSignedPayload {
+				t.Errorf("origin got x-amz-content-sha256 %q", got)
+			}
+		})
+	}
+}
+
+// Regression test: response compression must neither touch the aws-chunked Content-Encoding of the upload
+// nor gzip the response to it
+func TestAWSChunkedProxyWithCompression(t *testing.T) {
+	payload := bytes.Repeat([]byte("streamed payload "), 1000)
+	response := []byte("<PutObjectResult>" + strings.Repeat("<Part/>", 1000) + "</PutObjectResult>")
+	server, origin := newStreamingProxy(t, nil)
+	server.Config.Handler.(*AWSProxy).Compression = &ResponseCompression{}
+	origin.response = response
+
+	r := newStreamingUpload(t, server.URL, payload, 4096, false)
+	r.Header.Set("Accept-Encoding", "gzip")
+	res, err := server.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d", res.StatusCode)
+	}
+
+	if origin.err != nil {
+		t.Fatalf("origin failed to verify the upload: %v", origin.err)
+	}
+	if !bytes.Equal(origin.payload, payload) {
+		t.Errorf("origin decoded %d bytes, want the %d byte payload", len(origin.payload), len(payload))
+	}
+	if got := origin.header.Values("Content-Encoding"); len(got) != 1 || got[0] != "aws-chunked" {
+		t.Errorf("origin got Content-Encoding %q", got)
+	}
+	if got := res.Header.Get("Content-Encoding"); got != "" {
+		t.Errorf("response got Content-Encoding %q", got)
+	}
+	if !bytes.Equal(body, response) {
+		t.Errorf("got a %d byte response, want the %d byte origin response", len(body), len(response))
+	}
+}
+
+// Handlers never see a payload the client didn't sign
+func TestAWSChunkedDecodedBodyTampered(t *testing.T) {
+	var decodeErr error
+	server, origin := newStreamingProxy(t, func(_ context.Context, request *ProxiedRequest) (*http.Response, error) {
+		_, decodeErr = io.ReadAll(request.DecodedBody())
+		return &http.Response{StatusCode: http.StatusBadRequest, Header: http.Header{}, Body: http.NoBody}, nil
+	})
+
+	res, err := server.Client().Do(newStreamingUpload(t, server.URL, []byte(strings.Repeat("a", 10000)), 4096, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if !errors.Is(decodeErr, ErrChunkSignatureMismatch) {
+		t.Errorf("got %v, want ErrChunkSignatureMismatch", decodeErr)
+	}
+	if origin.header != nil {
+		t.Error("origin received the tampered upload")
+	}
+}
+
+func TestAWSChunkedDecodedBodyUnsigned(t *testing.T) {
+	framed := "5\r\nhello\r\n6\r\n world\r\n0\r\nx-amz-checksum-crc32:DUoRhQ==\r\n\r\n"
+	r := httptest.NewRequest(http.MethodPut, "/bucket/key", strings.NewReader(framed))
+	r.Header.Set("x-amz-content-sha256", "STREAMING-UNSIGNED-PAYLOAD-TRAILER")
+	r.Header.Set("Content-Encoding", "aws-chunked")
+	request := &ProxiedRequest{Request: r}
+
+	decoded, err := io.ReadAll(request.DecodedBody())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != "hello world" {
+		t.Errorf("got %q", decoded)
+	}
+	// The framed body is still there to forward
+	if forwarded, _ := io.ReadAll(request.Request.Body); string(forwarded) != framed {
+		t.Errorf("got forwarded body %q", forwarded)
+	}
+}
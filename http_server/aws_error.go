@@ -0,0 +1,215 @@
+package http_server
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/samber/lo"
+
+	"github.com/danthegoodman1/IAMTheService/gologger"
+	"github.com/danthegoodman1/IAMTheService/utils"
+)
+
+// AWSProtocol is the wire protocol of an AWS service, which determines the shape of its errors
+type AWSProtocol string
+
+const (
+	// ProtocolRESTXML is used by S3 (and other REST-XML services)
+	ProtocolRESTXML AWSProtocol = "rest-xml"
+	// ProtocolJSON is AWS JSON 1.0/1.1, used by DynamoDB, KMS, Kinesis, etc.
+	ProtocolJSON AWSProtocol = "json"
+	// ProtocolQuery is the AWS query protocol (Action=...), used by STS, IAM, SQS, SNS, etc.
+	ProtocolQuery AWSProtocol = "query"
+)
+
+var jsonProtocolServices = map[string]bool{
+	"dynamodb": true,
+	"kms":      true,
+	"kinesis":  true,
+	"logs":     true,
+	"events":   true,
+	"firehose": true,
+	"ssm":      true,
+	"lambda":   true,
+}
+
+var queryProtocolServices = map[string]bool{
+	"sts":         true,
+	"iam":         true,
+	"sqs":         true,
+	"sns":         true,
+	"ec2":         true,
+	"autoscaling": true,
+}
+
+// registeredProtocols are the protocols of services added with RegisterServiceProtocol
+var registeredProtocols sync.Map
+
+// RegisterServiceProtocol sets the wire protocol of a service that isn't built in, so errors for it are
+// rendered in its shape. NewQueryProtocolProvider and NewJSONRPCProvider register their service.
+func RegisterServiceProtocol(service string, protocol AWSProtocol) {
+	registeredProtocols.Store(service, protocol)
+}
+
+// ProtocolForService returns the wire protocol of a credential scope service, defaulting to REST-XML
+func ProtocolForService(service string) AWSProtocol {
+	if protocol, ok := registeredProtocols.Load(service); ok {
+		return protocol.(AWSProtocol)
+	}
+	switch {
+	case jsonProtocolServices[service]:
+		return ProtocolJSON
+	case queryProtocolServices[service]:
+		return ProtocolQuery
+	}
+	return ProtocolRESTXML
+}
+
+// AWSError is an error that is rendered to the client in the wire format of the target service,
+// so SDKs can parse it
+type AWSError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *AWSError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func NewAWSError(statusCode int, code, message string) *AWSError {
+	return &AWSError{
+		StatusCode: statusCode,
+		Code:       code,
+		Message:    message,
+	}
+}
+
+var (
+	ErrAWSSignatureDoesNotMatch = NewAWSError(http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided.")
+	ErrAWSAccessDenied          = NewAWSError(http.StatusForbidden, "AccessDenied", "Access Denied")
+	ErrAWSInvalidAccessKeyID    = NewAWSError(http.StatusForbidden, "InvalidAccessKeyId", "The AWS Access Key Id you provided does not exist in our records.")
+	ErrAWSInternalError         = NewAWSError(http.StatusInternalServerError, "InternalError", "We encountered an internal error. Please try again.")
+	ErrAWSRequestReplayed       = NewAWSError(http.StatusForbidden, "AccessDenied", "Request has already been used.")
+	ErrAWSGatewayTimeout        = NewAWSError(http.StatusGatewayTimeout, "GatewayTimeout", "The origin did not respond in time.")
+	ErrAWSSlowDown              = NewAWSError(http.StatusServiceUnavailable, "SlowDown", "Please reduce your request rate.")
+	ErrAWSServiceUnavailable    = NewAWSError(http.StatusServiceUnavailable, "ServiceUnavailable", "Please reduce your request rate.")
+	ErrAWSOriginUnavailable     = NewAWSError(http.StatusServiceUnavailable, "ServiceUnavailable", "The origin could not be reached.")
+	ErrAWSNotImplemented        = NewAWSError(http.StatusNotImplemented, "NotImplemented", "The request is not a recognized operation of the service.")
+	ErrAWSRequestTimeout        = NewAWSError(http.StatusBadRequest, "RequestTimeout", "The origin did not respond within the timeout period.")
+)
+
+type xmlError struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	RequestId string   `xml:"RequestId"`
+}
+
+type queryError struct {
+	XMLName xml.Name `xml:"ErrorResponse"`
+	Error   struct {
+		// Type is Sender for client errors and Receiver for server errors
+		Type    string `xml:"Type"`
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	} `xml:"Error"`
+	RequestId string `xml:"RequestId"`
+}
+
+// encodeAWSError renders the error body and headers for the protocol, with the request id
+// in both, since SDKs surface it in the errors they return
+func encodeAWSError(protocol AWSProtocol, awsErr *AWSError, requestID string) (http.Header, []byte) {
+	header := http.Header{}
+	var body []byte
+	switch protocol {
+	case ProtocolJSON:
+		header.Set("Content-Type", "application/x-amz-json-1.1")
+		header.Set("x-amzn-ErrorType", awsErr.Code)
+		header.Set("x-amzn-RequestId", requestID)
+		body = utils.MustMarshal(map[string]string{
+			"__type":  awsErr.Code,
+			"message": awsErr.Message,
+		})
+	case ProtocolQuery:
+		header.Set("Content-Type", "text/xml")
+		header.Set("x-amzn-RequestId", requestID)
+		queryErr := queryError{RequestId: requestID}
+		queryErr.Error.Type = lo.Ternary(awsErr.StatusCode >= 500, "Receiver", "Sender")
+		queryErr.Error.Code = awsErr.Code
+		queryErr.Error.Message = awsErr.Message
+		b, _ := xml.Marshal(queryErr)
+		body = append([]byte(xml.Header), b...)
+	default:
+		header.Set("Content-Type", "application/xml")
+		header.Set("x-amz-request-id", requestID)
+		b, _ := xml.Marshal(xmlError{Code: awsErr.Code, Message: awsErr.Message, RequestId: requestID})
+		body = append([]byte(xml.Header), b...)
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	return header, body
+}
+
+// requestIDFromContext is the id CreateReqContext gave the request (CustomContext.RequestID), so an error
+// a client reports can be found in the logs. Requests served outside of it get a new one.
+func requestIDFromContext(ctx context.Context) string {
+	if reqID, ok := ctx.Value(gologger.ReqIDKey).(string); ok && reqID != "" {
+		return reqID
+	}
+	return uuid.NewString()
+}
+
+// writeAWSError writes the error to the client in the shape of the protocol
+func writeAWSError(w http.ResponseWriter, r *http.Request, protocol AWSProtocol, awsErr *AWSError) {
+	header, body := encodeAWSError(protocol, awsErr, requestIDFromContext(r.Context()))
+	for key, vals := range header {
+		w.Header()[key] = vals
+	}
+	w.WriteHeader(awsErr.StatusCode)
+	w.Write(body)
+}
+
+// newAWSErrorResponse creates an error response for providers and handlers to return instead of proxying
+func newAWSErrorResponse(ctx context.Context, protocol AWSProtocol, awsErr *AWSError) *http.Response {
+	header, body := encodeAWSError(protocol, awsErr, requestIDFromContext(ctx))
+	return &http.Response{
+		StatusCode:    awsErr.StatusCode,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+}
+
+var credentialRe = regexp.MustCompile(`Credential=([^,\s]+)`)
+
+// requestService gets the credential scope service of a request without fully parsing it,
+// for rendering errors before (or because) the request failed parsing
+func requestService(r *http.Request) string {
+	if isPostPolicyRequest(r) {
+		return "s3"
+	}
+	if match := credentialRe.FindStringSubmatch(r.Header.Get("Authorization")); match != nil {
+		return credentialScopeService(match[1])
+	}
+	if isPresignedRequest(r) {
+		return credentialScopeService(r.URL.Query().Get("X-Amz-Credential"))
+	}
+	return ""
+}
+
+// credentialScopeService is second to last in both SigV4 (key/date/region/service/aws4_request) and
+// SigV4A (key/date/service/aws4_request) credentials
+func credentialScopeService(credential string) string {
+	if parts := strings.Split(credential, "/"); len(parts) >= 4 {
+		return parts[len(parts)-2]
+	}
+	return ""
+}
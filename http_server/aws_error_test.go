@@ -0,0 +1,89 @@
+package http_server
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danthegoodman1/IAMTheService/gologger"
+)
+
+func TestRequestService(t *testing.T) {
+	tests := []struct {
+		name          string
+		url           string
+		authorization string
+		want          string
+	}{
+		{
+			name:          "SigV4",
+			url:           "/",
+			authorization: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/dynamodb/aws4_request, SignedHeaders=host;x-amz-date, Signature=abc",
+			want:          "dynamodb",
+		},
+		{
+			name:          "SigV4A",
+			url:           "/",
+			authorization: "AWS4-ECDSA-P256-SHA256 Credential=AKIDEXAMPLE/20150830/s3/aws4_request, SignedHeaders=host;x-amz-date;x-amz-region-set, Signature=3045",
+			want:          "s3",
+		},
+		{
+			name: "presigned SigV4",
+			url:  "/bucket/key?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=AKIDEXAMPLE%2F20150830%2Fus-east-1%2Fsqs%2Faws4_request&X-Amz-Signature=abc",
+			want: "sqs",
+		},
+		{
+			name: "presigned SigV4A",
+			url:  "/bucket/key?X-Amz-Algorithm=AWS4-ECDSA-P256-SHA256&X-Amz-Credential=AKIDEXAMPLE%2F20150830%2Fs3%2Faws4_request&X-Amz-Signature=3045",
+			want: "s3",
+		},
+		{
+			name:          "truncated",
+			url:           "/",
+			authorization: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830, Signature=abc",
+			want:          "",
+		},
+		{name: "unsigned", url: "/", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			if got := requestService(r); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteAWSErrorRequestID(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/bucket/key", nil)
+	r = r.WithContext(context.WithValue(r.Context(), gologger.ReqIDKey, "req-123"))
+
+	w := httptest.NewRecorder()
+	writeAWSError(w, r, ProtocolRESTXML, ErrAWSAccessDenied)
+	if got := w.Header().Get("x-amz-request-id"); got != "req-123" {
+		t.Errorf("got request id header %q", got)
+	}
+	var body xmlError
+	if err := xml.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.RequestId != "req-123" || body.Code != "AccessDenied" {
+		t.Errorf("got %+v", body)
+	}
+
+	res := newAWSErrorResponse(r.Context(), ProtocolJSON, ErrAWSAccessDenied)
+	if got := res.Header.Get("x-amzn-RequestId"); got != "req-123" {
+		t.Errorf("got request id header %q", got)
+	}
+
+	// Requests outside of CreateReqContext still get one
+	if requestIDFromContext(context.Background()) == "" {
+		t.Error("no request id")
+	}
+}
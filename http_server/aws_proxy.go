@@ -2,62 +2,532 @@ package http_server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/samber/lo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/danthegoodman1/IAMTheService/utils"
 )
 
 type LookupFunc[TKey any, TVal any] func(ctx context.Context, key TKey) (TVal, error)
 
 type AWSProxy struct {
-	// AWS Key id to secret
+	// AWS Key id to secret, used if SecretProvider and CredentialStore are nil
 	KeyLookupFunc LookupFunc[string, string]
-	// incoming hostname to outgoing hostname
+	// Optional store of key secrets, used if SecretProvider is nil
+	CredentialStore CredentialStore
+	// Optional source of versioned key secrets, accepting previous versions during a rotation
+	SecretProvider SecretProvider
+	// incoming hostname to outgoing hostname, the origin of providers without Endpoints. Hosts it returns
+	// ErrKeyNotFound for go to the OriginHost (or default origin) of the provider.
 	HostLookupFunc LookupFunc[string, string]
-	// incoming hostname to service provider
+	// incoming hostname to service provider, used if Providers is nil
 	ServiceLookupFunc LookupFunc[string, AWSServiceProvider]
+	// Providers selects the provider by credential scope service, falling back to the host
+	Providers *ProviderRegistry
+	// Optional policies of a key id, which verified requests must be allowed by, see EvaluatePolicies.
+	// Keys without policies (ErrKeyNotFound) are denied everything.
+	PolicyLookupFunc LookupFunc[string, []PolicyDocument]
+	// Optional external authorizer (e.g. OPAAuthorizer) that requests allowed by their policies must also be allowed by
+	Authorizer Authorizer
+	// Optional rate limits of a key id, see StaticRateLimits. Keys without rules (ErrKeyNotFound) are unlimited.
+	RateLimitLookupFunc LookupFunc[string, []RateLimitRule]
+	// RateLimitStore holds the buckets of the rate limits, defaults to a MemoryRateLimitStore.
+	// Use a RedisRateLimitStore to limit keys across instances.
+	RateLimitStore RateLimitStore
+	// Optional outbound client customization per origin, defaults to DefaultOriginClientProvider
+	OriginClientProvider OriginClientProvider
+	// OutboundCredentials optionally re-signs requests with different credentials than the client's key,
+	// e.g. real AWS credentials for virtual tenant keys
+	OutboundCredentials OutboundCredentialsProvider
+	// Optional per-service (credential scope service) override of DefaultMandatorySignedHeaders
+	MandatorySignedHeaders map[string][]string
+	// Optional resolver of the identity behind a key id, defaults to KeyIDPrincipalResolver
+	PrincipalResolver PrincipalResolver
+	// Optional sink for an audit record of every verified request
+	AuditSink AuditSink
+	// Peers whose inbound X-Forwarded-* headers are appended to rather than replaced
+	TrustedProxies []netip.Prefix
+	// Optional header (e.g. X-Forwarded-Host) that TrustedProxies set to the Host the client sent, for load
+	// balancers that rewrite the Host. Requests from them are verified and routed with the client's host.
+	SignedHostHeader string
+	// Optional hedging of slow idempotent reads to the origin
+	HedgePolicy *HedgePolicy
+	// Optional timeout for the origin to respond, returning a GatewayTimeout error if it doesn't
+	Timeout time.Duration
+	// Optional per-operation overrides of Timeout, e.g. a short one for dynamodb GetItem
+	OperationTimeouts map[OperationKey]time.Duration
+	// Optional rejection of replayed signed requests
+	ReplayProtection *ReplayProtection
+	// Optional origin response headers to remove (e.g. Server, x-amz-id-2), so the backend isn't leaked
+	StripResponseHeaders []string
+	// Optional origin response headers to replace the value of
+	RewriteResponseHeaders map[string]string
+	// Optional gzip compression of responses to clients that accept it
+	Compression *ResponseCompression
+	// Optional source of the current time, defaults to RealClock
+	Clock Clock
+	// Optional load shedding that lowers the in-flight limit when the origin is degrading
+	AdaptiveLimiter *AdaptiveLimiter
+	// Optional limits on the headers and query of requests, checked before they are verified
+	Limits *RequestLimits
+	// Optional sampled logging of request and response bodies, for debugging
+	BodyLogger *BodyLogger
+	// Optional maintenance mode rejecting writes, which can be toggled at runtime
+	ReadOnly *ReadOnlyMode
+	// Optional per-request origin override by trusted callers, for canary testing
+	OriginOverride *OriginOverride
+	// Optional verification of the body against the signed x-amz-content-sha256
+	PayloadVerification *PayloadVerification
+	// Optional validation of the session token of requests signed with temporary credentials
+	SessionTokens SessionTokenValidator
+	// Optional retries of failed origin requests, with a pluggable Backoff
+	RetryPolicy *RetryPolicy
+	// How far the X-Amz-Date of a request may be from Clock, defaults to DefaultMaxClockSkew, negative disables the check
+	MaxClockSkew time.Duration
+
+	requests requestTracker
+	// reloaded replaces the lookups of the fields once Reload is called
+	reloaded atomic.Pointer[ProxyLookups]
+	// rateLimits is the default RateLimitStore
+	rateLimits MemoryRateLimitStore
 }
 
-func (p *AWSProxy) handleRequest(w http.ResponseWriter, r *http.Request) error {
-	ctx := context.Background()
+func (p *AWSProxy) mandatorySignedHeaders(service string) []string {
+	if headers, ok := p.MandatorySignedHeaders[service]; ok {
+		return headers
+	}
+	return DefaultMandatorySignedHeaders
+}
 
-	parsedHeader := parseAuthHeader(r.Header.Get("Authorization"))
+func (p *AWSProxy) maxClockSkew() time.Duration {
+	if p.MaxClockSkew == 0 {
+		return DefaultMaxClockSkew
+	}
+	return p.MaxClockSkew
+}
 
-	// Look up key secret from ID
-	keySecret, err := p.KeyLookupFunc(ctx, parsedHeader.Credential.KeyID)
+// ServeHTTP makes the AWSProxy usable as an http.Handler
+func (p *AWSProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !p.requests.start() {
+		// SDKs retry 503s, hopefully against an instance that isn't shutting down
+		w.Header().Set("Connection", "close")
+		writeAWSError(w, r, ProtocolForService(requestService(r)), ErrAWSServiceUnavailable)
+		return
+	}
+	defer p.requests.done()
+
+	ctx, span := startProxySpan(r)
+	started := &startedResponseWriter{ResponseWriter: w}
+	err := p.handleRequest(started, r.WithContext(ctx))
+	endSpan(span, err)
 	if err != nil {
-		// TODO respond
-		return fmt.Errorf("error looking up key: %w", err)
+		logger.Error().Err(err).Msg("error handling proxied request")
+		reason, rejected := rejectionReason(err)
+		if rejected {
+			rejectionsTotal.WithLabelValues(string(reason)).Inc()
+		}
+		if started.started {
+			// An error body would be appended to the response (e.g. a stream the origin broke off), so the
+			// connection is aborted for the client to see the response fail
+			panic(http.ErrAbortHandler)
+		}
+		if rejected {
+			w.Header().Set(RejectReasonHeader, string(reason))
+		}
+		awsErr, ok := utils.AsErr[*AWSError](err)
+		if !ok {
+			awsErr = ErrAWSInternalError
+		}
+		writeAWSError(w, r, ProtocolForService(requestService(r)), awsErr)
+	}
+}
+
+// startedResponseWriter records whether the response was started, after which an error can't be written
+type startedResponseWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (w *startedResponseWriter) WriteHeader(statusCode int) {
+	// Informational responses (e.g. 100 Continue) are followed by the real one
+	if statusCode >= 200 {
+		w.started = true
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *startedResponseWriter) Write(b []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *startedResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.started = true
+		flusher.Flush()
 	}
+}
+
+// ReadFrom keeps io.Copy using the io.ReaderFrom of the underlying writer, as it would without the wrapper
+func (w *startedResponseWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.started = true
+	return io.Copy(w.ResponseWriter, r)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *startedResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// lookupSecrets gets the accepted secrets of the key id, rejecting unknown keys
+func (p *AWSProxy) lookupSecrets(ctx context.Context, keyID string) (_ []Secret, err error) {
+	ctx, span := startSpan(ctx, "lookup secrets", attribute.String("key_id", keyID))
+	defer func() { endSpan(span, err) }()
+
+	lookups := p.Lookups()
+	provider := lookups.SecretProvider
+	switch {
+	case provider != nil:
+	case lookups.CredentialStore != nil:
+		provider = CredentialStoreSecretProvider{Store: lookups.CredentialStore}
+	default:
+		provider = LookupSecretProvider{Lookup: lookups.KeyLookupFunc}
+	}
+	secrets, err := provider.Secrets(ctx, keyID)
+	// An empty secret is a lookup that didn't report the key missing, which must not verify anything
+	secrets = lo.Filter(secrets, func(secret Secret, _ int) bool {
+		return secret.Value != ""
+	})
+	if errors.Is(err, ErrKeyNotFound) || (err == nil && len(secrets) == 0) {
+		return nil, reject(RejectionUnknownKey, fmt.Errorf("key %s: %w: %w", keyID, ErrAWSInvalidAccessKeyID, ErrKeyNotFound))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error in Secrets: %w", err)
+	}
+	return secrets, nil
+}
+
+// verifyWithSecrets returns the first of the secrets that verify succeeds with,
+// or the error of the current secret if none do
+func verifyWithSecrets(secrets []Secret, verify func(keySecret string) error) (string, error) {
+	var firstErr error
+	for _, secret := range secrets {
+		err := verify(secret.Value)
+		if err == nil {
+			return secret.Value, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return "", firstErr
+}
+
+// lookupServiceProvider gets the provider of the service listener serving the request, from the registry,
+// or by host from ServiceLookupFunc
+func (p *AWSProxy) lookupServiceProvider(ctx context.Context, request *ProxiedRequest) (_ AWSServiceProvider, err error) {
+	if provider, ok := ctx.Value(fixedProviderKey{}).(AWSServiceProvider); ok {
+		return provider, nil
+	}
+	ctx, span := startSpan(ctx, "lookup service provider")
+	defer func() { endSpan(span, err) }()
+
+	lookups := p.Lookups()
+	if lookups.Providers != nil {
+		return lookups.Providers.GetProviderForRequest(request)
+	}
+	provider, err := lookups.ServiceLookupFunc(ctx, request.Request.Host)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up service provider for host %s: %w", request.Request.Host, err)
+	}
+	return provider, nil
+}
+
+// Drain stops accepting new requests, and waits for in-flight requests to finish (or ctx to be done)
+func (p *AWSProxy) Drain(ctx context.Context) error {
+	return p.requests.drain(ctx)
+}
+
+func (p *AWSProxy) handleRequest(w http.ResponseWriter, r *http.Request) (err error) {
+	// The request context is cancelled when the client disconnects, which aborts the origin request
+	// (and so the response stream) rather than downloading what nobody will read
+	ctx := r.Context()
+	clock := clockOrReal(p.Clock)
+	start := clock.Now()
+	if host := signedHost(r, p.SignedHostHeader, p.TrustedProxies); host != "" {
+		r.Host = host
+	}
+
+	var (
+		parsedHeader AWSAuthHeader
+		postPolicy   *S3PostPolicy
+		keySecret    string
+	)
+	limits := p.Lookups().Limits
+	if limits != nil {
+		if err = limits.checkRequest(r); err != nil {
+			return reject(RejectionLimitExceeded, fmt.Errorf("error in checkRequest: %w: %w", ErrAWSRequestLimitExceeded, err))
+		}
+	}
+
+	// The span ends once the request is verified, or with the rejection
+	verifyCtx, verifySpan := startSpan(ctx, "verify signature")
+	defer func() { endSpan(verifySpan, err) }()
+
+	if isPostPolicyRequest(r) {
+		// Browser-based uploads sign the policy document in the form, rather than the request
+		postPolicy, err = readPostPolicyForm(r)
+		if err != nil {
+			return reject(RejectionMalformedAuth, fmt.Errorf("error in readPostPolicyForm: %w: %w", ErrAWSAccessDenied, err))
+		}
+
+		secrets, err := p.lookupSecrets(verifyCtx, postPolicy.Credential.KeyID)
+		if err != nil {
+			if errors.Is(err, ErrKeyNotFound) {
+				recordSignatureVerification(postPolicy.Credential.KeyID, "unknown_key")
+			}
+			return fmt.Errorf("error in lookupSecrets: %w", err)
+		}
 
-	signature := generateSigV4(r, parsedHeader, keySecret)
+		keySecret, err = verifyWithSecrets(secrets, func(keySecret string) error {
+			return postPolicy.verifySignature(keySecret, clock.Now())
+		})
+		recordSignatureVerification(postPolicy.Credential.KeyID, lo.Ternary(err == nil, "success", "failure"))
+		if err != nil {
+			reason := lo.Ternary(errors.Is(err, ErrPostPolicyExpired), RejectionExpired, RejectionInvalidSignature)
+			return reject(reason, fmt.Errorf("error verifying post policy: %w: %w", ErrAWSAccessDenied, err))
+		}
+		// The conditions are enforced here too, since the form may be re-signed for the origin
+		lengthRange, err := postPolicy.checkConditions(ParseS3Request(&ProxiedRequest{Request: r}).Bucket)
+		if err != nil {
+			return reject(RejectionPostPolicyCondition, fmt.Errorf("error in checkConditions: %w: %w", ErrAWSAccessDenied, err))
+		}
+		if lengthRange != nil {
+			r.Body = postPolicy.limitFileSize(r.Body, *lengthRange)
+		}
+
+		parsedHeader = AWSAuthHeader{
+			Credential: postPolicy.Credential,
+			Signature:  postPolicy.Signature,
+		}
+	} else {
+		// Presigned URLs carry the signature in the query string rather than the Authorization header
+		parsedHeader, err = parseRequestAuth(r, clock.Now())
+		if errors.Is(err, ErrPresignedURLExpired) {
+			return reject(RejectionExpired, fmt.Errorf("error in parseRequestAuth: %w: %w", ErrAWSPresignedURLExpired, err))
+		}
+		if err != nil {
+			return reject(RejectionMalformedAuth, fmt.Errorf("error in parseRequestAuth: %w: %w", ErrAWSAccessDenied, err))
+		}
+		if parsedHeader.Credential.KeyID == "" || parsedHeader.Signature == "" {
+			return reject(RejectionMalformedAuth, fmt.Errorf("missing credential or signature: %w", ErrAWSAccessDenied))
+		}
+		if maxSkew := p.maxClockSkew(); maxSkew > 0 {
+			if err = checkClockSkew(r, clock.Now(), maxSkew); err != nil {
+				return reject(RejectionClockSkew, fmt.Errorf("error in checkClockSkew: %w", err))
+			}
+		}
+		if limits != nil {
+			if err = limits.checkSignedHeaders(parsedHeader); err != nil {
+				return reject(RejectionLimitExceeded, fmt.Errorf("error in checkSignedHeaders: %w: %w", ErrAWSRequestLimitExceeded, err))
+			}
+		}
+		mandatory := mandatorySignedHeadersFor(r, parsedHeader, p.mandatorySignedHeaders(parsedHeader.Credential.Service))
+		if r.Header.Get("X-Amz-Trailer") != "" {
+			// The trailers are only covered by the signature if their declaration is
+			mandatory = append(append([]string{}, mandatory...), "x-amz-trailer")
+		}
+		if err = checkMandatorySignedHeaders(r, parsedHeader, mandatory); err != nil {
+			return reject(RejectionMissingSignedHeaders, fmt.Errorf("error in checkMandatorySignedHeaders: %w: %w", ErrAWSAccessDenied, err))
+		}
+
+		// Look up key secrets from ID
+		secrets, err := p.lookupSecrets(verifyCtx, parsedHeader.Credential.KeyID)
+		if err != nil {
+			if errors.Is(err, ErrKeyNotFound) {
+				recordSignatureVerification(parsedHeader.Credential.KeyID, "unknown_key")
+			}
+			return fmt.Errorf("error in lookupSecrets: %w", err)
+		}
+
+		// Keep the secret the request was signed with for re-signing
+		keySecret, err = verifyWithSecrets(secrets, func(keySecret string) error {
+			return verifyRequestSignature(r, parsedHeader, keySecret)
+		})
+		recordSignatureVerification(parsedHeader.Credential.KeyID, lo.Ternary(err == nil, "success", "failure"))
+		if err != nil {
+			return reject(RejectionInvalidSignature, fmt.Errorf("error in verifyRequestSignature: %w", err))
+		}
+		if isSigV4ASignedStreaming(r, parsedHeader) {
+			return reject(RejectionUnsupportedSigning, fmt.Errorf("signed streaming payload: %w", ErrAWSSigV4AStreamingUnsupported))
+		}
+		if err = p.verifySessionToken(verifyCtx, r, parsedHeader); err != nil {
+			return fmt.Errorf("error in verifySessionToken: %w", err)
+		}
 
-	if signature != parsedHeader.Signature {
-		// TODO respond
-		return fmt.Errorf("invalid signature")
+		if p.PayloadVerification != nil {
+			if err = p.PayloadVerification.verify(r); err != nil {
+				if errors.Is(err, ErrPayloadHashMismatch) {
+					return reject(RejectionPayloadMismatch, fmt.Errorf("error in PayloadVerification.verify: %w", err))
+				}
+				return fmt.Errorf("error in PayloadVerification.verify: %w", err)
+			}
+		}
 	}
 
+	verifySpan.SetAttributes(attribute.String("key_id", parsedHeader.Credential.KeyID))
+	verifySpan.End()
+
 	proxiedRequest := ProxiedRequest{
 		Request:        r,
 		OriginalHost:   r.Host,
 		Region:         parsedHeader.Credential.Region,
 		KeyID:          parsedHeader.Credential.KeyID,
+		KeySecret:      keySecret,
 		Service:        parsedHeader.Credential.Service,
 		XAMZDate:       parsedHeader.Credential.Date,
+		PostPolicy:     postPolicy,
 		responseWriter: w,
 		parsedHeader:   parsedHeader,
+		clientAuth:     parsedHeader,
+		originClients:  p.OriginClientProvider,
+		outboundCreds:  p.OutboundCredentials,
+		hedgePolicy:    p.HedgePolicy,
+		retryPolicy:    p.RetryPolicy,
+		hostLookup:     p.Lookups().HostLookupFunc,
+		clock:          clock,
+	}
+	proxiedRequest.forwardedHeaders, proxiedRequest.ClientIP = forwardedFor(r, p.TrustedProxies)
+	if p.OriginOverride != nil {
+		proxiedRequest.originOverride = p.OriginOverride.resolve(r, parsedHeader)
 	}
 
-	serviceProvider, err := p.ServiceLookupFunc(ctx, r.Host)
+	principalResolver := p.PrincipalResolver
+	if principalResolver == nil {
+		principalResolver = KeyIDPrincipalResolver
+	}
+	proxiedRequest.Principal, err = principalResolver(ctx, proxiedRequest.KeyID)
+	if errors.Is(err, ErrKeyNotFound) {
+		// The key verified but has no identity behind it, e.g. a user that was since deleted
+		return reject(RejectionUnknownKey, fmt.Errorf("principal of key %s: %w: %w", proxiedRequest.KeyID, ErrAWSInvalidAccessKeyID, err))
+	}
 	if err != nil {
-		// TODO respond
-		return fmt.Errorf("error looking up service provider for host %s: %w", r.Host, err)
+		return fmt.Errorf("error resolving principal: %w: %w", ErrAWSInternalError, err)
+	}
+	statusCode := 0
+	if p.AuditSink != nil {
+		defer func() {
+			record := AuditRecord{
+				Time:       start,
+				Principal:  proxiedRequest.Principal,
+				ClientIP:   proxiedRequest.ClientIP,
+				Service:    proxiedRequest.Service,
+				Operation:  proxiedRequest.Operation,
+				Method:     r.Method,
+				Host:       proxiedRequest.OriginalHost,
+				Path:       r.URL.Path,
+				StatusCode: statusCode,
+				Duration:   clock.Now().Sub(start),
+			}
+			if err != nil {
+				record.Error = err.Error()
+				reason, _ := rejectionReason(err)
+				record.RejectionReason = reason
+			}
+			// Written even if the client disconnected
+			p.AuditSink.WriteAuditRecord(context.WithoutCancel(ctx), record)
+		}()
 	}
 
-	res, err := serviceProvider.HandleRequest(ctx, &proxiedRequest)
+	serviceProvider, err := p.lookupServiceProvider(ctx, &proxiedRequest)
 	if err != nil {
-		// TODO respond
-		return fmt.Errorf("error handling request: %w", err)
+		return fmt.Errorf("error in lookupServiceProvider: %w", err)
+	}
+	service := serviceProvider.ServiceName()
+	// Scoped to the provider the request is routed to, which needn't be the service the client signed for
+	if err = p.checkKeyScope(ctx, &proxiedRequest, service); err != nil {
+		return fmt.Errorf("error in checkKeyScope: %w", err)
+	}
+	// The body is read while proxying, so it is counted as it streams through
+	requestBody := &countingReadCloser{ReadCloser: lo.Ternary[io.ReadCloser](r.Body == nil, http.NoBody, r.Body)}
+	// Empty bodies stay http.NoBody, which is how the outbound request knows to send Content-Length: 0
+	r.Body = lo.Ternary[io.ReadCloser](requestBody.ReadCloser == http.NoBody, http.NoBody, requestBody)
+	var responseBytes int64
+	defer func() {
+		proxiedRequestDuration.WithLabelValues(service, proxiedRequest.Operation).Observe(clock.Now().Sub(start).Seconds())
+		trace.SpanFromContext(ctx).SetAttributes(
+			attribute.String("aws.service", service),
+			attribute.String("aws.operation", proxiedRequest.Operation),
+			attribute.Int("http.status_code", statusCode),
+		)
+		proxiedBytes.WithLabelValues(service, "in").Add(float64(requestBody.n))
+		proxiedBytes.WithLabelValues(service, "out").Add(float64(responseBytes))
+	}()
+
+	if p.ReadOnly != nil && p.ReadOnly.Enabled(serviceProvider.ServiceName()) {
+		operation := extractOperationName(serviceProvider, &proxiedRequest)
+		if !IsReadOperation(service, operation, r.Method) {
+			return reject(RejectionReadOnly, fmt.Errorf("rejected %s in read-only mode: %w", operation, ErrAWSReadOnly))
+		}
+	}
+
+	// Throttled before authorizing, so a flood of requests doesn't reach an external Authorizer
+	if err = p.rateLimit(ctx, w, service, extractOperationName(serviceProvider, &proxiedRequest), &proxiedRequest, clock.Now()); err != nil {
+		return fmt.Errorf("error in rateLimit: %w", err)
+	}
+	if err = p.authorize(ctx, serviceProvider, &proxiedRequest, clock.Now()); err != nil {
+		return fmt.Errorf("error in authorize: %w", err)
+	}
+
+	if p.ReplayProtection != nil {
+		if err = p.ReplayProtection.check(ctx, serviceProvider, &proxiedRequest, clock.Now()); err != nil {
+			if errors.Is(err, ErrAWSRequestReplayed) {
+				return reject(RejectionReplayed, fmt.Errorf("error in ReplayProtection.check: %w", err))
+			}
+			return fmt.Errorf("error in ReplayProtection.check: %w", err)
+		}
+	}
+
+	// Latency is to the response headers, so long downloads don't look like a degrading origin
+	var (
+		originLatency  time.Duration
+		originDegraded bool
+	)
+	if p.AdaptiveLimiter != nil {
+		release, ok := p.AdaptiveLimiter.TryAcquire()
+		if !ok {
+			return reject(RejectionLoadShed, fmt.Errorf("adaptive limit of %d reached: %w", p.AdaptiveLimiter.Limit(), ErrAWSSlowDown))
+		}
+		defer func() {
+			release(originLatency, err != nil || originDegraded)
+		}()
+	}
+
+	var bodies *bodyLog
+	if p.BodyLogger != nil {
+		bodies = p.BodyLogger.start(&proxiedRequest, serviceProvider.ServiceName(), extractOperationName(serviceProvider, &proxiedRequest))
+	}
+
+	originStart := clock.Now()
+	handleCtx, handleSpan := startSpan(ctx, "handle request", attribute.String("aws.service", service))
+	res, err := p.handleWithTimeout(handleCtx, serviceProvider, &proxiedRequest)
+	originLatency = clock.Now().Sub(originStart)
+	handleSpan.SetAttributes(attribute.String("aws.operation", proxiedRequest.Operation))
+	endSpan(handleSpan, err)
+	if err != nil {
+		// Origin error responses are streamed back, this is failing to reach the origin at all
+		return fmt.Errorf("error handling request: %w", originTransportError(err))
 	}
 
 	if proxiedRequest.hijacked {
@@ -65,19 +535,70 @@ func (p *AWSProxy) handleRequest(w http.ResponseWriter, r *http.Request) error {
 		return nil
 	}
 
-	w.WriteHeader(res.StatusCode)
-	// Write the headers
+	statusCode = res.StatusCode
+	upstreamResponses.WithLabelValues(service, strconv.Itoa(statusCode)).Inc()
+	if p.AdaptiveLimiter != nil {
+		// Throttling is a degrading origin too, even though it's a 4xx for some services
+		originDegraded = statusCode >= 500
+		if statusCode >= 400 && statusCode < 500 {
+			originErr, parseErr := ParseOriginError(ProtocolForService(proxiedRequest.Service), res)
+			originDegraded = parseErr == nil && originErr.Class == ErrorClassThrottle
+		}
+	}
+
+	// Headers must be set before WriteHeader, otherwise they are dropped
+	removeHopByHopHeaders(res.Header)
 	for key, vals := range res.Header {
 		for _, val := range vals {
 			w.Header().Add(key, val)
 		}
 	}
+	for _, header := range p.StripResponseHeaders {
+		w.Header().Del(header)
+	}
+	for header, val := range p.RewriteResponseHeaders {
+		if w.Header().Get(header) != "" {
+			w.Header().Set(header, val)
+		}
+	}
+	var body io.Writer = w
+	if p.Compression != nil && p.Compression.shouldCompress(r, res) {
+		gz := compressedWriter(w)
+		defer gz.Close()
+		body = gz
+	}
+	w.WriteHeader(res.StatusCode)
 
 	// Stream the response
+	if bodies != nil {
+		bodies.teeResponse(res)
+		defer bodies.write(&proxiedRequest, statusCode)
+	}
 	defer res.Body.Close()
-	if _, err = io.Copy(w, res.Body); err != nil {
+	if responseBytes, err = io.Copy(body, res.Body); err != nil {
+		if ctx.Err() != nil {
+			// The client went away, the deferred close of the body frees the origin connection
+			return fmt.Errorf("client disconnected during response: %w", context.Cause(ctx))
+		}
 		return fmt.Errorf("error in io.Copy of response body: %w", err)
 	}
 
 	return nil
 }
+
+// hopByHopHeaders describe the connection to the origin rather than the response, so aren't forwarded (RFC 9110 7.6.1)
+var hopByHopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization", "Proxy-Connection", "TE", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+// removeHopByHopHeaders removes the hop-by-hop headers, and the headers the Connection header names as such
+func removeHopByHopHeaders(header http.Header) {
+	for _, connection := range header.Values("Connection") {
+		for _, name := range strings.Split(connection, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+}
@@ -17,3 +17,43 @@ type AWSServiceProvider interface {
 	// Returning a *http.Response will stream that response to the client
 	HandleRequest(ctx context.Context, request *ProxiedRequest) (*http.Response, error)
 }
+
+// OperationNameExtractor is implemented by providers that can classify requests as API operations
+type OperationNameExtractor interface {
+	// ExtractOperationName returns the operation (e.g. "GetObject"), or OperationUnknown
+	ExtractOperationName(request *ProxiedRequest) string
+}
+
+// ResourceExtractor is implemented by providers that can tell which resources a request addresses, for policies
+type ResourceExtractor interface {
+	// ExtractResources returns the ARNs of the resources (e.g. arn:aws:s3:::bucket/key), none if the request
+	// doesn't address any (e.g. ListBuckets)
+	ExtractResources(request *ProxiedRequest) ([]string, error)
+}
+
+// ResourceOperationExtractor is implemented by providers whose requests also act on resources as a different
+// operation, e.g. CopyObject writes its destination but reads its source as GetObject
+type ResourceOperationExtractor interface {
+	// ExtractResourceOperations returns the resources authorized as another operation, in addition to (never
+	// instead of) ExtractResources being authorized as the request's operation. A resource may be in both.
+	ExtractResourceOperations(request *ProxiedRequest) ([]ResourceOperation, error)
+}
+
+// ResourceOperation is a resource and the operation it is authorized as, e.g. the source of CopyObject as GetObject
+type ResourceOperation struct {
+	Resource  string `json:"resource"`
+	Operation string `json:"operation"`
+}
+
+// resourceARN is the ARN of a resource in the signed region and the account of the principal, if known
+func resourceARN(service string, request *ProxiedRequest, resource string) string {
+	return "arn:aws:" + service + ":" + request.Region + ":" + request.Principal.Account + ":" + resource
+}
+
+// extractOperationName classifies the request with the provider, if it can
+func extractOperationName(provider AWSServiceProvider, request *ProxiedRequest) string {
+	if extractor, ok := provider.(OperationNameExtractor); ok {
+		return extractor.ExtractOperationName(request)
+	}
+	return OperationUnknown
+}
@@ -2,6 +2,7 @@ package http_server
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"strings"
 )
@@ -9,6 +10,13 @@ import (
 // BaseAWSProvider provides common functionality for all AWS service providers
 type BaseAWSProvider struct {
 	serviceName string
+
+	// OriginHost overrides the default <service>.amazonaws.com origin, and may include a scheme
+	// (e.g. http://localhost:9000 for MinIO)
+	OriginHost string
+	// Endpoints optionally picks the origin per request (e.g. across an S3-compatible cluster),
+	// taking precedence over OriginHost
+	Endpoints EndpointResolver
 }
 
 // NewBaseAWSProvider creates a new base provider for the specified service
@@ -41,6 +49,39 @@ func (p *BaseAWSProvider) CanHandleRequest(request *ProxiedRequest) bool {
 func (p *BaseAWSProvider) HandleRequest(ctx context.Context, request *ProxiedRequest) (*http.Response, error) {
 	// Default behavior: proxy the request to the origin service
 	// Determine the target host based on the service name
-	targetHost := p.serviceName + ".amazonaws.com"
-	return request.DoProxiedRequest(ctx, targetHost)
+	return p.proxyTo(ctx, request, p.serviceName+".amazonaws.com")
+}
+
+// proxyToRegion proxies to the endpoint of the signed region (e.g. sns.us-west-2.amazonaws.com) for regional
+// services, unless Endpoints, the HostLookupFunc of the proxy, or OriginHost pick the origin
+func (p *BaseAWSProvider) proxyToRegion(ctx context.Context, request *ProxiedRequest) (*http.Response, error) {
+	if request.Region != "" {
+		return p.proxyTo(ctx, request, p.serviceName+"."+request.Region+".amazonaws.com")
+	}
+	return p.HandleRequest(ctx, request)
+}
+
+// proxyTo proxies to the origin picked by Endpoints, then the HostLookupFunc of the proxy, then OriginHost,
+// falling back to defaultHost
+func (p *BaseAWSProvider) proxyTo(ctx context.Context, request *ProxiedRequest, defaultHost string) (*http.Response, error) {
+	if p.Endpoints != nil {
+		endpoint, err := p.Endpoints.ResolveEndpoint(ctx, request)
+		if err != nil {
+			return nil, fmt.Errorf("error in ResolveEndpoint: %w", err)
+		}
+		res, err := request.DoProxiedRequest(ctx, endpoint)
+		reportEndpointResult(ctx, p.Endpoints, endpoint, res, err)
+		return res, err
+	}
+	host, err := request.lookupOriginHost(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error in lookupOriginHost: %w", err)
+	}
+	if host == "" {
+		host = defaultHost
+		if p.OriginHost != "" {
+			host = p.OriginHost
+		}
+	}
+	return request.DoProxiedRequest(ctx, host)
 }
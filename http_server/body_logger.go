@@ -0,0 +1,194 @@
+package http_server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+)
+
+// DefaultBodyLogMaxBytes is how much of each body BodyLogger logs by default
+const DefaultBodyLogMaxBytes = 4096
+
+// BodyLogger logs sampled request and response bodies to the debug log, for debugging client integrations.
+// Bodies are truncated and credentials are redacted. It is off unless SampleRate is set or a capture is started
+// with CaptureNext, and the bodies are teed as they stream, so the proxied request is unaffected.
+type BodyLogger struct {
+	// SampleRate logs 1 in SampleRate requests, 0 only logs captures
+	SampleRate uint64
+	// MaxBytes of each body to log, defaults to DefaultBodyLogMaxBytes
+	MaxBytes int
+	// Clock defaults to RealClock
+	Clock Clock
+
+	count   atomic.Uint64
+	mu      sync.Mutex
+	capture *bodyCapture
+}
+
+// BodyCaptureFilter selects requests to capture, empty fields match anything
+type BodyCaptureFilter struct {
+	Service   string `json:"service"`
+	Operation string `json:"operation"`
+	KeyID     string `json:"keyID"`
+}
+
+type bodyCapture struct {
+	filter    BodyCaptureFilter
+	remaining int
+	until     time.Time
+}
+
+// credentialFieldsRe matches credential and secret values in XML, JSON, and form bodies (e.g. STS and IAM
+// credentials, KMS data keys, Secrets Manager secrets), including values cut off by the truncation.
+// JSON strings may contain escaped quotes, e.g. a SecretString holding a JSON document.
+var credentialFieldsRe = regexp.MustCompile(`(?i)(<(?:SecretAccessKey|SessionToken|WebIdentityToken|Signature|Password)>)[^<]*(</|$)` +
+	`|("(?:SecretAccessKey|SessionToken|WebIdentityToken|Signature|Token|Password|OldPassword|NewPassword|Plaintext|PrivateKeyPlaintext|SecretString|SecretBinary)"\s*:\s*")(?:[^"\\]|\\.)*\\?("|$)` +
+	`|((?:^|&)(?:WebIdentityToken|X-Amz-Signature|X-Amz-Security-Token|SerialNumber|TokenCode|Password|OldPassword|NewPassword)=)[^&]*()`)
+
+// CaptureNext logs the next count requests matching filter, regardless of SampleRate, until the duration passes.
+// It replaces any capture in progress.
+func (l *BodyLogger) CaptureNext(count int, filter BodyCaptureFilter, duration time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.capture = &bodyCapture{
+		filter:    filter,
+		remaining: count,
+		until:     clockOrReal(l.Clock).Now().Add(duration),
+	}
+}
+
+// shouldLog decides if the request is sampled or captured
+func (l *BodyLogger) shouldLog(service, operation, keyID string) bool {
+	if l.captured(service, operation, keyID) {
+		return true
+	}
+	return l.SampleRate > 0 && l.count.Add(1)%l.SampleRate == 0
+}
+
+func (l *BodyLogger) captured(service, operation, keyID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c := l.capture
+	if c == nil {
+		return false
+	}
+	if c.remaining <= 0 || clockOrReal(l.Clock).Now().After(c.until) {
+		l.capture = nil
+		return false
+	}
+	if (c.filter.Service != "" && c.filter.Service != service) ||
+		(c.filter.Operation != "" && c.filter.Operation != operation) ||
+		(c.filter.KeyID != "" && c.filter.KeyID != keyID) {
+		return false
+	}
+	c.remaining--
+	return true
+}
+
+func (l *BodyLogger) maxBytes() int {
+	if l.MaxBytes > 0 {
+		return l.MaxBytes
+	}
+	return DefaultBodyLogMaxBytes
+}
+
+// cappedBuffer keeps the first max bytes written to it
+type cappedBuffer struct {
+	buf   bytes.Buffer
+	max   int
+	total int64
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	c.total += int64(len(p))
+	if room := c.max - c.buf.Len(); room > 0 {
+		c.buf.Write(p[:min(room, len(p))])
+	}
+	return len(p), nil
+}
+
+// String is the redacted body, or a placeholder if it isn't text
+func (c *cappedBuffer) String() string {
+	b := c.buf.Bytes()
+	if !utf8.Valid(b) {
+		return "<binary>"
+	}
+	return credentialFieldsRe.ReplaceAllString(string(b), "$1$3$5[REDACTED]$2$4$6")
+}
+
+// loggedBody tees a request or response body into a cappedBuffer
+type loggedBody struct {
+	io.Reader
+	io.Closer
+}
+
+func teeBody(body io.ReadCloser, buf *cappedBuffer) io.ReadCloser {
+	return loggedBody{Reader: io.TeeReader(body, buf), Closer: body}
+}
+
+// bodyLog captures the bodies of a sampled request, logging them with log once the response is streamed
+type bodyLog struct {
+	request  *cappedBuffer
+	response *cappedBuffer
+}
+
+func (l *BodyLogger) start(request *ProxiedRequest, service, operation string) *bodyLog {
+	if !l.shouldLog(service, operation, request.KeyID) {
+		return nil
+	}
+	log := &bodyLog{
+		request:  &cappedBuffer{max: l.maxBytes()},
+		response: &cappedBuffer{max: l.maxBytes()},
+	}
+	if request.PostPolicy == nil {
+		// POST policy forms carry the signature and policy, and are never logged
+		request.Request.Body = teeBody(request.Request.Body, log.request)
+	}
+	return log
+}
+
+func (b *bodyLog) teeResponse(res *http.Response) {
+	res.Body = teeBody(res.Body, b.response)
+}
+
+func (b *bodyLog) write(request *ProxiedRequest, statusCode int) {
+	logger.Debug().
+		Str("service", request.Service).
+		Str("operation", request.Operation).
+		Str("keyID", request.KeyID).
+		Str("method", request.Request.Method).
+		Str("path", request.Request.URL.Path).
+		Int("status", statusCode).
+		Int64("requestBytes", b.request.total).
+		Str("requestBody", b.request.String()).
+		Int64("responseBytes", b.response.total).
+		Str("responseBody", b.response.String()).
+		Msg("sampled bodies")
+}
+
+type BodyCaptureBody struct {
+	BodyCaptureFilter
+	Count   int `json:"count" validate:"required,min=1"`
+	Seconds int `json:"seconds" validate:"required,min=1"`
+}
+
+// StartBodyCapture captures the bodies of the next requests matching the filter to the debug log
+func (s *HTTPServer) StartBodyCapture(c echo.Context) error {
+	if s.bodyLogger == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "body logging is not configured")
+	}
+	var body BodyCaptureBody
+	if err := ValidateRequest(c, &body); err != nil {
+		return err
+	}
+	s.bodyLogger.CaptureNext(body.Count, body.BodyCaptureFilter, time.Duration(body.Seconds)*time.Second)
+	logger.Warn().Int("count", body.Count).Int("seconds", body.Seconds).Msg("capturing request bodies")
+	return c.JSON(http.StatusOK, body)
+}
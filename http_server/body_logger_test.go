@@ -0,0 +1,184 @@
+package http_server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestBodyLoggerTruncatesAndRedacts(t *testing.T) {
+	tests := []struct {
+		name   string
+		max    int
+		writes []string
+		want   string
+	}{
+		{
+			name:   "truncated across writes",
+			max:    8,
+			writes: []string{"hello ", "world", "!"},
+			want:   "hello wo",
+		},
+		{
+			name:   "under the limit",
+			max:    64,
+			writes: []string{`{"TableName":"users"}`},
+			want:   `{"TableName":"users"}`,
+		},
+		{
+			name:   "xml credentials",
+			max:    1024,
+			writes: []string{"<Credentials><AccessKeyId>ASIA</AccessKeyId><SecretAccessKey>s3cr3t</SecretAccessKey><SessionToken>t0k3n</SessionToken></Credentials>"},
+			want:   "<Credentials><AccessKeyId>ASIA</AccessKeyId><SecretAccessKey>[REDACTED]</SecretAccessKey><SessionToken>[REDACTED]</SessionToken></Credentials>",
+		},
+		{
+			name:   "json credentials",
+			max:    1024,
+			writes: []string{`{"AccessKeyId":"ASIA","SecretAccessKey": "s3cr3t","Password":"hunter2"}`},
+			want:   `{"AccessKeyId":"ASIA","SecretAccessKey": "[REDACTED]","Password":"[REDACTED]"}`,
+		},
+		{
+			name:   "form credentials",
+			max:    1024,
+			writes: []string{"Action=AssumeRoleWithWebIdentity&WebIdentityToken=eyJ.jwt&RoleArn=arn"},
+			want:   "Action=AssumeRoleWithWebIdentity&WebIdentityToken=[REDACTED]&RoleArn=arn",
+		},
+		{
+			name:   "xml secret cut off by the truncation",
+			max:    len("<SecretAccessKey>s3c"),
+			writes: []string{"<SecretAccessKey>s3cr3t</SecretAccessKey>"},
+			want:   "<SecretAccessKey>[REDACTED]",
+		},
+		{
+			name:   "json secret cut off by the truncation",
+			max:    len(`{"SessionToken":"t0`),
+			writes: []string{`{"SessionToken":"t0k3n"}`},
+			want:   `{"SessionToken":"[REDACTED]`,
+		},
+		{
+			name:   "kms data key",
+			max:    1024,
+			writes: []string{`{"CiphertextBlob":"AQIDAHg=","KeyId":"arn:aws:kms:us-east-1:111122223333:key/k","Plaintext":"s3cr3tK3y="}`},
+			want:   `{"CiphertextBlob":"AQIDAHg=","KeyId":"arn:aws:kms:us-east-1:111122223333:key/k","Plaintext":"[REDACTED]"}`,
+		},
+		{
+			name:   "secrets manager secret with escaped quotes",
+			max:    1024,
+			writes: []string{`{"Name":"db","SecretString":"{\"user\":\"app\",\"password\":\"s3cr3t\"}","VersionId":"v1"}`},
+			want:   `{"Name":"db","SecretString":"[REDACTED]","VersionId":"v1"}`,
+		},
+		{
+			name:   "secret cut off by the truncation after an escape",
+			max:    len(`{"SecretString":"{\`),
+			writes: []string{`{"SecretString":"{\"password\":\"s3cr3t\"}"}`},
+			want:   `{"SecretString":"[REDACTED]`,
+		},
+		{
+			name:   "form password",
+			max:    1024,
+			writes: []string{"Action=CreateLoginProfile&UserName=bob&Password=s3cr3t"},
+			want:   "Action=CreateLoginProfile&UserName=bob&Password=[REDACTED]",
+		},
+		{
+			name:   "binary",
+			max:    1024,
+			writes: []string{"\xff\xfe\x00\x01"},
+			want:   "<binary>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &cappedBuffer{max: tt.max}
+			for _, write := range tt.writes {
+				if n, err := buf.Write([]byte(write)); n != len(write) || err != nil {
+					t.Fatalf("got %d, %v writing %d bytes", n, err, len(write))
+				}
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if want := int64(len(strings.Join(tt.writes, ""))); buf.total != want {
+				t.Errorf("got total %d, want %d", buf.total, want)
+			}
+			if strings.Contains(buf.String(), "s3c") || strings.Contains(buf.String(), "t0") {
+				t.Errorf("secret logged in %q", buf.String())
+			}
+		})
+	}
+}
+
+// The data key of a sampled KMS GenerateDataKey is never logged, while the rest of the response is
+func TestBodyLoggerRedactsKMSDataKey(t *testing.T) {
+	const plaintext = "bXkgZGF0YSBrZXkgaXMgc2VjcmV0IQ=="
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		fmt.Fprintf(w, `{"CiphertextBlob":"AQIDAHhBbG9uZ0Jsb2I=","KeyId":"arn:aws:kms:us-east-1:111122223333:key/k","Plaintext":%q}`, plaintext)
+	}))
+	defer origin.Close()
+	provider := NewKMSProvider()
+	provider.OriginHost = origin.URL
+	server := httptest.NewServer(&AWSProxy{
+		KeyLookupFunc: func(context.Context, string) (string, error) {
+			return "secret", nil
+		},
+		ServiceLookupFunc: func(context.Context, string) (AWSServiceProvider, error) {
+			return provider, nil
+		},
+		BodyLogger: &BodyLogger{SampleRate: 1},
+	})
+
+	var logged bytes.Buffer
+	defaultLogger := logger
+	logger = zerolog.New(&logged)
+	t.Cleanup(func() { logger = defaultLogger })
+
+	r, _ := http.NewRequest(http.MethodPost, server.URL+"/", strings.NewReader(`{"KeyId":"alias/app","KeySpec":"AES_256"}`))
+	r.Header.Set("X-Amz-Target", "TrentService.GenerateDataKey")
+	r.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	SignRequest(r, "AKIALOGGED", "secret", "us-east-1", "kms", time.Now())
+	res, err := server.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	// Closing waits for the handler, which logs once the response is streamed
+	server.Close()
+
+	// The client still gets its key
+	if !strings.Contains(string(body), plaintext) {
+		t.Fatalf("got response %s", body)
+	}
+	if strings.Contains(logged.String(), plaintext) {
+		t.Fatalf("data key logged in %s", logged.String())
+	}
+	var entry struct {
+		Message      string `json:"message"`
+		Operation    string `json:"operation"`
+		RequestBody  string `json:"requestBody"`
+		ResponseBody string `json:"responseBody"`
+	}
+	for _, line := range strings.Split(strings.TrimSpace(logged.String()), "\n") {
+		if err = json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("got log line %q: %v", line, err)
+		}
+		if entry.Message == "sampled bodies" {
+			break
+		}
+	}
+	if entry.Message != "sampled bodies" || entry.Operation != "GenerateDataKey" || !strings.Contains(entry.RequestBody, "alias/app") {
+		t.Errorf("got log entry %+v", entry)
+	}
+	if !strings.Contains(entry.ResponseBody, `"KeyId":"arn:aws:kms:us-east-1:111122223333:key/k"`) || !strings.Contains(entry.ResponseBody, `"Plaintext":"[REDACTED]"`) {
+		t.Errorf("got logged response %s", entry.ResponseBody)
+	}
+}
@@ -0,0 +1,113 @@
+package http_server
+
+import (
+	"io"
+	"sync"
+)
+
+// bodyTee splits a body into two readers, e.g. the body sent to the origin and a clone a handler inspects.
+// Only what one reader has read and the other hasn't yet is buffered, so readers that keep pace (like a
+// handler hashing the body while it is sent) stream it, while a clone read in full before the body is sent
+// buffers all of it. Closing a reader stops buffering for it, and closing the first (the body sent on)
+// also closes src.
+type bodyTee struct {
+	src io.ReadCloser
+	// srcMu serializes reading src, which is done without holding mu so closing a reader doesn't wait for it
+	srcMu sync.Mutex
+
+	mu sync.Mutex
+	// err is the error of src, returned to each reader once it has read the buffer
+	err error
+	// buf is the body from offset base that the reader behind hasn't read yet
+	buf    []byte
+	base   int64
+	pos    [2]int64
+	closed [2]bool
+}
+
+// newBodyTee returns the two readers of src
+func newBodyTee(src io.ReadCloser) (io.ReadCloser, io.ReadCloser) {
+	t := &bodyTee{src: src}
+	return teeSide{t: t, side: 0}, teeSide{t: t, side: 1}
+}
+
+type teeSide struct {
+	t    *bodyTee
+	side int
+}
+
+func (s teeSide) Read(p []byte) (int, error) {
+	return s.t.read(s.side, p)
+}
+
+func (s teeSide) Close() error {
+	s.t.mu.Lock()
+	s.t.closed[s.side] = true
+	s.t.trim()
+	s.t.mu.Unlock()
+	if s.side == 0 {
+		return s.t.src.Close()
+	}
+	return nil
+}
+
+func (t *bodyTee) read(side int, p []byte) (int, error) {
+	if n, ok, err := t.readBuffered(side, p); ok {
+		return n, err
+	}
+
+	t.srcMu.Lock()
+	defer t.srcMu.Unlock()
+	// The other reader may have read src while this one waited
+	if n, ok, err := t.readBuffered(side, p); ok {
+		return n, err
+	}
+	n, err := t.src.Read(p)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		t.err = err
+	}
+	if n > 0 && !t.closed[1-side] {
+		t.buf = append(t.buf, p[:n]...)
+	}
+	t.pos[side] += int64(n)
+	t.trim()
+	return n, err
+}
+
+// readBuffered catches up on what the other reader already read, ok is false if src needs to be read
+func (t *bodyTee) readBuffered(side int, p []byte) (n int, ok bool, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed[side] {
+		return 0, true, io.ErrClosedPipe
+	}
+	if offset := t.pos[side] - t.base; offset < int64(len(t.buf)) {
+		n = copy(p, t.buf[offset:])
+		t.pos[side] += int64(n)
+		t.trim()
+		return n, true, nil
+	}
+	if t.err != nil {
+		return 0, true, t.err
+	}
+	return 0, false, nil
+}
+
+// trim drops what every open reader has read
+func (t *bodyTee) trim() {
+	end := t.base + int64(len(t.buf))
+	start := end
+	for side, pos := range t.pos {
+		if !t.closed[side] {
+			start = min(start, pos)
+		}
+	}
+	if start == t.base {
+		return
+	}
+	t.buf = t.buf[:copy(t.buf, t.buf[start-t.base:])]
+	t.base = start
+}
@@ -0,0 +1,186 @@
+package http_server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func randomBody(t testing.TB, n int) []byte {
+	body := make([]byte, n)
+	if _, err := rand.Read(body); err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestBodyTeeCloneReadFirst(t *testing.T) {
+	body := randomBody(t, 100_000)
+	outbound, clone := newBodyTee(io.NopCloser(bytes.NewReader(body)))
+
+	cloned, err := io.ReadAll(clone)
+	if err != nil || !bytes.Equal(cloned, body) {
+		t.Fatalf("clone got %d bytes, %v", len(cloned), err)
+	}
+	sent, err := io.ReadAll(outbound)
+	if err != nil || !bytes.Equal(sent, body) {
+		t.Fatalf("outbound got %d bytes, %v", len(sent), err)
+	}
+}
+
+func TestBodyTeeStreamsReadersThatKeepPace(t *testing.T) {
+	const chunk = 4096
+	body := randomBody(t, 1024*1024)
+	outbound, clone := newBodyTee(io.NopCloser(bytes.NewReader(body)))
+	tee := outbound.(teeSide).t
+
+	var sent, cloned bytes.Buffer
+	p := make([]byte, chunk)
+	maxBuffered := 0
+	for {
+		n, err := outbound.Read(p)
+		sent.Write(p[:n])
+		maxBuffered = max(maxBuffered, len(tee.buf))
+		m, _ := io.ReadFull(clone, p[:n])
+		cloned.Write(p[:m])
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(sent.Bytes(), body) || !bytes.Equal(cloned.Bytes(), body) {
+		t.Fatalf("got %d and %d bytes, want %d", sent.Len(), cloned.Len(), len(body))
+	}
+	if maxBuffered > chunk {
+		t.Errorf("buffered up to %d bytes, want at most a read of %d", maxBuffered, chunk)
+	}
+}
+
+func TestBodyTeeConcurrentReaders(t *testing.T) {
+	body := randomBody(t, 1024*1024)
+	outbound, clone := newBodyTee(io.NopCloser(bytes.NewReader(body)))
+
+	cloneHash := make(chan [32]byte)
+	go func() {
+		hash := sha256.New()
+		io.Copy(hash, clone)
+		cloneHash <- [32]byte(hash.Sum(nil))
+	}()
+	sent, err := io.ReadAll(outbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sent, body) {
+		t.Fatalf("outbound got %d bytes", len(sent))
+	}
+	if <-cloneHash != sha256.Sum256(body) {
+		t.Error("clone differs from the body")
+	}
+}
+
+func TestBodyTeeClosedCloneIsNotBuffered(t *testing.T) {
+	body := randomBody(t, 100_000)
+	outbound, clone := newBodyTee(io.NopCloser(bytes.NewReader(body)))
+	tee := outbound.(teeSide).t
+
+	// A handler peeks at the start of the clone, then is done with it
+	if _, err := io.ReadFull(clone, make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	clone.Close()
+
+	sent, err := io.ReadAll(outbound)
+	if err != nil || !bytes.Equal(sent, body) {
+		t.Fatalf("outbound got %d bytes, %v", len(sent), err)
+	}
+	if len(tee.buf) != 0 {
+		t.Errorf("%d bytes still buffered for the closed clone", len(tee.buf))
+	}
+	if _, err = clone.Read(make([]byte, 1)); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("got %v reading the closed clone", err)
+	}
+}
+
+func TestGetClonedBodyPassThrough(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPut, "/bucket/key", nil)
+	original := io.NopCloser(bytes.NewReader([]byte("body")))
+	r.Body = original
+	request := &ProxiedRequest{Request: r}
+
+	// Without a clone the body goes out untouched
+	if request.Request.Body != original {
+		t.Fatal("body was wrapped")
+	}
+
+	clone := request.GetClonedBody()
+	cloned, _ := io.ReadAll(clone)
+	sent, _ := io.ReadAll(request.Request.Body)
+	if string(cloned) != "body" || string(sent) != "body" {
+		t.Errorf("got clone %q and body %q", cloned, sent)
+	}
+}
+
+// BenchmarkProxiedBody compares proxying an upload through an AWSProxy to an origin on its own with proxying it
+// while a handler reads a clone of the body alongside
+func BenchmarkProxiedBody(b *testing.B) {
+	body := randomBody(b, 1024*1024)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer origin.Close()
+
+	for _, cloned := range []bool{false, true} {
+		name := "pass-through"
+		if cloned {
+			name = "cloned"
+		}
+		b.Run(name, func(b *testing.B) {
+			provider := NewS3Provider()
+			provider.OriginHost = origin.URL
+			if cloned {
+				provider.Use(func(next OperationHandler) OperationHandler {
+					return func(ctx context.Context, request *ProxiedRequest) (*http.Response, error) {
+						go io.Copy(io.Discard, request.GetClonedBody())
+						return next(ctx, request)
+					}
+				})
+			}
+			proxy := httptest.NewServer(&AWSProxy{
+				KeyLookupFunc: func(context.Context, string) (string, error) {
+					return "secret", nil
+				},
+				ServiceLookupFunc: func(context.Context, string) (AWSServiceProvider, error) {
+					return provider, nil
+				},
+			})
+			defer proxy.Close()
+			client := proxy.Client()
+
+			b.SetBytes(int64(len(body)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				r, _ := http.NewRequest(http.MethodPut, proxy.URL+"/bucket/key", bytes.NewReader(body))
+				SignRequest(r, "AKIDBENCH", "secret", "us-east-1", "s3", time.Now())
+				res, err := client.Do(r)
+				if err != nil {
+					b.Fatal(err)
+				}
+				io.Copy(io.Discard, res.Body)
+				res.Body.Close()
+				if res.StatusCode != http.StatusOK {
+					b.Fatalf("got status %d", res.StatusCode)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package http_server
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+// Cache stores values for a TTL, for caching handlers and middleware like S3ResponseCache.
+// Use a shared cache (e.g. RedisCache) so a fleet shares hits.
+type Cache interface {
+	// Get returns ErrKeyNotFound if the key is missing or expired
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// MemoryCache is an LRU Cache for a single instance, bounded by the total size of its values
+type MemoryCache struct {
+	// MaxBytes evicts the least recently used values once the total size exceeds it, 0 is unbounded
+	MaxBytes int64
+	// Clock defaults to RealClock
+	Clock Clock
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	size    int64
+}
+
+type memoryCacheEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func NewMemoryCache(maxBytes int64) *MemoryCache {
+	return &MemoryCache{
+		MaxBytes: maxBytes,
+		entries:  map[string]*list.Element{},
+		order:    list.New(),
+	}
+}
+
+func (c *MemoryCache) Get(_ context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	entry := elem.Value.(*memoryCacheEntry)
+	if clockOrReal(c.Clock).Now().After(entry.expires) {
+		c.remove(elem)
+		return nil, ErrKeyNotFound
+	}
+	c.order.MoveToFront(elem)
+	return entry.value, nil
+}
+
+func (c *MemoryCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	if c.MaxBytes > 0 && int64(len(value)) > c.MaxBytes {
+		// It would evict everything else and then itself
+		return nil
+	}
+
+	c.entries[key] = c.order.PushFront(&memoryCacheEntry{
+		key:     key,
+		value:   value,
+		expires: clockOrReal(c.Clock).Now().Add(ttl),
+	})
+	c.size += int64(len(value))
+	for c.MaxBytes > 0 && c.size > c.MaxBytes {
+		c.remove(c.order.Back())
+	}
+	return nil
+}
+
+func (c *MemoryCache) Delete(_ context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	return nil
+}
+
+func (c *MemoryCache) remove(elem *list.Element) {
+	entry := elem.Value.(*memoryCacheEntry)
+	c.order.Remove(elem)
+	delete(c.entries, entry.key)
+	c.size -= int64(len(entry.value))
+}
+
+// RedisCacheClient is the subset of a Redis client needed by RedisCache,
+// wrap your client of choice (e.g. go-redis's Get(...).Bytes()) to satisfy it
+type RedisCacheClient interface {
+	// Get returns ErrKeyNotFound if the key doesn't exist (e.g. on redis.Nil)
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Del(ctx context.Context, key string) error
+}
+
+// RedisCache is a Cache shared across instances through Redis
+type RedisCache struct {
+	Client RedisCacheClient
+	// KeyPrefix namespaces the keys, defaults to "iam:cache:"
+	KeyPrefix string
+}
+
+func (c *RedisCache) key(key string) string {
+	return lo.Ternary(c.KeyPrefix == "", "iam:cache:", c.KeyPrefix) + key
+}
+
+func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := c.Client.Get(ctx, c.key(key))
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error in Get: %w", err)
+	}
+	return value, nil
+}
+
+func (c *RedisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := c.Client.Set(ctx, c.key(key), value, ttl); err != nil {
+		return fmt.Errorf("error in Set: %w", err)
+	}
+	return nil
+}
+
+func (c *RedisCache) Delete(ctx context.Context, key string) error {
+	if err := c.Client.Del(ctx, c.key(key)); err != nil {
+		return fmt.Errorf("error in Del: %w", err)
+	}
+	return nil
+}
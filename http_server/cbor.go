@@ -0,0 +1,216 @@
+package http_server
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/samber/lo"
+)
+
+// A minimal CBOR (RFC 8949) walker, enough to rewrite the string fields of AWS CBOR protocol bodies
+// (e.g. Kinesis) while copying every other item byte for byte
+
+var ErrInvalidCBOR = errors.New("invalid cbor")
+
+const (
+	cborMajorText  = 3
+	cborMajorArray = 4
+	cborMajorMap   = 5
+	cborMajorTag   = 6
+	cborMajorOther = 7
+	cborBreak      = 0xff
+)
+
+// cborHead decodes the head of the item at offset into its major type and argument, and the offset after the head
+func cborHead(data []byte, offset int) (major byte, arg uint64, next int, indefinite bool, err error) {
+	if offset >= len(data) {
+		return 0, 0, 0, false, fmt.Errorf("%w: truncated item", ErrInvalidCBOR)
+	}
+	major, info := data[offset]>>5, data[offset]&0x1f
+	next = offset + 1
+	switch {
+	case info < 24:
+		return major, uint64(info), next, false, nil
+	case info <= 27:
+		n := 1 << (info - 24)
+		if next+n > len(data) {
+			return 0, 0, 0, false, fmt.Errorf("%w: truncated head", ErrInvalidCBOR)
+		}
+		for _, b := range data[next : next+n] {
+			arg = arg<<8 | uint64(b)
+		}
+		return major, arg, next + n, false, nil
+	case info == 31 && major >= 2 && major <= 5:
+		return major, 0, next, true, nil
+	}
+	return 0, 0, 0, false, fmt.Errorf("%w: reserved additional info %d", ErrInvalidCBOR, info)
+}
+
+// cborItemEnd returns the offset just past the item at offset
+func cborItemEnd(data []byte, offset int) (int, error) {
+	major, arg, next, indefinite, err := cborHead(data, offset)
+	if err != nil {
+		return 0, err
+	}
+	if indefinite {
+		// Chunks or items until the break
+		for {
+			if next >= len(data) {
+				return 0, fmt.Errorf("%w: missing break", ErrInvalidCBOR)
+			}
+			if data[next] == cborBreak {
+				return next + 1, nil
+			}
+			if next, err = cborItemEnd(data, next); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	switch major {
+	case 2, cborMajorText:
+		if arg > uint64(len(data)-next) {
+			return 0, fmt.Errorf("%w: truncated string", ErrInvalidCBOR)
+		}
+		return next + int(arg), nil
+	case cborMajorArray, cborMajorMap:
+		if major == cborMajorMap {
+			arg *= 2
+		}
+		// Every item is at least a byte, so a bogus length fails on truncation
+		for i := uint64(0); i < arg; i++ {
+			if next, err = cborItemEnd(data, next); err != nil {
+				return 0, err
+			}
+		}
+		return next, nil
+	case cborMajorTag:
+		return cborItemEnd(data, next)
+	}
+	// Integers, simple values, and floats are all head
+	return next, nil
+}
+
+// cborItems splits the array or map at offset into its head, the bounds of its items (keys and values
+// alternating for maps), and its tail (the break of indefinite length containers)
+func cborItems(data []byte, offset int) (head []byte, items [][2]int, tail []byte, end int, err error) {
+	major, arg, next, indefinite, err := cborHead(data, offset)
+	if err != nil {
+		return nil, nil, nil, 0, err
+	}
+	if major != cborMajorArray && major != cborMajorMap {
+		return nil, nil, nil, 0, fmt.Errorf("%w: expected array or map, got major type %d", ErrInvalidCBOR, major)
+	}
+	head = data[offset:next]
+	if major == cborMajorMap {
+		arg *= 2
+	}
+	for i := uint64(0); indefinite || i < arg; i++ {
+		if indefinite && next < len(data) && data[next] == cborBreak {
+			return head, items, data[next : next+1], next + 1, nil
+		}
+		itemEnd, err := cborItemEnd(data, next)
+		if err != nil {
+			return nil, nil, nil, 0, err
+		}
+		items = append(items, [2]int{next, itemEnd})
+		next = itemEnd
+	}
+	return head, items, nil, next, nil
+}
+
+// cborText decodes an item that is a definite length text string
+func cborText(item []byte) (string, bool) {
+	major, arg, next, indefinite, err := cborHead(item, 0)
+	if err != nil || major != cborMajorText || indefinite || uint64(len(item)-next) != arg {
+		return "", false
+	}
+	return string(item[next:]), true
+}
+
+func appendCBORHead(dst []byte, major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return append(dst, major<<5|byte(arg))
+	case arg <= 0xff:
+		return append(dst, major<<5|24, byte(arg))
+	case arg <= 0xffff:
+		return append(dst, major<<5|25, byte(arg>>8), byte(arg))
+	case arg <= 0xffffffff:
+		return append(dst, major<<5|26, byte(arg>>24), byte(arg>>16), byte(arg>>8), byte(arg))
+	}
+	return append(dst, major<<5|27, byte(arg>>56), byte(arg>>48), byte(arg>>40), byte(arg>>32),
+		byte(arg>>24), byte(arg>>16), byte(arg>>8), byte(arg))
+}
+
+func appendCBORText(dst []byte, s string) []byte {
+	return append(appendCBORHead(dst, cborMajorText, uint64(len(s))), s...)
+}
+
+// rewriteCBORStrings passes the text string fields of a CBOR map through rewrite, and those of the maps in
+// the array fields named by nested (e.g. Kinesis Records). Everything else is copied as-is.
+func rewriteCBORStrings(data []byte, rewrite func(field, value string) string, nested ...string) ([]byte, error) {
+	out, end, err := rewriteCBORMap(nil, data, 0, rewrite, nested)
+	if err != nil {
+		return nil, err
+	}
+	if end != len(data) {
+		return nil, fmt.Errorf("%w: trailing bytes", ErrInvalidCBOR)
+	}
+	return out, nil
+}
+
+func rewriteCBORMap(out, data []byte, offset int, rewrite func(field, value string) string, nested []string) ([]byte, int, error) {
+	if offset < len(data) && data[offset]>>5 != cborMajorMap {
+		return nil, 0, fmt.Errorf("%w: expected map", ErrInvalidCBOR)
+	}
+	head, items, tail, end, err := cborItems(data, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	out = append(out, head...)
+	for i := 0; i+1 < len(items); i += 2 {
+		key := data[items[i][0]:items[i][1]]
+		value := data[items[i+1][0]:items[i+1][1]]
+		out = append(out, key...)
+
+		field, ok := cborText(key)
+		if !ok {
+			out = append(out, value...)
+			continue
+		}
+		if s, ok := cborText(value); ok {
+			if rewritten := rewrite(field, s); rewritten != s {
+				out = appendCBORText(out, rewritten)
+				continue
+			}
+		} else if value[0]>>5 == cborMajorArray && lo.Contains(nested, field) {
+			if out, err = rewriteCBORMaps(out, data, items[i+1][0], rewrite); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		out = append(out, value...)
+	}
+	return append(out, tail...), end, nil
+}
+
+// rewriteCBORMaps rewrites the maps of the array at offset, without descending further
+func rewriteCBORMaps(out, data []byte, offset int, rewrite func(field, value string) string) ([]byte, error) {
+	head, items, tail, _, err := cborItems(data, offset)
+	if err != nil {
+		return nil, err
+	}
+	out = append(out, head...)
+	for _, item := range items {
+		if data[item[0]]>>5 != cborMajorMap {
+			out = append(out, data[item[0]:item[1]]...)
+			continue
+		}
+		if out, _, err = rewriteCBORMap(out, data, item[0], rewrite, nil); err != nil {
+			return nil, err
+		}
+	}
+	return append(out, tail...), nil
+}
@@ -0,0 +1,265 @@
+package http_server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// cborBytes builds CBOR from hex parts, and 'quoted' parts that are the raw bytes of a string (without its head)
+func cborBytes(t *testing.T, parts ...string) []byte {
+	t.Helper()
+	var out []byte
+	for _, part := range parts {
+		if strings.HasPrefix(part, "'") {
+			out = append(out, strings.Trim(part, "'")...)
+			continue
+		}
+		b, err := hex.DecodeString(strings.ReplaceAll(part, " ", ""))
+		if err != nil {
+			t.Fatalf("bad hex %q", part)
+		}
+		out = append(out, b...)
+	}
+	return out
+}
+
+// The examples of RFC 8949 appendix A, each of which is a single item
+func TestCBORItemEndRFC8949(t *testing.T) {
+	examples := []string{
+		"00", "17", "1818", "1903e8", "1a000f4240", "1b000000e8d4a51000", "1bffffffffffffffff",
+		"c249010000000000000000", "3bffffffffffffffff", "20", "3903e7",
+		"f90000", "f93c00", "f97bff", "fa47c35000", "fb3ff199999999999a", "f97c00", "f97e00", "fa7f800000",
+		"f4", "f5", "f6", "f7", "f0", "f8ff",
+		"c074323031332d30332d32315432303a30343a30305a", "c11a514b67b0", "c1fb41d452d9ec200000",
+		"d74401020304", "d818456449455446", "d82076687474703a2f2f7777772e6578616d706c652e636f6d",
+		"40", "4401020304", "60", "6161", "6449455446", "62225c", "62c3bc", "63e6b0b4", "64f0908591",
+		"80", "83010203", "8301820203820405",
+		"98190102030405060708090a0b0c0d0e0f101112131415161718181819",
+		"a0", "a201020304", "a26161016162820203", "826161a161626163",
+		"a56161614161626142616361436164614461656145",
+		"5f42010243030405ff", "7f657374726561646d696e67ff", "9fff", "9f018202039f0405ffff",
+		"9f01820203820405ff", "83018202039f0405ff", "83019f0203ff820405",
+		"9f0102030405060708090a0b0c0d0e0f101112131415161718181819ff",
+		"bf61610161629f0203ffff", "826161bf61626163ff", "bf6346756ef563416d7421ff",
+	}
+	for _, example := range examples {
+		data, _ := hex.DecodeString(example)
+		if end, err := cborItemEnd(data, 0); err != nil || end != len(data) {
+			t.Errorf("%s: got end %d, %v, want %d", example, end, err, len(data))
+		}
+	}
+}
+
+func TestCBORItemEndInvalid(t *testing.T) {
+	for _, example := range []string{
+		"",
+		"18",         // truncated argument
+		"1903",       // truncated argument
+		"1c",         // reserved additional info
+		"1f",         // indefinite length integer
+		"6261",       // truncated text
+		"5affffffff", // byte string longer than the data
+		"830102",     // truncated array
+		"a101",       // map missing a value
+		"9f01",       // missing break
+		"7f6161",     // missing break
+		"c1",         // tag without an item
+	} {
+		data, _ := hex.DecodeString(example)
+		if _, err := cborItemEnd(data, 0); !errors.Is(err, ErrInvalidCBOR) {
+			t.Errorf("%q: got %v, want ErrInvalidCBOR", example, err)
+		}
+	}
+}
+
+// Kinesis bodies as the Java SDK and KPL (Jackson CBOR) send them, with indefinite length maps and arrays,
+// and Data as a byte string
+func kinesisPutRecordsCBOR(t *testing.T, partitionKey1, partitionKey2, streamName []string) []byte {
+	t.Helper()
+	var parts []string
+	parts = append(parts,
+		"bf", // map(*)
+		"67", "'Records'",
+		"9f", // array(*)
+		"bf",
+		"64", "'Data'", "45", "'hello'",
+		"6c", "'PartitionKey'")
+	parts = append(parts, partitionKey1...)
+	parts = append(parts,
+		"ff",
+		"bf",
+		"64", "'Data'", "43", "010203",
+		"6c", "'PartitionKey'")
+	parts = append(parts, partitionKey2...)
+	parts = append(parts,
+		"6f", "'ExplicitHashKey'", "64", "'1234'",
+		"ff",
+		"ff",
+		"6a", "'StreamName'")
+	parts = append(parts, streamName...)
+	return cborBytes(t, append(parts, "ff")...)
+}
+
+func TestRewriteCBORStringsKinesis(t *testing.T) {
+	putRecords := kinesisPutRecordsCBOR(t, []string{"66", "'user-1'"}, []string{"66", "'user-2'"}, []string{"66", "'orders'"})
+	// PutRecord, with definite lengths
+	putRecord := cborBytes(t,
+		"a4", // map(4)
+		"64", "'Data'", "58 18", strings.Repeat("ab", 24),
+		"6c", "'PartitionKey'", "66", "'user-1'",
+		"78 19", "'SequenceNumberForOrdering'", "62", "'49'",
+		"69", "'StreamARN'", "78 34", "'arn:aws:kinesis:us-east-1:123456789012:stream/orders'",
+	)
+	// GetShardIterator at a timestamp, which is an epoch float tagged as a date
+	getShardIterator := cborBytes(t,
+		"bf",
+		"67", "'ShardId'", "74", "'shardId-000000000000'",
+		"71", "'ShardIteratorType'", "6c", "'AT_TIMESTAMP'",
+		"6a", "'StreamName'", "66", "'orders'",
+		"69", "'Timestamp'", "c1 fb 41d65a0bc0000000",
+		"ff",
+	)
+
+	tenantKeys := func(field, value string) string {
+		if field == "PartitionKey" {
+			return "tenant-0123456789/" + value
+		}
+		return value
+	}
+	tenantStreams := func(field, value string) string {
+		switch field {
+		case "StreamName":
+			return "tenant-a-" + value
+		case "StreamARN":
+			return renameKinesisStreamARN(value, func(stream string) string { return "tenant-a-" + stream })
+		}
+		return value
+	}
+
+	tests := []struct {
+		name    string
+		body    []byte
+		rewrite func(field, value string) string
+		want    []byte
+	}{
+		{
+			name:    "PutRecords partition keys",
+			body:    putRecords,
+			rewrite: tenantKeys,
+			// The keys grow past 23 bytes, so their heads grow a byte
+			want: kinesisPutRecordsCBOR(t,
+				[]string{"78 18", "'tenant-0123456789/user-1'"},
+				[]string{"78 18", "'tenant-0123456789/user-2'"},
+				[]string{"66", "'orders'"}),
+		},
+		{
+			name:    "PutRecords stream name",
+			body:    putRecords,
+			rewrite: tenantStreams,
+			want:    kinesisPutRecordsCBOR(t, []string{"66", "'user-1'"}, []string{"66", "'user-2'"}, []string{"6f", "'tenant-a-orders'"}),
+		},
+		{
+			name:    "PutRecord stream ARN",
+			body:    putRecord,
+			rewrite: tenantStreams,
+			want: cborBytes(t,
+				"a4",
+				"64", "'Data'", "58 18", strings.Repeat("ab", 24),
+				"6c", "'PartitionKey'", "66", "'user-1'",
+				"78 19", "'SequenceNumberForOrdering'", "62", "'49'",
+				"69", "'StreamARN'", "78 3d", "'arn:aws:kinesis:us-east-1:123456789012:stream/tenant-a-orders'",
+			),
+		},
+		{
+			name:    "GetShardIterator keeps the timestamp",
+			body:    getShardIterator,
+			rewrite: tenantStreams,
+			want: cborBytes(t,
+				"bf",
+				"67", "'ShardId'", "74", "'shardId-000000000000'",
+				"71", "'ShardIteratorType'", "6c", "'AT_TIMESTAMP'",
+				"6a", "'StreamName'", "6f", "'tenant-a-orders'",
+				"69", "'Timestamp'", "c1 fb 41d65a0bc0000000",
+				"ff",
+			),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rewriteCBORStrings(tt.body, tt.rewrite, "Records")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Fatalf("got  %x\nwant %x", got, tt.want)
+			}
+
+			// Every field is visited, and leaving them as they are is a byte for byte round trip
+			var fields []string
+			same, err := rewriteCBORStrings(tt.body, func(field, value string) string {
+				fields = append(fields, field)
+				return value
+			}, "Records")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(same, tt.body) {
+				t.Fatalf("identity rewrite changed the body\ngot  %x\nwant %x", same, tt.body)
+			}
+			if len(fields) == 0 {
+				t.Fatal("no fields visited")
+			}
+
+			// The rewritten body is itself well formed
+			if end, err := cborItemEnd(got, 0); err != nil || end != len(got) {
+				t.Fatalf("rewritten body is malformed: end %d, %v", end, err)
+			}
+		})
+	}
+}
+
+func TestRewriteCBORStringsVisitsRecordFields(t *testing.T) {
+	body := kinesisPutRecordsCBOR(t, []string{"66", "'user-1'"}, []string{"66", "'user-2'"}, []string{"66", "'orders'"})
+	var fields []string
+	if _, err := rewriteCBORStrings(body, func(field, value string) string {
+		fields = append(fields, field+"="+value)
+		return value
+	}, "Records"); err != nil {
+		t.Fatal(err)
+	}
+	// Data is a byte string, so it isn't passed to rewrite
+	want := []string{"PartitionKey=user-1", "PartitionKey=user-2", "ExplicitHashKey=1234", "StreamName=orders"}
+	if strings.Join(fields, ",") != strings.Join(want, ",") {
+		t.Errorf("visited %v, want %v", fields, want)
+	}
+
+	// Records is only descended into when asked
+	fields = nil
+	if _, err := rewriteCBORStrings(body, func(field, value string) string {
+		fields = append(fields, field)
+		return value
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(fields, ",") != "StreamName" {
+		t.Errorf("visited %v without nested fields", fields)
+	}
+}
+
+func TestRewriteCBORStringsInvalid(t *testing.T) {
+	for name, body := range map[string][]byte{
+		"empty":          {},
+		"not a map":      cborBytes(t, "83010203"),
+		"trailing bytes": cborBytes(t, "a0", "00"),
+		"truncated":      cborBytes(t, "a1", "6a", "'StreamName'", "66", "'ord'"),
+		"missing break":  cborBytes(t, "bf", "6a", "'StreamName'", "66", "'orders'"),
+		"bad record":     cborBytes(t, "a1", "67", "'Records'", "81", "a1", "6c", "'PartitionKey'"),
+	} {
+		if _, err := rewriteCBORStrings(body, func(_, value string) string { return value }, "Records"); !errors.Is(err, ErrInvalidCBOR) {
+			t.Errorf("%s: got %v, want ErrInvalidCBOR", name, err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package http_server_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// The origin request is cancelled once the client goes away, whether it was waiting for the response or
+// in the middle of reading it
+func TestClientDisconnectCancelsOrigin(t *testing.T) {
+	for _, midStream := range []bool{false, true} {
+		name := "before response"
+		if midStream {
+			name = "mid-stream"
+		}
+		t.Run(name, func(t *testing.T) {
+			h := newS3Harness(t)
+			cancelled := make(chan struct{})
+			h.Origin.Handle(func(w http.ResponseWriter, r *http.Request) {
+				if midStream {
+					w.Write(make([]byte, 64*1024))
+					w.(http.Flusher).Flush()
+				}
+				select {
+				case <-r.Context().Done():
+					close(cancelled)
+				case <-time.After(10 * time.Second):
+				}
+			})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			r := h.NewSignedRequest(http.MethodGet, "/bucket/key", nil).WithContext(ctx)
+			if midStream {
+				res, err := h.Do(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err = io.ReadFull(res.Body, make([]byte, 1024)); err != nil {
+					t.Fatal(err)
+				}
+				cancel()
+				res.Body.Close()
+			} else {
+				go h.Do(r)
+				time.Sleep(100 * time.Millisecond)
+				cancel()
+			}
+
+			select {
+			case <-cancelled:
+			case <-time.After(5 * time.Second):
+				t.Fatal("origin request wasn't cancelled after the client disconnected")
+			}
+		})
+	}
+}
+
+// An origin that breaks off a stream fails the client's response, rather than an error being appended to it
+func TestOriginDisconnectMidStreamAbortsResponse(t *testing.T) {
+	h := newS3Harness(t)
+	h.Origin.Handle(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, firstHalf)
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	})
+
+	res, err := h.Do(h.NewSignedRequest(http.MethodGet, "/bucket/key", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err == nil {
+		t.Error("response was complete after the origin broke off")
+	}
+	if string(body) != firstHalf {
+		t.Errorf("got %d bytes ending in %q, want only what the origin sent", len(body), body[max(0, len(body)-100):])
+	}
+}
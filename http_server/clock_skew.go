@@ -0,0 +1,30 @@
+package http_server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// DefaultMaxClockSkew is how far the X-Amz-Date of a request may be from the proxy's clock, like AWS
+const DefaultMaxClockSkew = 15 * time.Minute
+
+var ErrAWSRequestTimeTooSkewed = NewAWSError(http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the current time is too large.")
+
+// checkClockSkew rejects requests signed further than maxSkew from now, so a captured request can't be
+// replayed indefinitely. Presigned URLs are valid until they expire, so only a future X-Amz-Date is rejected.
+func checkClockSkew(r *http.Request, now time.Time, maxSkew time.Duration) error {
+	signedAt, err := time.Parse("20060102T150405Z", amzDate(r))
+	if err != nil {
+		return fmt.Errorf("malformed X-Amz-Date: %w", ErrAWSRequestTimeTooSkewed)
+	}
+
+	skew := now.Sub(signedAt)
+	if isPresignedRequest(r) {
+		skew = min(skew, 0)
+	}
+	if skew > maxSkew || skew < -maxSkew {
+		return fmt.Errorf("X-Amz-Date %s is %s from %s: %w", signedAt.Format(time.RFC3339), skew, now.UTC().Format(time.RFC3339), ErrAWSRequestTimeTooSkewed)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package http_server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckClockSkew(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	signed := func(date string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/bucket/key", nil)
+		r.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=x")
+		if date != "" {
+			r.Header.Set("X-Amz-Date", date)
+		}
+		return r
+	}
+	presigned := func(date string) *http.Request {
+		return httptest.NewRequest(http.MethodGet, "/bucket/key?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Signature=x&X-Amz-Date="+date, nil)
+	}
+
+	tests := []struct {
+		name    string
+		request *http.Request
+		skewed  bool
+	}{
+		{"now", signed("20240501T120000Z"), false},
+		{"within the window", signed("20240501T114600Z"), false},
+		{"too old", signed("20240501T114400Z"), true},
+		{"too far in the future", signed("20240501T121600Z"), true},
+		{"missing", signed(""), true},
+		{"malformed", signed("2024-05-01"), true},
+		{"old presigned URL", presigned("20240401T120000Z"), false},
+		{"future presigned URL", presigned("20240501T121600Z"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkClockSkew(tt.request, now, DefaultMaxClockSkew)
+			if !tt.skewed {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+			// Every rejection must be the AWS error, anything else would be a 500
+			var awsErr *AWSError
+			if !errors.As(err, &awsErr) || awsErr != ErrAWSRequestTimeTooSkewed {
+				t.Fatalf("got %v, want ErrAWSRequestTimeTooSkewed", err)
+			}
+			if awsErr.StatusCode != http.StatusForbidden {
+				t.Errorf("got status %d", awsErr.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package http_server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danthegoodman1/IAMTheService/utils"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+)
+
+// The verification and issuing below happen years after time.Now() would allow
+var clockTestTime = time.Date(2013, 5, 24, 0, 0, 0, 0, time.UTC)
+
+func TestVerifyAWSRequestMiddlewareClock(t *testing.T) {
+	e := echo.New()
+	handler := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+
+	verify := func(clock Clock) error {
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/bucket/key", nil)
+		SignRequest(r, "AKIDEXAMPLE", "test_secret", "us-east-1", "s3", clockTestTime)
+		c := &CustomContext{Context: e.NewContext(r, httptest.NewRecorder())}
+		return verifyAWSRequestMiddleware(clock)(handler)(c)
+	}
+
+	if err := verify(NewFakeClock(clockTestTime.Add(time.Minute))); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := verify(NewFakeClock(clockTestTime.Add(time.Hour))); err != ErrRequestTimeTooSkewed {
+		t.Fatalf("got %v, want ErrRequestTimeTooSkewed", err)
+	}
+}
+
+func TestCreateKeyClock(t *testing.T) {
+	s := &HTTPServer{credentials: NewMemoryCredentialStore(nil), clock: NewFakeClock(clockTestTime)}
+	e := echo.New()
+	e.Validator = &CustomValidator{validator: validator.New()}
+
+	req := httptest.NewRequest(http.MethodPost, "/.internal/keys", strings.NewReader(`{"tenantID":"t1"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	if err := s.CreateKey(e.NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	var issued IssuedKey
+	if err := json.Unmarshal(rec.Body.Bytes(), &issued); err != nil {
+		t.Fatal(err)
+	}
+	if !issued.Metadata.CreatedAt.Equal(clockTestTime) {
+		t.Errorf("got CreatedAt %s, want %s", issued.Metadata.CreatedAt, clockTestTime)
+	}
+}
+
+func TestLoadOrGenerateTLSCertClock(t *testing.T) {
+	cert, key := utils.TLSCert, utils.TLSKey
+	t.Cleanup(func() { utils.TLSCert, utils.TLSKey = cert, key })
+	utils.TLSCert = filepath.Join(t.TempDir(), "cert.pem")
+	utils.TLSKey = filepath.Join(t.TempDir(), "key.pem")
+
+	tlsCert, err := loadOrGenerateTLSCert(clockTestTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tlsCert.Leaf.NotBefore.Equal(clockTestTime) {
+		t.Errorf("got NotBefore %s, want %s", tlsCert.Leaf.NotBefore, clockTestTime)
+	}
+	if want := clockTestTime.Add(180 * 24 * time.Hour); !tlsCert.Leaf.NotAfter.Equal(want) {
+		t.Errorf("got NotAfter %s, want %s", tlsCert.Leaf.NotAfter, want)
+	}
+}
+
+type durationRecorder struct {
+	PrometheusOperationRecorder
+	duration time.Duration
+}
+
+func (r *durationRecorder) HandlerInvoked(_, _ string, _ bool, duration time.Duration, _ bool) {
+	r.duration = duration
+}
+
+func TestOperationRouterClock(t *testing.T) {
+	clock := NewFakeClock(clockTestTime)
+	recorder := &durationRecorder{}
+	router := &OperationRouter{Recorder: recorder}
+	router.RegisterOperationHandler("GetObject", func(context.Context, *ProxiedRequest) (*http.Response, error) {
+		clock.Advance(3 * time.Second)
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	})
+
+	request := &ProxiedRequest{Service: "s3", clock: clock}
+	if _, err := router.dispatch(context.Background(), "GetObject", request, nil); err != nil {
+		t.Fatal(err)
+	}
+	if recorder.duration != 3*time.Second {
+		t.Errorf("got duration %s, want 3s", recorder.duration)
+	}
+}
@@ -0,0 +1,142 @@
+package http_server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// RequestCoalescer collapses identical concurrent GetObject requests into a single origin fetch, whose
+// response is fanned out to every waiter. Install it with S3Provider.Use(coalescer.Middleware).
+//
+// Only responses with a known Content-Length up to MaxBodyBytes are shared, since the body has to be
+// buffered for the waiters. Otherwise, (or if the shared fetch fails) waiters make their own request.
+type RequestCoalescer struct {
+	// MaxBodyBytes defaults to DefaultCoalesceMaxBodyBytes
+	MaxBodyBytes int64
+
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+type coalescedCall struct {
+	done chan struct{}
+	// res is nil if the response could not be shared
+	res *bufferedResponse
+}
+
+type bufferedResponse struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+}
+
+func (b *bufferedResponse) toResponse() *http.Response {
+	return &http.Response{
+		StatusCode:    b.statusCode,
+		Header:        b.header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(b.body)),
+		ContentLength: int64(len(b.body)),
+	}
+}
+
+// DefaultCoalesceMaxBodyBytes is the default MaxBodyBytes of RequestCoalescer, enough for the small,
+// hot objects (configs, manifests) that get requested concurrently
+const DefaultCoalesceMaxBodyBytes = 1024 * 1024
+
+// NewRequestCoalescer creates a RequestCoalescer sharing bodies up to maxBodyBytes, 0 for DefaultCoalesceMaxBodyBytes
+func NewRequestCoalescer(maxBodyBytes int64) *RequestCoalescer {
+	return &RequestCoalescer{
+		MaxBodyBytes: maxBodyBytes,
+		calls:        map[string]*coalescedCall{},
+	}
+}
+
+// Middleware coalesces GetObject requests, other operations pass straight through
+func (c *RequestCoalescer) Middleware(next OperationHandler) OperationHandler {
+	return func(ctx context.Context, request *ProxiedRequest) (*http.Response, error) {
+		if request.Operation != "GetObject" {
+			return next(ctx, request)
+		}
+
+		key := coalesceKey(request)
+		c.mu.Lock()
+		if c.calls == nil {
+			c.calls = map[string]*coalescedCall{}
+		}
+		if call, ok := c.calls[key]; ok {
+			c.mu.Unlock()
+			select {
+			case <-call.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			request.RecordCacheResult(call.res != nil)
+			if call.res != nil {
+				return call.res.toResponse(), nil
+			}
+			return next(ctx, request)
+		}
+		request.RecordCacheResult(false)
+		call := &coalescedCall{done: make(chan struct{})}
+		c.calls[key] = call
+		c.mu.Unlock()
+
+		defer func() {
+			c.mu.Lock()
+			delete(c.calls, key)
+			c.mu.Unlock()
+			close(call.done)
+		}()
+
+		res, err := next(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		maxBodyBytes := c.maxBodyBytes()
+		if res.ContentLength < 0 || res.ContentLength > maxBodyBytes {
+			// Too big (or unknown) to buffer, waiters will make their own request
+			return res, nil
+		}
+
+		defer res.Body.Close()
+		body, err := io.ReadAll(io.LimitReader(res.Body, maxBodyBytes))
+		if err != nil {
+			return nil, fmt.Errorf("error reading coalesced response body: %w", err)
+		}
+		call.res = &bufferedResponse{
+			statusCode: res.StatusCode,
+			header:     res.Header.Clone(),
+			body:       body,
+		}
+		return call.res.toResponse(), nil
+	}
+}
+
+func (c *RequestCoalescer) maxBodyBytes() int64 {
+	if c.MaxBodyBytes == 0 {
+		return DefaultCoalesceMaxBodyBytes
+	}
+	return c.MaxBodyBytes
+}
+
+// coalesceKey identifies identical requests. The key id is included since the origin authorizes
+// each key separately, so a response must never be shared across keys.
+func coalesceKey(request *ProxiedRequest) string {
+	s3Req := ParseS3Request(request)
+	return strings.Join([]string{
+		request.KeyID,
+		s3Req.Bucket,
+		s3Req.Key,
+		request.Request.URL.RawQuery,
+		request.Request.Header.Get("Range"),
+		request.Request.Header.Get("If-Match"),
+		request.Request.Header.Get("If-None-Match"),
+		request.Request.Header.Get("If-Modified-Since"),
+		request.Request.Header.Get("If-Unmodified-Since"),
+	}, "\x00")
+}
@@ -0,0 +1,109 @@
+package http_server_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+func newCoalescingHarness(t *testing.T, coalescer *http_server.RequestCoalescer) *iamtest.Harness {
+	t.Helper()
+	h := iamtest.NewHarness(func(originURL string) http_server.AWSServiceProvider {
+		p := http_server.NewS3Provider()
+		p.OriginHost = originURL
+		p.Use(coalescer.Middleware)
+		return p
+	})
+	t.Cleanup(h.Close)
+	return h
+}
+
+// getConcurrently sends n identical GetObjects once the origin is holding the first, returning the bodies
+func getConcurrently(t *testing.T, h *iamtest.Harness, n int, path string, release chan struct{}) [][]byte {
+	t.Helper()
+	bodies := make([][]byte, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, err := h.Do(h.NewSignedRequest(http.MethodGet, path, nil))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer res.Body.Close()
+			bodies[i], _ = io.ReadAll(res.Body)
+		}(i)
+	}
+	// Let every request reach the coalescer before the origin responds
+	time.Sleep(200 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	return bodies
+}
+
+func TestRequestCoalescerSharesOneOriginFetch(t *testing.T) {
+	h := newCoalescingHarness(t, http_server.NewRequestCoalescer(0))
+	release := make(chan struct{})
+	object := bytes.Repeat([]byte("object"), 100)
+	h.Origin.Handle(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write(object)
+	})
+
+	bodies := getConcurrently(t, h, 5, "/bucket/key", release)
+	for i, body := range bodies {
+		if !bytes.Equal(body, object) {
+			t.Errorf("request %d got %d bytes", i, len(body))
+		}
+	}
+	if n := len(h.Origin.Requests()); n != 1 {
+		t.Errorf("origin received %d requests, want 1", n)
+	}
+}
+
+func TestRequestCoalescerDoesNotShareLargeBodies(t *testing.T) {
+	h := newCoalescingHarness(t, &http_server.RequestCoalescer{MaxBodyBytes: 10})
+	release := make(chan struct{})
+	h.Origin.Handle(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte("more than ten bytes"))
+	})
+
+	bodies := getConcurrently(t, h, 3, "/bucket/key", release)
+	for i, body := range bodies {
+		if string(body) != "more than ten bytes" {
+			t.Errorf("request %d got %q", i, body)
+		}
+	}
+	// The first fetch couldn't be shared, so the waiters made their own
+	if n := len(h.Origin.Requests()); n != 3 {
+		t.Errorf("origin received %d requests, want 3", n)
+	}
+}
+
+func TestRequestCoalescerKeepsDifferentObjectsApart(t *testing.T) {
+	h := newCoalescingHarness(t, http_server.NewRequestCoalescer(0))
+	h.Origin.Handle(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	})
+
+	for _, path := range []string{"/bucket/a", "/bucket/b"} {
+		res, err := h.Do(h.NewSignedRequest(http.MethodGet, path, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if string(body) != path {
+			t.Errorf("got %q for %s", body, path)
+		}
+	}
+}
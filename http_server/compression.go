@@ -0,0 +1,70 @@
+package http_server
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+// DefaultCompressibleContentTypes are the payloads of S3 listings and JSON protocol services
+var DefaultCompressibleContentTypes = []string{"application/xml", "text/xml", "application/json", "application/x-amz-json-1.0", "application/x-amz-json-1.1"}
+
+// ResponseCompression gzips uncompressed origin responses to clients that accept it,
+// for large listings and scans going to bandwidth constrained clients
+type ResponseCompression struct {
+	// ContentTypes that are compressed, defaults to DefaultCompressibleContentTypes
+	ContentTypes []string
+	// MinBytes is the smallest response to compress, defaults to 1024. Responses of unknown length are compressed.
+	MinBytes int64
+}
+
+// shouldCompress is whether the response to r should be gzipped
+func (c *ResponseCompression) shouldCompress(r *http.Request, res *http.Response) bool {
+	if r.Method == http.MethodHead || res.StatusCode == http.StatusNoContent || res.StatusCode == http.StatusNotModified {
+		return false
+	}
+	// Content-Range offsets are into the uncompressed object, so ranged responses must be forwarded untouched
+	if res.StatusCode == http.StatusPartialContent || res.Header.Get("Content-Range") != "" {
+		return false
+	}
+	// Already encoded responses (including aws-chunked) are never re-encoded, encodings aren't combined
+	if len(res.Header.Values("Content-Encoding")) > 0 || !acceptsGzip(r) {
+		return false
+	}
+	if isAWSChunked(r) {
+		// Streaming uploads are forwarded verbatim, and so is the response to them
+		return false
+	}
+
+	minBytes := lo.Ternary(c.MinBytes > 0, c.MinBytes, 1024)
+	if res.ContentLength >= 0 && res.ContentLength < minBytes {
+		return false
+	}
+
+	contentTypes := lo.Ternary(len(c.ContentTypes) > 0, c.ContentTypes, DefaultCompressibleContentTypes)
+	contentType, _, _ := strings.Cut(res.Header.Get("Content-Type"), ";")
+	return lo.Contains(contentTypes, strings.TrimSpace(strings.ToLower(contentType)))
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, encoding := range strings.Split(value, ",") {
+			encoding, params, _ := strings.Cut(strings.TrimSpace(encoding), ";")
+			if strings.EqualFold(encoding, "gzip") && strings.ReplaceAll(params, " ", "") != "q=0" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compressedWriter sets the gzip headers (which must be done before WriteHeader) and returns the writer
+// to copy the body to, which must be closed
+func compressedWriter(w http.ResponseWriter) *gzip.Writer {
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Del("Content-Length")
+	w.Header().Add("Vary", "Accept-Encoding")
+	return gzip.NewWriter(w)
+}
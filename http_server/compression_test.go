@@ -0,0 +1,103 @@
+package http_server_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+)
+
+func gzipBytes(t *testing.T, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestResponseCompression(t *testing.T) {
+	listing := bytes.Repeat([]byte("<Contents><Key>key</Key></Contents>"), 100)
+	compressed := gzipBytes(t, listing)
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		originHeader   http.Header
+		originBody     []byte
+		wantEncoding   string
+		// wantBody is the exact body, otherwise it should gunzip to the listing
+		wantBody []byte
+	}{
+		{
+			name:           "gzip accepted",
+			acceptEncoding: "gzip, deflate",
+			originHeader:   http.Header{"Content-Type": {"application/xml"}},
+			originBody:     listing,
+			wantEncoding:   "gzip",
+		},
+		{
+			name:         "no Accept-Encoding",
+			originHeader: http.Header{"Content-Type": {"application/xml"}},
+			originBody:   listing,
+			wantBody:     listing,
+		},
+		{
+			name:           "compressed by the origin",
+			acceptEncoding: "gzip",
+			originHeader:   http.Header{"Content-Type": {"application/xml"}, "Content-Encoding": {"gzip"}},
+			originBody:     compressed,
+			wantEncoding:   "gzip",
+			wantBody:       compressed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newS3Harness(t)
+			h.Proxy.Compression = &http_server.ResponseCompression{}
+			h.Origin.RespondWith(http.StatusOK, tt.originHeader, tt.originBody)
+
+			r := h.NewSignedRequest(http.MethodGet, "/bucket?list-type=2", nil)
+			if tt.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			// The client must not ask for or decode gzip itself
+			client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+			t.Cleanup(client.CloseIdleConnections)
+			res, err := client.Do(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+
+			if got := res.Header.Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("got Content-Encoding %q, want %q", got, tt.wantEncoding)
+			}
+			if tt.wantBody != nil {
+				if !bytes.Equal(body, tt.wantBody) {
+					t.Errorf("got a %d byte body, want the %d bytes of the origin", len(body), len(tt.wantBody))
+				}
+				return
+			}
+			zr, err := gzip.NewReader(bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			decompressed, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decompressed, listing) {
+				t.Errorf("got a %d byte decompressed body, want the %d byte listing", len(decompressed), len(listing))
+			}
+		})
+	}
+}
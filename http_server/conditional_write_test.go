@@ -0,0 +1,112 @@
+package http_server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+// signWithHeaders signs r with SigV4 like SignRequest, but also signing the extra headers
+func signWithHeaders(r *http.Request, keyID, keySecret, service string, extra ...string) {
+	amzDate := time.Now().UTC().Format("20060102T150405Z")
+	r.Header.Set("X-Amz-Date", amzDate)
+	if r.Header.Get("x-amz-content-sha256") == "" {
+		r.Header.Set("x-amz-content-sha256", "UNSIGNED-PAYLOAD")
+	}
+	signedHeaders := lo.Uniq(append([]string{"host", "x-amz-content-sha256", "x-amz-date"}, lo.Map(extra, func(name string, _ int) string {
+		return strings.ToLower(name)
+	})...))
+	sort.Strings(signedHeaders)
+	parsedHeader := AWSAuthHeader{
+		Credential: AWSAuthHeaderCredential{
+			KeyID:   keyID,
+			Date:    amzDate[:8],
+			Region:  "us-east-1",
+			Service: service,
+			Request: "aws4_request",
+		},
+		SignedHeaders: signedHeaders,
+	}
+	parsedHeader.Signature = generateSigV4(r, parsedHeader, keySecret)
+	r.Header.Set("Authorization", parsedHeader.String())
+}
+
+// A conditional PutObject keeps both signed conditional headers through verification and re-signing, and the
+// origin's 412 reaches the client untouched
+func TestConditionalPutPreconditionFailed(t *testing.T) {
+	const preconditionFailed = `<?xml version="1.0" encoding="UTF-8"?>
+<Error><Code>PreconditionFailed</Code><Message>At least one of the pre-conditions you specified did not hold</Message><Condition>If-Match</Condition></Error>`
+
+	for _, resign := range []bool{false, true} {
+		name := "forwarded"
+		if resign {
+			name = "re-signed"
+		}
+		t.Run(name, func(t *testing.T) {
+			var ifMatch, ifNoneMatch []string
+			origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ifMatch, ifNoneMatch = r.Header.Values("If-Match"), r.Header.Values("If-None-Match")
+				if resign {
+					if err := verifyRequestSignature(r, parseAuthHeader(r.Header.Get("Authorization")), "origin_secret"); err != nil {
+						w.WriteHeader(http.StatusForbidden)
+						return
+					}
+				}
+				w.Header().Set("Content-Type", "application/xml")
+				w.Header().Set("x-amz-request-id", "ORIGINREQUESTID")
+				w.WriteHeader(http.StatusPreconditionFailed)
+				io.WriteString(w, preconditionFailed)
+			}))
+			defer origin.Close()
+
+			provider := NewS3Provider()
+			provider.OriginHost = origin.URL
+			proxy := &AWSProxy{
+				KeyLookupFunc: func(context.Context, string) (string, error) {
+					return "client_secret", nil
+				},
+				ServiceLookupFunc: func(context.Context, string) (AWSServiceProvider, error) {
+					return provider, nil
+				},
+			}
+			if resign {
+				proxy.OutboundCredentials = StaticOutboundCredentials{AccessKeyID: "AKIAORIGIN", SecretAccessKey: "origin_secret"}
+			}
+			server := httptest.NewServer(proxy)
+			defer server.Close()
+
+			r, _ := http.NewRequest(http.MethodPut, server.URL+"/bucket/key", strings.NewReader("new contents"))
+			r.Header.Add("If-Match", `"etag1"`)
+			r.Header.Add("If-Match", `"etag2"`)
+			r.Header.Set("If-None-Match", `"etag3"`)
+			signWithHeaders(r, "AKIACLIENT", "client_secret", "s3", "If-Match", "If-None-Match")
+			res, err := server.Client().Do(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+
+			if res.StatusCode != http.StatusPreconditionFailed || string(body) != preconditionFailed {
+				t.Fatalf("got %d %s, want the origin's PreconditionFailed", res.StatusCode, body)
+			}
+			if got := res.Header.Get("Content-Type"); got != "application/xml" {
+				t.Errorf("got Content-Type %q", got)
+			}
+			if res.Header.Get(RejectReasonHeader) != "" {
+				t.Errorf("got rejection %q for an origin error", res.Header.Get(RejectReasonHeader))
+			}
+			if !slices.Equal(ifMatch, []string{`"etag1"`, `"etag2"`}) || !slices.Equal(ifNoneMatch, []string{`"etag3"`}) {
+				t.Errorf("origin got If-Match %q and If-None-Match %q", ifMatch, ifNoneMatch)
+			}
+		})
+	}
+}
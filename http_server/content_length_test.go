@@ -0,0 +1,155 @@
+package http_server_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+// bufferedBody is a body of known length
+type bufferedBody struct {
+	*bytes.Reader
+}
+
+func (bufferedBody) Close() error {
+	return nil
+}
+
+// The outbound request must be framed by the body actually sent, whatever the client declared
+func TestOutboundContentLength(t *testing.T) {
+	replacement := []byte("a replacement body longer than the original")
+	var replace bool
+	h := iamtest.NewHarness(func(originURL string) http_server.AWSServiceProvider {
+		p := http_server.NewS3Provider()
+		p.OriginHost = originURL
+		p.Use(func(next http_server.OperationHandler) http_server.OperationHandler {
+			return func(ctx context.Context, request *http_server.ProxiedRequest) (*http.Response, error) {
+				if replace {
+					// Replaced without updating Request.ContentLength, but the buffered body knows its length
+					request.Request.Body = bufferedBody{bytes.NewReader(replacement)}
+				}
+				return next(ctx, request)
+			}
+		})
+		return p
+	})
+	t.Cleanup(h.Close)
+
+	type received struct {
+		contentLength    int64
+		transferEncoding []string
+		body             []byte
+	}
+	var got received
+	h.Origin.Handle(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = received{contentLength: r.ContentLength, transferEncoding: r.TransferEncoding, body: body}
+	})
+
+	body := []byte("hello world")
+	tests := []struct {
+		name       string
+		newRequest func() *http.Request
+		replace    bool
+		want       received
+	}{
+		{
+			name: "known length",
+			newRequest: func() *http.Request {
+				return h.NewSignedRequest(http.MethodPut, "/bucket/key", body)
+			},
+			want: received{contentLength: int64(len(body)), body: body},
+		},
+		{
+			name: "chunked",
+			newRequest: func() *http.Request {
+				r := h.NewSignedRequest(http.MethodPut, "/bucket/key", nil)
+				// A reader of unknown length is sent with chunked transfer encoding
+				r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body)))
+				r.ContentLength = -1
+				return r
+			},
+			want: received{contentLength: -1, transferEncoding: []string{"chunked"}, body: body},
+		},
+		{
+			name: "zero Content-Length",
+			newRequest: func() *http.Request {
+				return h.NewSignedRequest(http.MethodPut, "/bucket/key", []byte{})
+			},
+			want: received{contentLength: 0, body: []byte{}},
+		},
+		{
+			name: "replaced with a body of another length",
+			newRequest: func() *http.Request {
+				return h.NewSignedRequest(http.MethodPut, "/bucket/key", body)
+			},
+			replace: true,
+			want:    received{contentLength: int64(len(replacement)), body: replacement},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replace = tt.replace
+			got = received{}
+			res, err := h.Do(tt.newRequest())
+			if err != nil {
+				t.Fatal(err)
+			}
+			resBody, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("got %d %s", res.StatusCode, resBody)
+			}
+			if got.contentLength != tt.want.contentLength || len(got.transferEncoding) != len(tt.want.transferEncoding) {
+				t.Errorf("origin received Content-Length %d and Transfer-Encoding %v, want %d and %v",
+					got.contentLength, got.transferEncoding, tt.want.contentLength, tt.want.transferEncoding)
+			}
+			if !bytes.Equal(got.body, tt.want.body) {
+				t.Errorf("origin received body %q, want %q", got.body, tt.want.body)
+			}
+		})
+	}
+}
+
+// A client that sends less than its Content-Length must not get a success
+func TestOutboundContentLengthShortBody(t *testing.T) {
+	h := newS3Harness(t)
+
+	signed := h.NewSignedRequest(http.MethodPut, "/bucket/key", []byte("hello world"))
+	conn, err := net.Dial("tcp", h.Server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var raw bytes.Buffer
+	fmt.Fprintf(&raw, "PUT /bucket/key HTTP/1.1\r\nHost: %s\r\nContent-Length: 100\r\n", signed.Host)
+	for name, vals := range signed.Header {
+		for _, val := range vals {
+			fmt.Fprintf(&raw, "%s: %s\r\n", name, val)
+		}
+	}
+	raw.WriteString("\r\nhello world")
+	if _, err = conn.Write(raw.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	conn.(*net.TCPConn).CloseWrite()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	// The proxy either responds with an error or drops the connection
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err == nil {
+		res.Body.Close()
+		if res.StatusCode < 300 {
+			t.Errorf("got status %d for a short body", res.StatusCode)
+		}
+	}
+}
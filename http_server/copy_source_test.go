@@ -0,0 +1,88 @@
+package http_server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/samber/lo"
+)
+
+func TestRewriteCopySource(t *testing.T) {
+	tests := []struct {
+		name        string
+		clientSigns bool
+		key         string
+		wantSource  string
+	}{
+		{name: "signed by the client", clientSigns: true, key: "reports/2024.csv", wantSource: "/shared-bucket/tenant-1/reports/2024.csv"},
+		{name: "unsigned by the client", key: "reports/2024.csv", wantSource: "/shared-bucket/tenant-1/reports/2024.csv"},
+		{name: "escaped key", clientSigns: true, key: "my report?.csv", wantSource: "/shared-bucket/tenant-1/my%20report%3F.csv"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var source string
+			var signedHeaders []string
+			var verifyErr, tamperedErr error
+			origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				source = r.Header.Get("x-amz-copy-source")
+				authHeader := parseAuthHeader(r.Header.Get("Authorization"))
+				signedHeaders = authHeader.SignedHeaders
+				verifyErr = verifyRequestSignature(r, authHeader, "client_secret")
+				// Another copy source doesn't verify, the signature covers it
+				r.Header.Set("x-amz-copy-source", "/other-bucket/key")
+				tamperedErr = verifyRequestSignature(r, authHeader, "client_secret")
+			}))
+			defer origin.Close()
+
+			provider := NewS3Provider()
+			provider.OriginHost = origin.URL
+			provider.RegisterOperationHandler("CopyObject", func(ctx context.Context, request *ProxiedRequest) (*http.Response, error) {
+				request.RewriteCopySource("shared-bucket", "tenant-1/"+tt.key)
+				return request.DoProxiedRequest(ctx, origin.URL)
+			})
+			server := httptest.NewServer(&AWSProxy{
+				KeyLookupFunc: func(context.Context, string) (string, error) {
+					return "client_secret", nil
+				},
+				ServiceLookupFunc: func(context.Context, string) (AWSServiceProvider, error) {
+					return provider, nil
+				},
+			})
+			defer server.Close()
+
+			r, _ := http.NewRequest(http.MethodPut, server.URL+"/dest-bucket/copy", nil)
+			r.Header.Set("x-amz-copy-source", "/tenant-1-bucket/"+tt.key)
+			if tt.clientSigns {
+				signWithHeaders(r, "AKIACLIENT", "client_secret", "s3", "x-amz-copy-source")
+			} else {
+				signWithHeaders(r, "AKIACLIENT", "client_secret", "s3")
+			}
+			res, err := server.Client().Do(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("got %d %s", res.StatusCode, body)
+			}
+
+			if source != tt.wantSource {
+				t.Errorf("origin got x-amz-copy-source %q, want %q", source, tt.wantSource)
+			}
+			if !lo.Contains(signedHeaders, "x-amz-copy-source") {
+				t.Errorf("x-amz-copy-source isn't signed, got SignedHeaders %s", strings.Join(signedHeaders, ";"))
+			}
+			if verifyErr != nil {
+				t.Errorf("origin failed to verify the re-signed request: %v", verifyErr)
+			}
+			if tamperedErr == nil {
+				t.Error("the signature doesn't cover x-amz-copy-source")
+			}
+		})
+	}
+}
@@ -0,0 +1,415 @@
+package http_server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/samber/lo"
+)
+
+// CredentialStore is where key secrets live, generalizing AWSProxy.KeyLookupFunc with listing and rotation
+// so secrets can be managed (e.g. at /.internal/keys) rather than baked into env vars or maps.
+// VaultSecretProvider is a CredentialStore (and KeyIssuer) backed by HashiCorp Vault.
+type CredentialStore interface {
+	// GetSecret returns the current secret of the key, or ErrKeyNotFound
+	GetSecret(ctx context.Context, keyID string) (string, error)
+	ListKeys(ctx context.Context) ([]string, error)
+	// Rotate makes secret the current secret of the key, returning the new version. KeyIssuers return
+	// ErrKeyNotFound for unknown keys, since a key created by rotation would have none of the scoping of CreateKey.
+	Rotate(ctx context.Context, keyID, secret string) (version string, err error)
+}
+
+// KeyMetadata scopes a key minted by a KeyIssuer
+type KeyMetadata struct {
+	// Services the key may be used with (e.g. s3), empty for any
+	Services []string `json:"services,omitempty"`
+	// TenantID is set on the Principal of the key's requests, for handlers and audit records
+	TenantID  string     `json:"tenantID,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Disabled  bool       `json:"disabled"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// usableAt is whether the key is neither disabled nor expired at now
+func (m KeyMetadata) usableAt(now time.Time) bool {
+	if m.Disabled {
+		return false
+	}
+	return m.ExpiresAt == nil || now.Before(*m.ExpiresAt)
+}
+
+// KeyIssuer is a CredentialStore that mints proxy-local keys, which never exist in AWS (see
+// AWSProxy.OutboundCredentials), at POST /.internal/keys. Disabled and expired keys are unknown to GetSecret.
+type KeyIssuer interface {
+	CredentialStore
+	// CreateKey stores a new key, failing if it exists
+	CreateKey(ctx context.Context, keyID, secret string, metadata KeyMetadata) error
+	// KeyMetadata returns the metadata of the key, or ErrKeyNotFound
+	KeyMetadata(ctx context.Context, keyID string) (KeyMetadata, error)
+	DisableKey(ctx context.Context, keyID string) error
+}
+
+var ErrKeyExists = errors.New("key already exists")
+
+// CredentialStoreSecretProvider adapts a CredentialStore to a SecretProvider. Stores that are SecretProviders
+// themselves (like VaultSecretProvider) keep accepting their previous secrets during a rotation.
+type CredentialStoreSecretProvider struct {
+	Store CredentialStore
+}
+
+func (p CredentialStoreSecretProvider) Secrets(ctx context.Context, keyID string) ([]Secret, error) {
+	if provider, ok := p.Store.(SecretProvider); ok {
+		return provider.Secrets(ctx, keyID)
+	}
+	secret, err := p.Store.GetSecret(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	return []Secret{{Value: secret}}, nil
+}
+
+func (p CredentialStoreSecretProvider) Rotate(ctx context.Context, keyID, secret string) (string, error) {
+	return p.Store.Rotate(ctx, keyID, secret)
+}
+
+// MemoryCredentialStore is a CredentialStore and KeyIssuer for a single instance, e.g. for tests
+type MemoryCredentialStore struct {
+	// Clock expires keys, defaults to RealClock
+	Clock Clock
+
+	mu       sync.RWMutex
+	secrets  map[string]string
+	versions map[string]int
+	metadata map[string]KeyMetadata
+}
+
+func NewMemoryCredentialStore(secrets map[string]string) *MemoryCredentialStore {
+	s := &MemoryCredentialStore{
+		secrets:  map[string]string{},
+		versions: map[string]int{},
+		metadata: map[string]KeyMetadata{},
+	}
+	for keyID, secret := range secrets {
+		s.secrets[keyID] = secret
+		s.versions[keyID] = 1
+	}
+	return s
+}
+
+func (s *MemoryCredentialStore) GetSecret(_ context.Context, keyID string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	secret, ok := s.secrets[keyID]
+	if !ok || !s.usable(keyID) {
+		return "", ErrKeyNotFound
+	}
+	return secret, nil
+}
+
+// usable is whether the key is neither disabled nor expired
+func (s *MemoryCredentialStore) usable(keyID string) bool {
+	return s.metadata[keyID].usableAt(clockOrReal(s.Clock).Now())
+}
+
+func (s *MemoryCredentialStore) ListKeys(context.Context) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := lo.Keys(s.secrets)
+	slices.Sort(keys)
+	return keys, nil
+}
+
+func (s *MemoryCredentialStore) Rotate(_ context.Context, keyID, secret string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.secrets[keyID]; !ok {
+		return "", ErrKeyNotFound
+	}
+	s.secrets[keyID] = secret
+	s.versions[keyID]++
+	return strconv.Itoa(s.versions[keyID]), nil
+}
+
+func (s *MemoryCredentialStore) CreateKey(_ context.Context, keyID, secret string, metadata KeyMetadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.secrets[keyID]; ok {
+		return ErrKeyExists
+	}
+	s.secrets[keyID] = secret
+	s.versions[keyID] = 1
+	s.metadata[keyID] = metadata
+	return nil
+}
+
+// ImportIssuedKeys copies the keys minted by CreateKey in from that s doesn't have, and disables the keys
+// disabled in from, e.g. so they survive replacing the store on a config reload
+func (s *MemoryCredentialStore) ImportIssuedKeys(from *MemoryCredentialStore) {
+	if from == s {
+		return
+	}
+	from.mu.RLock()
+	defer from.mu.RUnlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for keyID, metadata := range from.metadata {
+		if _, ok := s.secrets[keyID]; ok {
+			// Keys of the config are in both, and would otherwise be enabled again by the reload
+			if metadata.Disabled {
+				existing := s.metadata[keyID]
+				existing.Disabled = true
+				s.metadata[keyID] = existing
+			}
+			continue
+		}
+		if metadata.CreatedAt.IsZero() {
+			continue
+		}
+		s.secrets[keyID] = from.secrets[keyID]
+		s.versions[keyID] = from.versions[keyID]
+		s.metadata[keyID] = metadata
+	}
+}
+
+func (s *MemoryCredentialStore) KeyMetadata(_ context.Context, keyID string) (KeyMetadata, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.secrets[keyID]; !ok {
+		return KeyMetadata{}, ErrKeyNotFound
+	}
+	return s.metadata[keyID], nil
+}
+
+func (s *MemoryCredentialStore) DisableKey(_ context.Context, keyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.secrets[keyID]; !ok {
+		return ErrKeyNotFound
+	}
+	metadata := s.metadata[keyID]
+	metadata.Disabled = true
+	s.metadata[keyID] = metadata
+	return nil
+}
+
+// checkKeyScope rejects requests routed to the provider of a service the key wasn't issued for,
+// and sets the tenant of the principal
+func (p *AWSProxy) checkKeyScope(ctx context.Context, request *ProxiedRequest, service string) error {
+	issuer, ok := p.Lookups().CredentialStore.(KeyIssuer)
+	if !ok {
+		return nil
+	}
+	metadata, err := issuer.KeyMetadata(ctx, request.KeyID)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error in KeyMetadata: %w", err)
+	}
+	if len(metadata.Services) > 0 && !lo.Contains(metadata.Services, service) {
+		return reject(RejectionPolicyDenied, fmt.Errorf("key %s is not issued for %s: %w", request.KeyID, service, ErrAWSAccessDenied))
+	}
+	if metadata.TenantID != "" {
+		request.Principal.Tenant = metadata.TenantID
+	}
+	return nil
+}
+
+// generateKeySecret generates a secret with the 40 characters of an AWS secret access key
+func generateKeySecret() (string, error) {
+	b := make([]byte, 30)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error in rand.Read: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// generateKeyID generates an id in the format of an AWS access key id, AKIA and 16 base32 characters
+func generateKeyID() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error in rand.Read: %w", err)
+	}
+	return "AKIA" + base32.StdEncoding.EncodeToString(b), nil
+}
+
+type KeyList struct {
+	Keys []string `json:"keys"`
+	// Metadata of the keys, if the store is a KeyIssuer
+	Metadata map[string]KeyMetadata `json:"metadata,omitempty"`
+}
+
+type CreateKeyBody struct {
+	Services  []string   `json:"services"`
+	TenantID  string     `json:"tenantID"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+type IssuedKey struct {
+	KeyID    string      `json:"keyID"`
+	Secret   string      `json:"secret"`
+	Metadata KeyMetadata `json:"metadata"`
+}
+
+type RotateKeyBody struct {
+	// Secret is generated if empty
+	Secret string `json:"secret"`
+}
+
+type RotatedKey struct {
+	KeyID   string `json:"keyID"`
+	Secret  string `json:"secret"`
+	Version string `json:"version"`
+}
+
+// now is the time of the ServerConfig.Clock, or of the Clock of the proxy
+func (s *HTTPServer) now() time.Time {
+	if s.clock == nil && s.proxy != nil {
+		return clockOrReal(s.proxy.Clock).Now()
+	}
+	return clockOrReal(s.clock).Now()
+}
+
+// credentialStore is the ServerConfig.Credentials, or the current CredentialStore of the proxy
+func (s *HTTPServer) credentialStore() CredentialStore {
+	if s.credentials == nil && s.proxy != nil {
+		return s.proxy.Lookups().CredentialStore
+	}
+	return s.credentials
+}
+
+func (s *HTTPServer) ListKeys(c echo.Context) error {
+	credentials := s.credentialStore()
+	if credentials == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "credential store is not configured")
+	}
+	keys, err := credentials.ListKeys(c.Request().Context())
+	if err != nil {
+		return fmt.Errorf("error in ListKeys: %w", err)
+	}
+	list := KeyList{Keys: lo.Ternary(keys == nil, []string{}, keys)}
+	if issuer, ok := credentials.(KeyIssuer); ok {
+		list.Metadata = map[string]KeyMetadata{}
+		for _, keyID := range keys {
+			metadata, err := issuer.KeyMetadata(c.Request().Context(), keyID)
+			if err != nil && !errors.Is(err, ErrKeyNotFound) {
+				return fmt.Errorf("error in KeyMetadata: %w", err)
+			}
+			list.Metadata[keyID] = metadata
+		}
+	}
+	return c.JSON(http.StatusOK, list)
+}
+
+// keyIssuer is the credential store if it can issue keys
+func (s *HTTPServer) keyIssuer() (KeyIssuer, error) {
+	credentials := s.credentialStore()
+	if credentials == nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "credential store is not configured")
+	}
+	issuer, ok := credentials.(KeyIssuer)
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusNotImplemented, "credential store does not support issuing keys")
+	}
+	return issuer, nil
+}
+
+// CreateKey mints a proxy-local key, returning its secret, which can't be read again
+func (s *HTTPServer) CreateKey(c echo.Context) error {
+	issuer, err := s.keyIssuer()
+	if err != nil {
+		return err
+	}
+	var body CreateKeyBody
+	if err = ValidateRequest(c, &body); err != nil {
+		return err
+	}
+
+	keyID, err := generateKeyID()
+	if err != nil {
+		return fmt.Errorf("error in generateKeyID: %w", err)
+	}
+	secret, err := generateKeySecret()
+	if err != nil {
+		return fmt.Errorf("error in generateKeySecret: %w", err)
+	}
+	metadata := KeyMetadata{
+		Services:  body.Services,
+		TenantID:  body.TenantID,
+		ExpiresAt: body.ExpiresAt,
+		CreatedAt: s.now().UTC(),
+	}
+	if err = issuer.CreateKey(c.Request().Context(), keyID, secret, metadata); err != nil {
+		return fmt.Errorf("error in CreateKey: %w", err)
+	}
+	logger.Warn().Str("keyID", keyID).Str("tenantID", body.TenantID).Strs("services", body.Services).Msg("issued key")
+
+	return c.JSON(http.StatusCreated, IssuedKey{KeyID: keyID, Secret: secret, Metadata: metadata})
+}
+
+// DisableKey makes the key unknown to the proxy, keeping it listed
+func (s *HTTPServer) DisableKey(c echo.Context) error {
+	issuer, err := s.keyIssuer()
+	if err != nil {
+		return err
+	}
+	keyID := c.Param("keyID")
+	err = issuer.DisableKey(c.Request().Context(), keyID)
+	if errors.Is(err, ErrKeyNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "key not found")
+	}
+	if err != nil {
+		return fmt.Errorf("error in DisableKey: %w", err)
+	}
+	logger.Warn().Str("keyID", keyID).Msg("disabled key")
+
+	metadata, err := issuer.KeyMetadata(c.Request().Context(), keyID)
+	if err != nil {
+		return fmt.Errorf("error in KeyMetadata: %w", err)
+	}
+	return c.JSON(http.StatusOK, metadata)
+}
+
+// RotateKey sets a new secret for the key, returning it so it can be handed to the key's clients
+func (s *HTTPServer) RotateKey(c echo.Context) error {
+	credentials := s.credentialStore()
+	if credentials == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "credential store is not configured")
+	}
+	var body RotateKeyBody
+	if err := ValidateRequest(c, &body); err != nil {
+		return err
+	}
+	keyID := c.Param("keyID")
+
+	secret := body.Secret
+	if secret == "" {
+		var err error
+		if secret, err = generateKeySecret(); err != nil {
+			return fmt.Errorf("error in generateKeySecret: %w", err)
+		}
+	}
+	version, err := credentials.Rotate(c.Request().Context(), keyID, secret)
+	if errors.Is(err, ErrRotationUnsupported) {
+		return echo.NewHTTPError(http.StatusNotImplemented, "credential store does not support rotation")
+	}
+	if errors.Is(err, ErrKeyNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "key not found")
+	}
+	if err != nil {
+		return fmt.Errorf("error in Rotate: %w", err)
+	}
+	logger.Warn().Str("keyID", keyID).Str("version", version).Msg("rotated key secret")
+
+	return c.JSON(http.StatusOK, RotatedKey{KeyID: keyID, Secret: secret, Version: version})
+}
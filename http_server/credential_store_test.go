@@ -0,0 +1,162 @@
+package http_server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+)
+
+func TestMemoryCredentialStoreRotate(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryCredentialStore(map[string]string{"AKIAEXISTING": "old"})
+
+	version, err := store.Rotate(ctx, "AKIAEXISTING", "new")
+	if err != nil || version != "2" {
+		t.Fatalf("got %q, %v", version, err)
+	}
+	if secret, _ := store.GetSecret(ctx, "AKIAEXISTING"); secret != "new" {
+		t.Errorf("got secret %q after rotation", secret)
+	}
+
+	// Rotating an unknown key must not create an unscoped one
+	if _, err = store.Rotate(ctx, "AKIAUNKNOWN", "secret"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v, want ErrKeyNotFound", err)
+	}
+	if _, err = store.GetSecret(ctx, "AKIAUNKNOWN"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("rotation created the key")
+	}
+}
+
+func TestMemoryCredentialStoreIssuedKeys(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	store := NewMemoryCredentialStore(nil)
+	store.Clock = clock
+
+	expiresAt := clock.Now().Add(time.Hour)
+	if err := store.CreateKey(ctx, "AKIAISSUED", "secret", KeyMetadata{ExpiresAt: &expiresAt}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateKey(ctx, "AKIAISSUED", "other", KeyMetadata{}); !errors.Is(err, ErrKeyExists) {
+		t.Errorf("got %v, want ErrKeyExists", err)
+	}
+	if secret, err := store.GetSecret(ctx, "AKIAISSUED"); err != nil || secret != "secret" {
+		t.Fatalf("got %q, %v", secret, err)
+	}
+
+	clock.Set(expiresAt)
+	if _, err := store.GetSecret(ctx, "AKIAISSUED"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expired key is usable")
+	}
+}
+
+// Reloading the config replaces the store, keeping the keys minted since and the keys disabled since
+func TestMemoryCredentialStoreImportIssuedKeys(t *testing.T) {
+	ctx := context.Background()
+	config := map[string]string{"AKIACONFIG": "secret", "AKIAOTHER": "secret"}
+	current := NewMemoryCredentialStore(config)
+	if err := current.CreateKey(ctx, "AKIAISSUED", "issued", KeyMetadata{CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := current.DisableKey(ctx, "AKIACONFIG"); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := NewMemoryCredentialStore(config)
+	reloaded.ImportIssuedKeys(current)
+	if _, err := reloaded.GetSecret(ctx, "AKIACONFIG"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("got %v for the disabled config key, want ErrKeyNotFound", err)
+	}
+	if secret, err := reloaded.GetSecret(ctx, "AKIAOTHER"); err != nil || secret != "secret" {
+		t.Errorf("got %q, %v for the other config key", secret, err)
+	}
+	if secret, err := reloaded.GetSecret(ctx, "AKIAISSUED"); err != nil || secret != "issued" {
+		t.Errorf("got %q, %v for the issued key", secret, err)
+	}
+}
+
+func TestRotateKeyHandler(t *testing.T) {
+	s := &HTTPServer{credentials: NewMemoryCredentialStore(map[string]string{"AKIAEXISTING": "old"})}
+	e := echo.New()
+	e.Validator = &CustomValidator{validator: validator.New()}
+
+	rotate := func(keyID string) error {
+		req := httptest.NewRequest(http.MethodPost, "/.internal/keys/"+keyID+"/rotate", strings.NewReader(`{"secret":"new"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		c := e.NewContext(req, httptest.NewRecorder())
+		c.SetParamNames("keyID")
+		c.SetParamValues(keyID)
+		return s.RotateKey(c)
+	}
+
+	if err := rotate("AKIAEXISTING"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var httpErr *echo.HTTPError
+	if err := rotate("AKIAUNKNOWN"); !errors.As(err, &httpErr) || httpErr.Code != http.StatusNotFound {
+		t.Fatalf("got %v, want a 404", err)
+	}
+}
+
+// A key is scoped to the provider its requests are routed to, whatever service the client put in its credential scope
+func TestKeyScopeChecksRoutedProvider(t *testing.T) {
+	store := NewMemoryCredentialStore(nil)
+	if err := store.CreateKey(context.Background(), "AKIAISSUED", "secret", KeyMetadata{Services: []string{"s3"}}); err != nil {
+		t.Fatal(err)
+	}
+	var originRequests int
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		originRequests++
+	}))
+	defer origin.Close()
+	s3, sqs := NewS3Provider(), NewSQSProvider()
+	s3.OriginHost, sqs.OriginHost = origin.URL, origin.URL
+	proxy := &AWSProxy{
+		CredentialStore: store,
+		ServiceLookupFunc: func(_ context.Context, host string) (AWSServiceProvider, error) {
+			if host == "queue.example.com" {
+				return sqs, nil
+			}
+			return s3, nil
+		},
+	}
+
+	tests := []struct {
+		name    string
+		host    string
+		service string
+		want    int
+	}{
+		{name: "routed to s3", host: "s3.example.com", service: "s3", want: http.StatusOK},
+		{name: "signed for s3 but routed to sqs", host: "queue.example.com", service: "s3", want: http.StatusForbidden},
+		{name: "signed for sqs and routed to sqs", host: "queue.example.com", service: "sqs", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := originRequests
+			r := httptest.NewRequest(http.MethodPost, "http://"+tt.host+"/", strings.NewReader("Action=ListQueues"))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			SignRequest(r, "AKIAISSUED", "secret", "us-east-1", tt.service, time.Now())
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("got %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if tt.want == http.StatusForbidden {
+				if got := w.Header().Get(RejectReasonHeader); got != string(RejectionPolicyDenied) {
+					t.Errorf("got rejection reason %q", got)
+				}
+				if originRequests != before {
+					t.Error("origin received the request")
+				}
+			}
+		})
+	}
+}
@@ -16,6 +16,36 @@ type CustomContext struct {
 	echo.Context
 	RequestID      string
 	AWSCredentials AWSAuthHeaderCredential
+
+	authHeader AWSAuthHeader
+	principal  Principal
+	operation  string
+}
+
+// SetAWSAuth records the verified auth header of the request, and the principal behind its key
+func (c *CustomContext) SetAWSAuth(authHeader AWSAuthHeader, principal Principal) {
+	c.authHeader = authHeader
+	c.AWSCredentials = authHeader.Credential
+	c.principal = principal
+}
+
+// AuthHeader is the verified auth header, empty if the request hasn't been verified
+func (c *CustomContext) AuthHeader() AWSAuthHeader {
+	return c.authHeader
+}
+
+// Principal is the identity behind the verified key id
+func (c *CustomContext) Principal() Principal {
+	return c.principal
+}
+
+func (c *CustomContext) SetOperation(operation string) {
+	c.operation = operation
+}
+
+// Operation is the API operation of the request (e.g. "GetObject"), if it has been classified
+func (c *CustomContext) Operation() string {
+	return c.operation
 }
 
 func CreateReqContext(next echo.HandlerFunc) echo.HandlerFunc {
@@ -0,0 +1,150 @@
+package http_server
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// newContextTestEcho serves handler behind the middleware and error handler of the HTTPServer
+func newContextTestEcho(handler func(*CustomContext) error, middleware ...echo.MiddlewareFunc) *echo.Echo {
+	e := echo.New()
+	e.Use(CreateReqContext)
+	e.HTTPErrorHandler = customHTTPErrorHandler
+	e.Any("/*", ccHandler(handler), middleware...)
+	return e
+}
+
+func TestCustomContextCarriesVerifiedAuth(t *testing.T) {
+	var (
+		called    bool
+		requestID string
+		header    AWSAuthHeader
+		creds     AWSAuthHeaderCredential
+		principal Principal
+		operation string
+	)
+	e := newContextTestEcho(func(c *CustomContext) error {
+		called = true
+		requestID, header, creds, principal = c.RequestID, c.AuthHeader(), c.AWSCredentials, c.Principal()
+		c.SetOperation("GetObject")
+		operation = c.Operation()
+		return c.NoContent(http.StatusOK)
+	}, verifyAWSRequestMiddleware(nil))
+
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/bucket/key", nil)
+	SignRequest(r, "AKIDEXAMPLE", "test_secret", "eu-west-1", "s3", time.Now())
+	signed := parseAuthHeader(r.Header.Get("Authorization"))
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+
+	if requestID == "" {
+		t.Error("no request id")
+	}
+	if header.Signature != signed.Signature || header.Credential != signed.Credential {
+		t.Errorf("got auth header %+v, want %+v", header, signed)
+	}
+	if creds.KeyID != "AKIDEXAMPLE" || creds.Region != "eu-west-1" || creds.Service != "s3" {
+		t.Errorf("got credentials %+v", creds)
+	}
+	if principal.KeyID != "AKIDEXAMPLE" {
+		t.Errorf("got principal %+v", principal)
+	}
+	if operation != "GetObject" {
+		t.Errorf("got operation %q", operation)
+	}
+
+	// A request that doesn't verify never reaches the handler
+	called = false
+	r = httptest.NewRequest(http.MethodGet, "http://example.com/bucket/key", nil)
+	SignRequest(r, "AKIDEXAMPLE", "wrong_secret", "eu-west-1", "s3", time.Now())
+	w = httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+	if called || w.Code != http.StatusForbidden {
+		t.Errorf("got %d and handler called %t, want it rejected", w.Code, called)
+	}
+}
+
+func TestCustomHTTPErrorHandler(t *testing.T) {
+	tests := []struct {
+		name string
+		// service the request is signed for, unsigned if empty
+		service  string
+		err      error
+		wantCode int
+		// wantAWSCode is the code of the AWS-shaped error body, or wantBody is the plain one
+		wantAWSCode string
+		wantBody    string
+	}{
+		{name: "aws error to s3", service: "s3", err: ErrAWSAccessDenied, wantCode: http.StatusForbidden, wantAWSCode: "AccessDenied"},
+		{name: "wrapped aws error to dynamodb", service: "dynamodb", err: fmt.Errorf("error in handler: %w", ErrAWSAccessDenied), wantCode: http.StatusForbidden, wantAWSCode: "AccessDenied"},
+		{name: "aws error to sqs", service: "sqs", err: ErrAWSAccessDenied, wantCode: http.StatusForbidden, wantAWSCode: "AccessDenied"},
+		{name: "echo error to s3", service: "s3", err: echo.NewHTTPError(http.StatusNotFound, "no such route"), wantCode: http.StatusNotFound, wantAWSCode: "NotFound"},
+		{name: "internal error to s3", service: "s3", err: errors.New("database is down"), wantCode: http.StatusInternalServerError, wantAWSCode: "InternalError"},
+		{name: "echo error unsigned", err: echo.NewHTTPError(http.StatusNotFound, "no such route"), wantCode: http.StatusNotFound, wantBody: "no such route"},
+		{name: "internal error unsigned", err: errors.New("database is down"), wantCode: http.StatusInternalServerError, wantBody: "Something went wrong internally, an error has been logged"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newContextTestEcho(func(c *CustomContext) error {
+				return tt.err
+			})
+			r := httptest.NewRequest(http.MethodPost, "http://example.com/", nil)
+			if tt.service != "" {
+				SignRequest(r, "AKIDEXAMPLE", "test_secret", "us-east-1", tt.service, time.Now())
+			}
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("got %d %s, want %d", w.Code, w.Body, tt.wantCode)
+			}
+			if tt.wantBody != "" {
+				if w.Body.String() != tt.wantBody {
+					t.Errorf("got body %q, want %q", w.Body, tt.wantBody)
+				}
+				return
+			}
+
+			var code, requestID string
+			switch ProtocolForService(tt.service) {
+			case ProtocolJSON:
+				var body struct {
+					Type string `json:"__type"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+					t.Fatalf("got body %s: %v", w.Body, err)
+				}
+				code, requestID = body.Type, w.Header().Get("x-amzn-RequestId")
+			case ProtocolQuery:
+				var body queryError
+				if err := xml.Unmarshal(w.Body.Bytes(), &body); err != nil {
+					t.Fatalf("got body %s: %v", w.Body, err)
+				}
+				code, requestID = body.Error.Code, body.RequestId
+			default:
+				var body xmlError
+				if err := xml.Unmarshal(w.Body.Bytes(), &body); err != nil {
+					t.Fatalf("got body %s: %v", w.Body, err)
+				}
+				code, requestID = body.Code, body.RequestId
+			}
+			if code != tt.wantAWSCode {
+				t.Errorf("got code %q, want %q", code, tt.wantAWSCode)
+			}
+			// The request id of the error is the one CreateReqContext logs the request with
+			if requestID == "" {
+				t.Error("error has no request id")
+			}
+		})
+	}
+}
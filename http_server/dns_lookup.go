@@ -0,0 +1,142 @@
+package http_server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNSResolver resolves records along with their TTL
+type DNSResolver interface {
+	LookupTXT(ctx context.Context, name string) (records []string, ttl time.Duration, err error)
+	LookupSRV(ctx context.Context, name string) (records []*net.SRV, ttl time.Duration, err error)
+}
+
+// NetDNSResolver is a DNSResolver using a net.Resolver. The stdlib doesn't expose record TTLs,
+// so every record is given TTL.
+type NetDNSResolver struct {
+	Resolver *net.Resolver
+	TTL      time.Duration
+}
+
+func (r NetDNSResolver) LookupTXT(ctx context.Context, name string) ([]string, time.Duration, error) {
+	records, err := r.Resolver.LookupTXT(ctx, name)
+	return records, r.TTL, err
+}
+
+func (r NetDNSResolver) LookupSRV(ctx context.Context, name string) ([]*net.SRV, time.Duration, error) {
+	_, records, err := r.Resolver.LookupSRV(ctx, "", "", name)
+	return records, r.TTL, err
+}
+
+// DNSLookupProvider resolves the outbound host of an incoming host from DNS, so routing can be changed
+// without touching the service. The record for incoming host s3.example.com in zone routes.example.net is
+// s3.example.com.routes.example.net, either a TXT record of the outbound host, or an SRV record for host:port.
+// Use Lookup as AWSProxy.HostLookupFunc.
+type DNSLookupProvider struct {
+	Resolver DNSResolver
+	Zone     string
+	// UseSRV resolves SRV records rather than TXT
+	UseSRV bool
+	// Clock defaults to RealClock
+	Clock Clock
+
+	mu    sync.Mutex
+	cache map[string]dnsCacheEntry
+}
+
+type dnsCacheEntry struct {
+	host    string
+	expires time.Time
+}
+
+func NewDNSLookupProvider(resolver DNSResolver, zone string, useSRV bool) *DNSLookupProvider {
+	return &DNSLookupProvider{
+		Resolver: resolver,
+		Zone:     zone,
+		UseSRV:   useSRV,
+		cache:    map[string]dnsCacheEntry{},
+	}
+}
+
+// Lookup returns the outbound host of the incoming host. Missing records return ErrKeyNotFound,
+// and transient DNS failures return a *RetryableError.
+func (p *DNSLookupProvider) Lookup(ctx context.Context, host string) (string, error) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	name := strings.TrimSuffix(host, ".") + "." + strings.Trim(p.Zone, ".")
+
+	p.mu.Lock()
+	entry, ok := p.cache[name]
+	p.mu.Unlock()
+	now := clockOrReal(p.Clock).Now()
+	if ok && now.Before(entry.expires) {
+		return entry.host, nil
+	}
+
+	outbound, ttl, err := p.resolve(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	p.cache[name] = dnsCacheEntry{
+		host:    outbound,
+		expires: now.Add(ttl),
+	}
+	p.mu.Unlock()
+	return outbound, nil
+}
+
+func (p *DNSLookupProvider) resolve(ctx context.Context, name string) (string, time.Duration, error) {
+	if p.UseSRV {
+		records, ttl, err := p.Resolver.LookupSRV(ctx, name)
+		if err != nil {
+			return "", 0, classifyDNSError(err)
+		}
+		if len(records) == 0 {
+			return "", 0, ErrKeyNotFound
+		}
+		// Lowest priority wins, with the highest weight breaking ties
+		sort.Slice(records, func(i, j int) bool {
+			if records[i].Priority != records[j].Priority {
+				return records[i].Priority < records[j].Priority
+			}
+			return records[i].Weight > records[j].Weight
+		})
+		target := strings.TrimSuffix(records[0].Target, ".")
+		return net.JoinHostPort(target, strconv.Itoa(int(records[0].Port))), ttl, nil
+	}
+
+	records, ttl, err := p.Resolver.LookupTXT(ctx, name)
+	if err != nil {
+		return "", 0, classifyDNSError(err)
+	}
+	for _, record := range records {
+		if record = strings.TrimSpace(record); record != "" {
+			return record, ttl, nil
+		}
+	}
+	return "", 0, ErrKeyNotFound
+}
+
+// classifyDNSError maps NXDOMAIN to ErrKeyNotFound, and transient failures to a *RetryableError
+func classifyDNSError(err error) error {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		if dnsErr.IsNotFound {
+			return fmt.Errorf("%w: %w", ErrKeyNotFound, err)
+		}
+		if dnsErr.IsTemporary || dnsErr.IsTimeout {
+			return &RetryableError{Err: err}
+		}
+	}
+	return fmt.Errorf("error resolving dns: %w", err)
+}
@@ -0,0 +1,190 @@
+package http_server_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+// stubResolver answers from its records, counting the queries
+type stubResolver struct {
+	mu      sync.Mutex
+	txt     map[string][]string
+	srv     map[string][]*net.SRV
+	ttl     time.Duration
+	err     error
+	queries int
+}
+
+// setTXT replaces the TXT records of name while the resolver may be queried
+func (r *stubResolver) setTXT(name string, records ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.txt[name] = records
+}
+
+func (r *stubResolver) LookupTXT(_ context.Context, name string) ([]string, time.Duration, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.queries++
+	if r.err != nil {
+		return nil, 0, r.err
+	}
+	records, ok := r.txt[name]
+	if !ok {
+		return nil, 0, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, r.ttl, nil
+}
+
+func (r *stubResolver) LookupSRV(_ context.Context, name string) ([]*net.SRV, time.Duration, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.queries++
+	if r.err != nil {
+		return nil, 0, r.err
+	}
+	records, ok := r.srv[name]
+	if !ok {
+		return nil, 0, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, r.ttl, nil
+}
+
+func TestDNSLookupProviderTXT(t *testing.T) {
+	resolver := &stubResolver{
+		txt: map[string][]string{
+			"s3.example.com.routes.example.net":    {"", "minio.internal:9000"},
+			"empty.example.com.routes.example.net": {" "},
+		},
+		ttl: time.Minute,
+	}
+	clock := http_server.NewFakeClock(time.Now())
+	p := http_server.NewDNSLookupProvider(resolver, "routes.example.net.", false)
+	p.Clock = clock
+
+	for _, host := range []string{"s3.example.com", "s3.example.com:443"} {
+		got, err := p.Lookup(context.Background(), host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != "minio.internal:9000" {
+			t.Errorf("%s: got %q", host, got)
+		}
+	}
+	if resolver.queries != 1 {
+		t.Errorf("got %d queries, want the record cached", resolver.queries)
+	}
+
+	// The record is resolved again once its TTL is up
+	resolver.txt["s3.example.com.routes.example.net"] = []string{"minio-2.internal:9000"}
+	clock.Advance(time.Minute)
+	if got, _ := p.Lookup(context.Background(), "s3.example.com"); got != "minio-2.internal:9000" {
+		t.Errorf("got %q after the TTL, want the new record", got)
+	}
+	if resolver.queries != 2 {
+		t.Errorf("got %d queries", resolver.queries)
+	}
+
+	for _, host := range []string{"missing.example.com", "empty.example.com"} {
+		if _, err := p.Lookup(context.Background(), host); !errors.Is(err, http_server.ErrKeyNotFound) {
+			t.Errorf("%s: got %v, want ErrKeyNotFound", host, err)
+		}
+	}
+}
+
+func TestDNSLookupProviderSRV(t *testing.T) {
+	resolver := &stubResolver{
+		srv: map[string][]*net.SRV{
+			"s3.example.com.routes.example.net": {
+				{Target: "backup.internal.", Port: 9000, Priority: 20, Weight: 100},
+				{Target: "light.internal.", Port: 9001, Priority: 10, Weight: 10},
+				{Target: "heavy.internal.", Port: 9002, Priority: 10, Weight: 50},
+			},
+			"empty.example.com.routes.example.net": {},
+		},
+		ttl: time.Minute,
+	}
+	p := http_server.NewDNSLookupProvider(resolver, "routes.example.net", true)
+
+	got, err := p.Lookup(context.Background(), "s3.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Lowest priority, then highest weight
+	if got != "heavy.internal:9002" {
+		t.Errorf("got %q", got)
+	}
+	if _, err = p.Lookup(context.Background(), "empty.example.com"); !errors.Is(err, http_server.ErrKeyNotFound) {
+		t.Errorf("got %v, want ErrKeyNotFound", err)
+	}
+}
+
+func TestDNSLookupProviderErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		wantRetryable bool
+	}{
+		{name: "timeout", err: &net.DNSError{Err: "i/o timeout", IsTimeout: true}, wantRetryable: true},
+		{name: "temporary", err: &net.DNSError{Err: "server misbehaving", IsTemporary: true}, wantRetryable: true},
+		{name: "other", err: errors.New("resolver is broken")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := http_server.NewDNSLookupProvider(&stubResolver{err: tt.err}, "routes.example.net", false)
+			_, err := p.Lookup(context.Background(), "s3.example.com")
+			var retryable *http_server.RetryableError
+			if errors.As(err, &retryable) != tt.wantRetryable {
+				t.Errorf("got %v, want retryable %t", err, tt.wantRetryable)
+			}
+			if err == nil || errors.Is(err, http_server.ErrKeyNotFound) {
+				t.Errorf("got %v, want a lookup failure", err)
+			}
+		})
+	}
+}
+
+// Changing the record of a host changes which origin its requests go to, once the cached record expires
+func TestDNSLookupProviderRoutesRequests(t *testing.T) {
+	h := newS3Harness(t)
+	next := iamtest.NewFakeOrigin()
+	defer next.Close()
+	const name = "s3.example.com.routes.example.net"
+	resolver := &stubResolver{txt: map[string][]string{name: {h.Origin.URL}}, ttl: time.Minute}
+	clock := http_server.NewFakeClock(time.Now())
+	p := http_server.NewDNSLookupProvider(resolver, "routes.example.net", false)
+	p.Clock = clock
+	h.Proxy.HostLookupFunc = p.Lookup
+
+	checkRoutedTo := func(origin *iamtest.FakeOrigin) {
+		t.Helper()
+		before := len(origin.Requests())
+		res, err := h.Do(newSignedS3Request(h, http.MethodGet, "s3.example.com", "/bucket/key"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("got %d %s", res.StatusCode, body)
+		}
+		if n := len(origin.Requests()) - before; n != 1 {
+			t.Errorf("origin received %d requests, want 1", n)
+		}
+	}
+
+	checkRoutedTo(h.Origin)
+	resolver.setTXT(name, next.URL)
+	checkRoutedTo(h.Origin)
+	clock.Advance(time.Minute)
+	checkRoutedTo(next)
+}
@@ -0,0 +1,73 @@
+package http_server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+var ErrServerDraining = echo.NewHTTPError(http.StatusServiceUnavailable, "server is shutting down")
+
+// requestTracker counts in-flight requests so shutdown can wait for them (e.g. large S3 streams) to finish.
+// Once draining, new requests are rejected.
+type requestTracker struct {
+	mu       sync.Mutex
+	active   int
+	draining bool
+	// drained is closed when draining and there are no more active requests
+	drained chan struct{}
+}
+
+// start tracks a new request, returning false if the server is draining
+func (t *requestTracker) start() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.draining {
+		return false
+	}
+	t.active++
+	return true
+}
+
+func (t *requestTracker) done() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active--
+	if t.draining && t.active == 0 {
+		close(t.drained)
+	}
+}
+
+// drain stops accepting requests, and waits for the active ones to finish or ctx to be done
+func (t *requestTracker) drain(ctx context.Context) error {
+	t.mu.Lock()
+	if !t.draining {
+		t.draining = true
+		t.drained = make(chan struct{})
+		if t.active == 0 {
+			close(t.drained)
+		}
+	}
+	drained := t.drained
+	t.mu.Unlock()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *requestTracker) middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !t.start() {
+			c.Response().Header().Set("Connection", "close")
+			return ErrServerDraining
+		}
+		defer t.done()
+		return next(c)
+	}
+}
@@ -0,0 +1,106 @@
+package http_server_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+// firstHalf is large enough to make it through the buffers between the origin and the client
+var firstHalf = strings.Repeat("a", 64*1024)
+
+// newSlowStreamHarness is an S3 harness whose origin streams the first half of a body, and the second half
+// once release is closed
+func newSlowStreamHarness(t *testing.T) (h *iamtest.Harness, release chan struct{}) {
+	t.Helper()
+	h = newS3Harness(t)
+	release = make(chan struct{})
+	h.Origin.Handle(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, firstHalf)
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		io.WriteString(w, "second half")
+	})
+	return h, release
+}
+
+func startStream(t *testing.T, h *iamtest.Harness) *http.Response {
+	t.Helper()
+	res, err := h.Do(h.NewSignedRequest(http.MethodGet, "/bucket/large", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadFull(res.Body, make([]byte, len(firstHalf))); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestDrainWaitsForInFlightRequests(t *testing.T) {
+	h, release := newSlowStreamHarness(t)
+	res := startStream(t, h)
+	defer res.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	drained := make(chan error, 1)
+	go func() { drained <- h.Proxy.Drain(ctx) }()
+
+	select {
+	case err := <-drained:
+		t.Fatalf("drain returned %v with a request in flight", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// New requests are turned away with a retryable error while draining
+	rejected, err := h.Do(h.NewSignedRequest(http.MethodGet, "/bucket/other", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(rejected.Body)
+	rejected.Body.Close()
+	if rejected.StatusCode != http.StatusServiceUnavailable || !strings.Contains(string(body), "ServiceUnavailable") {
+		t.Errorf("got %d %s while draining, want a 503", rejected.StatusCode, body)
+	}
+
+	close(release)
+	rest, err := io.ReadAll(res.Body)
+	if err != nil || string(rest) != "second half" {
+		t.Fatalf("got %q %v, want the in-flight stream to finish", rest, err)
+	}
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Errorf("got %v once the request finished", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("drain didn't return once the request finished")
+	}
+}
+
+func TestDrainDeadline(t *testing.T) {
+	h, release := newSlowStreamHarness(t)
+	defer close(release)
+	res := startStream(t, h)
+	defer res.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := h.Proxy.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the deadline exceeded", err)
+	}
+	if waited := time.Since(start); waited > 2*time.Second {
+		t.Errorf("drain waited %s past its deadline", waited)
+	}
+}
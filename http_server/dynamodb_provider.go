@@ -0,0 +1,186 @@
+package http_server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/samber/lo"
+)
+
+const (
+	// DynamoDBMaxItemBytes is the maximum item size DynamoDB allows
+	DynamoDBMaxItemBytes = 400 * 1024
+	// maxDynamoDBRequestBytes bounds how much of a request body we will buffer to inspect it
+	maxDynamoDBRequestBytes = 16 * 1024 * 1024
+)
+
+// DynamoDBProvider is the AWSServiceProvider for DynamoDB, which uses the AWS JSON 1.0 protocol.
+// Register handlers per action (e.g. "GetItem"), and use DynamoDBTableNames and RewriteDynamoDBTableNames
+// to inspect or remap the tables of a request.
+type DynamoDBProvider struct {
+	*JSONRPCProvider
+
+	// MaxItemBytes rejects PutItem and UpdateItem requests whose item is estimated to be larger
+	// with a ValidationException before forwarding them. 0 disables the check, see DynamoDBMaxItemBytes.
+	MaxItemBytes int
+}
+
+func NewDynamoDBProvider() *DynamoDBProvider {
+	p := &DynamoDBProvider{
+		JSONRPCProvider: NewJSONRPCProvider("dynamodb"),
+	}
+	p.Operations = DynamoDBOperations
+	return p
+}
+
+// ExtractResources returns the tables of the request, e.g. arn:aws:dynamodb:us-east-1:123456789012:table/users
+func (p *DynamoDBProvider) ExtractResources(request *ProxiedRequest) ([]string, error) {
+	tables, err := DynamoDBTableNames(request)
+	if err != nil {
+		return nil, fmt.Errorf("error in DynamoDBTableNames: %w", err)
+	}
+	return lo.Map(tables, func(table string, _ int) string {
+		return resourceARN("dynamodb", request, "table/"+table)
+	}), nil
+}
+
+// HandleRequest dispatches to the registered operation handler, or proxies to DynamoDB if there is none.
+// Origin errors such as ProvisionedThroughputExceededException are passed through untouched.
+func (p *DynamoDBProvider) HandleRequest(ctx context.Context, request *ProxiedRequest) (*http.Response, error) {
+	operation := p.ExtractOperationName(request)
+
+	if p.MaxItemBytes > 0 && (operation == "PutItem" || operation == "UpdateItem") {
+		itemBytes, err := estimateDynamoDBItemBytes(request)
+		if err != nil {
+			return newAWSErrorResponse(ctx, ProtocolJSON, NewAWSError(http.StatusBadRequest, "SerializationException", err.Error())), nil
+		}
+		if itemBytes > p.MaxItemBytes {
+			return newRejectionResponse(ctx, RejectionBodyTooLarge, ProtocolJSON, NewAWSError(http.StatusBadRequest, "ValidationException", "Item size has exceeded the maximum allowed size")), nil
+		}
+	}
+
+	return p.dispatch(ctx, operation, request, p.proxy)
+}
+
+// estimateDynamoDBItemBytes buffers the (bounded) request body and estimates the item size using
+// DynamoDB's sizing rules. For UpdateItem, the key and new values are used since the stored item is unknown.
+func estimateDynamoDBItemBytes(request *ProxiedRequest) (int, error) {
+	body, err := io.ReadAll(io.LimitReader(request.Request.Body, maxDynamoDBRequestBytes))
+	if err != nil {
+		return 0, fmt.Errorf("error reading request body: %w", err)
+	}
+	request.Request.Body.Close()
+	request.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	var input struct {
+		Item                      map[string]json.RawMessage
+		Key                       map[string]json.RawMessage
+		ExpressionAttributeValues map[string]json.RawMessage
+		AttributeUpdates          map[string]struct{ Value json.RawMessage }
+	}
+	if err = json.Unmarshal(body, &input); err != nil {
+		return 0, fmt.Errorf("error in json.Unmarshal: %w", err)
+	}
+
+	size := 0
+	for _, attrs := range []map[string]json.RawMessage{input.Item, input.Key, input.ExpressionAttributeValues} {
+		attrSize, err := dynamoDBAttributesBytes(attrs)
+		if err != nil {
+			return 0, err
+		}
+		size += attrSize
+	}
+	for name, update := range input.AttributeUpdates {
+		if update.Value == nil {
+			continue
+		}
+		valueSize, err := dynamoDBAttributeValueBytes(update.Value)
+		if err != nil {
+			return 0, err
+		}
+		size += len(name) + valueSize
+	}
+
+	return size, nil
+}
+
+func dynamoDBAttributesBytes(attrs map[string]json.RawMessage) (int, error) {
+	size := 0
+	for name, value := range attrs {
+		valueSize, err := dynamoDBAttributeValueBytes(value)
+		if err != nil {
+			return 0, fmt.Errorf("error sizing attribute %s: %w", name, err)
+		}
+		size += len(name) + valueSize
+	}
+	return size, nil
+}
+
+// dynamoDBAttributeValueBytes approximates the stored size of a typed attribute value like {"S": "hello"},
+// see https://docs.aws.amazon.com/amazondynamodb/latest/developerguide/CapacityUnitCalculations.html
+func dynamoDBAttributeValueBytes(raw json.RawMessage) (int, error) {
+	var typed map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &typed); err != nil {
+		return 0, fmt.Errorf("error in json.Unmarshal of attribute value: %w", err)
+	}
+
+	size := 0
+	for typ, value := range typed {
+		switch typ {
+		case "S", "N":
+			var s string
+			if err := json.Unmarshal(value, &s); err != nil {
+				return 0, err
+			}
+			size += len(s)
+		case "B":
+			var s string
+			if err := json.Unmarshal(value, &s); err != nil {
+				return 0, err
+			}
+			size += base64.StdEncoding.DecodedLen(len(s))
+		case "BOOL", "NULL":
+			size += 1
+		case "SS", "NS", "BS":
+			var set []string
+			if err := json.Unmarshal(value, &set); err != nil {
+				return 0, err
+			}
+			for _, s := range set {
+				size += len(s)
+			}
+		case "L":
+			var list []json.RawMessage
+			if err := json.Unmarshal(value, &list); err != nil {
+				return 0, err
+			}
+			size += 3
+			for _, item := range list {
+				itemSize, err := dynamoDBAttributeValueBytes(item)
+				if err != nil {
+					return 0, err
+				}
+				size += 1 + itemSize
+			}
+		case "M":
+			var m map[string]json.RawMessage
+			if err := json.Unmarshal(value, &m); err != nil {
+				return 0, err
+			}
+			mapSize, err := dynamoDBAttributesBytes(m)
+			if err != nil {
+				return 0, err
+			}
+			size += 3 + len(m) + mapSize
+		default:
+			return 0, fmt.Errorf("unknown attribute type %s", strconv.Quote(typ))
+		}
+	}
+	return size, nil
+}
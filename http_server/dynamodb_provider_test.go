@@ -0,0 +1,311 @@
+package http_server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+// newDynamoDBClient is an SDK client of the harness that doesn't retry
+func newDynamoDBClient(h *iamtest.Harness) *dynamodb.Client {
+	return dynamodb.NewFromConfig(aws.Config{
+		Region:      iamtest.Region,
+		Credentials: credentials.NewStaticCredentialsProvider(iamtest.KeyID, iamtest.KeySecret, ""),
+		HTTPClient:  h.Server.Client(),
+	}, func(o *dynamodb.Options) {
+		o.BaseEndpoint = aws.String(h.Server.URL)
+		o.RetryMaxAttempts = 1
+	})
+}
+
+// The SDKs of services other than S3 don't send x-amz-content-sha256, and sign the hash of the body
+func TestDynamoDBSignedBySDK(t *testing.T) {
+	h := iamtest.NewHarness(func(originURL string) http_server.AWSServiceProvider {
+		p := http_server.NewDynamoDBProvider()
+		p.OriginHost = originURL
+		return p
+	})
+	t.Cleanup(h.Close)
+	h.Origin.RespondWith(http.StatusOK, http.Header{"Content-Type": {"application/x-amz-json-1.0"}}, []byte("{}"))
+
+	client := newDynamoDBClient(h)
+	_, err := client.PutItem(context.Background(), &dynamodb.PutItemInput{
+		TableName: aws.String("users"),
+		Item: map[string]types.AttributeValue{
+			"id":   &types.AttributeValueMemberS{Value: "user-1"},
+			"name": &types.AttributeValueMemberS{Value: "Ada"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := h.Origin.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("origin received %d requests", len(requests))
+	}
+	if got := requests[0].Header.Get("X-Amz-Target"); got != "DynamoDB_20120810.PutItem" {
+		t.Errorf("origin received target %q", got)
+	}
+	if body := string(requests[0].Body); !strings.Contains(body, `"TableName":"users"`) || !strings.Contains(body, `"Ada"`) {
+		t.Errorf("origin received body %s", body)
+	}
+
+	// A body changed after signing no longer matches
+	r := h.NewSignedRequest(http.MethodPost, "/", []byte(`{"TableName":"users"}`))
+	r.Header.Set("X-Amz-Target", "DynamoDB_20120810.DeleteTable")
+	tampered := []byte(`{"TableName":"admin"}`)
+	r.Body, r.GetBody = io.NopCloser(bytes.NewReader(tampered)), nil
+	r.ContentLength = int64(len(tampered))
+	res, err := h.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("got status %d for a tampered body, want 403", res.StatusCode)
+	}
+}
+
+// Operations are classified against the DynamoDB model, so StrictOperations rejects targets DynamoDB doesn't have
+func TestDynamoDBStrictOperations(t *testing.T) {
+	h := iamtest.NewHarness(func(originURL string) http_server.AWSServiceProvider {
+		p := http_server.NewDynamoDBProvider()
+		p.OriginHost = originURL
+		p.StrictOperations = true
+		return p
+	})
+	t.Cleanup(h.Close)
+
+	for target, wantStatus := range map[string]int{
+		"DynamoDB_20120810.GetItem":     http.StatusOK,
+		"DynamoDB_20120810.ExportTable": http.StatusNotImplemented,
+	} {
+		r := h.NewSignedRequest(http.MethodPost, "/", []byte(`{"TableName":"users"}`))
+		r.Header.Set("X-Amz-Target", target)
+		res, err := h.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		if res.StatusCode != wantStatus {
+			t.Errorf("%s got status %d, want %d", target, res.StatusCode, wantStatus)
+		}
+	}
+	if n := len(h.Origin.Requests()); n != 1 {
+		t.Errorf("origin received %d requests, want only GetItem", n)
+	}
+}
+
+func newDynamoDBHarness(t *testing.T, maxItemBytes int) *iamtest.Harness {
+	t.Helper()
+	h := iamtest.NewHarness(func(originURL string) http_server.AWSServiceProvider {
+		p := http_server.NewDynamoDBProvider()
+		p.OriginHost = originURL
+		p.MaxItemBytes = maxItemBytes
+		return p
+	})
+	t.Cleanup(h.Close)
+	return h
+}
+
+func doDynamoDB(t *testing.T, h *iamtest.Harness, operation, body string) (*http.Response, string) {
+	t.Helper()
+	r := h.NewSignedRequest(http.MethodPost, "/", []byte(body))
+	r.Header.Set("X-Amz-Target", "DynamoDB_20120810."+operation)
+	r.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	res, err := h.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resBody, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	return res, string(resBody)
+}
+
+func TestDynamoDBMaxItemBytes(t *testing.T) {
+	h := newDynamoDBHarness(t, http_server.DynamoDBMaxItemBytes)
+	big := strings.Repeat("x", http_server.DynamoDBMaxItemBytes)
+
+	tests := []struct {
+		name      string
+		operation string
+		body      string
+		wantCode  string
+	}{
+		{
+			name:      "small PutItem",
+			operation: "PutItem",
+			body:      `{"TableName":"users","Item":{"id":{"S":"user-1"},"name":{"S":"Ada"}}}`,
+		},
+		{
+			name:      "oversized PutItem",
+			operation: "PutItem",
+			body:      `{"TableName":"users","Item":{"id":{"S":"user-1"},"bio":{"S":"` + big + `"}}}`,
+			wantCode:  "ValidationException",
+		},
+		{
+			name:      "oversized nested PutItem",
+			operation: "PutItem",
+			body:      `{"TableName":"users","Item":{"id":{"S":"user-1"},"tags":{"L":[{"S":"` + big[:250*1024] + `"},{"M":{"bio":{"S":"` + big[:250*1024] + `"}}}]}}}`,
+			wantCode:  "ValidationException",
+		},
+		{
+			name:      "oversized UpdateItem",
+			operation: "UpdateItem",
+			body:      `{"TableName":"users","Key":{"id":{"S":"user-1"}},"UpdateExpression":"SET bio = :bio","ExpressionAttributeValues":{":bio":{"S":"` + big + `"}}}`,
+			wantCode:  "ValidationException",
+		},
+		{
+			// Only items being written are sized
+			name:      "large GetItem",
+			operation: "GetItem",
+			body:      `{"TableName":"users","Key":{"id":{"S":"` + big + `"}}}`,
+		},
+		{
+			name:      "malformed PutItem",
+			operation: "PutItem",
+			body:      `{"TableName":"users","Item":{"id":{"Q":"user-1"}}}`,
+			wantCode:  "SerializationException",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(h.Origin.Requests())
+			res, body := doDynamoDB(t, h, tt.operation, tt.body)
+			forwarded := len(h.Origin.Requests()) > before
+
+			if tt.wantCode == "" {
+				if res.StatusCode != http.StatusOK || !forwarded {
+					t.Fatalf("got status %d %s, forwarded %t, want it passed through", res.StatusCode, body, forwarded)
+				}
+				if got := string(h.Origin.Requests()[before].Body); got != tt.body {
+					t.Errorf("origin received a %d byte body, want the %d sent", len(got), len(tt.body))
+				}
+				return
+			}
+			if res.StatusCode != http.StatusBadRequest || !strings.Contains(body, tt.wantCode) {
+				t.Errorf("got %d %s, want a %s", res.StatusCode, body, tt.wantCode)
+			}
+			if forwarded {
+				t.Error("origin received the rejected request")
+			}
+		})
+	}
+
+	res, _ := doDynamoDB(t, h, "PutItem", `{"TableName":"users","Item":{"bio":{"S":"`+big+`"}}}`)
+	if got := res.Header.Get(http_server.RejectReasonHeader); got != string(http_server.RejectionBodyTooLarge) {
+		t.Errorf("got rejection reason %q", got)
+	}
+
+	// 0 disables the check
+	h = newDynamoDBHarness(t, 0)
+	if res, body := doDynamoDB(t, h, "PutItem", `{"TableName":"users","Item":{"bio":{"S":"`+big+`"}}}`); res.StatusCode != http.StatusOK {
+		t.Errorf("got %d %s with MaxItemBytes unset", res.StatusCode, body)
+	}
+}
+
+// DynamoDB's throttling errors reach the client as DynamoDB sent them, so the SDKs recognise and retry them
+func TestDynamoDBThroughputExceededPassthrough(t *testing.T) {
+	h := newDynamoDBHarness(t, http_server.DynamoDBMaxItemBytes)
+	errorBody := `{"__type":"com.amazonaws.dynamodb.v20120810#ProvisionedThroughputExceededException","message":"The level of configured provisioned throughput for the table was exceeded."}`
+	h.Origin.RespondWith(http.StatusBadRequest, http.Header{
+		"Content-Type":     {"application/x-amz-json-1.0"},
+		"X-Amzn-Errortype": {"ProvisionedThroughputExceededException:"},
+		"X-Amzn-Requestid": {"REQUEST1"},
+	}, []byte(errorBody))
+
+	res, body := doDynamoDB(t, h, "PutItem", `{"TableName":"users","Item":{"id":{"S":"user-1"}}}`)
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status %d", res.StatusCode)
+	}
+	if body != errorBody {
+		t.Errorf("got body %s", body)
+	}
+	for header, want := range map[string]string{
+		"Content-Type":     "application/x-amz-json-1.0",
+		"X-Amzn-ErrorType": "ProvisionedThroughputExceededException:",
+		"X-Amzn-RequestId": "REQUEST1",
+	} {
+		if got := res.Header.Get(header); got != want {
+			t.Errorf("got %s %q, want %q", header, got, want)
+		}
+	}
+	if got := res.Header.Get(http_server.RejectReasonHeader); got != "" {
+		t.Errorf("got rejection reason %q for an origin error", got)
+	}
+
+	// The SDK sees the typed error
+	client := newDynamoDBClient(h)
+	_, err := client.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName: aws.String("users"),
+		Key:       map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: "user-1"}},
+	})
+	var throughputErr *types.ProvisionedThroughputExceededException
+	if !errors.As(err, &throughputErr) {
+		t.Errorf("got error %v, want a ProvisionedThroughputExceededException", err)
+	}
+}
+
+// Rejections of JSON protocol requests are JSON errors, which the SDK parses into an API error
+// rather than failing to unmarshal XML
+func TestDynamoDBAccessDeniedParsedBySDK(t *testing.T) {
+	h := newDynamoDBHarness(t, 0)
+	h.Proxy.PolicyLookupFunc = http_server.StaticPolicies(map[string][]http_server.PolicyDocument{
+		iamtest.KeyID: {{Statement: []http_server.PolicyStatement{
+			{Effect: http_server.PolicyAllow, Action: http_server.PolicyStringList{"dynamodb:GetItem"}},
+		}}},
+	})
+
+	_, err := newDynamoDBClient(h).PutItem(context.Background(), &dynamodb.PutItemInput{
+		TableName: aws.String("users"),
+		Item:      map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: "user-1"}},
+	})
+	var apiErr interface {
+		ErrorCode() string
+		ErrorMessage() string
+	}
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("got error %v, want an API error", err)
+	}
+	if apiErr.ErrorCode() != "AccessDenied" || apiErr.ErrorMessage() != "Access Denied" {
+		t.Errorf("got %s: %s", apiErr.ErrorCode(), apiErr.ErrorMessage())
+	}
+
+	res, body := doDynamoDB(t, h, "PutItem", `{"TableName":"users","Item":{"id":{"S":"user-1"}}}`)
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("got status %d", res.StatusCode)
+	}
+	if got := res.Header.Get("X-Amzn-ErrorType"); got != "AccessDenied" {
+		t.Errorf("got X-Amzn-ErrorType %q", got)
+	}
+	if got := res.Header.Get("Content-Type"); !strings.HasPrefix(got, "application/x-amz-json-") {
+		t.Errorf("got Content-Type %q", got)
+	}
+	var jsonErr struct {
+		Type    string `json:"__type"`
+		Message string `json:"message"`
+	}
+	if err = json.Unmarshal([]byte(body), &jsonErr); err != nil {
+		t.Fatalf("error body %s isn't JSON: %v", body, err)
+	}
+	if jsonErr.Type != "AccessDenied" || jsonErr.Message != "Access Denied" {
+		t.Errorf("got error body %s", body)
+	}
+	if n := len(h.Origin.Requests()); n != 0 {
+		t.Errorf("origin received %d requests", n)
+	}
+}
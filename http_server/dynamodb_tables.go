@@ -0,0 +1,122 @@
+package http_server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// dynamoDBTransactActions are the keys of a TransactItems entry that name a table
+var dynamoDBTransactActions = []string{"Get", "Put", "Update", "Delete", "ConditionCheck"}
+
+// readDynamoDBBody buffers the (bounded) request body as top level JSON fields, and restores the body
+func readDynamoDBBody(request *ProxiedRequest) (map[string]json.RawMessage, error) {
+	body, err := io.ReadAll(io.LimitReader(request.Request.Body, maxDynamoDBRequestBytes))
+	if err != nil {
+		return nil, fmt.Errorf("error reading request body: %w", err)
+	}
+	request.Request.Body.Close()
+	request.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(body, &fields); err != nil {
+		return nil, fmt.Errorf("error in json.Unmarshal: %w", err)
+	}
+	return fields, nil
+}
+
+// DynamoDBTableNames returns the tables a DynamoDB request addresses, from TableName, the RequestItems of batch
+// operations, and the TransactItems of transactions, sorted and without duplicates
+func DynamoDBTableNames(request *ProxiedRequest) ([]string, error) {
+	seen := map[string]bool{}
+	err := RewriteDynamoDBTableNames(request, func(table string) string {
+		seen[table] = true
+		return table
+	})
+	if err != nil {
+		return nil, err
+	}
+	tables := make([]string, 0, len(seen))
+	for table := range seen {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	return tables, nil
+}
+
+// RewriteDynamoDBTableNames renames every table the request addresses (e.g. prefixing a tenant), and re-signs the
+// new body. Requests that don't address a table are left untouched.
+func RewriteDynamoDBTableNames(request *ProxiedRequest, rename func(table string) string) error {
+	fields, err := readDynamoDBBody(request)
+	if err != nil {
+		return fmt.Errorf("error in readDynamoDBBody: %w", err)
+	}
+
+	changed := false
+	renameField := func(raw json.RawMessage) (json.RawMessage, error) {
+		var table string
+		if err := json.Unmarshal(raw, &table); err != nil {
+			return nil, fmt.Errorf("error in json.Unmarshal of TableName: %w", err)
+		}
+		renamed := rename(table)
+		if renamed == table {
+			return raw, nil
+		}
+		changed = true
+		return json.Marshal(renamed)
+	}
+
+	if raw, ok := fields["TableName"]; ok {
+		if fields["TableName"], err = renameField(raw); err != nil {
+			return err
+		}
+	}
+
+	if raw, ok := fields["RequestItems"]; ok {
+		var items map[string]json.RawMessage
+		if err = json.Unmarshal(raw, &items); err != nil {
+			return fmt.Errorf("error in json.Unmarshal of RequestItems: %w", err)
+		}
+		renamed := make(map[string]json.RawMessage, len(items))
+		for table, item := range items {
+			newTable := rename(table)
+			changed = changed || newTable != table
+			renamed[newTable] = item
+		}
+		if fields["RequestItems"], err = json.Marshal(renamed); err != nil {
+			return fmt.Errorf("error in json.Marshal of RequestItems: %w", err)
+		}
+	}
+
+	if raw, ok := fields["TransactItems"]; ok {
+		var items []map[string]map[string]json.RawMessage
+		if err = json.Unmarshal(raw, &items); err != nil {
+			return fmt.Errorf("error in json.Unmarshal of TransactItems: %w", err)
+		}
+		for _, item := range items {
+			for _, action := range dynamoDBTransactActions {
+				if params, ok := item[action]; ok && params["TableName"] != nil {
+					if params["TableName"], err = renameField(params["TableName"]); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if fields["TransactItems"], err = json.Marshal(items); err != nil {
+			return fmt.Errorf("error in json.Marshal of TransactItems: %w", err)
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return fmt.Errorf("error in json.Marshal: %w", err)
+	}
+	request.SetBody(body)
+	return nil
+}
@@ -0,0 +1,187 @@
+package http_server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrNoHealthyEndpoints = errors.New("no healthy endpoints")
+
+const (
+	DefaultEndpointFailureThreshold = 3
+	DefaultEndpointCooldown         = 10 * time.Second
+)
+
+// EndpointResolver picks the origin host for a request. Like BaseAWSProvider.OriginHost,
+// the returned host may include a scheme.
+type EndpointResolver interface {
+	ResolveEndpoint(ctx context.Context, request *ProxiedRequest) (string, error)
+}
+
+// EndpointHealth reports whether an endpoint should receive traffic, e.g. from a health checker or circuit breaker
+type EndpointHealth interface {
+	Healthy(endpoint string) bool
+}
+
+// EndpointHealthReporter is told the outcome of each request proxied to an endpoint an EndpointResolver picked,
+// so failing endpoints can be taken out of rotation
+type EndpointHealthReporter interface {
+	ReportEndpointResult(endpoint string, ok bool)
+}
+
+// EndpointHealthMap is an EndpointHealth where endpoints are healthy until marked otherwise. It is also a passive
+// circuit breaker: an endpoint is unhealthy for Cooldown after FailureThreshold consecutive failed requests, then
+// gets traffic again until it fails once more.
+type EndpointHealthMap struct {
+	// FailureThreshold defaults to DefaultEndpointFailureThreshold
+	FailureThreshold int
+	// Cooldown defaults to DefaultEndpointCooldown
+	Cooldown time.Duration
+	Clock    Clock
+
+	mu        sync.Mutex
+	endpoints map[string]*endpointHealthState
+}
+
+type endpointHealthState struct {
+	marked         bool
+	failures       int
+	unhealthyUntil time.Time
+}
+
+func (m *EndpointHealthMap) state(endpoint string) *endpointHealthState {
+	if m.endpoints == nil {
+		m.endpoints = map[string]*endpointHealthState{}
+	}
+	state, ok := m.endpoints[endpoint]
+	if !ok {
+		state = &endpointHealthState{}
+		m.endpoints[endpoint] = state
+	}
+	return state
+}
+
+func (m *EndpointHealthMap) Healthy(endpoint string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, ok := m.endpoints[endpoint]
+	if !ok {
+		return true
+	}
+	return !state.marked && !clockOrReal(m.Clock).Now().Before(state.unhealthyUntil)
+}
+
+// MarkUnhealthy takes the endpoint out of rotation until MarkHealthy
+func (m *EndpointHealthMap) MarkUnhealthy(endpoint string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state(endpoint).marked = true
+}
+
+func (m *EndpointHealthMap) MarkHealthy(endpoint string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.endpoints, endpoint)
+}
+
+func (m *EndpointHealthMap) ReportEndpointResult(endpoint string, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state := m.state(endpoint)
+	if ok {
+		state.failures = 0
+		state.unhealthyUntil = time.Time{}
+		return
+	}
+
+	threshold := m.FailureThreshold
+	if threshold <= 0 {
+		threshold = DefaultEndpointFailureThreshold
+	}
+	cooldown := m.Cooldown
+	if cooldown <= 0 {
+		cooldown = DefaultEndpointCooldown
+	}
+	state.failures++
+	if state.failures >= threshold {
+		state.unhealthyUntil = clockOrReal(m.Clock).Now().Add(cooldown)
+	}
+}
+
+type WeightedEndpoint struct {
+	Host string
+	// Weight is relative to the other endpoints, values < 1 are treated as 1
+	Weight int
+}
+
+// RoundRobinEndpointResolver spreads requests across endpoints using smooth weighted round-robin,
+// so endpoints with equal weights are picked in turn. Unhealthy endpoints are skipped.
+type RoundRobinEndpointResolver struct {
+	// Health defaults to an EndpointHealthMap. If it is an EndpointHealthReporter, it is told the outcome of
+	// each request, so endpoints that keep failing are skipped.
+	Health EndpointHealth
+
+	mu        sync.Mutex
+	endpoints []WeightedEndpoint
+	current   []int
+}
+
+func NewRoundRobinEndpointResolver(endpoints ...WeightedEndpoint) *RoundRobinEndpointResolver {
+	for i := range endpoints {
+		endpoints[i].Weight = max(endpoints[i].Weight, 1)
+	}
+	return &RoundRobinEndpointResolver{
+		Health:    &EndpointHealthMap{},
+		endpoints: endpoints,
+		current:   make([]int, len(endpoints)),
+	}
+}
+
+func (r *RoundRobinEndpointResolver) ResolveEndpoint(context.Context, *ProxiedRequest) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	total := 0
+	chosen := -1
+	for i, endpoint := range r.endpoints {
+		if r.Health != nil && !r.Health.Healthy(endpoint.Host) {
+			continue
+		}
+		r.current[i] += endpoint.Weight
+		total += endpoint.Weight
+		if chosen == -1 || r.current[i] > r.current[chosen] {
+			chosen = i
+		}
+	}
+
+	if chosen == -1 {
+		return "", ErrNoHealthyEndpoints
+	}
+
+	r.current[chosen] -= total
+	return r.endpoints[chosen].Host, nil
+}
+
+func (r *RoundRobinEndpointResolver) ReportEndpointResult(endpoint string, ok bool) {
+	if reporter, isReporter := r.Health.(EndpointHealthReporter); isReporter {
+		reporter.ReportEndpointResult(endpoint, ok)
+	}
+}
+
+// reportEndpointResult tells the resolver whether the origin it picked could serve the request. Requests
+// cancelled by the client (or a hedge) say nothing about the origin, so aren't reported.
+func reportEndpointResult(ctx context.Context, resolver EndpointResolver, endpoint string, res *http.Response, err error) {
+	reporter, ok := resolver.(EndpointHealthReporter)
+	if !ok || ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		err = originTransportError(err)
+		reporter.ReportEndpointResult(endpoint, !errors.Is(err, ErrAWSOriginUnavailable) && !errors.Is(err, ErrAWSRequestTimeout))
+		return
+	}
+	reporter.ReportEndpointResult(endpoint, res.StatusCode < http.StatusInternalServerError)
+}
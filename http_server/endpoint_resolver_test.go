@@ -0,0 +1,158 @@
+package http_server_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+func TestRoundRobinEndpointDistribution(t *testing.T) {
+	r := http_server.NewRoundRobinEndpointResolver(
+		http_server.WeightedEndpoint{Host: "a"},
+		http_server.WeightedEndpoint{Host: "b", Weight: 1},
+		http_server.WeightedEndpoint{Host: "c", Weight: 2},
+	)
+
+	counts := map[string]int{}
+	var last string
+	for i := 0; i < 40; i++ {
+		host, err := r.ResolveEndpoint(context.Background(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if host == last && host != "c" {
+			t.Errorf("request %d picked %s twice in a row", i, host)
+		}
+		counts[host]++
+		last = host
+	}
+	if counts["a"] != 10 || counts["b"] != 10 || counts["c"] != 20 {
+		t.Errorf("got distribution %v, want 10/10/20", counts)
+	}
+}
+
+func TestRoundRobinEndpointSkipsUnhealthy(t *testing.T) {
+	health := &http_server.EndpointHealthMap{}
+	r := http_server.NewRoundRobinEndpointResolver(
+		http_server.WeightedEndpoint{Host: "a"},
+		http_server.WeightedEndpoint{Host: "b"},
+	)
+	r.Health = health
+
+	health.MarkUnhealthy("a")
+	for i := 0; i < 4; i++ {
+		if host, err := r.ResolveEndpoint(context.Background(), nil); err != nil || host != "b" {
+			t.Fatalf("got %q %v, want the healthy endpoint", host, err)
+		}
+	}
+
+	health.MarkUnhealthy("b")
+	if _, err := r.ResolveEndpoint(context.Background(), nil); err != http_server.ErrNoHealthyEndpoints {
+		t.Fatalf("got %v, want ErrNoHealthyEndpoints", err)
+	}
+
+	health.MarkHealthy("a")
+	if host, err := r.ResolveEndpoint(context.Background(), nil); err != nil || host != "a" {
+		t.Fatalf("got %q %v once marked healthy", host, err)
+	}
+}
+
+// newEndpointsHarness proxies S3 requests across endpoints, with a health map whose clock only moves when told to
+func newEndpointsHarness(t *testing.T, endpoints ...string) (*iamtest.Harness, *http_server.FakeClock) {
+	t.Helper()
+	var weighted []http_server.WeightedEndpoint
+	for _, endpoint := range endpoints {
+		weighted = append(weighted, http_server.WeightedEndpoint{Host: endpoint})
+	}
+	clock := http_server.NewFakeClock(time.Now())
+	resolver := http_server.NewRoundRobinEndpointResolver(weighted...)
+	resolver.Health = &http_server.EndpointHealthMap{FailureThreshold: 2, Cooldown: time.Minute, Clock: clock}
+
+	h := iamtest.NewHarness(func(string) http_server.AWSServiceProvider {
+		p := http_server.NewS3Provider()
+		p.Endpoints = resolver
+		return p
+	})
+	t.Cleanup(h.Close)
+	return h, clock
+}
+
+func countingOrigin(t *testing.T, status int) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var count atomic.Int64
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count.Add(1)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(origin.Close)
+	return origin, &count
+}
+
+func doEndpointRequest(t *testing.T, h *iamtest.Harness) *http.Response {
+	t.Helper()
+	res, err := h.Do(h.NewSignedRequest(http.MethodGet, "/bucket/key", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	return res
+}
+
+// Endpoints that keep failing are taken out of rotation until their cooldown passes
+func TestRoundRobinEndpointHealthFeedback(t *testing.T) {
+	healthy, healthyCount := countingOrigin(t, http.StatusOK)
+	failing, failingCount := countingOrigin(t, http.StatusServiceUnavailable)
+	h, clock := newEndpointsHarness(t, healthy.URL, failing.URL)
+
+	for i := 0; i < 10; i++ {
+		doEndpointRequest(t, h)
+	}
+	if n := failingCount.Load(); n != 2 {
+		t.Fatalf("failing endpoint got %d requests, want it skipped after 2 failures", n)
+	}
+	if n := healthyCount.Load(); n != 8 {
+		t.Fatalf("healthy endpoint got %d requests", n)
+	}
+
+	clock.Advance(time.Minute)
+	for i := 0; i < 4; i++ {
+		doEndpointRequest(t, h)
+	}
+	// It gets a request once the cooldown passes, and is skipped again when that fails
+	if n := failingCount.Load(); n != 3 {
+		t.Errorf("failing endpoint got %d requests, want 1 more after the cooldown", n)
+	}
+}
+
+// An origin that can't be reached counts as a failure, and once every endpoint is out of rotation the client
+// gets a retryable 503 rather than an InternalError
+func TestRoundRobinNoHealthyEndpoints(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	h, _ := newEndpointsHarness(t, down.URL)
+
+	for i := 0; i < 2; i++ {
+		if res := doEndpointRequest(t, h); res.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("got status %d for an unreachable origin", res.StatusCode)
+		}
+	}
+
+	res, err := h.Do(h.NewSignedRequest(http.MethodGet, "/bucket/key", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable || !strings.Contains(string(body), "<Code>ServiceUnavailable</Code>") {
+		t.Fatalf("got %d %s, want ServiceUnavailable with no healthy endpoints", res.StatusCode, body)
+	}
+}
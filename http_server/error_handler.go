@@ -2,15 +2,29 @@ package http_server
 
 import (
 	"errors"
+	"fmt"
 	"github.com/labstack/echo/v4"
 	"github.com/rs/zerolog"
 	"net/http"
+	"strings"
+
+	"github.com/danthegoodman1/IAMTheService/utils"
 )
 
 func customHTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	// AWS clients need errors in the shape of the service to parse them
+	if isAWSRequest(c.Request()) {
+		writeAWSError(c.Response(), c.Request(), ProtocolForService(requestService(c.Request())), toAWSError(c, err))
+		return
+	}
+
 	var he *echo.HTTPError
 	if errors.As(err, &he) {
-		c.String(he.Code, he.Message.(string))
+		c.String(he.Code, fmt.Sprint(he.Message))
 		return
 	}
 
@@ -19,3 +33,44 @@ func customHTTPErrorHandler(err error, c echo.Context) {
 
 	c.String(http.StatusInternalServerError, "Something went wrong internally, an error has been logged")
 }
+
+// isAWSRequest is whether the request was signed by an AWS client
+func isAWSRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-") || isPresignedRequest(r) || isPostPolicyRequest(r)
+}
+
+// toAWSError converts err to the AWSError to render, logging internal errors
+func toAWSError(c echo.Context, err error) *AWSError {
+	if awsErr, ok := utils.AsErr[*AWSError](err); ok {
+		return awsErr
+	}
+
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		return NewAWSError(he.Code, awsErrorCodeForStatus(he.Code), fmt.Sprint(he.Message))
+	}
+
+	zerolog.Ctx(c.Request().Context()).Error().Err(err).Msg("unhandled internal error")
+	return ErrAWSInternalError
+}
+
+func awsErrorCodeForStatus(statusCode int) string {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return "InvalidRequest"
+	case http.StatusForbidden, http.StatusUnauthorized:
+		return "AccessDenied"
+	case http.StatusNotFound:
+		return "NotFound"
+	case http.StatusMethodNotAllowed:
+		return "MethodNotAllowed"
+	case http.StatusServiceUnavailable:
+		return "ServiceUnavailable"
+	case http.StatusGatewayTimeout:
+		return "GatewayTimeout"
+	}
+	if statusCode >= 500 {
+		return "InternalError"
+	}
+	return strings.ReplaceAll(http.StatusText(statusCode), " ", "")
+}
@@ -0,0 +1,175 @@
+package http_server_test
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+// TestExpectContinueRejectedByOrigin sends a signed upload expecting 100-continue, which the origin rejects
+// without reading. The client must get the rejection without being asked for the body.
+func TestExpectContinueRejectedByOrigin(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		declaredHash bool
+		verify       bool
+	}{
+		{name: "declared hash", declaredHash: true},
+		{name: "unsigned payload"},
+		// A small body isn't buffered to verify it, since that would continue the client
+		{name: "payload verification", declaredHash: true, verify: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// Responding before reading the body declines the 100-continue
+				w.WriteHeader(http.StatusPreconditionFailed)
+			}))
+			defer origin.Close()
+			h := iamtest.NewHarness(func(string) http_server.AWSServiceProvider {
+				p := http_server.NewS3Provider()
+				p.OriginHost = origin.URL
+				return p
+			})
+			defer h.Close()
+			if tt.verify {
+				h.Proxy.PayloadVerification = &http_server.PayloadVerification{}
+			}
+
+			body := bytes.Repeat([]byte("x"), 1024)
+			r, _ := http.NewRequest(http.MethodPut, h.Server.URL+"/bucket/key", nil)
+			if tt.declaredHash {
+				sum := sha256.Sum256(body)
+				r.Header.Set("x-amz-content-sha256", hex.EncodeToString(sum[:]))
+			}
+			http_server.SignRequest(r, iamtest.KeyID, iamtest.KeySecret, iamtest.Region, "s3", time.Now())
+
+			conn, err := net.Dial("tcp", h.Server.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			var head strings.Builder
+			fmt.Fprintf(&head, "PUT /bucket/key HTTP/1.1\r\nHost: %s\r\nContent-Length: %d\r\nExpect: 100-continue\r\n", r.Host, len(body))
+			r.Header.Write(&head)
+			head.WriteString("\r\n")
+			if _, err = io.WriteString(conn, head.String()); err != nil {
+				t.Fatal(err)
+			}
+
+			// The body is never sent, so reading it would leave the response hanging
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != http.StatusPreconditionFailed {
+				t.Fatalf("got status %d, want the origin's 412 before sending the body", res.StatusCode)
+			}
+		})
+	}
+}
+
+// TestExpectContinueAcceptedByOrigin is the handshake proxied end to end: the client holds its body until the
+// origin's 100 Continue reaches it through the proxy, and the origin then gets the body intact
+func TestExpectContinueAcceptedByOrigin(t *testing.T) {
+	body := bytes.Repeat([]byte("continued upload "), 64)
+
+	// The origin answers the handshake itself rather than leaving it to net/http
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	type received struct {
+		expect string
+		body   []byte
+		err    error
+	}
+	originReceived := make(chan received, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			originReceived <- received{err: err}
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			originReceived <- received{err: err}
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 100 Continue\r\n\r\n")
+		got, err := io.ReadAll(req.Body)
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+		originReceived <- received{expect: req.Header.Get("Expect"), body: got, err: err}
+	}()
+
+	h := iamtest.NewHarness(func(string) http_server.AWSServiceProvider {
+		p := http_server.NewS3Provider()
+		p.OriginHost = "http://" + listener.Addr().String()
+		return p
+	})
+	defer h.Close()
+
+	sum := sha256.Sum256(body)
+	r, _ := http.NewRequest(http.MethodPut, h.Server.URL+"/bucket/key", nil)
+	r.Header.Set("x-amz-content-sha256", hex.EncodeToString(sum[:]))
+	http_server.SignRequest(r, iamtest.KeyID, iamtest.KeySecret, iamtest.Region, "s3", time.Now())
+
+	conn, err := net.Dial("tcp", h.Server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var head strings.Builder
+	fmt.Fprintf(&head, "PUT /bucket/key HTTP/1.1\r\nHost: %s\r\nContent-Length: %d\r\nExpect: 100-continue\r\n", r.Host, len(body))
+	r.Header.Write(&head)
+	head.WriteString("\r\n")
+	if _, err = io.WriteString(conn, head.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusContinue {
+		t.Fatalf("got status %d, want 100 Continue before sending the body", res.StatusCode)
+	}
+	if _, err = conn.Write(body); err != nil {
+		t.Fatal(err)
+	}
+	if res, err = http.ReadResponse(reader, nil); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want the origin's 200", res.StatusCode)
+	}
+
+	got := <-originReceived
+	if got.err != nil {
+		t.Fatal(got.err)
+	}
+	if !strings.EqualFold(got.expect, "100-continue") {
+		t.Errorf("origin got Expect %q", got.expect)
+	}
+	if !bytes.Equal(got.body, body) {
+		t.Errorf("origin got a %d byte body, want the %d byte upload", len(got.body), len(body))
+	}
+}
@@ -0,0 +1,102 @@
+package http_server_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+)
+
+// waitForKey polls the provider until keyID has the secret want
+func waitForKey(t *testing.T, p *http_server.FileLookupProvider, keyID, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, err := p.LookupKey(context.Background(), keyID)
+		if err == nil && got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %q, %v for %s, want %q", got, err, keyID, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFileLookupProviderHotReload(t *testing.T) {
+	tests := []struct {
+		file      string
+		first     string
+		rotated   string
+		malformed string
+		fixed     string
+	}{
+		{
+			file:      "keys.json",
+			first:     `{"keys": {"AKIDEXAMPLE": "secret1"}, "hosts": {"s3.example.com": "s3.amazonaws.com"}}`,
+			rotated:   `{"keys": {"AKIDEXAMPLE": "secret2"}, "hosts": {"s3.example.com": "s3.amazonaws.com"}}`,
+			malformed: `{"keys": {"AKIDEXAMPLE": "secret3"`,
+			fixed:     `{"keys": {"AKIDEXAMPLE": "secret3"}}`,
+		},
+		{
+			file:      "keys.yaml",
+			first:     "keys:\n  AKIDEXAMPLE: secret1\nhosts:\n  s3.example.com: s3.amazonaws.com\n",
+			rotated:   "keys:\n  AKIDEXAMPLE: secret2\nhosts:\n  s3.example.com: s3.amazonaws.com\n",
+			malformed: "keys:\n  AKIDEXAMPLE secret3\n",
+			fixed:     "keys:\n  AKIDEXAMPLE: secret3\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			write := func(content string) {
+				t.Helper()
+				if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			write(tt.first)
+			p, err := http_server.NewFileLookupProvider(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go p.Watch(ctx, 10*time.Millisecond)
+			waitForKey(t, p, "AKIDEXAMPLE", "secret1")
+
+			// A rotated key is picked up
+			write(tt.rotated)
+			waitForKey(t, p, "AKIDEXAMPLE", "secret2")
+
+			// A file that doesn't parse keeps the last good contents
+			write(tt.malformed)
+			if _, err := p.Reload(); err == nil {
+				t.Fatal("reloaded a malformed file")
+			}
+			time.Sleep(50 * time.Millisecond)
+			waitForKey(t, p, "AKIDEXAMPLE", "secret2")
+			if got, err := p.LookupHost(ctx, "s3.example.com"); err != nil || got != "s3.amazonaws.com" {
+				t.Errorf("got host %q, %v after a malformed reload", got, err)
+			}
+
+			// Fixing the file loads it
+			write(tt.fixed)
+			waitForKey(t, p, "AKIDEXAMPLE", "secret3")
+			if _, err := p.LookupHost(ctx, "s3.example.com"); !errors.Is(err, http_server.ErrKeyNotFound) {
+				t.Errorf("got %v for a host removed from the file, want ErrKeyNotFound", err)
+			}
+		})
+	}
+
+	// A file that doesn't parse to begin with fails the provider
+	path := filepath.Join(t.TempDir(), "keys.json")
+	os.WriteFile(path, []byte(`{"keys":`), 0o600)
+	if _, err := http_server.NewFileLookupProvider(path); err == nil {
+		t.Error("loaded a malformed file")
+	}
+}
@@ -0,0 +1,88 @@
+package http_server
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// forwardedFor computes the X-Forwarded-* headers for the outbound request and the real client IP.
+// Inbound X-Forwarded-* headers are only kept if the peer is a trusted proxy, otherwise they could be spoofed.
+func forwardedFor(r *http.Request, trustedProxies []netip.Prefix) (http.Header, string) {
+	peer := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		peer = host
+	}
+
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+
+	header := http.Header{}
+	header.Set("X-Forwarded-For", peer)
+	header.Set("X-Forwarded-Host", r.Host)
+	header.Set("X-Forwarded-Proto", proto)
+	clientIP := peer
+
+	if !isTrustedProxy(peer, trustedProxies) {
+		return header, clientIP
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		chain := strings.Join(xff, ", ")
+		header.Set("X-Forwarded-For", chain+", "+peer)
+
+		// The client is the right-most address that isn't one of our trusted proxies
+		hops := strings.Split(chain, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			clientIP = strings.TrimSpace(hops[i])
+			if !isTrustedProxy(clientIP, trustedProxies) {
+				break
+			}
+		}
+	}
+	if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+		header.Set("X-Forwarded-Host", host)
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		header.Set("X-Forwarded-Proto", proto)
+	}
+
+	return header, clientIP
+}
+
+// signedHost is the Host the client sent (and signed), from the header a trusted proxy that rewrote the Host
+// put it in. It is "" if the header isn't configured or set, or the peer isn't trusted to set it.
+func signedHost(r *http.Request, header string, trustedProxies []netip.Prefix) string {
+	if header == "" {
+		return ""
+	}
+	host := strings.TrimSpace(r.Header.Get(header))
+	if host == "" {
+		return ""
+	}
+	peer := r.RemoteAddr
+	if addr, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		peer = addr
+	}
+	if !isTrustedProxy(peer, trustedProxies) {
+		return ""
+	}
+	return host
+}
+
+func isTrustedProxy(ip string, trustedProxies []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
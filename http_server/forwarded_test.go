@@ -0,0 +1,153 @@
+package http_server_test
+
+import (
+	"io"
+	"net/http"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+func TestForwardedHeaders(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		inbound        http.Header
+		wantFor        string
+		wantHost       string
+		wantProto      string
+		wantClientIP   string
+	}{
+		{
+			name:         "no inbound headers",
+			wantFor:      "127.0.0.1",
+			wantProto:    "http",
+			wantClientIP: "127.0.0.1",
+		},
+		{
+			name: "spoofed by an untrusted peer",
+			inbound: http.Header{
+				"X-Forwarded-For":   {"203.0.113.7"},
+				"X-Forwarded-Host":  {"spoofed.example.com"},
+				"X-Forwarded-Proto": {"https"},
+			},
+			wantFor:      "127.0.0.1",
+			wantProto:    "http",
+			wantClientIP: "127.0.0.1",
+		},
+		{
+			name:           "appended for a trusted peer",
+			trustedProxies: []string{"127.0.0.0/8", "10.0.0.0/8"},
+			inbound: http.Header{
+				"X-Forwarded-For":   {"198.51.100.1, 203.0.113.7", "10.0.0.1"},
+				"X-Forwarded-Host":  {"s3.example.com"},
+				"X-Forwarded-Proto": {"https"},
+			},
+			wantFor:   "198.51.100.1, 203.0.113.7, 10.0.0.1, 127.0.0.1",
+			wantHost:  "s3.example.com",
+			wantProto: "https",
+			// The right-most untrusted hop, the left-most could be spoofed by the client
+			wantClientIP: "203.0.113.7",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newS3Harness(t)
+			records := make(auditRecorder, 1)
+			h.Proxy.AuditSink = records
+			for _, prefix := range tt.trustedProxies {
+				h.Proxy.TrustedProxies = append(h.Proxy.TrustedProxies, netip.MustParsePrefix(prefix))
+			}
+
+			r := h.NewSignedRequest(http.MethodGet, "/bucket/key", nil)
+			for header, values := range tt.inbound {
+				r.Header[header] = values
+			}
+			res, err := h.Do(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+
+			requests := h.Origin.Requests()
+			if len(requests) != 1 {
+				t.Fatalf("origin received %d requests", len(requests))
+			}
+			wantHost := tt.wantHost
+			if wantHost == "" {
+				wantHost = strings.TrimPrefix(h.Server.URL, "http://")
+			}
+			for header, want := range map[string]string{
+				"X-Forwarded-For":   tt.wantFor,
+				"X-Forwarded-Host":  wantHost,
+				"X-Forwarded-Proto": tt.wantProto,
+			} {
+				if got := strings.Join(requests[0].Header.Values(header), ", "); got != want {
+					t.Errorf("origin got %s %q, want %q", header, got, want)
+				}
+			}
+			if got := records.next(t).ClientIP; got != tt.wantClientIP {
+				t.Errorf("got audited client IP %q, want %q", got, tt.wantClientIP)
+			}
+		})
+	}
+}
+
+// A load balancer in front of the proxy rewrites the Host the client signed, and passes it on in the
+// SignedHostHeader, which is only honored from TrustedProxies
+func TestSignedHostHeaderBehindLoadBalancer(t *testing.T) {
+	tests := []struct {
+		name             string
+		signedHostHeader string
+		trustedProxies   []string
+		wantStatus       int
+	}{
+		{name: "trusted load balancer", signedHostHeader: "X-Forwarded-Host", trustedProxies: []string{"127.0.0.0/8"}, wantStatus: http.StatusOK},
+		{name: "untrusted peer", signedHostHeader: "X-Forwarded-Host", trustedProxies: []string{"10.0.0.0/8"}, wantStatus: http.StatusForbidden},
+		{name: "header not configured", trustedProxies: []string{"127.0.0.0/8"}, wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newS3Harness(t)
+			h.Proxy.SignedHostHeader = tt.signedHostHeader
+			for _, prefix := range tt.trustedProxies {
+				h.Proxy.TrustedProxies = append(h.Proxy.TrustedProxies, netip.MustParsePrefix(prefix))
+			}
+
+			// Signed for the public host, then sent to the proxy the way the load balancer forwards it
+			r := iamtest.NewSignedRequest(http.MethodGet, "http://s3.example.com/bucket/key", nil, h.Service)
+			r.URL.Host = strings.TrimPrefix(h.Server.URL, "http://")
+			r.Host = "internal-lb:8080"
+			r.Header.Set("X-Forwarded-Host", "s3.example.com")
+			res, err := h.Do(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got %d %s, want %d", res.StatusCode, body, tt.wantStatus)
+			}
+
+			requests := h.Origin.Requests()
+			if tt.wantStatus != http.StatusOK {
+				if len(requests) != 0 {
+					t.Errorf("origin received %d requests", len(requests))
+				}
+				return
+			}
+			if len(requests) != 1 {
+				t.Fatalf("origin received %d requests", len(requests))
+			}
+			if requests[0].Path != "/bucket/key" {
+				t.Errorf("origin got path %q", requests[0].Path)
+			}
+			if got := requests[0].Header.Get("X-Forwarded-Host"); got != "s3.example.com" {
+				t.Errorf("origin got X-Forwarded-Host %q", got)
+			}
+		})
+	}
+}
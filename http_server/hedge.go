@@ -0,0 +1,153 @@
+package http_server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+// maxHedgeBodyBytes is the largest request body that will be buffered so it can be sent twice
+const maxHedgeBodyBytes = 64 * 1024
+
+// DefaultHedgeOperations are idempotent reads that are safe to hedge
+var DefaultHedgeOperations = []string{"GetObject", "HeadObject", "ListObjects", "ListObjectsV2", "GetItem", "BatchGetItem", "Query"}
+
+// HedgePolicy sends a second (hedged) request to the origin if the first hasn't responded after Delay,
+// using whichever responds first and cancelling the other. This cuts tail latency for latency-sensitive reads.
+type HedgePolicy struct {
+	// Delay before hedging, e.g. the origin's p95 latency
+	Delay time.Duration
+	// MaxInFlightHedges bounds the hedged requests across all requests, so a slow origin doesn't get double the load.
+	// 0 is unlimited.
+	MaxInFlightHedges int64
+	// Operations that can be hedged, defaults to DefaultHedgeOperations. Only add idempotent operations.
+	Operations []string
+	// Endpoints optionally picks an alternate origin for the hedged request
+	Endpoints EndpointResolver
+
+	inFlight atomic.Int64
+}
+
+func (h *HedgePolicy) shouldHedge(r *ProxiedRequest) bool {
+	operations := h.Operations
+	if operations == nil {
+		operations = DefaultHedgeOperations
+	}
+	return lo.Contains(operations, r.Operation) &&
+		r.Request.ContentLength >= 0 && r.Request.ContentLength <= maxHedgeBodyBytes
+}
+
+func (h *HedgePolicy) acquire() bool {
+	if h.inFlight.Add(1) > h.MaxInFlightHedges && h.MaxInFlightHedges > 0 {
+		h.inFlight.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (h *HedgePolicy) release() {
+	h.inFlight.Add(-1)
+}
+
+type hedgeResult struct {
+	// attempt indexes the cancel funcs of the attempts
+	attempt int
+	res     *http.Response
+	err     error
+}
+
+// cancelOnClose cancels the context of the winning request once its body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+func (r *ProxiedRequest) doHedgedRequest(ctx context.Context, host string) (*http.Response, error) {
+	h := r.hedgePolicy
+
+	// Buffer the (small) body so both requests can send it
+	body, err := io.ReadAll(r.Request.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading body to hedge: %w", err)
+	}
+
+	results := make(chan hedgeResult, 2)
+	var cancels []context.CancelFunc
+	attempt := func(hedge bool) {
+		attemptCtx, cancel := context.WithCancel(ctx)
+		i := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			attemptHost := host
+			resolved := false
+			if hedge {
+				defer h.release()
+				if h.Endpoints != nil {
+					if endpoint, err := h.Endpoints.ResolveEndpoint(attemptCtx, r); err == nil {
+						attemptHost, resolved = endpoint, true
+					}
+				}
+			}
+			res, err := r.doProxiedRequest(attemptCtx, attemptHost, bytes.NewReader(body))
+			if resolved {
+				reportEndpointResult(attemptCtx, h.Endpoints, attemptHost, res, err)
+			}
+			results <- hedgeResult{attempt: i, res: res, err: err}
+		}()
+	}
+
+	attempt(false)
+	inFlight := 1
+	timer := time.NewTimer(h.Delay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			if h.acquire() {
+				attempt(true)
+				inFlight++
+			}
+		case result := <-results:
+			inFlight--
+			if result.err != nil {
+				cancels[result.attempt]()
+				if inFlight == 0 {
+					return nil, result.err
+				}
+				// The other request may still succeed
+				continue
+			}
+
+			// Cancel the losers now rather than once they return, so a stuck origin request is abandoned
+			for i, cancel := range cancels {
+				if i != result.attempt {
+					cancel()
+				}
+			}
+			if inFlight > 0 {
+				// A loser may have responded before it was cancelled
+				go func(losers int) {
+					for i := 0; i < losers; i++ {
+						if loser := <-results; loser.res != nil {
+							loser.res.Body.Close()
+						}
+					}
+				}(inFlight)
+			}
+			result.res.Body = cancelOnClose{ReadCloser: result.res.Body, cancel: cancels[result.attempt]}
+			return result.res, nil
+		}
+	}
+}
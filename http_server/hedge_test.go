@@ -0,0 +1,96 @@
+package http_server_test
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+func newS3Harness(t *testing.T) *iamtest.Harness {
+	t.Helper()
+	h := iamtest.NewHarness(func(originURL string) http_server.AWSServiceProvider {
+		p := http_server.NewS3Provider()
+		p.OriginHost = originURL
+		return p
+	})
+	t.Cleanup(h.Close)
+	return h
+}
+
+func TestHedgeCancelsLoserWhenWinnerResponds(t *testing.T) {
+	h := newS3Harness(t)
+	// MaxInFlightHedges 0 is unlimited
+	h.Proxy.HedgePolicy = &http_server.HedgePolicy{Delay: 20 * time.Millisecond}
+
+	var attempts atomic.Int32
+	loserCancelled := make(chan struct{})
+	h.Origin.Handle(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			// The first attempt is stuck until the proxy gives up on it
+			select {
+			case <-r.Context().Done():
+				close(loserCancelled)
+			case <-time.After(10 * time.Second):
+			}
+			return
+		}
+		w.Write([]byte("hedged"))
+	})
+
+	res, err := h.Do(h.NewSignedRequest(http.MethodGet, "/bucket/key", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || string(body) != "hedged" {
+		t.Fatalf("got %d %q, want the hedged response", res.StatusCode, body)
+	}
+
+	select {
+	case <-loserCancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the losing attempt was not cancelled")
+	}
+}
+
+func TestHedgeMaxInFlightHedges(t *testing.T) {
+	h := newS3Harness(t)
+	policy := &http_server.HedgePolicy{Delay: 10 * time.Millisecond, MaxInFlightHedges: 1}
+	h.Proxy.HedgePolicy = policy
+
+	release := make(chan struct{})
+	h.Origin.Handle(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+
+	// Two slow requests, only one of which may hedge
+	done := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go func() {
+			if res, err := h.Do(h.NewSignedRequest(http.MethodGet, "/bucket/key", nil)); err == nil {
+				res.Body.Close()
+			}
+			done <- struct{}{}
+		}()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(h.Origin.Requests()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := len(h.Origin.Requests()); n != 3 {
+		t.Errorf("origin received %d requests, want 2 originals and 1 hedge", n)
+	}
+	close(release)
+	<-done
+	<-done
+}
@@ -0,0 +1,136 @@
+package http_server
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of HTTPLookupProvider requests signed with an HMACSecret
+const (
+	HTTPLookupTimestampHeader = "X-IAM-Timestamp"
+	HTTPLookupSignatureHeader = "X-IAM-Signature"
+)
+
+// maxHTTPLookupResponseBytes bounds the response of a lookup endpoint
+const maxHTTPLookupResponseBytes = 64 * 1024
+
+// HTTPLookupProvider is a LookupProvider resolving values (e.g. key secrets or host mappings) from an
+// existing control plane. It GETs URL with {key} replaced by the escaped key, expecting a JSON
+// {"value": "..."} response, with a 404 for missing keys.
+//
+// For mTLS, use an HTTPClient from NewOriginHTTPClient with ClientCertPEM and ClientKeyPEM.
+// With an HMACSecret, requests carry the unix time in X-IAM-Timestamp and the hex
+// HMAC-SHA256 of "<timestamp>\n<method>\n<request uri>" in X-IAM-Signature, so the endpoint
+// can tell they came from the proxy.
+type HTTPLookupProvider struct {
+	// URL is e.g. https://control-plane/keys/{key}
+	URL string
+	// HMACSecret optionally signs requests
+	HMACSecret []byte
+	// HTTPClient defaults to http.DefaultClient
+	HTTPClient *http.Client
+	// Header is optionally added to requests, e.g. an Authorization header
+	Header http.Header
+	// TTL caches found values, 0 disables it
+	TTL time.Duration
+	// NegativeTTL caches missing values, 0 disables it
+	NegativeTTL time.Duration
+	// MaxCacheEntries bounds the local cache, defaults to DefaultLookupMaxCacheEntries
+	MaxCacheEntries int
+	// Clock defaults to RealClock
+	Clock Clock
+
+	cache lookupCache[string]
+}
+
+type httpLookupResponse struct {
+	Value *string `json:"value"`
+}
+
+// Lookup returns the value of the key. Missing keys return ErrKeyNotFound,
+// and network failures, 429s and 5xxs return a *RetryableError.
+func (p *HTTPLookupProvider) Lookup(ctx context.Context, key string) (string, error) {
+	now := clockOrReal(p.Clock).Now()
+	entry, ok := p.cache.get(key, now)
+	recordLookupCacheResult("http", ok)
+	if ok {
+		if !entry.found {
+			return "", ErrKeyNotFound
+		}
+		return entry.value, nil
+	}
+
+	value, err := p.fetch(ctx, key, now)
+	if errors.Is(err, ErrKeyNotFound) {
+		p.cache.put(key, lookupCacheEntry[string]{}, now, p.NegativeTTL, p.MaxCacheEntries)
+		return "", ErrKeyNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	p.cache.put(key, lookupCacheEntry[string]{value: value, found: true}, now, p.TTL, p.MaxCacheEntries)
+	return value, nil
+}
+
+func (p *HTTPLookupProvider) fetch(ctx context.Context, key string, now time.Time) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.ReplaceAll(p.URL, "{key}", url.PathEscape(key)), nil)
+	if err != nil {
+		return "", fmt.Errorf("error in http.NewRequestWithContext: %w", err)
+	}
+	for header, vals := range p.Header {
+		req.Header[header] = vals
+	}
+	req.Header.Set("Accept", "application/json")
+	if len(p.HMACSecret) > 0 {
+		timestamp := strconv.FormatInt(now.Unix(), 10)
+		req.Header.Set(HTTPLookupTimestampHeader, timestamp)
+		req.Header.Set(HTTPLookupSignatureHeader, hex.EncodeToString(getHMAC(p.HMACSecret, []byte(timestamp+"\n"+req.Method+"\n"+req.URL.RequestURI()))))
+	}
+
+	client := p.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) {
+			return "", &RetryableError{Err: err}
+		}
+		return "", fmt.Errorf("error in client.Do: %w", err)
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return "", ErrKeyNotFound
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return "", &RetryableError{Err: fmt.Errorf("lookup endpoint returned status %d", res.StatusCode)}
+	case res.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("lookup endpoint returned status %d", res.StatusCode)
+	}
+
+	var body httpLookupResponse
+	if err = json.NewDecoder(io.LimitReader(res.Body, maxHTTPLookupResponseBytes)).Decode(&body); err != nil {
+		return "", fmt.Errorf("error decoding lookup response: %w", err)
+	}
+	if body.Value == nil {
+		return "", fmt.Errorf("lookup response has no value")
+	}
+	return *body.Value, nil
+}
+
+// Invalidate drops the cached value of the key, e.g. after revoking it
+func (p *HTTPLookupProvider) Invalidate(key string) {
+	p.cache.invalidate(key)
+}
@@ -0,0 +1,207 @@
+package http_server_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+)
+
+// newLookupEndpoint serves values as the control plane HTTPLookupProvider queries, rejecting requests
+// that aren't signed with secret
+func newLookupEndpoint(t *testing.T, secret []byte, values map[string]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timestamp := r.Header.Get(http_server.HTTPLookupTimestampHeader)
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(timestamp + "\n" + r.Method + "\n" + r.URL.RequestURI()))
+		signature, _ := hex.DecodeString(r.Header.Get(http_server.HTTPLookupSignatureHeader))
+		unix, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || time.Since(time.Unix(unix, 0)).Abs() > time.Minute || !hmac.Equal(signature, mac.Sum(nil)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		value, ok := values[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"value":"` + value + `"}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHTTPLookupProviderSignsRequests(t *testing.T) {
+	secret := []byte("lookup-secret")
+	server := newLookupEndpoint(t, secret, map[string]string{"/keys/AKIDEXAMPLE": "secret1", "/keys/a/b": "secret2"})
+
+	p := &http_server.HTTPLookupProvider{URL: server.URL + "/keys/{key}", HMACSecret: secret}
+	for key, want := range map[string]string{"AKIDEXAMPLE": "secret1", "a/b": "secret2"} {
+		got, err := p.Lookup(context.Background(), key)
+		if err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+		if got != want {
+			t.Errorf("%s: got %q, want %q", key, got, want)
+		}
+	}
+	if _, err := p.Lookup(context.Background(), "AKIDMISSING"); !errors.Is(err, http_server.ErrKeyNotFound) {
+		t.Errorf("got %v, want ErrKeyNotFound", err)
+	}
+
+	// The endpoint rejects requests signed with another secret, which isn't a missing key
+	p = &http_server.HTTPLookupProvider{URL: server.URL + "/keys/{key}", HMACSecret: []byte("wrong-secret")}
+	if _, err := p.Lookup(context.Background(), "AKIDEXAMPLE"); err == nil || errors.Is(err, http_server.ErrKeyNotFound) {
+		t.Errorf("got %v with the wrong secret, want a lookup failure", err)
+	}
+}
+
+func TestHTTPLookupProviderStatuses(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		body          string
+		wantNotFound  bool
+		wantRetryable bool
+	}{
+		{name: "not found", status: http.StatusNotFound, wantNotFound: true},
+		{name: "internal error", status: http.StatusInternalServerError, wantRetryable: true},
+		{name: "unavailable", status: http.StatusServiceUnavailable, wantRetryable: true},
+		{name: "throttled", status: http.StatusTooManyRequests, wantRetryable: true},
+		{name: "forbidden", status: http.StatusForbidden},
+		{name: "no value", status: http.StatusOK, body: `{}`},
+		{name: "malformed", status: http.StatusOK, body: `{"value":`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+			p := &http_server.HTTPLookupProvider{URL: server.URL + "/keys/{key}", TTL: time.Minute, NegativeTTL: time.Minute}
+
+			for range 2 {
+				_, err := p.Lookup(context.Background(), "AKIDEXAMPLE")
+				if errors.Is(err, http_server.ErrKeyNotFound) != tt.wantNotFound {
+					t.Fatalf("got %v, want not found %t", err, tt.wantNotFound)
+				}
+				var retryable *http_server.RetryableError
+				if errors.As(err, &retryable) != tt.wantRetryable {
+					t.Errorf("got %v, want retryable %t", err, tt.wantRetryable)
+				}
+			}
+			// Only missing keys are cached, failures are asked again
+			wantRequests := int32(2)
+			if tt.wantNotFound {
+				wantRequests = 1
+			}
+			if got := requests.Load(); got != wantRequests {
+				t.Errorf("endpoint got %d requests, want %d", got, wantRequests)
+			}
+		})
+	}
+
+	// An endpoint that can't be reached is retryable
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	p := &http_server.HTTPLookupProvider{URL: server.URL + "/keys/{key}"}
+	_, err := p.Lookup(context.Background(), "AKIDEXAMPLE")
+	var retryable *http_server.RetryableError
+	if !errors.As(err, &retryable) {
+		t.Errorf("got %v for an unreachable endpoint, want a RetryableError", err)
+	}
+}
+
+// newClientCertificate creates a self-signed client certificate, returning it and its key PEM encoded
+func newClientCertificate(t *testing.T) (*x509.Certificate, []byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "iam-proxy"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestHTTPLookupProviderMTLS(t *testing.T) {
+	cert, certPEM, keyPEM := newClientCertificate(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(cert)
+
+	var clientName string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientName = r.TLS.PeerCertificates[0].Subject.CommonName
+		w.Write([]byte(`{"value":"secret1"}`))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+	serverCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	client, err := http_server.NewOriginHTTPClient(http_server.OriginHTTPClientOptions{RootCAsPEM: serverCA, ClientCertPEM: certPEM, ClientKeyPEM: keyPEM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &http_server.HTTPLookupProvider{URL: server.URL + "/keys/{key}", HTTPClient: client}
+	got, err := p.Lookup(context.Background(), "AKIDEXAMPLE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "secret1" || clientName != "iam-proxy" {
+		t.Errorf("got %q from a client presenting %q", got, clientName)
+	}
+
+	// Without the client certificate the endpoint refuses the handshake
+	client, err = http_server.NewOriginHTTPClient(http_server.OriginHTTPClientOptions{RootCAsPEM: serverCA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p = &http_server.HTTPLookupProvider{URL: server.URL + "/keys/{key}", HTTPClient: client}
+	if _, err = p.Lookup(context.Background(), "AKIDEXAMPLE"); err == nil || errors.Is(err, http_server.ErrKeyNotFound) {
+		t.Errorf("got %v without a client certificate, want a lookup failure", err)
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/rs/zerolog"
 	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 
 	"github.com/danthegoodman1/IAMTheService/gologger"
 	"github.com/danthegoodman1/IAMTheService/utils"
@@ -34,70 +35,205 @@ var logger = gologger.NewLogger()
 type HTTPServer struct {
 	Echo       *echo.Echo
 	quicServer *http3.Server
+	requests   *requestTracker
+	providers  *ProviderRegistry
+	bodyLogger *BodyLogger
+	readOnly   *ReadOnlyMode
+	proxy      *AWSProxy
+	// credentials is the ServerConfig.Credentials
+	credentials CredentialStore
+	clock       Clock
+	reload      ReloadFunc
+	// serviceListeners are the servers of ServerConfig.ServiceListeners
+	serviceListeners []*serviceListenerServer
+}
+
+type serviceListenerServer struct {
+	server *http.Server
+	proxy  *AWSProxy
 }
 
 type CustomValidator struct {
 	validator *validator.Validate
 }
 
+type ServerConfig struct {
+	Port int
+	CORS CORSConfig
+	// WebIdentity optionally serves an OIDC token to AWS credentials exchange at POST /.iam/web-identity
+	WebIdentity *WebIdentityExchange
+	// DisableH2C serves only HTTP/1.1 on the TCP listener. By default it serves h2c (prior knowledge and upgrade),
+	// falling back to HTTP/1.1 for clients that don't speak it.
+	DisableH2C bool
+	// ServiceListeners optionally serve services on their own ports, routing by listener rather than by host
+	ServiceListeners []ServiceListener
+	// Providers is optionally exposed at /.internal/routing for debugging
+	Providers *ProviderRegistry
+	// BodyLogger optionally accepts captures started with POST /.internal/body-capture
+	BodyLogger *BodyLogger
+	// ReadOnly is optionally toggled at /.internal/read-only, share it with the AWSProxy
+	ReadOnly *ReadOnlyMode
+	// Credentials are optionally listed at /.internal/keys and rotated at POST /.internal/keys/:keyID/rotate,
+	// defaulting to the CredentialStore of Proxy. A KeyIssuer also mints keys at POST /.internal/keys and
+	// disables them at POST /.internal/keys/:keyID/disable.
+	Credentials CredentialStore
+	// Reload is optionally called by POST /.internal/reload
+	Reload ReloadFunc
+	// Proxy serves every request outside of /.internal and /.iam, if nil a dummy route
+	// echoes the verified credentials of the request
+	Proxy *AWSProxy
+	// Clock dates issued keys and verifies the requests of the dummy route, defaulting to the Clock of Proxy
+	Clock Clock
+}
+
+// ServiceListener serves a single service on its own port
+type ServiceListener struct {
+	Port int
+	// Proxy verifies and proxies the requests, which are routed to Provider rather than by the lookups of
+	// Proxy. It is left unchanged, so it can be shared with other listeners or the main server.
+	Proxy    *AWSProxy
+	Provider AWSServiceProvider
+}
+
+// fixedProviderKey is the context key of the provider that a service listener routes its requests to
+type fixedProviderKey struct{}
+
+// serviceListenerHandler serves the requests of a ServiceListener with its proxy, routed to its provider
+type serviceListenerHandler struct {
+	proxy    *AWSProxy
+	provider AWSServiceProvider
+}
+
+func (h serviceListenerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.proxy.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), fixedProviderKey{}, h.provider)))
+}
+
+// FixedServiceLookupFunc is a ServiceLookupFunc that always returns provider, regardless of the host
+func FixedServiceLookupFunc(provider AWSServiceProvider) LookupFunc[string, AWSServiceProvider] {
+	return func(context.Context, string) (AWSServiceProvider, error) {
+		return provider, nil
+	}
+}
+
+// CORSConfig is the CORS policy of the server. It is locked down by default: no CORS headers are sent
+// unless AllowOrigins is set, which is what pure SDK traffic wants.
+type CORSConfig struct {
+	// AllowOrigins are the origins allowed to make cross-origin requests, empty disables CORS
+	AllowOrigins []string
+	// AllowMethods defaults to the methods browsers need for S3 (GET, HEAD, PUT, POST, DELETE)
+	AllowMethods     []string
+	AllowHeaders     []string
+	ExposeHeaders    []string
+	AllowCredentials bool
+	// MaxAge is how many seconds browsers can cache the preflight response
+	MaxAge int
+}
+
 func StartHTTPServer(port int) *HTTPServer {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	return StartHTTPServerWithConfig(ServerConfig{Port: port})
+}
+
+func StartHTTPServerWithConfig(cfg ServerConfig) *HTTPServer {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.Port))
 	if err != nil {
 		logger.Error().Err(err).Msg("error creating tcp listener, exiting")
 		os.Exit(1)
 	}
 
 	s := &HTTPServer{
-		Echo: echo.New(),
+		Echo:        echo.New(),
+		requests:    &requestTracker{},
+		providers:   cfg.Providers,
+		bodyLogger:  cfg.BodyLogger,
+		readOnly:    cfg.ReadOnly,
+		credentials: cfg.Credentials,
+		reload:      cfg.Reload,
+		clock:       cfg.Clock,
 	}
 	s.Echo.HideBanner = true
 	s.Echo.HidePort = true
 	s.Echo.JSONSerializer = &utils.NoEscapeJSONSerializer{}
 	s.Echo.Use(CreateReqContext)
 	s.Echo.Use(LoggerMiddleware)
-	s.Echo.Use(middleware.CORS())
+	s.Echo.Use(s.requests.middleware)
+	if len(cfg.CORS.AllowOrigins) > 0 {
+		s.Echo.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+			AllowOrigins:     cfg.CORS.AllowOrigins,
+			AllowMethods:     utils.IfElse(len(cfg.CORS.AllowMethods) > 0, cfg.CORS.AllowMethods, []string{http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPost, http.MethodDelete}),
+			AllowHeaders:     cfg.CORS.AllowHeaders,
+			ExposeHeaders:    cfg.CORS.ExposeHeaders,
+			AllowCredentials: cfg.CORS.AllowCredentials,
+			MaxAge:           cfg.CORS.MaxAge,
+		}))
+	}
 	s.Echo.Validator = &CustomValidator{validator: validator.New()}
 	s.Echo.HTTPErrorHandler = customHTTPErrorHandler
 
 	internalRoutes := s.Echo.Group("/.internal")
 	internalRoutes.GET("/hc", s.HealthCheck)
+	internalRoutes.GET("/log-level", s.GetLogLevel, adminAuthMiddleware)
+	internalRoutes.PUT("/log-level", s.SetLogLevel, adminAuthMiddleware)
+	internalRoutes.GET("/routing", s.GetRouting, adminAuthMiddleware)
+	internalRoutes.POST("/body-capture", s.StartBodyCapture, adminAuthMiddleware)
+	internalRoutes.GET("/read-only", s.GetReadOnly, adminAuthMiddleware)
+	internalRoutes.PUT("/read-only", s.SetReadOnly, adminAuthMiddleware)
+	internalRoutes.GET("/keys", s.ListKeys, adminAuthMiddleware)
+	internalRoutes.POST("/keys", s.CreateKey, adminAuthMiddleware)
+	internalRoutes.POST("/keys/:keyID/disable", s.DisableKey, adminAuthMiddleware)
+	internalRoutes.POST("/keys/:keyID/rotate", s.RotateKey, adminAuthMiddleware)
+	internalRoutes.POST("/reload", s.Reload, adminAuthMiddleware)
+
+	if cfg.WebIdentity != nil {
+		s.Echo.POST("/.iam/web-identity", cfg.WebIdentity.HandleExchange)
+	}
 
-	// dummy route to test request verification
-	s.Echo.Any("**", ccHandler(func(c *CustomContext) error {
-		return c.JSON(http.StatusOK, c.AWSCredentials)
-	}), verifyAWSRequestMiddleware)
+	if cfg.Proxy != nil {
+		s.MountAWSProxy(cfg.Proxy)
+	} else {
+		// dummy route to test request verification
+		s.Echo.Any("**", ccHandler(func(c *CustomContext) error {
+			return c.JSON(http.StatusOK, c.AWSCredentials)
+		}), verifyAWSRequestMiddleware(s.clock))
+	}
 
 	s.Echo.Listener = listener
 	go func() {
-		logger.Info().Msg("starting h2c server on " + listener.Addr().String())
-		// this just basically creates a h2c.NewHandler(echo, &http2.Server{})
-		err := s.Echo.StartH2CServer("", &http2.Server{})
+		var err error
+		if cfg.DisableH2C {
+			logger.Info().Msg("starting http/1.1 server on " + listener.Addr().String())
+			err = s.Echo.StartServer(s.Echo.Server)
+		} else {
+			logger.Info().Msg("starting h2c server on " + listener.Addr().String())
+			// this just basically creates a h2c.NewHandler(echo, &http2.Server{}), which serves HTTP/1.1 too
+			err = s.Echo.StartH2CServer("", &http2.Server{})
+		}
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			logger.Error().Err(err).Msg("failed to start h2c server, exiting")
 			os.Exit(1)
 		}
 	}()
 
-	// Start http/3 server
+	for _, serviceListener := range cfg.ServiceListeners {
+		s.serviceListeners = append(s.serviceListeners, startServiceListener(serviceListener))
+	}
+
+	// Start http/3 server. It is created up front so Shutdown can close it at any time.
+	s.quicServer = &http3.Server{
+		Addr:    listener.Addr().String(),
+		Handler: s.Echo,
+	}
 	go func() {
-		tlsCert, err := loadOrGenerateTLSCert()
+		tlsCert, err := loadOrGenerateTLSCert(s.now())
 		if err != nil {
 			logger.Fatal().Err(err).Msg("failed to generate self-signed cert")
 		}
 
 		// TLS configuration
-		tlsConfig := &tls.Config{
+		s.quicServer.TLSConfig = &tls.Config{
 			Certificates: []tls.Certificate{tlsCert},
 			NextProtos:   []string{"h3"},
 		}
 
-		// Create HTTP/3 server
-		s.quicServer = &http3.Server{
-			Addr:      listener.Addr().String(),
-			Handler:   s.Echo,
-			TLSConfig: tlsConfig,
-		}
-
 		logger.Info().Msg("starting h3 server on " + listener.Addr().String())
 		err = s.quicServer.ListenAndServe()
 
@@ -111,6 +247,42 @@ func StartHTTPServer(port int) *HTTPServer {
 	return s
 }
 
+// MountAWSProxy serves every request outside of /.internal and /.iam with the proxy, and drains it on Shutdown.
+// The proxy writes its own AWS-style errors, and streams responses (or hands hijacked requests the writer)
+// straight to the client.
+func (s *HTTPServer) MountAWSProxy(proxy *AWSProxy) {
+	s.proxy = proxy
+	handler := echo.WrapHandler(proxy)
+	s.Echo.Any("/*", handler)
+	// Methods that Any doesn't register still get an AWS-style error from the proxy, not an echo 405
+	s.Echo.RouteNotFound("/*", handler)
+}
+
+func startServiceListener(cfg ServiceListener) *serviceListenerServer {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.Port))
+	if err != nil {
+		logger.Error().Err(err).Int("port", cfg.Port).Msg("error creating service tcp listener, exiting")
+		os.Exit(1)
+	}
+
+	server := &http.Server{
+		Handler: h2c.NewHandler(serviceListenerHandler{proxy: cfg.Proxy, provider: cfg.Provider}, &http2.Server{}),
+	}
+	go func() {
+		logger.Info().Str("service", cfg.Provider.ServiceName()).Msg("starting service h2c server on " + listener.Addr().String())
+		err := server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error().Err(err).Msg("failed to start service h2c server, exiting")
+			os.Exit(1)
+		}
+	}()
+
+	return &serviceListenerServer{
+		server: server,
+		proxy:  cfg.Proxy,
+	}
+}
+
 func (cv *CustomValidator) Validate(i interface{}) error {
 	if err := cv.validator.Struct(i); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
@@ -136,18 +308,45 @@ func (*HTTPServer) HealthCheck(c echo.Context) error {
 	return c.String(http.StatusOK, "ok")
 }
 
+// Shutdown drains the server: new requests are rejected, and in-flight requests have until ctx is done to
+// finish before the listeners are closed. Every listener is closed even if another fails to, and the errors
+// are returned together.
 func (s *HTTPServer) Shutdown(ctx context.Context) error {
-	err := s.quicServer.Close()
-	if err != nil {
-		return fmt.Errorf("error in quicServer.Close: %w", err)
+	// The h2c server waits for its own in-flight requests, but http/3 requests have to be tracked
+	drainErr := s.requests.drain(ctx)
+	if drainErr != nil {
+		logger.Warn().Err(drainErr).Msg("drain deadline exceeded, closing with requests in flight")
 	}
 
-	err = s.Echo.Shutdown(ctx)
-	if err != nil {
-		return fmt.Errorf("error shutting down echo: %w", err)
+	if s.proxy != nil {
+		// Requests that arrive while closing are turned away with a retryable error
+		if err := s.proxy.Drain(ctx); err != nil {
+			logger.Warn().Err(err).Msg("proxy drain deadline exceeded, closing with requests in flight")
+		}
 	}
 
-	return nil
+	var errs []error
+	for _, serviceListener := range s.serviceListeners {
+		if err := serviceListener.proxy.Drain(ctx); err != nil {
+			logger.Warn().Err(err).Msg("service listener drain deadline exceeded, closing with requests in flight")
+		}
+		if err := serviceListener.server.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("error shutting down service listener: %w", err))
+			// The connections still open would otherwise keep being served
+			serviceListener.server.Close()
+		}
+	}
+
+	if err := s.quicServer.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("error in quicServer.Close: %w", err))
+	}
+
+	if err := s.Echo.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("error shutting down echo: %w", err))
+		s.Echo.Close()
+	}
+
+	return errors.Join(errs...)
 }
 
 func LoggerMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
@@ -178,8 +377,8 @@ func LoggerMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 }
 
 // loadOrGenerateTLSCert will look for utils.TLSCert and utils.TLSKey on disk and load them.
-// If both don't exist, it will generate a new pair and return those.
-func loadOrGenerateTLSCert() (tls.Certificate, error) {
+// If both don't exist, it will generate a new pair valid from now and return those.
+func loadOrGenerateTLSCert(now time.Time) (tls.Certificate, error) {
 	// Check if certificate and key files exist
 	if fileExists(utils.TLSCert) && fileExists(utils.TLSKey) {
 		// Load existing certificate and key
@@ -197,8 +396,8 @@ func loadOrGenerateTLSCert() (tls.Certificate, error) {
 		Subject: pkix.Name{
 			Organization: []string{"Example Co"},
 		},
-		NotBefore: time.Now(),
-		NotAfter:  time.Now().Add(time.Hour * 24 * 180), // Valid for 180 days
+		NotBefore: now,
+		NotAfter:  now.Add(time.Hour * 24 * 180), // Valid for 180 days
 		KeyUsage:  x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
 		ExtKeyUsage: []x509.ExtKeyUsage{
 			x509.ExtKeyUsageServerAuth,
@@ -0,0 +1,292 @@
+package http_server_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+	"github.com/danthegoodman1/IAMTheService/utils"
+)
+
+// startServer starts the server on a free port, returning its URL once it serves requests
+func startServer(t *testing.T, cfg http_server.ServerConfig) string {
+	t.Helper()
+	_, url := startHTTPServer(t, cfg)
+	return url
+}
+
+// startHTTPServer is startServer, also returning the server. It is shut down when the test ends.
+func startHTTPServer(t *testing.T, cfg http_server.ServerConfig) (*http_server.HTTPServer, string) {
+	t.Helper()
+	// The HTTP/3 server writes its self-signed certificate in the background, possibly after the test, so it
+	// goes in the temp dir rather than the package
+	utils.TLSCert = filepath.Join(os.TempDir(), "iamtheservice-test-cert.pem")
+	utils.TLSKey = filepath.Join(os.TempDir(), "iamtheservice-test-key.pem")
+
+	s := http_server.StartHTTPServerWithConfig(cfg)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+	_, port, _ := net.SplitHostPort(s.Echo.Listener.Addr().String())
+	url := "http://127.0.0.1:" + port
+	waitForServer(t, url+"/.internal/hc")
+	return s, url
+}
+
+// waitForServer waits for the url to be served
+func waitForServer(t *testing.T, url string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		res, err := http.Get(url)
+		if err == nil {
+			res.Body.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server didn't start: %v", err)
+		}
+	}
+}
+
+// Clients that only speak HTTP/1.1 (no h2c upgrade or prior knowledge) are served whether or not h2c is enabled
+func TestServerHTTP11Client(t *testing.T) {
+	for _, disableH2C := range []bool{false, true} {
+		name := "h2c"
+		if disableH2C {
+			name = "http/1.1 only"
+		}
+		t.Run(name, func(t *testing.T) {
+			h := newS3Harness(t)
+			url := startServer(t, http_server.ServerConfig{Proxy: h.Proxy, DisableH2C: disableH2C})
+
+			client := &http.Client{Transport: &http.Transport{
+				ForceAttemptHTTP2: false,
+				// A non-nil empty map disables HTTP/2
+				TLSNextProto: map[string]func(string, *tls.Conn) http.RoundTripper{},
+			}}
+			r := iamtest.NewSignedRequest(http.MethodGet, url+"/bucket/key", nil, "s3")
+			res, err := client.Do(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK || res.ProtoMajor != 1 || res.ProtoMinor != 1 {
+				t.Fatalf("got %s %d %s, want an HTTP/1.1 200", res.Proto, res.StatusCode, body)
+			}
+			if n := len(h.Origin.Requests()); n != 1 {
+				t.Errorf("origin received %d requests", n)
+			}
+		})
+	}
+}
+
+// A credential-handling proxy must not reflect any origin
+func TestServerCORS(t *testing.T) {
+	const allowed = "https://app.example.com"
+	locked := startServer(t, http_server.ServerConfig{})
+	configured := startServer(t, http_server.ServerConfig{CORS: http_server.CORSConfig{
+		AllowOrigins: []string{allowed},
+		AllowHeaders: []string{"Authorization", "X-Amz-Date", "X-Amz-Content-Sha256"},
+		MaxAge:       600,
+	}})
+
+	tests := []struct {
+		name       string
+		url        string
+		method     string
+		origin     string
+		wantOrigin string
+	}{
+		{name: "disabled by default", url: locked, method: http.MethodGet, origin: allowed},
+		{name: "preflight disabled by default", url: locked, method: http.MethodOptions, origin: allowed},
+		{name: "allowed origin", url: configured, method: http.MethodGet, origin: allowed, wantOrigin: allowed},
+		{name: "disallowed origin", url: configured, method: http.MethodGet, origin: "https://evil.example.com"},
+		{name: "allowed preflight", url: configured, method: http.MethodOptions, origin: allowed, wantOrigin: allowed},
+		{name: "disallowed preflight", url: configured, method: http.MethodOptions, origin: "https://evil.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest(tt.method, tt.url+"/.internal/hc", nil)
+			r.Header.Set("Origin", tt.origin)
+			if tt.method == http.MethodOptions {
+				r.Header.Set("Access-Control-Request-Method", http.MethodPut)
+			}
+			res, err := http.DefaultClient.Do(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			if got := res.Header.Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("got Access-Control-Allow-Origin %q, want %q", got, tt.wantOrigin)
+			}
+			if tt.method != http.MethodOptions || tt.wantOrigin == "" {
+				return
+			}
+			if got := res.Header.Get("Access-Control-Allow-Methods"); got != "GET,HEAD,PUT,POST,DELETE" {
+				t.Errorf("got Access-Control-Allow-Methods %q", got)
+			}
+			if got := res.Header.Get("Access-Control-Allow-Headers"); got != "Authorization,X-Amz-Date,X-Amz-Content-Sha256" {
+				t.Errorf("got Access-Control-Allow-Headers %q", got)
+			}
+			if got := res.Header.Get("Access-Control-Max-Age"); got != "600" {
+				t.Errorf("got Access-Control-Max-Age %q", got)
+			}
+			if got := res.Header.Get("Access-Control-Allow-Credentials"); got != "" {
+				t.Errorf("got Access-Control-Allow-Credentials %q without AllowCredentials", got)
+			}
+		})
+	}
+}
+
+// freePort is a port nothing is listening on
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// Each service listener routes to its own provider, whatever the host of the request, even when they share
+// a proxy with each other and the main server
+func TestServiceListeners(t *testing.T) {
+	s3Origin, dynamoDBOrigin := iamtest.NewFakeOrigin(), iamtest.NewFakeOrigin()
+	defer s3Origin.Close()
+	defer dynamoDBOrigin.Close()
+	s3Provider := http_server.NewS3Provider()
+	s3Provider.OriginHost = s3Origin.URL
+	dynamoDBProvider := http_server.NewDynamoDBProvider()
+	dynamoDBProvider.OriginHost = dynamoDBOrigin.URL
+
+	providers := http_server.NewProviderRegistry(s3Provider)
+	proxy := &http_server.AWSProxy{
+		KeyLookupFunc: iamtest.MapLookupFunc(map[string]string{iamtest.KeyID: iamtest.KeySecret}),
+		Providers:     providers,
+	}
+	s3Port, dynamoDBPort := freePort(t), freePort(t)
+	s, _ := startHTTPServer(t, http_server.ServerConfig{Proxy: proxy, ServiceListeners: []http_server.ServiceListener{
+		{Port: s3Port, Proxy: proxy, Provider: s3Provider},
+		{Port: dynamoDBPort, Proxy: proxy, Provider: dynamoDBProvider},
+	}})
+	s3URL := fmt.Sprintf("http://127.0.0.1:%d", s3Port)
+	dynamoDBURL := fmt.Sprintf("http://127.0.0.1:%d", dynamoDBPort)
+
+	getItem := iamtest.NewSignedRequest(http.MethodPost, dynamoDBURL+"/", []byte(`{"TableName":"users"}`), "dynamodb")
+	getItem.Header.Set("X-Amz-Target", "DynamoDB_20120810.GetItem")
+	tests := []struct {
+		name       string
+		r          *http.Request
+		wantOrigin *iamtest.FakeOrigin
+	}{
+		{name: "s3", r: iamtest.NewSignedRequest(http.MethodGet, s3URL+"/bucket/key", nil, "s3"), wantOrigin: s3Origin},
+		{name: "dynamodb", r: getItem, wantOrigin: dynamoDBOrigin},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(tt.wantOrigin.Requests())
+			waitForServer(t, tt.r.URL.Scheme+"://"+tt.r.URL.Host)
+			res, err := http.DefaultClient.Do(tt.r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("got %d %s", res.StatusCode, body)
+			}
+			if n := len(tt.wantOrigin.Requests()) - before; n != 1 {
+				t.Errorf("origin received %d requests", n)
+			}
+		})
+	}
+	if n := len(s3Origin.Requests()) + len(dynamoDBOrigin.Requests()); n != 2 {
+		t.Errorf("origins received %d requests, want one each", n)
+	}
+	if proxy.Providers != providers || proxy.ServiceLookupFunc != nil {
+		t.Error("the service listeners changed the routing of the shared proxy")
+	}
+
+	// The transport may have dialed a spare connection that never sent a request, which Shutdown only closes
+	// once it has been new for 5 seconds, so the client's idle connections are closed first
+	http.DefaultClient.CloseIdleConnections()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, url := range []string{s3URL, dynamoDBURL} {
+		if _, err := http.Get(url); err == nil {
+			t.Errorf("%s is still served after Shutdown", url)
+		}
+	}
+}
+
+// A service listener that can't drain in time doesn't stop the rest of the server from closing
+func TestShutdownClosesEverythingAfterAFailedDrain(t *testing.T) {
+	slowOrigin, otherOrigin := iamtest.NewFakeOrigin(), iamtest.NewFakeOrigin()
+	defer slowOrigin.Close()
+	defer otherOrigin.Close()
+	release := make(chan struct{})
+	defer close(release)
+	slowOrigin.Handle(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, firstHalf)
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	slowProvider, otherProvider := http_server.NewS3Provider(), http_server.NewS3Provider()
+	slowProvider.OriginHost = slowOrigin.URL
+	otherProvider.OriginHost = otherOrigin.URL
+	newProxy := func() *http_server.AWSProxy {
+		return &http_server.AWSProxy{KeyLookupFunc: iamtest.MapLookupFunc(map[string]string{iamtest.KeyID: iamtest.KeySecret})}
+	}
+
+	slowPort, otherPort := freePort(t), freePort(t)
+	s, url := startHTTPServer(t, http_server.ServerConfig{ServiceListeners: []http_server.ServiceListener{
+		{Port: slowPort, Proxy: newProxy(), Provider: slowProvider},
+		{Port: otherPort, Proxy: newProxy(), Provider: otherProvider},
+	}})
+	slowURL := fmt.Sprintf("http://127.0.0.1:%d", slowPort)
+	otherURL := fmt.Sprintf("http://127.0.0.1:%d", otherPort)
+	waitForServer(t, slowURL)
+	waitForServer(t, otherURL)
+
+	res, err := http.DefaultClient.Do(iamtest.NewSignedRequest(http.MethodGet, slowURL+"/bucket/large", nil, "s3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if _, err = io.ReadFull(res.Body, make([]byte, len(firstHalf))); err != nil {
+		t.Fatal(err)
+	}
+
+	http.DefaultClient.CloseIdleConnections()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the deadline exceeded", err)
+	}
+	for _, url := range []string{slowURL, otherURL, url} {
+		if _, err := http.Get(url + "/.internal/hc"); err == nil {
+			t.Errorf("%s is still served after Shutdown", url)
+		}
+	}
+}
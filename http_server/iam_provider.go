@@ -0,0 +1,133 @@
+package http_server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const iamXMLNamespace = "https://iam.amazonaws.com/doc/2010-05-08/"
+
+// IAM access key statuses
+const (
+	IAMAccessKeyActive   = "Active"
+	IAMAccessKeyInactive = "Inactive"
+)
+
+// IAMProvider is the AWSServiceProvider for IAM, which uses the AWS query protocol (Action=CreateAccessKey)
+// on the global iam.amazonaws.com endpoint. Register handlers for access key and policy actions to build a
+// virtual IAM, where keys issued through the proxy never reach AWS (see NewCreateAccessKeyResponse).
+type IAMProvider struct {
+	*QueryProtocolProvider
+}
+
+func NewIAMProvider() *IAMProvider {
+	return &IAMProvider{
+		QueryProtocolProvider: NewQueryProtocolProvider("iam"),
+	}
+}
+
+// IAMRequest is the parsed parameters of an IAM request
+type IAMRequest struct {
+	Action      string
+	UserName    string
+	RoleName    string
+	GroupName   string
+	AccessKeyId string
+	// Status is the new status of UpdateAccessKey
+	Status         string
+	PolicyArn      string
+	PolicyName     string
+	PolicyDocument string
+	// Params are all the query and form parameters
+	Params url.Values
+}
+
+// ParseIAMRequest parses the query string and form body (which is still forwarded to the origin) of an IAM request
+func ParseIAMRequest(request *ProxiedRequest) (IAMRequest, error) {
+	params, err := ParseQueryParams(request)
+	if err != nil {
+		return IAMRequest{}, fmt.Errorf("error in ParseQueryParams: %w", err)
+	}
+	return IAMRequest{
+		Action:         params.Get("Action"),
+		UserName:       params.Get("UserName"),
+		RoleName:       params.Get("RoleName"),
+		GroupName:      params.Get("GroupName"),
+		AccessKeyId:    params.Get("AccessKeyId"),
+		Status:         params.Get("Status"),
+		PolicyArn:      params.Get("PolicyArn"),
+		PolicyName:     params.Get("PolicyName"),
+		PolicyDocument: params.Get("PolicyDocument"),
+		Params:         params,
+	}, nil
+}
+
+// IAMAccessKey is an access key of a user. SecretAccessKey is only returned by CreateAccessKey.
+type IAMAccessKey struct {
+	UserName        string    `xml:"UserName"`
+	AccessKeyId     string    `xml:"AccessKeyId"`
+	Status          string    `xml:"Status"`
+	SecretAccessKey string    `xml:"SecretAccessKey,omitempty"`
+	CreateDate      time.Time `xml:"CreateDate"`
+}
+
+type iamResponseMetadata struct {
+	RequestId string `xml:"RequestId"`
+}
+
+type iamCreateAccessKeyResponse struct {
+	XMLName xml.Name `xml:"CreateAccessKeyResponse"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Result  struct {
+		AccessKey IAMAccessKey `xml:"AccessKey"`
+	} `xml:"CreateAccessKeyResult"`
+	ResponseMetadata iamResponseMetadata `xml:"ResponseMetadata"`
+}
+
+type iamListAccessKeysResponse struct {
+	XMLName xml.Name `xml:"ListAccessKeysResponse"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Result  struct {
+		UserName          string         `xml:"UserName"`
+		AccessKeyMetadata []IAMAccessKey `xml:"AccessKeyMetadata>member"`
+		IsTruncated       bool           `xml:"IsTruncated"`
+	} `xml:"ListAccessKeysResult"`
+	ResponseMetadata iamResponseMetadata `xml:"ResponseMetadata"`
+}
+
+// NewCreateAccessKeyResponse creates a CreateAccessKey response for keys issued by the proxy
+func NewCreateAccessKeyResponse(key IAMAccessKey) *http.Response {
+	res := iamCreateAccessKeyResponse{Xmlns: iamXMLNamespace}
+	res.Result.AccessKey = normalizeIAMAccessKey(key)
+	res.ResponseMetadata.RequestId = uuid.NewString()
+	return newQueryResponse(res)
+}
+
+// NewListAccessKeysResponse creates a ListAccessKeys response, without the secrets of the keys
+func NewListAccessKeysResponse(userName string, keys []IAMAccessKey) *http.Response {
+	res := iamListAccessKeysResponse{Xmlns: iamXMLNamespace}
+	res.Result.UserName = userName
+	for _, key := range keys {
+		key.SecretAccessKey = ""
+		res.Result.AccessKeyMetadata = append(res.Result.AccessKeyMetadata, normalizeIAMAccessKey(key))
+	}
+	res.ResponseMetadata.RequestId = uuid.NewString()
+	return newQueryResponse(res)
+}
+
+// NewIAMEmptyResponse creates the response of an action that returns no result, e.g. DeleteAccessKey,
+// UpdateAccessKey, AttachUserPolicy, or PutUserPolicy
+func NewIAMEmptyResponse(action string) *http.Response {
+	return NewQueryResponse(action, iamXMLNamespace, nil)
+}
+
+// normalizeIAMAccessKey formats the creation date the way IAM does (UTC, second precision)
+func normalizeIAMAccessKey(key IAMAccessKey) IAMAccessKey {
+	key.CreateDate = key.CreateDate.UTC().Truncate(time.Second)
+	return key
+}
@@ -0,0 +1,156 @@
+package http_server_test
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+var iamForm = http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}
+
+func TestIAMExtractOperationName(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		want   string
+	}{
+		{name: "create access key", target: "/", body: "Action=CreateAccessKey&UserName=alice&Version=2010-05-08", want: "CreateAccessKey"},
+		{name: "attach user policy", target: "/", body: "Action=AttachUserPolicy&UserName=alice&PolicyArn=arn%3Aaws%3Aiam%3A%3Aaws%3Apolicy%2FReadOnlyAccess", want: "AttachUserPolicy"},
+		{name: "query string", target: "/?Action=ListUsers&Version=2010-05-08", want: "ListUsers"},
+		{name: "no action", target: "/", body: "UserName=alice", want: http_server.OperationUnknown},
+	}
+	provider := http_server.NewIAMProvider()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "https://iam.amazonaws.com"+tt.target, strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if got := provider.ExtractOperationName(&http_server.ProxiedRequest{Request: r}); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// IAM is global, requests signed for any region go to iam.amazonaws.com
+func TestIAMProviderRoutesToGlobalEndpoint(t *testing.T) {
+	h, endpoints := newEndpointHarness(t, http_server.NewIAMProvider())
+
+	for _, region := range []string{"us-east-1", "eu-west-1"} {
+		res, err := h.Do(newRegionRequest(h, http.MethodPost, "/", region, iamForm, "Action=ListUsers&Version=2010-05-08"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: got status %d", region, res.StatusCode)
+		}
+		if got := endpoints.next(t); got != "iam.amazonaws.com" {
+			t.Errorf("%s: proxied to %s, want iam.amazonaws.com", region, got)
+		}
+	}
+}
+
+// virtualIAM issues and lists access keys without reaching AWS
+type virtualIAM struct {
+	mu   sync.Mutex
+	keys []http_server.IAMAccessKey
+}
+
+func (v *virtualIAM) register(provider *http_server.IAMProvider) {
+	provider.RegisterOperationHandler("CreateAccessKey", func(ctx context.Context, request *http_server.ProxiedRequest) (*http.Response, error) {
+		iamReq, err := http_server.ParseIAMRequest(request)
+		if err != nil {
+			return nil, err
+		}
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		key := http_server.IAMAccessKey{
+			UserName:        iamReq.UserName,
+			AccessKeyId:     "AKIAVIRTUAL",
+			Status:          http_server.IAMAccessKeyActive,
+			SecretAccessKey: "virtual-secret",
+			CreateDate:      time.Date(2024, 5, 1, 12, 0, 0, 123, time.FixedZone("CEST", 2*60*60)),
+		}
+		v.keys = append(v.keys, key)
+		return http_server.NewCreateAccessKeyResponse(key), nil
+	})
+	provider.RegisterOperationHandler("ListAccessKeys", func(ctx context.Context, request *http_server.ProxiedRequest) (*http.Response, error) {
+		iamReq, err := http_server.ParseIAMRequest(request)
+		if err != nil {
+			return nil, err
+		}
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		return http_server.NewListAccessKeysResponse(iamReq.UserName, v.keys), nil
+	})
+}
+
+func TestIAMProviderVirtualAccessKeys(t *testing.T) {
+	provider := http_server.NewIAMProvider()
+	(&virtualIAM{}).register(provider)
+	h, endpoints := newEndpointHarness(t, provider)
+
+	do := func(body string) []byte {
+		t.Helper()
+		res, err := h.Do(newRegionRequest(h, http.MethodPost, "/", iamtest.Region, iamForm, body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resBody, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("got %d %s", res.StatusCode, resBody)
+		}
+		return resBody
+	}
+
+	var created struct {
+		AccessKey http_server.IAMAccessKey `xml:"CreateAccessKeyResult>AccessKey"`
+		RequestId string                   `xml:"ResponseMetadata>RequestId"`
+	}
+	body := do("Action=CreateAccessKey&UserName=alice&Version=2010-05-08")
+	if err := xml.Unmarshal(body, &created); err != nil {
+		t.Fatal(err)
+	}
+	want := http_server.IAMAccessKey{
+		UserName:        "alice",
+		AccessKeyId:     "AKIAVIRTUAL",
+		Status:          http_server.IAMAccessKeyActive,
+		SecretAccessKey: "virtual-secret",
+		CreateDate:      time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+	}
+	if created.AccessKey != want || created.RequestId == "" {
+		t.Errorf("created %+v, want %+v", created, want)
+	}
+
+	var listed struct {
+		Keys []http_server.IAMAccessKey `xml:"ListAccessKeysResult>AccessKeyMetadata>member"`
+	}
+	body = do("Action=ListAccessKeys&UserName=alice")
+	if err := xml.Unmarshal(body, &listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed.Keys) != 1 || listed.Keys[0].AccessKeyId != "AKIAVIRTUAL" || listed.Keys[0].SecretAccessKey != "" {
+		t.Errorf("listed %+v, want the key without its secret", listed.Keys)
+	}
+	if n := len(h.Origin.Requests()); n != 0 {
+		t.Errorf("origin received %d requests", n)
+	}
+
+	// Other actions still go to IAM
+	do("Action=GetUser&UserName=alice")
+	if got := endpoints.next(t); got != "iam.amazonaws.com" {
+		t.Errorf("GetUser proxied to %s", got)
+	}
+}
@@ -0,0 +1,203 @@
+package http_server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+// maxKinesisRequestBytes bounds how much of a request body we will buffer to inspect it, PutRecords allows 10MiB
+const maxKinesisRequestBytes = 16 * 1024 * 1024
+
+// KinesisProvider is the AWSServiceProvider for Kinesis Data Streams, which uses the AWS JSON 1.1 protocol,
+// or CBOR 1.1 (e.g. the Java SDK and KPL). Register handlers per action (e.g. "PutRecords"), and use
+// RewriteKinesisStreamNames and RewriteKinesisPartitionKeys to map tenants onto shared streams.
+type KinesisProvider struct {
+	*JSONRPCProvider
+}
+
+func NewKinesisProvider() *KinesisProvider {
+	p := &KinesisProvider{
+		JSONRPCProvider: NewJSONRPCProvider("kinesis"),
+	}
+	// Streams are regional
+	p.Regional = true
+	return p
+}
+
+// KinesisRequest is the stream and shard a Kinesis request addresses
+type KinesisRequest struct {
+	StreamName string
+	StreamARN  string
+	// ShardId is set for shard operations, e.g. GetShardIterator and SplitShard
+	ShardId string
+	// ShardIterator is set for GetRecords, it is opaque and encodes the stream and shard
+	ShardIterator string
+	// PartitionKeys are the partition key of PutRecord, or of each PutRecords record
+	PartitionKeys []string
+}
+
+// ParseKinesisRequest reads the stream, shard, and partition keys from the JSON or CBOR body,
+// which is still forwarded to the origin
+func ParseKinesisRequest(request *ProxiedRequest) (KinesisRequest, error) {
+	var kinesisReq KinesisRequest
+	err := rewriteKinesisFields(request, func(field, value string) string {
+		switch field {
+		case "StreamName":
+			kinesisReq.StreamName = value
+		case "StreamARN":
+			kinesisReq.StreamARN = value
+		case "ShardId":
+			kinesisReq.ShardId = value
+		case "ShardIterator":
+			kinesisReq.ShardIterator = value
+		case "PartitionKey":
+			kinesisReq.PartitionKeys = append(kinesisReq.PartitionKeys, value)
+		}
+		return value
+	})
+	if err != nil {
+		return KinesisRequest{}, fmt.Errorf("error in rewriteKinesisFields: %w", err)
+	}
+	return kinesisReq, nil
+}
+
+// ExtractResources returns the stream of the request, by its StreamARN or StreamName
+func (p *KinesisProvider) ExtractResources(request *ProxiedRequest) ([]string, error) {
+	kinesisReq, err := ParseKinesisRequest(request)
+	if err != nil {
+		return nil, fmt.Errorf("error in ParseKinesisRequest: %w", err)
+	}
+	switch {
+	case kinesisReq.StreamARN != "":
+		return []string{kinesisReq.StreamARN}, nil
+	case kinesisReq.StreamName != "":
+		return []string{resourceARN("kinesis", request, "stream/"+kinesisReq.StreamName)}, nil
+	}
+	return nil, nil
+}
+
+// RewriteKinesisStreamNames renames the stream the request addresses (e.g. prefixing a tenant), by its
+// StreamName, or within its StreamARN or ConsumerARN, and re-signs the new body.
+// Stream names in responses (e.g. of ListStreams) and within shard iterators are not rewritten.
+func RewriteKinesisStreamNames(request *ProxiedRequest, rename func(stream string) string) error {
+	return rewriteKinesisFields(request, func(field, value string) string {
+		switch field {
+		case "StreamName", "ExclusiveStartStreamName":
+			return rename(value)
+		case "StreamARN", "ConsumerARN":
+			return renameKinesisStreamARN(value, rename)
+		}
+		return value
+	})
+}
+
+// RewriteKinesisPartitionKeys rewrites the partition key of PutRecord, or of each PutRecords record (e.g.
+// prefixing a tenant so tenants sharing a stream hash independently), and re-signs the new body
+func RewriteKinesisPartitionKeys(request *ProxiedRequest, rewrite func(partitionKey string) string) error {
+	return rewriteKinesisFields(request, func(field, value string) string {
+		if field != "PartitionKey" {
+			return value
+		}
+		return rewrite(value)
+	})
+}
+
+// renameKinesisStreamARN renames the stream of a stream or consumer ARN,
+// e.g. arn:aws:kinesis:us-east-1:123456789012:stream/name/consumer/app:1
+func renameKinesisStreamARN(arn string, rename func(stream string) string) string {
+	prefix, resource, found := strings.Cut(arn, ":stream/")
+	if !found {
+		return arn
+	}
+	stream, rest, hasRest := strings.Cut(resource, "/")
+	return prefix + ":stream/" + rename(stream) + lo.Ternary(hasRest, "/"+rest, "")
+}
+
+// isCBORRequest is whether the body uses the AWS CBOR protocol rather than JSON
+func isCBORRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-amz-cbor")
+}
+
+// rewriteKinesisFields buffers the (bounded) body and passes its top level string fields, and those of its
+// Records, through rewrite. If anything changed the new body is re-signed, otherwise the body is restored.
+func rewriteKinesisFields(request *ProxiedRequest, rewrite func(field, value string) string) error {
+	body, err := io.ReadAll(io.LimitReader(request.Request.Body, maxKinesisRequestBytes))
+	if err != nil {
+		return fmt.Errorf("error reading request body: %w", err)
+	}
+	request.Request.Body.Close()
+	request.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	var rewritten []byte
+	if isCBORRequest(request.Request) {
+		if rewritten, err = rewriteCBORStrings(body, rewrite, "Records"); err != nil {
+			return fmt.Errorf("error in rewriteCBORStrings: %w", err)
+		}
+	} else if rewritten, err = rewriteJSONStrings(body, rewrite, "Records"); err != nil {
+		return fmt.Errorf("error in rewriteJSONStrings: %w", err)
+	}
+	if bytes.Equal(rewritten, body) {
+		return nil
+	}
+
+	request.SetBody(rewritten)
+	return nil
+}
+
+// rewriteJSONStrings is rewriteCBORStrings for JSON bodies. The body is only re-encoded if something changed.
+func rewriteJSONStrings(body []byte, rewrite func(field, value string) string, nested ...string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, fmt.Errorf("error in json.Unmarshal: %w", err)
+	}
+
+	changed := false
+	rewriteFields := func(fields map[string]json.RawMessage) error {
+		for field, raw := range fields {
+			var value string
+			if json.Unmarshal(raw, &value) != nil {
+				continue
+			}
+			if rewritten := rewrite(field, value); rewritten != value {
+				var err error
+				if fields[field], err = json.Marshal(rewritten); err != nil {
+					return fmt.Errorf("error in json.Marshal of %s: %w", field, err)
+				}
+				changed = true
+			}
+		}
+		return nil
+	}
+
+	if err := rewriteFields(fields); err != nil {
+		return nil, err
+	}
+	for _, field := range nested {
+		var items []map[string]json.RawMessage
+		if raw, ok := fields[field]; !ok || json.Unmarshal(raw, &items) != nil {
+			continue
+		}
+		for _, item := range items {
+			if err := rewriteFields(item); err != nil {
+				return nil, err
+			}
+		}
+		if changed {
+			var err error
+			if fields[field], err = json.Marshal(items); err != nil {
+				return nil, fmt.Errorf("error in json.Marshal of %s: %w", field, err)
+			}
+		}
+	}
+
+	if !changed {
+		return body, nil
+	}
+	return json.Marshal(fields)
+}
@@ -0,0 +1,58 @@
+package http_server_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"testing"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+func TestRewriteKinesisCBORPartitionKeys(t *testing.T) {
+	h := iamtest.NewHarness(func(originURL string) http_server.AWSServiceProvider {
+		p := http_server.NewKinesisProvider()
+		p.OriginHost = originURL
+		p.Use(func(next http_server.OperationHandler) http_server.OperationHandler {
+			return func(ctx context.Context, request *http_server.ProxiedRequest) (*http.Response, error) {
+				if err := http_server.RewriteKinesisPartitionKeys(request, func(key string) string { return "tenant-a/" + key }); err != nil {
+					return nil, err
+				}
+				return next(ctx, request)
+			}
+		})
+		return p
+	})
+	t.Cleanup(h.Close)
+
+	// PutRecord as the Java SDK sends it: {"Data": h'00', "PartitionKey": "pk", "StreamName": "orders"}
+	body := []byte("\xbf\x64Data\x41\x00\x6cPartitionKey\x62pk\x6aStreamName\x66orders\xff")
+	want := []byte("\xbf\x64Data\x41\x00\x6cPartitionKey\x6btenant-a/pk\x6aStreamName\x66orders\xff")
+
+	r := h.NewSignedRequest(http.MethodPost, "/", body)
+	r.Header.Set("Content-Type", "application/x-amz-cbor-1.1")
+	r.Header.Set("X-Amz-Target", "Kinesis_20131202.PutRecord")
+	res, err := h.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d", res.StatusCode)
+	}
+
+	requests := h.Origin.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("origin received %d requests", len(requests))
+	}
+	if !bytes.Equal(requests[0].Body, want) {
+		t.Errorf("origin received %x, want %x", requests[0].Body, want)
+	}
+	sum := sha256.Sum256(want)
+	if got := requests[0].Header.Get("X-Amz-Content-Sha256"); got != hex.EncodeToString(sum[:]) {
+		t.Errorf("origin received payload hash %s, want the hash of the rewritten body", got)
+	}
+}
@@ -0,0 +1,79 @@
+package http_server
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Errors for handlers that answer KMS requests locally, rendered as KMS exceptions
+var (
+	ErrAWSKMSNotFound          = NewAWSError(http.StatusBadRequest, "NotFoundException", "The request was rejected because the specified entity or resource could not be found.")
+	ErrAWSKMSInvalidCiphertext = NewAWSError(http.StatusBadRequest, "InvalidCiphertextException", "The ciphertext is invalid or was not encrypted under the specified key and encryption context.")
+	ErrAWSKMSDisabled          = NewAWSError(http.StatusBadRequest, "DisabledException", "The request was rejected because the specified KMS key is not enabled.")
+)
+
+// KMSProvider is the AWSServiceProvider for KMS, which uses the AWS JSON 1.1 protocol (TrentService.Decrypt).
+// Register handlers for e.g. "Encrypt", "Decrypt", and "GenerateDataKey" to answer them from a local keystore
+// with ParseKMSRequest and the NewKMS*Response builders, while the proxy still verifies the SigV4 signature.
+type KMSProvider struct {
+	*JSONRPCProvider
+}
+
+func NewKMSProvider() *KMSProvider {
+	p := &KMSProvider{
+		JSONRPCProvider: NewJSONRPCProvider("kms"),
+	}
+	// Keys are regional
+	p.Regional = true
+	return p
+}
+
+// KMSRequest is the parameters of the cryptographic KMS operations. Blobs are decoded from base64.
+type KMSRequest struct {
+	KeyId               string
+	Plaintext           []byte
+	CiphertextBlob      []byte
+	EncryptionContext   map[string]string
+	EncryptionAlgorithm string
+	// KeySpec and NumberOfBytes size GenerateDataKey
+	KeySpec       string
+	NumberOfBytes int
+	GrantTokens   []string
+}
+
+// ParseKMSRequest decodes the request body, which is still forwarded to the origin
+func ParseKMSRequest(request *ProxiedRequest) (KMSRequest, error) {
+	var kmsReq KMSRequest
+	if err := ParseJSONRequest(request, &kmsReq); err != nil {
+		return KMSRequest{}, fmt.Errorf("error in ParseJSONRequest: %w", err)
+	}
+	return kmsReq, nil
+}
+
+// NewKMSEncryptResponse creates an Encrypt response for handlers that encrypt locally
+func NewKMSEncryptResponse(keyID string, ciphertext []byte, algorithm string) *http.Response {
+	return NewJSONResponse(struct {
+		KeyId               string
+		CiphertextBlob      []byte
+		EncryptionAlgorithm string `json:",omitempty"`
+	}{keyID, ciphertext, algorithm})
+}
+
+// NewKMSDecryptResponse creates a Decrypt response for handlers that decrypt locally
+func NewKMSDecryptResponse(keyID string, plaintext []byte, algorithm string) *http.Response {
+	return NewJSONResponse(struct {
+		KeyId               string
+		Plaintext           []byte
+		EncryptionAlgorithm string `json:",omitempty"`
+	}{keyID, plaintext, algorithm})
+}
+
+// NewKMSGenerateDataKeyResponse creates a GenerateDataKey response, with the data key in plaintext and
+// encrypted under keyID. Pass a nil plaintext for GenerateDataKeyWithoutPlaintext.
+func NewKMSGenerateDataKeyResponse(keyID string, plaintext, ciphertext []byte) *http.Response {
+	return NewJSONResponse(struct {
+		KeyId          string
+		Plaintext      []byte `json:",omitempty"`
+		CiphertextBlob []byte
+	}{keyID, plaintext, ciphertext})
+}
@@ -0,0 +1,138 @@
+package http_server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+func kmsHeader(operation string) http.Header {
+	return http.Header{
+		"Content-Type": {"application/x-amz-json-1.1"},
+		"X-Amz-Target": {"TrentService." + operation},
+	}
+}
+
+func TestKMSExtractOperationName(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{target: "TrentService.Encrypt", want: "Encrypt"},
+		{target: "TrentService.Decrypt", want: "Decrypt"},
+		{target: "TrentService.GenerateDataKey", want: "GenerateDataKey"},
+		{target: "TrentService.ListKeys", want: "ListKeys"},
+		{target: "Decrypt", want: http_server.OperationUnknown},
+		{target: "", want: http_server.OperationUnknown},
+	}
+	provider := http_server.NewKMSProvider()
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "https://kms.us-east-1.amazonaws.com/", nil)
+			r.Header.Set("X-Amz-Target", tt.target)
+			if got := provider.ExtractOperationName(&http_server.ProxiedRequest{Request: r}); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKMSProviderRouting(t *testing.T) {
+	for _, regional := range []bool{true, false} {
+		provider := http_server.NewKMSProvider()
+		provider.Regional = regional
+		h, endpoints := newEndpointHarness(t, provider)
+
+		body := `{"KeyId":"alias/orders","Plaintext":"aGk="}`
+		res, err := h.Do(newRegionRequest(h, http.MethodPost, "/", "ap-southeast-2", kmsHeader("Encrypt"), body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("got status %d", res.StatusCode)
+		}
+		want := "kms.amazonaws.com"
+		if regional {
+			want = "kms.ap-southeast-2.amazonaws.com"
+		}
+		if got := endpoints.next(t); got != want {
+			t.Errorf("regional %t: proxied to %s, want %s", regional, got, want)
+		}
+		requests := h.Origin.Requests()
+		if len(requests) != 1 || string(requests[0].Body) != body || requests[0].Header.Get("X-Amz-Target") != "TrentService.Encrypt" {
+			t.Errorf("origin received %+v", requests)
+		}
+	}
+}
+
+// A local keystore answers Decrypt, while the rest of KMS is proxied
+func TestKMSProviderLocalDecrypt(t *testing.T) {
+	provider := http_server.NewKMSProvider()
+	provider.RegisterOperationHandler("Decrypt", func(ctx context.Context, request *http_server.ProxiedRequest) (*http.Response, error) {
+		kmsReq, err := http_server.ParseKMSRequest(request)
+		if err != nil {
+			return nil, err
+		}
+		plaintext, ok := bytes.CutPrefix(kmsReq.CiphertextBlob, []byte("sealed:"))
+		if !ok || kmsReq.EncryptionContext["tenant"] != "acme" {
+			return nil, http_server.ErrAWSKMSInvalidCiphertext
+		}
+		return http_server.NewKMSDecryptResponse("arn:aws:kms:us-east-1:123456789012:key/local", plaintext, "SYMMETRIC_DEFAULT"), nil
+	})
+	h, endpoints := newEndpointHarness(t, provider)
+
+	decrypt := func(ciphertext string, encryptionContext map[string]string) (*http.Response, []byte) {
+		t.Helper()
+		body, _ := json.Marshal(map[string]any{"CiphertextBlob": []byte(ciphertext), "EncryptionContext": encryptionContext})
+		res, err := h.Do(newRegionRequest(h, http.MethodPost, "/", iamtest.Region, kmsHeader("Decrypt"), string(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resBody, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		return res, resBody
+	}
+
+	res, body := decrypt("sealed:the data key", map[string]string{"tenant": "acme"})
+	var decrypted struct {
+		KeyId     string
+		Plaintext []byte
+	}
+	if err := json.Unmarshal(body, &decrypted); err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("got %d %s: %v", res.StatusCode, body, err)
+	}
+	if string(decrypted.Plaintext) != "the data key" || decrypted.KeyId != "arn:aws:kms:us-east-1:123456789012:key/local" {
+		t.Errorf("got %+v", decrypted)
+	}
+
+	// Errors render as KMS exceptions
+	res, body = decrypt("sealed:the data key", map[string]string{"tenant": "other"})
+	var exception struct {
+		Type string `json:"__type"`
+	}
+	if err := json.Unmarshal(body, &exception); err != nil || res.StatusCode != http.StatusBadRequest || exception.Type != "InvalidCiphertextException" {
+		t.Errorf("got %d %s, want an InvalidCiphertextException", res.StatusCode, body)
+	}
+	if n := len(h.Origin.Requests()); n != 0 {
+		t.Errorf("origin received %d requests", n)
+	}
+
+	// Other operations still go to KMS
+	res, err := h.Do(newRegionRequest(h, http.MethodPost, "/", iamtest.Region, kmsHeader("ListKeys"), `{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if got := endpoints.next(t); got != "kms."+iamtest.Region+".amazonaws.com" {
+		t.Errorf("ListKeys proxied to %s", got)
+	}
+}
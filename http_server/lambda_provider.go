@@ -0,0 +1,181 @@
+package http_server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Lambda invocation types, from the X-Amz-Invocation-Type header
+const (
+	LambdaInvocationRequestResponse = "RequestResponse"
+	LambdaInvocationEvent           = "Event"
+	LambdaInvocationDryRun          = "DryRun"
+)
+
+// LambdaProvider is the AWSServiceProvider for Lambda, which uses a REST-JSON protocol addressing functions
+// by path (/2015-03-31/functions/{name}/invocations). Register handlers per operation (e.g. "Invoke") to
+// route invocations to a different function with RewriteLambdaFunctionName, or answer them locally with
+// NewLambdaInvokeResponse.
+type LambdaProvider struct {
+	*BaseAWSProvider
+	OperationRouter
+}
+
+func NewLambdaProvider() *LambdaProvider {
+	return &LambdaProvider{
+		BaseAWSProvider: NewBaseAWSProvider("lambda"),
+	}
+}
+
+// LambdaRequest is the function that a Lambda request addresses, and how it is invoked
+type LambdaRequest struct {
+	// FunctionName is the name, ARN, or partial ARN of the function, empty for requests not addressing one
+	FunctionName string
+	// Qualifier is the version or alias, if any
+	Qualifier string
+	// InvocationType is one of the LambdaInvocation types for invocations, defaulting to RequestResponse
+	InvocationType string
+	// LogType is "Tail" if the client asked for the last 4KB of the execution log
+	LogType string
+}
+
+// lambdaPath splits the path of a Lambda request into the API version, the function name, and what follows
+// it, e.g. /2015-03-31/functions/my-function/invocations is 2015-03-31, my-function, invocations
+func lambdaPath(u *url.URL) (version, functionName, rest string, ok bool) {
+	parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 4)
+	if len(parts) < 2 || parts[1] != "functions" {
+		return "", "", "", false
+	}
+	version = parts[0]
+	if len(parts) > 2 {
+		functionName = parts[2]
+	}
+	if len(parts) > 3 {
+		rest = strings.TrimSuffix(parts[3], "/")
+	}
+	return version, functionName, rest, true
+}
+
+// ParseLambdaRequest extracts the function and invocation options of a request
+func ParseLambdaRequest(request *ProxiedRequest) LambdaRequest {
+	_, functionName, _, _ := lambdaPath(request.Request.URL)
+	invocationType := request.Request.Header.Get("X-Amz-Invocation-Type")
+	if invocationType == "" {
+		invocationType = LambdaInvocationRequestResponse
+	}
+	return LambdaRequest{
+		FunctionName:   functionName,
+		Qualifier:      request.Request.URL.Query().Get("Qualifier"),
+		InvocationType: invocationType,
+		LogType:        request.Request.Header.Get("X-Amz-Log-Type"),
+	}
+}
+
+// ExtractOperationName classifies function requests by method and path, e.g. "Invoke" or "GetFunction"
+func (p *LambdaProvider) ExtractOperationName(request *ProxiedRequest) string {
+	_, functionName, rest, ok := lambdaPath(request.Request.URL)
+	if !ok {
+		return OperationUnknown
+	}
+
+	method := request.Request.Method
+	switch {
+	case functionName == "" && method == http.MethodGet:
+		return "ListFunctions"
+	case functionName == "" && method == http.MethodPost:
+		return "CreateFunction"
+	case rest == "invocations" && method == http.MethodPost:
+		return "Invoke"
+	case rest == "invoke-async" && method == http.MethodPost:
+		return "InvokeAsync"
+	case rest == "response-streaming-invocations" && method == http.MethodPost:
+		return "InvokeWithResponseStream"
+	case rest == "" && method == http.MethodGet:
+		return "GetFunction"
+	case rest == "" && method == http.MethodDelete:
+		return "DeleteFunction"
+	case rest == "configuration" && method == http.MethodGet:
+		return "GetFunctionConfiguration"
+	case rest == "configuration" && method == http.MethodPut:
+		return "UpdateFunctionConfiguration"
+	case rest == "code" && method == http.MethodPut:
+		return "UpdateFunctionCode"
+	case rest == "versions" && method == http.MethodGet:
+		return "ListVersionsByFunction"
+	case rest == "versions" && method == http.MethodPost:
+		return "PublishVersion"
+	case rest == "aliases" && method == http.MethodGet:
+		return "ListAliases"
+	case rest == "aliases" && method == http.MethodPost:
+		return "CreateAlias"
+	}
+	return OperationUnknown
+}
+
+// ExtractResources returns the function of the request, e.g. arn:aws:lambda:us-east-1:123456789012:function:name
+func (p *LambdaProvider) ExtractResources(request *ProxiedRequest) ([]string, error) {
+	functionName := ParseLambdaRequest(request).FunctionName
+	switch {
+	case functionName == "":
+		return nil, nil
+	case strings.HasPrefix(functionName, "arn:"):
+		return []string{functionName}, nil
+	}
+	return []string{resourceARN("lambda", request, "function:"+functionName)}, nil
+}
+
+// HandleRequest dispatches to the registered operation handler, or proxies to Lambda if there is none.
+// Lambda has no global endpoint, so requests go to the endpoint of the signed region.
+func (p *LambdaProvider) HandleRequest(ctx context.Context, request *ProxiedRequest) (*http.Response, error) {
+	return p.dispatch(ctx, p.ExtractOperationName(request), request, p.proxyToRegion)
+}
+
+// RewriteLambdaFunctionName points the request at a different function (name or ARN), e.g. to map
+// a tenant's function to a shared one. The request is re-signed for the new path when it is proxied.
+func RewriteLambdaFunctionName(request *ProxiedRequest, functionName string) {
+	version, _, rest, ok := lambdaPath(request.Request.URL)
+	if !ok {
+		return
+	}
+	segments := []string{"", version, "functions", functionName}
+	if rest != "" {
+		segments = append(segments, rest)
+	}
+	request.Request.URL.Path = strings.Join(segments, "/")
+	request.Request.URL.RawPath = ""
+	if strings.HasSuffix(request.Request.URL.Path, "/invoke-async") {
+		request.Request.URL.Path += "/"
+	}
+}
+
+// NewLambdaInvokeResponse creates the response of an invocation answered locally instead of by Lambda.
+// The status follows the invocation type (200, 202 for Event, 204 for DryRun), and a non-empty
+// functionError (e.g. "Unhandled") reports that the function failed with payload as the error.
+func NewLambdaInvokeResponse(invocationType string, payload []byte, functionError string) *http.Response {
+	statusCode := http.StatusOK
+	switch invocationType {
+	case LambdaInvocationEvent:
+		statusCode, payload = http.StatusAccepted, nil
+	case LambdaInvocationDryRun:
+		statusCode, payload = http.StatusNoContent, nil
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Content-Length", strconv.Itoa(len(payload)))
+	header.Set("X-Amz-Executed-Version", "$LATEST")
+	if functionError != "" {
+		header.Set("X-Amz-Function-Error", functionError)
+	}
+	return &http.Response{
+		StatusCode:    statusCode,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(payload)),
+		ContentLength: int64(len(payload)),
+	}
+}
@@ -0,0 +1,209 @@
+package http_server_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+// endpointRecorder is a transport sending every request to the fake origin, recording the AWS endpoint the
+// provider picked for it
+type endpointRecorder struct {
+	origin *url.URL
+
+	mu    sync.Mutex
+	hosts []string
+}
+
+func (e *endpointRecorder) RoundTrip(r *http.Request) (*http.Response, error) {
+	e.mu.Lock()
+	e.hosts = append(e.hosts, r.URL.Host)
+	e.mu.Unlock()
+	r = r.Clone(r.Context())
+	r.URL.Scheme, r.URL.Host = e.origin.Scheme, e.origin.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func (e *endpointRecorder) next(t *testing.T) string {
+	t.Helper()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.hosts) != 1 {
+		t.Fatalf("proxied to %v, want one endpoint", e.hosts)
+	}
+	host := e.hosts[0]
+	e.hosts = nil
+	return host
+}
+
+// newEndpointHarness serves provider without an OriginHost, so it proxies to the AWS endpoint it picks,
+// which is recorded instead of reached
+func newEndpointHarness(t *testing.T, provider http_server.AWSServiceProvider) (*iamtest.Harness, *endpointRecorder) {
+	t.Helper()
+	h := iamtest.NewHarness(func(string) http_server.AWSServiceProvider {
+		return provider
+	})
+	t.Cleanup(h.Close)
+	origin, _ := url.Parse(h.Origin.URL)
+	endpoints := &endpointRecorder{origin: origin}
+	h.Proxy.OriginClientProvider = http_server.OriginClientProviderFunc(func(context.Context, http_server.OriginTarget) (*http.Client, http.Header, error) {
+		return &http.Client{Transport: endpoints}, nil, nil
+	})
+	return h, endpoints
+}
+
+// newRegionRequest creates a request to the proxy at path, signed for the region
+func newRegionRequest(h *iamtest.Harness, method, path, region string, header http.Header, body string) *http.Request {
+	r, err := http.NewRequest(method, h.Server.URL+path, strings.NewReader(body))
+	if err != nil {
+		panic(err)
+	}
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	http_server.SignRequest(r, iamtest.KeyID, iamtest.KeySecret, region, h.Service, time.Now())
+	return r
+}
+
+func TestLambdaExtractOperationName(t *testing.T) {
+	tests := []struct {
+		method string
+		target string
+		want   string
+	}{
+		{method: http.MethodGet, target: "/2015-03-31/functions/", want: "ListFunctions"},
+		{method: http.MethodPost, target: "/2015-03-31/functions", want: "CreateFunction"},
+		{method: http.MethodPost, target: "/2015-03-31/functions/my-function/invocations", want: "Invoke"},
+		{method: http.MethodPost, target: "/2015-03-31/functions/my-function/invocations?Qualifier=prod", want: "Invoke"},
+		{method: http.MethodPost, target: "/2015-03-31/functions/arn%3Aaws%3Alambda%3Aus-east-1%3A123456789012%3Afunction%3Amy-function/invocations", want: "Invoke"},
+		{method: http.MethodPost, target: "/2014-11-13/functions/my-function/invoke-async/", want: "InvokeAsync"},
+		{method: http.MethodPost, target: "/2021-11-15/functions/my-function/response-streaming-invocations", want: "InvokeWithResponseStream"},
+		{method: http.MethodGet, target: "/2015-03-31/functions/my-function", want: "GetFunction"},
+		{method: http.MethodDelete, target: "/2015-03-31/functions/my-function", want: "DeleteFunction"},
+		{method: http.MethodGet, target: "/2015-03-31/functions/my-function/configuration", want: "GetFunctionConfiguration"},
+		{method: http.MethodPut, target: "/2015-03-31/functions/my-function/configuration", want: "UpdateFunctionConfiguration"},
+		{method: http.MethodPut, target: "/2015-03-31/functions/my-function/code", want: "UpdateFunctionCode"},
+		{method: http.MethodGet, target: "/2015-03-31/functions/my-function/versions", want: "ListVersionsByFunction"},
+		{method: http.MethodPost, target: "/2015-03-31/functions/my-function/versions", want: "PublishVersion"},
+		{method: http.MethodGet, target: "/2015-03-31/functions/my-function/aliases", want: "ListAliases"},
+		{method: http.MethodPost, target: "/2015-03-31/functions/my-function/aliases", want: "CreateAlias"},
+		{method: http.MethodGet, target: "/2015-03-31/functions/my-function/invocations", want: http_server.OperationUnknown},
+		{method: http.MethodGet, target: "/2015-03-31/layers", want: http_server.OperationUnknown},
+	}
+	provider := http_server.NewLambdaProvider()
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			request := &http_server.ProxiedRequest{Request: httptest.NewRequest(tt.method, "https://lambda.us-east-1.amazonaws.com"+tt.target, nil)}
+			if got := provider.ExtractOperationName(request); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// Lambda has no global endpoint, invocations go to the region they are signed for
+func TestLambdaProviderRoutesToRegion(t *testing.T) {
+	provider := http_server.NewLambdaProvider()
+	// Tenant functions are invoked as the shared one
+	provider.Use(func(next http_server.OperationHandler) http_server.OperationHandler {
+		return func(ctx context.Context, request *http_server.ProxiedRequest) (*http.Response, error) {
+			if http_server.ParseLambdaRequest(request).FunctionName == "tenant-function" {
+				http_server.RewriteLambdaFunctionName(request, "shared-function")
+			}
+			return next(ctx, request)
+		}
+	})
+	h, endpoints := newEndpointHarness(t, provider)
+
+	for _, region := range []string{"us-east-1", "eu-west-1"} {
+		res, err := h.Do(newRegionRequest(h, http.MethodPost, "/2015-03-31/functions/tenant-function/invocations", region, nil, `{"hello":"world"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: got status %d", region, res.StatusCode)
+		}
+		if got, want := endpoints.next(t), "lambda."+region+".amazonaws.com"; got != want {
+			t.Errorf("proxied to %s, want %s", got, want)
+		}
+	}
+	requests := h.Origin.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("origin received %d requests", len(requests))
+	}
+	for _, request := range requests {
+		if request.Path != "/2015-03-31/functions/shared-function/invocations" || string(request.Body) != `{"hello":"world"}` {
+			t.Errorf("origin received %s %s", request.Path, request.Body)
+		}
+	}
+}
+
+func TestLambdaProviderLocalInvoke(t *testing.T) {
+	provider := http_server.NewLambdaProvider()
+	provider.RegisterOperationHandler("Invoke", func(ctx context.Context, request *http_server.ProxiedRequest) (*http.Response, error) {
+		lambdaReq := http_server.ParseLambdaRequest(request)
+		if lambdaReq.FunctionName == "failing-function" {
+			return http_server.NewLambdaInvokeResponse(lambdaReq.InvocationType, []byte(`{"errorMessage":"boom"}`), "Unhandled"), nil
+		}
+		return http_server.NewLambdaInvokeResponse(lambdaReq.InvocationType, []byte(`{"answeredBy":"proxy","qualifier":"`+lambdaReq.Qualifier+`"}`), ""), nil
+	})
+	h, endpoints := newEndpointHarness(t, provider)
+
+	tests := []struct {
+		name              string
+		path              string
+		invocationType    string
+		wantStatus        int
+		wantBody          string
+		wantFunctionError string
+	}{
+		{name: "request response", path: "/2015-03-31/functions/my-function/invocations?Qualifier=prod", wantStatus: http.StatusOK, wantBody: `{"answeredBy":"proxy","qualifier":"prod"}`},
+		{name: "event", path: "/2015-03-31/functions/my-function/invocations", invocationType: http_server.LambdaInvocationEvent, wantStatus: http.StatusAccepted},
+		{name: "dry run", path: "/2015-03-31/functions/my-function/invocations", invocationType: http_server.LambdaInvocationDryRun, wantStatus: http.StatusNoContent},
+		{name: "function error", path: "/2015-03-31/functions/failing-function/invocations", wantStatus: http.StatusOK, wantBody: `{"errorMessage":"boom"}`, wantFunctionError: "Unhandled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.invocationType != "" {
+				header.Set("X-Amz-Invocation-Type", tt.invocationType)
+			}
+			res, err := h.Do(newRegionRequest(h, http.MethodPost, tt.path, iamtest.Region, header, `{}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			if res.StatusCode != tt.wantStatus || string(body) != tt.wantBody {
+				t.Errorf("got %d %s, want %d %s", res.StatusCode, body, tt.wantStatus, tt.wantBody)
+			}
+			if got := res.Header.Get("X-Amz-Function-Error"); got != tt.wantFunctionError {
+				t.Errorf("got X-Amz-Function-Error %q, want %q", got, tt.wantFunctionError)
+			}
+		})
+	}
+	if n := len(h.Origin.Requests()); n != 0 {
+		t.Errorf("origin received %d invocations", n)
+	}
+
+	// Other operations still go to Lambda
+	res, err := h.Do(newRegionRequest(h, http.MethodGet, "/2015-03-31/functions/my-function", iamtest.Region, nil, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if got := endpoints.next(t); got != "lambda."+iamtest.Region+".amazonaws.com" {
+		t.Errorf("GetFunction proxied to %s", got)
+	}
+}
@@ -0,0 +1,136 @@
+package http_server
+
+import (
+	"sync"
+	"time"
+)
+
+// ConcurrencyLimiter is a non-blocking semaphore, requests over the limit are shed rather than queued
+type ConcurrencyLimiter struct {
+	mu       sync.Mutex
+	limit    int
+	inFlight int
+}
+
+func NewConcurrencyLimiter(limit int) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{limit: limit}
+}
+
+// TryAcquire takes a slot, returning false if the limit is reached
+func (l *ConcurrencyLimiter) TryAcquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inFlight >= l.limit {
+		return false
+	}
+	l.inFlight++
+	return true
+}
+
+func (l *ConcurrencyLimiter) Release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+}
+
+// SetLimit changes the limit, in-flight requests over a lowered limit are allowed to finish
+func (l *ConcurrencyLimiter) SetLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+}
+
+func (l *ConcurrencyLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// AdaptiveLimiter sheds load based on origin health rather than a fixed cap, in the style of Netflix's
+// concurrency-limits. It tracks recent latency against a long term baseline: when latency rises past
+// LatencyTolerance times the baseline (or the origin errors) the limit is cut multiplicatively,
+// otherwise it grows additively back towards MaxLimit. It starts at MaxLimit, and can be used as a
+// struct literal or from NewAdaptiveLimiter.
+type AdaptiveLimiter struct {
+	MinLimit int
+	MaxLimit int
+	// LatencyTolerance is how many times slower than the baseline the origin can get before
+	// the limit is lowered, defaults to 2
+	LatencyTolerance float64
+	// BackoffRatio multiplies the limit when the origin is degrading, defaults to 0.9
+	BackoffRatio float64
+
+	limiter     *ConcurrencyLimiter
+	initLimiter sync.Once
+
+	mu       sync.Mutex
+	limit    float64
+	recent   float64
+	baseline float64
+}
+
+func NewAdaptiveLimiter(minLimit, maxLimit int) *AdaptiveLimiter {
+	return &AdaptiveLimiter{
+		MinLimit: minLimit,
+		MaxLimit: maxLimit,
+	}
+}
+
+// concurrencyLimiter starts the limit at MaxLimit on first use
+func (l *AdaptiveLimiter) concurrencyLimiter() *ConcurrencyLimiter {
+	l.initLimiter.Do(func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.limit = float64(l.MaxLimit)
+		l.limiter = NewConcurrencyLimiter(l.MaxLimit)
+	})
+	return l.limiter
+}
+
+// Limit is the current in-flight limit
+func (l *AdaptiveLimiter) Limit() int {
+	return l.concurrencyLimiter().Limit()
+}
+
+// TryAcquire takes a slot, returning false if the request should be shed. If it returns true,
+// the caller must call the returned release with the origin latency and whether it failed.
+func (l *AdaptiveLimiter) TryAcquire() (release func(latency time.Duration, failed bool), ok bool) {
+	limiter := l.concurrencyLimiter()
+	if !limiter.TryAcquire() {
+		return nil, false
+	}
+	return func(latency time.Duration, failed bool) {
+		limiter.Release()
+		l.observe(latency, failed)
+	}, true
+}
+
+func (l *AdaptiveLimiter) observe(latency time.Duration, failed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sample := float64(latency)
+	if l.baseline == 0 {
+		l.recent, l.baseline = sample, sample
+	}
+	// The recent average reacts quickly, the baseline slowly so a degrading origin stands out against it
+	l.recent = 0.8*l.recent + 0.2*sample
+	l.baseline = 0.99*l.baseline + 0.01*sample
+
+	tolerance := l.LatencyTolerance
+	if tolerance == 0 {
+		tolerance = 2
+	}
+	backoff := l.BackoffRatio
+	if backoff == 0 {
+		backoff = 0.9
+	}
+
+	if failed || l.recent > tolerance*l.baseline {
+		l.limit *= backoff
+	} else {
+		l.limit += 1 / l.limit
+	}
+	l.limit = min(max(l.limit, float64(l.MinLimit)), float64(l.MaxLimit))
+	l.limiter.SetLimit(int(l.limit))
+}
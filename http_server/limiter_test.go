@@ -0,0 +1,125 @@
+package http_server
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	l := NewConcurrencyLimiter(2)
+	if !l.TryAcquire() || !l.TryAcquire() {
+		t.Fatal("slots under the limit were refused")
+	}
+	if l.TryAcquire() {
+		t.Fatal("slot over the limit was taken")
+	}
+	l.Release()
+	if !l.TryAcquire() {
+		t.Fatal("released slot was refused")
+	}
+
+	// Lowering the limit lets in-flight requests finish, and sheds new ones until they have
+	l.SetLimit(1)
+	l.Release()
+	if l.TryAcquire() {
+		t.Fatal("slot over the lowered limit was taken")
+	}
+	l.Release()
+	if !l.TryAcquire() || l.Limit() != 1 {
+		t.Fatal("slot under the lowered limit was refused")
+	}
+}
+
+func TestAdaptiveLimiterStructLiteral(t *testing.T) {
+	l := &AdaptiveLimiter{MinLimit: 1, MaxLimit: 3}
+	if l.Limit() != 3 {
+		t.Fatalf("got limit %d, want MaxLimit", l.Limit())
+	}
+	var releases []func(time.Duration, bool)
+	for i := 0; i < 3; i++ {
+		release, ok := l.TryAcquire()
+		if !ok {
+			t.Fatalf("request %d was shed under the limit", i)
+		}
+		releases = append(releases, release)
+	}
+	if _, ok := l.TryAcquire(); ok {
+		t.Fatal("request over the limit was not shed")
+	}
+	for _, release := range releases {
+		release(10*time.Millisecond, false)
+	}
+	if _, ok := l.TryAcquire(); !ok {
+		t.Fatal("request was shed after releasing")
+	}
+}
+
+func TestAdaptiveLimiterBacksOffOnFailures(t *testing.T) {
+	l := NewAdaptiveLimiter(5, 100)
+	for i := 0; i < 5; i++ {
+		release, _ := l.TryAcquire()
+		release(10*time.Millisecond, true)
+	}
+	// 100 * 0.9^5
+	if l.Limit() != 59 {
+		t.Errorf("got limit %d after 5 failures, want 59", l.Limit())
+	}
+	for i := 0; i < 100; i++ {
+		release, _ := l.TryAcquire()
+		release(10*time.Millisecond, true)
+	}
+	if l.Limit() != 5 {
+		t.Errorf("got limit %d, want MinLimit", l.Limit())
+	}
+
+	// Healthy requests grow it back, additively
+	for i := 0; i < 50; i++ {
+		release, _ := l.TryAcquire()
+		release(10*time.Millisecond, false)
+	}
+	if limit := l.Limit(); limit <= 5 || limit >= 59 {
+		t.Errorf("got limit %d after recovering, want it between MinLimit and where it was", limit)
+	}
+}
+
+func TestAdaptiveLimiterBacksOffOnLatency(t *testing.T) {
+	l := &AdaptiveLimiter{MinLimit: 1, MaxLimit: 50, LatencyTolerance: 1.5, BackoffRatio: 0.5}
+	for i := 0; i < 200; i++ {
+		release, _ := l.TryAcquire()
+		release(10*time.Millisecond, false)
+	}
+	if l.Limit() != 50 {
+		t.Fatalf("got limit %d for a steady origin, want MaxLimit", l.Limit())
+	}
+
+	// The recent latency reaches 1.5 times the baseline within a few slow requests
+	for i := 0; i < 5; i++ {
+		release, _ := l.TryAcquire()
+		release(100*time.Millisecond, false)
+	}
+	if limit := l.Limit(); limit >= 50 {
+		t.Errorf("got limit %d for a degrading origin, want it lowered", limit)
+	}
+}
+
+func TestAdaptiveLimiterConcurrent(t *testing.T) {
+	l := &AdaptiveLimiter{MinLimit: 1, MaxLimit: 8}
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if release, ok := l.TryAcquire(); ok {
+					release(time.Millisecond, i%4 == 0 && j%10 == 0)
+				}
+				l.Limit()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if limit := l.Limit(); limit < 1 || limit > 8 {
+		t.Errorf("got limit %d outside MinLimit and MaxLimit", limit)
+	}
+}
@@ -0,0 +1,34 @@
+package http_server
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrKeyNotFound is returned by lookups of missing keys, rejected by AWSProxy with InvalidAccessKeyId
+var ErrKeyNotFound = errors.New("key not found")
+
+// LookupProvider is a LookupFunc with state (e.g. a cache or client). Its Lookup method can be used as a LookupFunc.
+// Like a LookupFunc, it must return ErrKeyNotFound (possibly wrapped) for missing keys rather than the zero value,
+// which AWSProxy would otherwise verify signatures against as an empty secret.
+type LookupProvider[TKey any, TVal any] interface {
+	Lookup(ctx context.Context, key TKey) (TVal, error)
+}
+
+// Lookup makes a LookupFunc a LookupProvider
+func (f LookupFunc[TKey, TVal]) Lookup(ctx context.Context, key TKey) (TVal, error) {
+	return f(ctx, key)
+}
+
+// RetryableError is a transient lookup failure, where trying again may succeed
+type RetryableError struct {
+	Err error
+}
+
+func (e *RetryableError) Error() string {
+	return "retryable: " + e.Err.Error()
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
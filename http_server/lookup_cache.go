@@ -0,0 +1,66 @@
+package http_server
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultLookupMaxCacheEntries is the default MaxCacheEntries of the lookup providers with a local cache
+const DefaultLookupMaxCacheEntries = 10_000
+
+// lookupCache caches the found and missing values of a remote lookup provider, so a hot key (or a client
+// retrying an unknown key) doesn't hit the remote on every request
+type lookupCache[T any] struct {
+	mu      sync.Mutex
+	entries map[string]lookupCacheEntry[T]
+}
+
+type lookupCacheEntry[T any] struct {
+	value   T
+	found   bool
+	expires time.Time
+}
+
+func (c *lookupCache[T]) get(key string, now time.Time) (lookupCacheEntry[T], bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || !now.Before(entry.expires) {
+		return lookupCacheEntry[T]{}, false
+	}
+	return entry, true
+}
+
+// put caches the entry for ttl, unless ttl is 0 or the cache is full of live entries
+func (c *lookupCache[T]) put(key string, entry lookupCacheEntry[T], now time.Time, ttl time.Duration, maxEntries int) {
+	if ttl <= 0 {
+		return
+	}
+	if maxEntries == 0 {
+		maxEntries = DefaultLookupMaxCacheEntries
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[string]lookupCacheEntry[T]{}
+	}
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxEntries {
+		for cached, cachedEntry := range c.entries {
+			if !now.Before(cachedEntry.expires) {
+				delete(c.entries, cached)
+			}
+		}
+		if len(c.entries) >= maxEntries {
+			return
+		}
+	}
+	entry.expires = now.Add(ttl)
+	c.entries[key] = entry
+}
+
+func (c *lookupCache[T]) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
@@ -0,0 +1,106 @@
+package http_server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// maxOPAResponseBytes bounds the response of an OPA decision
+const maxOPAResponseBytes = 64 * 1024
+
+// OPAAuthorizer is an Authorizer querying an Open Policy Agent (e.g. a sidecar) with the existing Rego
+// policies of an organization. It POSTs {"input": <AuthorizationRequest>} to the data API, e.g.
+//
+//	package iam
+//
+//	default allow := false
+//
+//	allow if {
+//	  input.service == "s3"
+//	  startswith(input.operation, "Get")
+//	  input.principal.Team == "data"
+//	}
+//
+// queried at http://localhost:8181/v1/data/iam/allow. The decision is either a boolean, or an object
+// with an "allow" boolean and an optional "reason". An undefined decision denies the request.
+type OPAAuthorizer struct {
+	// URL of the decision, e.g. http://localhost:8181/v1/data/iam/allow
+	URL string
+	// HTTPClient defaults to http.DefaultClient
+	HTTPClient *http.Client
+	// Header is optionally added to queries, e.g. an Authorization header for OPA's token authentication
+	Header http.Header
+}
+
+type opaQuery struct {
+	Input AuthorizationRequest `json:"input"`
+}
+
+type opaResponse struct {
+	Result json.RawMessage `json:"result"`
+}
+
+type opaDecision struct {
+	Allow  bool   `json:"allow"`
+	Reason string `json:"reason"`
+}
+
+// Authorize queries the decision. Network failures, 429s and 5xxs return a *RetryableError.
+func (a *OPAAuthorizer) Authorize(ctx context.Context, request AuthorizationRequest) (AuthorizationDecision, error) {
+	query, err := json.Marshal(opaQuery{Input: request})
+	if err != nil {
+		return AuthorizationDecision{}, fmt.Errorf("error in json.Marshal: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.URL, bytes.NewReader(query))
+	if err != nil {
+		return AuthorizationDecision{}, fmt.Errorf("error in http.NewRequestWithContext: %w", err)
+	}
+	for header, vals := range a.Header {
+		req.Header[header] = vals
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := a.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) {
+			return AuthorizationDecision{}, &RetryableError{Err: err}
+		}
+		return AuthorizationDecision{}, fmt.Errorf("error in client.Do: %w", err)
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return AuthorizationDecision{}, &RetryableError{Err: fmt.Errorf("OPA returned status %d", res.StatusCode)}
+	case res.StatusCode != http.StatusOK:
+		return AuthorizationDecision{}, fmt.Errorf("OPA returned status %d", res.StatusCode)
+	}
+
+	var body opaResponse
+	if err = json.NewDecoder(io.LimitReader(res.Body, maxOPAResponseBytes)).Decode(&body); err != nil {
+		return AuthorizationDecision{}, fmt.Errorf("error decoding OPA response: %w", err)
+	}
+	if len(body.Result) == 0 {
+		return AuthorizationDecision{Reason: "undefined decision"}, nil
+	}
+	var allow bool
+	if err = json.Unmarshal(body.Result, &allow); err == nil {
+		return AuthorizationDecision{Allowed: allow}, nil
+	}
+	var decision opaDecision
+	if err = json.Unmarshal(body.Result, &decision); err != nil {
+		return AuthorizationDecision{}, fmt.Errorf("OPA decision is neither a boolean nor an object: %w", err)
+	}
+	return AuthorizationDecision{Allowed: decision.Allow, Reason: decision.Reason}, nil
+}
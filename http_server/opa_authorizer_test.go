@@ -0,0 +1,144 @@
+package http_server_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+// fakeOPA answers decision queries with status and body, recording the inputs
+type fakeOPA struct {
+	mu     sync.Mutex
+	inputs []http_server.AuthorizationRequest
+	header http.Header
+	status int
+	body   string
+}
+
+func (o *fakeOPA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var query struct {
+		Input http_server.AuthorizationRequest `json:"input"`
+	}
+	if r.Method != http.MethodPost || r.URL.Path != "/v1/data/iam/allow" || json.NewDecoder(r.Body).Decode(&query) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	o.mu.Lock()
+	o.inputs = append(o.inputs, query.Input)
+	o.header = r.Header.Clone()
+	o.mu.Unlock()
+	w.WriteHeader(o.status)
+	io.WriteString(w, o.body)
+}
+
+func TestOPAAuthorizer(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		// wantStatus of the proxied request, the origin is only reached if it's 200
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "allowed", status: http.StatusOK, body: `{"result": true}`, wantStatus: http.StatusOK},
+		{name: "allowed by object", status: http.StatusOK, body: `{"result": {"allow": true}}`, wantStatus: http.StatusOK},
+		{name: "denied", status: http.StatusOK, body: `{"result": false}`, wantStatus: http.StatusForbidden, wantCode: "AccessDenied"},
+		{name: "denied with reason", status: http.StatusOK, body: `{"result": {"allow": false, "reason": "not on the data team"}}`, wantStatus: http.StatusForbidden, wantCode: "AccessDenied"},
+		{name: "undefined decision", status: http.StatusOK, body: `{}`, wantStatus: http.StatusForbidden, wantCode: "AccessDenied"},
+		{name: "malformed decision", status: http.StatusOK, body: `{"result": "yes"}`, wantStatus: http.StatusInternalServerError, wantCode: "InternalError"},
+		{name: "unparseable response", status: http.StatusOK, body: `<html>`, wantStatus: http.StatusInternalServerError, wantCode: "InternalError"},
+		{name: "opa error", status: http.StatusInternalServerError, body: `{"code": "internal_error"}`, wantStatus: http.StatusInternalServerError, wantCode: "InternalError"},
+		{name: "opa bad request", status: http.StatusBadRequest, body: `{"code": "invalid_parameter"}`, wantStatus: http.StatusInternalServerError, wantCode: "InternalError"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opa := &fakeOPA{status: tt.status, body: tt.body}
+			server := httptest.NewServer(opa)
+			defer server.Close()
+			h := newS3Harness(t)
+			h.Proxy.Authorizer = &http_server.OPAAuthorizer{
+				URL:    server.URL + "/v1/data/iam/allow",
+				Header: http.Header{"Authorization": {"Bearer opa-token"}},
+			}
+
+			res, err := h.Do(h.NewSignedRequest(http.MethodGet, "/bucket/key", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got %d %s, want %d", res.StatusCode, body, tt.wantStatus)
+			}
+			if tt.wantCode != "" && !strings.Contains(string(body), "<Code>"+tt.wantCode+"</Code>") {
+				t.Errorf("got body %s, want %s", body, tt.wantCode)
+			}
+			wantRequests := 0
+			if tt.wantStatus == http.StatusOK {
+				wantRequests = 1
+			}
+			if n := len(h.Origin.Requests()); n != wantRequests {
+				t.Errorf("origin received %d requests, want %d", n, wantRequests)
+			}
+
+			opa.mu.Lock()
+			defer opa.mu.Unlock()
+			if len(opa.inputs) != 1 {
+				t.Fatalf("OPA got %d queries", len(opa.inputs))
+			}
+			input := opa.inputs[0]
+			if input.KeyID != iamtest.KeyID || input.Service != "s3" || input.Operation != "GetObject" || input.Path != "/bucket/key" {
+				t.Errorf("OPA got input %+v", input)
+			}
+			if got := opa.header.Get("Authorization"); got != "Bearer opa-token" {
+				t.Errorf("OPA got Authorization %q", got)
+			}
+		})
+	}
+}
+
+func TestOPAAuthorizerErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		wantRetryable bool
+	}{
+		{name: "unavailable", status: http.StatusServiceUnavailable, wantRetryable: true},
+		{name: "throttled", status: http.StatusTooManyRequests, wantRetryable: true},
+		{name: "unauthorized", status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(&fakeOPA{status: tt.status})
+			defer server.Close()
+			a := &http_server.OPAAuthorizer{URL: server.URL + "/v1/data/iam/allow"}
+			decision, err := a.Authorize(context.Background(), http_server.AuthorizationRequest{})
+			var retryable *http_server.RetryableError
+			if err == nil || errors.As(err, &retryable) != tt.wantRetryable {
+				t.Errorf("got %v, want retryable %t", err, tt.wantRetryable)
+			}
+			if decision.Allowed {
+				t.Error("allowed on an error")
+			}
+		})
+	}
+
+	// An OPA that can't be reached is retryable
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	a := &http_server.OPAAuthorizer{URL: server.URL + "/v1/data/iam/allow"}
+	_, err := a.Authorize(context.Background(), http_server.AuthorizationRequest{})
+	var retryable *http_server.RetryableError
+	if !errors.As(err, &retryable) {
+		t.Errorf("got %v for an unreachable OPA, want a RetryableError", err)
+	}
+}
@@ -0,0 +1,105 @@
+package http_server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/samber/lo"
+)
+
+// OperationUnknown is the operation name for requests a provider can't classify
+const OperationUnknown = "Unknown"
+
+// OperationHandler handles a single operation of a service (e.g. S3 GetObject), instead of
+// blindly proxying it. Handlers can still call request.DoProxiedRequest to get the origin's response,
+// and either return it directly or mutate it first.
+type OperationHandler func(ctx context.Context, request *ProxiedRequest) (*http.Response, error)
+
+// S3OperationHandler is an OperationHandler for an S3 operation
+type S3OperationHandler = OperationHandler
+
+// OperationMiddleware wraps an OperationHandler to compose reusable behavior (policy checks, caching,
+// logging, transforms) around it
+type OperationMiddleware func(next OperationHandler) OperationHandler
+
+// OperationRouter dispatches requests to the handler registered for their operation, falling back
+// to a default handler (normally proxying to the origin). Embed it in a service provider.
+//
+// Handlers and middleware should be registered before the provider starts serving requests.
+type OperationRouter struct {
+	// Recorder optionally overrides the PrometheusOperationRecorder of handler metrics
+	Recorder OperationRecorder
+	// StrictOperations rejects requests the provider can't classify (OperationUnknown) with NotImplemented,
+	// rather than proxying them, unless a handler is registered for OperationUnknown
+	StrictOperations bool
+
+	handlers   map[string]OperationHandler
+	middleware []OperationMiddleware
+}
+
+// RegisterOperationHandler overrides the handling of an operation, e.g. "GetObject"
+func (o *OperationRouter) RegisterOperationHandler(operation string, handler OperationHandler) {
+	if o.handlers == nil {
+		o.handlers = map[string]OperationHandler{}
+	}
+	o.handlers[operation] = handler
+}
+
+// Use adds middleware that wraps every operation handler, including the default handler.
+// Middleware runs in the order it was added, so the first middleware is the outermost.
+func (o *OperationRouter) Use(middleware ...OperationMiddleware) {
+	o.middleware = append(o.middleware, middleware...)
+}
+
+// dispatch runs the handler registered for the operation (or the default handler) wrapped in the middleware chain
+func (o *OperationRouter) dispatch(ctx context.Context, operation string, request *ProxiedRequest, defaultHandler OperationHandler) (*http.Response, error) {
+	request.Operation = operation
+	recorder := o.Recorder
+	if recorder == nil {
+		recorder = PrometheusOperationRecorder{}
+	}
+	request.operationRecorder = recorder
+
+	handler, custom := o.handlers[operation]
+	if !custom && o.StrictOperations && operation == OperationUnknown {
+		return newRejectionResponse(ctx, RejectionUnknownOperation, ProtocolForService(request.Service), ErrAWSNotImplemented), nil
+	}
+	if !custom {
+		handler = defaultHandler
+	}
+
+	for i := len(o.middleware) - 1; i >= 0; i-- {
+		handler = o.middleware[i](handler)
+	}
+
+	clock := clockOrReal(request.clock)
+	start := clock.Now()
+	res, err := handler(ctx, request)
+	recorder.HandlerInvoked(request.Service, operation, custom, clock.Now().Sub(start), err != nil)
+	return res, err
+}
+
+// ResponseTransformer modifies the response of an operation before it is sent to the client
+type ResponseTransformer func(ctx context.Context, request *ProxiedRequest, res *http.Response) (*http.Response, error)
+
+// TransformResponses is middleware that runs the transformer over the response of the given operations,
+// or of every operation if none are given
+func TransformResponses(transformer ResponseTransformer, operations ...string) OperationMiddleware {
+	return func(next OperationHandler) OperationHandler {
+		return func(ctx context.Context, request *ProxiedRequest) (*http.Response, error) {
+			res, err := next(ctx, request)
+			if err != nil {
+				return nil, err
+			}
+			if len(operations) > 0 && !lo.Contains(operations, request.Operation) {
+				return res, nil
+			}
+			transformed, err := transformer(ctx, request, res)
+			if err != nil {
+				request.recordTransformFailed()
+				return nil, err
+			}
+			return transformed, nil
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package http_server_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+// tracingMiddleware records when it is entered and left
+func tracingMiddleware(name string, trace *[]string) http_server.OperationMiddleware {
+	return func(next http_server.OperationHandler) http_server.OperationHandler {
+		return func(ctx context.Context, request *http_server.ProxiedRequest) (*http.Response, error) {
+			*trace = append(*trace, name+" "+request.Operation)
+			res, err := next(ctx, request)
+			*trace = append(*trace, "/"+name)
+			return res, err
+		}
+	}
+}
+
+func TestOperationMiddleware(t *testing.T) {
+	var trace []string
+	h := iamtest.NewHarness(func(originURL string) http_server.AWSServiceProvider {
+		p := http_server.NewS3Provider()
+		p.OriginHost = originURL
+		p.Use(tracingMiddleware("first", &trace))
+		p.Use(tracingMiddleware("second", &trace), tracingMiddleware("third", &trace))
+		p.RegisterOperationHandler("GetObject", func(ctx context.Context, request *http_server.ProxiedRequest) (*http.Response, error) {
+			trace = append(trace, "handler")
+			return request.DoProxiedRequest(ctx, originURL)
+		})
+		return p
+	})
+	t.Cleanup(h.Close)
+
+	tests := []struct {
+		name      string
+		method    string
+		wantTrace []string
+	}{
+		{
+			name:      "registered handler",
+			method:    http.MethodGet,
+			wantTrace: []string{"first GetObject", "second GetObject", "third GetObject", "handler", "/third", "/second", "/first"},
+		},
+		{
+			name:      "default handler",
+			method:    http.MethodPut,
+			wantTrace: []string{"first PutObject", "second PutObject", "third PutObject", "/third", "/second", "/first"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace = nil
+			res, err := h.Do(h.NewSignedRequest(tt.method, "/bucket/key", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("got status %d", res.StatusCode)
+			}
+			if !reflect.DeepEqual(trace, tt.wantTrace) {
+				t.Errorf("got trace %v, want %v", trace, tt.wantTrace)
+			}
+		})
+	}
+}
+
+// Middleware is shared across providers, and can answer without calling the rest of the chain
+func TestOperationMiddlewareShortCircuit(t *testing.T) {
+	var trace []string
+	deny := func(next http_server.OperationHandler) http_server.OperationHandler {
+		return func(ctx context.Context, request *http_server.ProxiedRequest) (*http.Response, error) {
+			if request.Operation == "DeleteTable" {
+				return &http.Response{
+					StatusCode: http.StatusBadRequest,
+					Header:     http.Header{"Content-Type": {"application/x-amz-json-1.0"}},
+					Body:       io.NopCloser(strings.NewReader(`{"__type":"AccessDeniedException"}`)),
+				}, nil
+			}
+			return next(ctx, request)
+		}
+	}
+	h := iamtest.NewHarness(func(originURL string) http_server.AWSServiceProvider {
+		p := http_server.NewDynamoDBProvider()
+		p.OriginHost = originURL
+		p.Use(deny, tracingMiddleware("inner", &trace))
+		return p
+	})
+	t.Cleanup(h.Close)
+
+	for _, operation := range []string{"DeleteTable", "GetItem"} {
+		r := h.NewSignedRequest(http.MethodPost, "/", []byte(`{"TableName":"users"}`))
+		r.Header.Set("X-Amz-Target", "DynamoDB_20120810."+operation)
+		res, err := h.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+	}
+	if want := []string{"inner GetItem", "/inner"}; !reflect.DeepEqual(trace, want) {
+		t.Errorf("got trace %v, want %v", trace, want)
+	}
+	if n := len(h.Origin.Requests()); n != 1 {
+		t.Errorf("origin received %d requests, want only GetItem", n)
+	}
+}
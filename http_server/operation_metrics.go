@@ -0,0 +1,83 @@
+package http_server
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// OperationRecorder records how operation handlers perform, labeled by service and operation
+type OperationRecorder interface {
+	// HandlerInvoked records a handled operation. custom is whether a registered handler (rather than
+	// the default one) handled it, and failed is whether it returned an error.
+	HandlerInvoked(service, operation string, custom bool, duration time.Duration, failed bool)
+	// CacheResult records whether a caching handler or middleware served the operation from its cache
+	CacheResult(service, operation string, hit bool)
+	// TransformFailed records a response transformer returning an error
+	TransformFailed(service, operation string)
+}
+
+var (
+	operationHandlerInvocations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "iam_proxy_operation_handler_invocations_total",
+		Help: "Operations handled, by whether a registered (custom) or the default handler handled them",
+	}, []string{"service", "operation", "handler", "failed"})
+	operationHandlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "iam_proxy_operation_handler_duration_seconds",
+		Help:    "Time for operation handlers to return a response",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"service", "operation", "handler"})
+	operationCacheResults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "iam_proxy_operation_cache_results_total",
+		Help: "Cache lookups of caching operation handlers, by hit or miss",
+	}, []string{"service", "operation", "result"})
+	operationTransformFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "iam_proxy_operation_transform_failures_total",
+		Help: "Response transformers that returned an error",
+	}, []string{"service", "operation"})
+)
+
+// PrometheusOperationRecorder records to the default prometheus registry, served with the proxy metrics
+type PrometheusOperationRecorder struct{}
+
+func (PrometheusOperationRecorder) HandlerInvoked(service, operation string, custom bool, duration time.Duration, failed bool) {
+	handler := handlerLabel(custom)
+	operationHandlerInvocations.WithLabelValues(service, operation, handler, boolLabel(failed)).Inc()
+	operationHandlerDuration.WithLabelValues(service, operation, handler).Observe(duration.Seconds())
+}
+
+func (PrometheusOperationRecorder) CacheResult(service, operation string, hit bool) {
+	operationCacheResults.WithLabelValues(service, operation, cacheResultLabel(hit)).Inc()
+}
+
+func (PrometheusOperationRecorder) TransformFailed(service, operation string) {
+	operationTransformFailures.WithLabelValues(service, operation).Inc()
+}
+
+func handlerLabel(custom bool) string {
+	if custom {
+		return "custom"
+	}
+	return "default"
+}
+
+func boolLabel(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
+
+// RecordCacheResult lets caching handlers and middleware record whether they served the request from cache
+func (r *ProxiedRequest) RecordCacheResult(hit bool) {
+	if r.operationRecorder != nil {
+		r.operationRecorder.CacheResult(r.Service, r.Operation, hit)
+	}
+}
+
+func (r *ProxiedRequest) recordTransformFailed() {
+	if r.operationRecorder != nil {
+		r.operationRecorder.TransformFailed(r.Service, r.Operation)
+	}
+}
@@ -0,0 +1,67 @@
+package http_server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPrometheusOperationRecorderInvocations(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer origin.Close()
+	provider := NewS3Provider()
+	provider.OriginHost = origin.URL
+	provider.RegisterOperationHandler("GetObject", func(context.Context, *ProxiedRequest) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody}, nil
+	})
+	provider.RegisterOperationHandler("DeleteObject", func(context.Context, *ProxiedRequest) (*http.Response, error) {
+		return nil, ErrAWSAccessDenied
+	})
+	server := httptest.NewServer(&AWSProxy{
+		KeyLookupFunc: func(context.Context, string) (string, error) {
+			return "secret", nil
+		},
+		ServiceLookupFunc: func(context.Context, string) (AWSServiceProvider, error) {
+			return provider, nil
+		},
+	})
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		method    string
+		operation string
+		handler   string
+		failed    string
+	}{
+		{name: "custom handler", method: http.MethodGet, operation: "GetObject", handler: "custom", failed: "false"},
+		{name: "default proxy handler", method: http.MethodPut, operation: "PutObject", handler: "default", failed: "false"},
+		{name: "failed custom handler", method: http.MethodDelete, operation: "DeleteObject", handler: "custom", failed: "true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := operationHandlerInvocations.WithLabelValues("s3", tt.operation, tt.handler, tt.failed)
+			other := operationHandlerInvocations.WithLabelValues("s3", tt.operation, handlerLabel(tt.handler != "custom"), tt.failed)
+			before, otherBefore := testutil.ToFloat64(counter), testutil.ToFloat64(other)
+
+			r, _ := http.NewRequest(tt.method, server.URL+"/bucket/key", nil)
+			SignRequest(r, "AKIAMETRICS", "secret", "us-east-1", "s3", time.Now())
+			res, err := server.Client().Do(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("%s handler counted %v times, want once", tt.handler, got)
+			}
+			if got := testutil.ToFloat64(other) - otherBefore; got != 0 {
+				t.Errorf("the other handler label counted %v times", got)
+			}
+		})
+	}
+}
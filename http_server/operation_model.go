@@ -0,0 +1,91 @@
+package http_server
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Operation tables generated from the botocore models of services, see providergen. The models are pinned
+// to a botocore release so regenerating is reproducible, bump it to pick up new operations.
+// go generate downloads the models, so it needs network access.
+//go:generate go run ../providergen -operations-only -model https://raw.githubusercontent.com/boto/botocore/1.35.63/botocore/data/dynamodb/2012-08-10/service-2.json -name DynamoDB -out dynamodb_model_gen.go
+//go:generate go run ../providergen -operations-only -model https://raw.githubusercontent.com/boto/botocore/1.35.63/botocore/data/sqs/2012-11-05/service-2.json -name SQS -out sqs_model_gen.go
+
+// OperationSet is the operations of a service, e.g. generated from its API model by providergen
+type OperationSet map[string]bool
+
+// classify returns the operation if it is in the set (or the set is nil), otherwise OperationUnknown
+func (s OperationSet) classify(operation string) string {
+	if operation == "" || (s != nil && !s[operation]) {
+		return OperationUnknown
+	}
+	return operation
+}
+
+// OperationRoute is the HTTP binding of an operation of a REST protocol service, e.g. Lambda's
+// Invoke is POST /2015-03-31/functions/{FunctionName}/invocations
+type OperationRoute struct {
+	Operation string
+	Method    string
+	// RequestURI is the path template, where {Label} matches a segment and {Label+} the rest of the path,
+	// optionally followed by required query parameters, e.g. /{Bucket}/{Key+}?uploads
+	RequestURI string
+}
+
+// MatchOperationRoute returns the operation of the most specific route matching the request, or
+// OperationUnknown. Routes that require query parameters are more specific, then those with more literal
+// path segments. Paths are matched as sent, so virtual-hosted S3 requests need their bucket prepended.
+func MatchOperationRoute(routes []OperationRoute, r *http.Request) string {
+	operation, best := OperationUnknown, -1
+	query := r.URL.Query()
+	segments := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	for _, route := range routes {
+		if route.Method != r.Method {
+			continue
+		}
+		score, ok := matchRoute(route.RequestURI, segments, query)
+		if ok && score > best {
+			operation, best = route.Operation, score
+		}
+	}
+	return operation
+}
+
+// matchRoute matches a request URI template against the path segments and query of a request, scoring
+// how specific the match is
+func matchRoute(requestURI string, segments []string, query map[string][]string) (int, bool) {
+	pathTemplate, queryTemplate, _ := strings.Cut(requestURI, "?")
+	score := 0
+	if queryTemplate != "" {
+		for _, param := range strings.Split(queryTemplate, "&") {
+			key, value, hasValue := strings.Cut(param, "=")
+			vals, present := query[key]
+			if !present || (hasValue && (len(vals) == 0 || vals[0] != value)) {
+				return 0, false
+			}
+			score += 1000
+		}
+	}
+
+	templateSegments := strings.Split(strings.TrimPrefix(pathTemplate, "/"), "/")
+	for i, templateSegment := range templateSegments {
+		if strings.HasPrefix(templateSegment, "{") && strings.HasSuffix(templateSegment, "+}") {
+			// Greedy labels match the rest of the path, which can't be empty
+			return score, i < len(segments) && strings.Join(segments[i:], "") != ""
+		}
+		if i >= len(segments) {
+			return 0, templateSegment == ""
+		}
+		if strings.HasPrefix(templateSegment, "{") {
+			if segments[i] == "" {
+				return 0, false
+			}
+			continue
+		}
+		if templateSegment != segments[i] {
+			return 0, false
+		}
+		score += 10
+	}
+	return score, len(segments) == len(templateSegments)
+}
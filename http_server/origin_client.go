@@ -0,0 +1,167 @@
+package http_server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+// OriginTarget describes the origin that a proxied request is about to be sent to
+type OriginTarget struct {
+	Host    string
+	Service string
+	Region  string
+}
+
+// OriginClientProvider customizes outbound requests per origin, e.g. to go through a
+// corporate egress proxy, trust custom root CAs for an internal S3-compatible store,
+// or inject headers like a VPC endpoint token.
+type OriginClientProvider interface {
+	// OriginClient returns the client to send the request to the origin with, and any extra
+	// headers that should be merged into the outbound request before it is sent.
+	// Extra headers are not part of the re-signed request.
+	OriginClient(ctx context.Context, origin OriginTarget) (*http.Client, http.Header, error)
+}
+
+// OriginClientProviderFunc allows a plain function to be used as an OriginClientProvider
+type OriginClientProviderFunc func(ctx context.Context, origin OriginTarget) (*http.Client, http.Header, error)
+
+func (f OriginClientProviderFunc) OriginClient(ctx context.Context, origin OriginTarget) (*http.Client, http.Header, error) {
+	return f(ctx, origin)
+}
+
+// DefaultExpectContinueTimeout is how long to wait for the origin's 100 Continue before sending the body anyway
+const DefaultExpectContinueTimeout = time.Second
+
+// defaultOriginClient waits for 100 Continue when the client sent Expect: 100-continue, the body is only read from
+// the client (which sends the client its own 100 Continue) once the origin is ready for it
+var defaultOriginClient = lo.Must(NewOriginHTTPClient(OriginHTTPClientOptions{}))
+
+// DefaultOriginClientProvider uses a client with the default OriginHTTPClientOptions, which reuses keep-alive
+// connections and negotiates HTTP/2, and adds no headers
+type DefaultOriginClientProvider struct{}
+
+func (DefaultOriginClientProvider) OriginClient(context.Context, OriginTarget) (*http.Client, http.Header, error) {
+	return defaultOriginClient, nil, nil
+}
+
+type OriginHTTPClientOptions struct {
+	// ProxyURL is an optional HTTP proxy to send origin requests through
+	ProxyURL string
+	// RootCAsPEM are optional PEM encoded root CAs to trust in addition to the system pool
+	RootCAsPEM []byte
+	// InsecureSkipVerify disables TLS verification of the origin, only use this for testing
+	InsecureSkipVerify bool
+	// ClientCertPEM and ClientKeyPEM are an optional PEM encoded client certificate for mTLS
+	ClientCertPEM []byte
+	ClientKeyPEM  []byte
+	// ExpectContinueTimeout defaults to DefaultExpectContinueTimeout
+	ExpectContinueTimeout time.Duration
+	// HTTP2 selects the protocol to origins, defaults to OriginHTTP2Prefer
+	HTTP2 OriginHTTP2Mode
+	// HTTP2PingTimeout optionally pings idle HTTP/2 connections after this long, closing them if the ping
+	// isn't answered, so a dead connection isn't reused for every multiplexed request
+	HTTP2PingTimeout time.Duration
+	// MaxConnsPerHost optionally limits connections per origin, requests wait for a free one
+	MaxConnsPerHost int
+	// MaxIdleConnsPerHost is how many keep-alive connections to keep per origin, defaults to 100,
+	// since http.DefaultTransport's 2 churns connections to high-throughput origins
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout optionally overrides how long idle connections are kept
+	IdleConnTimeout time.Duration
+	// KeepAlive optionally overrides the TCP keep-alive period of origin connections
+	KeepAlive time.Duration
+}
+
+// OriginHTTP2Mode is whether to use HTTP/2 to origins
+type OriginHTTP2Mode int
+
+const (
+	// OriginHTTP2Prefer negotiates HTTP/2 over TLS with ALPN, falling back to HTTP/1.1
+	OriginHTTP2Prefer OriginHTTP2Mode = iota
+	// OriginHTTP2Force only uses HTTP/2, with prior knowledge (h2c) for http:// origins
+	OriginHTTP2Force
+	// OriginHTTP2Disable only uses HTTP/1.1
+	OriginHTTP2Disable
+)
+
+// defaultOriginMaxIdleConnsPerHost is the default of OriginHTTPClientOptions.MaxIdleConnsPerHost
+const defaultOriginMaxIdleConnsPerHost = 100
+
+// NewOriginHTTPClient builds an *http.Client for use in an OriginClientProvider
+func NewOriginHTTPClient(opts OriginHTTPClientOptions) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ExpectContinueTimeout = lo.Ternary(opts.ExpectContinueTimeout > 0, opts.ExpectContinueTimeout, DefaultExpectContinueTimeout)
+	// Responses are forwarded byte for byte, the transport must not ask for and transparently decode gzip,
+	// which would drop Content-Length and Content-Encoding
+	transport.DisableCompression = true
+
+	var protocols http.Protocols
+	switch opts.HTTP2 {
+	case OriginHTTP2Force:
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+	case OriginHTTP2Disable:
+		protocols.SetHTTP1(true)
+	default:
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+	}
+	transport.Protocols = &protocols
+	if opts.HTTP2PingTimeout > 0 {
+		transport.HTTP2 = &http.HTTP2Config{SendPingTimeout: opts.HTTP2PingTimeout}
+	}
+
+	transport.MaxConnsPerHost = opts.MaxConnsPerHost
+	transport.MaxIdleConnsPerHost = lo.Ternary(opts.MaxIdleConnsPerHost > 0, opts.MaxIdleConnsPerHost, defaultOriginMaxIdleConnsPerHost)
+	if opts.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = opts.IdleConnTimeout
+	}
+	if opts.KeepAlive > 0 {
+		transport.DialContext = (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: opts.KeepAlive,
+		}).DialContext
+	}
+
+	if opts.ProxyURL != "" {
+		proxyURL, err := url.Parse(opts.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("error in url.Parse for proxy url: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if len(opts.RootCAsPEM) > 0 || opts.InsecureSkipVerify || len(opts.ClientCertPEM) > 0 {
+		tlsConfig := &tls.Config{
+			InsecureSkipVerify: opts.InsecureSkipVerify,
+		}
+		if len(opts.RootCAsPEM) > 0 {
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(opts.RootCAsPEM) {
+				return nil, fmt.Errorf("no valid certificates found in RootCAsPEM")
+			}
+			tlsConfig.RootCAs = pool
+		}
+		if len(opts.ClientCertPEM) > 0 {
+			cert, err := tls.X509KeyPair(opts.ClientCertPEM, opts.ClientKeyPEM)
+			if err != nil {
+				return nil, fmt.Errorf("error in tls.X509KeyPair: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
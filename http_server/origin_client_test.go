@@ -0,0 +1,176 @@
+package http_server_test
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+// protoOrigin records the protocol and connection of each request
+type protoOrigin struct {
+	mu          sync.Mutex
+	protoMajors []int
+	conns       map[string]bool
+}
+
+func (o *protoOrigin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.protoMajors = append(o.protoMajors, r.ProtoMajor)
+	o.conns[r.RemoteAddr] = true
+}
+
+func TestOriginHTTP2(t *testing.T) {
+	tests := []struct {
+		name      string
+		tls       bool
+		mode      http_server.OriginHTTP2Mode
+		wantMajor int
+	}{
+		{name: "prefer over tls", tls: true, mode: http_server.OriginHTTP2Prefer, wantMajor: 2},
+		{name: "force over tls", tls: true, mode: http_server.OriginHTTP2Force, wantMajor: 2},
+		{name: "disable over tls", tls: true, mode: http_server.OriginHTTP2Disable, wantMajor: 1},
+		{name: "prefer over h2c", mode: http_server.OriginHTTP2Prefer, wantMajor: 1},
+		{name: "force over h2c", mode: http_server.OriginHTTP2Force, wantMajor: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &protoOrigin{conns: map[string]bool{}}
+			origin := httptest.NewUnstartedServer(recorder)
+			opts := http_server.OriginHTTPClientOptions{HTTP2: tt.mode}
+			if tt.tls {
+				origin.EnableHTTP2 = true
+				origin.StartTLS()
+				opts.RootCAsPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: origin.Certificate().Raw})
+			} else {
+				origin.Config.Protocols = &http.Protocols{}
+				origin.Config.Protocols.SetHTTP1(true)
+				origin.Config.Protocols.SetUnencryptedHTTP2(true)
+				origin.Start()
+			}
+			defer origin.Close()
+
+			client, err := http_server.NewOriginHTTPClient(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := iamtest.NewHarness(func(string) http_server.AWSServiceProvider {
+				p := http_server.NewS3Provider()
+				p.OriginHost = origin.URL
+				return p
+			})
+			defer h.Close()
+			h.Proxy.OriginClientProvider = http_server.OriginClientProviderFunc(func(context.Context, http_server.OriginTarget) (*http.Client, http.Header, error) {
+				return client, nil, nil
+			})
+
+			for i := 0; i < 3; i++ {
+				res, err := h.Do(h.NewSignedRequest(http.MethodGet, "/bucket/key", nil))
+				if err != nil {
+					t.Fatal(err)
+				}
+				io.Copy(io.Discard, res.Body)
+				res.Body.Close()
+				if res.StatusCode != http.StatusOK {
+					t.Fatalf("got status %d", res.StatusCode)
+				}
+			}
+
+			recorder.mu.Lock()
+			defer recorder.mu.Unlock()
+			if len(recorder.protoMajors) != 3 {
+				t.Fatalf("origin got %d requests", len(recorder.protoMajors))
+			}
+			for _, major := range recorder.protoMajors {
+				if major != tt.wantMajor {
+					t.Fatalf("origin got HTTP/%d requests, want HTTP/%d", major, tt.wantMajor)
+				}
+			}
+			// Sequential requests reuse the connection
+			if len(recorder.conns) != 1 {
+				t.Errorf("origin got %d connections, want 1", len(recorder.conns))
+			}
+		})
+	}
+}
+
+// roundTripCounter is a custom transport in front of the default one
+type roundTripCounter struct {
+	mu    sync.Mutex
+	hosts []string
+}
+
+func (c *roundTripCounter) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	c.hosts = append(c.hosts, r.URL.Host)
+	c.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestOriginClientProvider(t *testing.T) {
+	h := newS3Harness(t)
+	transport := &roundTripCounter{}
+	var targets []http_server.OriginTarget
+	h.Proxy.OriginClientProvider = http_server.OriginClientProviderFunc(func(_ context.Context, origin http_server.OriginTarget) (*http.Client, http.Header, error) {
+		targets = append(targets, origin)
+		return &http.Client{Transport: transport}, http.Header{"X-Vpce-Token": {"vpce-123"}}, nil
+	})
+
+	res, err := h.Do(h.NewSignedRequest(http.MethodGet, "/bucket/key", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d", res.StatusCode)
+	}
+
+	originHost := strings.TrimPrefix(h.Origin.URL, "http://")
+	if len(targets) != 1 || targets[0] != (http_server.OriginTarget{Host: originHost, Service: "s3", Region: iamtest.Region}) {
+		t.Errorf("got targets %+v", targets)
+	}
+	if len(transport.hosts) != 1 || transport.hosts[0] != originHost {
+		t.Errorf("custom transport sent %v", transport.hosts)
+	}
+	requests := h.Origin.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("origin received %d requests", len(requests))
+	}
+	if got := requests[0].Header.Get("X-Vpce-Token"); got != "vpce-123" {
+		t.Errorf("origin received X-Vpce-Token %q", got)
+	}
+	// Extra headers are added after signing, the origin's signature doesn't depend on them
+	if auth := requests[0].Header.Get("Authorization"); strings.Contains(auth, "x-vpce-token") {
+		t.Errorf("extra header was signed: %s", auth)
+	}
+}
+
+func TestOriginClientProviderError(t *testing.T) {
+	h := newS3Harness(t)
+	h.Proxy.OriginClientProvider = http_server.OriginClientProviderFunc(func(context.Context, http_server.OriginTarget) (*http.Client, http.Header, error) {
+		return nil, nil, errors.New("no client for origin")
+	})
+
+	res, err := h.Do(h.NewSignedRequest(http.MethodGet, "/bucket/key", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusInternalServerError || !strings.Contains(string(body), "<Code>InternalError</Code>") {
+		t.Errorf("got %d %s, want an InternalError", res.StatusCode, body)
+	}
+	if n := len(h.Origin.Requests()); n != 0 {
+		t.Errorf("origin received %d requests", n)
+	}
+}
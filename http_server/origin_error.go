@@ -0,0 +1,57 @@
+package http_server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/danthegoodman1/IAMTheService/utils"
+)
+
+// originTransportError maps an error reaching the origin (rather than an error response from it) to the
+// AWS error SDKs expect, so they get a parseable (and retryable) response instead of an InternalError.
+// AWS errors, like ErrAWSGatewayTimeout, are returned as is.
+func originTransportError(err error) error {
+	if _, ok := utils.AsErr[*AWSError](err); ok {
+		return err
+	}
+	if errors.Is(err, ErrChunkSignatureMismatch) {
+		// The client's streaming signatures are verified as the body is sent to the origin
+		return reject(RejectionInvalidSignature, fmt.Errorf("%w: %w", ErrAWSSignatureDoesNotMatch, err))
+	}
+	if errors.Is(err, ErrNoHealthyEndpoints) {
+		return fmt.Errorf("%w: %w", ErrAWSOriginUnavailable, err)
+	}
+	if errors.Is(err, ErrPayloadHashMismatch) {
+		// A large body failed PayloadVerification at its end, after the origin request started
+		return reject(RejectionPayloadMismatch, fmt.Errorf("%w: %w", ErrAWSContentSHA256Mismatch, err))
+	}
+
+	var (
+		netErr      net.Error
+		dnsErr      *net.DNSError
+		opErr       *net.OpError
+		certErr     *tls.CertificateVerificationError
+		unknownAuth x509.UnknownAuthorityError
+		hostnameErr x509.HostnameError
+		recordErr   tls.RecordHeaderError
+	)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return fmt.Errorf("%w: %w", ErrAWSRequestTimeout, err)
+	case errors.As(err, &dnsErr),
+		errors.As(err, &opErr),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.As(err, &certErr),
+		errors.As(err, &unknownAuth),
+		errors.As(err, &hostnameErr),
+		errors.As(err, &recordErr):
+		return fmt.Errorf("%w: %w", ErrAWSOriginUnavailable, err)
+	}
+	return err
+}
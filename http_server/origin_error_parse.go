@@ -0,0 +1,145 @@
+package http_server
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+// maxOriginErrorBytes bounds how much of an origin error body is read to parse it
+const maxOriginErrorBytes = 64 * 1024
+
+// ErrorClass is whether an origin error is worth retrying
+type ErrorClass string
+
+const (
+	// ErrorClassPermanent is a client error that will fail again, e.g. NoSuchKey or AccessDenied
+	ErrorClassPermanent ErrorClass = "permanent"
+	// ErrorClassTransient is an origin failure that may succeed on retry, e.g. InternalError
+	ErrorClassTransient ErrorClass = "transient"
+	// ErrorClassThrottle is the origin asking to slow down, e.g. SlowDown
+	ErrorClassThrottle ErrorClass = "throttle"
+)
+
+var (
+	throttleErrorCodes = []string{
+		"Throttling", "ThrottlingException", "ThrottledException", "RequestThrottledException", "TooManyRequestsException",
+		"ProvisionedThroughputExceededException", "TransactionInProgressException", "RequestLimitExceeded",
+		"BandwidthLimitExceeded", "LimitExceededException", "RequestThrottled", "SlowDown", "PriorRequestNotComplete",
+		"EC2ThrottledException",
+	}
+	transientErrorCodes = []string{
+		"RequestTimeout", "RequestTimeoutException", "InternalError", "InternalServerError", "InternalFailure",
+		"ServiceUnavailable", "ServiceUnavailableException",
+	}
+)
+
+// OriginError is the parsed error response of an origin
+type OriginError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Class      ErrorClass
+}
+
+func (e *OriginError) Error() string {
+	return fmt.Sprintf("origin error %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// ParseOriginError parses the error code and message of an origin error response of the protocol, and classifies it.
+// At most the first 64KiB of the body is read, and the body is restored so the response can still be
+// streamed to the client. Returns nil for responses that aren't errors.
+func ParseOriginError(protocol AWSProtocol, res *http.Response) (*OriginError, error) {
+	if res.StatusCode < 400 {
+		return nil, nil
+	}
+
+	originErr := &OriginError{StatusCode: res.StatusCode}
+	if res.Body != nil && res.Body != http.NoBody {
+		head, err := io.ReadAll(io.LimitReader(res.Body, maxOriginErrorBytes))
+		res.Body = readCloser{
+			Reader: io.MultiReader(bytes.NewReader(head), res.Body),
+			Closer: res.Body,
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading origin error body: %w", err)
+		}
+
+		if protocol == ProtocolJSON {
+			originErr.Code, originErr.Message = parseJSONError(head)
+		} else {
+			originErr.Code, originErr.Message = parseXMLError(head)
+		}
+	}
+	if originErr.Code == "" {
+		// JSON protocols also send the code in a header, and HEAD errors have no body at all
+		originErr.Code, _, _ = strings.Cut(res.Header.Get("X-Amzn-ErrorType"), ":")
+	}
+	originErr.Class = classifyOriginError(originErr.StatusCode, originErr.Code)
+	return originErr, nil
+}
+
+func classifyOriginError(statusCode int, code string) ErrorClass {
+	switch {
+	case lo.Contains(throttleErrorCodes, code), statusCode == http.StatusTooManyRequests:
+		return ErrorClassThrottle
+	case lo.Contains(transientErrorCodes, code), statusCode >= 500:
+		return ErrorClassTransient
+	default:
+		return ErrorClassPermanent
+	}
+}
+
+// parseJSONError reads {"__type": "com.amazonaws.dynamodb.v20120810#ProvisionedThroughputExceededException", "message": "..."}
+func parseJSONError(body []byte) (code, message string) {
+	var jsonErr struct {
+		Type         string `json:"__type"`
+		Code         string `json:"code"`
+		Message      string `json:"message"`
+		MessageUpper string `json:"Message"`
+	}
+	if err := json.Unmarshal(body, &jsonErr); err != nil {
+		return "", ""
+	}
+	code = lo.Ternary(jsonErr.Type != "", jsonErr.Type, jsonErr.Code)
+	if i := strings.LastIndex(code, "#"); i >= 0 {
+		code = code[i+1:]
+	}
+	return code, lo.Ternary(jsonErr.Message != "", jsonErr.Message, jsonErr.MessageUpper)
+}
+
+// parseXMLError reads the first Code and Message elements, which covers both REST-XML (<Error>) and
+// query protocol (<ErrorResponse><Error>) errors
+func parseXMLError(body []byte) (code, message string) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	for code == "" || message == "" {
+		token, err := decoder.Token()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				logger.Debug().Err(err).Msg("error parsing origin xml error")
+			}
+			return code, message
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || (start.Name.Local != "Code" && start.Name.Local != "Message") {
+			continue
+		}
+		var value string
+		if err = decoder.DecodeElement(&value, &start); err != nil {
+			return code, message
+		}
+		if start.Name.Local == "Code" && code == "" {
+			code = value
+		} else if start.Name.Local == "Message" && message == "" {
+			message = value
+		}
+	}
+	return code, message
+}
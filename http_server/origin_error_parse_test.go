@@ -0,0 +1,108 @@
+package http_server_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+)
+
+func TestParseOriginError(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol http_server.AWSProtocol
+		status   int
+		header   http.Header
+		body     string
+		// want is nil for responses that aren't errors
+		want *http_server.OriginError
+	}{
+		{
+			name:     "rest-xml",
+			protocol: http_server.ProtocolRESTXML,
+			status:   http.StatusNotFound,
+			body:     `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message><Key>key</Key></Error>`,
+			want:     &http_server.OriginError{StatusCode: http.StatusNotFound, Code: "NoSuchKey", Message: "The specified key does not exist.", Class: http_server.ErrorClassPermanent},
+		},
+		{
+			name:     "rest-xml throttle",
+			protocol: http_server.ProtocolRESTXML,
+			status:   http.StatusServiceUnavailable,
+			body:     `<Error><Code>SlowDown</Code><Message>Please reduce your request rate.</Message></Error>`,
+			want:     &http_server.OriginError{StatusCode: http.StatusServiceUnavailable, Code: "SlowDown", Message: "Please reduce your request rate.", Class: http_server.ErrorClassThrottle},
+		},
+		{
+			name:     "query",
+			protocol: http_server.ProtocolQuery,
+			status:   http.StatusBadRequest,
+			body:     `<ErrorResponse><Error><Type>Sender</Type><Code>Throttling</Code><Message>Rate exceeded</Message></Error><RequestId>id</RequestId></ErrorResponse>`,
+			want:     &http_server.OriginError{StatusCode: http.StatusBadRequest, Code: "Throttling", Message: "Rate exceeded", Class: http_server.ErrorClassThrottle},
+		},
+		{
+			name:     "json",
+			protocol: http_server.ProtocolJSON,
+			status:   http.StatusBadRequest,
+			body:     `{"__type":"com.amazonaws.dynamodb.v20120810#ProvisionedThroughputExceededException","message":"The level of configured provisioned throughput for the table was exceeded."}`,
+			want:     &http_server.OriginError{StatusCode: http.StatusBadRequest, Code: "ProvisionedThroughputExceededException", Message: "The level of configured provisioned throughput for the table was exceeded.", Class: http_server.ErrorClassThrottle},
+		},
+		{
+			name:     "json code and capitalized message",
+			protocol: http_server.ProtocolJSON,
+			status:   http.StatusBadRequest,
+			body:     `{"code":"ResourceNotFoundException","Message":"Stream not found"}`,
+			want:     &http_server.OriginError{StatusCode: http.StatusBadRequest, Code: "ResourceNotFoundException", Message: "Stream not found", Class: http_server.ErrorClassPermanent},
+		},
+		{
+			name:     "unparseable json falls back to the error type header",
+			protocol: http_server.ProtocolJSON,
+			status:   http.StatusInternalServerError,
+			header:   http.Header{"X-Amzn-Errortype": {"InternalFailure:http://internal.amazon.com/coral/com.amazon.coral.service/"}},
+			body:     `<html>Internal Server Error</html>`,
+			want:     &http_server.OriginError{StatusCode: http.StatusInternalServerError, Code: "InternalFailure", Class: http_server.ErrorClassTransient},
+		},
+		{
+			name:     "unparseable xml is classified by status",
+			protocol: http_server.ProtocolRESTXML,
+			status:   http.StatusBadGateway,
+			body:     `upstream connect error`,
+			want:     &http_server.OriginError{StatusCode: http.StatusBadGateway, Class: http_server.ErrorClassTransient},
+		},
+		{
+			name:     "empty throttling response",
+			protocol: http_server.ProtocolRESTXML,
+			status:   http.StatusTooManyRequests,
+			want:     &http_server.OriginError{StatusCode: http.StatusTooManyRequests, Class: http_server.ErrorClassThrottle},
+		},
+		{
+			name:     "not an error",
+			protocol: http_server.ProtocolRESTXML,
+			status:   http.StatusOK,
+			body:     `<Error><Code>InternalError</Code></Error>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &http.Response{
+				StatusCode: tt.status,
+				Header:     http.Header{},
+				Body:       io.NopCloser(strings.NewReader(tt.body)),
+			}
+			if tt.header != nil {
+				res.Header = tt.header
+			}
+			got, err := http_server.ParseOriginError(tt.protocol, res)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			// The body is still there to stream to the client
+			if body, _ := io.ReadAll(res.Body); string(body) != tt.body {
+				t.Errorf("got body %q after parsing, want %q", body, tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package http_server
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+// DefaultOriginOverrideHeader is the default header of OriginOverride
+const DefaultOriginOverrideHeader = "X-IAM-Origin-Override"
+
+// OriginOverride lets trusted internal callers send a request to a different origin than routing would
+// (e.g. canarying a new backend with real signed traffic). The request is re-signed for the override.
+// The header is ignored unless the peer is trusted and the key is allowed.
+type OriginOverride struct {
+	// Header defaults to DefaultOriginOverrideHeader
+	Header string
+	// TrustedPeers are the peers (the direct connection, not X-Forwarded-For) that may override the origin
+	TrustedPeers []netip.Prefix
+	// KeyIDs are the keys whose requests may be overridden
+	KeyIDs []string
+	// AllowedOrigins optionally restricts what the origin can be overridden to
+	AllowedOrigins []string
+}
+
+func (o *OriginOverride) header() string {
+	if o.Header == "" {
+		return DefaultOriginOverrideHeader
+	}
+	return o.Header
+}
+
+// resolve returns the origin to override to, or "" if the request can't override it.
+// The header is removed from the request unless the client signed it, so it doesn't reach the origin.
+func (o *OriginOverride) resolve(r *http.Request, parsedHeader AWSAuthHeader) string {
+	origin := r.Header.Get(o.header())
+	if origin == "" {
+		return ""
+	}
+	if !lo.Contains(parsedHeader.SignedHeaders, strings.ToLower(o.header())) {
+		r.Header.Del(o.header())
+	}
+
+	peer := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		peer = host
+	}
+	if !isTrustedProxy(peer, o.TrustedPeers) || !lo.Contains(o.KeyIDs, parsedHeader.Credential.KeyID) {
+		logger.Warn().Str("peer", peer).Str("keyID", parsedHeader.Credential.KeyID).Msg("ignoring untrusted origin override")
+		return ""
+	}
+	if len(o.AllowedOrigins) > 0 && !lo.Contains(o.AllowedOrigins, origin) {
+		logger.Warn().Str("origin", origin).Msg("ignoring origin override to a disallowed origin")
+		return ""
+	}
+	return origin
+}
@@ -0,0 +1,81 @@
+package http_server_test
+
+import (
+	"io"
+	"net/http"
+	"net/netip"
+	"testing"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+func TestOriginOverride(t *testing.T) {
+	canary := iamtest.NewFakeOrigin()
+	t.Cleanup(canary.Close)
+
+	tests := []struct {
+		name       string
+		override   http_server.OriginOverride
+		header     string
+		wantCanary bool
+	}{
+		{
+			name:       "trusted",
+			override:   http_server.OriginOverride{KeyIDs: []string{iamtest.KeyID}},
+			header:     canary.URL,
+			wantCanary: true,
+		},
+		{
+			name:       "allowed origin",
+			override:   http_server.OriginOverride{KeyIDs: []string{iamtest.KeyID}, AllowedOrigins: []string{canary.URL}},
+			header:     canary.URL,
+			wantCanary: true,
+		},
+		{name: "no header", override: http_server.OriginOverride{KeyIDs: []string{iamtest.KeyID}}},
+		{
+			name:     "key not allowed",
+			override: http_server.OriginOverride{KeyIDs: []string{"AKIAOTHER"}},
+			header:   canary.URL,
+		},
+		{
+			name:     "origin not allowed",
+			override: http_server.OriginOverride{KeyIDs: []string{iamtest.KeyID}, AllowedOrigins: []string{"http://minio:9000"}},
+			header:   canary.URL,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newS3Harness(t)
+			override := tt.override
+			override.TrustedPeers = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+			h.Proxy.OriginOverride = &override
+			beforeCanary := len(canary.Requests())
+
+			r := h.NewSignedRequest(http.MethodGet, "/bucket/key", nil)
+			if tt.header != "" {
+				r.Header.Set(http_server.DefaultOriginOverrideHeader, tt.header)
+			}
+			res, err := h.Do(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("got status %d", res.StatusCode)
+			}
+
+			requests, otherRequests := h.Origin.Requests(), canary.Requests()[beforeCanary:]
+			if tt.wantCanary {
+				requests, otherRequests = otherRequests, requests
+			}
+			if len(requests) != 1 || len(otherRequests) != 0 {
+				t.Fatalf("got %d requests at the expected origin and %d at the other", len(requests), len(otherRequests))
+			}
+			if got := requests[0].Header.Get(http_server.DefaultOriginOverrideHeader); got != "" {
+				t.Errorf("the origin received the override header %q", got)
+			}
+		})
+	}
+}
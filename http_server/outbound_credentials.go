@@ -0,0 +1,96 @@
+package http_server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultCredentialsRefreshBefore is the default RefreshingOutboundCredentials.RefreshBefore
+const DefaultCredentialsRefreshBefore = 5 * time.Minute
+
+// OutboundCredentialsProvider picks the credentials a request is re-signed with for its origin. Clients sign
+// with virtual (e.g. per-tenant) keys that the proxy verifies, and the origin only sees real AWS credentials.
+// Without one, requests are re-signed with the client's own key.
+type OutboundCredentialsProvider interface {
+	// OutboundCredentials returns the credentials to sign the request to origin with.
+	// request.KeyID and request.Principal are the key the client signed with.
+	OutboundCredentials(ctx context.Context, origin OriginTarget, request *ProxiedRequest) (SigningCredentials, error)
+}
+
+// OutboundCredentialsProviderFunc allows a plain function to be used as an OutboundCredentialsProvider
+type OutboundCredentialsProviderFunc func(ctx context.Context, origin OriginTarget, request *ProxiedRequest) (SigningCredentials, error)
+
+func (f OutboundCredentialsProviderFunc) OutboundCredentials(ctx context.Context, origin OriginTarget, request *ProxiedRequest) (SigningCredentials, error) {
+	return f(ctx, origin, request)
+}
+
+// StaticOutboundCredentials re-signs every request with the same credentials, e.g. an IAM user's key pair
+type StaticOutboundCredentials SigningCredentials
+
+func (c StaticOutboundCredentials) OutboundCredentials(context.Context, OriginTarget, *ProxiedRequest) (SigningCredentials, error) {
+	return SigningCredentials(c), nil
+}
+
+// RefreshingOutboundCredentials caches expiring credentials, e.g. the session credentials of an assumed role,
+// per origin service and region, and refreshes them before they expire. Requests to an origin wait for its
+// refresh, and if a refresh fails the cached credentials are used until they expire.
+type RefreshingOutboundCredentials struct {
+	// Refresh fetches new credentials for the origin and when they expire, e.g. with sts:AssumeRole
+	Refresh func(ctx context.Context, origin OriginTarget) (SigningCredentials, time.Time, error)
+	// RefreshBefore defaults to DefaultCredentialsRefreshBefore
+	RefreshBefore time.Duration
+	// Clock defaults to RealClock
+	Clock Clock
+
+	mu      sync.Mutex
+	entries map[string]*outboundCredentialsEntry
+}
+
+type outboundCredentialsEntry struct {
+	mu      sync.Mutex
+	creds   SigningCredentials
+	expires time.Time
+}
+
+func (p *RefreshingOutboundCredentials) entry(origin OriginTarget) *outboundCredentialsEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.entries == nil {
+		p.entries = map[string]*outboundCredentialsEntry{}
+	}
+	key := origin.Service + "/" + origin.Region
+	e, ok := p.entries[key]
+	if !ok {
+		e = &outboundCredentialsEntry{}
+		p.entries[key] = e
+	}
+	return e
+}
+
+func (p *RefreshingOutboundCredentials) OutboundCredentials(ctx context.Context, origin OriginTarget, _ *ProxiedRequest) (SigningCredentials, error) {
+	e := p.entry(origin)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	refreshBefore := p.RefreshBefore
+	if refreshBefore == 0 {
+		refreshBefore = DefaultCredentialsRefreshBefore
+	}
+	now := clockOrReal(p.Clock).Now()
+	if now.Before(e.expires.Add(-refreshBefore)) {
+		return e.creds, nil
+	}
+
+	creds, expires, err := p.Refresh(ctx, origin)
+	if err != nil {
+		if now.Before(e.expires) {
+			logger.Warn().Err(err).Str("service", origin.Service).Msg("error refreshing outbound credentials, using cached credentials until they expire")
+			return e.creds, nil
+		}
+		return SigningCredentials{}, fmt.Errorf("error in Refresh: %w", err)
+	}
+	e.creds, e.expires = creds, expires
+	return creds, nil
+}
@@ -0,0 +1,148 @@
+package http_server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultVerifyFirstMaxBytes is the default PayloadVerification.VerifyFirstMaxBytes
+const DefaultVerifyFirstMaxBytes = 1024 * 1024
+
+var (
+	ErrPayloadHashMismatch      = errors.New("payload does not match x-amz-content-sha256")
+	ErrAWSContentSHA256Mismatch = NewAWSError(http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed.")
+)
+
+// PayloadVerification checks that the body matches the x-amz-content-sha256 the client signed, so a
+// tampered body can't ride on a valid signature to an origin that doesn't check it.
+//
+// Bodies up to VerifyFirstMaxBytes are buffered and verified before anything is forwarded. Larger bodies are
+// hashed as they stream to the origin, since buffering large uploads isn't acceptable, so a mismatch can
+// only be detected at the end: the outbound body then fails instead of ending, which aborts the origin
+// request before it is complete (the origin never sees a well-formed request). Bodies of requests expecting
+// 100-continue are also streamed, since reading them would continue the client before the origin accepted the
+// request. Unsigned and aws-chunked payloads have no hash to verify.
+type PayloadVerification struct {
+	// VerifyFirstMaxBytes defaults to DefaultVerifyFirstMaxBytes, negative streams every body
+	VerifyFirstMaxBytes int64
+}
+
+func (v *PayloadVerification) verifyFirstMaxBytes() int64 {
+	if v.VerifyFirstMaxBytes == 0 {
+		return DefaultVerifyFirstMaxBytes
+	}
+	return v.VerifyFirstMaxBytes
+}
+
+// verify verifies small bodies, and wraps the request body of larger ones to verify it as it streams
+func (v *PayloadVerification) verify(r *http.Request) error {
+	if isAWSChunked(r) {
+		// Framed bodies are verified per chunk, if signed
+		return nil
+	}
+	declared := strings.ToLower(r.Header.Get("x-amz-content-sha256"))
+	expected, err := hex.DecodeString(declared)
+	if err != nil || len(expected) != sha256.Size {
+		// UNSIGNED-PAYLOAD, STREAMING-*, or missing
+		return nil
+	}
+
+	expectContinue := strings.EqualFold(r.Header.Get("Expect"), "100-continue")
+	if r.ContentLength >= 0 && r.ContentLength <= v.verifyFirstMaxBytes() && !expectContinue {
+		body, err := io.ReadAll(io.LimitReader(r.Body, r.ContentLength+1))
+		if err != nil {
+			return fmt.Errorf("error reading body: %w", err)
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if sum := sha256.Sum256(body); !bytes.Equal(sum[:], expected) {
+			return fmt.Errorf("%w: %w", ErrAWSContentSHA256Mismatch, ErrPayloadHashMismatch)
+		}
+		return nil
+	}
+
+	r.Body = readCloser{
+		Reader: &hashVerifyingReader{r: r.Body, hash: sha256.New(), expected: expected},
+		Closer: r.Body,
+	}
+	return nil
+}
+
+// hashVerifyingReader returns ErrPayloadHashMismatch instead of io.EOF if what was read doesn't hash to expected.
+// The last byte read is held back until the body ends, so an origin never receives a complete body that doesn't
+// match.
+type hashVerifyingReader struct {
+	r        io.Reader
+	hash     hash.Hash
+	expected []byte
+
+	eof  bool
+	held bool
+	last byte
+	next [1]byte
+}
+
+func (h *hashVerifyingReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if !h.held {
+		n, err := h.read(h.next[:])
+		if n == 0 || err != nil {
+			return 0, err
+		}
+		h.held, h.last = true, h.next[0]
+	}
+
+	// Read past the held byte to know whether it can be released, one ahead if p only has room for it
+	buf := p[1:]
+	if len(buf) == 0 {
+		buf = h.next[:]
+	}
+	n, err := h.read(buf)
+	if n == 0 {
+		if errors.Is(err, io.EOF) {
+			h.held = false
+			p[0] = h.last
+			return 1, err
+		}
+		return 0, err
+	}
+	p[0] = h.last
+	if len(p) == 1 {
+		h.last = h.next[0]
+		return 1, err
+	}
+	h.last = p[n]
+	return n, err
+}
+
+// read reads into p and hashes what was read. Once the body ended it returns io.EOF if the hash matches, or
+// ErrPayloadHashMismatch. Reads that return nothing are retried like bufio.Reader does.
+func (h *hashVerifyingReader) read(p []byte) (int, error) {
+	for i := 0; !h.eof; i++ {
+		if i == 100 {
+			return 0, io.ErrNoProgress
+		}
+		n, err := h.r.Read(p)
+		h.hash.Write(p[:n])
+		if errors.Is(err, io.EOF) {
+			h.eof, err = true, nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+	if !bytes.Equal(h.hash.Sum(nil), h.expected) {
+		logger.Warn().Msg("streamed payload does not match x-amz-content-sha256, aborting origin request")
+		return 0, ErrPayloadHashMismatch
+	}
+	return 0, io.EOF
+}
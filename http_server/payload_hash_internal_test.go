@@ -0,0 +1,52 @@
+package http_server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// Reading one byte at a time, every Read makes progress, and a mismatching body never reads to the end
+func TestHashVerifyingReaderOneByteReads(t *testing.T) {
+	for _, body := range []string{"", "a", "ab", "the signed body"} {
+		newReader := func(expected string) *hashVerifyingReader {
+			sum := sha256.Sum256([]byte(expected))
+			return &hashVerifyingReader{r: iotest.OneByteReader(bytes.NewReader([]byte(body))), hash: sha256.New(), expected: sum[:]}
+		}
+		readOneByteAtATime := func(r io.Reader) ([]byte, error) {
+			var read []byte
+			p := make([]byte, 1)
+			for range 2*len(body) + 2 {
+				n, err := r.Read(p)
+				read = append(read, p[:n]...)
+				if err != nil {
+					return read, err
+				}
+				if n == 0 {
+					t.Fatalf("%q: Read returned 0, nil after %q", body, read)
+				}
+			}
+			t.Fatalf("%q: never reached the end of the body", body)
+			return nil, nil
+		}
+
+		read, err := readOneByteAtATime(newReader(body))
+		if !errors.Is(err, io.EOF) || string(read) != body {
+			t.Errorf("got %q, %v, want %q", read, err, body)
+		}
+		if err := iotest.TestReader(newReader(body), []byte(body)); err != nil {
+			t.Errorf("%q: %v", body, err)
+		}
+
+		read, err = readOneByteAtATime(newReader("a different body"))
+		if !errors.Is(err, ErrPayloadHashMismatch) {
+			t.Errorf("%q: got %v reading a mismatching body", body, err)
+		}
+		if body != "" && len(read) >= len(body) {
+			t.Errorf("read all of the mismatching body %q", read)
+		}
+	}
+}
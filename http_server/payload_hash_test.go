@@ -0,0 +1,171 @@
+package http_server_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+	"github.com/samber/lo"
+)
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// newPayloadUpload signs a PutObject declaring payloadHash, then swaps in body
+func newPayloadUpload(h *iamtest.Harness, payloadHash string, body []byte) *http.Request {
+	r, _ := http.NewRequest(http.MethodPut, h.Server.URL+"/bucket/key", bytes.NewReader(body))
+	r.Header.Set("x-amz-content-sha256", payloadHash)
+	http_server.SignRequest(r, iamtest.KeyID, iamtest.KeySecret, iamtest.Region, "s3", time.Now())
+	return r
+}
+
+func TestPayloadVerification(t *testing.T) {
+	signed := []byte("the signed body")
+	// Larger than VerifyFirstMaxBytes and what re-signing buffers to re-hash
+	large := bytes.Repeat([]byte("x"), 2*http_server.DefaultVerifyFirstMaxBytes)
+
+	for _, tt := range []struct {
+		name          string
+		payloadHash   string
+		body          []byte
+		wantForwarded bool
+	}{
+		{name: "matching", payloadHash: sha256Hex(signed), body: signed, wantForwarded: true},
+		{name: "mismatched", payloadHash: sha256Hex(signed), body: []byte("a tampered body")},
+		{name: "unsigned payload", payloadHash: "UNSIGNED-PAYLOAD", body: []byte("anything"), wantForwarded: true},
+		{name: "matching streamed", payloadHash: sha256Hex(large), body: large, wantForwarded: true},
+		{name: "mismatched streamed", payloadHash: sha256Hex(signed), body: large},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newS3Harness(t)
+			h.Proxy.PayloadVerification = &http_server.PayloadVerification{}
+
+			res, err := h.Do(newPayloadUpload(h, tt.payloadHash, tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resBody, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+
+			var forwarded bool
+			for _, req := range h.Origin.Requests() {
+				forwarded = forwarded || bytes.Equal(req.Body, tt.body)
+			}
+			if forwarded != tt.wantForwarded {
+				t.Errorf("got body forwarded %t, want %t", forwarded, tt.wantForwarded)
+			}
+			if tt.wantForwarded {
+				if res.StatusCode != http.StatusOK {
+					t.Errorf("got %d %s", res.StatusCode, resBody)
+				}
+				return
+			}
+			if res.StatusCode != http.StatusBadRequest || !strings.Contains(string(resBody), "<Code>XAmzContentSHA256Mismatch</Code>") {
+				t.Errorf("got %d %s, want XAmzContentSHA256Mismatch", res.StatusCode, resBody)
+			}
+			if got := res.Header.Get(http_server.RejectReasonHeader); got != string(http_server.RejectionPayloadMismatch) {
+				t.Errorf("got rejection reason %q", got)
+			}
+		})
+	}
+}
+
+// A small body expecting 100-continue is verified as it streams once the origin accepts the request, and the
+// origin never gets all of a mismatched body
+func TestPayloadVerificationExpectContinue(t *testing.T) {
+	h := newS3Harness(t)
+	h.Proxy.PayloadVerification = &http_server.PayloadVerification{}
+
+	r := newPayloadUpload(h, sha256Hex([]byte("the signed body")), []byte("a tampered body"))
+	r.Header.Set("Expect", "100-continue")
+	res, err := h.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), "<Code>XAmzContentSHA256Mismatch</Code>") {
+		t.Fatalf("got %d %s, want XAmzContentSHA256Mismatch", res.StatusCode, body)
+	}
+	for _, req := range h.Origin.Requests() {
+		if string(req.Body) == "a tampered body" {
+			t.Error("origin received the tampered body")
+		}
+	}
+}
+
+// Without PayloadVerification, a body that doesn't match the hash the client signed is still never re-signed
+// for the origin, while a body a handler replaced with SetBody is
+func TestTamperedBodyIsNotResigned(t *testing.T) {
+	signed := []byte("hello world")
+	for _, tt := range []struct {
+		name    string
+		setBody []byte
+		body    []byte
+		want    int
+	}{
+		{name: "untouched", body: signed, want: http.StatusOK},
+		{name: "tampered", body: []byte("HACKED_WRLD"), want: http.StatusBadRequest},
+		{name: "replaced by a handler", setBody: []byte("rewritten by the handler"), body: signed, want: http.StatusOK},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := iamtest.NewHarness(func(originURL string) http_server.AWSServiceProvider {
+				p := http_server.NewS3Provider()
+				p.OriginHost = originURL
+				p.Use(func(next http_server.OperationHandler) http_server.OperationHandler {
+					return func(ctx context.Context, request *http_server.ProxiedRequest) (*http.Response, error) {
+						if tt.setBody != nil {
+							request.SetBody(tt.setBody)
+						}
+						return next(ctx, request)
+					}
+				})
+				return p
+			})
+			t.Cleanup(h.Close)
+			// Retrying doesn't get a rejected body through either
+			h.Proxy.RetryPolicy = &http_server.RetryPolicy{Backoff: http_server.FullJitterBackoff{}}
+
+			res, err := h.Do(newPayloadUpload(h, sha256Hex(signed), tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resBody, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			if res.StatusCode != tt.want {
+				t.Fatalf("got %d %s, want %d", res.StatusCode, resBody, tt.want)
+			}
+
+			requests := h.Origin.Requests()
+			if tt.want != http.StatusOK {
+				if !strings.Contains(string(resBody), "<Code>XAmzContentSHA256Mismatch</Code>") {
+					t.Errorf("got %s, want XAmzContentSHA256Mismatch", resBody)
+				}
+				if got := res.Header.Get(http_server.RejectReasonHeader); got != string(http_server.RejectionPayloadMismatch) {
+					t.Errorf("got rejection reason %q", got)
+				}
+				if len(requests) != 0 {
+					t.Errorf("origin received %d requests", len(requests))
+				}
+				return
+			}
+			want := lo.Ternary(tt.setBody != nil, tt.setBody, tt.body)
+			if len(requests) != 1 || !bytes.Equal(requests[0].Body, want) {
+				t.Fatalf("origin got %d requests, want one with %q", len(requests), want)
+			}
+			if got := requests[0].Header.Get("x-amz-content-sha256"); got != sha256Hex(want) {
+				t.Errorf("origin got x-amz-content-sha256 %s, want the hash of the body", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,375 @@
+package http_server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PolicyDocument is an IAM-like policy attached to a key, e.g.
+//
+//	{"Statement": [{
+//	  "Effect": "Allow",
+//	  "Action": ["s3:GetObject", "s3:List*"],
+//	  "Resource": "arn:aws:s3:::reports/${aws:username}/*",
+//	  "Condition": {"IpAddress": {"aws:SourceIp": "10.0.0.0/8"}}
+//	}]}
+//
+// Actions are <service>:<operation> as classified by the provider, so requests the provider can't classify are
+// only matched by wildcard actions like s3:*.
+type PolicyDocument struct {
+	Version   string            `json:"Version,omitempty" yaml:"Version,omitempty"`
+	Statement []PolicyStatement `json:"Statement" yaml:"Statement"`
+}
+
+type PolicyEffect string
+
+const (
+	PolicyAllow PolicyEffect = "Allow"
+	PolicyDeny  PolicyEffect = "Deny"
+)
+
+type PolicyStatement struct {
+	Sid    string       `json:"Sid,omitempty" yaml:"Sid,omitempty"`
+	Effect PolicyEffect `json:"Effect" yaml:"Effect"`
+	// Action patterns may use * and ?, and are case-insensitive
+	Action PolicyStringList `json:"Action" yaml:"Action"`
+	// Resource patterns may use * and ?, and ${key} policy variables of the condition keys. Empty is any resource.
+	Resource PolicyStringList `json:"Resource,omitempty" yaml:"Resource,omitempty"`
+	// Condition is operator to condition key to values. Operators and keys must all match, and a key
+	// matches if any of its values does.
+	Condition map[string]map[string]PolicyStringList `json:"Condition,omitempty" yaml:"Condition,omitempty"`
+}
+
+// PolicyStringList is a single string or a list of strings, like in IAM policies
+type PolicyStringList []string
+
+func (l *PolicyStringList) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*l = PolicyStringList{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return fmt.Errorf("expected a string or list of strings: %w", err)
+	}
+	*l = list
+	return nil
+}
+
+// UnmarshalYAML is UnmarshalJSON for YAML configs, e.g. Action: s3:GetObject or Action: [s3:GetObject, s3:PutObject]
+func (l *PolicyStringList) UnmarshalYAML(unmarshal func(any) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		*l = PolicyStringList{s}
+		return nil
+	}
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return fmt.Errorf("expected a string or list of strings: %w", err)
+	}
+	*l = list
+	return nil
+}
+
+// Condition keys set on every PolicyRequest, plus s3:prefix for S3 listings
+const (
+	PolicyKeySourceIP         = "aws:SourceIp"
+	PolicyKeySecureTransport  = "aws:SecureTransport"
+	PolicyKeyCurrentTime      = "aws:CurrentTime"
+	PolicyKeyRequestedRegion  = "aws:RequestedRegion"
+	PolicyKeyUsername         = "aws:username"
+	PolicyKeyUserID           = "aws:userid"
+	PolicyKeyPrincipalAccount = "aws:PrincipalAccount"
+	PolicyKeyS3Prefix         = "s3:prefix"
+)
+
+var ErrInvalidPolicy = errors.New("invalid policy")
+
+// Validate checks the effects and condition operators of the policy, so typos fail when policies are loaded
+// rather than silently never matching
+func (d PolicyDocument) Validate() error {
+	for i, statement := range d.Statement {
+		if statement.Effect != PolicyAllow && statement.Effect != PolicyDeny {
+			return fmt.Errorf("statement %d: unknown effect %q: %w", i, statement.Effect, ErrInvalidPolicy)
+		}
+		if len(statement.Action) == 0 {
+			return fmt.Errorf("statement %d: no actions: %w", i, ErrInvalidPolicy)
+		}
+		for operator := range statement.Condition {
+			if _, ok := policyConditionOperators[operator]; !ok {
+				return fmt.Errorf("statement %d: unknown condition operator %q: %w", i, operator, ErrInvalidPolicy)
+			}
+		}
+	}
+	return nil
+}
+
+// PolicyRequest is what a request is authorized as
+type PolicyRequest struct {
+	// Action is <service>:<operation>, e.g. s3:GetObject
+	Action string
+	// Resources are the ARNs the request addresses, all of which must be allowed. Empty is only matched by
+	// statements for any resource.
+	Resources []string
+	// ResourceActions are resources that must also be allowed a different action than Action, e.g. the source
+	// of s3:CopyObject is read as s3:GetObject. They don't replace the Action of the same resource in Resources.
+	ResourceActions []ResourceAction
+	// Context are the condition keys of the request
+	Context map[string]string
+}
+
+// ResourceAction is a resource and the action it must be allowed
+type ResourceAction struct {
+	Resource string
+	Action   string
+}
+
+// PolicyDecision is the result of evaluating policies
+type PolicyDecision struct {
+	Allowed bool
+	// Sid is the statement that denied the request explicitly, or allowed it
+	Sid string
+	// ExplicitDeny is whether a Deny statement matched, rather than no Allow statement
+	ExplicitDeny bool
+}
+
+// EvaluatePolicies decides like IAM: an explicit Deny wins, otherwise the request needs every resource allowed,
+// and is implicitly denied if it isn't
+func EvaluatePolicies(policies []PolicyDocument, request PolicyRequest) PolicyDecision {
+	resources := request.Resources
+	if len(resources) == 0 {
+		resources = []string{""}
+	}
+	checks := make([]ResourceAction, 0, len(resources)+len(request.ResourceActions))
+	for _, resource := range resources {
+		checks = append(checks, ResourceAction{Resource: resource, Action: request.Action})
+	}
+	checks = append(checks, request.ResourceActions...)
+
+	allowedSid := ""
+	allowed := make([]bool, len(checks))
+	for _, policy := range policies {
+		for _, statement := range policy.Statement {
+			if !statement.matchesConditions(request.Context) {
+				continue
+			}
+			for i, check := range checks {
+				if !statement.matchesAction(check.Action) || !statement.matchesResource(check.Resource, request.Context) {
+					continue
+				}
+				if statement.Effect == PolicyDeny {
+					return PolicyDecision{Sid: statement.Sid, ExplicitDeny: true}
+				}
+				if !allowed[i] {
+					allowed[i] = true
+					allowedSid = statement.Sid
+				}
+			}
+		}
+	}
+	for _, ok := range allowed {
+		if !ok {
+			return PolicyDecision{}
+		}
+	}
+	return PolicyDecision{Allowed: true, Sid: allowedSid}
+}
+
+func (s PolicyStatement) matchesAction(action string) bool {
+	for _, pattern := range s.Action {
+		if wildcardMatch(strings.ToLower(pattern), strings.ToLower(action)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s PolicyStatement) matchesResource(resource string, context map[string]string) bool {
+	if len(s.Resource) == 0 {
+		return true
+	}
+	for _, pattern := range s.Resource {
+		if pattern == "*" || (resource != "" && wildcardMatch(expandPolicyVariables(pattern, context), resource)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s PolicyStatement) matchesConditions(context map[string]string) bool {
+	for operator, keys := range s.Condition {
+		condition, ok := policyConditionOperators[operator]
+		if !ok {
+			return false
+		}
+		for key, values := range keys {
+			value, present := context[key]
+			if !present {
+				// Like IAM, negated operators match requests without the key
+				if !condition.negated {
+					return false
+				}
+				continue
+			}
+			matched := false
+			for _, expected := range values {
+				if condition.match(value, expandPolicyVariables(expected, context)) {
+					matched = true
+					break
+				}
+			}
+			if matched == condition.negated {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+type policyCondition struct {
+	match func(value, expected string) bool
+	// negated operators match if none of the values do
+	negated bool
+}
+
+var policyConditionOperators = map[string]policyCondition{
+	"StringEquals":              {match: func(v, e string) bool { return v == e }},
+	"StringNotEquals":           {match: func(v, e string) bool { return v == e }, negated: true},
+	"StringEqualsIgnoreCase":    {match: strings.EqualFold},
+	"StringNotEqualsIgnoreCase": {match: strings.EqualFold, negated: true},
+	"StringLike":                {match: func(v, e string) bool { return wildcardMatch(e, v) }},
+	"StringNotLike":             {match: func(v, e string) bool { return wildcardMatch(e, v) }, negated: true},
+	"IpAddress":                 {match: ipInPrefix},
+	"NotIpAddress":              {match: ipInPrefix, negated: true},
+	"Bool":                      {match: func(v, e string) bool { return strings.EqualFold(v, e) }},
+	"DateGreaterThan":           {match: compareDates(func(c int) bool { return c > 0 })},
+	"DateGreaterThanEquals":     {match: compareDates(func(c int) bool { return c >= 0 })},
+	"DateLessThan":              {match: compareDates(func(c int) bool { return c < 0 })},
+	"DateLessThanEquals":        {match: compareDates(func(c int) bool { return c <= 0 })},
+}
+
+func ipInPrefix(value, expected string) bool {
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return false
+	}
+	if !strings.Contains(expected, "/") {
+		expectedAddr, err := netip.ParseAddr(expected)
+		return err == nil && expectedAddr == addr.Unmap()
+	}
+	prefix, err := netip.ParsePrefix(expected)
+	return err == nil && prefix.Contains(addr.Unmap())
+}
+
+// compareDates matches RFC 3339 times or epoch seconds by their comparison, unparseable times never match
+func compareDates(ok func(comparison int) bool) func(value, expected string) bool {
+	return func(value, expected string) bool {
+		v, err := parsePolicyDate(value)
+		if err != nil {
+			return false
+		}
+		e, err := parsePolicyDate(expected)
+		if err != nil {
+			return false
+		}
+		return ok(v.Compare(e))
+	}
+}
+
+func parsePolicyDate(s string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// expandPolicyVariables replaces ${key} with the value of the condition key, e.g. ${aws:username}.
+// Unknown keys are left as-is, so they don't match.
+func expandPolicyVariables(pattern string, context map[string]string) string {
+	if !strings.Contains(pattern, "${") {
+		return pattern
+	}
+	var b strings.Builder
+	for {
+		start := strings.Index(pattern, "${")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(pattern[start:], "}")
+		if end < 0 {
+			break
+		}
+		key := pattern[start+2 : start+end]
+		b.WriteString(pattern[:start])
+		if value, ok := context[key]; ok {
+			b.WriteString(value)
+		} else {
+			b.WriteString(pattern[start : start+end+1])
+		}
+		pattern = pattern[start+end+1:]
+	}
+	b.WriteString(pattern)
+	return b.String()
+}
+
+// wildcardMatch matches s against a pattern where * is any run of characters and ? is any single character
+func wildcardMatch(pattern, s string) bool {
+	p, i := 0, 0
+	star, match := -1, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]):
+			p++
+			i++
+		case p < len(pattern) && pattern[p] == '*':
+			star, match = p, i
+			p++
+		case star >= 0:
+			p = star + 1
+			match++
+			i = match
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// StaticPolicies is a PolicyLookupFunc of policies per key id, keys without policies are denied everything
+func StaticPolicies(policies map[string][]PolicyDocument) LookupFunc[string, []PolicyDocument] {
+	return func(_ context.Context, keyID string) ([]PolicyDocument, error) {
+		keyPolicies, ok := policies[keyID]
+		if !ok {
+			return nil, ErrKeyNotFound
+		}
+		return keyPolicies, nil
+	}
+}
+
+func policyContext(request *ProxiedRequest, now time.Time) map[string]string {
+	context := map[string]string{
+		PolicyKeySourceIP:        request.ClientIP,
+		PolicyKeySecureTransport: strconv.FormatBool(request.Request.TLS != nil),
+		PolicyKeyCurrentTime:     now.UTC().Format(time.RFC3339),
+		PolicyKeyRequestedRegion: request.Region,
+		PolicyKeyUsername:        request.Principal.Name,
+		PolicyKeyUserID:          request.KeyID,
+	}
+	if request.Principal.Account != "" {
+		context[PolicyKeyPrincipalAccount] = request.Principal.Account
+	}
+	if query := request.Request.URL.Query(); request.Service == "s3" && query.Has("prefix") {
+		context[PolicyKeyS3Prefix] = query.Get("prefix")
+	}
+	return context
+}
@@ -0,0 +1,326 @@
+package http_server
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEvaluatePolicies(t *testing.T) {
+	readReports := PolicyDocument{Statement: []PolicyStatement{{
+		Sid:      "ReadReports",
+		Effect:   PolicyAllow,
+		Action:   PolicyStringList{"s3:GetObject", "s3:List*"},
+		Resource: PolicyStringList{"arn:aws:s3:::reports", "arn:aws:s3:::reports/*"},
+	}}}
+	denySecrets := PolicyDocument{Statement: []PolicyStatement{{
+		Sid:      "NoSecrets",
+		Effect:   PolicyDeny,
+		Action:   PolicyStringList{"s3:*"},
+		Resource: PolicyStringList{"arn:aws:s3:::reports/secret/*"},
+	}}}
+	ownPrefix := PolicyDocument{Statement: []PolicyStatement{{
+		Sid:      "OwnPrefix",
+		Effect:   PolicyAllow,
+		Action:   PolicyStringList{"s3:PutObject"},
+		Resource: PolicyStringList{"arn:aws:s3:::home/${aws:username}/*"},
+	}}}
+	fromOffice := PolicyDocument{Statement: []PolicyStatement{{
+		Sid:    "FromOffice",
+		Effect: PolicyAllow,
+		Action: PolicyStringList{"dynamodb:*"},
+		Condition: map[string]map[string]PolicyStringList{
+			"IpAddress":     {PolicyKeySourceIP: {"10.0.0.0/8", "192.168.1.1"}},
+			"Bool":          {PolicyKeySecureTransport: {"true"}},
+			"DateLessThan":  {PolicyKeyCurrentTime: {"2025-01-01T00:00:00Z"}},
+			"StringNotLike": {"aws:userid": {"AKIATEMP*"}},
+		},
+	}}}
+	context := map[string]string{
+		PolicyKeySourceIP:        "10.1.2.3",
+		PolicyKeySecureTransport: "true",
+		PolicyKeyCurrentTime:     "2024-06-01T00:00:00Z",
+		PolicyKeyUsername:        "alice",
+		PolicyKeyUserID:          "AKIAALICE",
+	}
+	with := func(key, value string) map[string]string {
+		c := map[string]string{}
+		for k, v := range context {
+			c[k] = v
+		}
+		if value == "" {
+			delete(c, key)
+		} else {
+			c[key] = value
+		}
+		return c
+	}
+
+	tests := []struct {
+		name         string
+		policies     []PolicyDocument
+		request      PolicyRequest
+		allowed      bool
+		explicitDeny bool
+		sid          string
+	}{
+		{
+			name:     "allowed",
+			policies: []PolicyDocument{readReports},
+			request:  PolicyRequest{Action: "s3:GetObject", Resources: []string{"arn:aws:s3:::reports/2024/q1.csv"}},
+			allowed:  true,
+			sid:      "ReadReports",
+		},
+		{
+			name:     "wildcard action, case-insensitive",
+			policies: []PolicyDocument{readReports},
+			request:  PolicyRequest{Action: "S3:ListObjectsV2", Resources: []string{"arn:aws:s3:::reports"}},
+			allowed:  true,
+			sid:      "ReadReports",
+		},
+		{
+			name:     "other action is implicitly denied",
+			policies: []PolicyDocument{readReports},
+			request:  PolicyRequest{Action: "s3:PutObject", Resources: []string{"arn:aws:s3:::reports/2024/q1.csv"}},
+		},
+		{
+			name:     "other resource is implicitly denied",
+			policies: []PolicyDocument{readReports},
+			request:  PolicyRequest{Action: "s3:GetObject", Resources: []string{"arn:aws:s3:::payroll/2024.csv"}},
+		},
+		{
+			name:    "no policies",
+			request: PolicyRequest{Action: "s3:GetObject", Resources: []string{"arn:aws:s3:::reports/2024/q1.csv"}},
+		},
+		{
+			name:         "explicit deny wins over allow",
+			policies:     []PolicyDocument{readReports, denySecrets},
+			request:      PolicyRequest{Action: "s3:GetObject", Resources: []string{"arn:aws:s3:::reports/secret/keys.txt"}},
+			explicitDeny: true,
+			sid:          "NoSecrets",
+		},
+		{
+			name:     "every resource must be allowed",
+			policies: []PolicyDocument{readReports},
+			request: PolicyRequest{Action: "s3:GetObject", Resources: []string{
+				"arn:aws:s3:::reports/2024/q1.csv", "arn:aws:s3:::payroll/2024.csv",
+			}},
+		},
+		{
+			name:     "requests without resources need any resource",
+			policies: []PolicyDocument{readReports},
+			request:  PolicyRequest{Action: "s3:ListBuckets"},
+		},
+		{
+			name:     "policy variable",
+			policies: []PolicyDocument{ownPrefix},
+			request:  PolicyRequest{Action: "s3:PutObject", Resources: []string{"arn:aws:s3:::home/alice/notes.txt"}, Context: context},
+			allowed:  true,
+			sid:      "OwnPrefix",
+		},
+		{
+			name:     "policy variable of another user",
+			policies: []PolicyDocument{ownPrefix},
+			request:  PolicyRequest{Action: "s3:PutObject", Resources: []string{"arn:aws:s3:::home/bob/notes.txt"}, Context: context},
+		},
+		{
+			name:     "policy variable without its key",
+			policies: []PolicyDocument{ownPrefix},
+			request:  PolicyRequest{Action: "s3:PutObject", Resources: []string{"arn:aws:s3:::home/alice/notes.txt"}, Context: with(PolicyKeyUsername, "")},
+		},
+		{
+			name:     "conditions match",
+			policies: []PolicyDocument{fromOffice},
+			request:  PolicyRequest{Action: "dynamodb:GetItem", Context: context},
+			allowed:  true,
+			sid:      "FromOffice",
+		},
+		{
+			name:     "single IP condition",
+			policies: []PolicyDocument{fromOffice},
+			request:  PolicyRequest{Action: "dynamodb:GetItem", Context: with(PolicyKeySourceIP, "192.168.1.1")},
+			allowed:  true,
+			sid:      "FromOffice",
+		},
+		{
+			name:     "IP condition fails",
+			policies: []PolicyDocument{fromOffice},
+			request:  PolicyRequest{Action: "dynamodb:GetItem", Context: with(PolicyKeySourceIP, "203.0.113.7")},
+		},
+		{
+			name:     "missing key fails a condition",
+			policies: []PolicyDocument{fromOffice},
+			request:  PolicyRequest{Action: "dynamodb:GetItem", Context: with(PolicyKeySourceIP, "")},
+		},
+		{
+			name:     "Bool condition fails",
+			policies: []PolicyDocument{fromOffice},
+			request:  PolicyRequest{Action: "dynamodb:GetItem", Context: with(PolicyKeySecureTransport, "false")},
+		},
+		{
+			name:     "date condition fails",
+			policies: []PolicyDocument{fromOffice},
+			request:  PolicyRequest{Action: "dynamodb:GetItem", Context: with(PolicyKeyCurrentTime, "2025-06-01T00:00:00Z")},
+		},
+		{
+			name:     "negated condition fails",
+			policies: []PolicyDocument{fromOffice},
+			request:  PolicyRequest{Action: "dynamodb:GetItem", Context: with(PolicyKeyUserID, "AKIATEMP1")},
+		},
+		{
+			name:     "negated condition matches without its key",
+			policies: []PolicyDocument{fromOffice},
+			request:  PolicyRequest{Action: "dynamodb:GetItem", Context: with(PolicyKeyUserID, "")},
+			allowed:  true,
+			sid:      "FromOffice",
+		},
+		{
+			name: "resource action",
+			policies: []PolicyDocument{readReports, {Statement: []PolicyStatement{{
+				Effect: PolicyAllow, Action: PolicyStringList{"s3:PutObject"}, Resource: PolicyStringList{"arn:aws:s3:::home/*"},
+			}}}},
+			request: PolicyRequest{
+				Action:          "s3:CopyObject",
+				Resources:       []string{"arn:aws:s3:::home/q1.csv"},
+				ResourceActions: []ResourceAction{{Resource: "arn:aws:s3:::reports/q1.csv", Action: "s3:GetObject"}},
+			},
+		},
+		{
+			name: "resource action allowed",
+			policies: []PolicyDocument{readReports, {Statement: []PolicyStatement{{
+				Sid: "CopyHome", Effect: PolicyAllow, Action: PolicyStringList{"s3:CopyObject"}, Resource: PolicyStringList{"arn:aws:s3:::home/*"},
+			}}}},
+			request: PolicyRequest{
+				Action:          "s3:CopyObject",
+				Resources:       []string{"arn:aws:s3:::home/q1.csv"},
+				ResourceActions: []ResourceAction{{Resource: "arn:aws:s3:::reports/q1.csv", Action: "s3:GetObject"}},
+			},
+			allowed: true,
+			sid:     "CopyHome",
+		},
+		{
+			name: "resource action explicitly denied",
+			policies: []PolicyDocument{denySecrets, {Statement: []PolicyStatement{{
+				Effect: PolicyAllow, Action: PolicyStringList{"s3:CopyObject", "s3:GetObject"},
+			}}}},
+			request: PolicyRequest{
+				Action:          "s3:CopyObject",
+				Resources:       []string{"arn:aws:s3:::home/keys.txt"},
+				ResourceActions: []ResourceAction{{Resource: "arn:aws:s3:::reports/secret/keys.txt", Action: "s3:GetObject"}},
+			},
+			explicitDeny: true,
+			sid:          "NoSecrets",
+		},
+		{
+			name:     "resource action doesn't replace the action of the same resource",
+			policies: []PolicyDocument{readReports},
+			request: PolicyRequest{
+				Action:          "s3:CopyObject",
+				Resources:       []string{"arn:aws:s3:::reports/q1.csv"},
+				ResourceActions: []ResourceAction{{Resource: "arn:aws:s3:::reports/q1.csv", Action: "s3:GetObject"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := EvaluatePolicies(tt.policies, tt.request)
+			if decision.Allowed != tt.allowed || decision.ExplicitDeny != tt.explicitDeny || decision.Sid != tt.sid {
+				t.Errorf("got %+v, want allowed %v, explicit deny %v, sid %q", decision, tt.allowed, tt.explicitDeny, tt.sid)
+			}
+		})
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	for name, statement := range map[string]PolicyStatement{
+		"effect":    {Effect: "allow", Action: PolicyStringList{"s3:*"}},
+		"actions":   {Effect: PolicyAllow},
+		"condition": {Effect: PolicyAllow, Action: PolicyStringList{"s3:*"}, Condition: map[string]map[string]PolicyStringList{"StringEqual": {}}},
+	} {
+		if err := (PolicyDocument{Statement: []PolicyStatement{statement}}).Validate(); !errors.Is(err, ErrInvalidPolicy) {
+			t.Errorf("%s: got %v, want ErrInvalidPolicy", name, err)
+		}
+	}
+}
+
+// Copying an object reads its source, so the source must be allowed as GetObject, not just the destination
+func TestAuthorizeS3CopySource(t *testing.T) {
+	proxy := &AWSProxy{PolicyLookupFunc: StaticPolicies(map[string][]PolicyDocument{
+		"AKID": {{Statement: []PolicyStatement{
+			{Effect: PolicyAllow, Action: PolicyStringList{"s3:*"}, Resource: PolicyStringList{"arn:aws:s3:::tenant-a/*"}},
+			{Effect: PolicyAllow, Action: PolicyStringList{"s3:GetObject"}, Resource: PolicyStringList{"arn:aws:s3:::shared/*"}},
+		}}},
+	})}
+	provider := NewS3Provider()
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		copySource string
+		allowed    bool
+	}{
+		{name: "CopyObject from own bucket", method: "PUT", target: "/tenant-a/copy.txt", copySource: "/tenant-a/original.txt", allowed: true},
+		{name: "CopyObject from readable bucket", method: "PUT", target: "/tenant-a/copy.txt", copySource: "shared/report%20q1.csv", allowed: true},
+		{name: "CopyObject from other bucket", method: "PUT", target: "/tenant-a/copy.txt", copySource: "/tenant-b/secret.txt"},
+		{name: "CopyObject version", method: "PUT", target: "/tenant-a/copy.txt", copySource: "/tenant-b/secret.txt?versionId=3"},
+		{name: "UploadPartCopy from other bucket", method: "PUT", target: "/tenant-a/copy.txt?partNumber=1&uploadId=abc", copySource: "/tenant-b/secret.txt"},
+		{name: "UploadPartCopy from readable bucket", method: "PUT", target: "/tenant-a/copy.txt?partNumber=1&uploadId=abc", copySource: "/shared/big.bin", allowed: true},
+		{name: "malformed copy source", method: "PUT", target: "/tenant-a/copy.txt", copySource: "/tenant-a"},
+		{name: "PutObject", method: "PUT", target: "/tenant-a/new.txt", allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "http://s3.amazonaws.com"+tt.target, nil)
+			if tt.copySource != "" {
+				r.Header.Set("x-amz-copy-source", tt.copySource)
+			}
+			request := &ProxiedRequest{Request: r, Service: "s3", KeyID: "AKID"}
+			err := proxy.authorize(context.Background(), provider, request, time.Now())
+			if tt.allowed && err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !tt.allowed && !errors.Is(err, ErrAWSAccessDenied) {
+				t.Fatalf("got %v, want ErrAWSAccessDenied", err)
+			}
+		})
+	}
+}
+
+// An in-place copy (the copy source is the destination) replaces the metadata, ACL, or storage class of the
+// object, so it must not be allowed by only being able to read it
+func TestAuthorizeS3InPlaceCopy(t *testing.T) {
+	proxy := &AWSProxy{PolicyLookupFunc: StaticPolicies(map[string][]PolicyDocument{
+		"READER": {{Statement: []PolicyStatement{
+			{Effect: PolicyAllow, Action: PolicyStringList{"s3:GetObject"}, Resource: PolicyStringList{"arn:aws:s3:::reports/*"}},
+		}}},
+		"WRITER": {{Statement: []PolicyStatement{
+			{Effect: PolicyAllow, Action: PolicyStringList{"s3:GetObject", "s3:CopyObject"}, Resource: PolicyStringList{"arn:aws:s3:::reports/*"}},
+		}}},
+	})}
+	provider := NewS3Provider()
+
+	for _, tt := range []struct {
+		keyID   string
+		allowed bool
+	}{
+		{keyID: "READER"},
+		{keyID: "WRITER", allowed: true},
+	} {
+		t.Run(tt.keyID, func(t *testing.T) {
+			r := httptest.NewRequest("PUT", "http://s3.amazonaws.com/reports/q1.csv", nil)
+			r.Header.Set("x-amz-copy-source", "/reports/q1.csv")
+			r.Header.Set("x-amz-metadata-directive", "REPLACE")
+			request := &ProxiedRequest{Request: r, Service: "s3", KeyID: tt.keyID}
+			err := proxy.authorize(context.Background(), provider, request, time.Now())
+			if tt.allowed && err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !tt.allowed && !errors.Is(err, ErrAWSAccessDenied) {
+				t.Fatalf("got %v, want ErrAWSAccessDenied", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package http_server
+
+import (
+	"context"
+)
+
+// Principal is the human-meaningful identity behind an access key, used in audit logs and policies
+// so they show "alice@team-data" instead of AKIA...
+type Principal struct {
+	KeyID   string
+	Name    string
+	Team    string
+	Account string
+	// Tenant is the TenantID of keys minted by a KeyIssuer
+	Tenant string
+}
+
+func (p Principal) String() string {
+	if p.Team == "" {
+		return p.Name
+	}
+	return p.Name + "@" + p.Team
+}
+
+// PrincipalResolver resolves the principal for an access key id. Requests of keys it returns ErrKeyNotFound
+// for are rejected as unknown keys, other errors fail the request with an InternalError.
+type PrincipalResolver func(ctx context.Context, keyID string) (Principal, error)
+
+// KeyIDPrincipalResolver is the default PrincipalResolver, which uses the key id as the principal name
+func KeyIDPrincipalResolver(_ context.Context, keyID string) (Principal, error) {
+	return Principal{
+		KeyID: keyID,
+		Name:  keyID,
+	}, nil
+}
@@ -0,0 +1,128 @@
+package http_server_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+// auditRecorder is an AuditSink that hands the records to the test
+type auditRecorder chan http_server.AuditRecord
+
+func (a auditRecorder) WriteAuditRecord(_ context.Context, record http_server.AuditRecord) {
+	a <- record
+}
+
+func (a auditRecorder) next(t *testing.T) http_server.AuditRecord {
+	t.Helper()
+	select {
+	case record := <-a:
+		return record
+	case <-time.After(5 * time.Second):
+		t.Fatal("no audit record was written")
+		return http_server.AuditRecord{}
+	}
+}
+
+func TestPrincipalInAuditRecord(t *testing.T) {
+	h := newS3Harness(t)
+	records := make(auditRecorder, 1)
+	h.Proxy.AuditSink = records
+	h.Proxy.PrincipalResolver = func(_ context.Context, keyID string) (http_server.Principal, error) {
+		return http_server.Principal{KeyID: keyID, Name: "alice", Team: "team-data", Account: "123456789012"}, nil
+	}
+
+	res, err := h.Do(h.NewSignedRequest(http.MethodGet, "/bucket/key", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+
+	record := records.next(t)
+	want := http_server.Principal{KeyID: iamtest.KeyID, Name: "alice", Team: "team-data", Account: "123456789012"}
+	if record.Principal != want {
+		t.Errorf("got principal %+v, want %+v", record.Principal, want)
+	}
+	if got := record.Principal.String(); got != "alice@team-data" {
+		t.Errorf("got principal %q", got)
+	}
+	if record.StatusCode != http.StatusOK || record.Operation != "GetObject" {
+		t.Errorf("got record %+v", record)
+	}
+}
+
+func TestDefaultPrincipalIsKeyID(t *testing.T) {
+	h := newS3Harness(t)
+	records := make(auditRecorder, 1)
+	h.Proxy.AuditSink = records
+
+	res, err := h.Do(h.NewSignedRequest(http.MethodGet, "/bucket/key", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+
+	if got := records.next(t).Principal; got != (http_server.Principal{KeyID: iamtest.KeyID, Name: iamtest.KeyID}) {
+		t.Errorf("got principal %+v", got)
+	}
+}
+
+func TestPrincipalResolverErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+		wantReason http_server.RejectionReason
+	}{
+		{
+			name:       "unknown key",
+			err:        http_server.ErrKeyNotFound,
+			wantStatus: http.StatusForbidden,
+			wantCode:   "InvalidAccessKeyId",
+			wantReason: http_server.RejectionUnknownKey,
+		},
+		{
+			name:       "resolver failed",
+			err:        errors.New("directory unavailable"),
+			wantStatus: http.StatusInternalServerError,
+			wantCode:   "InternalError",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newS3Harness(t)
+			h.Proxy.PrincipalResolver = func(context.Context, string) (http_server.Principal, error) {
+				return http_server.Principal{}, tt.err
+			}
+
+			res, err := h.Do(h.NewSignedRequest(http.MethodGet, "/bucket/key", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			if res.StatusCode != tt.wantStatus || !strings.Contains(string(body), "<Code>"+tt.wantCode+"</Code>") {
+				t.Errorf("got %d %s, want a %d %s", res.StatusCode, body, tt.wantStatus, tt.wantCode)
+			}
+			if ct := res.Header.Get("Content-Type"); ct != "application/xml" {
+				t.Errorf("got Content-Type %q", ct)
+			}
+			if got := res.Header.Get(http_server.RejectReasonHeader); got != string(tt.wantReason) {
+				t.Errorf("got rejection reason %q, want %q", got, tt.wantReason)
+			}
+			if n := len(h.Origin.Requests()); n != 0 {
+				t.Errorf("origin received %d requests", n)
+			}
+		})
+	}
+}
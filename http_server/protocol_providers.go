@@ -0,0 +1,157 @@
+package http_server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// maxJSONRequestBytes bounds how much of a JSON protocol request body ParseJSONRequest will buffer
+const maxJSONRequestBytes = 16 * 1024 * 1024
+
+// QueryProtocolProvider is a base AWSServiceProvider for services using the AWS query protocol (Action=...),
+// e.g. STS, IAM, and SNS. It classifies requests by Action and renders errors in the query shape, so a
+// service only adds parsing of its parameters (see ParseQueryParams) and its responses (see NewQueryResponse).
+type QueryProtocolProvider struct {
+	*BaseAWSProvider
+	OperationRouter
+
+	// Operations optionally limits classification to the operations of the service model (e.g. generated
+	// by providergen), so unknown operations are OperationUnknown, which StrictOperations rejects
+	Operations OperationSet
+
+	// Regional sends requests to the endpoint of the signed region (e.g. sns.us-west-2.amazonaws.com)
+	// rather than the global <service>.amazonaws.com
+	Regional bool
+}
+
+func NewQueryProtocolProvider(serviceName string) *QueryProtocolProvider {
+	RegisterServiceProtocol(serviceName, ProtocolQuery)
+	return &QueryProtocolProvider{
+		BaseAWSProvider: NewBaseAWSProvider(serviceName),
+	}
+}
+
+// ExtractOperationName returns the Action, e.g. "Publish" or "AssumeRole"
+func (p *QueryProtocolProvider) ExtractOperationName(request *ProxiedRequest) string {
+	params, err := ParseQueryParams(request)
+	if err != nil {
+		return OperationUnknown
+	}
+	return p.Operations.classify(params.Get("Action"))
+}
+
+// HandleRequest dispatches to the registered operation handler, or proxies to the service if there is none
+func (p *QueryProtocolProvider) HandleRequest(ctx context.Context, request *ProxiedRequest) (*http.Response, error) {
+	return p.dispatch(ctx, p.ExtractOperationName(request), request, p.proxy)
+}
+
+func (p *QueryProtocolProvider) proxy(ctx context.Context, request *ProxiedRequest) (*http.Response, error) {
+	if p.Regional {
+		return p.proxyToRegion(ctx, request)
+	}
+	return p.BaseAWSProvider.HandleRequest(ctx, request)
+}
+
+// NewQueryResponse creates a successful query protocol response for handlers answering locally, wrapping
+// result in <{action}Response><{action}Result> with a generated request id. A nil result is omitted,
+// for actions like DeleteAccessKey that return nothing.
+func NewQueryResponse(action, namespace string, result any) *http.Response {
+	var body bytes.Buffer
+	encoder := xml.NewEncoder(&body)
+	start := xml.StartElement{Name: xml.Name{Local: action + "Response"}}
+	if namespace != "" {
+		start.Attr = []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: namespace}}
+	}
+	_ = encoder.EncodeToken(start)
+	if result != nil {
+		_ = encoder.EncodeElement(result, xml.StartElement{Name: xml.Name{Local: action + "Result"}})
+	}
+	_ = encoder.EncodeElement(struct {
+		RequestId string
+	}{uuid.NewString()}, xml.StartElement{Name: xml.Name{Local: "ResponseMetadata"}})
+	_ = encoder.EncodeToken(start.End())
+	_ = encoder.Flush()
+	return newXMLResponse(body.Bytes())
+}
+
+// JSONRPCProvider is a base AWSServiceProvider for services using the AWS JSON 1.0/1.1 protocols, whose
+// operation is in the X-Amz-Target header (e.g. Kinesis_20131202.PutRecord), such as DynamoDB, Kinesis,
+// and KMS. It classifies requests by target and renders errors in the JSON shape, so a service only adds
+// its request types (see ParseJSONRequest) and responses (see NewJSONResponse).
+type JSONRPCProvider struct {
+	*BaseAWSProvider
+	OperationRouter
+
+	// Operations optionally limits classification to the operations of the service model (e.g. generated
+	// by providergen), so unknown operations are OperationUnknown, which StrictOperations rejects
+	Operations OperationSet
+
+	// Regional sends requests to the endpoint of the signed region (e.g. kms.us-west-2.amazonaws.com)
+	// rather than <service>.amazonaws.com
+	Regional bool
+}
+
+func NewJSONRPCProvider(serviceName string) *JSONRPCProvider {
+	RegisterServiceProtocol(serviceName, ProtocolJSON)
+	return &JSONRPCProvider{
+		BaseAWSProvider: NewBaseAWSProvider(serviceName),
+	}
+}
+
+// ExtractOperationName gets the operation from the X-Amz-Target header, e.g. TrentService.Decrypt
+func (p *JSONRPCProvider) ExtractOperationName(request *ProxiedRequest) string {
+	_, operation, _ := strings.Cut(request.Request.Header.Get("X-Amz-Target"), ".")
+	return p.Operations.classify(operation)
+}
+
+// HandleRequest dispatches to the registered operation handler, or proxies to the service if there is none
+func (p *JSONRPCProvider) HandleRequest(ctx context.Context, request *ProxiedRequest) (*http.Response, error) {
+	return p.dispatch(ctx, p.ExtractOperationName(request), request, p.proxy)
+}
+
+func (p *JSONRPCProvider) proxy(ctx context.Context, request *ProxiedRequest) (*http.Response, error) {
+	if p.Regional {
+		return p.proxyToRegion(ctx, request)
+	}
+	return p.BaseAWSProvider.HandleRequest(ctx, request)
+}
+
+// ParseJSONRequest buffers and decodes the (bounded) request body into v, the body is still forwarded
+// to the origin
+func ParseJSONRequest(request *ProxiedRequest, v any) error {
+	body, err := io.ReadAll(io.LimitReader(request.Request.Body, maxJSONRequestBytes))
+	if err != nil {
+		return fmt.Errorf("error reading request body: %w", err)
+	}
+	request.Request.Body.Close()
+	request.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	if err = json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("error in json.Unmarshal: %w", err)
+	}
+	return nil
+}
+
+// NewJSONResponse creates a successful JSON protocol response of v, for handlers answering locally
+func NewJSONResponse(v any) *http.Response {
+	body, _ := json.Marshal(v)
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-amz-json-1.1")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	header.Set("x-amzn-RequestId", uuid.NewString())
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+}
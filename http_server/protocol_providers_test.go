@@ -0,0 +1,141 @@
+package http_server_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+)
+
+const formContentType = "application/x-www-form-urlencoded"
+
+func TestQueryProtocolProviderOperations(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		body        string
+		operations  http_server.OperationSet
+		want        string
+	}{
+		{name: "form", method: http.MethodPost, target: "/", contentType: formContentType, body: "Action=Publish&Message=hi", want: "Publish"},
+		{name: "form with charset", method: http.MethodPost, target: "/", contentType: formContentType + "; charset=utf-8", body: "Version=2010-03-31&Action=Publish", want: "Publish"},
+		{name: "query string", method: http.MethodGet, target: "/?Action=GetCallerIdentity&Version=2011-06-15", want: "GetCallerIdentity"},
+		{name: "query string of a post", method: http.MethodPost, target: "/?Action=ListTopics", contentType: formContentType, body: "NextToken=abc", want: "ListTopics"},
+		{name: "form of a get is ignored", method: http.MethodGet, target: "/", contentType: formContentType, body: "Action=Publish", want: http_server.OperationUnknown},
+		{name: "body that isn't a form", method: http.MethodPost, target: "/", contentType: "application/json", body: "Action=Publish", want: http_server.OperationUnknown},
+		{name: "no action", method: http.MethodPost, target: "/", contentType: formContentType, body: "Message=hi", want: http_server.OperationUnknown},
+		{name: "empty action", method: http.MethodPost, target: "/", contentType: formContentType, body: "Action=&Message=hi", want: http_server.OperationUnknown},
+		{name: "malformed escape", method: http.MethodPost, target: "/", contentType: formContentType, body: "Action=Publish&Message=100%", want: http_server.OperationUnknown},
+		{name: "malformed semicolon", method: http.MethodPost, target: "/", contentType: formContentType, body: "Action=Publish;Message=hi", want: http_server.OperationUnknown},
+		{name: "in the service model", method: http.MethodPost, target: "/", contentType: formContentType, body: "Action=Publish", operations: http_server.OperationSet{"Publish": true}, want: "Publish"},
+		{name: "not in the service model", method: http.MethodPost, target: "/", contentType: formContentType, body: "Action=PublishAll", operations: http_server.OperationSet{"Publish": true}, want: http_server.OperationUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := http_server.NewQueryProtocolProvider("sns")
+			provider.Operations = tt.operations
+			r := httptest.NewRequest(tt.method, "https://sns.us-east-1.amazonaws.com"+tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			if got := provider.ExtractOperationName(&http_server.ProxiedRequest{Request: r}); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+			// Parsing leaves the body to forward, even if it is malformed
+			if body, _ := io.ReadAll(r.Body); string(body) != tt.body {
+				t.Errorf("got body %q after classifying, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestJSONRPCProviderOperations(t *testing.T) {
+	tests := []struct {
+		target     string
+		operations http_server.OperationSet
+		want       string
+	}{
+		{target: "Kinesis_20131202.PutRecord", want: "PutRecord"},
+		{target: "DynamoDB_20120810.GetItem", want: "GetItem"},
+		{target: "TrentService.Decrypt", operations: http_server.OperationSet{"Decrypt": true}, want: "Decrypt"},
+		{target: "TrentService.DecryptAll", operations: http_server.OperationSet{"Decrypt": true}, want: http_server.OperationUnknown},
+		{target: "PutRecord", want: http_server.OperationUnknown},
+		{target: "Kinesis_20131202.", want: http_server.OperationUnknown},
+		{target: "", want: http_server.OperationUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			provider := http_server.NewJSONRPCProvider("kinesis")
+			provider.Operations = tt.operations
+			r := httptest.NewRequest(http.MethodPost, "https://kinesis.us-east-1.amazonaws.com/", strings.NewReader(`{`))
+			r.Header.Set("X-Amz-Target", tt.target)
+			if got := provider.ExtractOperationName(&http_server.ProxiedRequest{Request: r}); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRequestBodies(t *testing.T) {
+	jsonTests := []struct {
+		name    string
+		body    string
+		wantErr bool
+	}{
+		{name: "object", body: `{"StreamName":"orders","PartitionKey":"a"}`},
+		{name: "truncated", body: `{"StreamName":"orders"`, wantErr: true},
+		{name: "not json", body: `StreamName=orders`, wantErr: true},
+		{name: "wrong type", body: `{"StreamName":7}`, wantErr: true},
+		{name: "empty", body: ``, wantErr: true},
+	}
+	for _, tt := range jsonTests {
+		t.Run("json "+tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "https://kinesis.us-east-1.amazonaws.com/", strings.NewReader(tt.body))
+			var v struct {
+				StreamName   string
+				PartitionKey string
+			}
+			err := http_server.ParseJSONRequest(&http_server.ProxiedRequest{Request: r}, &v)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got %v, want error %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && v.StreamName != "orders" {
+				t.Errorf("got %+v", v)
+			}
+			if body, _ := io.ReadAll(r.Body); string(body) != tt.body {
+				t.Errorf("got body %q after parsing, want %q", body, tt.body)
+			}
+		})
+	}
+
+	formTests := []struct {
+		name    string
+		body    string
+		wantErr bool
+	}{
+		{name: "form", body: "Action=Publish&Message=a%20b"},
+		{name: "malformed escape", body: "Action=Publish&Message=%zz", wantErr: true},
+		{name: "semicolon", body: "Action=Publish;Message=hi", wantErr: true},
+	}
+	for _, tt := range formTests {
+		t.Run("form "+tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "https://sns.us-east-1.amazonaws.com/?Version=2010-03-31", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", formContentType)
+			params, err := http_server.ParseQueryParams(&http_server.ProxiedRequest{Request: r})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got %v, want error %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && (params.Get("Action") != "Publish" || params.Get("Message") != "a b" || params.Get("Version") != "2010-03-31") {
+				t.Errorf("got %v", params)
+			}
+			if body, _ := io.ReadAll(r.Body); string(body) != tt.body {
+				t.Errorf("got body %q after parsing, want %q", body, tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package http_server
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+)
+
+var ErrNoProvider = errors.New("no provider for request")
+
+// ProviderRegistry selects the provider for a request. The credential scope service (s3, dynamodb) is what the
+// client intends, so it is tried first, falling back to the host heuristics of CanHandleRequest.
+// This makes routing robust behind a single proxy hostname.
+//
+// When several providers CanHandleRequest, the one with the highest Priority wins (see PrioritizedProvider),
+// and providers of equal priority win in registration order.
+type ProviderRegistry struct {
+	mu        sync.RWMutex
+	byService map[string]AWSServiceProvider
+	providers []AWSServiceProvider
+}
+
+func NewProviderRegistry(providers ...AWSServiceProvider) *ProviderRegistry {
+	r := &ProviderRegistry{
+		byService: map[string]AWSServiceProvider{},
+	}
+	for _, provider := range providers {
+		r.Register(provider)
+	}
+	return r
+}
+
+// PrioritizedProvider is implemented by providers that should be tried before (or after) others in the host
+// fallback, e.g. a permissive custom provider. Providers without it have priority 0.
+type PrioritizedProvider interface {
+	Priority() int
+}
+
+func providerPriority(provider AWSServiceProvider) int {
+	if prioritized, ok := provider.(PrioritizedProvider); ok {
+		return prioritized.Priority()
+	}
+	return 0
+}
+
+// Register adds the provider for its ServiceName's credential scope service (replacing any provider
+// registered for it before), and to the host fallback
+func (r *ProviderRegistry) Register(provider AWSServiceProvider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.byService[provider.ServiceName()] = provider
+
+	// Insert after every provider of the same or higher priority, keeping registration order within a priority
+	priority := providerPriority(provider)
+	i := sort.Search(len(r.providers), func(i int) bool {
+		return providerPriority(r.providers[i]) < priority
+	})
+	r.providers = slices.Insert(r.providers, i, provider)
+}
+
+// RegisterScopeService maps a credential scope service to a provider, e.g. when one provider handles
+// several services, or its ServiceName differs from the scope
+func (r *ProviderRegistry) RegisterScopeService(service string, provider AWSServiceProvider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.byService[service] = provider
+}
+
+// GetProviderForRequest returns the provider of the request's credential scope service,
+// or the highest priority provider that CanHandleRequest by host
+func (r *ProviderRegistry) GetProviderForRequest(request *ProxiedRequest) (AWSServiceProvider, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if provider, ok := r.byService[request.Service]; ok {
+		return provider, nil
+	}
+	for _, provider := range r.providers {
+		if provider.CanHandleRequest(request) {
+			return provider, nil
+		}
+	}
+	return nil, ErrNoProvider
+}
@@ -0,0 +1,88 @@
+package http_server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+)
+
+// newRegistryRequest is a request to host, signed for the credential scope service
+func newRegistryRequest(host, service string) *http_server.ProxiedRequest {
+	r := httptest.NewRequest(http.MethodGet, "https://"+host+"/", nil)
+	return &http_server.ProxiedRequest{Request: r, Service: service}
+}
+
+func TestProviderRegistryScopeServiceWinsOverHost(t *testing.T) {
+	s3, dynamodb := http_server.NewS3Provider(), http_server.NewDynamoDBProvider()
+	registry := http_server.NewProviderRegistry(s3, dynamodb)
+
+	// The host looks like DynamoDB, but the client signed for S3
+	request := newRegistryRequest("dynamodb.us-east-1.amazonaws.com", "s3")
+	if !dynamodb.CanHandleRequest(request) {
+		t.Fatal("the host heuristic should pick dynamodb")
+	}
+	provider, err := registry.GetProviderForRequest(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider != s3 {
+		t.Errorf("got the %s provider, want s3", provider.ServiceName())
+	}
+
+	// Without a provider for the scope, the host decides
+	provider, err = registry.GetProviderForRequest(newRegistryRequest("dynamodb.us-east-1.amazonaws.com", "execute-api"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider != dynamodb {
+		t.Errorf("got the %s provider, want dynamodb", provider.ServiceName())
+	}
+}
+
+// prioritizedS3Provider is an S3Provider with a Priority
+type prioritizedS3Provider struct {
+	*http_server.S3Provider
+	priority int
+}
+
+func (p prioritizedS3Provider) Priority() int {
+	return p.priority
+}
+
+func TestProviderRegistryHigherPriorityWins(t *testing.T) {
+	low := prioritizedS3Provider{S3Provider: http_server.NewS3Provider(), priority: -1}
+	unprioritized := http_server.NewS3Provider()
+	high := prioritizedS3Provider{S3Provider: http_server.NewS3Provider(), priority: 10}
+
+	tests := []struct {
+		name      string
+		providers []http_server.AWSServiceProvider
+		want      http_server.AWSServiceProvider
+	}{
+		{name: "higher registered last", providers: []http_server.AWSServiceProvider{low, unprioritized, high}, want: high},
+		{name: "higher registered first", providers: []http_server.AWSServiceProvider{high, unprioritized, low}, want: high},
+		{name: "default over lower", providers: []http_server.AWSServiceProvider{low, unprioritized}, want: unprioritized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := http_server.NewProviderRegistry(tt.providers...)
+			// Every provider can handle the host, and none is registered for the scope service
+			provider, err := registry.GetProviderForRequest(newRegistryRequest("bucket.s3.amazonaws.com", "execute-api"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if provider != tt.want {
+				t.Errorf("got the provider of priority %d", priorityOf(provider))
+			}
+		})
+	}
+}
+
+func priorityOf(provider http_server.AWSServiceProvider) int {
+	if prioritized, ok := provider.(http_server.PrioritizedProvider); ok {
+		return prioritized.Priority()
+	}
+	return 0
+}
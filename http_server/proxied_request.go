@@ -1,11 +1,23 @@
 package http_server
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"regexp"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/samber/lo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/danthegoodman1/IAMTheService/tracing"
 )
 
 type ProxiedRequest struct {
@@ -16,90 +28,348 @@ type ProxiedRequest struct {
 	KeySecret    string
 	Service      string
 	XAMZDate     string
+	// Principal is the resolved identity behind KeyID
+	Principal Principal
+	// ClientIP is the real client IP, honoring X-Forwarded-For from trusted proxies
+	ClientIP string
+	// Operation is the API operation (e.g. "GetObject"), set by the provider when it dispatches the request
+	Operation string
+	// PostPolicy is set for browser-based S3 uploads, which are signed by the form rather than the request
+	PostPolicy *S3PostPolicy
 
 	responseWriter http.ResponseWriter
 	hijacked       bool
 	parsedHeader   AWSAuthHeader
+	// clientAuth is the auth the client signed with, before any handler changes to parsedHeader
+	clientAuth    AWSAuthHeader
+	originClients OriginClientProvider
+	outboundCreds OutboundCredentialsProvider
+	// X-Forwarded-* headers to set on the outbound request
+	forwardedHeaders http.Header
+	// Headers injected with AddOutboundHeader, and which of them are signed
+	outboundHeaders       http.Header
+	outboundSignedHeaders []string
+	hedgePolicy           *HedgePolicy
+	retryPolicy           *RetryPolicy
+	// originOverride replaces the host of DoProxiedRequest, see OriginOverride
+	originOverride string
+	// hostLookup is the HostLookupFunc of the AWSProxy, mapping OriginalHost to an origin
+	hostLookup LookupFunc[string, string]
+	// Recorder of the OperationRouter that dispatched the request
+	operationRecorder OperationRecorder
+	// clock of the AWSProxy, dating the outbound signature
+	clock Clock
 }
 
-func cloneBody(orig io.ReadCloser) (io.ReadCloser, io.ReadCloser) {
-	// Create two pipes.
-	pr1, pw1 := io.Pipe()
-	pr2, pw2 := io.Pipe()
-
-	// Create a MultiWriter that writes to both pipe writers.
-	multiWriter := io.MultiWriter(pw1, pw2)
-
-	// Start a goroutine that copies data from the original stream
-	// to both pipe writers concurrently.
-	go func() {
-		// Ensure both writers are closed when done.
-		defer pw1.Close()
-		defer pw2.Close()
-
-		// Copy data from the original stream to both pipes.
-		if _, err := io.Copy(multiWriter, orig); err != nil {
-			// Propagate the error to both pipes.
-			pw1.CloseWithError(err)
-			pw2.CloseWithError(err)
-		}
+// GetClonedBody will get a clone of the original request body that can be read, without breaking
+// the original *http.Request.Body. Nothing is copied unless this is called, so pass-through requests stream
+// the body straight to the origin. The clone streams alongside the body sent to the origin, buffering only
+// what one has read ahead of the other, so a clone read in full before DoProxiedRequest buffers the whole body.
+// Close it once done, so the rest of the body isn't kept for it.
+func (r *ProxiedRequest) GetClonedBody() io.ReadCloser {
+	return r.cloneBody()
+}
 
-		// Close the original stream so we don't leak
-		orig.Close()
-	}()
+// DecodedBody returns the request payload for handlers to inspect, de-framing (and verifying the chunk
+// signatures of) aws-chunked streaming uploads.
+// Like GetClonedBody, DoProxiedRequest still forwards the original (framed) body untouched, and whatever
+// is read ahead of it is buffered.
+func (r *ProxiedRequest) DecodedBody() io.Reader {
+	body := r.cloneBody()
+	if isSignedStreaming(r.Request) && r.clientAuth.Algorithm != AlgorithmSigV4A && r.KeySecret != "" {
+		// Handlers never see a payload the client didn't sign
+		return newVerifiedChunkedReader(body, r.Request, r.clientAuth, r.KeySecret)
+	}
+	if isAWSChunked(r.Request) {
+		return newAWSChunkedReader(body)
+	}
+	return body
+}
 
-	return pr1, pr2
+// PeekBody returns up to the first n bytes of the request body for inspection (e.g. sniffing a content type),
+// while the full body still streams to the origin. Unlike GetClonedBody, only the peeked bytes are buffered.
+// Fewer than n bytes are returned if the body is shorter.
+func (r *ProxiedRequest) PeekBody(n int) ([]byte, error) {
+	original := r.Request.Body
+	peeked := make([]byte, n)
+	read, err := io.ReadFull(original, peeked)
+	peeked = peeked[:read]
+	r.Request.Body = readCloser{
+		Reader: io.MultiReader(bytes.NewReader(peeked), original),
+		Closer: original,
+	}
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return peeked, fmt.Errorf("error reading body: %w", err)
+	}
+	return peeked, nil
 }
 
-// GetClonedBody will get a clone of the original request body that can be read, without breaking
-// the original *http.Request.Body
-func (r *ProxiedRequest) GetClonedBody() io.Reader {
-	cloned, original := cloneBody(r.Request.Body)
-	r.Request.Body = original
+// cloneBody tees the request body, replacing it with one side and returning the other
+func (r *ProxiedRequest) cloneBody() io.ReadCloser {
+	original := r.Request.Body
+	if original == nil || original == http.NoBody {
+		return http.NoBody
+	}
+	outbound, clone := newBodyTee(original)
+	r.Request.Body = outbound
+	return clone
+}
 
-	return cloned
+// lookupOriginHost returns the origin that the HostLookupFunc of the AWSProxy maps the incoming host to,
+// or "" if there is no lookup or it has no origin for the host
+func (r *ProxiedRequest) lookupOriginHost(ctx context.Context) (string, error) {
+	if r.hostLookup == nil {
+		return "", nil
+	}
+	host, err := r.hostLookup(ctx, r.OriginalHost)
+	if errors.Is(err, ErrKeyNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error in HostLookupFunc for host %s: %w", r.OriginalHost, err)
+	}
+	return host, nil
 }
 
-// DoProxiedRequest will do the original request, replacing the specified host
+// DoProxiedRequest will do the original request, replacing the specified host.
+// The host is requested over https, unless it is prefixed with a scheme (e.g. http://localhost:9000).
+// A trusted OriginOverride takes precedence over host.
 func (r *ProxiedRequest) DoProxiedRequest(ctx context.Context, host string) (*http.Response, error) {
-	originalURL := r.Request.URL
-	oldHost := r.Request.Host
+	if r.originOverride != "" {
+		host = r.originOverride
+	}
+	if r.hedgePolicy != nil && r.hedgePolicy.shouldHedge(r) {
+		return r.doHedgedRequest(ctx, host)
+	}
+	if r.retryPolicy != nil {
+		return r.doRetriedRequest(ctx, host)
+	}
+	return r.doProxiedRequest(ctx, host, r.Request.Body)
+}
 
-	// set the new host
-	originalURL.Host = host
+func (r *ProxiedRequest) doProxiedRequest(ctx context.Context, host string, body io.Reader) (_ *http.Response, err error) {
+	ctx, span := tracing.Tracer.Start(ctx, "DoProxiedRequest", trace.WithSpanKind(trace.SpanKindClient))
+	defer func() { endSpan(span, err) }()
 
-	// Because we changed the host, we need to resign the request to the new host
-	r.Request.Host = host
-	signature := generateSigV4(r.Request, r.parsedHeader, r.KeySecret)
-	// Put the host back
-	r.Request.Host = oldHost
+	scheme := "https"
+	if before, after, found := strings.Cut(host, "://"); found {
+		scheme, host = before, after
+	}
 
-	// Update the auth header with the new signature
-	originalAuthHeader := r.Request.Header.Get("Authorization")
-	re := regexp.MustCompile(`Signature=[^,]+`)
-	re.ReplaceAllString(originalAuthHeader, "Signature="+signature)
+	// set the new host
+	outboundURL := *r.Request.URL
+	outboundURL.Scheme = scheme
+	outboundURL.Host = host
+	presigned := isPresignedRequest(r.Request)
+	if presigned {
+		// The origin gets a request signed in headers, the query signature is only valid for the client's host
+		outboundURL.RawQuery = withoutPresignQuery(outboundURL.RawQuery)
+	}
 
 	// Now we can do the original request
-	req, err := http.NewRequestWithContext(ctx, r.Request.Method, originalURL.String(), r.Request.Body)
+	req, err := http.NewRequestWithContext(ctx, r.Request.Method, outboundURL.String(), body)
 	if err != nil {
 		return nil, fmt.Errorf("error in http.NewRequestWithContext: %w", err)
 	}
 
-	// Copy headers
+	// Keep the original length, S3 rejects framed (aws-chunked) uploads sent with chunked transfer encoding.
+	// The framing and x-amz-decoded-content-length header are forwarded as-is.
+	req.ContentLength = outboundContentLength(body, r.Request.ContentLength)
+	if req.ContentLength == 0 {
+		req.Body = http.NoBody
+		req.GetBody = nil
+	}
+
+	// Copy headers. Expect: 100-continue is forwarded, so the transport holds the body until the origin
+	// continues, and only then reads the client body (which sends the client its 100 Continue).
 	for header, vals := range r.Request.Header {
 		req.Header[header] = vals
 	}
-	// Set new header
-	req.Header.Set("Authorization", originalAuthHeader)
+	for header, vals := range r.forwardedHeaders {
+		req.Header[header] = vals
+	}
+	// The transport frames the body from req.ContentLength, the signed header must agree with it
+	req.Header.Del("Transfer-Encoding")
+	if req.ContentLength >= 0 {
+		req.Header.Set("Content-Length", strconv.FormatInt(req.ContentLength, 10))
+	} else {
+		req.Header.Del("Content-Length")
+	}
+	// The client's signed host is replaced by the origin host, which is sent from req.Host
+	req.Header.Del("Host")
 
-	res, err := http.DefaultClient.Do(req)
+	originClients := r.originClients
+	if originClients == nil {
+		originClients = DefaultOriginClientProvider{}
+	}
+	target := OriginTarget{
+		Host:    host,
+		Service: r.Service,
+		Region:  r.Region,
+	}
+	client, extraHeaders, err := originClients.OriginClient(ctx, target)
 	if err != nil {
-		return nil, fmt.Errorf("error in http.DefaultClient.Do: %w", err)
+		return nil, fmt.Errorf("error in OriginClientProvider.OriginClient: %w", err)
+	}
+	for header, vals := range extraHeaders {
+		req.Header[header] = vals
 	}
+	for header, vals := range r.outboundHeaders {
+		req.Header[header] = vals
+	}
+	// The origin continues the trace, unsigned unless the client signed its own traceparent
+	injectTraceContext(ctx, req.Header)
+	span.SetAttributes(
+		attribute.String("http.method", req.Method),
+		attribute.String("server.address", host),
+	)
+
+	if r.PostPolicy != nil && r.outboundCreds != nil {
+		// POST policy uploads are signed by the policy in the form, which is re-signed for the origin's key
+		creds, err := r.outboundCreds.OutboundCredentials(ctx, target, r)
+		if err != nil {
+			return nil, fmt.Errorf("error in OutboundCredentials: %w", err)
+		}
+		if err = r.PostPolicy.resign(req, creds, r.Region, clockOrReal(r.clock).Now()); err != nil {
+			return nil, fmt.Errorf("error in resign: %w", err)
+		}
+	} else if r.PostPolicy == nil {
+		// Because we changed the host, we need to resign the request to the new host, dated now so retries
+		// and presigned URLs used long after their X-Amz-Date aren't rejected as stale.
+		// Signed headers are read from the outbound request, so handler modifications are covered.
+		// POST policy uploads signed with the origin's key are forwarded untouched.
+		signedHeaders := append(append([]string{}, r.parsedHeader.SignedHeaders...), r.outboundSignedHeaders...)
+		if presigned && r.parsedHeader.Algorithm == AlgorithmSigV4A {
+			// The origin gets the region set in a header, rather than the client's query
+			req.Header.Set("X-Amz-Region-Set", r.parsedHeader.Credential.Region)
+			signedHeaders = append(signedHeaders, "x-amz-region-set")
+		}
+		if token := sessionToken(r.Request); presigned && token != "" {
+			// The client's session token moves from the query to a header along with the signature
+			req.Header.Set("X-Amz-Security-Token", token)
+			signedHeaders = append(signedHeaders, "x-amz-security-token")
+		}
+		signingHeader, keySecret := r.parsedHeader, r.KeySecret
+		if r.outboundCreds != nil {
+			creds, err := r.outboundCreds.OutboundCredentials(ctx, target, r)
+			if err != nil {
+				return nil, fmt.Errorf("error in OutboundCredentials: %w", err)
+			}
+			signingHeader.Credential.KeyID, keySecret = creds.AccessKeyID, creds.SecretAccessKey
+			// The client's session token belongs to its own key
+			req.Header.Del("X-Amz-Security-Token")
+			signedHeaders = lo.Without(signedHeaders, "x-amz-security-token")
+			if creds.SessionToken != "" {
+				req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+				signedHeaders = append(signedHeaders, "x-amz-security-token")
+			}
+		}
+		outboundHeader, err := signOutbound(req, signingHeader, signedHeaders, keySecret, clockOrReal(r.clock).Now(), false)
+		if errors.Is(err, ErrPayloadHashMismatch) {
+			return nil, reject(RejectionPayloadMismatch, err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error in signOutbound: %w", err)
+		}
+
+		if isSignedStreaming(req) {
+			// Chunk signatures chain from the seed signature, which changed. SigV4A requests with signed
+			// chunks were rejected, see isSigV4ASignedStreaming.
+			inbound, err := newChunkSigner(r.Request, r.clientAuth, r.KeySecret)
+			if err != nil {
+				return nil, reject(RejectionInvalidSignature, fmt.Errorf("%w: %w", ErrAWSSignatureDoesNotMatch, err))
+			}
+			outbound, err := newChunkSigner(req, outboundHeader, keySecret)
+			if err != nil {
+				return nil, fmt.Errorf("error in newChunkSigner: %w", err)
+			}
+			req.Body = io.NopCloser(newChunkResigner(
+				body,
+				inbound,
+				outbound,
+				req.Header.Get("x-amz-content-sha256") == streamingSignedPayloadTrailer,
+			))
+			req.GetBody = nil
+		}
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error in client.Do: %w", err)
+	}
+	span.SetAttributes(attribute.Int("http.status_code", res.StatusCode))
 
 	return res, nil
 }
 
+// outboundContentLength is the length of the body to send, or -1 to send it with chunked transfer encoding.
+// Buffered bodies know their length, otherwise the declared length of the request is used. Handlers that
+// replace the body should update Request.ContentLength, -1 if unknown.
+func outboundContentLength(body io.Reader, declared int64) int64 {
+	if body == nil || body == http.NoBody {
+		return 0
+	}
+	if buffered, ok := body.(interface{ Len() int }); ok {
+		return int64(buffered.Len())
+	}
+	if declared == 0 {
+		// The server gives empty bodies http.NoBody, so this body was replaced without updating the length
+		return -1
+	}
+	return declared
+}
+
+// AddOutboundHeader adds a header (e.g. trace context or a tenant id) to the request sent to the origin only.
+// Signed headers are included in the re-signed request, which origins like S3 require for sensitive headers
+// (e.g. x-amz-*), unsigned headers are sent as-is.
+func (r *ProxiedRequest) AddOutboundHeader(name, value string, signed bool) {
+	if r.outboundHeaders == nil {
+		r.outboundHeaders = http.Header{}
+	}
+	r.outboundHeaders.Add(name, value)
+	name = strings.ToLower(name)
+	if signed && !lo.Contains(r.outboundSignedHeaders, name) {
+		r.outboundSignedHeaders = append(r.outboundSignedHeaders, name)
+	}
+}
+
+// SetSignedHeader sets a header on the request and adds it to SignedHeaders if needed,
+// so the re-signed outbound request covers the new value
+func (r *ProxiedRequest) SetSignedHeader(name, value string) {
+	r.Request.Header.Set(name, value)
+	name = strings.ToLower(name)
+	if !lo.Contains(r.parsedHeader.SignedHeaders, name) {
+		r.parsedHeader.SignedHeaders = append(r.parsedHeader.SignedHeaders, name)
+	}
+}
+
+// SetBody replaces the body sent to the origin, and the signed x-amz-content-sha256 with its hash.
+// Handlers must replace the body with it: a body that doesn't match the declared hash is rejected rather
+// than re-signed, so a body tampered with after the client signed it never reaches the origin.
+func (r *ProxiedRequest) SetBody(body []byte) {
+	r.Request.Body = io.NopCloser(bytes.NewReader(body))
+	r.Request.ContentLength = int64(len(body))
+	r.Request.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	sum := sha256.Sum256(body)
+	r.SetSignedHeader("x-amz-content-sha256", hex.EncodeToString(sum[:]))
+}
+
+// RewriteCopySource points a CopyObject (or UploadPartCopy) at a different source object, e.g. to map a
+// tenant's bucket to a shared one. The outbound request is re-signed with the new x-amz-copy-source.
+func (r *ProxiedRequest) RewriteCopySource(bucket, key string) {
+	segments := strings.Split(key, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	r.SetSignedHeader("x-amz-copy-source", "/"+bucket+"/"+strings.Join(segments, "/"))
+}
+
+// IsPostPolicyUpload returns whether the request is a browser-based S3 upload (HTML form POST),
+// whose signature was verified against the policy document in the form
+func (r *ProxiedRequest) IsPostPolicyUpload() bool {
+	return r.PostPolicy != nil
+}
+
 // Hijack tells the proxy that it is no longer responsible for handling the
 // response to the original request, and gives you the response writer instead.
 // It is not checked whether this has been called prior, so be careful with creating multiple writers
@@ -0,0 +1,63 @@
+package http_server_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+func TestPeekBodyForwardsBodyUnchanged(t *testing.T) {
+	const peekSize = 16
+	var peeked []byte
+	h := iamtest.NewHarness(func(originURL string) http_server.AWSServiceProvider {
+		p := http_server.NewS3Provider()
+		p.OriginHost = originURL
+		p.Use(func(next http_server.OperationHandler) http_server.OperationHandler {
+			return func(ctx context.Context, request *http_server.ProxiedRequest) (*http.Response, error) {
+				var err error
+				if peeked, err = request.PeekBody(peekSize); err != nil {
+					return nil, err
+				}
+				return next(ctx, request)
+			}
+		})
+		return p
+	})
+	t.Cleanup(h.Close)
+
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		{name: "empty", body: []byte{}},
+		{name: "shorter than the peek", body: []byte("short")},
+		{name: "longer than the peek", body: bytes.Repeat([]byte("0123456789"), 1000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(h.Origin.Requests())
+			res, err := h.Do(h.NewSignedRequest(http.MethodPut, "/bucket/key", tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("got status %d", res.StatusCode)
+			}
+
+			if want := tt.body[:min(len(tt.body), peekSize)]; !bytes.Equal(peeked, want) {
+				t.Errorf("peeked %q, want %q", peeked, want)
+			}
+			requests := h.Origin.Requests()[before:]
+			if len(requests) != 1 || !bytes.Equal(requests[0].Body, tt.body) {
+				t.Fatalf("origin didn't receive the body unchanged: %d requests", len(requests))
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package http_server_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+func TestProxyClockDatesOutboundSignature(t *testing.T) {
+	now := time.Date(2013, 5, 24, 0, 0, 0, 0, time.UTC)
+	h := newS3Harness(t)
+	clock := http_server.NewFakeClock(now)
+	h.Proxy.Clock = clock
+
+	// Signed a minute before the proxy's clock, and years before the real one
+	r, _ := http.NewRequest(http.MethodGet, h.Server.URL+"/bucket/key", nil)
+	http_server.SignRequest(r, iamtest.KeyID, iamtest.KeySecret, iamtest.Region, "s3", now.Add(-time.Minute))
+	res, err := h.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d", res.StatusCode)
+	}
+
+	requests := h.Origin.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("origin received %d requests", len(requests))
+	}
+	if got := requests[0].Header.Get("X-Amz-Date"); got != "20130524T000000Z" {
+		t.Errorf("outbound request dated %s, want the proxy's clock", got)
+	}
+
+	// The real clock would have rejected it as skewed
+	h.Proxy.Clock = nil
+	r, _ = http.NewRequest(http.MethodGet, h.Server.URL+"/bucket/key", nil)
+	http_server.SignRequest(r, iamtest.KeyID, iamtest.KeySecret, iamtest.Region, "s3", now)
+	if res, err = h.Do(r); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("got status %d, want 403", res.StatusCode)
+	}
+}
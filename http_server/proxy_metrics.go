@@ -0,0 +1,68 @@
+package http_server
+
+import (
+	"io"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Proxy metrics are on the default prometheus registry, served at /metrics of the internal HTTP server.
+// Operation handler cache hits are iam_proxy_operation_cache_results_total, see PrometheusOperationRecorder.
+
+// unknownKeyLabel is the key_id of verifications of unknown keys, so made up key ids can't blow up cardinality
+const unknownKeyLabel = "unknown"
+
+var (
+	signatureVerifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "iam_proxy_signature_verifications_total",
+		Help: "Signature verifications, by key id and success, failure, or unknown_key",
+	}, []string{"key_id", "result"})
+	proxiedRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "iam_proxy_request_duration_seconds",
+		Help:    "Time from receiving a verified request to the end of its response stream",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"service", "operation"})
+	upstreamResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "iam_proxy_upstream_responses_total",
+		Help: "Origin responses, by status code",
+	}, []string{"service", "status"})
+	proxiedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "iam_proxy_bytes_total",
+		Help: "Body bytes read from clients (in) and streamed back from the origin (out)",
+	}, []string{"service", "direction"})
+	lookupCacheResults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "iam_proxy_lookup_cache_results_total",
+		Help: "Local cache lookups of remote lookup providers, by hit or miss",
+	}, []string{"provider", "result"})
+)
+
+func recordSignatureVerification(keyID, result string) {
+	if result == "unknown_key" {
+		keyID = unknownKeyLabel
+	}
+	signatureVerifications.WithLabelValues(keyID, result).Inc()
+}
+
+func recordLookupCacheResult(provider string, hit bool) {
+	lookupCacheResults.WithLabelValues(provider, cacheResultLabel(hit)).Inc()
+}
+
+func cacheResultLabel(hit bool) string {
+	if hit {
+		return "hit"
+	}
+	return "miss"
+}
+
+// countingReadCloser counts the bytes read through it
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
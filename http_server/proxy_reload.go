@@ -0,0 +1,85 @@
+package http_server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ProxyLookups are the parts of an AWSProxy that AWSProxy.Reload swaps at runtime, see the AWSProxy
+// fields of the same names
+type ProxyLookups struct {
+	KeyLookupFunc     LookupFunc[string, string]
+	SecretProvider    SecretProvider
+	CredentialStore   CredentialStore
+	HostLookupFunc    LookupFunc[string, string]
+	ServiceLookupFunc LookupFunc[string, AWSServiceProvider]
+	Providers         *ProviderRegistry
+	Limits            *RequestLimits
+	PolicyLookupFunc  LookupFunc[string, []PolicyDocument]
+	Authorizer        Authorizer
+	// RateLimitLookupFunc swaps the rules, the buckets of the RateLimitStore are kept
+	RateLimitLookupFunc LookupFunc[string, []RateLimitRule]
+}
+
+// Validate checks the lookups can serve requests: that they have a source of key secrets, and of providers
+func (l ProxyLookups) Validate() error {
+	if l.KeyLookupFunc == nil && l.SecretProvider == nil && l.CredentialStore == nil {
+		return errors.New("no KeyLookupFunc, SecretProvider, or CredentialStore")
+	}
+	if l.ServiceLookupFunc == nil && l.Providers == nil {
+		return errors.New("no ServiceLookupFunc or Providers")
+	}
+	return nil
+}
+
+// Reload atomically replaces the lookups, providers, and limits of the proxy, e.g. after the config changed.
+// Requests in flight finish with what they already looked up, and nothing else (rate limits, caches,
+// in-flight tracking) is reset. Lookups that don't Validate are rejected, keeping the current ones.
+func (p *AWSProxy) Reload(lookups ProxyLookups) error {
+	if err := lookups.Validate(); err != nil {
+		return fmt.Errorf("error in Validate: %w", err)
+	}
+	p.reloaded.Store(&lookups)
+	return nil
+}
+
+// Lookups returns the current lookups, from the last Reload or the AWSProxy fields if it was never reloaded
+func (p *AWSProxy) Lookups() ProxyLookups {
+	if reloaded := p.reloaded.Load(); reloaded != nil {
+		return *reloaded
+	}
+	return ProxyLookups{
+		KeyLookupFunc:       p.KeyLookupFunc,
+		SecretProvider:      p.SecretProvider,
+		CredentialStore:     p.CredentialStore,
+		HostLookupFunc:      p.HostLookupFunc,
+		ServiceLookupFunc:   p.ServiceLookupFunc,
+		Providers:           p.Providers,
+		Limits:              p.Limits,
+		PolicyLookupFunc:    p.PolicyLookupFunc,
+		Authorizer:          p.Authorizer,
+		RateLimitLookupFunc: p.RateLimitLookupFunc,
+	}
+}
+
+// ReloadFunc reloads the config of the server, e.g. re-reading a config file and calling AWSProxy.Reload
+type ReloadFunc func(ctx context.Context) error
+
+type ReloadResult struct {
+	Reloaded bool `json:"reloaded"`
+}
+
+func (s *HTTPServer) Reload(c echo.Context) error {
+	if s.reload == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "reload is not configured")
+	}
+	if err := s.reload(c.Request().Context()); err != nil {
+		return fmt.Errorf("error in reload: %w", err)
+	}
+	logger.Warn().Msg("reloaded config")
+	return c.JSON(http.StatusOK, ReloadResult{Reloaded: true})
+}
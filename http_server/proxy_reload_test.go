@@ -0,0 +1,109 @@
+package http_server_test
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+// newReloadLookups serves the key with secret, proxying S3 to origin
+func newReloadLookups(keyID, secret string, origin *iamtest.FakeOrigin) http_server.ProxyLookups {
+	provider := http_server.NewS3Provider()
+	provider.OriginHost = origin.URL
+	return http_server.ProxyLookups{
+		KeyLookupFunc:     iamtest.MapLookupFunc(map[string]string{keyID: secret}),
+		ServiceLookupFunc: iamtest.StaticLookupFunc[string, http_server.AWSServiceProvider](provider),
+	}
+}
+
+func TestProxyReloadWithRequestsInFlight(t *testing.T) {
+	h := newS3Harness(t)
+	started, release := make(chan struct{}), make(chan struct{})
+	h.Origin.Handle(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "old origin")
+	})
+	next := iamtest.NewFakeOrigin()
+	defer next.Close()
+	next.RespondWith(http.StatusOK, nil, []byte("new origin"))
+
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	do := func(r *http.Request) result {
+		res, err := h.Do(r)
+		if err != nil {
+			return result{err: err}
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return result{status: res.StatusCode, body: string(body)}
+	}
+	inFlight := make(chan result, 1)
+	go func() {
+		inFlight <- do(h.NewSignedRequest(http.MethodGet, "/bucket/key", nil))
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request never reached the origin")
+	}
+
+	// The key is rotated and the origin moved while the request is in flight
+	if err := h.Proxy.Reload(newReloadLookups(iamtest.KeyID, "rotated-secret", next)); err != nil {
+		t.Fatal(err)
+	}
+	r, _ := http.NewRequest(http.MethodGet, h.Server.URL+"/bucket/key", nil)
+	http_server.SignRequest(r, iamtest.KeyID, "rotated-secret", iamtest.Region, "s3", time.Now())
+	if got := do(r); got.err != nil || got.status != http.StatusOK || got.body != "new origin" {
+		t.Errorf("got %+v with the reloaded config, want the new origin", got)
+	}
+	if got := do(h.NewSignedRequest(http.MethodGet, "/bucket/key", nil)); got.err != nil || got.status != http.StatusForbidden {
+		t.Errorf("got %+v with the replaced secret, want it rejected", got)
+	}
+
+	// The request in flight finishes with the config it started with
+	close(release)
+	select {
+	case got := <-inFlight:
+		if got.err != nil || got.status != http.StatusOK || got.body != "old origin" {
+			t.Errorf("in flight request got %+v", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("in flight request never finished")
+	}
+}
+
+func TestProxyReloadRejectsInvalidLookups(t *testing.T) {
+	h := newS3Harness(t)
+	valid := newReloadLookups(iamtest.KeyID, iamtest.KeySecret, h.Origin)
+	noKeys, noProviders := valid, valid
+	noKeys.KeyLookupFunc = nil
+	noProviders.ServiceLookupFunc = nil
+
+	for name, lookups := range map[string]http_server.ProxyLookups{"no keys": noKeys, "no providers": noProviders, "empty": {}} {
+		if err := h.Proxy.Reload(lookups); err == nil {
+			t.Errorf("%s: reloaded", name)
+		}
+		// The current config keeps serving
+		res, err := h.Do(h.NewSignedRequest(http.MethodGet, "/bucket/key", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("%s: got status %d after a rejected reload", name, res.StatusCode)
+		}
+	}
+
+	if err := h.Proxy.Reload(valid); err != nil {
+		t.Errorf("valid lookups: %v", err)
+	}
+}
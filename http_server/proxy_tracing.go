@@ -0,0 +1,56 @@
+package http_server
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/danthegoodman1/IAMTheService/tracing"
+)
+
+// Spans of a proxied request are:
+//
+//	proxy request (server, continuing the client's traceparent)
+//	├── verify signature
+//	│   └── lookup secrets
+//	├── lookup service provider
+//	└── handle request
+//	    └── DoProxiedRequest (client, per attempt, propagating traceparent to the origin)
+//
+// They are recorded with the global tracer provider, see tracing.InitTracer.
+
+// startProxySpan starts the span of an incoming request, continuing the trace context of its headers
+func startProxySpan(r *http.Request) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	return tracing.Tracer.Start(ctx, "proxy request",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.method", r.Method),
+			attribute.String("http.host", r.Host),
+		),
+	)
+}
+
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracing.Tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends the span, recording err if any. Ending a span again is a no-op, so it can be deferred
+// for early returns and ended explicitly on success.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// injectTraceContext sets the traceparent of the span in ctx on an outbound request
+func injectTraceContext(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
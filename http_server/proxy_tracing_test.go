@@ -0,0 +1,117 @@
+package http_server_test
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+var (
+	spanExporter     = tracetest.NewInMemoryExporter()
+	spanExporterOnce sync.Once
+)
+
+// recordSpans records the spans of the global tracer provider, which the tracer of the proxy delegates to
+// once it is set, so it is only set once for the tests
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	spanExporterOnce.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spanExporter)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	spanExporter.Reset()
+	return spanExporter
+}
+
+// waitForSpan waits for the span to end, the server span ends after the response was written
+func waitForSpan(t *testing.T, exporter *tracetest.InMemoryExporter, name string) tracetest.SpanStub {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		for _, span := range exporter.GetSpans() {
+			if span.Name == name {
+				return span
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no %q span in %v", name, exporter.GetSpans())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func spanAttribute(span tracetest.SpanStub, key string) string {
+	for _, attr := range span.Attributes {
+		if string(attr.Key) == key {
+			return attr.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestProxyTracing(t *testing.T) {
+	const (
+		clientTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		clientSpanID  = "00f067aa0ba902b7"
+	)
+	for _, continued := range []bool{true, false} {
+		exporter := recordSpans(t)
+		h := newS3Harness(t)
+		r := h.NewSignedRequest(http.MethodGet, "/bucket/key", nil)
+		if continued {
+			r.Header.Set("traceparent", "00-"+clientTraceID+"-"+clientSpanID+"-01")
+		}
+		res, err := h.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("got status %d", res.StatusCode)
+		}
+
+		server := waitForSpan(t, exporter, "proxy request")
+		if server.SpanKind != trace.SpanKindServer || spanAttribute(server, "http.method") != http.MethodGet {
+			t.Errorf("got server span %+v", server)
+		}
+		if continued {
+			if server.SpanContext.TraceID().String() != clientTraceID || server.Parent.SpanID().String() != clientSpanID || !server.Parent.IsRemote() {
+				t.Errorf("server span %s has parent %s, want the client's trace", server.SpanContext.TraceID(), server.Parent.SpanID())
+			}
+		} else if server.Parent.IsValid() {
+			t.Errorf("server span has parent %s without a traceparent", server.Parent.SpanID())
+		}
+
+		for _, name := range []string{"verify signature", "lookup secrets"} {
+			span := waitForSpan(t, exporter, name)
+			if got := spanAttribute(span, "key_id"); got != iamtest.KeyID {
+				t.Errorf("%s span has key_id %q, want %q", name, got, iamtest.KeyID)
+			}
+			if span.SpanContext.TraceID() != server.SpanContext.TraceID() {
+				t.Errorf("%s span is in trace %s", name, span.SpanContext.TraceID())
+			}
+		}
+
+		// The origin continues the trace from the span of the outbound request
+		client := waitForSpan(t, exporter, "DoProxiedRequest")
+		requests := h.Origin.Requests()
+		if len(requests) != 1 {
+			t.Fatalf("origin received %d requests", len(requests))
+		}
+		want := "00-" + server.SpanContext.TraceID().String() + "-" + client.SpanContext.SpanID().String() + "-01"
+		if got := requests[0].Header.Get("traceparent"); client.SpanKind != trace.SpanKindClient || got != want {
+			t.Errorf("origin got traceparent %q, want %q", got, want)
+		}
+	}
+}
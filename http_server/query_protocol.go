@@ -0,0 +1,110 @@
+package http_server
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// maxQueryFormBytes bounds how much of a query protocol form body is buffered, SNS messages can be 256KiB
+const maxQueryFormBytes = 1024 * 1024
+
+// isQueryForm is whether the query protocol parameters of the request are in a form body, rather than the query string
+func isQueryForm(r *http.Request) bool {
+	return r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded")
+}
+
+// ParseQueryParams parses the parameters of a query protocol (Action=...) request from its query string
+// and form body. The body is buffered, and still forwarded to the origin.
+func ParseQueryParams(request *ProxiedRequest) (url.Values, error) {
+	params := request.Request.URL.Query()
+	if !isQueryForm(request.Request) {
+		return params, nil
+	}
+
+	form, err := readQueryForm(request)
+	if err != nil {
+		return nil, fmt.Errorf("error in readQueryForm: %w", err)
+	}
+	for key, vals := range form {
+		params[key] = append(params[key], vals...)
+	}
+	return params, nil
+}
+
+// rewriteQueryParams replaces the values of parameters in the query string and form body of a query
+// protocol request, e.g. TopicArn. The form is re-encoded and the signed payload hash updated if it changed.
+func rewriteQueryParams(request *ProxiedRequest, rewrite func(key, value string) string) error {
+	query := request.Request.URL.Query()
+	if rewriteValues(query, rewrite) {
+		request.Request.URL.RawQuery = query.Encode()
+	}
+	if !isQueryForm(request.Request) {
+		return nil
+	}
+
+	form, err := readQueryForm(request)
+	if err != nil {
+		return fmt.Errorf("error in readQueryForm: %w", err)
+	}
+	if !rewriteValues(form, rewrite) {
+		return nil
+	}
+
+	request.SetBody([]byte(form.Encode()))
+	return nil
+}
+
+// readQueryForm buffers and parses the (bounded) form body, and restores the body
+func readQueryForm(request *ProxiedRequest) (url.Values, error) {
+	body, err := io.ReadAll(io.LimitReader(request.Request.Body, maxQueryFormBytes))
+	if err != nil {
+		return nil, fmt.Errorf("error reading request body: %w", err)
+	}
+	request.Request.Body.Close()
+	request.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("error in url.ParseQuery: %w", err)
+	}
+	return form, nil
+}
+
+// rewriteValues passes every value through rewrite, returning whether any changed
+func rewriteValues(values url.Values, rewrite func(key, value string) string) bool {
+	changed := false
+	for key, vals := range values {
+		for i, val := range vals {
+			if rewritten := rewrite(key, val); rewritten != val {
+				vals[i] = rewritten
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// newQueryResponse creates a successful query protocol response with v marshalled as its XML body
+func newQueryResponse(v any) *http.Response {
+	body, _ := xml.Marshal(v)
+	return newXMLResponse(body)
+}
+
+func newXMLResponse(b []byte) *http.Response {
+	body := append([]byte(xml.Header), b...)
+	header := http.Header{}
+	header.Set("Content-Type", "text/xml")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+}
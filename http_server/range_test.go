@@ -0,0 +1,89 @@
+package http_server_test
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+)
+
+// Ranged responses reach the client as the origin sent them, even with compression enabled
+func TestRangedResponseForwarded(t *testing.T) {
+	object := bytes.Repeat([]byte(`{"compressible":true}`), 200)
+	h := newS3Harness(t)
+	h.Proxy.Compression = &http_server.ResponseCompression{}
+	h.Origin.Handle(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		http.ServeContent(w, r, "key", time.Time{}, bytes.NewReader(object))
+	})
+
+	get := func(rangeHeader string) (*http.Response, []byte) {
+		t.Helper()
+		r := h.NewSignedRequest(http.MethodGet, "/bucket/key", nil)
+		r.Header.Set("Range", rangeHeader)
+		r.Header.Set("Accept-Encoding", "gzip")
+		res, err := h.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		if res.StatusCode != http.StatusPartialContent {
+			t.Fatalf("got status %d, want 206", res.StatusCode)
+		}
+		if res.Header.Get("Content-Encoding") != "" {
+			t.Errorf("got Content-Encoding %q on a ranged response", res.Header.Get("Content-Encoding"))
+		}
+		return res, body
+	}
+
+	t.Run("single range", func(t *testing.T) {
+		res, body := get("bytes=100-2099")
+		if got := res.Header.Get("Content-Range"); got != "bytes 100-2099/4200" {
+			t.Errorf("got Content-Range %q", got)
+		}
+		if got := res.Header.Get("Accept-Ranges"); got != "bytes" {
+			t.Errorf("got Accept-Ranges %q", got)
+		}
+		if res.ContentLength != 2000 || !bytes.Equal(body, object[100:2100]) {
+			t.Errorf("got %d bytes %q, want the range", res.ContentLength, body)
+		}
+	})
+
+	t.Run("multiple ranges", func(t *testing.T) {
+		res, body := get("bytes=0-9,20-29")
+		mediaType, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+		if err != nil || mediaType != "multipart/byteranges" {
+			t.Fatalf("got Content-Type %q", res.Header.Get("Content-Type"))
+		}
+		parts := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		for _, want := range []struct {
+			contentRange string
+			body         []byte
+		}{
+			{"bytes 0-9/4200", object[0:10]},
+			{"bytes 20-29/4200", object[20:30]},
+		} {
+			part, err := parts.NextPart()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := io.ReadAll(part)
+			if part.Header.Get("Content-Range") != want.contentRange || !bytes.Equal(got, want.body) {
+				t.Errorf("got part %q %q, want %q", part.Header.Get("Content-Range"), got, want.contentRange)
+			}
+		}
+		if _, err := parts.NextPart(); err != io.EOF {
+			t.Errorf("got %v after the ranges, want the end of the body", err)
+		}
+		if !strings.HasSuffix(string(body), "--"+params["boundary"]+"--\r\n") {
+			t.Error("multipart body was truncated")
+		}
+	})
+}
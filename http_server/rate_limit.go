@@ -0,0 +1,293 @@
+package http_server
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrAWSThrottling          = NewAWSError(http.StatusBadRequest, "Throttling", "Rate exceeded")
+	ErrAWSThrottlingException = NewAWSError(http.StatusBadRequest, "ThrottlingException", "Rate exceeded")
+)
+
+// RateLimit is a token bucket of Burst requests, refilled at Rate requests per second.
+// A low Rate with a high Burst is a quota, e.g. Rate 1000/86400 and Burst 1000 is 1000 requests a day.
+type RateLimit struct {
+	Rate float64 `json:"rate" yaml:"rate"`
+	// Burst defaults to Rate, at least 1
+	Burst int `json:"burst" yaml:"burst"`
+}
+
+func (l RateLimit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return math.Max(1, math.Ceil(l.Rate))
+}
+
+// RateLimitRule limits the requests of a key, optionally only to a service and operation. Each rule has
+// its own bucket, so a key can have an overall limit and a lower one for e.g. s3 PutObject.
+type RateLimitRule struct {
+	// Service is e.g. s3, empty for any
+	Service string `json:"service,omitempty" yaml:"service,omitempty"`
+	// Operation is e.g. PutObject, empty for any
+	Operation string `json:"operation,omitempty" yaml:"operation,omitempty"`
+	RateLimit `yaml:",inline"`
+}
+
+func (r RateLimitRule) matches(service, operation string) bool {
+	return r.Rate > 0 && (r.Service == "" || r.Service == service) && (r.Operation == "" || r.Operation == operation)
+}
+
+// bucket is the bucket of the rule for the key. Rules of the same scope (e.g. a per-second limit and a daily
+// quota) are told apart by their limit, so they don't share one.
+func (r RateLimitRule) bucket(keyID string) string {
+	return keyID + ":" + r.Service + ":" + r.Operation + ":" +
+		strconv.FormatFloat(r.Rate, 'g', -1, 64) + ":" + strconv.FormatFloat(r.burst(), 'g', -1, 64)
+}
+
+// StaticRateLimits is a RateLimitLookupFunc of rules per key id, with the "*" rules for keys without their own
+func StaticRateLimits(rules map[string][]RateLimitRule) LookupFunc[string, []RateLimitRule] {
+	return func(_ context.Context, keyID string) ([]RateLimitRule, error) {
+		if keyRules, ok := rules[keyID]; ok {
+			return keyRules, nil
+		}
+		if defaultRules, ok := rules["*"]; ok {
+			return defaultRules, nil
+		}
+		return nil, ErrKeyNotFound
+	}
+}
+
+// RateLimitStore holds the token buckets, e.g. MemoryRateLimitStore per instance or RedisRateLimitStore
+// shared across instances
+type RateLimitStore interface {
+	// Take takes a token from the bucket, returning false and how long until there is one if it is empty
+	Take(ctx context.Context, bucket string, limit RateLimit, now time.Time) (ok bool, retryAfter time.Duration, err error)
+	// Refund puts back a token taken from the bucket, for a request another bucket rejected
+	Refund(ctx context.Context, bucket string, limit RateLimit, now time.Time) error
+}
+
+// DefaultRateLimitMaxBuckets is the default MaxBuckets of MemoryRateLimitStore
+const DefaultRateLimitMaxBuckets = 100_000
+
+// MemoryRateLimitStore is a RateLimitStore of a single instance, the default of AWSProxy
+type MemoryRateLimitStore struct {
+	// MaxBuckets bounds the buckets, full buckets are dropped to make room, then the least recently used.
+	// Defaults to DefaultRateLimitMaxBuckets.
+	MaxBuckets int
+
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	// recent orders the buckets from most to least recently used
+	recent list.List
+}
+
+type tokenBucket struct {
+	name    string
+	tokens  float64
+	updated time.Time
+	// full is when the bucket refills, after which it can be dropped
+	full time.Time
+}
+
+func (s *MemoryRateLimitStore) Take(_ context.Context, bucket string, limit RateLimit, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buckets == nil {
+		s.buckets = map[string]*list.Element{}
+	}
+
+	burst := limit.burst()
+	var b *tokenBucket
+	if element, ok := s.buckets[bucket]; ok {
+		s.recent.MoveToFront(element)
+		b = element.Value.(*tokenBucket)
+	} else {
+		s.makeRoom(now)
+		b = &tokenBucket{name: bucket, tokens: burst, updated: now}
+		s.buckets[bucket] = s.recent.PushFront(b)
+	}
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed.Seconds()*limit.Rate)
+		b.updated = now
+	}
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second)), nil
+	}
+	b.tokens--
+	b.full = now.Add(time.Duration((burst - b.tokens) / limit.Rate * float64(time.Second)))
+	return true, 0, nil
+}
+
+func (s *MemoryRateLimitStore) Refund(_ context.Context, bucket string, limit RateLimit, _ time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if element, ok := s.buckets[bucket]; ok {
+		b := element.Value.(*tokenBucket)
+		b.tokens = math.Min(limit.burst(), b.tokens+1)
+	}
+	return nil
+}
+
+// makeRoom drops the full buckets if there are MaxBuckets, which behave the same as new ones. If none are
+// full (e.g. steady traffic from many keys), the least recently used are dropped, refilling them early.
+func (s *MemoryRateLimitStore) makeRoom(now time.Time) {
+	maxBuckets := s.MaxBuckets
+	if maxBuckets == 0 {
+		maxBuckets = DefaultRateLimitMaxBuckets
+	}
+	if len(s.buckets) < maxBuckets {
+		return
+	}
+	for bucket, element := range s.buckets {
+		if !now.Before(element.Value.(*tokenBucket).full) {
+			s.recent.Remove(element)
+			delete(s.buckets, bucket)
+		}
+	}
+	for len(s.buckets) >= maxBuckets {
+		b := s.recent.Remove(s.recent.Back()).(*tokenBucket)
+		delete(s.buckets, b.name)
+	}
+}
+
+// RedisScriptClient is the subset of a Redis client needed by RedisRateLimitStore,
+// wrap your client of choice (e.g. go-redis's Eval(...).Result()) to satisfy it
+type RedisScriptClient interface {
+	// Eval runs a Lua script, returning []any for array replies and int64 for integers
+	Eval(ctx context.Context, script string, keys []string, args ...string) (any, error)
+}
+
+// redisTokenBucketScript refills and takes from the bucket hash atomically, timed by the Redis clock so
+// instances with skewed clocks share buckets fairly. It returns {taken, retry after ms}.
+const redisTokenBucketScript = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(state[1]) or burst
+local updated = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - updated) / 1000 * rate)
+local taken, retry = 0, 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  taken = 1
+else
+  retry = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / rate * 1000) + 1000)
+return {taken, retry}
+`
+
+// redisRefundScript puts a token back in the bucket hash, if it hasn't expired (i.e. refilled) since
+const redisRefundScript = `
+local burst = tonumber(ARGV[1])
+local tokens = tonumber(redis.call('HGET', KEYS[1], 'tokens'))
+if tokens then
+  redis.call('HSET', KEYS[1], 'tokens', tostring(math.min(burst, tokens + 1)))
+end
+return 1
+`
+
+// RedisRateLimitStore is a RateLimitStore shared by the instances of a deployment through Redis
+type RedisRateLimitStore struct {
+	Client RedisScriptClient
+	// KeyPrefix namespaces the buckets, defaults to "iam:ratelimit:"
+	KeyPrefix string
+}
+
+func (s *RedisRateLimitStore) key(bucket string) string {
+	if s.KeyPrefix == "" {
+		return "iam:ratelimit:" + bucket
+	}
+	return s.KeyPrefix + bucket
+}
+
+func (s *RedisRateLimitStore) Take(ctx context.Context, bucket string, limit RateLimit, _ time.Time) (bool, time.Duration, error) {
+	reply, err := s.Client.Eval(ctx, redisTokenBucketScript, []string{s.key(bucket)},
+		strconv.FormatFloat(limit.Rate, 'f', -1, 64), strconv.FormatFloat(limit.burst(), 'f', -1, 64))
+	if err != nil {
+		return false, 0, fmt.Errorf("error in EVAL: %w", err)
+	}
+	values, ok := reply.([]any)
+	if !ok || len(values) != 2 {
+		return false, 0, fmt.Errorf("unexpected token bucket reply %v", reply)
+	}
+	taken, _ := values[0].(int64)
+	retryMs, _ := values[1].(int64)
+	return taken == 1, time.Duration(retryMs) * time.Millisecond, nil
+}
+
+func (s *RedisRateLimitStore) Refund(ctx context.Context, bucket string, limit RateLimit, _ time.Time) error {
+	_, err := s.Client.Eval(ctx, redisRefundScript, []string{s.key(bucket)}, strconv.FormatFloat(limit.burst(), 'f', -1, 64))
+	if err != nil {
+		return fmt.Errorf("error in EVAL: %w", err)
+	}
+	return nil
+}
+
+// throttlingError is how the protocol of the service says to slow down
+func throttlingError(service string) *AWSError {
+	switch ProtocolForService(service) {
+	case ProtocolJSON:
+		return ErrAWSThrottlingException
+	case ProtocolQuery:
+		return ErrAWSThrottling
+	}
+	return ErrAWSSlowDown
+}
+
+// rateLimit takes a token from each bucket of the key's rules that match the request, putting back those it took
+// if a later rule rejects it, so rejected requests don't count against the other limits. A store that fails
+// (e.g. Redis being unreachable) lets the request through, so it can't take the proxy down with it.
+func (p *AWSProxy) rateLimit(ctx context.Context, w http.ResponseWriter, service, operation string, request *ProxiedRequest, now time.Time) error {
+	lookup := p.Lookups().RateLimitLookupFunc
+	if lookup == nil {
+		return nil
+	}
+	rules, err := lookup(ctx, request.KeyID)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error in RateLimitLookupFunc: %w", err)
+	}
+
+	store := p.RateLimitStore
+	if store == nil {
+		store = &p.rateLimits
+	}
+	var taken []RateLimitRule
+	for _, rule := range rules {
+		if !rule.matches(service, operation) {
+			continue
+		}
+		ok, retryAfter, err := store.Take(ctx, rule.bucket(request.KeyID), rule.RateLimit, now)
+		if err != nil {
+			logger.Warn().Err(err).Str("keyID", request.KeyID).Msg("error taking rate limit token, allowing request")
+			continue
+		}
+		if !ok {
+			for _, takenRule := range taken {
+				if err := store.Refund(ctx, takenRule.bucket(request.KeyID), takenRule.RateLimit, now); err != nil {
+					logger.Warn().Err(err).Str("keyID", request.KeyID).Msg("error refunding rate limit token")
+				}
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			return reject(RejectionRateLimited, fmt.Errorf("key %s exceeded %g requests/s for %q %q: %w",
+				request.KeyID, rule.Rate, rule.Service, rule.Operation, throttlingError(service)))
+		}
+		taken = append(taken, rule)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package http_server_test
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+// newRateLimitedHarness is an S3 harness whose clock doesn't move, so buckets only refill when the test says so
+func newRateLimitedHarness(t *testing.T, rules []http_server.RateLimitRule) (*iamtest.Harness, *http_server.FakeClock) {
+	t.Helper()
+	h := newS3Harness(t)
+	clock := http_server.NewFakeClock(time.Now())
+	h.Proxy.Clock = clock
+	h.Proxy.RateLimitLookupFunc = http_server.StaticRateLimits(map[string][]http_server.RateLimitRule{iamtest.KeyID: rules})
+	return h, clock
+}
+
+func doRateLimited(t *testing.T, h *iamtest.Harness, method string) *http.Response {
+	t.Helper()
+	res, err := h.Do(h.NewSignedRequest(method, "/bucket/key", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	return res
+}
+
+func TestRateLimitThrottles(t *testing.T) {
+	h, clock := newRateLimitedHarness(t, []http_server.RateLimitRule{{RateLimit: http_server.RateLimit{Rate: 0.5, Burst: 2}}})
+
+	for i := 0; i < 2; i++ {
+		if res := doRateLimited(t, h, http.MethodGet); res.StatusCode != http.StatusOK {
+			t.Fatalf("request %d got status %d within the burst", i, res.StatusCode)
+		}
+	}
+
+	res, err := h.Do(h.NewSignedRequest(http.MethodGet, "/bucket/key", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	// S3 says to slow down with a 503 SlowDown, which its SDKs retry after backing off
+	if res.StatusCode != http.StatusServiceUnavailable || !strings.Contains(string(body), "<Code>SlowDown</Code>") {
+		t.Fatalf("got %d %s, want a SlowDown error", res.StatusCode, body)
+	}
+	if got := res.Header.Get("Retry-After"); got != "2" {
+		t.Errorf("got Retry-After %q, want the 2s until a token is refilled", got)
+	}
+	if got := res.Header.Get(http_server.RejectReasonHeader); got != string(http_server.RejectionRateLimited) {
+		t.Errorf("got rejection reason %q", got)
+	}
+	if n := len(h.Origin.Requests()); n != 2 {
+		t.Errorf("origin received %d requests, want the throttled one rejected", n)
+	}
+
+	clock.Advance(2 * time.Second)
+	if res := doRateLimited(t, h, http.MethodGet); res.StatusCode != http.StatusOK {
+		t.Errorf("got status %d once the bucket refilled", res.StatusCode)
+	}
+}
+
+// A per-second limit and a quota of the same scope have their own buckets, so the quota isn't refilled at the
+// per-second rate
+func TestRateLimitRulesOfTheSameScope(t *testing.T) {
+	h, _ := newRateLimitedHarness(t, []http_server.RateLimitRule{
+		{RateLimit: http_server.RateLimit{Rate: 10, Burst: 10}},
+		// 3 requests a day
+		{RateLimit: http_server.RateLimit{Rate: 3.0 / 86400, Burst: 3}},
+	})
+
+	for i := 0; i < 3; i++ {
+		if res := doRateLimited(t, h, http.MethodGet); res.StatusCode != http.StatusOK {
+			t.Fatalf("request %d got status %d within the quota", i, res.StatusCode)
+		}
+	}
+	res := doRateLimited(t, h, http.MethodGet)
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d, want the quota exhausted", res.StatusCode)
+	}
+	// The quota refills a request every 8h, not every 100ms
+	if retryAfter, _ := strconv.Atoi(res.Header.Get("Retry-After")); retryAfter < 28800 {
+		t.Errorf("got Retry-After %q, want the time until the quota refills a request", res.Header.Get("Retry-After"))
+	}
+}
+
+// A request rejected by one rule doesn't use up the tokens of the others
+func TestRateLimitRefundsEarlierRules(t *testing.T) {
+	h, _ := newRateLimitedHarness(t, []http_server.RateLimitRule{
+		{Service: "s3", RateLimit: http_server.RateLimit{Rate: 0.001, Burst: 3}},
+		{Service: "s3", Operation: "PutObject", RateLimit: http_server.RateLimit{Rate: 0.001, Burst: 1}},
+	})
+
+	if res := doRateLimited(t, h, http.MethodPut); res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d", res.StatusCode)
+	}
+	for i := 0; i < 3; i++ {
+		if res := doRateLimited(t, h, http.MethodPut); res.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("got status %d, want PutObject throttled", res.StatusCode)
+		}
+	}
+	// Only the PutObject that went through counts against the overall limit
+	for i := 0; i < 2; i++ {
+		if res := doRateLimited(t, h, http.MethodGet); res.StatusCode != http.StatusOK {
+			t.Fatalf("GetObject %d got status %d, want the overall limit refunded", i, res.StatusCode)
+		}
+	}
+	if res := doRateLimited(t, h, http.MethodGet); res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want the overall limit exhausted", res.StatusCode)
+	}
+}
+
+func TestRateLimitJSONProtocol(t *testing.T) {
+	h := iamtest.NewHarness(func(originURL string) http_server.AWSServiceProvider {
+		p := http_server.NewDynamoDBProvider()
+		p.OriginHost = originURL
+		return p
+	})
+	t.Cleanup(h.Close)
+	h.Proxy.Clock = http_server.NewFakeClock(time.Now())
+	h.Proxy.RateLimitLookupFunc = http_server.StaticRateLimits(map[string][]http_server.RateLimitRule{
+		"*": {{RateLimit: http_server.RateLimit{Rate: 1}}},
+	})
+
+	var res *http.Response
+	for i := 0; i < 2; i++ {
+		r := h.NewSignedRequest(http.MethodPost, "/", []byte(`{"TableName":"users"}`))
+		r.Header.Set("X-Amz-Target", "DynamoDB_20120810.GetItem")
+		var err error
+		if res, err = h.Do(r); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			res.Body.Close()
+		}
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), "ThrottlingException") {
+		t.Fatalf("got %d %s, want a ThrottlingException", res.StatusCode, body)
+	}
+	if got := res.Header.Get("Retry-After"); got != "1" {
+		t.Errorf("got Retry-After %q", got)
+	}
+}
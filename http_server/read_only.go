@@ -0,0 +1,189 @@
+package http_server
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+var ErrAWSReadOnly = NewAWSError(http.StatusServiceUnavailable, "ServiceUnavailable", "The service is in read-only mode for maintenance, please retry writes later.")
+
+// ReadOperations are the operations of each service that don't mutate, which read-only mode lets through.
+// They are listed explicitly since names don't tell, e.g. STS GetSessionToken mints credentials and SQS
+// ReceiveMessage hides the messages it returns. Add the reads of custom providers here, otherwise every
+// classified operation of their service is a write.
+var ReadOperations = map[string]OperationSet{
+	"s3": {
+		"GetObject": true, "HeadObject": true, "HeadBucket": true, "ListBuckets": true, "ListObjects": true,
+		"ListObjectsV2": true, "ListObjectVersions": true, "ListMultipartUploads": true, "ListParts": true,
+		"GetObjectAcl": true, "GetObjectAttributes": true, "GetObjectLegalHold": true, "GetObjectRetention": true,
+		"GetObjectTagging": true, "GetObjectTorrent": true, "SelectObjectContent": true,
+		"GetBucketAccelerateConfiguration": true, "GetBucketAcl": true, "GetBucketCors": true,
+		"GetBucketEncryption": true, "GetBucketLifecycleConfiguration": true, "GetBucketLocation": true,
+		"GetBucketLogging": true, "GetBucketNotificationConfiguration": true, "GetBucketOwnershipControls": true,
+		"GetBucketPolicy": true, "GetBucketPolicyStatus": true, "GetBucketReplication": true,
+		"GetBucketRequestPayment": true, "GetBucketTagging": true, "GetBucketVersioning": true,
+		"GetBucketWebsite": true, "GetObjectLockConfiguration": true, "GetPublicAccessBlock": true,
+	},
+	"dynamodb": {
+		"GetItem": true, "BatchGetItem": true, "TransactGetItems": true, "Query": true, "Scan": true,
+		"DescribeBackup": true, "DescribeContinuousBackups": true, "DescribeContributorInsights": true,
+		"DescribeEndpoints": true, "DescribeExport": true, "DescribeGlobalTable": true,
+		"DescribeGlobalTableSettings": true, "DescribeImport": true, "DescribeKinesisStreamingDestination": true,
+		"DescribeLimits": true, "DescribeTable": true, "DescribeTableReplicaAutoScaling": true,
+		"DescribeTimeToLive": true, "GetResourcePolicy": true, "ListBackups": true, "ListContributorInsights": true,
+		"ListExports": true, "ListGlobalTables": true, "ListImports": true, "ListTables": true,
+		"ListTagsOfResource": true,
+	},
+	"sqs": {
+		"GetQueueAttributes": true, "GetQueueUrl": true, "ListDeadLetterSourceQueues": true,
+		"ListMessageMoveTasks": true, "ListQueueTags": true, "ListQueues": true,
+	},
+	"sns": {
+		"GetDataProtectionPolicy": true, "GetEndpointAttributes": true, "GetPlatformApplicationAttributes": true,
+		"GetSMSAttributes": true, "GetSMSSandboxAccountStatus": true, "GetSubscriptionAttributes": true,
+		"GetTopicAttributes": true, "CheckIfPhoneNumberIsOptedOut": true, "ListEndpointsByPlatformApplication": true,
+		"ListOriginationNumbers": true, "ListPhoneNumbersOptedOut": true, "ListPlatformApplications": true,
+		"ListSMSSandboxPhoneNumbers": true, "ListSubscriptions": true, "ListSubscriptionsByTopic": true,
+		"ListTagsForResource": true, "ListTopics": true,
+	},
+	"sts": {
+		"GetCallerIdentity": true, "GetAccessKeyInfo": true, "DecodeAuthorizationMessage": true,
+	},
+	"kinesis": {
+		"DescribeLimits": true, "DescribeStream": true, "DescribeStreamConsumer": true, "DescribeStreamSummary": true,
+		"GetRecords": true, "GetResourcePolicy": true, "GetShardIterator": true, "ListShards": true,
+		"ListStreamConsumers": true, "ListStreams": true, "ListTagsForResource": true, "ListTagsForStream": true,
+		"SubscribeToShard": true,
+	},
+	"kms": {
+		"DescribeCustomKeyStores": true, "DescribeKey": true, "GetKeyPolicy": true, "GetKeyRotationStatus": true,
+		"GetPublicKey": true, "ListAliases": true, "ListGrants": true, "ListKeyPolicies": true,
+		"ListKeyRotations": true, "ListKeys": true, "ListResourceTags": true, "ListRetirableGrants": true,
+	},
+	"iam": {
+		"GetAccessKeyLastUsed": true, "GetAccountAuthorizationDetails": true, "GetAccountPasswordPolicy": true,
+		"GetAccountSummary": true, "GetContextKeysForCustomPolicy": true, "GetContextKeysForPrincipalPolicy": true,
+		"GetCredentialReport": true, "GetGroup": true, "GetGroupPolicy": true, "GetInstanceProfile": true,
+		"GetLoginProfile": true, "GetOpenIDConnectProvider": true, "GetPolicy": true, "GetPolicyVersion": true,
+		"GetRole": true, "GetRolePolicy": true, "GetSAMLProvider": true, "GetSSHPublicKey": true,
+		"GetServerCertificate": true, "GetServiceLastAccessedDetails": true, "GetUser": true, "GetUserPolicy": true,
+		"ListAccessKeys": true, "ListAccountAliases": true, "ListAttachedGroupPolicies": true,
+		"ListAttachedRolePolicies": true, "ListAttachedUserPolicies": true, "ListEntitiesForPolicy": true,
+		"ListGroupPolicies": true, "ListGroups": true, "ListGroupsForUser": true, "ListInstanceProfiles": true,
+		"ListInstanceProfilesForRole": true, "ListMFADevices": true, "ListOpenIDConnectProviders": true,
+		"ListPolicies": true, "ListPolicyTags": true, "ListPolicyVersions": true, "ListRolePolicies": true,
+		"ListRoleTags": true, "ListRoles": true, "ListSAMLProviders": true, "ListSSHPublicKeys": true,
+		"ListServerCertificates": true, "ListSigningCertificates": true, "ListUserPolicies": true,
+		"ListUserTags": true, "ListUsers": true, "ListVirtualMFADevices": true, "SimulateCustomPolicy": true,
+		"SimulatePrincipalPolicy": true,
+	},
+	"lambda": {
+		"GetFunction": true, "GetFunctionConfiguration": true, "ListAliases": true, "ListFunctions": true,
+		"ListVersionsByFunction": true,
+	},
+}
+
+// IsReadOperation classifies an operation of a service (e.g. s3 GetObject, dynamodb Query) as a read by
+// ReadOperations. Unknown operations are classified by HTTP method, so only GET and HEAD requests are reads.
+func IsReadOperation(service, operation, method string) bool {
+	if operation == "" || operation == OperationUnknown {
+		return method == http.MethodGet || method == http.MethodHead
+	}
+	return ReadOperations[service][operation]
+}
+
+// ReadOnlyMode rejects writes during origin maintenance, while reads pass through.
+// It can be enabled for every service, with per-service overrides, and changed at runtime.
+type ReadOnlyMode struct {
+	mu       sync.RWMutex
+	enabled  bool
+	services map[string]bool
+}
+
+// Set enables or disables read-only mode for services without an override
+func (m *ReadOnlyMode) Set(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.enabled = enabled
+}
+
+// SetService overrides read-only mode for a service (e.g. "s3")
+func (m *ReadOnlyMode) SetService(service string, enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.services == nil {
+		m.services = map[string]bool{}
+	}
+	m.services[service] = enabled
+}
+
+// ClearService removes the override of a service
+func (m *ReadOnlyMode) ClearService(service string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.services, service)
+}
+
+// Enabled returns whether writes to the service are rejected
+func (m *ReadOnlyMode) Enabled(service string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if enabled, ok := m.services[service]; ok {
+		return enabled
+	}
+	return m.enabled
+}
+
+type ReadOnlyStatus struct {
+	Enabled  bool            `json:"enabled"`
+	Services map[string]bool `json:"services"`
+}
+
+func (m *ReadOnlyMode) status() ReadOnlyStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	services := make(map[string]bool, len(m.services))
+	for service, enabled := range m.services {
+		services[service] = enabled
+	}
+	return ReadOnlyStatus{Enabled: m.enabled, Services: services}
+}
+
+type ReadOnlyBody struct {
+	Enabled *bool `json:"enabled" validate:"required"`
+	// Service optionally sets the override of a single service
+	Service string `json:"service"`
+	// Clear removes the override of Service
+	Clear bool `json:"clear"`
+}
+
+func (s *HTTPServer) GetReadOnly(c echo.Context) error {
+	if s.readOnly == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "read-only mode is not configured")
+	}
+	return c.JSON(http.StatusOK, s.readOnly.status())
+}
+
+func (s *HTTPServer) SetReadOnly(c echo.Context) error {
+	if s.readOnly == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "read-only mode is not configured")
+	}
+	var body ReadOnlyBody
+	if err := ValidateRequest(c, &body); err != nil {
+		return err
+	}
+
+	switch {
+	case body.Service != "" && body.Clear:
+		s.readOnly.ClearService(body.Service)
+	case body.Service != "":
+		s.readOnly.SetService(body.Service, *body.Enabled)
+	default:
+		s.readOnly.Set(*body.Enabled)
+	}
+	logger.Warn().Bool("enabled", *body.Enabled).Str("service", body.Service).Bool("clear", body.Clear).Msg("read-only mode changed")
+
+	return c.JSON(http.StatusOK, s.readOnly.status())
+}
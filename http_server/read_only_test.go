@@ -0,0 +1,68 @@
+package http_server_test
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+func newSTSProvider(originURL string) http_server.AWSServiceProvider {
+	p := http_server.NewSTSProvider()
+	p.OriginHost = originURL
+	return p
+}
+
+func newSQSProvider(originURL string) http_server.AWSServiceProvider {
+	p := http_server.NewSQSProvider()
+	p.OriginHost = originURL
+	return p
+}
+
+func TestReadOnlyModeRejectsWrites(t *testing.T) {
+	tests := []struct {
+		name        string
+		newProvider func(originURL string) http_server.AWSServiceProvider
+		action      string
+		wantRead    bool
+	}{
+		{name: "sts GetCallerIdentity", newProvider: newSTSProvider, action: "GetCallerIdentity", wantRead: true},
+		{name: "sts GetSessionToken", newProvider: newSTSProvider, action: "GetSessionToken"},
+		{name: "sts GetFederationToken", newProvider: newSTSProvider, action: "GetFederationToken"},
+		{name: "sqs GetQueueUrl", newProvider: newSQSProvider, action: "GetQueueUrl", wantRead: true},
+		{name: "sqs ReceiveMessage", newProvider: newSQSProvider, action: "ReceiveMessage"},
+		{name: "sqs SendMessage", newProvider: newSQSProvider, action: "SendMessage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := iamtest.NewHarness(tt.newProvider)
+			t.Cleanup(h.Close)
+			h.Proxy.ReadOnly = &http_server.ReadOnlyMode{}
+			h.Proxy.ReadOnly.Set(true)
+
+			r := h.NewSignedRequest(http.MethodPost, "/", []byte("Action="+tt.action))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			res, err := h.Do(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+
+			if tt.wantRead {
+				if res.StatusCode != http.StatusOK || len(h.Origin.Requests()) != 1 {
+					t.Fatalf("got %d %s and %d origin requests, want it proxied", res.StatusCode, body, len(h.Origin.Requests()))
+				}
+				return
+			}
+			if res.StatusCode != http.StatusServiceUnavailable || len(h.Origin.Requests()) != 0 {
+				t.Fatalf("got %d %s and %d origin requests, want it rejected", res.StatusCode, body, len(h.Origin.Requests()))
+			}
+			if got := res.Header.Get(http_server.RejectReasonHeader); got != string(http_server.RejectionReadOnly) {
+				t.Errorf("got rejection reason %q", got)
+			}
+		})
+	}
+}
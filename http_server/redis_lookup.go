@@ -0,0 +1,72 @@
+package http_server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// RedisLookupProvider is a LookupProvider reading values from Redis strings at <KeyPrefix><key>, e.g.
+// iam:keys:<key id> for AWSProxy.KeyLookupFunc and iam:hosts:<host> for AWSProxy.HostLookupFunc.
+// Found values are cached locally for TTL and missing ones for NegativeTTL, so a hot key (or a client
+// retrying an unknown key) doesn't hit Redis on every request.
+type RedisLookupProvider struct {
+	Client RedisCacheClient
+	// KeyPrefix namespaces the keys, e.g. "iam:keys:"
+	KeyPrefix string
+	// TTL caches found values, 0 disables it
+	TTL time.Duration
+	// NegativeTTL caches missing values, 0 disables it
+	NegativeTTL time.Duration
+	// MaxCacheEntries bounds the local cache, defaults to DefaultLookupMaxCacheEntries
+	MaxCacheEntries int
+	// Clock defaults to RealClock
+	Clock Clock
+
+	cache lookupCache[string]
+}
+
+func NewRedisLookupProvider(client RedisCacheClient, keyPrefix string, ttl, negativeTTL time.Duration) *RedisLookupProvider {
+	return &RedisLookupProvider{
+		Client:      client,
+		KeyPrefix:   keyPrefix,
+		TTL:         ttl,
+		NegativeTTL: negativeTTL,
+	}
+}
+
+// Lookup returns the value of the key. Missing keys return ErrKeyNotFound,
+// and network failures return a *RetryableError.
+func (p *RedisLookupProvider) Lookup(ctx context.Context, key string) (string, error) {
+	now := clockOrReal(p.Clock).Now()
+	entry, ok := p.cache.get(key, now)
+	recordLookupCacheResult("redis", ok)
+	if ok {
+		if !entry.found {
+			return "", ErrKeyNotFound
+		}
+		return entry.value, nil
+	}
+
+	value, err := p.Client.Get(ctx, p.KeyPrefix+key)
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		p.cache.put(key, lookupCacheEntry[string]{}, now, p.NegativeTTL, p.MaxCacheEntries)
+		return "", ErrKeyNotFound
+	case errors.As(err, &netErr):
+		return "", &RetryableError{Err: err}
+	case err != nil:
+		return "", fmt.Errorf("error in Get: %w", err)
+	}
+
+	p.cache.put(key, lookupCacheEntry[string]{value: string(value), found: true}, now, p.TTL, p.MaxCacheEntries)
+	return string(value), nil
+}
+
+// Invalidate drops the cached value of the key, e.g. after revoking it
+func (p *RedisLookupProvider) Invalidate(key string) {
+	p.cache.invalidate(key)
+}
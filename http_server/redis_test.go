@@ -0,0 +1,176 @@
+package http_server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a map standing in for a wrapped Redis client
+type fakeRedis struct {
+	values map[string][]byte
+	gets   int
+	err    error
+
+	evalScript string
+	evalKeys   []string
+	evalArgs   []string
+	evalReply  any
+}
+
+func (r *fakeRedis) Get(_ context.Context, key string) ([]byte, error) {
+	r.gets++
+	if r.err != nil {
+		return nil, r.err
+	}
+	value, ok := r.values[key]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return value, nil
+}
+
+func (r *fakeRedis) Set(_ context.Context, key string, value []byte, _ time.Duration) error {
+	r.values[key] = value
+	return nil
+}
+
+func (r *fakeRedis) Del(_ context.Context, key string) error {
+	delete(r.values, key)
+	return nil
+}
+
+func (r *fakeRedis) Eval(_ context.Context, script string, keys []string, args ...string) (any, error) {
+	r.evalScript, r.evalKeys, r.evalArgs = script, keys, args
+	return r.evalReply, r.err
+}
+
+func TestRedisCache(t *testing.T) {
+	client := &fakeRedis{values: map[string][]byte{}}
+	cache := &RedisCache{Client: client}
+	ctx := context.Background()
+
+	if err := cache.Set(ctx, "k", []byte("v"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := client.values["iam:cache:k"]; !ok {
+		t.Fatalf("stored %v, want the key prefixed", client.values)
+	}
+	if value, err := cache.Get(ctx, "k"); err != nil || string(value) != "v" {
+		t.Fatalf("got %q, %v", value, err)
+	}
+	if err := cache.Delete(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Get(ctx, "k"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v, want ErrKeyNotFound", err)
+	}
+}
+
+func TestRedisLookupProvider(t *testing.T) {
+	client := &fakeRedis{values: map[string][]byte{"iam:keys:AKID": []byte("secret")}}
+	clock := NewFakeClock(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	p := NewRedisLookupProvider(client, "iam:keys:", time.Minute, time.Second)
+	p.Clock = clock
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if value, err := p.Lookup(ctx, "AKID"); err != nil || value != "secret" {
+			t.Fatalf("got %q, %v", value, err)
+		}
+		if _, err := p.Lookup(ctx, "missing"); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("got %v, want ErrKeyNotFound", err)
+		}
+	}
+	if client.gets != 2 {
+		t.Errorf("Redis was read %d times, want once per key", client.gets)
+	}
+
+	// The missing key is only cached for the NegativeTTL
+	clock.Advance(2 * time.Second)
+	p.Lookup(ctx, "AKID")
+	p.Lookup(ctx, "missing")
+	if client.gets != 3 {
+		t.Errorf("Redis was read %d times, want the missing key read again", client.gets)
+	}
+
+	client.err = &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+	var retryable *RetryableError
+	if _, err := p.Lookup(ctx, "other"); !errors.As(err, &retryable) {
+		t.Errorf("got %v, want a RetryableError", err)
+	}
+}
+
+func TestRedisRateLimitStore(t *testing.T) {
+	client := &fakeRedis{evalReply: []any{int64(0), int64(250)}}
+	store := &RedisRateLimitStore{Client: client}
+
+	taken, retry, err := store.Take(context.Background(), "AKID:s3", RateLimit{Rate: 2, Burst: 5}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if taken || retry != 250*time.Millisecond {
+		t.Errorf("got taken %v, retry %s", taken, retry)
+	}
+	if client.evalScript != redisTokenBucketScript || len(client.evalKeys) != 1 || client.evalKeys[0] != "iam:ratelimit:AKID:s3" {
+		t.Errorf("evaluated keys %v", client.evalKeys)
+	}
+	if len(client.evalArgs) != 2 || client.evalArgs[0] != "2" || client.evalArgs[1] != "5" {
+		t.Errorf("evaluated args %v, want rate and burst", client.evalArgs)
+	}
+
+	client.evalReply = []any{int64(1), int64(0)}
+	if taken, _, _ = store.Take(context.Background(), "AKID:s3", RateLimit{Rate: 2, Burst: 5}, time.Time{}); !taken {
+		t.Error("token not taken")
+	}
+
+	client.evalReply = "OK"
+	if _, _, err = store.Take(context.Background(), "AKID:s3", RateLimit{Rate: 2}, time.Time{}); err == nil {
+		t.Error("unexpected reply accepted")
+	}
+
+	client.evalReply = int64(1)
+	if err = store.Refund(context.Background(), "AKID:s3", RateLimit{Rate: 2, Burst: 5}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if client.evalScript != redisRefundScript || client.evalKeys[0] != "iam:ratelimit:AKID:s3" || client.evalArgs[0] != "5" {
+		t.Errorf("evaluated %v %v, want the refund of the bucket up to its burst", client.evalKeys, client.evalArgs)
+	}
+}
+
+// Under steady traffic no bucket is full, so the least recently used is dropped to stay at MaxBuckets
+func TestMemoryRateLimitStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	store := &MemoryRateLimitStore{MaxBuckets: 3}
+	limit := RateLimit{Rate: 1, Burst: 1}
+	now := time.Now()
+	take := func(bucket string) bool {
+		t.Helper()
+		taken, _, err := store.Take(context.Background(), bucket, limit, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return taken
+	}
+
+	for i := 0; i < 100; i++ {
+		take(fmt.Sprintf("AKID%d:s3", i))
+		if len(store.buckets) > store.MaxBuckets || store.recent.Len() != len(store.buckets) {
+			t.Fatalf("got %d buckets (%d ordered) after %d keys, want at most %d", len(store.buckets), store.recent.Len(), i+1, store.MaxBuckets)
+		}
+	}
+
+	// AKID97 is used again, so AKID98 is the least recently used once AKID100 needs room
+	if take("AKID97:s3") {
+		t.Error("empty bucket AKID97 gave a token")
+	}
+	take("AKID100:s3")
+	if take("AKID97:s3") {
+		t.Error("recently used bucket AKID97 was dropped")
+	}
+	if !take("AKID98:s3") {
+		t.Error("least recently used bucket AKID98 wasn't dropped")
+	}
+}
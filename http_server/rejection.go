@@ -0,0 +1,82 @@
+package http_server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/danthegoodman1/IAMTheService/utils"
+)
+
+// RejectionReason is why a request was rejected, sent to the client in the x-iam-reject-reason header
+type RejectionReason string
+
+const (
+	RejectionMalformedAuth        RejectionReason = "malformed_auth"
+	RejectionMissingSignedHeaders RejectionReason = "missing_signed_headers"
+	RejectionUnknownKey           RejectionReason = "unknown_key"
+	RejectionInvalidSignature     RejectionReason = "invalid_signature"
+	RejectionExpired              RejectionReason = "expired"
+	RejectionClockSkew            RejectionReason = "clock_skew"
+	RejectionReplayed             RejectionReason = "replayed"
+	RejectionPolicyDenied         RejectionReason = "policy_denied"
+	RejectionRateLimited          RejectionReason = "rate_limited"
+	RejectionLoadShed             RejectionReason = "load_shed"
+	RejectionBodyTooLarge         RejectionReason = "body_too_large"
+	RejectionLimitExceeded        RejectionReason = "limit_exceeded"
+	RejectionReadOnly             RejectionReason = "read_only"
+	RejectionUnknownOperation     RejectionReason = "unknown_operation"
+	RejectionPayloadMismatch      RejectionReason = "payload_mismatch"
+	RejectionInvalidToken         RejectionReason = "invalid_token"
+	RejectionPostPolicyCondition  RejectionReason = "post_policy_condition"
+	RejectionUnsupportedSigning   RejectionReason = "unsupported_signing"
+)
+
+// RejectReasonHeader is the response header with the RejectionReason of a rejected request
+const RejectReasonHeader = "x-iam-reject-reason"
+
+var rejectionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "iam_proxy_rejections_total",
+	Help: "Requests rejected by the proxy, by reason",
+}, []string{"reason"})
+
+// RejectionError is an error that rejected the request for Reason
+type RejectionError struct {
+	Reason RejectionReason
+	Err    error
+}
+
+func (e *RejectionError) Error() string {
+	return string(e.Reason) + ": " + e.Err.Error()
+}
+
+func (e *RejectionError) Unwrap() error {
+	return e.Err
+}
+
+// reject attaches the reason to err, which should wrap the *AWSError to render
+func reject(reason RejectionReason, err error) error {
+	return &RejectionError{
+		Reason: reason,
+		Err:    err,
+	}
+}
+
+// rejectionReason gets the reason err rejected the request, if it did
+func rejectionReason(err error) (RejectionReason, bool) {
+	rejection, ok := utils.AsErr[*RejectionError](err)
+	if !ok {
+		return "", false
+	}
+	return rejection.Reason, true
+}
+
+// newRejectionResponse is newAWSErrorResponse for providers rejecting a request rather than returning an error
+func newRejectionResponse(ctx context.Context, reason RejectionReason, protocol AWSProtocol, awsErr *AWSError) *http.Response {
+	rejectionsTotal.WithLabelValues(string(reason)).Inc()
+	res := newAWSErrorResponse(ctx, protocol, awsErr)
+	res.Header.Set(RejectReasonHeader, string(reason))
+	return res
+}
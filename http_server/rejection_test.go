@@ -0,0 +1,215 @@
+package http_server_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/samber/lo"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+// rejectionCount is the iam_proxy_rejections_total of the reason
+func rejectionCount(t *testing.T, reason http_server.RejectionReason) float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "iam_proxy_rejections_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "reason" && label.GetValue() == string(reason) {
+					return metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func TestRejectionReasons(t *testing.T) {
+	signedGet := func(h *iamtest.Harness) *http.Request {
+		return h.NewSignedRequest(http.MethodGet, "/bucket/key", nil)
+	}
+	tests := []struct {
+		reason     http_server.RejectionReason
+		newHarness func(t *testing.T) *iamtest.Harness
+		setup      func(h *iamtest.Harness)
+		newRequest func(h *iamtest.Harness) *http.Request
+		// repeat sends the same request again, rejecting the last one
+		repeat int
+	}{
+		{
+			reason: http_server.RejectionMalformedAuth,
+			newRequest: func(h *iamtest.Harness) *http.Request {
+				r, _ := http.NewRequest(http.MethodGet, h.Server.URL+"/bucket/key", nil)
+				r.Header.Set("Authorization", "AWS4-HMAC-SHA256 garbage")
+				return r
+			},
+		},
+		{
+			reason: http_server.RejectionUnknownKey,
+			newRequest: func(h *iamtest.Harness) *http.Request {
+				r, _ := http.NewRequest(http.MethodGet, h.Server.URL+"/bucket/key", nil)
+				http_server.SignRequest(r, "AKIAUNKNOWN", iamtest.KeySecret, iamtest.Region, "s3", time.Now())
+				return r
+			},
+		},
+		{
+			reason: http_server.RejectionInvalidSignature,
+			newRequest: func(h *iamtest.Harness) *http.Request {
+				r, _ := http.NewRequest(http.MethodGet, h.Server.URL+"/bucket/key", nil)
+				http_server.SignRequest(r, iamtest.KeyID, "not_the_secret", iamtest.Region, "s3", time.Now())
+				return r
+			},
+		},
+		{
+			reason: http_server.RejectionExpired,
+			newRequest: func(h *iamtest.Harness) *http.Request {
+				r, _ := http.NewRequest(http.MethodGet, h.Server.URL+"/bucket/key?X-Amz-Algorithm=AWS4-HMAC-SHA256"+
+					"&X-Amz-Credential="+iamtest.KeyID+"%2F20240501%2Fus-east-1%2Fs3%2Faws4_request&X-Amz-Date=20240501T120000Z"+
+					"&X-Amz-Expires=60&X-Amz-SignedHeaders=host&X-Amz-Signature=abc", nil)
+				return r
+			},
+		},
+		{
+			reason: http_server.RejectionClockSkew,
+			newRequest: func(h *iamtest.Harness) *http.Request {
+				r, _ := http.NewRequest(http.MethodGet, h.Server.URL+"/bucket/key", nil)
+				http_server.SignRequest(r, iamtest.KeyID, iamtest.KeySecret, iamtest.Region, "s3", time.Now().Add(-time.Hour))
+				return r
+			},
+		},
+		{
+			reason: http_server.RejectionMissingSignedHeaders,
+			setup: func(h *iamtest.Harness) {
+				h.Proxy.MandatorySignedHeaders = map[string][]string{"s3": {"host", "content-md5"}}
+			},
+			newRequest: signedGet,
+		},
+		{
+			reason: http_server.RejectionLimitExceeded,
+			setup: func(h *iamtest.Harness) {
+				h.Proxy.Limits = &http_server.RequestLimits{MaxQueryParams: 1}
+			},
+			newRequest: func(h *iamtest.Harness) *http.Request {
+				return h.NewSignedRequest(http.MethodGet, "/bucket/key?a=1&b=2", nil)
+			},
+		},
+		{
+			reason: http_server.RejectionPolicyDenied,
+			setup: func(h *iamtest.Harness) {
+				h.Proxy.PolicyLookupFunc = http_server.StaticPolicies(map[string][]http_server.PolicyDocument{
+					iamtest.KeyID: {{Statement: []http_server.PolicyStatement{
+						{Effect: http_server.PolicyAllow, Action: http_server.PolicyStringList{"s3:*"}, Resource: http_server.PolicyStringList{"arn:aws:s3:::other/*"}},
+					}}},
+				})
+			},
+			newRequest: signedGet,
+		},
+		{
+			reason: http_server.RejectionReadOnly,
+			setup: func(h *iamtest.Harness) {
+				h.Proxy.ReadOnly = &http_server.ReadOnlyMode{}
+				h.Proxy.ReadOnly.Set(true)
+			},
+			newRequest: func(h *iamtest.Harness) *http.Request {
+				return h.NewSignedRequest(http.MethodPut, "/bucket/key", []byte("body"))
+			},
+		},
+		{
+			reason: http_server.RejectionRateLimited,
+			setup: func(h *iamtest.Harness) {
+				h.Proxy.RateLimitLookupFunc = http_server.StaticRateLimits(map[string][]http_server.RateLimitRule{
+					iamtest.KeyID: {{RateLimit: http_server.RateLimit{Rate: 0.001, Burst: 1}}},
+				})
+			},
+			newRequest: signedGet,
+			repeat:     1,
+		},
+		{
+			reason: http_server.RejectionReplayed,
+			setup: func(h *iamtest.Harness) {
+				h.Proxy.ReplayProtection = &http_server.ReplayProtection{}
+			},
+			newRequest: signedGet,
+			repeat:     1,
+		},
+		{
+			reason: http_server.RejectionPayloadMismatch,
+			setup: func(h *iamtest.Harness) {
+				h.Proxy.PayloadVerification = &http_server.PayloadVerification{}
+			},
+			newRequest: func(h *iamtest.Harness) *http.Request {
+				return newPayloadUpload(h, sha256Hex([]byte("the signed body")), []byte("a tampered body"))
+			},
+		},
+		{
+			reason: http_server.RejectionUnknownOperation,
+			newHarness: func(t *testing.T) *iamtest.Harness {
+				h := iamtest.NewHarness(func(originURL string) http_server.AWSServiceProvider {
+					p := http_server.NewSQSProvider()
+					p.OriginHost = originURL
+					p.StrictOperations = true
+					return p
+				})
+				t.Cleanup(h.Close)
+				return h
+			},
+			newRequest: func(h *iamtest.Harness) *http.Request {
+				r := h.NewSignedRequest(http.MethodPost, "/", []byte("Action=Publish"))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return r
+			},
+		},
+		{
+			reason: http_server.RejectionBodyTooLarge,
+			newHarness: func(t *testing.T) *iamtest.Harness {
+				return newDynamoDBHarness(t, 16)
+			},
+			newRequest: func(h *iamtest.Harness) *http.Request {
+				r := h.NewSignedRequest(http.MethodPost, "/", []byte(`{"TableName":"users","Item":{"bio":{"S":"`+strings.Repeat("x", 32)+`"}}}`))
+				r.Header.Set("X-Amz-Target", "DynamoDB_20120810.PutItem")
+				r.Header.Set("Content-Type", "application/x-amz-json-1.0")
+				return r
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.reason), func(t *testing.T) {
+			h := lo.Ternary(tt.newHarness == nil, newS3Harness, tt.newHarness)(t)
+			if tt.setup != nil {
+				tt.setup(h)
+			}
+
+			r := tt.newRequest(h)
+			for i := 0; i <= tt.repeat; i++ {
+				before := rejectionCount(t, tt.reason)
+				res, err := h.Do(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				io.Copy(io.Discard, res.Body)
+				res.Body.Close()
+				if i < tt.repeat {
+					continue
+				}
+				if got := res.Header.Get(http_server.RejectReasonHeader); got != string(tt.reason) {
+					t.Errorf("got status %d and rejection reason %q", res.StatusCode, got)
+				}
+				if got := rejectionCount(t, tt.reason) - before; got != 1 {
+					t.Errorf("counted %g rejections with the reason label", got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package http_server
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+// DefaultReplayWindow is how long AWS accepts a signed request after its X-Amz-Date
+const DefaultReplayWindow = 15 * time.Minute
+
+// ReplayStore records request fingerprints. Use a shared store (e.g. RedisReplayStore) so replays
+// are caught across a fleet.
+type ReplayStore interface {
+	// MarkSeen records the fingerprint for ttl, returning true if it was already recorded
+	MarkSeen(ctx context.Context, fingerprint string, ttl time.Duration) (seen bool, err error)
+}
+
+// ReplayProtection rejects signed requests that have already been seen within the skew window,
+// so a captured request can't be replayed. Presigned URLs are remembered until they expire
+// (X-Amz-Date plus X-Amz-Expires), so each can only be used once.
+type ReplayProtection struct {
+	// Store defaults to an in-memory store
+	Store ReplayStore
+	// Window defaults to DefaultReplayWindow, it should be at least the AWSProxy.MaxClockSkew
+	Window time.Duration
+	// Operations to protect (e.g. "DeleteObject"), empty protects every operation
+	Operations []string
+
+	defaultStore sync.Once
+}
+
+func (p *ReplayProtection) check(ctx context.Context, provider AWSServiceProvider, request *ProxiedRequest, now time.Time) error {
+	if len(p.Operations) > 0 && !lo.Contains(p.Operations, extractOperationName(provider, request)) {
+		return nil
+	}
+
+	p.defaultStore.Do(func() {
+		if p.Store == nil {
+			p.Store = NewMemoryReplayStore()
+		}
+	})
+	window := p.Window
+	if window == 0 {
+		window = DefaultReplayWindow
+	}
+
+	// A request is accepted until its X-Amz-Date is a window old, which is later than a window from now
+	// if the client's clock is ahead. Presigned URLs are accepted until they expire instead.
+	ttl := window
+	if signedAt, err := time.Parse("20060102T150405Z", amzDate(request.Request)); err == nil {
+		validFor := window
+		if isPresignedRequest(request.Request) {
+			if expires, err := strconv.Atoi(request.Request.URL.Query().Get("X-Amz-Expires")); err == nil {
+				validFor = time.Duration(expires) * time.Second
+			}
+		}
+		ttl = max(window, signedAt.Add(validFor).Sub(now))
+	}
+
+	fingerprint := sha256.Sum256([]byte(request.KeyID + "\n" + amzDate(request.Request) + "\n" + request.parsedHeader.Signature))
+	seen, err := p.Store.MarkSeen(ctx, hex.EncodeToString(fingerprint[:]), ttl)
+	if err != nil {
+		return fmt.Errorf("error in ReplayStore.MarkSeen: %w", err)
+	}
+	if seen {
+		return ErrAWSRequestReplayed
+	}
+	return nil
+}
+
+// MemoryReplayStore is a ReplayStore for a single instance
+type MemoryReplayStore struct {
+	// Clock defaults to RealClock
+	Clock Clock
+	// MaxEntries bounds memory by evicting the least recently seen fingerprints (which could then be replayed),
+	// 0 is unbounded
+	MaxEntries int
+
+	mu        sync.Mutex
+	seen      map[string]*list.Element
+	order     *list.List
+	lastSweep time.Time
+}
+
+type replayEntry struct {
+	fingerprint string
+	expires     time.Time
+}
+
+func NewMemoryReplayStore() *MemoryReplayStore {
+	return &MemoryReplayStore{
+		seen:  map[string]*list.Element{},
+		order: list.New(),
+	}
+}
+
+func (s *MemoryReplayStore) MarkSeen(_ context.Context, fingerprint string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := clockOrReal(s.Clock).Now()
+	if now.Sub(s.lastSweep) > time.Minute {
+		for key, elem := range s.seen {
+			if now.After(elem.Value.(*replayEntry).expires) {
+				s.order.Remove(elem)
+				delete(s.seen, key)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	if elem, ok := s.seen[fingerprint]; ok {
+		entry := elem.Value.(*replayEntry)
+		s.order.MoveToFront(elem)
+		if now.Before(entry.expires) {
+			return true, nil
+		}
+		entry.expires = now.Add(ttl)
+		return false, nil
+	}
+
+	s.seen[fingerprint] = s.order.PushFront(&replayEntry{fingerprint: fingerprint, expires: now.Add(ttl)})
+	if s.MaxEntries > 0 && s.order.Len() > s.MaxEntries {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.seen, oldest.Value.(*replayEntry).fingerprint)
+	}
+	return false, nil
+}
+
+// RedisSetNXClient is the subset of a Redis client needed by RedisReplayStore,
+// wrap your client of choice (e.g. go-redis's SetNX(...).Result()) to satisfy it
+type RedisSetNXClient interface {
+	// SetNX sets key to value with a ttl if it doesn't exist, returning whether it was set
+	SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+}
+
+// RedisReplayStore is a ReplayStore shared across instances through Redis
+type RedisReplayStore struct {
+	Client RedisSetNXClient
+	// KeyPrefix namespaces the fingerprints, defaults to "iam:replay:"
+	KeyPrefix string
+}
+
+func (s *RedisReplayStore) MarkSeen(ctx context.Context, fingerprint string, ttl time.Duration) (bool, error) {
+	prefix := lo.Ternary(s.KeyPrefix == "", "iam:replay:", s.KeyPrefix)
+	set, err := s.Client.SetNX(ctx, prefix+fingerprint, "1", ttl)
+	if err != nil {
+		return false, fmt.Errorf("error in SetNX: %w", err)
+	}
+	return !set, nil
+}
@@ -0,0 +1,98 @@
+package http_server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// ttlReplayStore records the ttl fingerprints are marked with
+type ttlReplayStore struct {
+	*MemoryReplayStore
+	ttl time.Duration
+}
+
+func (s *ttlReplayStore) MarkSeen(ctx context.Context, fingerprint string, ttl time.Duration) (bool, error) {
+	s.ttl = ttl
+	return s.MemoryReplayStore.MarkSeen(ctx, fingerprint, ttl)
+}
+
+func TestReplayProtection(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		target string
+		date   string
+		ttl    time.Duration
+	}{
+		{
+			name:   "header signed",
+			target: "/bucket/key",
+			date:   "20240501T120000Z",
+			ttl:    DefaultReplayWindow,
+		},
+		{
+			name:   "header signed by a clock ahead",
+			target: "/bucket/key",
+			date:   "20240501T120500Z",
+			ttl:    DefaultReplayWindow + 5*time.Minute,
+		},
+		{
+			name:   "presigned for a week",
+			target: "/bucket/key?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Date=20240501T110000Z&X-Amz-Expires=604800&X-Amz-Signature=abc",
+			ttl:    7*24*time.Hour - time.Hour,
+		},
+		{
+			name:   "presigned for less than the window",
+			target: "/bucket/key?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Date=20240501T120000Z&X-Amz-Expires=60&X-Amz-Signature=abc",
+			ttl:    DefaultReplayWindow,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewFakeClock(now)
+			store := &ttlReplayStore{MemoryReplayStore: NewMemoryReplayStore()}
+			store.Clock = clock
+			protection := &ReplayProtection{Store: store}
+
+			r := httptest.NewRequest(http.MethodGet, "http://s3.amazonaws.com"+tt.target, nil)
+			if tt.date != "" {
+				r.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=AKID/20240501/us-east-1/s3/aws4_request, SignedHeaders=host;x-amz-date, Signature=abc")
+				r.Header.Set("X-Amz-Date", tt.date)
+			}
+			request := &ProxiedRequest{Request: r, KeyID: "AKID", parsedHeader: AWSAuthHeader{Signature: "abc"}}
+
+			if err := protection.check(context.Background(), NewS3Provider(), request, now); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if store.ttl != tt.ttl {
+				t.Errorf("marked seen for %s, want %s", store.ttl, tt.ttl)
+			}
+
+			// Replays are rejected until the fingerprint expires
+			clock.Advance(tt.ttl - time.Second)
+			if err := protection.check(context.Background(), NewS3Provider(), request, clock.Now()); !errors.Is(err, ErrAWSRequestReplayed) {
+				t.Fatalf("got %v, want ErrAWSRequestReplayed", err)
+			}
+			clock.Advance(2 * time.Second)
+			if err := protection.check(context.Background(), NewS3Provider(), request, clock.Now()); err != nil {
+				t.Fatalf("unexpected error %v once expired", err)
+			}
+		})
+	}
+}
+
+func TestReplayProtectionOperations(t *testing.T) {
+	protection := &ReplayProtection{Operations: []string{"DeleteObject"}}
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest(http.MethodGet, "http://s3.amazonaws.com/bucket/key", nil)
+		r.Header.Set("X-Amz-Date", "20240501T120000Z")
+		request := &ProxiedRequest{Request: r, KeyID: "AKID", parsedHeader: AWSAuthHeader{Signature: "abc"}}
+		if err := protection.check(context.Background(), NewS3Provider(), request, time.Now()); err != nil {
+			t.Fatalf("unexpected error %v for an unprotected operation", err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package http_server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrTooManySignedHeaders = errors.New("too many signed headers")
+	ErrHeadersTooLarge      = errors.New("headers too large")
+	ErrTooManyQueryParams   = errors.New("too many query parameters")
+)
+
+var ErrAWSRequestLimitExceeded = NewAWSError(http.StatusBadRequest, "InvalidRequest", "The request exceeds the limits of the proxy.")
+
+// RequestLimits bounds the work verifying a request can cause, checked before canonicalization.
+// A zero limit is unlimited.
+type RequestLimits struct {
+	MaxSignedHeaders int
+	// MaxHeaderBytes is the total size of the header names and values
+	MaxHeaderBytes int
+	MaxQueryParams int
+}
+
+// DefaultRequestLimits are generous compared to what SDKs send
+var DefaultRequestLimits = RequestLimits{
+	MaxSignedHeaders: 64,
+	MaxHeaderBytes:   64 * 1024,
+	MaxQueryParams:   256,
+}
+
+// checkRequest checks the headers and query of the request
+func (l *RequestLimits) checkRequest(r *http.Request) error {
+	if l.MaxHeaderBytes > 0 {
+		size := 0
+		for name, values := range r.Header {
+			for _, value := range values {
+				size += len(name) + len(value)
+			}
+		}
+		if size > l.MaxHeaderBytes {
+			return fmt.Errorf("%w: %d bytes is over %d", ErrHeadersTooLarge, size, l.MaxHeaderBytes)
+		}
+	}
+	if l.MaxQueryParams > 0 && r.URL.RawQuery != "" {
+		// Counted without parsing, the limit is meant to avoid that work
+		if params := strings.Count(r.URL.RawQuery, "&") + 1; params > l.MaxQueryParams {
+			return fmt.Errorf("%w: %d is over %d", ErrTooManyQueryParams, params, l.MaxQueryParams)
+		}
+	}
+	return nil
+}
+
+// checkSignedHeaders checks the signed headers of the parsed auth
+func (l *RequestLimits) checkSignedHeaders(parsedHeader AWSAuthHeader) error {
+	if l.MaxSignedHeaders > 0 && len(parsedHeader.SignedHeaders) > l.MaxSignedHeaders {
+		return fmt.Errorf("%w: %d is over %d", ErrTooManySignedHeaders, len(parsedHeader.SignedHeaders), l.MaxSignedHeaders)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package http_server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestLimitsAtTheLimit(t *testing.T) {
+	// withHeaderBytes is a request whose header names and values total n bytes
+	withHeaderBytes := func(n int) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/bucket/key", nil)
+		r.Header = http.Header{"X-Pad": {strings.Repeat("x", n-len("X-Pad"))}}
+		return r
+	}
+	withQueryParams := func(n int) *http.Request {
+		params := make([]string, n)
+		for i := range params {
+			params[i] = "p=1"
+		}
+		return httptest.NewRequest(http.MethodGet, "/bucket?"+strings.Join(params, "&"), nil)
+	}
+	withSignedHeaders := func(n int) AWSAuthHeader {
+		return AWSAuthHeader{SignedHeaders: make([]string, n)}
+	}
+
+	limits := &RequestLimits{MaxSignedHeaders: 3, MaxHeaderBytes: 100, MaxQueryParams: 4}
+	tests := []struct {
+		name    string
+		check   func() error
+		wantErr error
+	}{
+		{name: "header bytes at the limit", check: func() error { return limits.checkRequest(withHeaderBytes(100)) }},
+		{name: "header bytes over the limit", check: func() error { return limits.checkRequest(withHeaderBytes(101)) }, wantErr: ErrHeadersTooLarge},
+		{name: "query params at the limit", check: func() error { return limits.checkRequest(withQueryParams(4)) }},
+		{name: "query params over the limit", check: func() error { return limits.checkRequest(withQueryParams(5)) }, wantErr: ErrTooManyQueryParams},
+		{name: "signed headers at the limit", check: func() error { return limits.checkSignedHeaders(withSignedHeaders(3)) }},
+		{name: "signed headers over the limit", check: func() error { return limits.checkSignedHeaders(withSignedHeaders(4)) }, wantErr: ErrTooManySignedHeaders},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.check(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package http_server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+const (
+	// emptyPayloadHash is the SHA256 of an empty body
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	// maxRehashPayloadBytes is the largest body whose declared hash is recomputed before re-signing,
+	// larger bodies keep the hash the client declared rather than being buffered
+	maxRehashPayloadBytes = 1024 * 1024
+	// maxHashedPayloadBytes bounds the bodies buffered because services other than S3 need their hash
+	maxHashedPayloadBytes = 64 * 1024 * 1024
+)
+
+// unsignedHeaders are never signed, the way the AWS SDK signer ignores them, because proxies and the
+// transport can change them
+var unsignedHeaders = []string{
+	"authorization", "user-agent", "x-amzn-trace-id", "expect", "transfer-encoding",
+	"connection", "keep-alive", "proxy-authorization", "proxy-connection", "te", "trailer", "upgrade",
+}
+
+// SigningCredentials are the credentials ResignRequest signs with
+type SigningCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is sent as X-Amz-Security-Token for temporary credentials
+	SessionToken string
+}
+
+// ResignRequest signs r with SigV4 for the host of its URL, replacing any previous signature, the way the
+// AWS SDK signer does: X-Amz-Date is set to now, and every header but the hop-by-hop ones (and others
+// proxies change, like User-Agent) is signed. The payload hash is recomputed if the body can be re-read
+// (GetBody) or is small, so a changed body never carries a stale x-amz-content-sha256.
+func ResignRequest(r *http.Request, creds SigningCredentials, region, service string, now time.Time) error {
+	r.Host = r.URL.Host
+	r.Header.Del("Host")
+	r.Header.Del("Authorization")
+	if creds.SessionToken != "" {
+		r.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	} else {
+		r.Header.Del("X-Amz-Security-Token")
+	}
+
+	header := AWSAuthHeader{
+		Algorithm: AlgorithmSigV4,
+		Credential: AWSAuthHeaderCredential{
+			KeyID:   creds.AccessKeyID,
+			Region:  region,
+			Service: service,
+			Request: "aws4_request",
+		},
+	}
+	if _, err := signOutbound(r, header, headersToSign(r), creds.SecretAccessKey, now, true); err != nil {
+		return fmt.Errorf("error in signOutbound: %w", err)
+	}
+	return nil
+}
+
+// headersToSign is host and every header of r that isn't in unsignedHeaders
+func headersToSign(r *http.Request) []string {
+	headers := []string{"host"}
+	for name := range r.Header {
+		name = strings.ToLower(name)
+		if !lo.Contains(unsignedHeaders, name) {
+			headers = append(headers, name)
+		}
+	}
+	return headers
+}
+
+// signOutbound dates the request now, sets the payload hash (see outboundPayloadHash), and signs
+// signedHeaders (plus those two) with header's algorithm and scope, setting the Authorization header.
+// The header it signed with is returned, e.g. to chain the chunk signatures of streaming uploads.
+func signOutbound(r *http.Request, header AWSAuthHeader, signedHeaders []string, keySecret string, now time.Time, rehash bool) (AWSAuthHeader, error) {
+	date := now.UTC().Format("20060102T150405Z")
+	r.Header.Set("X-Amz-Date", date)
+	header.Credential.Date = date[:8]
+
+	payloadHash, err := outboundPayloadHash(r, header.Credential.Service, rehash)
+	if err != nil {
+		return header, fmt.Errorf("error in outboundPayloadHash: %w", err)
+	}
+	r.Header.Set("x-amz-content-sha256", payloadHash)
+
+	header.SignedHeaders = lo.Uniq(lo.Map(append(signedHeaders, "x-amz-date", "x-amz-content-sha256"), func(name string, _ int) string {
+		return strings.ToLower(name)
+	}))
+	sort.Strings(header.SignedHeaders)
+	if header.Signature, err = signRequestSignature(r, header, keySecret); err != nil {
+		return header, fmt.Errorf("error in signRequestSignature: %w", err)
+	}
+	r.Header.Set("Authorization", header.String())
+	return header, nil
+}
+
+// outboundPayloadHash returns the payload hash to sign r with. Streaming and UNSIGNED-PAYLOAD markers are
+// kept. Otherwise the body is hashed if it can be re-read or is small, and also if no hash was declared,
+// since only S3 accepts UNSIGNED-PAYLOAD. Hashed bodies are buffered. Small bodies of requests expecting
+// 100-continue aren't hashed, since reading them would continue the client before the origin accepted the request.
+//
+// Unless rehash, the hash of the body must be the declared one: proxied requests are signed with the hash the
+// client signed (or SetBody declared), so a body changed after signing is rejected with ErrPayloadHashMismatch
+// rather than signed afresh. Bodies that aren't hashed are left for the origin to check against the declared hash.
+func outboundPayloadHash(r *http.Request, service string, rehash bool) (string, error) {
+	declared := r.Header.Get("x-amz-content-sha256")
+	if declared == "UNSIGNED-PAYLOAD" || strings.HasPrefix(declared, "STREAMING-") {
+		return declared, nil
+	}
+	payloadHash, err := hashOutboundBody(r, service, declared)
+	if err != nil {
+		return "", err
+	}
+	if !rehash && declared != "" && !strings.EqualFold(payloadHash, declared) {
+		return "", fmt.Errorf("%w: %w", ErrAWSContentSHA256Mismatch, ErrPayloadHashMismatch)
+	}
+	return payloadHash, nil
+}
+
+// hashOutboundBody is the hash of the body of r if it is hashed (see outboundPayloadHash), otherwise declared
+// or UNSIGNED-PAYLOAD
+func hashOutboundBody(r *http.Request, service, declared string) (string, error) {
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return emptyPayloadHash, nil
+	}
+
+	if r.GetBody != nil {
+		body, err := r.GetBody()
+		if err != nil {
+			return "", fmt.Errorf("error in GetBody: %w", err)
+		}
+		defer body.Close()
+		hash := sha256.New()
+		if _, err = io.Copy(hash, body); err != nil {
+			return "", fmt.Errorf("error hashing body: %w", err)
+		}
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	}
+
+	expectContinue := strings.EqualFold(r.Header.Get("Expect"), "100-continue")
+	small := r.ContentLength > 0 && r.ContentLength <= maxRehashPayloadBytes && !expectContinue
+	switch {
+	case small:
+	case declared != "":
+		return declared, nil
+	case service == "s3":
+		return "UNSIGNED-PAYLOAD", nil
+	}
+
+	body, err := bufferBody(r)
+	if err != nil {
+		return "", fmt.Errorf("error in bufferBody: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// bufferBody reads the (bounded) body of r into memory, leaving it re-readable through GetBody
+func bufferBody(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxHashedPayloadBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading body: %w", err)
+	}
+	if len(body) > maxHashedPayloadBytes {
+		return nil, fmt.Errorf("body exceeds %d bytes to hash", maxHashedPayloadBytes)
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	r.ContentLength = int64(len(body))
+	return body, nil
+}
@@ -0,0 +1,147 @@
+package http_server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// unreadBody fails the test if the body is read
+type unreadBody struct {
+	t *testing.T
+}
+
+func (b unreadBody) Read([]byte) (int, error) {
+	b.t.Error("body was read")
+	return 0, io.EOF
+}
+
+func (unreadBody) Close() error {
+	return nil
+}
+
+func TestOutboundPayloadHash(t *testing.T) {
+	const body = "hello"
+	sum := sha256.Sum256([]byte(body))
+	bodyHash := hex.EncodeToString(sum[:])
+	staleHash := strings.Repeat("0", 64)
+
+	newRequest := func(declared string, expectContinue bool, body io.ReadCloser) *http.Request {
+		r, _ := http.NewRequest(http.MethodPut, "https://s3.amazonaws.com/bucket/key", nil)
+		r.Body = body
+		r.ContentLength = 5
+		if declared != "" {
+			r.Header.Set("x-amz-content-sha256", declared)
+		}
+		if expectContinue {
+			r.Header.Set("Expect", "100-continue")
+		}
+		return r
+	}
+
+	t.Run("small body is re-hashed", func(t *testing.T) {
+		r := newRequest(staleHash, false, io.NopCloser(strings.NewReader(body)))
+		hash, err := outboundPayloadHash(r, "s3", true)
+		if err != nil || hash != bodyHash {
+			t.Fatalf("got %q, %v, want the hash of the body", hash, err)
+		}
+		// The buffered body is still sent
+		if b, _ := io.ReadAll(r.Body); string(b) != body {
+			t.Errorf("got body %q", b)
+		}
+	})
+
+	t.Run("small body must match the declared hash unless re-hashed", func(t *testing.T) {
+		r := newRequest(staleHash, false, io.NopCloser(strings.NewReader(body)))
+		if _, err := outboundPayloadHash(r, "s3", false); !errors.Is(err, ErrPayloadHashMismatch) {
+			t.Fatalf("got %v, want ErrPayloadHashMismatch", err)
+		}
+		r = newRequest(strings.ToUpper(bodyHash), false, io.NopCloser(strings.NewReader(body)))
+		if hash, err := outboundPayloadHash(r, "s3", false); err != nil || !strings.EqualFold(hash, bodyHash) {
+			t.Fatalf("got %q, %v, want the declared hash", hash, err)
+		}
+	})
+
+	t.Run("100-continue keeps the declared hash", func(t *testing.T) {
+		r := newRequest(bodyHash, true, unreadBody{t})
+		if hash, err := outboundPayloadHash(r, "dynamodb", false); err != nil || hash != bodyHash {
+			t.Fatalf("got %q, %v, want the declared hash", hash, err)
+		}
+	})
+
+	t.Run("100-continue without a hash is unsigned for S3", func(t *testing.T) {
+		r := newRequest("", true, unreadBody{t})
+		if hash, err := outboundPayloadHash(r, "s3", false); err != nil || hash != "UNSIGNED-PAYLOAD" {
+			t.Fatalf("got %q, %v, want UNSIGNED-PAYLOAD", hash, err)
+		}
+	})
+
+	t.Run("unsigned and streaming markers are kept", func(t *testing.T) {
+		for _, declared := range []string{"UNSIGNED-PAYLOAD", streamingSignedPayload} {
+			r := newRequest(declared, false, unreadBody{t})
+			if hash, err := outboundPayloadHash(r, "s3", false); err != nil || hash != declared {
+				t.Errorf("got %q, %v, want %q", hash, err, declared)
+			}
+		}
+	})
+}
+
+func TestAddOutboundHeaderIsSigned(t *testing.T) {
+	var verifyErr error
+	var tenant string
+	var signedHeaders []string
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant = r.Header.Get("X-Tenant-Id")
+		parsedHeader := parseAuthHeader(r.Header.Get("Authorization"))
+		signedHeaders = parsedHeader.SignedHeaders
+		verifyErr = verifyRequestSignature(r, parsedHeader, "secret")
+	}))
+	defer origin.Close()
+
+	provider := NewS3Provider()
+	provider.OriginHost = origin.URL
+	provider.Use(func(next OperationHandler) OperationHandler {
+		return func(ctx context.Context, request *ProxiedRequest) (*http.Response, error) {
+			request.AddOutboundHeader("X-Tenant-Id", "tenant-1", true)
+			return next(ctx, request)
+		}
+	})
+	proxy := httptest.NewServer(&AWSProxy{
+		KeyLookupFunc: func(context.Context, string) (string, error) {
+			return "secret", nil
+		},
+		ServiceLookupFunc: func(context.Context, string) (AWSServiceProvider, error) {
+			return provider, nil
+		},
+	})
+	defer proxy.Close()
+
+	r, _ := http.NewRequest(http.MethodGet, proxy.URL+"/bucket/key", nil)
+	SignRequest(r, "AKIDEXAMPLE", "secret", "us-east-1", "s3", time.Now())
+	res, err := proxy.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d", res.StatusCode)
+	}
+
+	if tenant != "tenant-1" {
+		t.Errorf("origin received X-Tenant-Id %q", tenant)
+	}
+	if !slices.Contains(signedHeaders, "x-tenant-id") {
+		t.Errorf("got signed headers %v, want x-tenant-id", signedHeaders)
+	}
+	if verifyErr != nil {
+		t.Errorf("outbound signature doesn't verify: %v", verifyErr)
+	}
+}
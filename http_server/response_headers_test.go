@@ -0,0 +1,43 @@
+package http_server_test
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestResponseHeaderFiltering(t *testing.T) {
+	h := newS3Harness(t)
+	h.Origin.RespondWith(http.StatusOK, http.Header{
+		"Server":           {"AmazonS3"},
+		"X-Amz-Id-2":       {"origin-host-id"},
+		"X-Amz-Request-Id": {"ORIGINREQUESTID"},
+		"Etag":             {`"etag"`},
+		"Connection":       {"X-Origin-Hop"},
+		"X-Origin-Hop":     {"origin-connection-detail"},
+		"Keep-Alive":       {"timeout=5"},
+	}, []byte("body"))
+	h.Proxy.StripResponseHeaders = []string{"Server", "x-amz-id-2"}
+	h.Proxy.RewriteResponseHeaders = map[string]string{
+		"x-amz-request-id": "PROXYREQUESTID",
+		// Only headers the origin sent are rewritten
+		"x-amz-version-id": "null",
+	}
+
+	res, err := h.Do(h.NewSignedRequest(http.MethodGet, "/bucket/key", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	for _, header := range []string{"Server", "X-Amz-Id-2", "X-Origin-Hop", "Keep-Alive", "X-Amz-Version-Id"} {
+		if val := res.Header.Get(header); val != "" {
+			t.Errorf("got %s %q, want it absent", header, val)
+		}
+	}
+	if got := res.Header.Get("x-amz-request-id"); got != "PROXYREQUESTID" {
+		t.Errorf("got x-amz-request-id %q, want it rewritten", got)
+	}
+	if got := res.Header.Get("ETag"); got != `"etag"` {
+		t.Errorf("got ETag %q, want it forwarded", got)
+	}
+}
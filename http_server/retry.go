@@ -0,0 +1,179 @@
+package http_server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+// Backoff decides how long to wait before retrying a request to the origin
+type Backoff interface {
+	// NextDelay is the delay before retry attempt (1 for the first retry). resp is the response
+	// that is being retried, or nil if the origin couldn't be reached.
+	NextDelay(attempt int, resp *http.Response) time.Duration
+}
+
+// exponentialCap is min(max, base * 2^attempt)
+func exponentialCap(base, max time.Duration, attempt int) time.Duration {
+	if attempt > 30 {
+		return max
+	}
+	return min(max, base<<attempt)
+}
+
+// FullJitterBackoff waits a random delay up to the exponential backoff, what the AWS SDKs do.
+// See https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+type FullJitterBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+func (b FullJitterBackoff) NextDelay(attempt int, _ *http.Response) time.Duration {
+	return time.Duration(rand.Int64N(int64(exponentialCap(b.Base, b.Max, attempt)) + 1))
+}
+
+// EqualJitterBackoff waits at least half of the exponential backoff, plus a random delay up to the other half
+type EqualJitterBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+func (b EqualJitterBackoff) NextDelay(attempt int, _ *http.Response) time.Duration {
+	half := exponentialCap(b.Base, b.Max, attempt) / 2
+	return half + time.Duration(rand.Int64N(int64(half)+1))
+}
+
+// DecorrelatedJitterBackoff waits a random delay between Base and three times the previous delay. Backoffs are
+// shared across requests, so the previous delay is taken to be the upper bound of the last attempt.
+type DecorrelatedJitterBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+func (b DecorrelatedJitterBackoff) NextDelay(attempt int, _ *http.Response) time.Duration {
+	upper := b.Base
+	for i := 1; i < attempt && upper < b.Max; i++ {
+		upper *= 3
+	}
+	upper = min(b.Max, upper*3)
+	if upper <= b.Base {
+		return b.Base
+	}
+	return b.Base + time.Duration(rand.Int64N(int64(upper-b.Base)+1))
+}
+
+var (
+	// DefaultBackoff follows the AWS SDK standard retry mode
+	DefaultBackoff Backoff = FullJitterBackoff{Base: 100 * time.Millisecond, Max: 20 * time.Second}
+	// DefaultRetryStatuses are throttling and transient origin errors
+	DefaultRetryStatuses = []int{http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+)
+
+// maxRetryBodyBytes is the default RetryPolicy.MaxBodyBytes
+const maxRetryBodyBytes = 1024 * 1024
+
+// RetryPolicy retries origin requests that fail to connect or respond with a retryable status.
+// Retry-After on throttling responses is honored, if it asks for a longer delay than the Backoff.
+// A request that failed to get a response may have reached the origin, so only use it for origins
+// where retrying every operation is safe.
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt, defaults to 3
+	MaxAttempts int
+	// Backoff defaults to DefaultBackoff
+	Backoff Backoff
+	// Statuses that are retried, defaults to DefaultRetryStatuses
+	Statuses []int
+	// MaxRetryAfter caps the delay a Retry-After header can ask for, defaults to 20s
+	MaxRetryAfter time.Duration
+	// MaxBodyBytes is the largest request body buffered so it can be resent, defaults to 1MiB.
+	// Requests with larger (or unknown length) bodies are not retried.
+	MaxBodyBytes int64
+}
+
+// delay is the wait before retry attempt, now dates a Retry-After given as an HTTP date
+func (p *RetryPolicy) delay(attempt int, res *http.Response, now time.Time) time.Duration {
+	backoff := lo.Ternary(p.Backoff != nil, p.Backoff, DefaultBackoff)
+	delay := backoff.NextDelay(attempt, res)
+	if retryAfter, ok := parseRetryAfter(res, now); ok {
+		maxRetryAfter := lo.Ternary(p.MaxRetryAfter > 0, p.MaxRetryAfter, 20*time.Second)
+		delay = max(delay, min(retryAfter, maxRetryAfter))
+	}
+	return delay
+}
+
+// retryable is whether the attempt failed to connect, responded with a retryable status, or with an error
+// the origin classifies as throttling or transient (e.g. a 400 ProvisionedThroughputExceededException).
+// Requests the proxy rejected (e.g. a body not matching its signed hash) are rejected again, so aren't retried.
+func (p *RetryPolicy) retryable(protocol AWSProtocol, res *http.Response, err error) bool {
+	if _, rejected := rejectionReason(err); rejected {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	if lo.Contains(lo.Ternary(p.Statuses != nil, p.Statuses, DefaultRetryStatuses), res.StatusCode) {
+		return true
+	}
+	originErr, err := ParseOriginError(protocol, res)
+	return err == nil && originErr != nil && originErr.Class != ErrorClassPermanent
+}
+
+// parseRetryAfter reads the Retry-After seconds or date of a throttling response
+func parseRetryAfter(res *http.Response, now time.Time) (time.Duration, bool) {
+	if res == nil || (res.StatusCode != http.StatusTooManyRequests && res.StatusCode != http.StatusServiceUnavailable) {
+		return 0, false
+	}
+	value := res.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(0, date.Sub(now)), true
+	}
+	return 0, false
+}
+
+func (r *ProxiedRequest) doRetriedRequest(ctx context.Context, host string) (*http.Response, error) {
+	p := r.retryPolicy
+	maxBodyBytes := lo.Ternary(p.MaxBodyBytes > 0, p.MaxBodyBytes, maxRetryBodyBytes)
+	if r.Request.ContentLength < 0 || r.Request.ContentLength > maxBodyBytes {
+		return r.doProxiedRequest(ctx, host, r.Request.Body)
+	}
+
+	body, err := io.ReadAll(r.Request.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading body to retry: %w", err)
+	}
+
+	maxAttempts := lo.Ternary(p.MaxAttempts > 0, p.MaxAttempts, 3)
+	for attempt := 1; ; attempt++ {
+		res, err := r.doProxiedRequest(ctx, host, bytes.NewReader(body))
+		if attempt >= maxAttempts || !p.retryable(ProtocolForService(r.Service), res, err) {
+			return res, err
+		}
+
+		delay := p.delay(attempt, res, clockOrReal(r.clock).Now())
+		if res != nil {
+			res.Body.Close()
+		}
+		logger.Debug().Err(err).Int("attempt", attempt).Dur("delay", delay).Msg("retrying origin request")
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("cancelled waiting to retry: %w", ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
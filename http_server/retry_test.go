@@ -0,0 +1,108 @@
+package http_server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// fixedBackoff always waits the same delay
+type fixedBackoff time.Duration
+
+func (b fixedBackoff) NextDelay(int, *http.Response) time.Duration {
+	return time.Duration(b)
+}
+
+// Every delay a strategy picks for an attempt is within the bounds of its progression
+func TestBackoffDelays(t *testing.T) {
+	const base, maxDelay = 100 * time.Millisecond, 2 * time.Second
+
+	tests := []struct {
+		name    string
+		backoff Backoff
+		// bounds are the [min, max] delays of attempts 1, 2, ...
+		bounds [][2]time.Duration
+	}{
+		{
+			name:    "full jitter",
+			backoff: FullJitterBackoff{Base: base, Max: maxDelay},
+			bounds:  [][2]time.Duration{{0, 200 * time.Millisecond}, {0, 400 * time.Millisecond}, {0, 800 * time.Millisecond}, {0, 1600 * time.Millisecond}, {0, maxDelay}, {0, maxDelay}},
+		},
+		{
+			name:    "equal jitter",
+			backoff: EqualJitterBackoff{Base: base, Max: maxDelay},
+			bounds:  [][2]time.Duration{{100 * time.Millisecond, 200 * time.Millisecond}, {200 * time.Millisecond, 400 * time.Millisecond}, {400 * time.Millisecond, 800 * time.Millisecond}, {800 * time.Millisecond, 1600 * time.Millisecond}, {time.Second, maxDelay}, {time.Second, maxDelay}},
+		},
+		{
+			name:    "decorrelated jitter",
+			backoff: DecorrelatedJitterBackoff{Base: base, Max: maxDelay},
+			bounds:  [][2]time.Duration{{base, 300 * time.Millisecond}, {base, 900 * time.Millisecond}, {base, maxDelay}, {base, maxDelay}},
+		},
+		{
+			name:    "decorrelated jitter with base at max",
+			backoff: DecorrelatedJitterBackoff{Base: maxDelay, Max: maxDelay},
+			bounds:  [][2]time.Duration{{maxDelay, maxDelay}, {maxDelay, maxDelay}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, bounds := range tt.bounds {
+				attempt := i + 1
+				for range 1000 {
+					if delay := tt.backoff.NextDelay(attempt, nil); delay < bounds[0] || delay > bounds[1] {
+						t.Fatalf("attempt %d waited %s, want between %s and %s", attempt, delay, bounds[0], bounds[1])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDelayHonorsRetryAfter(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	at := func(d time.Duration) string {
+		return clock.Now().Add(d).Format(http.TimeFormat)
+	}
+
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		backoff    time.Duration
+		want       time.Duration
+	}{
+		{name: "no Retry-After", status: http.StatusTooManyRequests, backoff: time.Second, want: time.Second},
+		{name: "seconds longer than the backoff", status: http.StatusTooManyRequests, retryAfter: "5", backoff: time.Second, want: 5 * time.Second},
+		{name: "seconds shorter than the backoff", status: http.StatusServiceUnavailable, retryAfter: "1", backoff: 3 * time.Second, want: 3 * time.Second},
+		{name: "date", status: http.StatusServiceUnavailable, retryAfter: at(7 * time.Second), backoff: time.Second, want: 7 * time.Second},
+		{name: "date in the past", status: http.StatusTooManyRequests, retryAfter: at(-time.Minute), backoff: time.Second, want: time.Second},
+		{name: "seconds capped", status: http.StatusTooManyRequests, retryAfter: "3600", backoff: time.Second, want: 20 * time.Second},
+		{name: "date capped", status: http.StatusTooManyRequests, retryAfter: at(time.Hour), backoff: time.Second, want: 20 * time.Second},
+		{name: "not a throttling response", status: http.StatusInternalServerError, retryAfter: "5", backoff: time.Second, want: time.Second},
+		{name: "unparseable", status: http.StatusTooManyRequests, retryAfter: "soon", backoff: time.Second, want: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			if tt.retryAfter != "" {
+				res.Header.Set("Retry-After", tt.retryAfter)
+			}
+			policy := &RetryPolicy{Backoff: fixedBackoff(tt.backoff)}
+			if got := policy.delay(1, res, clock.Now()); got != tt.want {
+				t.Errorf("got delay %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// The MaxRetryAfter cap is configurable
+	res := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {at(time.Minute)}}}
+	policy := &RetryPolicy{Backoff: fixedBackoff(time.Second), MaxRetryAfter: 45 * time.Second}
+	if got := policy.delay(1, res, clock.Now()); got != 45*time.Second {
+		t.Errorf("got delay %s with MaxRetryAfter 45s", got)
+	}
+	// A Retry-After date counts down as the clock moves
+	clock.Advance(50 * time.Second)
+	if got := policy.delay(1, res, clock.Now()); got != 10*time.Second {
+		t.Errorf("got delay %s 50s later, want 10s", got)
+	}
+}
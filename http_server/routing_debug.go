@@ -0,0 +1,122 @@
+package http_server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RoutedProvider describes a registered provider in the routing table
+type RoutedProvider struct {
+	ServiceName string `json:"serviceName"`
+	Type        string `json:"type"`
+	Priority    int    `json:"priority"`
+	// ScopeServices are the credential scope services routed to the provider
+	ScopeServices []string `json:"scopeServices"`
+	// Origin is where the provider proxies to by default, if known
+	Origin string `json:"origin,omitempty"`
+}
+
+// RoutingDecision is which provider would handle a request, and why
+type RoutingDecision struct {
+	ServiceName string `json:"serviceName,omitempty"`
+	// Reason is "scope_service", "host", or "no_provider"
+	Reason string `json:"reason"`
+}
+
+type RoutingTable struct {
+	Providers []RoutedProvider `json:"providers"`
+	Decision  *RoutingDecision `json:"decision,omitempty"`
+}
+
+// originDescriber is implemented by providers that embed BaseAWSProvider
+type originDescriber interface {
+	DefaultOrigin() string
+}
+
+// DefaultOrigin describes where the provider proxies to when no operation handler takes over
+func (p *BaseAWSProvider) DefaultOrigin() string {
+	if p.Endpoints != nil {
+		return "endpoint resolver"
+	}
+	if p.OriginHost != "" {
+		return p.OriginHost
+	}
+	return p.serviceName + ".amazonaws.com"
+}
+
+// Describe returns the registered providers, in host fallback (priority) order
+func (r *ProviderRegistry) Describe() []RoutedProvider {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	routed := make([]RoutedProvider, 0, len(r.providers))
+	for _, provider := range r.providers {
+		described := RoutedProvider{
+			ServiceName:   provider.ServiceName(),
+			Type:          fmt.Sprintf("%T", provider),
+			Priority:      providerPriority(provider),
+			ScopeServices: []string{},
+		}
+		for service, scoped := range r.byService {
+			if scoped == provider {
+				described.ScopeServices = append(described.ScopeServices, service)
+			}
+		}
+		sort.Strings(described.ScopeServices)
+		if origin, ok := provider.(originDescriber); ok {
+			described.Origin = origin.DefaultOrigin()
+		}
+		routed = append(routed, described)
+	}
+	return routed
+}
+
+// Explain is GetProviderForRequest, with the reason for the choice
+func (r *ProviderRegistry) Explain(request *ProxiedRequest) RoutingDecision {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if provider, ok := r.byService[request.Service]; ok {
+		return RoutingDecision{ServiceName: provider.ServiceName(), Reason: "scope_service"}
+	}
+	for _, provider := range r.providers {
+		if provider.CanHandleRequest(request) {
+			return RoutingDecision{ServiceName: provider.ServiceName(), Reason: "host"}
+		}
+	}
+	return RoutingDecision{Reason: "no_provider"}
+}
+
+// GetRouting dumps the routing table. Given a host (and optionally service, method, and path) query,
+// it also dry-runs routing a request like that.
+func (s *HTTPServer) GetRouting(c echo.Context) error {
+	providers := s.providers
+	if s.proxy != nil && s.proxy.Lookups().Providers != nil {
+		// The registry may have been swapped by a reload
+		providers = s.proxy.Lookups().Providers
+	}
+	if providers == nil {
+		return c.JSON(http.StatusOK, RoutingTable{Providers: []RoutedProvider{}})
+	}
+
+	table := RoutingTable{Providers: providers.Describe()}
+	host, service := c.QueryParam("host"), c.QueryParam("service")
+	if host != "" || service != "" {
+		method := c.QueryParam("method")
+		if method == "" {
+			method = http.MethodGet
+		}
+		u := &url.URL{Scheme: "https", Host: host, Path: "/" + strings.TrimPrefix(c.QueryParam("path"), "/")}
+		decision := providers.Explain(&ProxiedRequest{
+			Request: &http.Request{Method: method, URL: u, Host: host, Header: http.Header{}},
+			Service: service,
+		})
+		table.Decision = &decision
+	}
+	return c.JSON(http.StatusOK, table)
+}
@@ -0,0 +1,115 @@
+package http_server
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// S3ListingOperations are the operations whose XML responses can embed origin hostnames or owner info
+var S3ListingOperations = []string{"ListBuckets", "ListObjects", "ListObjectsV2", "ListObjectVersions", "ListMultipartUploads", "CompleteMultipartUpload"}
+
+// S3ListingRewriter rewrites S3 XML responses so they don't leak the real backend: origin hostnames
+// (e.g. in a Location) are replaced with the proxy's public host, and Owner elements are optionally removed.
+// The document is rewritten as it streams, so large listings are never buffered.
+//
+// Install it with S3Provider.Use(TransformResponses(rewriter.Transform, S3ListingOperations...)).
+type S3ListingRewriter struct {
+	// PublicHost replaces origin hostnames, defaults to the host the client requested
+	PublicHost string
+	// OriginHosts are rewritten in addition to the host the request was proxied to
+	OriginHosts []string
+	// StripOwner removes Owner elements (the backend account's ID and display name)
+	StripOwner bool
+}
+
+// Transform is a ResponseTransformer that rewrites successful XML responses
+func (s *S3ListingRewriter) Transform(ctx context.Context, request *ProxiedRequest, res *http.Response) (*http.Response, error) {
+	if res.StatusCode != http.StatusOK || !strings.Contains(res.Header.Get("Content-Type"), "xml") {
+		return res, nil
+	}
+
+	publicHost := s.PublicHost
+	if publicHost == "" {
+		publicHost = request.OriginalHost
+	}
+	var pairs []string
+	originHosts := s.OriginHosts
+	if res.Request != nil {
+		originHosts = append([]string{res.Request.URL.Host}, originHosts...)
+	}
+	for _, host := range originHosts {
+		if host != "" && host != publicHost {
+			pairs = append(pairs, host, publicHost)
+		}
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	// The goroutine must read the origin body, not the pipe that replaces it
+	body := res.Body
+	pr, pw := io.Pipe()
+	go func() {
+		defer body.Close()
+		pw.CloseWithError(s.rewrite(pw, body, replacer))
+	}()
+
+	// The length changes with the rewrites
+	res.Header.Del("Content-Length")
+	res.ContentLength = -1
+	res.Body = pr
+	return res, nil
+}
+
+func (s *S3ListingRewriter) rewrite(w io.Writer, r io.Reader, replacer *strings.Replacer) error {
+	// Raw tokens keep namespace prefixes and xmlns attributes exactly as the origin sent them
+	decoder := xml.NewDecoder(r)
+	encoder := xml.NewEncoder(w)
+	skipDepth := 0
+	for {
+		token, err := decoder.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error in decoder.RawToken: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if skipDepth > 0 || (s.StripOwner && t.Name.Local == "Owner") {
+				skipDepth++
+				continue
+			}
+			for i := range t.Attr {
+				t.Attr[i].Value = replacer.Replace(t.Attr[i].Value)
+			}
+			token = t
+		case xml.EndElement:
+			if skipDepth > 0 {
+				skipDepth--
+				continue
+			}
+		case xml.CharData:
+			if skipDepth > 0 {
+				continue
+			}
+			token = xml.CharData(replacer.Replace(string(t)))
+		default:
+			if skipDepth > 0 {
+				continue
+			}
+		}
+
+		if err = encoder.EncodeToken(token); err != nil {
+			return fmt.Errorf("error in encoder.EncodeToken: %w", err)
+		}
+	}
+	if err := encoder.Flush(); err != nil {
+		return fmt.Errorf("error in encoder.Flush: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package http_server_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+const listObjectsV2Response = `<?xml version="1.0" encoding="UTF-8"?>
+<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>bucket</Name><Prefix></Prefix><KeyCount>2</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated><Contents><Key>photos/a.jpg</Key><LastModified>2024-01-01T00:00:00.000Z</LastModified><ETag>&quot;abc&quot;</ETag><Size>1024</Size><Owner><ID>backend-account-id</ID><DisplayName>backend</DisplayName></Owner><StorageClass>STANDARD</StorageClass></Contents><Contents><Key>links/{{ORIGIN}}/b.txt</Key><Size>7</Size><StorageClass>STANDARD</StorageClass></Contents><EncodingType>url</EncodingType><StartAfter>https://backend.internal/bucket</StartAfter></ListBucketResult>`
+
+func TestS3ListingRewriter(t *testing.T) {
+	rewriter := &http_server.S3ListingRewriter{
+		PublicHost:  "s3.example.com",
+		OriginHosts: []string{"backend.internal"},
+		StripOwner:  true,
+	}
+	h := iamtest.NewHarness(func(originURL string) http_server.AWSServiceProvider {
+		p := http_server.NewS3Provider()
+		p.OriginHost = originURL
+		p.Use(http_server.TransformResponses(rewriter.Transform, http_server.S3ListingOperations...))
+		return p
+	})
+	t.Cleanup(h.Close)
+	originHost := strings.TrimPrefix(h.Origin.URL, "http://")
+	listing := strings.ReplaceAll(listObjectsV2Response, "{{ORIGIN}}", originHost)
+	h.Origin.RespondWith(http.StatusOK, http.Header{"Content-Type": {"application/xml"}}, []byte(listing))
+
+	res, err := h.Do(h.NewSignedRequest(http.MethodGet, "/bucket?list-type=2", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got %d %s", res.StatusCode, body)
+	}
+
+	got := string(body)
+	for _, leaked := range []string{originHost, "backend.internal", "backend-account-id", "<Owner>"} {
+		if strings.Contains(got, leaked) {
+			t.Errorf("rewritten listing still has %q: %s", leaked, got)
+		}
+	}
+	for _, want := range []string{
+		`<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`,
+		"<Key>links/s3.example.com/b.txt</Key>",
+		"<StartAfter>https://s3.example.com/bucket</StartAfter>",
+		"<Key>photos/a.jpg</Key>",
+		"<ETag>&#34;abc&#34;</ETag>",
+		"<Size>1024</Size><StorageClass>STANDARD</StorageClass>",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("rewritten listing is missing %s: %s", want, got)
+		}
+	}
+	if res.Header.Get("Content-Length") != "" {
+		t.Errorf("got the origin's Content-Length %q for a rewritten body", res.Header.Get("Content-Length"))
+	}
+
+	// Objects aren't listings, their contents are never rewritten
+	res, err = h.Do(h.NewSignedRequest(http.MethodGet, "/bucket/listing.xml", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != listing {
+		t.Errorf("GetObject body was rewritten: %s", body)
+	}
+}
+
+// Listings are rewritten as they stream, a listing larger than any buffer is rewritten throughout
+func TestS3ListingRewriterLargeListing(t *testing.T) {
+	rewriter := &http_server.S3ListingRewriter{PublicHost: "s3.example.com", OriginHosts: []string{"backend.internal"}}
+	h := iamtest.NewHarness(func(originURL string) http_server.AWSServiceProvider {
+		p := http_server.NewS3Provider()
+		p.OriginHost = originURL
+		p.Use(http_server.TransformResponses(rewriter.Transform, http_server.S3ListingOperations...))
+		return p
+	})
+	t.Cleanup(h.Close)
+
+	var listing strings.Builder
+	listing.WriteString(`<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`)
+	const objects = 20000
+	for i := 0; i < objects; i++ {
+		listing.WriteString("<Contents><Key>backend.internal/object</Key></Contents>")
+	}
+	listing.WriteString("</ListBucketResult>")
+	h.Origin.RespondWith(http.StatusOK, http.Header{"Content-Type": {"application/xml"}}, []byte(listing.String()))
+
+	res, err := h.Do(h.NewSignedRequest(http.MethodGet, "/bucket?list-type=2", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if n := strings.Count(string(body), "<Key>s3.example.com/object</Key>"); n != objects {
+		t.Errorf("got %d rewritten keys, want %d", n, objects)
+	}
+}
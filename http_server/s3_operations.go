@@ -0,0 +1,67 @@
+package http_server
+
+import (
+	"net/http"
+)
+
+// s3Subresource maps the methods of requests with a subresource query parameter (e.g. ?tagging) to operations
+type s3Subresource struct {
+	param      string
+	operations map[string]string
+}
+
+// s3BucketSubresources classify bucket requests, in order of precedence
+var s3BucketSubresources = []s3Subresource{
+	{"uploads", map[string]string{http.MethodGet: "ListMultipartUploads"}},
+	{"versions", map[string]string{http.MethodGet: "ListObjectVersions"}},
+	{"location", map[string]string{http.MethodGet: "GetBucketLocation"}},
+	{"delete", map[string]string{http.MethodPost: "DeleteObjects"}},
+	{"tagging", map[string]string{http.MethodGet: "GetBucketTagging", http.MethodPut: "PutBucketTagging", http.MethodDelete: "DeleteBucketTagging"}},
+	{"acl", map[string]string{http.MethodGet: "GetBucketAcl", http.MethodPut: "PutBucketAcl"}},
+	{"versioning", map[string]string{http.MethodGet: "GetBucketVersioning", http.MethodPut: "PutBucketVersioning"}},
+	{"policyStatus", map[string]string{http.MethodGet: "GetBucketPolicyStatus"}},
+	{"policy", map[string]string{http.MethodGet: "GetBucketPolicy", http.MethodPut: "PutBucketPolicy", http.MethodDelete: "DeleteBucketPolicy"}},
+	{"cors", map[string]string{http.MethodGet: "GetBucketCors", http.MethodPut: "PutBucketCors", http.MethodDelete: "DeleteBucketCors"}},
+	{"lifecycle", map[string]string{http.MethodGet: "GetBucketLifecycleConfiguration", http.MethodPut: "PutBucketLifecycleConfiguration", http.MethodDelete: "DeleteBucketLifecycle"}},
+	{"encryption", map[string]string{http.MethodGet: "GetBucketEncryption", http.MethodPut: "PutBucketEncryption", http.MethodDelete: "DeleteBucketEncryption"}},
+	{"website", map[string]string{http.MethodGet: "GetBucketWebsite", http.MethodPut: "PutBucketWebsite", http.MethodDelete: "DeleteBucketWebsite"}},
+	{"logging", map[string]string{http.MethodGet: "GetBucketLogging", http.MethodPut: "PutBucketLogging"}},
+	{"notification", map[string]string{http.MethodGet: "GetBucketNotificationConfiguration", http.MethodPut: "PutBucketNotificationConfiguration"}},
+	{"replication", map[string]string{http.MethodGet: "GetBucketReplication", http.MethodPut: "PutBucketReplication", http.MethodDelete: "DeleteBucketReplication"}},
+	{"object-lock", map[string]string{http.MethodGet: "GetObjectLockConfiguration", http.MethodPut: "PutObjectLockConfiguration"}},
+	{"publicAccessBlock", map[string]string{http.MethodGet: "GetPublicAccessBlock", http.MethodPut: "PutPublicAccessBlock", http.MethodDelete: "DeletePublicAccessBlock"}},
+	{"ownershipControls", map[string]string{http.MethodGet: "GetBucketOwnershipControls", http.MethodPut: "PutBucketOwnershipControls", http.MethodDelete: "DeleteBucketOwnershipControls"}},
+	{"accelerate", map[string]string{http.MethodGet: "GetBucketAccelerateConfiguration", http.MethodPut: "PutBucketAccelerateConfiguration"}},
+	{"requestPayment", map[string]string{http.MethodGet: "GetBucketRequestPayment", http.MethodPut: "PutBucketRequestPayment"}},
+}
+
+// s3ObjectSubresources classify object requests, in order of precedence. UploadPartCopy is classified
+// separately since it is an uploadId PUT with a copy source.
+var s3ObjectSubresources = []s3Subresource{
+	{"uploadId", map[string]string{http.MethodGet: "ListParts", http.MethodPut: "UploadPart", http.MethodPost: "CompleteMultipartUpload", http.MethodDelete: "AbortMultipartUpload"}},
+	{"uploads", map[string]string{http.MethodPost: "CreateMultipartUpload"}},
+	{"tagging", map[string]string{http.MethodGet: "GetObjectTagging", http.MethodPut: "PutObjectTagging", http.MethodDelete: "DeleteObjectTagging"}},
+	{"acl", map[string]string{http.MethodGet: "GetObjectAcl", http.MethodPut: "PutObjectAcl"}},
+	{"retention", map[string]string{http.MethodGet: "GetObjectRetention", http.MethodPut: "PutObjectRetention"}},
+	{"legal-hold", map[string]string{http.MethodGet: "GetObjectLegalHold", http.MethodPut: "PutObjectLegalHold"}},
+	{"attributes", map[string]string{http.MethodGet: "GetObjectAttributes"}},
+	{"restore", map[string]string{http.MethodPost: "RestoreObject"}},
+	{"select", map[string]string{http.MethodPost: "SelectObjectContent"}},
+	{"torrent", map[string]string{http.MethodGet: "GetObjectTorrent"}},
+}
+
+// classifyS3Subresource returns the operation of the first subresource in the query, if any.
+// A request with a subresource but an unsupported method is OperationUnknown rather than
+// falling through to e.g. GetObject.
+func classifyS3Subresource(subresources []s3Subresource, method string, has func(param string) bool) (string, bool) {
+	for _, subresource := range subresources {
+		if !has(subresource.param) {
+			continue
+		}
+		if operation, ok := subresource.operations[method]; ok {
+			return operation, true
+		}
+		return OperationUnknown, true
+	}
+	return "", false
+}
@@ -0,0 +1,124 @@
+package http_server
+
+import (
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func newS3OperationRequest(method, host, target string, header http.Header) *ProxiedRequest {
+	r := httptest.NewRequest(method, target, nil)
+	r.Host = host
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	return &ProxiedRequest{Request: r}
+}
+
+// The requests are as recorded from aws-sdk-go-v2, which adds the x-id parameter to some operations
+func TestS3ExtractOperationName(t *testing.T) {
+	copySource := http.Header{"X-Amz-Copy-Source": {"/source-bucket/source-key"}}
+	tests := []struct {
+		method string
+		host   string
+		target string
+		header http.Header
+		post   bool
+		want   string
+	}{
+		{method: http.MethodGet, host: "s3.us-east-1.amazonaws.com", target: "/?x-id=ListBuckets", want: "ListBuckets"},
+		{method: http.MethodGet, host: "bucket.s3.us-east-1.amazonaws.com", target: "/?list-type=2&prefix=logs%2F", want: "ListObjectsV2"},
+		{method: http.MethodGet, host: "s3.us-east-1.amazonaws.com", target: "/bucket?prefix=logs%2F", want: "ListObjects"},
+		{method: http.MethodHead, host: "bucket.s3.us-east-1.amazonaws.com", target: "/", want: "HeadBucket"},
+		{method: http.MethodPut, host: "bucket.s3.us-east-1.amazonaws.com", target: "/", want: "CreateBucket"},
+		{method: http.MethodDelete, host: "bucket.s3.us-east-1.amazonaws.com", target: "/", want: "DeleteBucket"},
+		{method: http.MethodGet, host: "bucket.s3.us-east-1.amazonaws.com", target: "/?location", want: "GetBucketLocation"},
+		{method: http.MethodGet, host: "bucket.s3.us-east-1.amazonaws.com", target: "/?versioning", want: "GetBucketVersioning"},
+		{method: http.MethodPut, host: "bucket.s3.us-east-1.amazonaws.com", target: "/?versioning", want: "PutBucketVersioning"},
+		{method: http.MethodGet, host: "bucket.s3.us-east-1.amazonaws.com", target: "/?tagging", want: "GetBucketTagging"},
+		{method: http.MethodGet, host: "bucket.s3.us-east-1.amazonaws.com", target: "/?acl", want: "GetBucketAcl"},
+		{method: http.MethodGet, host: "bucket.s3.us-east-1.amazonaws.com", target: "/?policyStatus", want: "GetBucketPolicyStatus"},
+		{method: http.MethodGet, host: "bucket.s3.us-east-1.amazonaws.com", target: "/?versions&prefix=logs%2F", want: "ListObjectVersions"},
+		{method: http.MethodGet, host: "bucket.s3.us-east-1.amazonaws.com", target: "/?uploads", want: "ListMultipartUploads"},
+		{method: http.MethodPost, host: "bucket.s3.us-east-1.amazonaws.com", target: "/?delete", want: "DeleteObjects"},
+		{method: http.MethodPost, host: "bucket.s3.us-east-1.amazonaws.com", target: "/", post: true, want: "PostObject"},
+		{method: http.MethodPost, host: "bucket.s3.us-east-1.amazonaws.com", target: "/", want: OperationUnknown},
+		{method: http.MethodGet, host: "bucket.s3.us-east-1.amazonaws.com", target: "/key?x-id=GetObject", want: "GetObject"},
+		{method: http.MethodHead, host: "bucket.s3.us-east-1.amazonaws.com", target: "/key", want: "HeadObject"},
+		{method: http.MethodPut, host: "bucket.s3.us-east-1.amazonaws.com", target: "/key?x-id=PutObject", want: "PutObject"},
+		{method: http.MethodPut, host: "s3.us-east-1.amazonaws.com", target: "/bucket/key?x-id=PutObject", want: "PutObject"},
+		{method: http.MethodPut, host: "bucket.s3.us-east-1.amazonaws.com", target: "/key?x-id=CopyObject", header: copySource, want: "CopyObject"},
+		{method: http.MethodDelete, host: "bucket.s3.us-east-1.amazonaws.com", target: "/key?x-id=DeleteObject", want: "DeleteObject"},
+		{method: http.MethodPost, host: "bucket.s3.us-east-1.amazonaws.com", target: "/key?uploads&x-id=CreateMultipartUpload", want: "CreateMultipartUpload"},
+		{method: http.MethodPut, host: "bucket.s3.us-east-1.amazonaws.com", target: "/key?partNumber=1&uploadId=upload&x-id=UploadPart", want: "UploadPart"},
+		{method: http.MethodPut, host: "bucket.s3.us-east-1.amazonaws.com", target: "/key?partNumber=2&uploadId=upload&x-id=UploadPartCopy", header: copySource, want: "UploadPartCopy"},
+		{method: http.MethodGet, host: "bucket.s3.us-east-1.amazonaws.com", target: "/key?uploadId=upload&x-id=ListParts", want: "ListParts"},
+		{method: http.MethodPost, host: "bucket.s3.us-east-1.amazonaws.com", target: "/key?uploadId=upload", want: "CompleteMultipartUpload"},
+		{method: http.MethodDelete, host: "bucket.s3.us-east-1.amazonaws.com", target: "/key?uploadId=upload&x-id=AbortMultipartUpload", want: "AbortMultipartUpload"},
+		{method: http.MethodGet, host: "bucket.s3.us-east-1.amazonaws.com", target: "/key?tagging", want: "GetObjectTagging"},
+		{method: http.MethodPut, host: "bucket.s3.us-east-1.amazonaws.com", target: "/key?tagging", want: "PutObjectTagging"},
+		{method: http.MethodDelete, host: "bucket.s3.us-east-1.amazonaws.com", target: "/key?tagging", want: "DeleteObjectTagging"},
+		{method: http.MethodGet, host: "bucket.s3.us-east-1.amazonaws.com", target: "/key?acl", want: "GetObjectAcl"},
+		{method: http.MethodPut, host: "bucket.s3.us-east-1.amazonaws.com", target: "/key?acl", want: "PutObjectAcl"},
+		{method: http.MethodGet, host: "bucket.s3.us-east-1.amazonaws.com", target: "/key?attributes", want: "GetObjectAttributes"},
+		// A subresource with an unsupported method isn't the plain object operation
+		{method: http.MethodPost, host: "bucket.s3.us-east-1.amazonaws.com", target: "/key?tagging", want: OperationUnknown},
+		{method: http.MethodDelete, host: "bucket.s3.us-east-1.amazonaws.com", target: "/key?acl", want: OperationUnknown},
+	}
+	provider := NewS3Provider()
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.host+tt.target, func(t *testing.T) {
+			request := newS3OperationRequest(tt.method, tt.host, tt.target, tt.header)
+			if tt.post {
+				request.PostPolicy = &S3PostPolicy{}
+			}
+			if got := provider.ExtractOperationName(request); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// Every operation of the subresource tables is reachable, and the operation names the rest of the proxy
+// refers to are ones the providers classify
+func TestOperationTableNames(t *testing.T) {
+	provider := NewS3Provider()
+	s3Operations := OperationSet{
+		"ListBuckets": true, "ListObjects": true, "ListObjectsV2": true, "HeadBucket": true, "CreateBucket": true,
+		"DeleteBucket": true, "PostObject": true, "GetObject": true, "HeadObject": true, "PutObject": true,
+		"CopyObject": true, "DeleteObject": true, "UploadPartCopy": true,
+	}
+	for target, subresources := range map[string][]s3Subresource{"/bucket": s3BucketSubresources, "/bucket/key": s3ObjectSubresources} {
+		for _, subresource := range subresources {
+			for method, want := range subresource.operations {
+				request := newS3OperationRequest(method, "s3.us-east-1.amazonaws.com", target+"?"+subresource.param, nil)
+				if got := provider.ExtractOperationName(request); got != want {
+					t.Errorf("%s %s?%s classified as %s, want %s", method, target, subresource.param, got, want)
+				}
+				s3Operations[want] = true
+			}
+		}
+	}
+
+	tables := map[string]OperationSet{"s3": s3Operations, "dynamodb": DynamoDBOperations, "sqs": SQSOperations}
+	for service, table := range tables {
+		for _, operation := range slices.Sorted(maps.Keys(ReadOperations[service])) {
+			if !table[operation] {
+				t.Errorf("read operation %s:%s isn't an operation of the service", service, operation)
+			}
+		}
+	}
+	referenced := map[string][]string{
+		"S3ListingOperations":           S3ListingOperations,
+		"s3CacheInvalidatingOperations": s3CacheInvalidatingOperations,
+	}
+	for name, operations := range referenced {
+		for _, operation := range operations {
+			if !s3Operations[operation] {
+				t.Errorf("%s lists %s, which isn't an S3 operation", name, operation)
+			}
+		}
+	}
+}
@@ -0,0 +1,428 @@
+package http_server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxPostPolicyFieldBytes bounds how much of a single form field we will buffer
+	maxPostPolicyFieldBytes = 64 * 1024
+	// maxPostPolicyFields bounds how many form fields can precede the file
+	maxPostPolicyFields = 100
+)
+
+var (
+	ErrInvalidPostPolicy         = errors.New("invalid post policy form")
+	ErrPostPolicyExpired         = errors.New("post policy expired")
+	ErrPostPolicyConditionFailed = errors.New("post policy condition failed")
+)
+
+var (
+	ErrAWSEntityTooLarge = NewAWSError(http.StatusBadRequest, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed size")
+	ErrAWSEntityTooSmall = NewAWSError(http.StatusBadRequest, "EntityTooSmall", "Your proposed upload is smaller than the minimum allowed size")
+)
+
+// postPolicySigningFields are the form fields that sign the policy, which are replaced when it is re-signed
+var postPolicySigningFields = map[string]bool{
+	"x-amz-algorithm":      true,
+	"x-amz-credential":     true,
+	"x-amz-date":           true,
+	"x-amz-security-token": true,
+	"x-amz-signature":      true,
+}
+
+// S3PostPolicy is the signed policy of a browser-based (HTML form POST) S3 upload.
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-HTTPPOSTConstructPolicy.html
+type S3PostPolicy struct {
+	// Policy is the base64 encoded policy document, which is what gets signed
+	Policy     string
+	Algorithm  string
+	Credential AWSAuthHeaderCredential
+	Date       string
+	Signature  string
+	// Fields are all form fields preceding the file, keyed by lowercased name
+	Fields map[string]string
+
+	boundary string
+	// fieldNames are the names of Fields as sent, in the order of the form
+	fieldNames []string
+	// filePartOffset is where the delimiter of the file part starts in the body, and fileOffset its content
+	filePartOffset int64
+	fileOffset     int64
+	// document is the decoded policy, once its signature is verified
+	document postPolicyDocument
+}
+
+// postPolicyDocument is the decoded policy, with the conditions the form must meet
+type postPolicyDocument struct {
+	Expiration string            `json:"expiration"`
+	Conditions []json.RawMessage `json:"conditions"`
+}
+
+// isPostPolicyRequest detects browser-based uploads, which carry their signature in the multipart body
+// instead of the Authorization header
+func isPostPolicyRequest(r *http.Request) bool {
+	if r.Method != http.MethodPost || r.Header.Get("Authorization") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// readPostPolicyForm reads the form fields that precede the file part. S3 requires the file to be the
+// last field, so only the (small) fields are consumed, and r.Body is replaced with a reader that replays
+// what was consumed followed by the rest of the original body so it can be proxied untouched.
+func readPostPolicyForm(r *http.Request) (*S3PostPolicy, error) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, fmt.Errorf("error in mime.ParseMediaType: %w", err)
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, fmt.Errorf("missing multipart boundary: %w", ErrInvalidPostPolicy)
+	}
+
+	original := r.Body
+	var consumed bytes.Buffer
+	defer func() {
+		r.Body = readCloser{
+			Reader: io.MultiReader(&consumed, original),
+			Closer: original,
+		}
+	}()
+
+	policy := &S3PostPolicy{
+		Fields:   map[string]string{},
+		boundary: boundary,
+	}
+	hasFile, fieldParts := false, 0
+	mr := multipart.NewReader(io.TeeReader(original, &consumed), boundary)
+	for ; fieldParts < maxPostPolicyFields; fieldParts++ {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error in NextPart: %w", err)
+		}
+
+		name := strings.ToLower(part.FormName())
+		if name == "file" {
+			hasFile = true
+			break
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, maxPostPolicyFieldBytes+1))
+		if err != nil {
+			return nil, fmt.Errorf("error reading form field %s: %w", name, err)
+		}
+		if len(value) > maxPostPolicyFieldBytes {
+			return nil, fmt.Errorf("form field %s too large: %w", name, ErrInvalidPostPolicy)
+		}
+		if _, ok := policy.Fields[name]; !ok {
+			policy.fieldNames = append(policy.fieldNames, part.FormName())
+		}
+		policy.Fields[name] = string(value)
+	}
+	if !hasFile {
+		return nil, fmt.Errorf("missing file: %w", ErrInvalidPostPolicy)
+	}
+	policy.filePartOffset, policy.fileOffset, err = filePartOffsets(consumed.Bytes(), boundary, fieldParts)
+	if err != nil {
+		return nil, err
+	}
+
+	policy.Policy = policy.Fields["policy"]
+	policy.Algorithm = policy.Fields["x-amz-algorithm"]
+	policy.Date = policy.Fields["x-amz-date"]
+	policy.Signature = policy.Fields["x-amz-signature"]
+	if policy.Policy == "" || policy.Signature == "" || policy.Date == "" {
+		return nil, fmt.Errorf("missing policy, x-amz-signature, or x-amz-date: %w", ErrInvalidPostPolicy)
+	}
+	if policy.Algorithm != AlgorithmSigV4 {
+		return nil, fmt.Errorf("unsupported x-amz-algorithm %s: %w", policy.Algorithm, ErrInvalidPostPolicy)
+	}
+
+	credentialParts := strings.Split(policy.Fields["x-amz-credential"], "/")
+	if len(credentialParts) != 5 {
+		return nil, fmt.Errorf("malformed x-amz-credential: %w", ErrInvalidPostPolicy)
+	}
+	policy.Credential = AWSAuthHeaderCredential{
+		KeyID:   credentialParts[0],
+		Date:    credentialParts[1],
+		Region:  credentialParts[2],
+		Service: credentialParts[3],
+		Request: credentialParts[4],
+	}
+
+	return policy, nil
+}
+
+// filePartOffsets finds where the file part, which follows the fields, starts in the body read so far.
+// Fields can't contain the boundary, so the file part is the one after the delimiters of the fields.
+func filePartOffsets(body []byte, boundary string, fields int) (delimiter, content int64, err error) {
+	dashBoundary := []byte("--" + boundary)
+	offset := 0
+	for i := 0; i <= fields; i++ {
+		next := bytes.Index(body[offset:], dashBoundary)
+		if next < 0 {
+			return 0, 0, fmt.Errorf("missing delimiter of the file part: %w", ErrInvalidPostPolicy)
+		}
+		offset += next + len(dashBoundary)
+	}
+	start := offset - len(dashBoundary)
+	if !bytes.HasSuffix(body[:start], []byte("\r\n")) {
+		return 0, 0, fmt.Errorf("file part delimiter isn't preceded by CRLF: %w", ErrInvalidPostPolicy)
+	}
+	headersEnd := bytes.Index(body[offset:], []byte("\r\n\r\n"))
+	if headersEnd < 0 {
+		return 0, 0, fmt.Errorf("missing end of the file part headers: %w", ErrInvalidPostPolicy)
+	}
+	return int64(start - 2), int64(offset + headersEnd + 4), nil
+}
+
+// verifySignature checks the SigV4 signature over the base64 policy document, and that the policy has not expired
+func (p *S3PostPolicy) verifySignature(keySecret string, now time.Time) error {
+	signingKey := getSigningKeyForDate(p.Credential.Date, keySecret, p.Credential.Region, p.Credential.Service)
+	signature := fmt.Sprintf("%x", getHMAC(signingKey, []byte(p.Policy)))
+	if !hmac.Equal([]byte(signature), []byte(p.Signature)) {
+		return ErrInvalidSignature
+	}
+
+	policyJSON, err := base64.StdEncoding.DecodeString(p.Policy)
+	if err != nil {
+		return fmt.Errorf("error decoding policy: %w", ErrInvalidPostPolicy)
+	}
+	if err := json.Unmarshal(policyJSON, &p.document); err != nil {
+		return fmt.Errorf("error in json.Unmarshal of policy: %w", ErrInvalidPostPolicy)
+	}
+	expiration, err := time.Parse(time.RFC3339, p.document.Expiration)
+	if err != nil {
+		return fmt.Errorf("error parsing policy expiration: %w", ErrInvalidPostPolicy)
+	}
+	if now.After(expiration) {
+		return ErrPostPolicyExpired
+	}
+
+	return nil
+}
+
+// checkConditions checks the form fields against the exact match and starts-with conditions of the verified
+// policy, with the bucket being the one the form was posted to. The content-length-range is returned to
+// be enforced on the file as it is streamed, see limitFileSize. Like S3, fields are matched case-insensitively.
+func (p *S3PostPolicy) checkConditions(bucket string) (lengthRange *[2]int64, err error) {
+	value := func(field string) string {
+		field = strings.ToLower(strings.TrimPrefix(field, "$"))
+		if field == "bucket" {
+			return bucket
+		}
+		return p.Fields[field]
+	}
+
+	for _, raw := range p.document.Conditions {
+		var exact map[string]string
+		if json.Unmarshal(raw, &exact) == nil {
+			for field, want := range exact {
+				if value(field) != want {
+					return nil, fmt.Errorf("%s: %w", raw, ErrPostPolicyConditionFailed)
+				}
+			}
+			continue
+		}
+
+		var condition []json.RawMessage
+		var operator string
+		if json.Unmarshal(raw, &condition) != nil || len(condition) != 3 || json.Unmarshal(condition[0], &operator) != nil {
+			return nil, fmt.Errorf("malformed condition %s: %w", raw, ErrInvalidPostPolicy)
+		}
+		switch operator = strings.ToLower(operator); operator {
+		case "content-length-range":
+			var bounds [2]int64
+			if json.Unmarshal(condition[1], &bounds[0]) != nil || json.Unmarshal(condition[2], &bounds[1]) != nil {
+				return nil, fmt.Errorf("malformed condition %s: %w", raw, ErrInvalidPostPolicy)
+			}
+			lengthRange = &bounds
+		case "eq", "starts-with":
+			var field, want string
+			if json.Unmarshal(condition[1], &field) != nil || json.Unmarshal(condition[2], &want) != nil {
+				return nil, fmt.Errorf("malformed condition %s: %w", raw, ErrInvalidPostPolicy)
+			}
+			got := value(field)
+			if (operator == "eq" && got != want) || !strings.HasPrefix(got, want) {
+				return nil, fmt.Errorf("%s: %w", raw, ErrPostPolicyConditionFailed)
+			}
+		default:
+			return nil, fmt.Errorf("unknown condition %s: %w", raw, ErrInvalidPostPolicy)
+		}
+	}
+	return lengthRange, nil
+}
+
+// limitFileSize wraps the body so reading it fails once the file part is outside of the content-length-range,
+// which aborts the upload to the origin
+func (p *S3PostPolicy) limitFileSize(body io.ReadCloser, lengthRange [2]int64) io.ReadCloser {
+	return &fileSizeLimiter{
+		ReadCloser: body,
+		delimiter:  []byte("\r\n--" + p.boundary),
+		skip:       p.fileOffset,
+		min:        lengthRange[0],
+		max:        lengthRange[1],
+	}
+}
+
+// fileSizeLimiter counts the content of the file part as the form streams through, up to its delimiter
+type fileSizeLimiter struct {
+	io.ReadCloser
+	delimiter []byte
+	// skip is how much of the form before the file content is still to be read
+	skip int64
+	// pending is the end of what was read, which may be the start of a delimiter split across reads
+	pending  []byte
+	size     int64
+	min, max int64
+	done     bool
+}
+
+func (l *fileSizeLimiter) Read(b []byte) (int, error) {
+	n, err := l.ReadCloser.Read(b)
+	if l.done {
+		return n, err
+	}
+
+	data := b[:n]
+	skipped := min(l.skip, int64(len(data)))
+	l.skip -= skipped
+	window := append(l.pending, data[skipped:]...)
+	if i := bytes.Index(window, l.delimiter); i >= 0 {
+		l.size += int64(i)
+		l.done = true
+	} else {
+		keep := min(len(window), len(l.delimiter)-1)
+		l.size += int64(len(window) - keep)
+		l.pending = append(l.pending[:0], window[len(window)-keep:]...)
+	}
+
+	switch {
+	case l.size > l.max:
+		return 0, reject(RejectionBodyTooLarge, fmt.Errorf("file is larger than %d bytes: %w", l.max, ErrAWSEntityTooLarge))
+	case l.done && l.size < l.min:
+		return 0, reject(RejectionPostPolicyCondition, fmt.Errorf("file is %d bytes, smaller than %d: %w", l.size, l.min, ErrAWSEntityTooSmall))
+	case !l.done && errors.Is(err, io.EOF):
+		return 0, reject(RejectionMalformedAuth, fmt.Errorf("form ended in the file part: %w: %w", ErrAWSAccessDenied, ErrInvalidPostPolicy))
+	}
+	return n, err
+}
+
+// resign replaces the signing fields of the form in req's body with a policy signed by creds, for origins
+// that don't know the client's key. The policy keeps the client's conditions, with those on the signing
+// fields replaced by the new values. The file part and everything after it is forwarded as sent.
+func (p *S3PostPolicy) resign(req *http.Request, creds SigningCredentials, region string, now time.Time) error {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	credential := strings.Join([]string{creds.AccessKeyID, amzDate[:8], region, "s3", "aws4_request"}, "/")
+	signing := map[string]string{
+		"x-amz-algorithm":  AlgorithmSigV4,
+		"x-amz-credential": credential,
+		"x-amz-date":       amzDate,
+	}
+	if creds.SessionToken != "" {
+		signing["x-amz-security-token"] = creds.SessionToken
+	}
+
+	document := postPolicyDocument{Expiration: p.document.Expiration}
+	for _, raw := range p.document.Conditions {
+		if !conditionOnSigningField(raw) {
+			document.Conditions = append(document.Conditions, raw)
+		}
+	}
+	for _, field := range []string{"x-amz-algorithm", "x-amz-credential", "x-amz-date", "x-amz-security-token"} {
+		if value, ok := signing[field]; ok {
+			condition, err := json.Marshal(map[string]string{field: value})
+			if err != nil {
+				return fmt.Errorf("error in json.Marshal: %w", err)
+			}
+			document.Conditions = append(document.Conditions, condition)
+		}
+	}
+	documentJSON, err := json.Marshal(document)
+	if err != nil {
+		return fmt.Errorf("error in json.Marshal: %w", err)
+	}
+	policy := base64.StdEncoding.EncodeToString(documentJSON)
+	signing["policy"] = policy
+	signing["x-amz-signature"] = fmt.Sprintf("%x", getHMAC(getSigningKeyForDate(amzDate[:8], creds.SecretAccessKey, region, "s3"), []byte(policy)))
+
+	var fields bytes.Buffer
+	mw := multipart.NewWriter(&fields)
+	if err := mw.SetBoundary(p.boundary); err != nil {
+		return fmt.Errorf("error in SetBoundary: %w", err)
+	}
+	names := p.fieldNames
+	if _, ok := p.Fields["x-amz-security-token"]; !ok && creds.SessionToken != "" {
+		names = append(names[:len(names):len(names)], "x-amz-security-token")
+	}
+	for _, name := range names {
+		field := strings.ToLower(name)
+		value := p.Fields[field]
+		if field == "policy" || postPolicySigningFields[field] {
+			var ok bool
+			if value, ok = signing[field]; !ok {
+				// The client's session token belongs to its own key
+				continue
+			}
+		}
+		if err := mw.WriteField(name, value); err != nil {
+			return fmt.Errorf("error in WriteField: %w", err)
+		}
+	}
+
+	// The fields are written without the delimiter that closes the last one, which starts the file part
+	if _, err := io.CopyN(io.Discard, req.Body, p.filePartOffset); err != nil {
+		return fmt.Errorf("error skipping the form fields: %w", err)
+	}
+	req.Body = readCloser{
+		Reader: io.MultiReader(&fields, req.Body),
+		Closer: req.Body,
+	}
+	req.GetBody = nil
+	if req.ContentLength > 0 {
+		req.ContentLength += int64(fields.Len()) - p.filePartOffset
+		req.Header.Set("Content-Length", strconv.FormatInt(req.ContentLength, 10))
+	}
+	return nil
+}
+
+// conditionOnSigningField is whether a policy condition is on one of the fields that sign the policy
+func conditionOnSigningField(raw json.RawMessage) bool {
+	var exact map[string]string
+	if json.Unmarshal(raw, &exact) == nil {
+		for field := range exact {
+			if postPolicySigningFields[strings.ToLower(field)] {
+				return true
+			}
+		}
+		return false
+	}
+	var condition []any
+	if json.Unmarshal(raw, &condition) != nil || len(condition) < 2 {
+		return false
+	}
+	field, _ := condition[1].(string)
+	return postPolicySigningFields[strings.ToLower(strings.TrimPrefix(field, "$"))]
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
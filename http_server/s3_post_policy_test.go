@@ -0,0 +1,353 @@
+package http_server_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+var formInput = regexp.MustCompile(`<input type="([^"]+)" name="([^"]+)"(?: value="([^"]*)")?`)
+
+// postPolicySigningKey derives the SigV4 signing key of S3 for the date
+func postPolicySigningKey(secret, date string) []byte {
+	key := []byte("AWS4" + secret)
+	for _, data := range []string{date, iamtest.Region, "s3", "aws4_request"} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(data))
+		key = mac.Sum(nil)
+	}
+	return key
+}
+
+func signPostPolicy(secret, date, policy string) string {
+	mac := hmac.New(sha256.New, postPolicySigningKey(secret, date))
+	mac.Write([]byte(policy))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newPostPolicyForm fills in testdata/post_policy_form.html with a policy of the conditions signed with the
+// iamtest key, and encodes it as a browser would, uploading file
+func newPostPolicyForm(t *testing.T, now time.Time, conditions []any, file []byte) (body []byte, contentType string) {
+	t.Helper()
+	html, err := os.ReadFile("testdata/post_policy_form.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	amzDate := now.UTC().Format("20060102T150405Z")
+	credential := iamtest.KeyID + "/" + amzDate[:8] + "/" + iamtest.Region + "/s3/aws4_request"
+	document, err := json.Marshal(map[string]any{
+		"expiration": now.Add(time.Hour).UTC().Format(time.RFC3339),
+		"conditions": append(conditions,
+			map[string]string{"x-amz-credential": credential},
+			map[string]string{"x-amz-algorithm": "AWS4-HMAC-SHA256"},
+			map[string]string{"x-amz-date": amzDate},
+		),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := base64.StdEncoding.EncodeToString(document)
+	placeholders := strings.NewReplacer(
+		"{{credential}}", credential,
+		"{{date}}", amzDate,
+		"{{policy}}", policy,
+		"{{signature}}", signPostPolicy(iamtest.KeySecret, amzDate[:8], policy),
+	)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, input := range formInput.FindAllStringSubmatch(string(html), -1) {
+		if input[1] == "file" {
+			part, err := mw.CreateFormFile(input[2], "photo.jpg")
+			if err != nil {
+				t.Fatal(err)
+			}
+			part.Write(file)
+			continue
+		}
+		if err := mw.WriteField(input[2], placeholders.Replace(input[3])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mw.Close()
+	return buf.Bytes(), mw.FormDataContentType()
+}
+
+// defaultPostPolicyConditions are the conditions of the fields of testdata/post_policy_form.html
+func defaultPostPolicyConditions() []any {
+	return []any{
+		map[string]string{"bucket": "bucket"},
+		[]any{"starts-with", "$key", "user/user1/"},
+		map[string]string{"acl": "public-read"},
+		map[string]string{"success_action_status": "201"},
+		[]any{"starts-with", "$Content-Type", "image/"},
+		map[string]string{"x-amz-meta-uuid": "14365123651274"},
+		[]any{"content-length-range", 1, 1024},
+	}
+}
+
+func postForm(t *testing.T, h *iamtest.Harness, path string, body []byte, contentType string) (*http.Response, []byte) {
+	t.Helper()
+	r, err := http.NewRequest(http.MethodPost, h.Server.URL+path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", contentType)
+	res, err := h.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	resBody, _ := io.ReadAll(res.Body)
+	return res, resBody
+}
+
+// readForm decodes a multipart form, including the fields after the file
+func readForm(t *testing.T, contentType string, body []byte) map[string]string {
+	t.Helper()
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]string{}
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return fields
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, _ := io.ReadAll(part)
+		fields[strings.ToLower(part.FormName())] = string(value)
+	}
+}
+
+func TestPostPolicyUploadForwardedUntouched(t *testing.T) {
+	h := newS3Harness(t)
+	body, contentType := newPostPolicyForm(t, time.Now(), defaultPostPolicyConditions(), []byte("jpeg bytes"))
+
+	res, resBody := postForm(t, h, "/bucket", body, contentType)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got %d %s", res.StatusCode, resBody)
+	}
+	requests := h.Origin.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("origin received %d requests", len(requests))
+	}
+	if !bytes.Equal(requests[0].Body, body) {
+		t.Errorf("origin received a different form:\n%s", requests[0].Body)
+	}
+	if got := requests[0].Header.Get("Authorization"); got != "" {
+		t.Errorf("form upload was signed with Authorization %q", got)
+	}
+}
+
+func TestPostPolicyConditions(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		conditions func() []any
+		file       []byte
+		wantStatus int
+		wantCode   string
+		wantReason http_server.RejectionReason
+	}{
+		{
+			name:       "other bucket",
+			path:       "/other-bucket",
+			conditions: defaultPostPolicyConditions,
+			file:       []byte("jpeg bytes"),
+			wantStatus: http.StatusForbidden,
+			wantCode:   "AccessDenied",
+			wantReason: http_server.RejectionPostPolicyCondition,
+		},
+		{
+			name: "key outside of the prefix",
+			path: "/bucket",
+			conditions: func() []any {
+				conditions := defaultPostPolicyConditions()
+				conditions[1] = []any{"starts-with", "$key", "user/user2/"}
+				return conditions
+			},
+			file:       []byte("jpeg bytes"),
+			wantStatus: http.StatusForbidden,
+			wantCode:   "AccessDenied",
+			wantReason: http_server.RejectionPostPolicyCondition,
+		},
+		{
+			name: "field doesn't match",
+			path: "/bucket",
+			conditions: func() []any {
+				return append(defaultPostPolicyConditions(), []any{"eq", "$acl", "private"})
+			},
+			file:       []byte("jpeg bytes"),
+			wantStatus: http.StatusForbidden,
+			wantCode:   "AccessDenied",
+			wantReason: http_server.RejectionPostPolicyCondition,
+		},
+		{
+			name:       "file too large",
+			path:       "/bucket",
+			conditions: defaultPostPolicyConditions,
+			file:       bytes.Repeat([]byte("j"), 1025),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "EntityTooLarge",
+			wantReason: http_server.RejectionBodyTooLarge,
+		},
+		{
+			name:       "empty file",
+			path:       "/bucket",
+			conditions: defaultPostPolicyConditions,
+			file:       []byte{},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "EntityTooSmall",
+			wantReason: http_server.RejectionPostPolicyCondition,
+		},
+		{
+			name:       "file of the maximum size",
+			path:       "/bucket",
+			conditions: defaultPostPolicyConditions,
+			file:       bytes.Repeat([]byte("j"), 1024),
+			wantStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newS3Harness(t)
+			body, contentType := newPostPolicyForm(t, time.Now(), tt.conditions(), tt.file)
+
+			res, resBody := postForm(t, h, tt.path, body, contentType)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got %d %s, want %d", res.StatusCode, resBody, tt.wantStatus)
+			}
+			if tt.wantCode == "" {
+				return
+			}
+			if !strings.Contains(string(resBody), "<Code>"+tt.wantCode+"</Code>") {
+				t.Errorf("got %s, want a %s error", resBody, tt.wantCode)
+			}
+			if got := res.Header.Get(http_server.RejectReasonHeader); got != string(tt.wantReason) {
+				t.Errorf("got rejection reason %q, want %q", got, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestPostPolicyExpired(t *testing.T) {
+	h := newS3Harness(t)
+	body, contentType := newPostPolicyForm(t, time.Now().Add(-2*time.Hour), defaultPostPolicyConditions(), []byte("jpeg bytes"))
+
+	res, resBody := postForm(t, h, "/bucket", body, contentType)
+	if res.StatusCode != http.StatusForbidden {
+		t.Fatalf("got %d %s", res.StatusCode, resBody)
+	}
+	if got := res.Header.Get(http_server.RejectReasonHeader); got != string(http_server.RejectionExpired) {
+		t.Errorf("got rejection reason %q", got)
+	}
+	if n := len(h.Origin.Requests()); n != 0 {
+		t.Errorf("origin received %d requests", n)
+	}
+}
+
+// Clients sign forms with keys the origin doesn't know, so the form is re-signed with the outbound credentials
+func TestPostPolicyResignedWithOutboundCredentials(t *testing.T) {
+	h := newS3Harness(t)
+	h.Proxy.OutboundCredentials = http_server.StaticOutboundCredentials{
+		AccessKeyID:     "AKIAORIGIN",
+		SecretAccessKey: "origin_secret",
+		SessionToken:    "origin_token",
+	}
+	file := []byte("jpeg bytes")
+	body, contentType := newPostPolicyForm(t, time.Now(), defaultPostPolicyConditions(), file)
+
+	res, resBody := postForm(t, h, "/bucket", body, contentType)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got %d %s", res.StatusCode, resBody)
+	}
+	requests := h.Origin.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("origin received %d requests", len(requests))
+	}
+	received := requests[0]
+	fields := readForm(t, received.Header.Get("Content-Type"), received.Body)
+
+	if !strings.HasPrefix(fields["x-amz-credential"], "AKIAORIGIN/") {
+		t.Errorf("got x-amz-credential %q, want the outbound key", fields["x-amz-credential"])
+	}
+	if fields["x-amz-security-token"] != "origin_token" {
+		t.Errorf("got x-amz-security-token %q", fields["x-amz-security-token"])
+	}
+	date := fields["x-amz-date"]
+	if want := signPostPolicy("origin_secret", date[:min(len(date), 8)], fields["policy"]); fields["x-amz-signature"] != want {
+		t.Errorf("policy isn't signed with the outbound secret")
+	}
+
+	document, err := base64.StdEncoding.DecodeString(fields["policy"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`{"x-amz-credential":"` + fields["x-amz-credential"] + `"}`,
+		`{"x-amz-security-token":"origin_token"}`,
+		`["starts-with","$key","user/user1/"]`,
+		`["content-length-range",1,1024]`,
+	} {
+		if !strings.Contains(string(document), want) {
+			t.Errorf("re-signed policy %s is missing %s", document, want)
+		}
+	}
+	if strings.Contains(string(document), iamtest.KeyID) {
+		t.Errorf("re-signed policy %s still has the client's key", document)
+	}
+
+	// Everything but the signing fields is forwarded as sent
+	for _, field := range []string{"key", "acl", "content-type", "x-amz-meta-uuid", "submit"} {
+		if fields[field] == "" {
+			t.Errorf("missing field %s", field)
+		}
+	}
+	if fields["file"] != string(file) {
+		t.Errorf("got file %q", fields["file"])
+	}
+}
+
+// Uploads are authorized against the key in the form, not just the bucket they are posted to
+func TestPostPolicyAuthorizesKey(t *testing.T) {
+	for resource, wantStatus := range map[string]int{
+		"arn:aws:s3:::bucket/user/user1/*": http.StatusOK,
+		"arn:aws:s3:::bucket/user/user2/*": http.StatusForbidden,
+	} {
+		h := newS3Harness(t)
+		h.Proxy.PolicyLookupFunc = http_server.StaticPolicies(map[string][]http_server.PolicyDocument{
+			iamtest.KeyID: {{Statement: []http_server.PolicyStatement{{
+				Effect:   http_server.PolicyAllow,
+				Action:   http_server.PolicyStringList{"s3:PostObject"},
+				Resource: http_server.PolicyStringList{resource},
+			}}}},
+		})
+		body, contentType := newPostPolicyForm(t, time.Now(), defaultPostPolicyConditions(), []byte("jpeg bytes"))
+
+		res, resBody := postForm(t, h, "/bucket", body, contentType)
+		if res.StatusCode != wantStatus {
+			t.Errorf("allowing %s got %d %s, want %d", resource, res.StatusCode, resBody, wantStatus)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package http_server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// S3Provider is the AWSServiceProvider for S3.
+// Browser-based POST policy uploads are verified by the proxy, which enforces the conditions of the policy,
+// and their multipart body is streamed to S3. With OutboundCredentials, the fields are re-signed for the
+// origin's key, otherwise the form is forwarded untouched since S3 verifies the policy signature itself.
+type S3Provider struct {
+	*BaseAWSProvider
+	OperationRouter
+
+	// BucketOrigins optionally routes buckets to different backends (e.g. one bucket on AWS, another on MinIO).
+	// Buckets it returns ErrKeyNotFound for go to the default origin.
+	BucketOrigins LookupProvider[string, Origin]
+}
+
+// Origin is a backend that S3 requests can be routed to
+type Origin struct {
+	// Host of the backend, which may include a scheme (e.g. http://minio:9000)
+	Host string
+	// Region optionally re-signs the request for a different region than the client signed
+	Region string
+	// PathStyle sends virtual-hosted requests to the backend path-style (/bucket/key), since the
+	// bucket is no longer in the host
+	PathStyle bool
+}
+
+func NewS3Provider() *S3Provider {
+	return &S3Provider{
+		BaseAWSProvider: NewBaseAWSProvider("s3"),
+	}
+}
+
+// S3Request is the bucket and key that an S3 request addresses
+type S3Request struct {
+	Bucket string
+	Key    string
+	// VirtualHosted is whether the bucket was in the host (bucket.s3.amazonaws.com) rather than the path
+	VirtualHosted bool
+}
+
+// ParseS3Request extracts the bucket and key from both path-style and virtual-hosted-style requests
+func ParseS3Request(request *ProxiedRequest) S3Request {
+	host := request.Request.Host
+	if host == "" {
+		host = request.Request.URL.Host
+	}
+	path := strings.TrimPrefix(request.Request.URL.Path, "/")
+
+	// Virtual-hosted style looks like bucket.s3.amazonaws.com or bucket.s3.us-east-1.amazonaws.com
+	if i := strings.Index(host, ".s3."); i > 0 {
+		return S3Request{
+			Bucket:        host[:i],
+			Key:           path,
+			VirtualHosted: true,
+		}
+	}
+
+	bucket, key, _ := strings.Cut(path, "/")
+	return S3Request{
+		Bucket: bucket,
+		Key:    key,
+	}
+}
+
+// ExtractOperationName classifies the request as an S3 API operation, e.g. "GetObject" or "UploadPart",
+// by its method, whether it addresses a bucket or an object, and its subresource query parameters.
+// Returns OperationUnknown if the request could not be classified.
+func (p *S3Provider) ExtractOperationName(request *ProxiedRequest) string {
+	s3Req := ParseS3Request(request)
+	query := request.Request.URL.Query()
+
+	if s3Req.Bucket == "" {
+		if request.Request.Method == http.MethodGet {
+			return "ListBuckets"
+		}
+		return OperationUnknown
+	}
+
+	copySource := request.Request.Header.Get("x-amz-copy-source") != ""
+	method := request.Request.Method
+	if s3Req.Key == "" {
+		if operation, ok := classifyS3Subresource(s3BucketSubresources, method, query.Has); ok {
+			return operation
+		}
+		switch method {
+		case http.MethodGet:
+			if query.Get("list-type") == "2" {
+				return "ListObjectsV2"
+			}
+			return "ListObjects"
+		case http.MethodHead:
+			return "HeadBucket"
+		case http.MethodPut:
+			return "CreateBucket"
+		case http.MethodDelete:
+			return "DeleteBucket"
+		case http.MethodPost:
+			if request.IsPostPolicyUpload() {
+				return "PostObject"
+			}
+		}
+		return OperationUnknown
+	}
+
+	if query.Has("uploadId") && method == http.MethodPut && copySource {
+		return "UploadPartCopy"
+	}
+	if operation, ok := classifyS3Subresource(s3ObjectSubresources, method, query.Has); ok {
+		return operation
+	}
+	switch method {
+	case http.MethodGet:
+		return "GetObject"
+	case http.MethodHead:
+		return "HeadObject"
+	case http.MethodPut:
+		if copySource {
+			return "CopyObject"
+		}
+		return "PutObject"
+	case http.MethodDelete:
+		return "DeleteObject"
+	}
+	return OperationUnknown
+}
+
+// ExtractResources returns the bucket (arn:aws:s3:::bucket) or object (arn:aws:s3:::bucket/key) of the request,
+// which for POST policy uploads is the key field of the form.
+// The source object of CopyObject and UploadPartCopy is authorized separately, see ExtractResourceOperations.
+func (p *S3Provider) ExtractResources(request *ProxiedRequest) ([]string, error) {
+	s3Req := ParseS3Request(request)
+	if request.IsPostPolicyUpload() && s3Req.Key == "" {
+		s3Req.Key = request.PostPolicy.Fields["key"]
+	}
+	switch {
+	case s3Req.Bucket == "":
+		return nil, nil
+	case s3Req.Key == "":
+		return []string{"arn:aws:s3:::" + s3Req.Bucket}, nil
+	}
+	return []string{"arn:aws:s3:::" + s3Req.Bucket + "/" + s3Req.Key}, nil
+}
+
+// ExtractResourceOperations authorizes the source object of CopyObject and UploadPartCopy as GetObject, so
+// copying can't read objects the key isn't allowed to get. The destination is still authorized as the copy,
+// even if it is the source (an in-place copy replacing the metadata of an object).
+func (p *S3Provider) ExtractResourceOperations(request *ProxiedRequest) ([]ResourceOperation, error) {
+	if operation := p.ExtractOperationName(request); operation != "CopyObject" && operation != "UploadPartCopy" {
+		return nil, nil
+	}
+	source, err := copySourceARN(request.Request.Header.Get("x-amz-copy-source"))
+	if err != nil {
+		return nil, fmt.Errorf("error in copySourceARN: %w", err)
+	}
+	return []ResourceOperation{{Resource: source, Operation: "GetObject"}}, nil
+}
+
+// copySourceARN is the ARN of the object in x-amz-copy-source, which is a URL-encoded bucket/key (with an
+// optional leading / and ?versionId=), or an access point object ARN
+func copySourceARN(copySource string) (string, error) {
+	copySource, _, _ = strings.Cut(copySource, "?versionId=")
+	source, err := url.PathUnescape(copySource)
+	if err != nil {
+		return "", fmt.Errorf("error in url.PathUnescape: %w", err)
+	}
+	if strings.HasPrefix(source, "arn:") {
+		return source, nil
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	if bucket == "" || key == "" {
+		return "", fmt.Errorf("copy source %q is not a bucket and key", copySource)
+	}
+	return "arn:aws:s3:::" + bucket + "/" + key, nil
+}
+
+// HandleRequest dispatches to the registered operation handler, or proxies to S3 if there is none
+func (p *S3Provider) HandleRequest(ctx context.Context, request *ProxiedRequest) (*http.Response, error) {
+	return p.dispatch(ctx, p.ExtractOperationName(request), request, p.proxyToBucketOrigin)
+}
+
+// proxyToBucketOrigin proxies the request to the origin of its bucket, or the default origin
+func (p *S3Provider) proxyToBucketOrigin(ctx context.Context, request *ProxiedRequest) (*http.Response, error) {
+	s3Req := ParseS3Request(request)
+	if p.BucketOrigins == nil || s3Req.Bucket == "" {
+		return p.proxyToDefaultOrigin(ctx, request, s3Req)
+	}
+
+	origin, err := p.BucketOrigins.Lookup(ctx, s3Req.Bucket)
+	if errors.Is(err, ErrKeyNotFound) {
+		return p.proxyToDefaultOrigin(ctx, request, s3Req)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error in BucketOrigins.Lookup: %w", err)
+	}
+
+	if origin.Region != "" {
+		request.Region = origin.Region
+		request.parsedHeader.Credential.Region = origin.Region
+	}
+	if origin.PathStyle {
+		toPathStyle(request, s3Req)
+	}
+	return request.DoProxiedRequest(ctx, origin.Host)
+}
+
+// proxyToDefaultOrigin proxies the request to the origin of BaseAWSProvider. Its host doesn't have the bucket
+// in it, so virtual-hosted requests are sent path-style, otherwise the origin would take the bucket from the
+// start of the path rather than the host that was authorized.
+func (p *S3Provider) proxyToDefaultOrigin(ctx context.Context, request *ProxiedRequest, s3Req S3Request) (*http.Response, error) {
+	toPathStyle(request, s3Req)
+	return p.BaseAWSProvider.HandleRequest(ctx, request)
+}
+
+// toPathStyle moves the bucket of a virtual-hosted request to the start of its path (/bucket/key)
+func toPathStyle(request *ProxiedRequest, s3Req S3Request) {
+	if !s3Req.VirtualHosted {
+		return
+	}
+	request.Request.URL.Path = "/" + s3Req.Bucket + request.Request.URL.Path
+	if request.Request.URL.RawPath != "" {
+		request.Request.URL.RawPath = "/" + url.PathEscape(s3Req.Bucket) + request.Request.URL.RawPath
+	}
+}
@@ -0,0 +1,123 @@
+package http_server_test
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+// newSignedS3Request is a request to the harness at path, signed for host rather than the harness' address
+func newSignedS3Request(h *iamtest.Harness, method, host, path string) *http.Request {
+	r, err := http.NewRequest(method, h.Server.URL+path, nil)
+	if err != nil {
+		panic(err)
+	}
+	r.Host = host
+	http_server.SignRequest(r, iamtest.KeyID, iamtest.KeySecret, iamtest.Region, "s3", time.Now())
+	return r
+}
+
+// The bucket of a virtual-hosted request is the one authorized, so it must be the one the origin receives,
+// rather than whatever the path starts with
+func TestS3VirtualHostedAuthorizesTheBucketSentToTheOrigin(t *testing.T) {
+	h := newS3Harness(t)
+	h.Proxy.PolicyLookupFunc = http_server.StaticPolicies(map[string][]http_server.PolicyDocument{
+		iamtest.KeyID: {{Statement: []http_server.PolicyStatement{
+			{Effect: http_server.PolicyAllow, Action: http_server.PolicyStringList{"s3:*"}, Resource: http_server.PolicyStringList{"arn:aws:s3:::allowed/*"}},
+		}}},
+	})
+
+	tests := []struct {
+		name     string
+		host     string
+		path     string
+		wantPath string
+	}{
+		{name: "virtual-hosted allowed bucket", host: "allowed.s3.example.com", path: "/secret/key", wantPath: "/allowed/secret/key"},
+		{name: "path-style allowed bucket", host: "s3.example.com", path: "/allowed/secret/key", wantPath: "/allowed/secret/key"},
+		{name: "path-style other bucket", host: "s3.example.com", path: "/secret/key"},
+		{name: "virtual-hosted other bucket", host: "secret.s3.example.com", path: "/allowed/key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(h.Origin.Requests())
+			res, err := h.Do(newSignedS3Request(h, http.MethodGet, tt.host, tt.path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			requests := h.Origin.Requests()[before:]
+
+			if tt.wantPath == "" {
+				if res.StatusCode != http.StatusForbidden || len(requests) != 0 {
+					t.Fatalf("got %d %s and %d origin requests, want it denied", res.StatusCode, body, len(requests))
+				}
+				return
+			}
+			if res.StatusCode != http.StatusOK || len(requests) != 1 {
+				t.Fatalf("got %d %s and %d origin requests", res.StatusCode, body, len(requests))
+			}
+			if requests[0].Path != tt.wantPath {
+				t.Errorf("origin received %s, want %s", requests[0].Path, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestS3BucketOrigins(t *testing.T) {
+	minio := iamtest.NewFakeOrigin()
+	t.Cleanup(minio.Close)
+	h := iamtest.NewHarness(func(originURL string) http_server.AWSServiceProvider {
+		p := http_server.NewS3Provider()
+		p.OriginHost = originURL
+		p.BucketOrigins = iamtest.MapLookupFunc(map[string]http_server.Origin{
+			"on-minio": {Host: minio.URL, PathStyle: true},
+		})
+		return p
+	})
+	t.Cleanup(h.Close)
+
+	tests := []struct {
+		name       string
+		host       string
+		path       string
+		wantOrigin *iamtest.FakeOrigin
+		wantPath   string
+	}{
+		{name: "path-style mapped bucket", host: "s3.example.com", path: "/on-minio/key", wantOrigin: minio, wantPath: "/on-minio/key"},
+		{name: "virtual-hosted mapped bucket", host: "on-minio.s3.example.com", path: "/key", wantOrigin: minio, wantPath: "/on-minio/key"},
+		{name: "path-style unmapped bucket", host: "s3.example.com", path: "/on-aws/key", wantOrigin: h.Origin, wantPath: "/on-aws/key"},
+		{name: "virtual-hosted unmapped bucket", host: "on-aws.s3.example.com", path: "/key", wantOrigin: h.Origin, wantPath: "/on-aws/key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			beforeDefault, beforeMinio := len(h.Origin.Requests()), len(minio.Requests())
+			res, err := h.Do(newSignedS3Request(h, http.MethodGet, tt.host, tt.path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("got %d %s", res.StatusCode, body)
+			}
+
+			defaultRequests, minioRequests := h.Origin.Requests()[beforeDefault:], minio.Requests()[beforeMinio:]
+			requests, otherRequests := defaultRequests, minioRequests
+			if tt.wantOrigin == minio {
+				requests, otherRequests = minioRequests, defaultRequests
+			}
+			if len(requests) != 1 || len(otherRequests) != 0 {
+				t.Fatalf("got %d requests at the bucket's origin and %d at the other", len(requests), len(otherRequests))
+			}
+			if requests[0].Path != tt.wantPath {
+				t.Errorf("origin received %s, want %s", requests[0].Path, tt.wantPath)
+			}
+		})
+	}
+}
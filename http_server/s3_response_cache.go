@@ -0,0 +1,283 @@
+package http_server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+const (
+	// DefaultS3CacheTTL is the default S3ResponseCache.TTL
+	DefaultS3CacheTTL = time.Minute
+	// DefaultS3CacheMaxObjectBytes is the default S3ResponseCache.MaxObjectBytes
+	DefaultS3CacheMaxObjectBytes = 8 * 1024 * 1024
+
+	// CacheStatusHeader tells the client whether the response was served from the S3ResponseCache
+	CacheStatusHeader = "x-iam-cache"
+)
+
+// s3CacheInvalidatingOperations replace or remove the object, so its cached response is deleted when they succeed
+var s3CacheInvalidatingOperations = []string{"PutObject", "CopyObject", "DeleteObject", "CompleteMultipartUpload", "PutObjectTagging", "PutObjectAcl", "RestoreObject"}
+
+// S3ResponseCache caches whole GetObject responses of hot objects at the proxy.
+// Install it with S3Provider.Use(cache.Middleware).
+//
+// Only complete 200 responses up to MaxObjectBytes are stored. Range requests for a single range and
+// HeadObject are answered from a cached object, and an If-None-Match matching its ETag gets a 304.
+// Other conditional requests, part number and SSE-C requests bypass the cache.
+// Cache-Control is respected both ways: the client can skip (no-cache) or bypass (no-store) the cache,
+// and origin responses are stored for no longer than their max-age, and not at all if no-store or private.
+// Writes through the proxy (e.g. PutObject) delete the cached object (of the writing key, unless
+// SharedAcrossKeys), but other writes are only seen once the TTL expires.
+type S3ResponseCache struct {
+	Cache Cache
+	// TTL defaults to DefaultS3CacheTTL
+	TTL time.Duration
+	// MaxObjectBytes defaults to DefaultS3CacheMaxObjectBytes
+	MaxObjectBytes int64
+	// SharedAcrossKeys serves an object cached for one key id to other keys. Only enable it if every key
+	// may read every cached bucket, since the origin authorizes each key separately.
+	SharedAcrossKeys bool
+}
+
+type cachedS3Object struct {
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+func (c *S3ResponseCache) ttl() time.Duration {
+	return lo.Ternary(c.TTL == 0, DefaultS3CacheTTL, c.TTL)
+}
+
+func (c *S3ResponseCache) maxObjectBytes() int64 {
+	return lo.Ternary(c.MaxObjectBytes == 0, DefaultS3CacheMaxObjectBytes, c.MaxObjectBytes)
+}
+
+// key identifies the object version, and the key id unless SharedAcrossKeys
+func (c *S3ResponseCache) key(request *ProxiedRequest) string {
+	s3Req := ParseS3Request(request)
+	keyID := lo.Ternary(c.SharedAcrossKeys, "", request.KeyID)
+	return strings.Join([]string{"s3", keyID, s3Req.Bucket, s3Req.Key, request.Request.URL.Query().Get("versionId")}, "\x00")
+}
+
+// Middleware serves GetObject and HeadObject from the cache, and invalidates it on writes
+func (c *S3ResponseCache) Middleware(next OperationHandler) OperationHandler {
+	return func(ctx context.Context, request *ProxiedRequest) (*http.Response, error) {
+		switch {
+		case lo.Contains(s3CacheInvalidatingOperations, request.Operation):
+			return c.invalidate(ctx, request, next)
+		case request.Operation != "GetObject" && request.Operation != "HeadObject":
+			return next(ctx, request)
+		case !cacheableS3Request(request.Request):
+			return next(ctx, request)
+		}
+
+		key := c.key(request)
+		requestCacheControl := request.Request.Header.Get("Cache-Control")
+		if !hasCacheDirective(requestCacheControl, "no-cache") && !hasCacheDirective(requestCacheControl, "no-store") {
+			if res, ok := c.serveCached(ctx, request, key); ok {
+				request.RecordCacheResult(true)
+				return res, nil
+			}
+		}
+		request.RecordCacheResult(false)
+
+		res, err := next(ctx, request)
+		if err != nil || request.Operation != "GetObject" || hasCacheDirective(requestCacheControl, "no-store") ||
+			request.Request.Header.Get("Range") != "" {
+			return res, err
+		}
+		return c.store(ctx, key, res)
+	}
+}
+
+// cacheableS3Request is whether the cache can answer the request. Single ranges are served from the
+// cached object, anything else that changes the response (besides If-None-Match) bypasses the cache.
+func cacheableS3Request(r *http.Request) bool {
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" && strings.Contains(rangeHeader, ",") {
+		return false
+	}
+	for _, header := range []string{"If-Match", "If-Modified-Since", "If-Unmodified-Since", "x-amz-server-side-encryption-customer-key"} {
+		if r.Header.Get(header) != "" {
+			return false
+		}
+	}
+	query := r.URL.Query()
+	for _, param := range []string{"partNumber", "response-content-type", "response-content-disposition", "response-cache-control"} {
+		if query.Has(param) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *S3ResponseCache) serveCached(ctx context.Context, request *ProxiedRequest, key string) (*http.Response, bool) {
+	value, err := c.Cache.Get(ctx, key)
+	if err != nil {
+		if !errors.Is(err, ErrKeyNotFound) {
+			logger.Warn().Err(err).Msg("error getting cached s3 object, fetching from origin")
+		}
+		return nil, false
+	}
+	var cached cachedS3Object
+	if err = json.Unmarshal(value, &cached); err != nil {
+		logger.Warn().Err(err).Msg("error decoding cached s3 object, fetching from origin")
+		return nil, false
+	}
+
+	header := cached.Header.Clone()
+	header.Set(CacheStatusHeader, "hit")
+	body := cached.Body
+	statusCode := http.StatusOK
+	if etag := header.Get("ETag"); etag != "" && etagMatches(request.Request.Header.Get("If-None-Match"), etag) {
+		statusCode, body = http.StatusNotModified, nil
+		header.Del("Content-Length")
+	} else if rangeHeader := request.Request.Header.Get("Range"); rangeHeader != "" {
+		start, end, ok := parseByteRange(rangeHeader, int64(len(body)))
+		if !ok {
+			// Let the origin render the InvalidRange error
+			return nil, false
+		}
+		statusCode = http.StatusPartialContent
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(body)))
+		body = body[start : end+1]
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+
+	contentLength := int64(len(body))
+	if request.Request.Method == http.MethodHead {
+		body = nil
+	}
+	return &http.Response{
+		StatusCode:    statusCode,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: contentLength,
+	}, true
+}
+
+// store buffers and caches complete, cacheable responses, returning a response with the buffered body
+func (c *S3ResponseCache) store(ctx context.Context, key string, res *http.Response) (*http.Response, error) {
+	ttl := c.ttl()
+	responseCacheControl := res.Header.Get("Cache-Control")
+	if maxAge, ok := cacheMaxAge(responseCacheControl); ok {
+		ttl = min(ttl, maxAge)
+	}
+	if res.StatusCode != http.StatusOK || res.ContentLength < 0 || res.ContentLength > c.maxObjectBytes() || ttl <= 0 ||
+		hasCacheDirective(responseCacheControl, "no-store") || hasCacheDirective(responseCacheControl, "private") {
+		return res, nil
+	}
+
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, res.ContentLength))
+	if err != nil {
+		return nil, fmt.Errorf("error reading cacheable response body: %w", err)
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+
+	value, err := json.Marshal(cachedS3Object{Header: res.Header.Clone(), Body: body})
+	if err != nil {
+		return nil, fmt.Errorf("error in json.Marshal: %w", err)
+	}
+	if err = c.Cache.Set(ctx, key, value, ttl); err != nil {
+		logger.Warn().Err(err).Msg("error caching s3 object")
+	}
+	res.Header.Set(CacheStatusHeader, "miss")
+	return res, nil
+}
+
+// invalidate deletes the cached object once a write to it succeeds
+func (c *S3ResponseCache) invalidate(ctx context.Context, request *ProxiedRequest, next OperationHandler) (*http.Response, error) {
+	res, err := next(ctx, request)
+	if err != nil || res.StatusCode >= 300 {
+		return res, err
+	}
+	if err := c.Cache.Delete(ctx, c.key(request)); err != nil {
+		logger.Warn().Err(err).Msg("error invalidating cached s3 object")
+	}
+	return res, nil
+}
+
+// hasCacheDirective is whether a Cache-Control header has the directive, e.g. no-store
+func hasCacheDirective(cacheControl, directive string) bool {
+	for _, part := range strings.Split(cacheControl, ",") {
+		name, _, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if strings.EqualFold(name, directive) {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheMaxAge gets the max-age of a Cache-Control header
+func cacheMaxAge(cacheControl string) (time.Duration, bool) {
+	for _, part := range strings.Split(cacheControl, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if !strings.EqualFold(name, "max-age") {
+			continue
+		}
+		seconds, err := strconv.Atoi(strings.Trim(value, `"`))
+		if err != nil {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	return 0, false
+}
+
+// etagMatches is whether an If-None-Match header lists the etag (or is *)
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// parseByteRange parses a single range (bytes=0-99, bytes=100-, or bytes=-100) of an object of size bytes
+// into inclusive offsets
+func parseByteRange(rangeHeader string, size int64) (start, end int64, ok bool) {
+	spec, found := strings.CutPrefix(rangeHeader, "bytes=")
+	if !found {
+		return 0, 0, false
+	}
+	first, last, found := strings.Cut(spec, "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	var err error
+	switch {
+	case first == "":
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix <= 0 {
+			return 0, 0, false
+		}
+		return max(size-suffix, 0), size - 1, size > 0
+	case last == "":
+		end = size - 1
+	default:
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	if start, err = strconv.ParseInt(first, 10, 64); err != nil || start > end {
+		return 0, 0, false
+	}
+	return start, end, true
+}
@@ -0,0 +1,428 @@
+package http_server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+var ErrRotationUnsupported = errors.New("secret provider does not support rotation")
+
+// errVaultCheckAndSet is a write rejected by Vault, which for the check-and-set writes of VaultSecretProvider
+// means another write got there first
+var errVaultCheckAndSet = errors.New("vault rejected the write")
+
+// DefaultRotationGracePeriod is how long VaultSecretProvider accepts the previous secret of a key after a rotation
+const DefaultRotationGracePeriod = 24 * time.Hour
+
+// Secret is a version of a key's secret
+type Secret struct {
+	Value   string
+	Version string
+}
+
+// SecretProvider is a source of key secrets with rotation, e.g. an encrypted-at-rest store like Vault or
+// KMS-wrapped secrets, rather than raw secrets in a generic LookupProvider.
+type SecretProvider interface {
+	// Secrets returns the secrets a request for the key may be signed with: the current version first,
+	// then any previous versions still accepted during a rotation. Unknown keys return ErrKeyNotFound.
+	Secrets(ctx context.Context, keyID string) ([]Secret, error)
+	// Rotate makes secret the current version of the key, returning the new version
+	Rotate(ctx context.Context, keyID, secret string) (version string, err error)
+}
+
+// LookupSecretProvider adapts a LookupFunc of key id to secret (e.g. AWSProxy.KeyLookupFunc) to a SecretProvider,
+// with a single unversioned secret and no rotation
+type LookupSecretProvider struct {
+	Lookup LookupFunc[string, string]
+}
+
+func (p LookupSecretProvider) Secrets(ctx context.Context, keyID string) ([]Secret, error) {
+	secret, err := p.Lookup(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	return []Secret{{Value: secret}}, nil
+}
+
+func (LookupSecretProvider) Rotate(context.Context, string, string) (string, error) {
+	return "", ErrRotationUnsupported
+}
+
+// EnvSecretProvider reads secrets from the environment: <Prefix><KEY_ID> is the current secret, and
+// <Prefix><KEY_ID>_PREVIOUS is accepted during a rotation. Key ids are upper cased.
+type EnvSecretProvider struct {
+	Prefix string
+}
+
+func (p EnvSecretProvider) Secrets(_ context.Context, keyID string) ([]Secret, error) {
+	name := p.Prefix + strings.ToUpper(keyID)
+	current, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	secrets := []Secret{{Value: current, Version: "current"}}
+	if previous, ok := os.LookupEnv(name + "_PREVIOUS"); ok {
+		secrets = append(secrets, Secret{Value: previous, Version: "previous"})
+	}
+	return secrets, nil
+}
+
+func (EnvSecretProvider) Rotate(context.Context, string, string) (string, error) {
+	return "", ErrRotationUnsupported
+}
+
+// VaultSecretProvider reads secrets from a Vault KV v2 engine at <Mount>/data/<Path>/<key id>,
+// in the Field of the secret. The previous version is accepted for RotationGracePeriod after the current one
+// was created. Secrets and their metadata are cached locally for CacheTTL, and unknown keys for NegativeTTL.
+//
+// It is a KeyIssuer, keeping the KeyMetadata of the keys it mints as JSON in the MetadataField next to the
+// secret, so every version carries it. Keys written to Vault directly have no metadata, and are never disabled.
+type VaultSecretProvider struct {
+	// Address of Vault, e.g. https://vault:8200
+	Address string
+	Token   string
+	// Mount of the KV v2 engine, defaults to "secret"
+	Mount string
+	Path  string
+	// Field of the secret data with the key secret, defaults to "secret"
+	Field string
+	// MetadataField of the secret data with the KeyMetadata, defaults to "metadata"
+	MetadataField string
+	// HTTPClient defaults to http.DefaultClient
+	HTTPClient *http.Client
+	// RotationGracePeriod defaults to DefaultRotationGracePeriod
+	RotationGracePeriod time.Duration
+	// CacheTTL caches the secrets of found keys, 0 disables it. Rotate and DisableKey drop the cached secrets
+	// of the key, other instances keep using theirs until they expire.
+	CacheTTL time.Duration
+	// NegativeTTL caches unknown keys, 0 disables it
+	NegativeTTL time.Duration
+	// MaxCacheEntries bounds the local cache, defaults to DefaultLookupMaxCacheEntries
+	MaxCacheEntries int
+	// Clock defaults to RealClock
+	Clock Clock
+
+	cache lookupCache[vaultSecrets]
+}
+
+// vaultSecrets are the secrets of a key, when its current version was created, and its metadata
+type vaultSecrets struct {
+	secrets   []Secret
+	rotatedAt time.Time
+	metadata  KeyMetadata
+}
+
+type vaultKVResponse struct {
+	Data struct {
+		Data     map[string]string `json:"data"`
+		Metadata struct {
+			Version     int       `json:"version"`
+			CreatedTime time.Time `json:"created_time"`
+		} `json:"metadata"`
+		// Version is set in the response to writes
+		Version int `json:"version"`
+	} `json:"data"`
+}
+
+type vaultListResponse struct {
+	Data struct {
+		Keys []string `json:"keys"`
+	} `json:"data"`
+}
+
+func (p *VaultSecretProvider) url(keyID string) string {
+	return p.engineURL("data") + "/" + url.PathEscape(keyID)
+}
+
+// engineURL is the url of the Path in the KV v2 API, e.g. data or metadata
+func (p *VaultSecretProvider) engineURL(api string) string {
+	mount := p.Mount
+	if mount == "" {
+		mount = "secret"
+	}
+	return strings.TrimSuffix(p.Address, "/") + "/v1/" + mount + "/" + api + "/" + strings.Trim(p.Path, "/")
+}
+
+func (p *VaultSecretProvider) field() string {
+	if p.Field == "" {
+		return "secret"
+	}
+	return p.Field
+}
+
+func (p *VaultSecretProvider) metadataField() string {
+	if p.MetadataField == "" {
+		return "metadata"
+	}
+	return p.MetadataField
+}
+
+// keyMetadata decodes the metadata stored next to the secret, empty for keys not minted by CreateKey
+func (p *VaultSecretProvider) keyMetadata(kv *vaultKVResponse) (KeyMetadata, error) {
+	var metadata KeyMetadata
+	raw, ok := kv.Data.Data[p.metadataField()]
+	if !ok {
+		return metadata, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &metadata); err != nil {
+		return metadata, fmt.Errorf("error decoding key metadata: %w", err)
+	}
+	return metadata, nil
+}
+
+// write stores a new version of the key, which must replace version cas (0 for a new key).
+// metadata is the JSON KeyMetadata, if any.
+func (p *VaultSecretProvider) write(ctx context.Context, keyID, secret, metadata string, cas int) (*vaultKVResponse, error) {
+	data := map[string]string{p.field(): secret}
+	if metadata != "" {
+		data[p.metadataField()] = metadata
+	}
+	res, err := p.do(ctx, http.MethodPost, p.url(keyID), map[string]any{
+		"data":    data,
+		"options": map[string]int{"cas": cas},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error writing secret: %w", err)
+	}
+	p.cache.invalidate(keyID)
+	return res, nil
+}
+
+func (p *VaultSecretProvider) do(ctx context.Context, method, url string, body any) (*vaultKVResponse, error) {
+	var kv vaultKVResponse
+	if err := p.doJSON(ctx, method, url, body, &kv); err != nil {
+		return nil, err
+	}
+	return &kv, nil
+}
+
+// doJSON does a Vault API request, decoding the response into out
+func (p *VaultSecretProvider) doJSON(ctx context.Context, method, url string, body, out any) error {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return fmt.Errorf("error encoding vault request: %w", err)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, &reqBody)
+	if err != nil {
+		return fmt.Errorf("error in http.NewRequestWithContext: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", p.Token)
+
+	client := p.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error in client.Do: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return ErrKeyNotFound
+	}
+	if res.StatusCode == http.StatusBadRequest && method == http.MethodPost {
+		return errVaultCheckAndSet
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault returned status %d", res.StatusCode)
+	}
+	if err = json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("error decoding vault response: %w", err)
+	}
+	return nil
+}
+
+func (p *VaultSecretProvider) rotationGracePeriod() time.Duration {
+	if p.RotationGracePeriod == 0 {
+		return DefaultRotationGracePeriod
+	}
+	return p.RotationGracePeriod
+}
+
+// Secrets returns the current secret of the key, and the previous one if the current one was created within
+// the RotationGracePeriod
+func (p *VaultSecretProvider) Secrets(ctx context.Context, keyID string) ([]Secret, error) {
+	now := clockOrReal(p.Clock).Now()
+	entry, err := p.lookup(ctx, keyID, now)
+	if err != nil {
+		return nil, err
+	}
+	if !entry.found || !entry.value.metadata.usableAt(now) {
+		return nil, ErrKeyNotFound
+	}
+
+	// Cached secrets may outlive the grace period of the previous one
+	secrets := entry.value.secrets
+	if now.Sub(entry.value.rotatedAt) > p.rotationGracePeriod() {
+		secrets = secrets[:1]
+	}
+	return secrets, nil
+}
+
+// lookup gets the secrets and metadata of the key from the cache, or Vault if they aren't cached
+func (p *VaultSecretProvider) lookup(ctx context.Context, keyID string, now time.Time) (lookupCacheEntry[vaultSecrets], error) {
+	entry, ok := p.cache.get(keyID, now)
+	recordLookupCacheResult("vault", ok)
+	if ok {
+		return entry, nil
+	}
+	secrets, err := p.fetchSecrets(ctx, keyID, now)
+	if errors.Is(err, ErrKeyNotFound) {
+		p.cache.put(keyID, lookupCacheEntry[vaultSecrets]{}, now, p.NegativeTTL, p.MaxCacheEntries)
+		return lookupCacheEntry[vaultSecrets]{}, nil
+	}
+	if err != nil {
+		return lookupCacheEntry[vaultSecrets]{}, err
+	}
+	entry = lookupCacheEntry[vaultSecrets]{value: secrets, found: true}
+	p.cache.put(keyID, entry, now, p.CacheTTL, p.MaxCacheEntries)
+	return entry, nil
+}
+
+// fetchSecrets reads the current version of the key, and the previous one if it is still within its grace period
+func (p *VaultSecretProvider) fetchSecrets(ctx context.Context, keyID string, now time.Time) (vaultSecrets, error) {
+	current, err := p.do(ctx, http.MethodGet, p.url(keyID), nil)
+	if errors.Is(err, ErrKeyNotFound) {
+		return vaultSecrets{}, ErrKeyNotFound
+	}
+	if err != nil {
+		return vaultSecrets{}, fmt.Errorf("error getting current secret: %w", err)
+	}
+	metadata, err := p.keyMetadata(current)
+	if err != nil {
+		return vaultSecrets{}, fmt.Errorf("error in keyMetadata: %w", err)
+	}
+	fetched := vaultSecrets{
+		secrets: []Secret{{
+			Value:   current.Data.Data[p.field()],
+			Version: strconv.Itoa(current.Data.Metadata.Version),
+		}},
+		rotatedAt: current.Data.Metadata.CreatedTime,
+		metadata:  metadata,
+	}
+
+	version := current.Data.Metadata.Version - 1
+	if version <= 0 || now.Sub(fetched.rotatedAt) > p.rotationGracePeriod() {
+		return fetched, nil
+	}
+	previous, err := p.do(ctx, http.MethodGet, p.url(keyID)+"?version="+strconv.Itoa(version), nil)
+	// The previous version may have been deleted, which just ends the rotation
+	if err == nil && previous.Data.Data[p.field()] != "" {
+		fetched.secrets = append(fetched.secrets, Secret{
+			Value:   previous.Data.Data[p.field()],
+			Version: strconv.Itoa(version),
+		})
+	}
+	return fetched, nil
+}
+
+// Rotate writes a new version of an existing key, carrying its metadata over
+func (p *VaultSecretProvider) Rotate(ctx context.Context, keyID, secret string) (string, error) {
+	current, err := p.do(ctx, http.MethodGet, p.url(keyID), nil)
+	if err != nil {
+		return "", fmt.Errorf("error getting current secret: %w", err)
+	}
+	res, err := p.write(ctx, keyID, secret, current.Data.Data[p.metadataField()], current.Data.Metadata.Version)
+	if err != nil {
+		return "", fmt.Errorf("error in write: %w", err)
+	}
+	return strconv.Itoa(res.Data.Version), nil
+}
+
+// GetSecret gets the current secret of the key, making VaultSecretProvider a CredentialStore
+func (p *VaultSecretProvider) GetSecret(ctx context.Context, keyID string) (string, error) {
+	current, err := p.do(ctx, http.MethodGet, p.url(keyID), nil)
+	if err != nil {
+		return "", fmt.Errorf("error getting current secret: %w", err)
+	}
+	metadata, err := p.keyMetadata(current)
+	if err != nil {
+		return "", fmt.Errorf("error in keyMetadata: %w", err)
+	}
+	secret, ok := current.Data.Data[p.field()]
+	if !ok || !metadata.usableAt(clockOrReal(p.Clock).Now()) {
+		return "", ErrKeyNotFound
+	}
+	return secret, nil
+}
+
+// CreateKey writes the first version of the key, with its metadata next to the secret
+func (p *VaultSecretProvider) CreateKey(ctx context.Context, keyID, secret string, metadata KeyMetadata) error {
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("error encoding key metadata: %w", err)
+	}
+	_, err = p.write(ctx, keyID, secret, string(encoded), 0)
+	if errors.Is(err, errVaultCheckAndSet) {
+		return ErrKeyExists
+	}
+	if err != nil {
+		return fmt.Errorf("error in write: %w", err)
+	}
+	return nil
+}
+
+// KeyMetadata returns the metadata of the key, which is empty for keys written to Vault directly.
+// It is cached with the secrets, so checking the scope of a verified request doesn't read Vault again.
+func (p *VaultSecretProvider) KeyMetadata(ctx context.Context, keyID string) (KeyMetadata, error) {
+	entry, err := p.lookup(ctx, keyID, clockOrReal(p.Clock).Now())
+	if err != nil {
+		return KeyMetadata{}, fmt.Errorf("error in lookup: %w", err)
+	}
+	if !entry.found {
+		return KeyMetadata{}, ErrKeyNotFound
+	}
+	return entry.value.metadata, nil
+}
+
+// DisableKey writes a new version of the key with the same secret, disabled
+func (p *VaultSecretProvider) DisableKey(ctx context.Context, keyID string) error {
+	current, err := p.do(ctx, http.MethodGet, p.url(keyID), nil)
+	if err != nil {
+		return fmt.Errorf("error getting current secret: %w", err)
+	}
+	metadata, err := p.keyMetadata(current)
+	if err != nil {
+		return fmt.Errorf("error in keyMetadata: %w", err)
+	}
+	metadata.Disabled = true
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("error encoding key metadata: %w", err)
+	}
+	if _, err = p.write(ctx, keyID, current.Data.Data[p.field()], string(encoded), current.Data.Metadata.Version); err != nil {
+		return fmt.Errorf("error in write: %w", err)
+	}
+	return nil
+}
+
+// ListKeys lists the key ids under Path
+func (p *VaultSecretProvider) ListKeys(ctx context.Context) ([]string, error) {
+	var list vaultListResponse
+	err := p.doJSON(ctx, "LIST", p.engineURL("metadata"), nil, &list)
+	if errors.Is(err, ErrKeyNotFound) {
+		// Vault 404s an empty path
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error listing keys: %w", err)
+	}
+	// Folders (suffixed with /) are nested paths, not keys
+	return lo.Filter(list.Data.Keys, func(key string, _ int) bool {
+		return !strings.HasSuffix(key, "/")
+	}), nil
+}
@@ -0,0 +1,383 @@
+package http_server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+// fakeVault is a Vault KV v2 engine at secret/iam-keys, versioning the secrets it is written
+type fakeVault struct {
+	*httptest.Server
+	clock Clock
+
+	mu       sync.Mutex
+	versions map[string][]fakeVaultVersion
+	reads    int
+}
+
+type fakeVaultVersion struct {
+	data    map[string]any
+	created time.Time
+}
+
+func newFakeVault(t *testing.T, clock Clock) *fakeVault {
+	v := &fakeVault{clock: clock, versions: map[string][]fakeVaultVersion{}}
+	v.Server = httptest.NewServer(http.HandlerFunc(v.serveHTTP))
+	t.Cleanup(v.Close)
+	return v
+}
+
+func (v *fakeVault) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Token") != "token" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if r.Method == "LIST" && r.URL.Path == "/v1/secret/metadata/iam-keys" {
+		var keys []string
+		for key := range v.versions {
+			keys = append(keys, key)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"keys": keys}})
+		return
+	}
+	keyID, ok := strings.CutPrefix(r.URL.Path, "/v1/secret/data/iam-keys/")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		v.reads++
+		versions := v.versions[keyID]
+		version := len(versions)
+		if query := r.URL.Query().Get("version"); query != "" {
+			version, _ = strconv.Atoi(query)
+		}
+		if version < 1 || version > len(versions) || versions[version-1].data == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+			"data":     versions[version-1].data,
+			"metadata": map[string]any{"version": version, "created_time": versions[version-1].created},
+		}})
+	case http.MethodPost:
+		var body struct {
+			Data    map[string]any `json:"data"`
+			Options struct {
+				CAS *int `json:"cas"`
+			} `json:"options"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.Options.CAS != nil && *body.Options.CAS != len(v.versions[keyID]) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		v.versions[keyID] = append(v.versions[keyID], fakeVaultVersion{data: body.Data, created: v.clock.Now()})
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"version": len(v.versions[keyID])}})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// put writes a version of the key as an operator would, without the provider
+func (v *fakeVault) put(keyID, secret string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.versions[keyID] = append(v.versions[keyID], fakeVaultVersion{data: map[string]any{"secret": secret}, created: v.clock.Now()})
+}
+
+func (v *fakeVault) readCount() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.reads
+}
+
+func secretValues(secrets []Secret) string {
+	values := make([]string, len(secrets))
+	for i, secret := range secrets {
+		values[i] = secret.Value + "@" + secret.Version
+	}
+	return strings.Join(values, ",")
+}
+
+func TestVaultSecretProviderRotationGracePeriod(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	vault := newFakeVault(t, clock)
+	p := &VaultSecretProvider{Address: vault.URL, Token: "token", Path: "iam-keys", RotationGracePeriod: time.Hour, Clock: clock}
+	ctx := context.Background()
+
+	if _, err := p.Secrets(ctx, "AKID"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v, want ErrKeyNotFound", err)
+	}
+	vault.put("AKID", "first")
+	clock.Advance(10 * time.Minute)
+	if _, err := p.Rotate(ctx, "AKID", "second"); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(10 * time.Minute)
+
+	// The previous secret is accepted within the grace period of the rotation, measured from created_time
+	secrets, err := p.Secrets(ctx, "AKID")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := secretValues(secrets); got != "second@2,first@1" {
+		t.Errorf("got %s within the grace period", got)
+	}
+
+	clock.Advance(time.Hour)
+	if secrets, err = p.Secrets(ctx, "AKID"); err != nil {
+		t.Fatal(err)
+	}
+	if got := secretValues(secrets); got != "second@2" {
+		t.Errorf("got %s after the grace period", got)
+	}
+}
+
+func TestVaultSecretProviderDefaultGracePeriod(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	vault := newFakeVault(t, clock)
+	p := &VaultSecretProvider{Address: vault.URL, Token: "token", Path: "iam-keys", Clock: clock}
+	ctx := context.Background()
+	vault.put("AKID", "first")
+	p.Rotate(ctx, "AKID", "second")
+
+	clock.Advance(DefaultRotationGracePeriod - time.Minute)
+	if secrets, _ := p.Secrets(ctx, "AKID"); len(secrets) != 2 {
+		t.Errorf("got %s, want both secrets", secretValues(secrets))
+	}
+	clock.Advance(2 * time.Minute)
+	if secrets, _ := p.Secrets(ctx, "AKID"); len(secrets) != 1 {
+		t.Errorf("got %s, want the current secret", secretValues(secrets))
+	}
+}
+
+func TestVaultSecretProviderCache(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	vault := newFakeVault(t, clock)
+	p := &VaultSecretProvider{
+		Address: vault.URL, Token: "token", Path: "iam-keys",
+		RotationGracePeriod: time.Hour, CacheTTL: 10 * time.Minute, NegativeTTL: time.Minute, Clock: clock,
+	}
+	ctx := context.Background()
+	vault.put("AKID", "first")
+	p.Rotate(ctx, "AKID", "second")
+	before := vault.readCount()
+
+	for i := 0; i < 3; i++ {
+		if secrets, err := p.Secrets(ctx, "AKID"); err != nil || len(secrets) != 2 {
+			t.Fatalf("got %v, %v", secrets, err)
+		}
+		if _, err := p.Secrets(ctx, "missing"); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("got %v, want ErrKeyNotFound", err)
+		}
+	}
+	// The current and previous version of AKID, and the missing key
+	if reads := vault.readCount() - before; reads != 3 {
+		t.Errorf("vault was read %d times, want each lookup cached", reads)
+	}
+
+	// A rotation through the provider drops its cached secrets
+	if _, err := p.Rotate(ctx, "AKID", "third"); err != nil {
+		t.Fatal(err)
+	}
+	if secrets, _ := p.Secrets(ctx, "AKID"); secretValues(secrets) != "third@3,second@2" {
+		t.Errorf("got %s after rotating", secretValues(secrets))
+	}
+
+	// Cached secrets still end the grace period on time
+	clock.Advance(time.Hour + time.Second)
+	p.CacheTTL = 2 * time.Hour
+	p.Rotate(ctx, "AKID", "fourth")
+	p.Secrets(ctx, "AKID")
+	clock.Advance(time.Hour + time.Second)
+	if secrets, _ := p.Secrets(ctx, "AKID"); secretValues(secrets) != "fourth@4" {
+		t.Errorf("got %s from the cache after the grace period", secretValues(secrets))
+	}
+
+	clock.Advance(2 * time.Minute)
+	reads := vault.readCount()
+	p.Secrets(ctx, "missing")
+	if vault.readCount() != reads+1 {
+		t.Error("the missing key was cached past the NegativeTTL")
+	}
+}
+
+func TestVaultSecretProviderListAndGet(t *testing.T) {
+	vault := newFakeVault(t, RealClock{})
+	p := &VaultSecretProvider{Address: vault.URL, Token: "token", Path: "iam-keys"}
+	ctx := context.Background()
+	vault.put("AKID", "secret")
+
+	if secret, err := p.GetSecret(ctx, "AKID"); err != nil || secret != "secret" {
+		t.Fatalf("got %q, %v", secret, err)
+	}
+	if _, err := p.GetSecret(ctx, "missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v, want ErrKeyNotFound", err)
+	}
+	if keys, err := p.ListKeys(ctx); err != nil || len(keys) != 1 || keys[0] != "AKID" {
+		t.Fatalf("got %v, %v", keys, err)
+	}
+
+	p.Token = "wrong"
+	if _, err := p.Secrets(ctx, "AKID"); err == nil || errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v, want a vault error", err)
+	}
+}
+
+func TestEnvSecretProvider(t *testing.T) {
+	p := EnvSecretProvider{Prefix: "IAM_TEST_KEY_"}
+	ctx := context.Background()
+	t.Setenv("IAM_TEST_KEY_AKID", "current")
+
+	if _, err := p.Secrets(ctx, "other"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v, want ErrKeyNotFound", err)
+	}
+	// Key ids are upper cased
+	secrets, err := p.Secrets(ctx, "akid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := secretValues(secrets); got != "current@current" {
+		t.Errorf("got %s", got)
+	}
+
+	t.Setenv("IAM_TEST_KEY_AKID_PREVIOUS", "previous")
+	if secrets, _ = p.Secrets(ctx, "AKID"); secretValues(secrets) != "current@current,previous@previous" {
+		t.Errorf("got %s during a rotation", secretValues(secrets))
+	}
+	if _, err = p.Rotate(ctx, "AKID", "new"); !errors.Is(err, ErrRotationUnsupported) {
+		t.Errorf("got %v, want ErrRotationUnsupported", err)
+	}
+}
+
+func TestVaultSecretProviderKeyIssuer(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	vault := newFakeVault(t, clock)
+	p := &VaultSecretProvider{Address: vault.URL, Token: "token", Path: "iam-keys", CacheTTL: time.Hour, Clock: clock}
+	var _ KeyIssuer = p
+	ctx := context.Background()
+
+	expiresAt := clock.Now().Add(48 * time.Hour)
+	metadata := KeyMetadata{Services: []string{"s3"}, TenantID: "tenant-a", ExpiresAt: &expiresAt, CreatedAt: clock.Now()}
+	if err := p.CreateKey(ctx, "AKIAISSUED", "first", metadata); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CreateKey(ctx, "AKIAISSUED", "other", KeyMetadata{}); !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("got %v, want ErrKeyExists", err)
+	}
+	if _, err := p.Rotate(ctx, "AKIAUNKNOWN", "secret"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v, want rotation to not create keys", err)
+	}
+
+	// The metadata is kept next to the secret across rotations
+	if _, err := p.Rotate(ctx, "AKIAISSUED", "second"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.KeyMetadata(ctx, "AKIAISSUED")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.TenantID != "tenant-a" || len(got.Services) != 1 || !got.ExpiresAt.Equal(expiresAt) || got.Disabled {
+		t.Errorf("got metadata %+v", got)
+	}
+	if secret, err := p.GetSecret(ctx, "AKIAISSUED"); err != nil || secret != "second" {
+		t.Fatalf("got %q, %v", secret, err)
+	}
+	if secrets, err := p.Secrets(ctx, "AKIAISSUED"); err != nil || secretValues(secrets) != "second@2,first@1" {
+		t.Fatalf("got %s, %v", secretValues(secrets), err)
+	}
+
+	// Keys written to Vault directly have no metadata
+	vault.put("AKIAOPERATOR", "secret")
+	if got, err = p.KeyMetadata(ctx, "AKIAOPERATOR"); err != nil || got.Disabled || got.TenantID != "" {
+		t.Fatalf("got %+v, %v", got, err)
+	}
+	if _, err = p.KeyMetadata(ctx, "AKIAUNKNOWN"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v, want ErrKeyNotFound", err)
+	}
+
+	// Disabling drops the cached secrets, and keeps the key listed
+	if err = p.DisableKey(ctx, "AKIAISSUED"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.Secrets(ctx, "AKIAISSUED"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("got %v for a disabled key, want ErrKeyNotFound", err)
+	}
+	if _, err = p.GetSecret(ctx, "AKIAISSUED"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("got %v for a disabled key, want ErrKeyNotFound", err)
+	}
+	if got, err = p.KeyMetadata(ctx, "AKIAISSUED"); err != nil || !got.Disabled || got.TenantID != "tenant-a" {
+		t.Errorf("got %+v, %v", got, err)
+	}
+	if keys, _ := p.ListKeys(ctx); len(keys) != 2 {
+		t.Errorf("listed %v", keys)
+	}
+
+	// Expired keys are unknown
+	if err = p.CreateKey(ctx, "AKIAEXPIRING", "secret", KeyMetadata{ExpiresAt: &expiresAt}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.Secrets(ctx, "AKIAEXPIRING"); err != nil {
+		t.Fatal(err)
+	}
+	clock.Set(expiresAt)
+	if _, err = p.Secrets(ctx, "AKIAEXPIRING"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("got %v for an expired key, want ErrKeyNotFound", err)
+	}
+	if _, err = p.GetSecret(ctx, "AKIAEXPIRING"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("got %v for an expired key, want ErrKeyNotFound", err)
+	}
+}
+
+// Verifying a request and checking the scope of its key share the cached read of the key
+func TestVaultSecretProviderScopesRequestsFromCache(t *testing.T) {
+	vault := newFakeVault(t, RealClock{})
+	p := &VaultSecretProvider{Address: vault.URL, Token: "token", Path: "iam-keys", CacheTTL: time.Hour}
+	if err := p.CreateKey(context.Background(), "AKIAISSUED", "secret", KeyMetadata{Services: []string{"s3"}}); err != nil {
+		t.Fatal(err)
+	}
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer origin.Close()
+	s3, sqs := NewS3Provider(), NewSQSProvider()
+	s3.OriginHost, sqs.OriginHost = origin.URL, origin.URL
+	proxy := &AWSProxy{
+		CredentialStore: p,
+		ServiceLookupFunc: func(_ context.Context, host string) (AWSServiceProvider, error) {
+			return lo.Ternary[AWSServiceProvider](host == "sqs.localhost", sqs, s3), nil
+		},
+	}
+
+	for _, service := range []string{"s3", "s3", "s3", "sqs"} {
+		r := httptest.NewRequest(http.MethodGet, "http://"+service+".localhost/bucket/key", nil)
+		SignRequest(r, "AKIAISSUED", "secret", "us-east-1", service, time.Now())
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, r)
+		want := http.StatusOK
+		if service != "s3" {
+			// The key wasn't issued for it
+			want = http.StatusForbidden
+		}
+		if w.Code != want {
+			t.Fatalf("%s got status %d, want %d", service, w.Code, want)
+		}
+	}
+	if reads := vault.readCount(); reads != 1 {
+		t.Errorf("vault was read %d times, want once for the secret and metadata", reads)
+	}
+}
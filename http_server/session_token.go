@@ -0,0 +1,123 @@
+package http_server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+var (
+	ErrInvalidSessionToken = errors.New("invalid session token")
+	ErrSessionTokenExpired = errors.New("session token expired")
+)
+
+var (
+	ErrAWSInvalidToken = NewAWSError(http.StatusForbidden, "InvalidToken", "The provided token is malformed or otherwise invalid.")
+	ErrAWSExpiredToken = NewAWSError(http.StatusBadRequest, "ExpiredToken", "The provided token has expired.")
+)
+
+// SessionTokenValidator validates the session token of requests signed with temporary credentials
+// (e.g. from AssumeRole). The secret of a temporary key id is still looked up through the SecretProvider.
+type SessionTokenValidator interface {
+	// ValidateSessionToken is called for every verified request, with an empty token if the request has none,
+	// so temporary keys can require one. It returns ErrInvalidSessionToken or ErrSessionTokenExpired to reject.
+	ValidateSessionToken(ctx context.Context, keyID, token string) error
+}
+
+// SessionTokenValidatorFunc adapts a function to a SessionTokenValidator
+type SessionTokenValidatorFunc func(ctx context.Context, keyID, token string) error
+
+func (f SessionTokenValidatorFunc) ValidateSessionToken(ctx context.Context, keyID, token string) error {
+	return f(ctx, keyID, token)
+}
+
+// MemorySessionTokens is a SessionTokenValidator of temporary credentials vended locally, e.g. by an
+// STSProvider handler for AssumeRole. Key ids it doesn't know are long-term keys, which must not carry a token.
+type MemorySessionTokens struct {
+	// Clock defaults to RealClock
+	Clock Clock
+
+	mu       sync.RWMutex
+	sessions map[string]memorySession
+}
+
+type memorySession struct {
+	token   string
+	expires time.Time
+}
+
+// Put registers temporary credentials, valid until their Expiration
+func (s *MemorySessionTokens) Put(creds STSCredentials) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions == nil {
+		s.sessions = map[string]memorySession{}
+	}
+	s.sessions[creds.AccessKeyId] = memorySession{
+		token:   creds.SessionToken,
+		expires: creds.Expiration,
+	}
+}
+
+// Revoke invalidates the temporary credentials of the key id
+func (s *MemorySessionTokens) Revoke(keyID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, keyID)
+}
+
+func (s *MemorySessionTokens) ValidateSessionToken(_ context.Context, keyID, token string) error {
+	s.mu.RLock()
+	session, ok := s.sessions[keyID]
+	s.mu.RUnlock()
+
+	switch {
+	case !ok && token == "":
+		return nil
+	case !ok:
+		return fmt.Errorf("token for long-term key %s: %w", keyID, ErrInvalidSessionToken)
+	case subtle.ConstantTimeCompare([]byte(token), []byte(session.token)) != 1:
+		return fmt.Errorf("token for key %s: %w", keyID, ErrInvalidSessionToken)
+	case !clockOrReal(s.Clock).Now().Before(session.expires):
+		s.Revoke(keyID)
+		return fmt.Errorf("token for key %s: %w", keyID, ErrSessionTokenExpired)
+	}
+	return nil
+}
+
+// sessionToken is the session token of the request, from the X-Amz-Security-Token header or the query of a presigned URL
+func sessionToken(r *http.Request) string {
+	if token := r.Header.Get("X-Amz-Security-Token"); token != "" || !isPresignedRequest(r) {
+		return token
+	}
+	return r.URL.Query().Get("X-Amz-Security-Token")
+}
+
+// verifySessionToken checks the session token of a verified request with the SessionTokens validator.
+// Tokens in headers must be signed, so they can't be swapped for another token of the same key.
+func (p *AWSProxy) verifySessionToken(ctx context.Context, r *http.Request, parsedHeader AWSAuthHeader) error {
+	if p.SessionTokens == nil {
+		return nil
+	}
+	token := sessionToken(r)
+	if r.Header.Get("X-Amz-Security-Token") != "" && !lo.Contains(parsedHeader.SignedHeaders, "x-amz-security-token") {
+		return reject(RejectionMissingSignedHeaders, fmt.Errorf("x-amz-security-token is not signed: %w", ErrAWSAccessDenied))
+	}
+
+	err := p.SessionTokens.ValidateSessionToken(ctx, parsedHeader.Credential.KeyID, token)
+	switch {
+	case errors.Is(err, ErrSessionTokenExpired):
+		return reject(RejectionExpired, fmt.Errorf("error in ValidateSessionToken: %w: %w", ErrAWSExpiredToken, err))
+	case errors.Is(err, ErrInvalidSessionToken):
+		return reject(RejectionInvalidToken, fmt.Errorf("error in ValidateSessionToken: %w: %w", ErrAWSInvalidToken, err))
+	case err != nil:
+		return fmt.Errorf("error in ValidateSessionToken: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package http_server_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+// newSDKS3Client is an aws-sdk-go-v2 S3 client signing path-style requests to the harness
+func newSDKS3Client(h *iamtest.Harness) *s3.Client {
+	return s3.NewFromConfig(aws.Config{
+		Region:      iamtest.Region,
+		Credentials: credentials.NewStaticCredentialsProvider(iamtest.KeyID, iamtest.KeySecret, ""),
+		HTTPClient:  h.Server.Client(),
+	}, func(o *s3.Options) {
+		o.BaseEndpoint = aws.String(h.Server.URL)
+		o.UsePathStyle = true
+		o.RetryMaxAttempts = 1
+	})
+}
+
+// Keys S3 clients escape differently from other services, which the canonical request must encode the same
+// way the SDK signed them
+func TestSigV4S3KeysSignedBySDK(t *testing.T) {
+	h := iamtest.NewHarness(func(originURL string) http_server.AWSServiceProvider {
+		p := http_server.NewS3Provider()
+		p.OriginHost = originURL
+		return p
+	})
+	t.Cleanup(h.Close)
+	client := newSDKS3Client(h)
+	ctx := context.Background()
+
+	for _, key := range []string{
+		"reports/q1 2024.csv",
+		"a+b.txt",
+		"100%.txt",
+		"100%25.txt",
+		"café/naïve-日本.txt",
+		"k=v&x=y.txt",
+		"a  b/ c /d~e!f'g(h)*i.txt",
+	} {
+		t.Run(key, func(t *testing.T) {
+			before := len(h.Origin.Requests())
+			if _, err := client.PutObject(ctx, &s3.PutObjectInput{
+				Bucket: aws.String("bucket"),
+				Key:    aws.String(key),
+				Body:   strings.NewReader("hello"),
+			}); err != nil {
+				t.Fatalf("PutObject: %v", err)
+			}
+			out, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String(key)})
+			if err != nil {
+				t.Fatalf("GetObject: %v", err)
+			}
+			io.Copy(io.Discard, out.Body)
+			out.Body.Close()
+			if _, err = client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: aws.String("bucket"), Prefix: aws.String(key)}); err != nil {
+				t.Fatalf("ListObjectsV2: %v", err)
+			}
+
+			requests := h.Origin.Requests()[before:]
+			if len(requests) != 3 {
+				t.Fatalf("origin received %d requests", len(requests))
+			}
+			for _, r := range requests[:2] {
+				if r.Path != "/bucket/"+key {
+					t.Errorf("origin received %s %s, want /bucket/%s", r.Method, r.Path, key)
+				}
+			}
+			if r := requests[0]; r.Method != http.MethodPut || string(r.Body) != "hello" {
+				t.Errorf("origin received %s with %q", r.Method, r.Body)
+			}
+		})
+	}
+}
+
+// Repeated query keys are canonicalized sorted by value, which a presigned URL signs alongside its own params
+func TestSigV4PresignedRepeatedQueryKey(t *testing.T) {
+	h := newS3Harness(t)
+	const query = "response-content-type=text%2Fplain&response-content-type=application%2Fjson&X-Amz-Expires=900"
+	r, err := http.NewRequest(http.MethodGet, h.Server.URL+"/bucket/key?"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	presignedURL, signedHeaders, err := v4.NewSigner().PresignHTTP(context.Background(),
+		aws.Credentials{AccessKeyID: iamtest.KeyID, SecretAccessKey: iamtest.KeySecret}, r,
+		"UNSIGNED-PAYLOAD", "s3", iamtest.Region, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	presigned, err := http.NewRequest(http.MethodGet, presignedURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for header, vals := range signedHeaders {
+		if !strings.EqualFold(header, "Host") {
+			presigned.Header[header] = vals
+		}
+	}
+	res, err := h.Do(presigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got %d %s", res.StatusCode, body)
+	}
+	requests := h.Origin.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("origin received %d requests", len(requests))
+	}
+	values, _ := url.ParseQuery(requests[0].RawQuery)
+	if got := values["response-content-type"]; !slices.Equal(slices.Sorted(slices.Values(got)), []string{"application/json", "text/plain"}) {
+		t.Errorf("origin received query %q, want both values", requests[0].RawQuery)
+	}
+}
@@ -0,0 +1,71 @@
+package http_server
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/samber/lo"
+)
+
+// SNSProvider is the AWSServiceProvider for SNS, which uses the AWS query protocol (Action=Publish).
+// Register handlers for actions, and use RewriteSNSTopicArns to map topics (e.g. tenant topics to a shared one).
+type SNSProvider struct {
+	*QueryProtocolProvider
+}
+
+func NewSNSProvider() *SNSProvider {
+	p := &SNSProvider{
+		QueryProtocolProvider: NewQueryProtocolProvider("sns"),
+	}
+	// Topics are regional
+	p.Regional = true
+	return p
+}
+
+// SNSRequest is the parsed parameters of an SNS request
+type SNSRequest struct {
+	Action   string
+	TopicArn string
+	// TargetArn is the topic or endpoint ARN that Publish can address instead of TopicArn
+	TargetArn string
+	Subject   string
+	Message   string
+	// Params are all the query and form parameters
+	Params url.Values
+}
+
+// ParseSNSRequest parses the query string and form body (which is still forwarded to the origin) of an SNS request
+func ParseSNSRequest(request *ProxiedRequest) (SNSRequest, error) {
+	params, err := ParseQueryParams(request)
+	if err != nil {
+		return SNSRequest{}, fmt.Errorf("error in ParseQueryParams: %w", err)
+	}
+	return SNSRequest{
+		Action:    params.Get("Action"),
+		TopicArn:  params.Get("TopicArn"),
+		TargetArn: params.Get("TargetArn"),
+		Subject:   params.Get("Subject"),
+		Message:   params.Get("Message"),
+		Params:    params,
+	}, nil
+}
+
+// ExtractResources returns the TopicArn and TargetArn of the request
+func (p *SNSProvider) ExtractResources(request *ProxiedRequest) ([]string, error) {
+	snsReq, err := ParseSNSRequest(request)
+	if err != nil {
+		return nil, fmt.Errorf("error in ParseSNSRequest: %w", err)
+	}
+	return lo.Compact([]string{snsReq.TopicArn, snsReq.TargetArn}), nil
+}
+
+// RewriteSNSTopicArns replaces the TopicArn and TargetArn of the request with rename(arn) before it is proxied.
+// Topic ARNs in responses (e.g. of CreateTopic) are not rewritten.
+func RewriteSNSTopicArns(request *ProxiedRequest, rename func(arn string) string) error {
+	return rewriteQueryParams(request, func(key, value string) string {
+		if key != "TopicArn" && key != "TargetArn" {
+			return value
+		}
+		return rename(value)
+	})
+}
@@ -0,0 +1,142 @@
+package http_server_test
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+var snsForm = http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}
+
+func TestSNSExtractOperationName(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		want   string
+	}{
+		{name: "form", target: "/", body: "Action=Publish&TopicArn=arn%3Aaws%3Asns%3Aus-east-1%3A123456789012%3Aorders&Message=hi", want: "Publish"},
+		{name: "query string", target: "/?Action=ListTopics&Version=2010-03-31", want: "ListTopics"},
+		{name: "no action", target: "/", body: "TopicArn=arn%3Aaws%3Asns%3Aus-east-1%3A123456789012%3Aorders", want: http_server.OperationUnknown},
+	}
+	provider := http_server.NewSNSProvider()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "https://sns.us-east-1.amazonaws.com"+tt.target, strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			request := &http_server.ProxiedRequest{Request: r}
+			if got := provider.ExtractOperationName(request); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+			// The body is still there to forward
+			if body, _ := io.ReadAll(r.Body); string(body) != tt.body {
+				t.Errorf("got body %q after classifying, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestSNSProviderRouting(t *testing.T) {
+	for _, regional := range []bool{true, false} {
+		provider := http_server.NewSNSProvider()
+		provider.Regional = regional
+		// Tenant topics are published to the shared one
+		provider.Use(func(next http_server.OperationHandler) http_server.OperationHandler {
+			return func(ctx context.Context, request *http_server.ProxiedRequest) (*http.Response, error) {
+				err := http_server.RewriteSNSTopicArns(request, func(arn string) string {
+					return strings.Replace(arn, ":tenant-orders", ":shared-orders", 1)
+				})
+				if err != nil {
+					return nil, err
+				}
+				return next(ctx, request)
+			}
+		})
+		h, endpoints := newEndpointHarness(t, provider)
+
+		body := "Action=Publish&TopicArn=" + url.QueryEscape("arn:aws:sns:eu-west-1:123456789012:tenant-orders") + "&Message=hi"
+		res, err := h.Do(newRegionRequest(h, http.MethodPost, "/", "eu-west-1", snsForm, body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("got status %d", res.StatusCode)
+		}
+		want := "sns.amazonaws.com"
+		if regional {
+			want = "sns.eu-west-1.amazonaws.com"
+		}
+		if got := endpoints.next(t); got != want {
+			t.Errorf("regional %t: proxied to %s, want %s", regional, got, want)
+		}
+
+		requests := h.Origin.Requests()
+		if len(requests) != 1 {
+			t.Fatalf("origin received %d requests", len(requests))
+		}
+		params, _ := url.ParseQuery(string(requests[0].Body))
+		if got := params.Get("TopicArn"); got != "arn:aws:sns:eu-west-1:123456789012:shared-orders" || params.Get("Message") != "hi" {
+			t.Errorf("origin received %s", requests[0].Body)
+		}
+	}
+}
+
+func TestSNSProviderLocalPublish(t *testing.T) {
+	provider := http_server.NewSNSProvider()
+	var published []http_server.SNSRequest
+	provider.RegisterOperationHandler("Publish", func(ctx context.Context, request *http_server.ProxiedRequest) (*http.Response, error) {
+		snsReq, err := http_server.ParseSNSRequest(request)
+		if err != nil {
+			return nil, err
+		}
+		published = append(published, snsReq)
+		return http_server.NewQueryResponse("Publish", "http://sns.amazonaws.com/doc/2010-03-31/", struct {
+			MessageId string
+		}{"local-message-id"}), nil
+	})
+	h, endpoints := newEndpointHarness(t, provider)
+
+	body := "Action=Publish&TopicArn=" + url.QueryEscape("arn:aws:sns:us-east-1:123456789012:orders") + "&Subject=order&Message=hi"
+	res, err := h.Do(newRegionRequest(h, http.MethodPost, "/", iamtest.Region, snsForm, body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resBody, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got %d %s", res.StatusCode, resBody)
+	}
+	var publish struct {
+		MessageId string `xml:"PublishResult>MessageId"`
+		RequestId string `xml:"ResponseMetadata>RequestId"`
+	}
+	if err := xml.Unmarshal(resBody, &publish); err != nil || publish.MessageId != "local-message-id" || publish.RequestId == "" {
+		t.Errorf("got %s: %v", resBody, err)
+	}
+	if len(published) != 1 || published[0].TopicArn != "arn:aws:sns:us-east-1:123456789012:orders" || published[0].Subject != "order" || published[0].Message != "hi" {
+		t.Errorf("handler got %+v", published)
+	}
+	if n := len(h.Origin.Requests()); n != 0 {
+		t.Errorf("origin received %d requests", n)
+	}
+
+	// Other actions still go to SNS
+	res, err = h.Do(newRegionRequest(h, http.MethodPost, "/", iamtest.Region, snsForm, "Action=ListTopics"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if got := endpoints.next(t); got != "sns."+iamtest.Region+".amazonaws.com" {
+		t.Errorf("ListTopics proxied to %s", got)
+	}
+}
@@ -0,0 +1,130 @@
+package http_server
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// SQL dialects of SQLLookupConfig, which differ in their query placeholders
+const (
+	SQLDialectPostgres = "postgres"
+	SQLDialectMySQL    = "mysql"
+)
+
+// sqlIdentifierPattern is what a table or column name may be, as they can't be query parameters
+var sqlIdentifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// SQLLookupConfig is the table of a SQLLookupProvider, e.g. iam_keys with key_id and secret columns
+// for AWSProxy.KeyLookupFunc, or routes with host and origin columns for AWSProxy.HostLookupFunc
+type SQLLookupConfig struct {
+	// Dialect is SQLDialectPostgres or SQLDialectMySQL
+	Dialect string
+	// Table may be schema qualified, e.g. iam.keys
+	Table       string
+	KeyColumn   string
+	ValueColumn string
+	// CacheTTL is how long values are cached after they are read, 0 disables it
+	CacheTTL time.Duration
+	// NegativeTTL caches missing keys, 0 disables it
+	NegativeTTL time.Duration
+	// MaxCacheEntries bounds the local cache, defaults to DefaultLookupMaxCacheEntries
+	MaxCacheEntries int
+}
+
+// SQLLookupProvider is a LookupProvider reading values from a database table through a prepared statement,
+// for deployments where credentials or routes are managed in an existing database. Values are cached
+// for CacheTTL, so changes (e.g. a revoked key) are seen once the cached value expires, and missing keys
+// for NegativeTTL.
+type SQLLookupProvider struct {
+	// Clock defaults to RealClock
+	Clock Clock
+
+	stmt            *sql.Stmt
+	cacheTTL        time.Duration
+	negativeTTL     time.Duration
+	maxCacheEntries int
+
+	cache lookupCache[string]
+}
+
+// NewSQLLookupProvider prepares the lookup query of the table, so a misconfigured table fails at startup
+func NewSQLLookupProvider(ctx context.Context, db *sql.DB, cfg SQLLookupConfig) (*SQLLookupProvider, error) {
+	query, err := sqlLookupQuery(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error in sqlLookupQuery: %w", err)
+	}
+	stmt, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("error in db.PrepareContext: %w", err)
+	}
+	return &SQLLookupProvider{
+		stmt:            stmt,
+		cacheTTL:        cfg.CacheTTL,
+		negativeTTL:     cfg.NegativeTTL,
+		maxCacheEntries: cfg.MaxCacheEntries,
+	}, nil
+}
+
+func sqlLookupQuery(cfg SQLLookupConfig) (string, error) {
+	for _, identifier := range []string{cfg.Table, cfg.KeyColumn, cfg.ValueColumn} {
+		if !sqlIdentifierPattern.MatchString(identifier) {
+			return "", fmt.Errorf("invalid sql identifier %q", identifier)
+		}
+	}
+	var placeholder string
+	switch cfg.Dialect {
+	case SQLDialectPostgres:
+		placeholder = "$1"
+	case SQLDialectMySQL:
+		placeholder = "?"
+	default:
+		return "", fmt.Errorf("unknown sql dialect %q", cfg.Dialect)
+	}
+	return fmt.Sprintf("SELECT %s FROM %s WHERE %s = %s", cfg.ValueColumn, cfg.Table, cfg.KeyColumn, placeholder), nil
+}
+
+// Lookup returns the value of the key, reading through the cache. Missing rows return ErrKeyNotFound,
+// and dropped connections return a *RetryableError.
+func (p *SQLLookupProvider) Lookup(ctx context.Context, key string) (string, error) {
+	now := clockOrReal(p.Clock).Now()
+	if p.cacheTTL > 0 || p.negativeTTL > 0 {
+		entry, ok := p.cache.get(key, now)
+		recordLookupCacheResult("sql", ok)
+		if ok {
+			if !entry.found {
+				return "", ErrKeyNotFound
+			}
+			return entry.value, nil
+		}
+	}
+
+	var value string
+	err := p.stmt.QueryRowContext(ctx, key).Scan(&value)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		p.cache.put(key, lookupCacheEntry[string]{}, now, p.negativeTTL, p.maxCacheEntries)
+		return "", ErrKeyNotFound
+	case errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone):
+		return "", &RetryableError{Err: err}
+	case err != nil:
+		return "", fmt.Errorf("error in QueryRowContext: %w", err)
+	}
+
+	p.cache.put(key, lookupCacheEntry[string]{value: value, found: true}, now, p.cacheTTL, p.maxCacheEntries)
+	return value, nil
+}
+
+// Invalidate drops the cached value of the key, e.g. after revoking it
+func (p *SQLLookupProvider) Invalidate(key string) {
+	p.cache.invalidate(key)
+}
+
+// Close closes the prepared statement, the database is left open
+func (p *SQLLookupProvider) Close() error {
+	return p.stmt.Close()
+}
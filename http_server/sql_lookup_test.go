@@ -0,0 +1,116 @@
+package http_server_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+)
+
+// fakeSQLDriver serves the single column lookup query from a map, counting the queries
+type fakeSQLDriver struct {
+	rows    map[string]string
+	queries atomic.Int64
+}
+
+func (d *fakeSQLDriver) Open(string) (driver.Conn, error) { return fakeSQLConn{d}, nil }
+
+type fakeSQLConnector struct{ d *fakeSQLDriver }
+
+func (c fakeSQLConnector) Connect(context.Context) (driver.Conn, error) { return fakeSQLConn{c.d}, nil }
+func (c fakeSQLConnector) Driver() driver.Driver                        { return c.d }
+
+type fakeSQLConn struct{ d *fakeSQLDriver }
+
+func (c fakeSQLConn) Prepare(string) (driver.Stmt, error) { return fakeSQLStmt(c), nil }
+func (c fakeSQLConn) Close() error                        { return nil }
+func (c fakeSQLConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+type fakeSQLStmt struct{ d *fakeSQLDriver }
+
+func (s fakeSQLStmt) Close() error  { return nil }
+func (s fakeSQLStmt) NumInput() int { return 1 }
+func (s fakeSQLStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+func (s fakeSQLStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.queries.Add(1)
+	value, ok := s.d.rows[args[0].(string)]
+	return &fakeSQLRows{value: value, done: !ok}, nil
+}
+
+type fakeSQLRows struct {
+	value string
+	done  bool
+}
+
+func (r *fakeSQLRows) Columns() []string { return []string{"secret"} }
+func (r *fakeSQLRows) Close() error      { return nil }
+func (r *fakeSQLRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.value
+	return nil
+}
+
+func TestSQLLookupProviderCache(t *testing.T) {
+	d := &fakeSQLDriver{rows: map[string]string{"AKIA1": "secret1"}}
+	db := sql.OpenDB(fakeSQLConnector{d})
+	defer db.Close()
+
+	p, err := http_server.NewSQLLookupProvider(context.Background(), db, http_server.SQLLookupConfig{
+		Dialect:     http_server.SQLDialectPostgres,
+		Table:       "iam.keys",
+		KeyColumn:   "key_id",
+		ValueColumn: "secret",
+		CacheTTL:    time.Minute,
+		NegativeTTL: 10 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	clock := http_server.NewFakeClock(time.Now())
+	p.Clock = clock
+
+	lookup := func(key string) (string, error) {
+		t.Helper()
+		return p.Lookup(context.Background(), key)
+	}
+	for i := 0; i < 3; i++ {
+		if value, err := lookup("AKIA1"); err != nil || value != "secret1" {
+			t.Fatalf("got %q %v", value, err)
+		}
+		if _, err := lookup("AKIA2"); !errors.Is(err, http_server.ErrKeyNotFound) {
+			t.Fatalf("got %v, want ErrKeyNotFound", err)
+		}
+	}
+	if n := d.queries.Load(); n != 2 {
+		t.Fatalf("got %d queries, want the found and missing keys cached", n)
+	}
+
+	// The missing key expires first, then the found one
+	d.rows["AKIA2"] = "secret2"
+	clock.Advance(10 * time.Second)
+	if value, err := lookup("AKIA2"); err != nil || value != "secret2" {
+		t.Fatalf("got %q %v once the negative entry expired", value, err)
+	}
+	lookup("AKIA1")
+	if n := d.queries.Load(); n != 3 {
+		t.Fatalf("got %d queries", n)
+	}
+
+	d.rows["AKIA1"] = "rotated"
+	p.Invalidate("AKIA1")
+	if value, _ := lookup("AKIA1"); value != "rotated" {
+		t.Errorf("got %q after Invalidate", value)
+	}
+}
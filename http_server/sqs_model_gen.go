@@ -0,0 +1,30 @@
+// Code generated by providergen from https://raw.githubusercontent.com/boto/botocore/1.35.63/botocore/data/sqs/2012-11-05/service-2.json. DO NOT EDIT.
+
+package http_server
+
+// SQSOperations are the operations of SQS (API version 2012-11-05)
+var SQSOperations = OperationSet{
+	"AddPermission":                true,
+	"CancelMessageMoveTask":        true,
+	"ChangeMessageVisibility":      true,
+	"ChangeMessageVisibilityBatch": true,
+	"CreateQueue":                  true,
+	"DeleteMessage":                true,
+	"DeleteMessageBatch":           true,
+	"DeleteQueue":                  true,
+	"GetQueueAttributes":           true,
+	"GetQueueUrl":                  true,
+	"ListDeadLetterSourceQueues":   true,
+	"ListMessageMoveTasks":         true,
+	"ListQueueTags":                true,
+	"ListQueues":                   true,
+	"PurgeQueue":                   true,
+	"ReceiveMessage":               true,
+	"RemovePermission":             true,
+	"SendMessage":                  true,
+	"SendMessageBatch":             true,
+	"SetQueueAttributes":           true,
+	"StartMessageMoveTask":         true,
+	"TagQueue":                     true,
+	"UntagQueue":                   true,
+}
@@ -0,0 +1,38 @@
+package http_server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// SQSProvider is the AWSServiceProvider for SQS, which current SDKs call with the AWS JSON 1.0 protocol
+// (X-Amz-Target: AmazonSQS.SendMessage) and older ones with the query protocol (Action=SendMessage).
+// Operations are classified against the SQS model, so StrictOperations rejects actions SQS doesn't have.
+type SQSProvider struct {
+	*QueryProtocolProvider
+}
+
+func NewSQSProvider() *SQSProvider {
+	p := &SQSProvider{
+		QueryProtocolProvider: NewQueryProtocolProvider("sqs"),
+	}
+	p.Operations = SQSOperations
+	// Queues are regional
+	p.Regional = true
+	return p
+}
+
+// ExtractOperationName gets the operation from the X-Amz-Target header, or the Action of query requests
+func (p *SQSProvider) ExtractOperationName(request *ProxiedRequest) string {
+	if target := request.Request.Header.Get("X-Amz-Target"); target != "" {
+		_, operation, _ := strings.Cut(target, ".")
+		return p.Operations.classify(operation)
+	}
+	return p.QueryProtocolProvider.ExtractOperationName(request)
+}
+
+// HandleRequest dispatches to the registered operation handler, or proxies to SQS if there is none
+func (p *SQSProvider) HandleRequest(ctx context.Context, request *ProxiedRequest) (*http.Response, error) {
+	return p.dispatch(ctx, p.ExtractOperationName(request), request, p.proxy)
+}
@@ -0,0 +1,71 @@
+package http_server_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+func TestSQSProviderOperations(t *testing.T) {
+	var operations []string
+	h := iamtest.NewHarness(func(originURL string) http_server.AWSServiceProvider {
+		p := http_server.NewSQSProvider()
+		p.OriginHost = originURL
+		p.StrictOperations = true
+		p.Use(func(next http_server.OperationHandler) http_server.OperationHandler {
+			return func(ctx context.Context, request *http_server.ProxiedRequest) (*http.Response, error) {
+				operations = append(operations, request.Operation)
+				return next(ctx, request)
+			}
+		})
+		return p
+	})
+	t.Cleanup(h.Close)
+
+	tests := []struct {
+		name       string
+		target     string
+		body       string
+		wantStatus int
+		wantOp     string
+	}{
+		{name: "json", target: "AmazonSQS.SendMessage", body: `{"QueueUrl":"q","MessageBody":"hi"}`, wantStatus: http.StatusOK, wantOp: "SendMessage"},
+		{name: "query", body: "Action=ReceiveMessage&QueueUrl=q", wantStatus: http.StatusOK, wantOp: "ReceiveMessage"},
+		{name: "unknown json operation", target: "AmazonSQS.PublishMessage", body: `{}`, wantStatus: http.StatusNotImplemented},
+		{name: "unknown query action", body: "Action=Publish", wantStatus: http.StatusNotImplemented},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			operations = nil
+			r := h.NewSignedRequest(http.MethodPost, "/", []byte(tt.body))
+			if tt.target != "" {
+				r.Header.Set("X-Amz-Target", tt.target)
+				r.Header.Set("Content-Type", "application/x-amz-json-1.0")
+			} else {
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			res, err := h.Do(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", res.StatusCode, tt.wantStatus)
+			}
+			if tt.wantOp == "" {
+				if got := res.Header.Get(http_server.RejectReasonHeader); got != string(http_server.RejectionUnknownOperation) {
+					t.Errorf("got rejection reason %q", got)
+				}
+				return
+			}
+			if len(operations) != 1 || operations[0] != tt.wantOp {
+				t.Errorf("got operations %v, want %s", operations, tt.wantOp)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package http_server_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+func TestS3StrictOperations(t *testing.T) {
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		strict        bool
+		handleUnknown bool
+		wantStatus    int
+		wantForwarded bool
+	}{
+		{name: "classified, permissive", method: http.MethodGet, path: "/bucket/key", wantStatus: http.StatusOK, wantForwarded: true},
+		{name: "classified, strict", method: http.MethodGet, path: "/bucket/key", strict: true, wantStatus: http.StatusOK, wantForwarded: true},
+		{name: "unknown method, permissive", method: http.MethodPatch, path: "/bucket/key", wantStatus: http.StatusOK, wantForwarded: true},
+		{name: "unknown method, strict", method: http.MethodPatch, path: "/bucket/key", strict: true, wantStatus: http.StatusNotImplemented},
+		{name: "unknown object post, strict", method: http.MethodPost, path: "/bucket/key", strict: true, wantStatus: http.StatusNotImplemented},
+		{name: "no bucket, strict", method: http.MethodPut, path: "/", strict: true, wantStatus: http.StatusNotImplemented},
+		{name: "unknown with a handler, strict", method: http.MethodPatch, path: "/bucket/key", strict: true, handleUnknown: true, wantStatus: http.StatusTeapot},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := iamtest.NewHarness(func(originURL string) http_server.AWSServiceProvider {
+				p := http_server.NewS3Provider()
+				p.OriginHost = originURL
+				p.StrictOperations = tt.strict
+				if tt.handleUnknown {
+					p.RegisterOperationHandler(http_server.OperationUnknown, func(context.Context, *http_server.ProxiedRequest) (*http.Response, error) {
+						return &http.Response{StatusCode: http.StatusTeapot, Header: http.Header{}, Body: http.NoBody}, nil
+					})
+				}
+				return p
+			})
+			t.Cleanup(h.Close)
+
+			res, err := h.Do(h.NewSignedRequest(tt.method, tt.path, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got %d %s, want %d", res.StatusCode, body, tt.wantStatus)
+			}
+			if forwarded := len(h.Origin.Requests()) > 0; forwarded != tt.wantForwarded {
+				t.Errorf("got forwarded %t, want %t", forwarded, tt.wantForwarded)
+			}
+			if tt.wantStatus != http.StatusNotImplemented {
+				return
+			}
+			if !strings.Contains(string(body), "<Code>NotImplemented</Code>") {
+				t.Errorf("got body %s, want a NotImplemented error", body)
+			}
+			if got := res.Header.Get(http_server.RejectReasonHeader); got != string(http_server.RejectionUnknownOperation) {
+				t.Errorf("got rejection reason %q", got)
+			}
+		})
+	}
+}
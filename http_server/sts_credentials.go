@@ -0,0 +1,244 @@
+package http_server
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The STS actions whose responses carry credentials or identities
+const (
+	STSActionAssumeRole        = "AssumeRole"
+	STSActionGetSessionToken   = "GetSessionToken"
+	STSActionGetCallerIdentity = "GetCallerIdentity"
+)
+
+const stsXMLNamespace = "https://sts.amazonaws.com/doc/2011-06-15/"
+
+// maxSTSResponseBytes bounds how much of an STS response is buffered to rewrite its credentials
+const maxSTSResponseBytes = 64 * 1024
+
+// STSCredentials are the temporary credentials returned by AssumeRole and GetSessionToken
+type STSCredentials struct {
+	AccessKeyId     string    `xml:"AccessKeyId"`
+	SecretAccessKey string    `xml:"SecretAccessKey"`
+	SessionToken    string    `xml:"SessionToken"`
+	Expiration      time.Time `xml:"Expiration"`
+}
+
+// STSAssumedRoleUser identifies the role session of AssumeRole credentials
+type STSAssumedRoleUser struct {
+	AssumedRoleId string `xml:"AssumedRoleId"`
+	Arn           string `xml:"Arn"`
+}
+
+// STSCallerIdentity is the result of GetCallerIdentity
+type STSCallerIdentity struct {
+	UserId  string `xml:"UserId"`
+	Account string `xml:"Account"`
+	Arn     string `xml:"Arn"`
+}
+
+type stsResponseMetadata struct {
+	RequestId string `xml:"RequestId"`
+}
+
+type stsAssumeRoleResponse struct {
+	XMLName xml.Name `xml:"AssumeRoleResponse"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Result  struct {
+		Credentials     STSCredentials     `xml:"Credentials"`
+		AssumedRoleUser STSAssumedRoleUser `xml:"AssumedRoleUser"`
+	} `xml:"AssumeRoleResult"`
+	ResponseMetadata stsResponseMetadata `xml:"ResponseMetadata"`
+}
+
+type stsGetSessionTokenResponse struct {
+	XMLName xml.Name `xml:"GetSessionTokenResponse"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Result  struct {
+		Credentials STSCredentials `xml:"Credentials"`
+	} `xml:"GetSessionTokenResult"`
+	ResponseMetadata stsResponseMetadata `xml:"ResponseMetadata"`
+}
+
+type stsGetCallerIdentityResponse struct {
+	XMLName          xml.Name            `xml:"GetCallerIdentityResponse"`
+	Xmlns            string              `xml:"xmlns,attr"`
+	Result           STSCallerIdentity   `xml:"GetCallerIdentityResult"`
+	ResponseMetadata stsResponseMetadata `xml:"ResponseMetadata"`
+}
+
+// NewAssumeRoleResponse creates an AssumeRole response for handlers that vend credentials locally
+// instead of forwarding to STS
+func NewAssumeRoleResponse(creds STSCredentials, user STSAssumedRoleUser) *http.Response {
+	res := stsAssumeRoleResponse{Xmlns: stsXMLNamespace}
+	res.Result.Credentials = normalizeSTSCredentials(creds)
+	res.Result.AssumedRoleUser = user
+	res.ResponseMetadata.RequestId = uuid.NewString()
+	return newQueryResponse(res)
+}
+
+// NewGetSessionTokenResponse creates a GetSessionToken response for handlers that vend credentials locally
+func NewGetSessionTokenResponse(creds STSCredentials) *http.Response {
+	res := stsGetSessionTokenResponse{Xmlns: stsXMLNamespace}
+	res.Result.Credentials = normalizeSTSCredentials(creds)
+	res.ResponseMetadata.RequestId = uuid.NewString()
+	return newQueryResponse(res)
+}
+
+// NewGetCallerIdentityResponse creates a GetCallerIdentity response, e.g. to report the identity of a
+// proxy key rather than the origin's credentials
+func NewGetCallerIdentityResponse(identity STSCallerIdentity) *http.Response {
+	res := stsGetCallerIdentityResponse{Xmlns: stsXMLNamespace, Result: identity}
+	res.ResponseMetadata.RequestId = uuid.NewString()
+	return newQueryResponse(res)
+}
+
+// normalizeSTSCredentials formats the expiration the way STS does (UTC, second precision)
+func normalizeSTSCredentials(creds STSCredentials) STSCredentials {
+	creds.Expiration = creds.Expiration.UTC().Truncate(time.Second)
+	return creds
+}
+
+// STSCredentialRewriter modifies the credentials of an AssumeRole or GetSessionToken response in place
+type STSCredentialRewriter func(ctx context.Context, request *ProxiedRequest, creds *STSCredentials) error
+
+// RewriteSTSCredentials is a ResponseTransformer that passes the credentials of successful STS responses
+// through rewrite, e.g. to wrap the origin's session in a proxy-issued key:
+//
+//	p.Use(TransformResponses(RewriteSTSCredentials(rewrite), STSActionAssumeRole, STSActionGetSessionToken))
+func RewriteSTSCredentials(rewrite STSCredentialRewriter) ResponseTransformer {
+	return func(ctx context.Context, request *ProxiedRequest, res *http.Response) (*http.Response, error) {
+		if res.StatusCode != http.StatusOK || res.Header.Get("Content-Encoding") != "" {
+			return res, nil
+		}
+
+		body, err := io.ReadAll(io.LimitReader(res.Body, maxSTSResponseBytes+1))
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading STS response: %w", err)
+		}
+		if len(body) > maxSTSResponseBytes {
+			return nil, fmt.Errorf("STS response exceeds %d bytes", maxSTSResponseBytes)
+		}
+
+		var out bytes.Buffer
+		if err = rewriteSTSCredentials(ctx, request, &out, bytes.NewReader(body), rewrite); err != nil {
+			return nil, fmt.Errorf("error in rewriteSTSCredentials: %w", err)
+		}
+
+		res.Body = io.NopCloser(&out)
+		res.ContentLength = int64(out.Len())
+		res.Header.Set("Content-Length", strconv.Itoa(out.Len()))
+		return res, nil
+	}
+}
+
+func rewriteSTSCredentials(ctx context.Context, request *ProxiedRequest, w io.Writer, r io.Reader, rewrite STSCredentialRewriter) error {
+	// Raw tokens keep everything but the credentials exactly as the origin sent them
+	decoder := xml.NewDecoder(r)
+	encoder := xml.NewEncoder(w)
+	for {
+		token, err := decoder.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error in decoder.RawToken: %w", err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "Credentials" {
+			if err = encoder.EncodeToken(token); err != nil {
+				return fmt.Errorf("error in encoder.EncodeToken: %w", err)
+			}
+			continue
+		}
+
+		creds, err := readSTSCredentials(decoder)
+		if err != nil {
+			return fmt.Errorf("error in readSTSCredentials: %w", err)
+		}
+		if err = rewrite(ctx, request, &creds); err != nil {
+			return fmt.Errorf("error in rewrite: %w", err)
+		}
+		if err = writeSTSCredentials(encoder, start, normalizeSTSCredentials(creds)); err != nil {
+			return fmt.Errorf("error in writeSTSCredentials: %w", err)
+		}
+	}
+	if err := encoder.Flush(); err != nil {
+		return fmt.Errorf("error in encoder.Flush: %w", err)
+	}
+	return nil
+}
+
+// readSTSCredentials reads the children of a Credentials element, through its end element
+func readSTSCredentials(decoder *xml.Decoder) (STSCredentials, error) {
+	var creds STSCredentials
+	var field string
+	for {
+		token, err := decoder.RawToken()
+		if err != nil {
+			return creds, fmt.Errorf("error in decoder.RawToken: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			field = t.Name.Local
+		case xml.EndElement:
+			if t.Name.Local == "Credentials" {
+				return creds, nil
+			}
+			field = ""
+		case xml.CharData:
+			value := string(t)
+			switch field {
+			case "AccessKeyId":
+				creds.AccessKeyId += value
+			case "SecretAccessKey":
+				creds.SecretAccessKey += value
+			case "SessionToken":
+				creds.SessionToken += value
+			case "Expiration":
+				if creds.Expiration, err = time.Parse(time.RFC3339, value); err != nil {
+					return creds, fmt.Errorf("error parsing Expiration: %w", err)
+				}
+			}
+		}
+	}
+}
+
+func writeSTSCredentials(encoder *xml.Encoder, start xml.StartElement, creds STSCredentials) error {
+	fields := []struct {
+		name  string
+		value string
+	}{
+		{"AccessKeyId", creds.AccessKeyId},
+		{"SecretAccessKey", creds.SecretAccessKey},
+		{"SessionToken", creds.SessionToken},
+		{"Expiration", creds.Expiration.Format(time.RFC3339)},
+	}
+
+	tokens := []xml.Token{start}
+	for _, f := range fields {
+		name := xml.Name{Space: start.Name.Space, Local: f.name}
+		tokens = append(tokens, xml.StartElement{Name: name}, xml.CharData(f.value), xml.EndElement{Name: name})
+	}
+	tokens = append(tokens, xml.EndElement{Name: start.Name})
+
+	for _, token := range tokens {
+		if err := encoder.EncodeToken(token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package http_server
+
+import "net/url"
+
+// STSProvider is the AWSServiceProvider for STS, which uses the AWS query protocol (Action=AssumeRole).
+// Register handlers for actions to log or constrain role assumption, or to vend credentials locally
+// (see NewAssumeRoleResponse and RewriteSTSCredentials).
+// Set Regional to use the endpoint of the signed region (sts.us-west-2.amazonaws.com) rather than the global one.
+type STSProvider struct {
+	*QueryProtocolProvider
+}
+
+func NewSTSProvider() *STSProvider {
+	return &STSProvider{
+		QueryProtocolProvider: NewQueryProtocolProvider("sts"),
+	}
+}
+
+// STSRequest is the parsed parameters of an STS request
+type STSRequest struct {
+	Action          string
+	RoleArn         string
+	RoleSessionName string
+	DurationSeconds string
+	// Params are all the query and form parameters
+	Params url.Values
+}
+
+// ParseSTSRequest parses the query string and form body (which is still forwarded to the origin) of an STS request
+func ParseSTSRequest(request *ProxiedRequest) STSRequest {
+	// Unparseable forms are left for STS to reject
+	params, _ := ParseQueryParams(request)
+	return STSRequest{
+		Action:          params.Get("Action"),
+		RoleArn:         params.Get("RoleArn"),
+		RoleSessionName: params.Get("RoleSessionName"),
+		DurationSeconds: params.Get("DurationSeconds"),
+		Params:          params,
+	}
+}
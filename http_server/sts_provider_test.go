@@ -0,0 +1,82 @@
+package http_server_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+func TestParseSTSRequest(t *testing.T) {
+	var parsed http_server.STSRequest
+	h := iamtest.NewHarness(func(originURL string) http_server.AWSServiceProvider {
+		p := http_server.NewSTSProvider()
+		p.OriginHost = originURL
+		p.Use(func(next http_server.OperationHandler) http_server.OperationHandler {
+			return func(ctx context.Context, request *http_server.ProxiedRequest) (*http.Response, error) {
+				parsed = http_server.ParseSTSRequest(request)
+				return next(ctx, request)
+			}
+		})
+		return p
+	})
+	t.Cleanup(h.Close)
+
+	const params = "Action=AssumeRole&RoleArn=arn%3Aaws%3Aiam%3A%3A123456789012%3Arole%2Fdemo&RoleSessionName=session&DurationSeconds=900&Version=2011-06-15"
+	tests := []struct {
+		name   string
+		newReq func() *http.Request
+	}{
+		{
+			name: "form-encoded",
+			newReq: func() *http.Request {
+				r := h.NewSignedRequest(http.MethodPost, "/", []byte(params))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return r
+			},
+		},
+		{
+			name: "query string",
+			newReq: func() *http.Request {
+				return h.NewSignedRequest(http.MethodGet, "/?"+params, nil)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed = http_server.STSRequest{}
+			before := len(h.Origin.Requests())
+			res, err := h.Do(tt.newReq())
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("got status %d", res.StatusCode)
+			}
+
+			want := http_server.STSRequest{
+				Action:          "AssumeRole",
+				RoleArn:         "arn:aws:iam::123456789012:role/demo",
+				RoleSessionName: "session",
+				DurationSeconds: "900",
+			}
+			if parsed.Action != want.Action || parsed.RoleArn != want.RoleArn ||
+				parsed.RoleSessionName != want.RoleSessionName || parsed.DurationSeconds != want.DurationSeconds {
+				t.Errorf("got %+v, want %+v", parsed, want)
+			}
+			if got := parsed.Params.Get("Version"); got != "2011-06-15" {
+				t.Errorf("got Version param %q", got)
+			}
+			// The form is still forwarded after being parsed
+			requests := h.Origin.Requests()[before:]
+			if len(requests) != 1 || string(requests[0].Body)+requests[0].RawQuery != params {
+				t.Errorf("origin didn't receive the params unchanged: %+v", requests)
+			}
+		})
+	}
+}
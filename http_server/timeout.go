@@ -0,0 +1,61 @@
+package http_server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// OperationKey identifies an API operation of a service, e.g. {"dynamodb", "GetItem"}
+type OperationKey struct {
+	Service   string
+	Operation string
+}
+
+// operationTimeout returns the timeout of the operation, falling back to the proxy Timeout
+func (p *AWSProxy) operationTimeout(provider AWSServiceProvider, request *ProxiedRequest) time.Duration {
+	if len(p.OperationTimeouts) > 0 {
+		key := OperationKey{
+			Service:   provider.ServiceName(),
+			Operation: extractOperationName(provider, request),
+		}
+		if timeout, ok := p.OperationTimeouts[key]; ok {
+			return timeout
+		}
+	}
+	return p.Timeout
+}
+
+// handleWithTimeout has the provider handle the request, returning ErrAWSGatewayTimeout if it doesn't
+// respond within the operation timeout. The timeout is to the response headers, so long streams aren't cut off.
+func (p *AWSProxy) handleWithTimeout(ctx context.Context, provider AWSServiceProvider, request *ProxiedRequest) (*http.Response, error) {
+	timeout := p.operationTimeout(provider, request)
+	if timeout <= 0 {
+		return provider.HandleRequest(ctx, request)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(timeout, func() {
+		timedOut.Store(true)
+		cancel()
+	})
+
+	res, err := provider.HandleRequest(ctx, request)
+	if !timer.Stop() && timedOut.Load() {
+		if res != nil {
+			res.Body.Close()
+		}
+		return nil, fmt.Errorf("operation exceeded timeout of %s: %w", timeout, ErrAWSGatewayTimeout)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// The response body is read with ctx, so it can only be cancelled once it's closed
+	res.Body = cancelOnClose{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
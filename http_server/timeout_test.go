@@ -0,0 +1,101 @@
+package http_server_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+)
+
+// slowOrigin responds after delay, or gives up when the proxy cancels the request
+func slowOrigin(delay time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		io.WriteString(w, "{}")
+	}
+}
+
+func TestOperationTimeouts(t *testing.T) {
+	h := newDynamoDBHarness(t, 0)
+	h.Origin.Handle(slowOrigin(200 * time.Millisecond))
+	h.Proxy.Timeout = 5 * time.Second
+	h.Proxy.OperationTimeouts = map[http_server.OperationKey]time.Duration{
+		{Service: "dynamodb", Operation: "GetItem"}: 20 * time.Millisecond,
+		{Service: "dynamodb", Operation: "Query"}:   2 * time.Second,
+	}
+
+	tests := []struct {
+		operation  string
+		wantStatus int
+	}{
+		{operation: "GetItem", wantStatus: http.StatusGatewayTimeout},
+		{operation: "Query", wantStatus: http.StatusOK},
+		// Falls back to the proxy Timeout
+		{operation: "PutItem", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.operation, func(t *testing.T) {
+			start := time.Now()
+			res, body := doDynamoDB(t, h, tt.operation, `{"TableName":"users"}`)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got %d %s, want %d", res.StatusCode, body, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusGatewayTimeout {
+				return
+			}
+			if took := time.Since(start); took > 150*time.Millisecond {
+				t.Errorf("timed out after %s, want the operation timeout", took)
+			}
+			if got := res.Header.Get("X-Amzn-ErrorType"); got != "GatewayTimeout" || !strings.Contains(body, `"__type":"GatewayTimeout"`) {
+				t.Errorf("got X-Amzn-ErrorType %q and body %s, want a JSON GatewayTimeout", got, body)
+			}
+		})
+	}
+}
+
+func TestOperationTimeoutS3(t *testing.T) {
+	h := newS3Harness(t)
+	h.Proxy.OperationTimeouts = map[http_server.OperationKey]time.Duration{
+		{Service: "s3", Operation: "GetObject"}:             20 * time.Millisecond,
+		{Service: "s3", Operation: "CreateMultipartUpload"}: 20 * time.Millisecond,
+	}
+	h.Origin.Handle(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			slowOrigin(time.Second)(w, r)
+			return
+		}
+		// Headers arrive in time, the body streams after the timeout without being cut off
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(50 * time.Millisecond)
+		io.WriteString(w, "streamed")
+	})
+
+	res, err := h.Do(h.NewSignedRequest(http.MethodGet, "/bucket/key", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusGatewayTimeout || !strings.Contains(string(body), "<Code>GatewayTimeout</Code>") {
+		t.Errorf("got %d %s, want an XML GatewayTimeout", res.StatusCode, body)
+	}
+
+	res, err = h.Do(h.NewSignedRequest(http.MethodPost, "/bucket/key?uploads", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || string(body) != "streamed" {
+		t.Errorf("got %d %q", res.StatusCode, body)
+	}
+}
@@ -0,0 +1,153 @@
+package http_server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ValidateOptions configures AWSProxy.Validate
+type ValidateOptions struct {
+	// ProbeKey is looked up to check each lookup function is reachable, it doesn't need to exist
+	ProbeKey string
+	// Origins are dialed to check connectivity, and may include a scheme (e.g. http://minio:9000)
+	Origins     []string
+	DialTimeout time.Duration
+	// SampleKeyID optionally round-trips a request signed with this key through verification
+	SampleKeyID string
+	// SampleHost is the incoming host of the sample request, defaults to s3.amazonaws.com
+	SampleHost string
+}
+
+// ValidationProblem is a failed check of Validate
+type ValidationProblem struct {
+	// Check is what failed, e.g. "key lookup" or "origin minio:9000"
+	Check string
+	Err   error
+}
+
+// ValidationReport is the result of Validate
+type ValidationReport struct {
+	Problems []ValidationProblem
+}
+
+func (r ValidationReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r ValidationReport) Error() string {
+	var problems []string
+	for _, problem := range r.Problems {
+		problems = append(problems, problem.Check+": "+problem.Err.Error())
+	}
+	return "proxy validation failed: " + strings.Join(problems, "; ")
+}
+
+func (r *ValidationReport) add(check string, err error) {
+	r.Problems = append(r.Problems, ValidationProblem{Check: check, Err: err})
+}
+
+// Validate checks the proxy configuration before it takes traffic: that the lookup functions are reachable,
+// that the origins can be connected to, and that a sample signed request verifies end to end.
+func (p *AWSProxy) Validate(ctx context.Context, opts ValidateOptions) ValidationReport {
+	var report ValidationReport
+
+	probeKey := opts.ProbeKey
+	if probeKey == "" {
+		probeKey = "iam-validate-probe"
+	}
+	// Not finding the probe key is fine, any other error means the lookup is broken
+	if _, err := p.lookupSecrets(ctx, probeKey); err != nil && !errors.Is(err, ErrKeyNotFound) {
+		report.add("key lookup", err)
+	}
+	lookups := p.Lookups()
+	if lookups.HostLookupFunc != nil {
+		if _, err := lookups.HostLookupFunc(ctx, probeKey); err != nil && !errors.Is(err, ErrKeyNotFound) {
+			report.add("HostLookupFunc", err)
+		}
+	}
+	if lookups.Providers == nil {
+		if _, err := lookups.ServiceLookupFunc(ctx, probeKey); err != nil && !errors.Is(err, ErrKeyNotFound) {
+			report.add("ServiceLookupFunc", err)
+		}
+	}
+
+	dialTimeout := opts.DialTimeout
+	if dialTimeout == 0 {
+		dialTimeout = 5 * time.Second
+	}
+	for _, origin := range opts.Origins {
+		if err := probeOrigin(ctx, origin, dialTimeout); err != nil {
+			report.add("origin "+origin, err)
+		}
+	}
+
+	if opts.SampleKeyID != "" {
+		if err := p.verifySampleRequest(ctx, opts); err != nil {
+			report.add("sample request", err)
+		}
+	}
+
+	return report
+}
+
+// probeOrigin dials the origin, defaulting to port 443 (or 80 for http://)
+func probeOrigin(ctx context.Context, origin string, timeout time.Duration) error {
+	port := "443"
+	if scheme, host, found := strings.Cut(origin, "://"); found {
+		origin = host
+		if scheme == "http" {
+			port = "80"
+		}
+	}
+	if _, _, err := net.SplitHostPort(origin); err != nil {
+		origin = net.JoinHostPort(origin, port)
+	}
+
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", origin)
+	if err != nil {
+		return fmt.Errorf("error dialing origin: %w", err)
+	}
+	return conn.Close()
+}
+
+// verifySampleRequest signs a request with the sample key the way an SDK would, and verifies it
+// like an incoming request
+func (p *AWSProxy) verifySampleRequest(ctx context.Context, opts ValidateOptions) error {
+	secrets, err := p.lookupSecrets(ctx, opts.SampleKeyID)
+	if err != nil {
+		return fmt.Errorf("error looking up sample key: %w", err)
+	}
+	keySecret := secrets[0].Value
+
+	host := opts.SampleHost
+	if host == "" {
+		host = "s3.amazonaws.com"
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+host+"/", nil)
+	if err != nil {
+		return fmt.Errorf("error in http.NewRequestWithContext: %w", err)
+	}
+	SignRequest(r, opts.SampleKeyID, keySecret, "us-east-1", "s3", clockOrReal(p.Clock).Now())
+
+	parsedHeader := parseAuthHeader(r.Header.Get("Authorization"))
+	if err = checkMandatorySignedHeaders(r, parsedHeader, p.mandatorySignedHeaders(parsedHeader.Credential.Service)); err != nil {
+		return fmt.Errorf("error in checkMandatorySignedHeaders: %w", err)
+	}
+	if err = verifyRequestSignature(r, parsedHeader, keySecret); err != nil {
+		return fmt.Errorf("error in verifyRequestSignature: %w", err)
+	}
+	sample := &ProxiedRequest{
+		Request: r,
+		Service: parsedHeader.Credential.Service,
+	}
+	if _, err = p.lookupServiceProvider(ctx, sample); err != nil {
+		return fmt.Errorf("error looking up service provider for sample request: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package http_server_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(h *iamtest.Harness)
+		wantChecks []string
+	}{
+		{name: "valid"},
+		{
+			name: "unknown provider",
+			setup: func(h *iamtest.Harness) {
+				h.Proxy.ServiceLookupFunc = iamtest.MapLookupFunc(map[string]http_server.AWSServiceProvider{})
+			},
+			wantChecks: []string{"sample request"},
+		},
+		{
+			name: "failing host lookup",
+			setup: func(h *iamtest.Harness) {
+				h.Proxy.HostLookupFunc = func(context.Context, string) (string, error) {
+					return "", errors.New("connection refused")
+				}
+			},
+			wantChecks: []string{"HostLookupFunc"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newS3Harness(t)
+			if tt.setup != nil {
+				tt.setup(h)
+			}
+
+			report := h.Proxy.Validate(t.Context(), http_server.ValidateOptions{
+				Origins:     []string{h.Origin.URL},
+				SampleKeyID: iamtest.KeyID,
+			})
+			var checks []string
+			for _, problem := range report.Problems {
+				checks = append(checks, problem.Check)
+			}
+			if !slices.Equal(checks, tt.wantChecks) || report.OK() != (len(checks) == 0) {
+				t.Errorf("got problems %v, want %v", report.Problems, tt.wantChecks)
+			}
+		})
+	}
+}
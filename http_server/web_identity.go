@@ -0,0 +1,305 @@
+package http_server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	ErrInvalidWebIdentityToken = errors.New("invalid web identity token")
+	ErrWebIdentityTokenExpired = errors.New("web identity token expired")
+)
+
+const (
+	// jwtLeeway is the clock skew tolerated for exp and nbf
+	jwtLeeway = time.Minute
+	// jwksMinRefreshInterval stops unknown key ids from hammering the JWKS URL
+	jwksMinRefreshInterval = time.Minute
+)
+
+type (
+	// WebIdentityClaims are the validated claims of an OIDC token
+	WebIdentityClaims struct {
+		Issuer    string
+		Subject   string
+		Audience  []string
+		ExpiresAt time.Time
+		// Raw has every claim of the token, for mapping custom claims (e.g. groups) to credentials
+		Raw map[string]any
+	}
+
+	// WebIdentityCredentials are the AWS credentials a client can sign requests to the proxy with
+	WebIdentityCredentials struct {
+		AccessKeyID     string
+		SecretAccessKey string
+		SessionToken    string
+		// Expiration is capped to the token's expiry
+		Expiration time.Time
+	}
+)
+
+// WebIdentityExchange exchanges a validated OIDC JWT for AWS credentials that can be used against the proxy,
+// like STS AssumeRoleWithWebIdentity. Only RS256 and ES256 signed tokens are supported.
+type WebIdentityExchange struct {
+	JWKSURL  string
+	Issuer   string
+	Audience string
+	// CredentialsLookupFunc maps the token's claims to an internal AWS key id and secret
+	CredentialsLookupFunc LookupFunc[WebIdentityClaims, WebIdentityCredentials]
+	// HTTPClient fetches the JWKS, defaults to http.DefaultClient
+	HTTPClient *http.Client
+	// Clock defaults to RealClock
+	Clock Clock
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	lastRefresh time.Time
+}
+
+// Exchange validates the token's signature, issuer, audience, and expiry, and looks up its credentials
+func (e *WebIdentityExchange) Exchange(ctx context.Context, token string) (WebIdentityCredentials, error) {
+	claims, err := e.validateToken(ctx, token, clockOrReal(e.Clock).Now())
+	if err != nil {
+		return WebIdentityCredentials{}, err
+	}
+
+	creds, err := e.CredentialsLookupFunc(ctx, claims)
+	if err != nil {
+		return WebIdentityCredentials{}, fmt.Errorf("error in CredentialsLookupFunc: %w", err)
+	}
+	if creds.Expiration.IsZero() || creds.Expiration.After(claims.ExpiresAt) {
+		creds.Expiration = claims.ExpiresAt
+	}
+	return creds, nil
+}
+
+// HandleExchange is the echo handler for the exchange. The token is read from the WebIdentityToken form value
+// or a bearer Authorization header, and the credentials are returned in the credential_process JSON format.
+func (e *WebIdentityExchange) HandleExchange(c echo.Context) error {
+	token := c.FormValue("WebIdentityToken")
+	if token == "" {
+		token = strings.TrimPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+	}
+	if token == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "missing WebIdentityToken")
+	}
+
+	creds, err := e.Exchange(c.Request().Context(), token)
+	if errors.Is(err, ErrInvalidWebIdentityToken) || errors.Is(err, ErrWebIdentityTokenExpired) {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+	if err != nil {
+		return fmt.Errorf("error in Exchange: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"Version":         1,
+		"AccessKeyId":     creds.AccessKeyID,
+		"SecretAccessKey": creds.SecretAccessKey,
+		"SessionToken":    creds.SessionToken,
+		"Expiration":      creds.Expiration.UTC().Format(time.RFC3339),
+	})
+}
+
+func (e *WebIdentityExchange) validateToken(ctx context.Context, token string, now time.Time) (WebIdentityClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return WebIdentityClaims{}, fmt.Errorf("token is not a JWT: %w", ErrInvalidWebIdentityToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return WebIdentityClaims{}, err
+	}
+
+	key, err := e.getKey(ctx, header.Kid)
+	if err != nil {
+		return WebIdentityClaims{}, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return WebIdentityClaims{}, fmt.Errorf("bad signature encoding: %w", ErrInvalidWebIdentityToken)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !verifyJWTSignature(header.Alg, key, digest[:], signature) {
+		return WebIdentityClaims{}, fmt.Errorf("bad signature: %w", ErrInvalidWebIdentityToken)
+	}
+
+	var raw map[string]any
+	if err = decodeJWTSegment(parts[1], &raw); err != nil {
+		return WebIdentityClaims{}, err
+	}
+	claims := WebIdentityClaims{Raw: raw}
+	claims.Issuer, _ = raw["iss"].(string)
+	claims.Subject, _ = raw["sub"].(string)
+	switch aud := raw["aud"].(type) {
+	case string:
+		claims.Audience = []string{aud}
+	case []any:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				claims.Audience = append(claims.Audience, s)
+			}
+		}
+	}
+
+	exp, ok := raw["exp"].(float64)
+	if !ok {
+		return WebIdentityClaims{}, fmt.Errorf("missing exp: %w", ErrInvalidWebIdentityToken)
+	}
+	claims.ExpiresAt = time.Unix(int64(exp), 0)
+	if now.After(claims.ExpiresAt.Add(jwtLeeway)) {
+		return WebIdentityClaims{}, ErrWebIdentityTokenExpired
+	}
+	if nbf, ok := raw["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return WebIdentityClaims{}, fmt.Errorf("token not valid yet: %w", ErrInvalidWebIdentityToken)
+	}
+	if claims.Issuer != e.Issuer {
+		return WebIdentityClaims{}, fmt.Errorf("unexpected issuer %s: %w", claims.Issuer, ErrInvalidWebIdentityToken)
+	}
+	audienceOK := false
+	for _, aud := range claims.Audience {
+		audienceOK = audienceOK || aud == e.Audience
+	}
+	if !audienceOK {
+		return WebIdentityClaims{}, fmt.Errorf("unexpected audience: %w", ErrInvalidWebIdentityToken)
+	}
+
+	return claims, nil
+}
+
+func decodeJWTSegment(segment string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("bad segment encoding: %w", ErrInvalidWebIdentityToken)
+	}
+	if err = json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("bad segment json: %w", ErrInvalidWebIdentityToken)
+	}
+	return nil
+}
+
+func verifyJWTSignature(alg string, key crypto.PublicKey, digest, signature []byte) bool {
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest, signature) == nil
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(ecKey, digest, r, s)
+	default:
+		return false
+	}
+}
+
+// getKey gets the key from the cached JWKS, refreshing it if the key id is unknown
+func (e *WebIdentityExchange) getKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if key, ok := e.keys[kid]; ok {
+		return key, nil
+	}
+	now := clockOrReal(e.Clock).Now()
+	if now.Sub(e.lastRefresh) < jwksMinRefreshInterval {
+		return nil, fmt.Errorf("unknown key id %s: %w", kid, ErrInvalidWebIdentityToken)
+	}
+
+	keys, err := e.fetchJWKS(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error in fetchJWKS: %w", err)
+	}
+	e.keys = keys
+	e.lastRefresh = now
+
+	if key, ok := e.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id %s: %w", kid, ErrInvalidWebIdentityToken)
+}
+
+func (e *WebIdentityExchange) fetchJWKS(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.JWKSURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error in http.NewRequestWithContext: %w", err)
+	}
+	client := e.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error in client.Do: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS url returned status %d", res.StatusCode)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&jwks); err != nil {
+		return nil, fmt.Errorf("error decoding JWKS: %w", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		switch {
+		case jwk.Kty == "RSA":
+			modulus, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+			exponent, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[jwk.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(modulus),
+				E: int(new(big.Int).SetBytes(exponent).Int64()),
+			}
+		case jwk.Kty == "EC" && jwk.Crv == "P-256":
+			x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+			y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			keys[jwk.Kid] = &ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(x),
+				Y:     new(big.Int).SetBytes(y),
+			}
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,182 @@
+package http_server_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+)
+
+const (
+	webIdentityIssuer   = "https://issuer.example.com"
+	webIdentityAudience = "iamtheservice"
+)
+
+// webIdentityKeys is an OIDC provider's RSA and EC signing keys, served as a JWKS
+type webIdentityKeys struct {
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+}
+
+func newWebIdentityKeys(t *testing.T) *webIdentityKeys {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &webIdentityKeys{rsa: rsaKey, ec: ecKey}
+}
+
+func (k *webIdentityKeys) serveJWKS(w http.ResponseWriter, _ *http.Request) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+		{"kid": "rsa", "kty": "RSA", "n": b64(k.rsa.N.Bytes()), "e": b64(big.NewInt(int64(k.rsa.E)).Bytes())},
+		{"kid": "ec", "kty": "EC", "crv": "P-256", "x": b64(k.ec.X.FillBytes(make([]byte, 32))), "y": b64(k.ec.Y.FillBytes(make([]byte, 32)))},
+	}})
+}
+
+// sign makes a JWT of the claims, signed with RS256 for the "rsa" kid and ES256 for "ec"
+func (k *webIdentityKeys) sign(t *testing.T, kid string, claims map[string]any) string {
+	t.Helper()
+	alg := map[string]string{"rsa": "RS256", "ec": "ES256"}[kid]
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	var err error
+	if kid == "rsa" {
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, digest[:])
+	} else {
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k.ec, digest[:])
+		if err == nil {
+			signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestWebIdentityExchange(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	keys := newWebIdentityKeys(t)
+	other := newWebIdentityKeys(t)
+	jwks := httptest.NewServer(http.HandlerFunc(keys.serveJWKS))
+	defer jwks.Close()
+
+	exchange := &http_server.WebIdentityExchange{
+		JWKSURL:  jwks.URL,
+		Issuer:   webIdentityIssuer,
+		Audience: webIdentityAudience,
+		CredentialsLookupFunc: func(_ context.Context, claims http_server.WebIdentityClaims) (http_server.WebIdentityCredentials, error) {
+			if claims.Subject != "user-1" {
+				return http_server.WebIdentityCredentials{}, http_server.ErrKeyNotFound
+			}
+			return http_server.WebIdentityCredentials{
+				AccessKeyID:     "AKIAUSER1",
+				SecretAccessKey: "user1_secret",
+				Expiration:      now.Add(12 * time.Hour),
+			}, nil
+		},
+		Clock: http_server.NewFakeClock(now),
+	}
+	e := echo.New()
+	e.POST("/.iam/web-identity", exchange.HandleExchange)
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	claims := func(overrides map[string]any) map[string]any {
+		c := map[string]any{
+			"iss": webIdentityIssuer,
+			"sub": "user-1",
+			"aud": webIdentityAudience,
+			"exp": now.Add(time.Hour).Unix(),
+			"iat": now.Unix(),
+		}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{name: "valid RS256", token: keys.sign(t, "rsa", claims(nil)), wantStatus: http.StatusOK},
+		{name: "valid ES256", token: keys.sign(t, "ec", claims(nil)), wantStatus: http.StatusOK},
+		{name: "audience list", token: keys.sign(t, "rsa", claims(map[string]any{"aud": []string{"other", webIdentityAudience}})), wantStatus: http.StatusOK},
+		{name: "expired", token: keys.sign(t, "rsa", claims(map[string]any{"exp": now.Add(-time.Hour).Unix()})), wantStatus: http.StatusForbidden},
+		{name: "wrong audience", token: keys.sign(t, "rsa", claims(map[string]any{"aud": "someone-else"})), wantStatus: http.StatusForbidden},
+		{name: "wrong issuer", token: keys.sign(t, "rsa", claims(map[string]any{"iss": "https://evil.example.com"})), wantStatus: http.StatusForbidden},
+		{name: "not valid yet", token: keys.sign(t, "ec", claims(map[string]any{"nbf": now.Add(time.Hour).Unix()})), wantStatus: http.StatusForbidden},
+		{name: "signed by another key", token: other.sign(t, "rsa", claims(nil)), wantStatus: http.StatusForbidden},
+		{name: "not a jwt", token: "not-a-jwt", wantStatus: http.StatusForbidden},
+		{name: "missing", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := http.PostForm(server.URL+"/.iam/web-identity", url.Values{"WebIdentityToken": {tt.token}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", res.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var creds map[string]any
+			if err = json.NewDecoder(res.Body).Decode(&creds); err != nil {
+				t.Fatal(err)
+			}
+			if creds["AccessKeyId"] != "AKIAUSER1" || creds["SecretAccessKey"] != "user1_secret" {
+				t.Errorf("got credentials %v", creds)
+			}
+			// The credentials don't outlive the token
+			if want := now.Add(time.Hour).UTC().Format(time.RFC3339); creds["Expiration"] != want {
+				t.Errorf("got Expiration %v, want %s", creds["Expiration"], want)
+			}
+		})
+	}
+
+	t.Run("bearer token", func(t *testing.T) {
+		r, _ := http.NewRequest(http.MethodPost, server.URL+"/.iam/web-identity", strings.NewReader(""))
+		r.Header.Set("Authorization", "Bearer "+keys.sign(t, "ec", claims(nil)))
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("got status %d", res.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,42 @@
+package iamtest_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+	"github.com/danthegoodman1/IAMTheService/iamtest"
+)
+
+// An S3Provider caching GetObject responses in front of an InMemoryS3, so the second read never reaches it
+func ExampleInMemoryS3() {
+	cache := &http_server.S3ResponseCache{Cache: http_server.NewMemoryCache(1024 * 1024)}
+	h := iamtest.NewHarness(func(originURL string) http_server.AWSServiceProvider {
+		p := http_server.NewS3Provider()
+		p.OriginHost = originURL
+		p.Use(cache.Middleware)
+		return p
+	})
+	defer h.Close()
+
+	store := iamtest.NewInMemoryS3()
+	h.Origin.Handle(store.ServeHTTP)
+	store.Put("bucket", "key", []byte("hello"), "text/plain")
+
+	for i := 0; i < 2; i++ {
+		res, err := h.Do(h.NewSignedRequest(http.MethodGet, "/bucket/key", nil))
+		if err != nil {
+			panic(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		fmt.Println(res.StatusCode, res.Header.Get(http_server.CacheStatusHeader), string(body))
+	}
+	fmt.Println("origin requests:", len(h.Origin.Requests()))
+
+	// Output:
+	// 200 miss hello
+	// 200 hit hello
+	// origin requests: 1
+}
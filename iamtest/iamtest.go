@@ -0,0 +1,177 @@
+// Package iamtest is a harness for testing service providers and handlers against a fake origin,
+// without hand-rolling httptest servers and signed requests.
+//
+//	h := iamtest.NewHarness(func(originURL string) http_server.AWSServiceProvider {
+//		p := http_server.NewS3Provider()
+//		p.OriginHost = originURL
+//		return p
+//	})
+//	defer h.Close()
+//
+//	h.Origin.RespondWith(http.StatusOK, nil, []byte("hello"))
+//	res, err := h.Do(h.NewSignedRequest(http.MethodGet, "/bucket/key", nil))
+//	// h.Origin.Requests() has what the origin received
+package iamtest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+)
+
+const (
+	KeyID     = "AKIAIAMTEST"
+	KeySecret = "iamtest_secret"
+	Region    = "us-east-1"
+)
+
+// RecordedRequest is a request received by the FakeOrigin
+type RecordedRequest struct {
+	Method   string
+	Host     string
+	Path     string
+	RawQuery string
+	Header   http.Header
+	Body     []byte
+}
+
+// FakeOrigin records the requests it receives and responds with a canned response
+type FakeOrigin struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []RecordedRequest
+	handler  http.HandlerFunc
+}
+
+func NewFakeOrigin() *FakeOrigin {
+	o := &FakeOrigin{}
+	o.RespondWith(http.StatusOK, nil, nil)
+	o.Server = httptest.NewServer(http.HandlerFunc(o.serveHTTP))
+	return o
+}
+
+func (o *FakeOrigin) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	o.mu.Lock()
+	o.requests = append(o.requests, RecordedRequest{
+		Method:   r.Method,
+		Host:     r.Host,
+		Path:     r.URL.Path,
+		RawQuery: r.URL.RawQuery,
+		Header:   r.Header.Clone(),
+		Body:     body,
+	})
+	handler := o.handler
+	o.mu.Unlock()
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	handler(w, r)
+}
+
+// RespondWith sets the canned response for subsequent requests
+func (o *FakeOrigin) RespondWith(statusCode int, header http.Header, body []byte) {
+	o.Handle(func(w http.ResponseWriter, r *http.Request) {
+		for key, vals := range header {
+			w.Header()[key] = vals
+		}
+		w.WriteHeader(statusCode)
+		w.Write(body)
+	})
+}
+
+// Handle sets a custom handler for subsequent requests, e.g. InMemoryS3.ServeHTTP
+func (o *FakeOrigin) Handle(handler http.HandlerFunc) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.handler = handler
+}
+
+// Requests returns the requests received so far
+func (o *FakeOrigin) Requests() []RecordedRequest {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]RecordedRequest(nil), o.requests...)
+}
+
+// Harness is an AWSProxy in front of a FakeOrigin, accepting requests signed with KeyID and KeySecret
+type Harness struct {
+	Origin *FakeOrigin
+	Proxy  *http_server.AWSProxy
+	// Server serves the Proxy
+	Server *httptest.Server
+	// Service is the credential scope service requests are signed for
+	Service string
+}
+
+// NewHarness builds a Harness whose provider is created by newProvider, which should point the provider at originURL
+func NewHarness(newProvider func(originURL string) http_server.AWSServiceProvider) *Harness {
+	origin := NewFakeOrigin()
+	provider := newProvider(origin.URL)
+
+	h := &Harness{
+		Origin:  origin,
+		Service: provider.ServiceName(),
+		Proxy: &http_server.AWSProxy{
+			KeyLookupFunc:     MapLookupFunc(map[string]string{KeyID: KeySecret}),
+			HostLookupFunc:    MapLookupFunc(map[string]string{}),
+			ServiceLookupFunc: StaticLookupFunc[string, http_server.AWSServiceProvider](provider),
+		},
+	}
+	h.Server = httptest.NewServer(h.Proxy)
+	return h
+}
+
+// NewSignedRequest creates a request to the proxy at path, signed with KeyID and KeySecret
+func (h *Harness) NewSignedRequest(method, path string, body []byte) *http.Request {
+	return NewSignedRequest(method, h.Server.URL+path, body, h.Service)
+}
+
+// Do sends the request to the proxy
+func (h *Harness) Do(r *http.Request) (*http.Response, error) {
+	return h.Server.Client().Do(r)
+}
+
+func (h *Harness) Close() {
+	h.Server.Close()
+	h.Origin.Close()
+}
+
+// NewSignedRequest creates a request to url for service, signed with KeyID and KeySecret
+func NewSignedRequest(method, url string, body []byte, service string) *http.Request {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	r, err := http.NewRequest(method, url, bodyReader)
+	if err != nil {
+		panic(err)
+	}
+	http_server.SignRequest(r, KeyID, KeySecret, Region, service, time.Now())
+	return r
+}
+
+// MapLookupFunc looks up keys in a map, returning http_server.ErrKeyNotFound for missing keys
+func MapLookupFunc[TKey comparable, TVal any](m map[TKey]TVal) http_server.LookupFunc[TKey, TVal] {
+	return func(_ context.Context, key TKey) (TVal, error) {
+		val, ok := m[key]
+		if !ok {
+			return val, http_server.ErrKeyNotFound
+		}
+		return val, nil
+	}
+}
+
+// StaticLookupFunc always returns val
+func StaticLookupFunc[TKey any, TVal any](val TVal) http_server.LookupFunc[TKey, TVal] {
+	return func(context.Context, TKey) (TVal, error) {
+		return val, nil
+	}
+}
@@ -0,0 +1,139 @@
+package iamtest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/danthegoodman1/IAMTheService/http_server"
+)
+
+func newS3Harness(t *testing.T) *Harness {
+	t.Helper()
+	h := NewHarness(func(originURL string) http_server.AWSServiceProvider {
+		p := http_server.NewS3Provider()
+		p.OriginHost = originURL
+		return p
+	})
+	t.Cleanup(h.Close)
+	return h
+}
+
+func TestHarnessProxiesSignedRequests(t *testing.T) {
+	h := newS3Harness(t)
+	h.Origin.RespondWith(http.StatusOK, http.Header{"X-Origin": {"fake"}}, []byte("hello"))
+
+	res, err := h.Do(h.NewSignedRequest(http.MethodGet, "/bucket/key?versionId=1", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK || string(body) != "hello" {
+		t.Fatalf("got %d %q, want 200 %q", res.StatusCode, body, "hello")
+	}
+	if res.Header.Get("X-Origin") != "fake" {
+		t.Errorf("origin header not relayed, got %v", res.Header)
+	}
+
+	requests := h.Origin.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("origin received %d requests, want 1", len(requests))
+	}
+	if got := requests[0]; got.Method != http.MethodGet || got.Path != "/bucket/key" || got.RawQuery != "versionId=1" {
+		t.Errorf("origin received %s %s?%s", got.Method, got.Path, got.RawQuery)
+	}
+	if requests[0].Header.Get("Authorization") == "" {
+		t.Error("request to the origin is not re-signed")
+	}
+}
+
+func TestHarnessForwardsBodies(t *testing.T) {
+	h := newS3Harness(t)
+	body := bytes.Repeat([]byte("iamtest"), 1000)
+
+	res, err := h.Do(h.NewSignedRequest(http.MethodPut, "/bucket/key", body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d", res.StatusCode)
+	}
+	requests := h.Origin.Requests()
+	if len(requests) != 1 || !bytes.Equal(requests[0].Body, body) {
+		t.Fatalf("origin did not receive the body")
+	}
+}
+
+func TestHarnessRejectsBadSignatures(t *testing.T) {
+	h := newS3Harness(t)
+
+	for name, r := range map[string]*http.Request{
+		"unsigned": func() *http.Request {
+			r, _ := http.NewRequest(http.MethodGet, h.Server.URL+"/bucket/key", nil)
+			return r
+		}(),
+		"wrong secret": func() *http.Request {
+			r, _ := http.NewRequest(http.MethodGet, h.Server.URL+"/bucket/key", nil)
+			http_server.SignRequest(r, KeyID, "not_the_secret", Region, "s3", time.Now())
+			return r
+		}(),
+		"unknown key": func() *http.Request {
+			r, _ := http.NewRequest(http.MethodGet, h.Server.URL+"/bucket/key", nil)
+			http_server.SignRequest(r, "AKIAUNKNOWN", KeySecret, Region, "s3", time.Now())
+			return r
+		}(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			res, err := h.Do(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != http.StatusForbidden {
+				t.Errorf("got status %d, want 403", res.StatusCode)
+			}
+		})
+	}
+	if n := len(h.Origin.Requests()); n != 0 {
+		t.Errorf("origin received %d rejected requests", n)
+	}
+}
+
+func TestFakeOriginHandle(t *testing.T) {
+	origin := NewFakeOrigin()
+	defer origin.Close()
+	origin.Handle(func(w http.ResponseWriter, r *http.Request) {
+		// The body was already recorded, and is still readable by the handler
+		body, _ := io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+		w.Write(bytes.ToUpper(body))
+	})
+
+	res, err := http.Post(origin.URL+"/x", "text/plain", bytes.NewReader([]byte("abc")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusCreated || string(body) != "ABC" {
+		t.Fatalf("got %d %q", res.StatusCode, body)
+	}
+	if requests := origin.Requests(); len(requests) != 1 || string(requests[0].Body) != "abc" {
+		t.Fatalf("recorded %+v", requests)
+	}
+}
+
+func TestMapLookupFunc(t *testing.T) {
+	lookup := MapLookupFunc(map[string]string{"a": "1"})
+	if val, err := lookup(context.Background(), "a"); err != nil || val != "1" {
+		t.Errorf("got %q, %v", val, err)
+	}
+	if _, err := lookup(context.Background(), "b"); err != http_server.ErrKeyNotFound {
+		t.Errorf("got %v, want ErrKeyNotFound", err)
+	}
+}
@@ -0,0 +1,162 @@
+package iamtest
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// InMemoryS3 is a path-style S3 origin backed by a map, supporting PutObject, GetObject, HeadObject,
+// DeleteObject, and ListObjects(V2) with a prefix. Use it as the origin of a Harness for hermetic handler tests:
+//
+//	store := iamtest.NewInMemoryS3()
+//	h.Origin.Handle(store.ServeHTTP)
+type InMemoryS3 struct {
+	mu      sync.RWMutex
+	objects map[string]inMemoryObject
+}
+
+type inMemoryObject struct {
+	body         []byte
+	contentType  string
+	etag         string
+	lastModified time.Time
+}
+
+func NewInMemoryS3() *InMemoryS3 {
+	return &InMemoryS3{objects: map[string]inMemoryObject{}}
+}
+
+// Put stores an object directly, e.g. to seed a test
+func (s *InMemoryS3) Put(bucket, key string, body []byte, contentType string) string {
+	sum := md5.Sum(body)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[bucket+"/"+key] = inMemoryObject{
+		body:         append([]byte(nil), body...),
+		contentType:  contentType,
+		etag:         etag,
+		lastModified: time.Now().UTC(),
+	}
+	return etag
+}
+
+// Get returns a stored object, and whether it exists
+func (s *InMemoryS3) Get(bucket, key string) ([]byte, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	object, ok := s.objects[bucket+"/"+key]
+	return object.body, ok
+}
+
+func (s *InMemoryS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket == "" {
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented", "ListBuckets is not supported")
+		return
+	}
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		s.list(w, bucket, r.URL.Query().Get("prefix"))
+	case key == "":
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented", "Bucket operations are not supported")
+	case r.Method == http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+			return
+		}
+		w.Header().Set("ETag", s.Put(bucket, key, body, r.Header.Get("Content-Type")))
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		s.mu.RLock()
+		object, ok := s.objects[bucket+"/"+key]
+		s.mu.RUnlock()
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+			return
+		}
+		w.Header().Set("ETag", object.etag)
+		w.Header().Set("Last-Modified", object.lastModified.Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.body)))
+		if object.contentType != "" {
+			w.Header().Set("Content-Type", object.contentType)
+		}
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(object.body)
+		}
+	case r.Method == http.MethodDelete:
+		// Deleting a missing key succeeds, like S3
+		s.mu.Lock()
+		delete(s.objects, bucket+"/"+key)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource.")
+	}
+}
+
+type listBucketResult struct {
+	XMLName  xml.Name           `xml:"ListBucketResult"`
+	Name     string             `xml:"Name"`
+	Prefix   string             `xml:"Prefix"`
+	KeyCount int                `xml:"KeyCount"`
+	Contents []listBucketObject `xml:"Contents"`
+}
+
+type listBucketObject struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int    `xml:"Size"`
+}
+
+func (s *InMemoryS3) list(w http.ResponseWriter, bucket, prefix string) {
+	result := listBucketResult{Name: bucket, Prefix: prefix}
+	s.mu.RLock()
+	for path, object := range s.objects {
+		objectBucket, key, _ := strings.Cut(path, "/")
+		if objectBucket != bucket || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		result.Contents = append(result.Contents, listBucketObject{
+			Key:          key,
+			LastModified: object.lastModified.Format(time.RFC3339),
+			ETag:         object.etag,
+			Size:         len(object.body),
+		})
+	}
+	s.mu.RUnlock()
+	sort.Slice(result.Contents, func(i, j int) bool {
+		return result.Contents[i].Key < result.Contents[j].Key
+	})
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(result)
+}
+
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+func writeS3Error(w http.ResponseWriter, statusCode int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(statusCode)
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(s3Error{Code: code, Message: message})
+}
@@ -0,0 +1,180 @@
+package iamtest
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func newInMemoryS3Harness(t *testing.T) (*Harness, *InMemoryS3) {
+	t.Helper()
+	h := newS3Harness(t)
+	store := NewInMemoryS3()
+	h.Origin.Handle(store.ServeHTTP)
+	return h, store
+}
+
+// do sends a signed request through the proxy, returning the response with its body read
+func do(t *testing.T, h *Harness, r *http.Request) (*http.Response, []byte) {
+	t.Helper()
+	res, err := h.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, body
+}
+
+func TestInMemoryS3PutGetHead(t *testing.T) {
+	h, store := newInMemoryS3Harness(t)
+	object := []byte(`{"hello":"world"}`)
+	sum := md5.Sum(object)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	put := h.NewSignedRequest(http.MethodPut, "/bucket/dir/key.json", object)
+	put.Header.Set("Content-Type", "application/json")
+	res, _ := do(t, h, put)
+	if res.StatusCode != http.StatusOK || res.Header.Get("ETag") != etag {
+		t.Fatalf("PutObject got %d with ETag %s, want 200 with %s", res.StatusCode, res.Header.Get("ETag"), etag)
+	}
+	if stored, ok := store.Get("bucket", "dir/key.json"); !ok || !bytes.Equal(stored, object) {
+		t.Fatalf("stored %q, %v", stored, ok)
+	}
+
+	res, body := do(t, h, h.NewSignedRequest(http.MethodGet, "/bucket/dir/key.json", nil))
+	if res.StatusCode != http.StatusOK || !bytes.Equal(body, object) {
+		t.Fatalf("GetObject got %d %q", res.StatusCode, body)
+	}
+	if res.Header.Get("ETag") != etag || res.Header.Get("Content-Type") != "application/json" || res.Header.Get("Last-Modified") == "" {
+		t.Errorf("GetObject headers %v", res.Header)
+	}
+
+	res, body = do(t, h, h.NewSignedRequest(http.MethodHead, "/bucket/dir/key.json", nil))
+	if res.StatusCode != http.StatusOK || len(body) != 0 {
+		t.Fatalf("HeadObject got %d with %d bytes", res.StatusCode, len(body))
+	}
+	if res.ContentLength != int64(len(object)) || res.Header.Get("ETag") != etag {
+		t.Errorf("HeadObject got length %d, ETag %s", res.ContentLength, res.Header.Get("ETag"))
+	}
+}
+
+func TestInMemoryS3Overwrite(t *testing.T) {
+	h, store := newInMemoryS3Harness(t)
+	store.Put("bucket", "key", []byte("old"), "")
+
+	do(t, h, h.NewSignedRequest(http.MethodPut, "/bucket/key", []byte("new")))
+	if _, body := do(t, h, h.NewSignedRequest(http.MethodGet, "/bucket/key", nil)); string(body) != "new" {
+		t.Errorf("got %q after overwriting", body)
+	}
+}
+
+func TestInMemoryS3NoSuchKey(t *testing.T) {
+	h, _ := newInMemoryS3Harness(t)
+
+	res, body := do(t, h, h.NewSignedRequest(http.MethodGet, "/bucket/missing", nil))
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("got status %d", res.StatusCode)
+	}
+	var s3Err s3Error
+	if err := xml.Unmarshal(body, &s3Err); err != nil || s3Err.Code != "NoSuchKey" {
+		t.Errorf("got %q, %v", body, err)
+	}
+
+	if res, _ = do(t, h, h.NewSignedRequest(http.MethodHead, "/bucket/missing", nil)); res.StatusCode != http.StatusNotFound {
+		t.Errorf("HeadObject got status %d", res.StatusCode)
+	}
+}
+
+func TestInMemoryS3Delete(t *testing.T) {
+	h, store := newInMemoryS3Harness(t)
+	store.Put("bucket", "key", []byte("object"), "")
+
+	res, _ := do(t, h, h.NewSignedRequest(http.MethodDelete, "/bucket/key", nil))
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("got status %d", res.StatusCode)
+	}
+	if _, ok := store.Get("bucket", "key"); ok {
+		t.Fatal("object still stored")
+	}
+	if res, _ = do(t, h, h.NewSignedRequest(http.MethodGet, "/bucket/key", nil)); res.StatusCode != http.StatusNotFound {
+		t.Errorf("GetObject after delete got status %d", res.StatusCode)
+	}
+
+	// Like S3, deleting a missing key succeeds
+	if res, _ = do(t, h, h.NewSignedRequest(http.MethodDelete, "/bucket/key", nil)); res.StatusCode != http.StatusNoContent {
+		t.Errorf("deleting a missing key got status %d", res.StatusCode)
+	}
+}
+
+func TestInMemoryS3ListPrefix(t *testing.T) {
+	h, store := newInMemoryS3Harness(t)
+	for _, key := range []string{"logs/b", "logs/a", "images/c"} {
+		store.Put("bucket", key, []byte(key), "")
+	}
+	store.Put("other", "logs/d", []byte("other bucket"), "")
+
+	tests := []struct {
+		name string
+		path string
+		keys []string
+	}{
+		{"everything", "/bucket?list-type=2", []string{"images/c", "logs/a", "logs/b"}},
+		{"prefix", "/bucket?list-type=2&prefix=logs%2F", []string{"logs/a", "logs/b"}},
+		{"ListObjects v1", "/bucket/?prefix=images", []string{"images/c"}},
+		{"no matches", "/bucket?prefix=nope", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, body := do(t, h, h.NewSignedRequest(http.MethodGet, tt.path, nil))
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("got status %d: %s", res.StatusCode, body)
+			}
+			var result listBucketResult
+			if err := xml.Unmarshal(body, &result); err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			for _, object := range result.Contents {
+				keys = append(keys, object.Key)
+				if object.Size != len(object.Key) || object.ETag == "" {
+					t.Errorf("listed %+v", object)
+				}
+			}
+			if result.Name != "bucket" || result.KeyCount != len(tt.keys) || len(keys) != len(tt.keys) {
+				t.Fatalf("listed %s with %d keys %v, want %v", result.Name, result.KeyCount, keys, tt.keys)
+			}
+			for i := range keys {
+				if keys[i] != tt.keys[i] {
+					t.Fatalf("listed %v, want %v", keys, tt.keys)
+				}
+			}
+		})
+	}
+}
+
+func TestInMemoryS3Unsupported(t *testing.T) {
+	h, _ := newInMemoryS3Harness(t)
+
+	for _, tt := range []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/", http.StatusNotImplemented},
+		{http.MethodPut, "/bucket", http.StatusNotImplemented},
+		{http.MethodPost, "/bucket/key", http.StatusMethodNotAllowed},
+	} {
+		res, _ := do(t, h, h.NewSignedRequest(tt.method, tt.path, nil))
+		if res.StatusCode != tt.status {
+			t.Errorf("%s %s got status %d, want %d", tt.method, tt.path, res.StatusCode, tt.status)
+		}
+	}
+}
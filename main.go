@@ -1,64 +0,0 @@
-package main
-
-import (
-	"context"
-	"errors"
-	"fmt"
-	"net/http"
-	"os"
-	"os/signal"
-	"syscall"
-	"time"
-
-	"github.com/joho/godotenv"
-
-	"github.com/danthegoodman1/IAMTheService/observability"
-
-	"github.com/danthegoodman1/IAMTheService/gologger"
-	"github.com/danthegoodman1/IAMTheService/http_server"
-	"github.com/danthegoodman1/IAMTheService/utils"
-)
-
-var logger = gologger.NewLogger()
-
-func main() {
-	if _, err := os.Stat(".env"); err == nil {
-		err = godotenv.Load()
-		if err != nil {
-			logger.Fatal().Err(err).Msg("error loading .env file, exiting")
-			return
-		}
-	}
-	logger.Debug().Msg("starting unnamed api")
-
-	prometheusReporter := observability.NewPrometheusReporter()
-	go func() {
-		err := observability.StartInternalHTTPServer(":8042", prometheusReporter)
-		if err != nil && !errors.Is(err, http.ErrServerClosed) {
-			logger.Fatal().Err(err).Msg("internal server couldn't start")
-			return
-		}
-	}()
-	httpServer := http_server.StartHTTPServer()
-
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	<-c
-	logger.Warn().Msg("received shutdown signal!")
-
-	// For AWS ALB needing some time to de-register pod
-	// Convert the time to seconds
-	sleepTime := utils.GetEnvOrDefaultInt("SHUTDOWN_SLEEP_SEC", 0)
-	logger.Info().Msg(fmt.Sprintf("sleeping for %ds before exiting", sleepTime))
-
-	time.Sleep(time.Second * time.Duration(sleepTime))
-	logger.Info().Msg(fmt.Sprintf("slept for %ds, exiting", sleepTime))
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-	defer cancel()
-	if err := httpServer.Shutdown(ctx); err != nil {
-		logger.Error().Err(err).Msg("failed to shutdown HTTP server")
-	} else {
-		logger.Info().Msg("successfully shutdown HTTP server")
-	}
-}
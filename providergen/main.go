@@ -0,0 +1,517 @@
+// providergen generates the operations and request/response shapes of an AWS service from its API model,
+// for providers to classify operations exhaustively and decode requests into typed shapes.
+//
+// It reads botocore models (botocore/data/<service>/<version>/service-2.json) or Smithy JSON AST models
+// (aws/api-models-aws), from a file or URL:
+//
+//	go run ./providergen -model service-2.json -name SQS -out http_server/sqs_model_gen.go
+//
+// The output has <Name>Operations (an OperationSet), <Name>OperationRoutes for REST protocols, and a
+// <Name><Shape> struct for the input and output shapes of every operation, unless -operations-only.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/samber/lo"
+)
+
+// model is the parts of an API model that are generated, in either format
+type model struct {
+	ServiceID  string
+	APIVersion string
+	// Protocol is the botocore protocol name: json, rest-json, rest-xml, query, or ec2
+	Protocol   string
+	Operations []operation
+	Shapes     map[string]*shape
+}
+
+type operation struct {
+	Name       string
+	Method     string
+	RequestURI string
+	Input      string
+	Output     string
+}
+
+type shape struct {
+	// Type is the botocore type: structure, list, map, string, integer, long, float, double, boolean,
+	// blob, or timestamp
+	Type    string
+	Members []member
+	// Member is the shape of list items, Key and Value those of maps
+	Member    string
+	Key       string
+	Value     string
+	Flattened bool
+	// MemberLocationName is the element name of list items in XML, defaulting to member
+	MemberLocationName string
+}
+
+type member struct {
+	Name         string
+	Shape        string
+	LocationName string
+}
+
+func main() {
+	modelPath := flag.String("model", "", "path or URL of the botocore or Smithy JSON model")
+	name := flag.String("name", "", "Go name of the service, prefixed to generated identifiers (e.g. SQS)")
+	pkg := flag.String("package", "http_server", "package of the generated file")
+	out := flag.String("out", "", "file to write, stdout if empty")
+	operationsOnly := flag.Bool("operations-only", false, "only generate the operations and routes, not the shapes")
+	flag.Parse()
+	if *modelPath == "" || *name == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(*modelPath, *name, *pkg, *out, *operationsOnly); err != nil {
+		fmt.Fprintln(os.Stderr, "providergen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(modelPath, name, pkg, out string, operationsOnly bool) error {
+	raw, err := readModel(modelPath)
+	if err != nil {
+		return fmt.Errorf("error in readModel: %w", err)
+	}
+
+	var m *model
+	if bytes.Contains(raw[:min(len(raw), 512)], []byte(`"smithy"`)) {
+		m, err = parseSmithy(raw)
+	} else {
+		m, err = parseBotocore(raw)
+	}
+	if err != nil {
+		return fmt.Errorf("error parsing model: %w", err)
+	}
+
+	src, err := generate(m, modelPath, name, pkg, operationsOnly)
+	if err != nil {
+		return fmt.Errorf("error in generate: %w", err)
+	}
+	if out == "" {
+		_, err = os.Stdout.Write(src)
+		return err
+	}
+	return os.WriteFile(out, src, 0o644)
+}
+
+func readModel(modelPath string) ([]byte, error) {
+	if !strings.HasPrefix(modelPath, "https://") && !strings.HasPrefix(modelPath, "http://") {
+		return os.ReadFile(modelPath)
+	}
+	res, err := http.Get(modelPath)
+	if err != nil {
+		return nil, fmt.Errorf("error in http.Get: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", modelPath, res.Status)
+	}
+	return io.ReadAll(res.Body)
+}
+
+// parseBotocore reads a botocore service-2.json model
+func parseBotocore(raw []byte) (*model, error) {
+	var doc struct {
+		Metadata struct {
+			APIVersion string `json:"apiVersion"`
+			Protocol   string `json:"protocol"`
+			ServiceID  string `json:"serviceId"`
+		} `json:"metadata"`
+		Operations map[string]struct {
+			HTTP struct {
+				Method     string `json:"method"`
+				RequestURI string `json:"requestUri"`
+			} `json:"http"`
+			Input  struct{ Shape string } `json:"input"`
+			Output struct{ Shape string } `json:"output"`
+		} `json:"operations"`
+		Shapes map[string]struct {
+			Type    string `json:"type"`
+			Members map[string]struct {
+				Shape        string `json:"shape"`
+				LocationName string `json:"locationName"`
+			} `json:"members"`
+			Member struct {
+				Shape        string `json:"shape"`
+				LocationName string `json:"locationName"`
+			} `json:"member"`
+			Key       struct{ Shape string } `json:"key"`
+			Value     struct{ Shape string } `json:"value"`
+			Flattened bool                   `json:"flattened"`
+		} `json:"shapes"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("error in json.Unmarshal: %w", err)
+	}
+
+	m := &model{
+		ServiceID:  doc.Metadata.ServiceID,
+		APIVersion: doc.Metadata.APIVersion,
+		Protocol:   doc.Metadata.Protocol,
+		Shapes:     map[string]*shape{},
+	}
+	for opName, op := range doc.Operations {
+		m.Operations = append(m.Operations, operation{
+			Name:       opName,
+			Method:     op.HTTP.Method,
+			RequestURI: op.HTTP.RequestURI,
+			Input:      op.Input.Shape,
+			Output:     op.Output.Shape,
+		})
+	}
+	for shapeName, s := range doc.Shapes {
+		parsed := &shape{
+			Type:               s.Type,
+			Member:             s.Member.Shape,
+			MemberLocationName: s.Member.LocationName,
+			Key:                s.Key.Shape,
+			Value:              s.Value.Shape,
+			Flattened:          s.Flattened,
+		}
+		for memberName, mem := range s.Members {
+			parsed.Members = append(parsed.Members, member{Name: memberName, Shape: mem.Shape, LocationName: mem.LocationName})
+		}
+		m.Shapes[shapeName] = parsed
+	}
+	return m, nil
+}
+
+// smithyProtocols maps Smithy protocol traits to botocore protocol names
+var smithyProtocols = map[string]string{
+	"aws.protocols#awsJson1_0": "json",
+	"aws.protocols#awsJson1_1": "json",
+	"aws.protocols#restJson1":  "rest-json",
+	"aws.protocols#restXml":    "rest-xml",
+	"aws.protocols#awsQuery":   "query",
+	"aws.protocols#ec2Query":   "ec2",
+}
+
+// smithySimpleTypes maps Smithy simple types to botocore types
+var smithySimpleTypes = map[string]string{
+	"string": "string", "enum": "string", "integer": "integer", "intEnum": "integer", "short": "integer",
+	"byte": "integer", "long": "long", "bigInteger": "long", "float": "float", "double": "double",
+	"bigDecimal": "double", "boolean": "boolean", "blob": "blob", "timestamp": "timestamp",
+	"document": "document",
+}
+
+// parseSmithy reads a Smithy JSON AST model of a single service
+func parseSmithy(raw []byte) (*model, error) {
+	var doc struct {
+		Shapes map[string]struct {
+			Type    string                     `json:"type"`
+			Version string                     `json:"version"`
+			Input   struct{ Target string }    `json:"input"`
+			Output  struct{ Target string }    `json:"output"`
+			Members map[string]smithyMember    `json:"members"`
+			Member  smithyMember               `json:"member"`
+			Key     smithyMember               `json:"key"`
+			Value   smithyMember               `json:"value"`
+			Traits  map[string]json.RawMessage `json:"traits"`
+		} `json:"shapes"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("error in json.Unmarshal: %w", err)
+	}
+
+	m := &model{Shapes: map[string]*shape{}}
+	var operationIDs []string
+	for id, s := range doc.Shapes {
+		switch s.Type {
+		case "service":
+			m.APIVersion = s.Version
+			m.ServiceID = shapeName(id)
+			for trait := range s.Traits {
+				if protocol, ok := smithyProtocols[trait]; ok {
+					m.Protocol = protocol
+				}
+			}
+		case "operation":
+			operationIDs = append(operationIDs, id)
+		case "structure", "list", "map":
+			parsed := &shape{
+				Type:   s.Type,
+				Member: shapeName(s.Member.Target),
+				Key:    shapeName(s.Key.Target),
+				Value:  shapeName(s.Value.Target),
+			}
+			_, parsed.Flattened = s.Traits["smithy.api#xmlFlattened"]
+			parsed.MemberLocationName = s.Member.xmlName()
+			for memberName, mem := range s.Members {
+				parsed.Members = append(parsed.Members, member{Name: memberName, Shape: shapeName(mem.Target), LocationName: mem.xmlName()})
+			}
+			m.Shapes[shapeName(id)] = parsed
+		default:
+			if botocoreType, ok := smithySimpleTypes[s.Type]; ok {
+				m.Shapes[shapeName(id)] = &shape{Type: botocoreType}
+			}
+		}
+	}
+	if m.Protocol == "" {
+		return nil, fmt.Errorf("no service shape with a known protocol trait")
+	}
+
+	for _, id := range operationIDs {
+		s := doc.Shapes[id]
+		op := operation{
+			Name:   shapeName(id),
+			Input:  shapeName(s.Input.Target),
+			Output: shapeName(s.Output.Target),
+		}
+		if httpTrait, ok := s.Traits["smithy.api#http"]; ok {
+			var binding struct {
+				Method string `json:"method"`
+				URI    string `json:"uri"`
+			}
+			if err := json.Unmarshal(httpTrait, &binding); err != nil {
+				return nil, fmt.Errorf("error in json.Unmarshal of http trait of %s: %w", id, err)
+			}
+			op.Method, op.RequestURI = binding.Method, binding.URI
+		}
+		m.Operations = append(m.Operations, op)
+	}
+	return m, nil
+}
+
+type smithyMember struct {
+	Target string                     `json:"target"`
+	Traits map[string]json.RawMessage `json:"traits"`
+}
+
+func (m smithyMember) xmlName() string {
+	var name string
+	_ = json.Unmarshal(m.Traits["smithy.api#xmlName"], &name)
+	return name
+}
+
+// shapeName strips the namespace of a Smithy shape id, e.g. com.amazonaws.sqs#SendMessage is SendMessage.
+// Prelude types (smithy.api#String) are mapped to botocore types.
+func shapeName(id string) string {
+	namespace, name, found := strings.Cut(id, "#")
+	if !found {
+		return id
+	}
+	if namespace == "smithy.api" {
+		return "smithy.api#" + name
+	}
+	return name
+}
+
+// generator writes the Go source of a model
+type generator struct {
+	m    *model
+	name string
+	buf  bytes.Buffer
+	// usesTime is whether a field is a time.Time, which needs the import
+	usesTime bool
+	// emitted are the shapes already generated, pending those still to be
+	emitted map[string]bool
+	pending []string
+}
+
+func generate(m *model, source, name, pkg string, operationsOnly bool) ([]byte, error) {
+	sort.Slice(m.Operations, func(i, j int) bool { return m.Operations[i].Name < m.Operations[j].Name })
+	g := &generator{m: m, name: name, emitted: map[string]bool{}}
+
+	fmt.Fprintf(&g.buf, "// %sOperations are the operations of %s (API version %s)\n", name, m.ServiceID, m.APIVersion)
+	fmt.Fprintf(&g.buf, "var %sOperations = OperationSet{\n", name)
+	for _, op := range m.Operations {
+		fmt.Fprintf(&g.buf, "\t%q: true,\n", op.Name)
+	}
+	g.buf.WriteString("}\n\n")
+
+	if strings.HasPrefix(m.Protocol, "rest-") {
+		fmt.Fprintf(&g.buf, "// %sOperationRoutes are the HTTP bindings of the operations, see MatchOperationRoute\n", name)
+		fmt.Fprintf(&g.buf, "var %sOperationRoutes = []OperationRoute{\n", name)
+		for _, op := range m.Operations {
+			fmt.Fprintf(&g.buf, "\t{Operation: %q, Method: %q, RequestURI: %q},\n", op.Name, op.Method, op.RequestURI)
+		}
+		g.buf.WriteString("}\n\n")
+	}
+
+	for _, op := range m.Operations {
+		for _, s := range []string{op.Input, op.Output} {
+			// Smithy operations without input or output target smithy.api#Unit
+			if s != "" && !strings.HasPrefix(s, "smithy.api#") && !g.emitted[s] && !operationsOnly {
+				g.emitted[s] = true
+				g.pending = append(g.pending, s)
+			}
+		}
+	}
+	for len(g.pending) > 0 {
+		s := g.pending[0]
+		g.pending = g.pending[1:]
+		if err := g.structure(s); err != nil {
+			return nil, err
+		}
+	}
+
+	var file bytes.Buffer
+	fmt.Fprintf(&file, "// Code generated by providergen from %s. DO NOT EDIT.\n\n", source)
+	fmt.Fprintf(&file, "package %s\n\n", pkg)
+	if g.usesTime {
+		file.WriteString("import \"time\"\n\n")
+	}
+	file.Write(g.buf.Bytes())
+
+	src, err := format.Source(file.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("error in format.Source: %w", err)
+	}
+	return src, nil
+}
+
+func (g *generator) jsonProtocol() bool {
+	return g.m.Protocol == "json" || g.m.Protocol == "rest-json"
+}
+
+func (g *generator) structure(shapeName string) error {
+	s, ok := g.m.Shapes[shapeName]
+	if !ok {
+		return fmt.Errorf("unknown shape %s", shapeName)
+	}
+	if s.Type != "structure" {
+		return nil
+	}
+
+	sort.Slice(s.Members, func(i, j int) bool { return s.Members[i].Name < s.Members[j].Name })
+	fmt.Fprintf(&g.buf, "type %s struct {\n", g.typeName(shapeName))
+	for _, mem := range s.Members {
+		goType, err := g.goType(mem.Shape)
+		if err != nil {
+			return fmt.Errorf("error in goType of %s.%s: %w", shapeName, mem.Name, err)
+		}
+		fmt.Fprintf(&g.buf, "\t%s %s `%s`\n", exportedName(mem.Name), goType, g.tag(mem))
+	}
+	g.buf.WriteString("}\n\n")
+	return nil
+}
+
+// tag is the json tag of a member for JSON protocols, and the xml tag otherwise
+func (g *generator) tag(mem member) string {
+	if g.jsonProtocol() {
+		return fmt.Sprintf(`json:"%s,omitempty"`, lo.Ternary(mem.LocationName != "", mem.LocationName, mem.Name))
+	}
+	name := lo.Ternary(mem.LocationName != "", mem.LocationName, mem.Name)
+	if s := g.m.Shapes[mem.Shape]; s != nil && !s.Flattened {
+		switch s.Type {
+		case "list":
+			name += ">" + lo.Ternary(s.MemberLocationName != "", s.MemberLocationName, "member")
+		case "map":
+			name += ">entry"
+		}
+	}
+	return fmt.Sprintf(`xml:"%s,omitempty"`, name)
+}
+
+func (g *generator) goType(shapeName string) (string, error) {
+	if strings.HasPrefix(shapeName, "smithy.api#") {
+		return g.preludeType(strings.TrimPrefix(shapeName, "smithy.api#"))
+	}
+	s, ok := g.m.Shapes[shapeName]
+	if !ok {
+		return "", fmt.Errorf("unknown shape %s", shapeName)
+	}
+
+	switch s.Type {
+	case "structure":
+		if !g.emitted[shapeName] {
+			g.emitted[shapeName] = true
+			g.pending = append(g.pending, shapeName)
+		}
+		// A pointer, since shapes can be recursive
+		return "*" + g.typeName(shapeName), nil
+	case "list":
+		item, err := g.goType(s.Member)
+		if err != nil {
+			return "", err
+		}
+		return "[]" + item, nil
+	case "map":
+		key, err := g.goType(s.Key)
+		if err != nil {
+			return "", err
+		}
+		value, err := g.goType(s.Value)
+		if err != nil {
+			return "", err
+		}
+		if g.jsonProtocol() {
+			return "map[" + key + "]" + value, nil
+		}
+		// encoding/xml can't decode maps, XML protocols send them as key/value entries
+		return "[]struct {\n" + "Key " + key + " `xml:\"key\"`\n" + "Value " + value + " `xml:\"value\"`\n}", nil
+	}
+	return g.simpleType(s.Type)
+}
+
+func (g *generator) preludeType(name string) (string, error) {
+	// Prelude shapes are the simple types, capitalized (smithy.api#String, smithy.api#PrimitiveLong)
+	name = strings.TrimPrefix(name, "Primitive")
+	return g.simpleType(string(unicode.ToLower(rune(name[0]))) + name[1:])
+}
+
+func (g *generator) simpleType(botocoreType string) (string, error) {
+	switch botocoreType {
+	case "string":
+		return "string", nil
+	case "integer", "long", "short", "byte":
+		return "int64", nil
+	case "float", "double":
+		return "float64", nil
+	case "boolean":
+		return "bool", nil
+	case "blob":
+		return "[]byte", nil
+	case "timestamp":
+		// JSON protocols send epoch seconds
+		if g.jsonProtocol() {
+			return "float64", nil
+		}
+		g.usesTime = true
+		return "time.Time", nil
+	case "document", "unit":
+		return "any", nil
+	}
+	return "", fmt.Errorf("unsupported type %s", botocoreType)
+}
+
+// typeName prefixes shapes with the service name, so services don't collide in a package
+func (g *generator) typeName(shapeName string) string {
+	return g.name + exportedName(shapeName)
+}
+
+// exportedName makes a shape or member name a valid exported Go identifier
+func exportedName(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 || unicode.IsDigit(rune(b.String()[0])) {
+		return "X" + b.String()
+	}
+	return b.String()
+}
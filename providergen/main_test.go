@@ -0,0 +1,186 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const botocoreModel = `{
+  "metadata": {"apiVersion": "2015-03-31", "protocol": "rest-json", "serviceId": "Lambda"},
+  "operations": {
+    "Invoke": {
+      "http": {"method": "POST", "requestUri": "/2015-03-31/functions/{FunctionName}/invocations"},
+      "input": {"shape": "InvocationRequest"},
+      "output": {"shape": "InvocationResponse"}
+    },
+    "ListFunctions": {
+      "http": {"method": "GET", "requestUri": "/2015-03-31/functions/"},
+      "output": {"shape": "ListFunctionsResponse"}
+    }
+  },
+  "shapes": {
+    "InvocationRequest": {"type": "structure", "members": {
+      "FunctionName": {"shape": "String"},
+      "Payload": {"shape": "Blob"}
+    }},
+    "InvocationResponse": {"type": "structure", "members": {"StatusCode": {"shape": "Integer"}}},
+    "ListFunctionsResponse": {"type": "structure", "members": {"Functions": {"shape": "FunctionList"}}},
+    "FunctionList": {"type": "list", "member": {"shape": "FunctionConfiguration"}},
+    "FunctionConfiguration": {"type": "structure", "members": {
+      "FunctionName": {"shape": "String"},
+      "LastModified": {"shape": "Timestamp"},
+      "Environment": {"shape": "Environment"}
+    }},
+    "Environment": {"type": "map", "key": {"shape": "String"}, "value": {"shape": "String"}},
+    "String": {"type": "string"},
+    "Blob": {"type": "blob"},
+    "Integer": {"type": "integer"},
+    "Timestamp": {"type": "timestamp"}
+  }
+}`
+
+const smithyModel = `{
+  "smithy": "2.0",
+  "shapes": {
+    "com.amazonaws.sqs#AmazonSQS": {
+      "type": "service",
+      "version": "2012-11-05",
+      "operations": [{"target": "com.amazonaws.sqs#SendMessage"}, {"target": "com.amazonaws.sqs#PurgeQueue"}],
+      "traits": {"aws.protocols#awsQuery": {}}
+    },
+    "com.amazonaws.sqs#SendMessage": {
+      "type": "operation",
+      "input": {"target": "com.amazonaws.sqs#SendMessageRequest"},
+      "output": {"target": "com.amazonaws.sqs#SendMessageResult"}
+    },
+    "com.amazonaws.sqs#PurgeQueue": {
+      "type": "operation",
+      "input": {"target": "com.amazonaws.sqs#PurgeQueueRequest"},
+      "output": {"target": "smithy.api#Unit"}
+    },
+    "com.amazonaws.sqs#SendMessageRequest": {"type": "structure", "members": {
+      "QueueUrl": {"target": "smithy.api#String"},
+      "DelaySeconds": {"target": "smithy.api#Integer"},
+      "MessageAttributes": {"target": "com.amazonaws.sqs#MessageBodyAttributeMap", "traits": {"smithy.api#xmlName": "MessageAttribute"}}
+    }},
+    "com.amazonaws.sqs#SendMessageResult": {"type": "structure", "members": {
+      "MessageId": {"target": "smithy.api#String"}
+    }},
+    "com.amazonaws.sqs#PurgeQueueRequest": {"type": "structure", "members": {
+      "QueueUrl": {"target": "smithy.api#String"}
+    }},
+    "com.amazonaws.sqs#MessageBodyAttributeMap": {
+      "type": "map",
+      "key": {"target": "smithy.api#String"},
+      "value": {"target": "smithy.api#String"},
+      "traits": {"smithy.api#xmlFlattened": {}}
+    }
+  }
+}`
+
+func generateModel(t *testing.T, model, name string, operationsOnly bool) string {
+	t.Helper()
+	dir := t.TempDir()
+	modelPath, out := filepath.Join(dir, "model.json"), filepath.Join(dir, "model_gen.go")
+	if err := os.WriteFile(modelPath, []byte(model), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(modelPath, name, "http_server", out, operationsOnly); err != nil {
+		t.Fatal(err)
+	}
+	src, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(src)
+}
+
+// assertContains checks the source has the snippets, ignoring gofmt's alignment
+func assertContains(t *testing.T, src string, want ...string) {
+	t.Helper()
+	normalized := strings.Join(strings.Fields(src), " ")
+	for _, w := range want {
+		if !strings.Contains(normalized, strings.Join(strings.Fields(w), " ")) {
+			t.Errorf("generated source is missing %q:\n%s", w, src)
+		}
+	}
+}
+
+func TestGenerateBotocore(t *testing.T) {
+	src := generateModel(t, botocoreModel, "Lambda", false)
+	assertContains(t, src,
+		"// Code generated by providergen from ",
+		"package http_server",
+		"// LambdaOperations are the operations of Lambda (API version 2015-03-31)",
+		`"Invoke":        true,`,
+		`"ListFunctions": true,`,
+		`{Operation: "Invoke", Method: "POST", RequestURI: "/2015-03-31/functions/{FunctionName}/invocations"},`,
+		"type LambdaInvocationRequest struct {",
+		"FunctionName string `json:\"FunctionName,omitempty\"`",
+		"Payload      []byte `json:\"Payload,omitempty\"`",
+		"Functions []*LambdaFunctionConfiguration `json:\"Functions,omitempty\"`",
+		"Environment  map[string]string `json:\"Environment,omitempty\"`",
+		// JSON protocols send timestamps as epoch seconds
+		"LastModified float64 `json:\"LastModified,omitempty\"`",
+	)
+	if strings.Contains(src, "time") {
+		t.Errorf("JSON protocol timestamps should be epoch seconds:\n%s", src)
+	}
+}
+
+func TestGenerateSmithy(t *testing.T) {
+	src := generateModel(t, smithyModel, "SQS", false)
+	assertContains(t, src,
+		"// SQSOperations are the operations of AmazonSQS (API version 2012-11-05)",
+		`"PurgeQueue":  true,`,
+		`"SendMessage": true,`,
+		"type SQSSendMessageRequest struct {",
+		"DelaySeconds int64 `xml:\"DelaySeconds,omitempty\"`",
+		// Flattened maps are entries under the member's xmlName, without a wrapper element
+		"`xml:\"MessageAttribute,omitempty\"`",
+		"type SQSSendMessageResult struct {",
+		"type SQSPurgeQueueRequest struct {",
+	)
+	// The query protocol isn't REST, so it has no routes, and operations without output have no shape
+	for _, unwanted := range []string{"SQSOperationRoutes", "Unit"} {
+		if strings.Contains(src, unwanted) {
+			t.Errorf("generated source has %q:\n%s", unwanted, src)
+		}
+	}
+}
+
+func TestGenerateOperationsOnly(t *testing.T) {
+	src := generateModel(t, botocoreModel, "Lambda", true)
+	assertContains(t, src, "var LambdaOperations = OperationSet{", "var LambdaOperationRoutes = []OperationRoute{")
+	if strings.Contains(src, "struct") || strings.Contains(src, "import") {
+		t.Errorf("generated shapes with -operations-only:\n%s", src)
+	}
+}
+
+func TestGenerateUnknownShape(t *testing.T) {
+	model := strings.Replace(botocoreModel, `"Payload": {"shape": "Blob"}`, `"Payload": {"shape": "Missing"}`, 1)
+	dir := t.TempDir()
+	modelPath := filepath.Join(dir, "model.json")
+	if err := os.WriteFile(modelPath, []byte(model), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err := run(modelPath, "Lambda", "http_server", filepath.Join(dir, "model_gen.go"), false)
+	if err == nil || !strings.Contains(err.Error(), "unknown shape Missing") {
+		t.Errorf("got error %v, want the unknown shape reported", err)
+	}
+}
+
+func TestExportedName(t *testing.T) {
+	for name, want := range map[string]string{
+		"queueUrl":          "QueueUrl",
+		"x-amz-meta":        "XAmzMeta",
+		"2ndAttempt":        "X2ndAttempt",
+		"Message.Attribute": "MessageAttribute",
+	} {
+		if got := exportedName(name); got != want {
+			t.Errorf("exportedName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -14,6 +14,7 @@ import (
 	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
 	oteltrace "go.opentelemetry.io/otel/trace"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -22,10 +23,17 @@ var (
 )
 
 // InitTracer creates a new OLTP trace provider instance and registers it as global trace provider.
+// The exporter is configured by TRACING_EXPORTER, and tp is nil if it is none.
+// The W3C trace context propagator is registered regardless, so incoming traces continue to the origins.
 func InitTracer(ctx context.Context) (tp *trace.TracerProvider, err error) {
 	logger := zerolog.Ctx(ctx)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
 	var exporter trace.SpanExporter
-	if utils.Env_OLTPEndpoint != "" {
+	switch utils.Env_TracingExporter {
+	case "none":
+		return nil, nil
+	case "otlp":
 		exporter, err = otlptracegrpc.New(ctx,
 			otlptracegrpc.WithEndpoint(utils.Env_OLTPEndpoint),
 			otlptracegrpc.WithInsecure(),
@@ -33,21 +41,26 @@ func InitTracer(ctx context.Context) (tp *trace.TracerProvider, err error) {
 		if err != nil {
 			return nil, err
 		}
-	} else {
+	case "stdout":
 		logger.Warn().Msg("No OLTP endpoint provided, tracing to stdout")
 		// exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
 		exporter, err = stdouttrace.New()
 		if err != nil {
 			return nil, err
 		}
+	default:
+		return nil, fmt.Errorf("unknown TRACING_EXPORTER %q", utils.Env_TracingExporter)
+	}
+	sampleRatio, err := strconv.ParseFloat(utils.Env_TracingSampleRatio, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRACING_SAMPLE_RATIO: %w", err)
 	}
 	hostname, err := os.Hostname()
 	if err != nil {
 		return nil, fmt.Errorf("error in os.Hostname: %w", err)
 	}
 	tp = trace.NewTracerProvider(
-		// trace.WithSampler(trace.ParentBased(trace.TraceIDRatioBased(0.01))),
-		trace.WithSampler(trace.AlwaysSample()),
+		trace.WithSampler(trace.ParentBased(trace.TraceIDRatioBased(sampleRatio))),
 		trace.WithBatcher(exporter),
 		trace.WithResource(resource.NewSchemaless(
 			semconv.ServiceName(utils.Env_TracingServiceName),
@@ -55,7 +68,6 @@ func InitTracer(ctx context.Context) (tp *trace.TracerProvider, err error) {
 		)),
 	)
 	otel.SetTracerProvider(tp)
-	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
 	return tp, nil
 }
 
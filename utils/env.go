@@ -4,11 +4,38 @@ import "os"
 
 var (
 	Env                    = os.Getenv("ENV")
-	Env_TracingServiceName = os.Getenv("TRACING_SERVICE_NAME")
+	Env_TracingServiceName = GetEnvOrDefault("TRACING_SERVICE_NAME", "iamtheservice")
 	Env_OLTPEndpoint       = os.Getenv("OLTP_ENDPOINT")
+	// otlp, stdout, or none. Defaults to otlp if OLTP_ENDPOINT is set, otherwise stdout.
+	// Incoming trace context is propagated to origins either way.
+	Env_TracingExporter = GetEnvOrDefault("TRACING_EXPORTER", IfElse(Env_OLTPEndpoint != "", "otlp", "stdout"))
+	// Fraction of new traces to sample, requests continuing a sampled trace are always sampled
+	Env_TracingSampleRatio = GetEnvOrDefault("TRACING_SAMPLE_RATIO", "1")
 
 	PG_DSN = os.Getenv("PG_DSN")
 
 	TLSKey  = GetEnvOrDefault("TLS_KEY", "key.pem")
 	TLSCert = GetEnvOrDefault("TLS_CERT", "cert.pem")
+
+	// Bearer token for the admin endpoints under /.internal, they are disabled if empty
+	AdminToken = os.Getenv("ADMIN_TOKEN")
+
+	// Comma separated, CORS is disabled if empty
+	CORSAllowOrigins = os.Getenv("CORS_ALLOW_ORIGINS")
+
+	// Key secrets are read from <KEY_SECRET_PREFIX><KEY_ID> env vars, and <KEY_SECRET_PREFIX><KEY_ID>_PREVIOUS during rotation
+	KeySecretPrefix = GetEnvOrDefault("KEY_SECRET_PREFIX", "IAM_KEY_")
+
+	// Origin overrides of the proxied services, which may include a scheme (e.g. http://minio:9000)
+	S3OriginHost       = os.Getenv("S3_ORIGIN_HOST")
+	DynamoDBOriginHost = os.Getenv("DYNAMODB_ORIGIN_HOST")
+	STSOriginHost      = os.Getenv("STS_ORIGIN_HOST")
+
+	// Credentials to re-sign outbound requests with, instead of the client's key, if set
+	OutboundAccessKeyID     = os.Getenv("OUTBOUND_AWS_ACCESS_KEY_ID")
+	OutboundSecretAccessKey = os.Getenv("OUTBOUND_AWS_SECRET_ACCESS_KEY")
+	OutboundSessionToken    = os.Getenv("OUTBOUND_AWS_SESSION_TOKEN")
+
+	// Verify request bodies against the signed x-amz-content-sha256 if set to "true"
+	VerifyPayloadHash = os.Getenv("VERIFY_PAYLOAD_HASH") == "true"
 )